@@ -0,0 +1,10 @@
+// Package loom holds repository-level assets compiled into the loom binary.
+package loom
+
+import _ "embed"
+
+// ExampleConfig is loom.example.toml: every option with its default or a commented example.
+// Printed by `loom print-defaults`.
+//
+//go:embed loom.example.toml
+var ExampleConfig []byte
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	loom "github.com/StefanGrimminck/Loom"
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/backend"
+	"github.com/StefanGrimminck/Loom/internal/config"
+	"github.com/StefanGrimminck/Loom/internal/detect"
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/StefanGrimminck/Loom/internal/export"
+	"github.com/StefanGrimminck/Loom/internal/replay"
+	"github.com/StefanGrimminck/Loom/internal/server"
+	pipeline "github.com/StefanGrimminck/Loom/pkg/loom"
+	"github.com/rs/zerolog"
+)
+
+// runSubcommand runs a CLI subcommand (check-config, print-defaults, replay, token, export,
+// init-backend) if args names one.
+// Returns false when args is not a subcommand and the server should start.
+func runSubcommand(args []string) (exitCode int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "check-config":
+		return checkConfig(args[1:], os.Stdout), true
+	case "print-defaults":
+		_, _ = os.Stdout.Write(loom.ExampleConfig)
+		return 0, true
+	case "replay":
+		return replayCmd(args[1:], os.Stderr), true
+	case "token":
+		return tokenCmd(args[1:], os.Stdin, os.Stdout), true
+	case "export":
+		return exportCmd(args[1:], os.Stderr), true
+	case "init-backend":
+		return initBackendCmd(args[1:], os.Stdout), true
+	}
+	return 0, false
+}
+
+// checkConfig validates a config file the way startup would: parse and validate, load TLS
+// certificates, open MaxMind DBs and compile signature and detection rules. With -probe it also connects to
+// the output and runs its health check.
+func checkConfig(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	configPath := fs.String("config", "loom.toml", "Path to config file (TOML)")
+	configOverride := fs.String("config-override", "", "Optional overlay config file merged over -config")
+	probe := fs.Bool("probe", false, "Also connect to the output and check it is reachable")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	fail := func(stage string, err error) int {
+		fmt.Fprintf(w, "FAIL %s: %v\n", stage, err)
+		return 1
+	}
+
+	cfg, err := config.Load(*configPath, overlays(*configOverride)...)
+	if err != nil {
+		return fail("config", err)
+	}
+	fmt.Fprintf(w, "ok   config %s (%d sensors)\n", *configPath, len(cfg.Auth.Tokens))
+
+	if cfg.Server.TLSEnabled() {
+		pairs := []server.CertPair{{CertFile: cfg.Server.CertFile, KeyFile: cfg.Server.KeyFile}}
+		for _, c := range cfg.Server.Certificates {
+			pairs = append(pairs, server.CertPair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+		}
+		if _, err := server.NewCertStore(pairs, zerolog.Nop()); err != nil {
+			return fail("tls", err)
+		}
+		fmt.Fprintf(w, "ok   tls (%d certificates)\n", len(pairs))
+	}
+
+	enricher, err := enrich.NewEnricher(enrich.Config{
+		GeoIPDBPath: cfg.Enrichment.GeoIPDBPath,
+		ASNDBPath:   cfg.Enrichment.ASNDBPath,
+	}, zerolog.Nop())
+	if err != nil {
+		return fail("enrichment databases", err)
+	}
+	_ = enricher.Close()
+	fmt.Fprintln(w, "ok   enrichment databases")
+
+	if cfg.Enrichment.Signatures.Enabled {
+		sigs, err := enrich.NewSignatureMatcher(cfg.Enrichment.Signatures.RulesPath, cfg.Enrichment.Signatures.Fields)
+		if err != nil {
+			return fail("signatures", err)
+		}
+		fmt.Fprintf(w, "ok   signatures (%d rules)\n", sigs.Len())
+	}
+	if cfg.Detection.Enabled {
+		rules, err := detect.LoadRules(cfg.Detection.RulesPath)
+		if err != nil {
+			return fail("detection", err)
+		}
+		fmt.Fprintf(w, "ok   detection (%d rules)\n", len(rules))
+	}
+
+	if *probe {
+		out, err := pipeline.NewOutputWriter(cfg, zerolog.Nop())
+		if err != nil {
+			return fail("output", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := out.Health(ctx); err != nil {
+			return fail("output", err)
+		}
+		fmt.Fprintf(w, "ok   output %s reachable\n", cfg.Output.Type)
+	}
+	fmt.Fprintln(w, "config OK")
+	return 0
+}
+
+// replayCmd re-submits NDJSON spool files through the configured output (without its outbox, so
+// failures do not spool back into the directory being replayed) or to a Loom ingest endpoint.
+func replayCmd(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dir := fs.String("dir", "", "Directory of NDJSON files to replay (e.g. the outbox dir); files are taken as arguments otherwise")
+	configPath := fs.String("config", "loom.toml", "Config file whose [output] receives the events (unless -url is set)")
+	configOverride := fs.String("config-override", "", "Optional overlay config file merged over -config")
+	ingestURL := fs.String("url", "", "Send to a running Loom ingest endpoint instead, e.g. https://loom:8443/api/v1/ingest")
+	token := fs.String("token", os.Getenv("LOOM_REPLAY_TOKEN"), "Sensor token for -url (default $LOOM_REPLAY_TOKEN)")
+	sensorID := fs.String("sensor-id", "", "Optional X-Spip-ID header for -url")
+	rate := fs.Float64("rate", 0, "Maximum events per second (0 = unlimited)")
+	batch := fs.Int("batch", 500, "Events per batch")
+	del := fs.Bool("delete", false, "Delete each file after all of its events were sent")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*dir == "") == (fs.NArg() == 0) {
+		fmt.Fprintln(w, "replay: give either -dir or a list of files")
+		return 2
+	}
+
+	var sink replay.Sink
+	if *ingestURL != "" {
+		if *token == "" {
+			fmt.Fprintln(w, "replay: -url needs -token or LOOM_REPLAY_TOKEN")
+			return 2
+		}
+		sink = replay.IngestSink{URL: *ingestURL, Token: *token, SensorID: *sensorID}
+	} else {
+		cfg, err := config.Load(*configPath, overlays(*configOverride)...)
+		if err != nil {
+			fmt.Fprintf(w, "replay: config: %v\n", err)
+			return 1
+		}
+		out, err := pipeline.NewOutputWriter(cfg, zerolog.Nop())
+		if err != nil {
+			fmt.Fprintf(w, "replay: output: %v\n", err)
+			return 1
+		}
+		defer out.Close()
+		sink = replay.WriterSink{W: out}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := func(p replay.Progress) {
+		fmt.Fprintf(w, "replay: %d/%d files, %d events, %.0f events/s, %s\n",
+			p.FilesDone, p.Files, p.Events, p.Rate(), p.Elapsed.Round(time.Second))
+	}
+	_, err := replay.Run(ctx, sink, replay.Options{
+		Dir:       *dir,
+		Files:     fs.Args(),
+		BatchSize: *batch,
+		Rate:      *rate,
+		Delete:    *del,
+		Progress:  report,
+	})
+	if err != nil {
+		fmt.Fprintf(w, "replay: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+const tokenUsage = `usage: loom token <command> [flags]
+
+  generate [-sensor-id ID]                         print a new random token
+  hash                                             read a token from stdin, print its sha256: form
+  add -file PATH -sensor-id ID [-hash] [-replace]  generate a token and add it to the token file
+  revoke -file PATH -sensor-id ID                  remove the sensor's token from the token file
+  list -file PATH                                  list the sensors in the token file
+`
+
+// tokenCmd manages sensor tokens and the auth.token_file. Tokens are never taken from the command
+// line; hash reads the token from stdin.
+func tokenCmd(args []string, stdin io.Reader, w io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(w, tokenUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	fs.SetOutput(w)
+	file := fs.String("file", "", "Token file (auth.token_file)")
+	sensorID := fs.String("sensor-id", "", "Sensor ID")
+	hashed := fs.Bool("hash", false, "Store the token's SHA-256 hash instead of the token")
+	replace := fs.Bool("replace", false, "Replace the sensor's existing token (rotation)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(w, "token %s: %v\n", args[0], err)
+		return 1
+	}
+	needs := func(names ...string) bool {
+		for _, n := range names {
+			if fs.Lookup(n).Value.String() == "" {
+				fmt.Fprintf(w, "token %s: -%s is required\n", args[0], n)
+				return false
+			}
+		}
+		return true
+	}
+
+	switch args[0] {
+	case "generate":
+		token, err := auth.GenerateToken()
+		if err != nil {
+			return fail(err)
+		}
+		if *sensorID == "" {
+			fmt.Fprintln(w, token)
+			return 0
+		}
+		printSensorSnippet(w, *sensorID, token, token, "")
+	case "hash":
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fail(err)
+		}
+		token := strings.TrimSpace(line)
+		if token == "" {
+			return fail(fmt.Errorf("no token on stdin"))
+		}
+		fmt.Fprintln(w, auth.HashToken(token))
+	case "add":
+		if !needs("file", "sensor-id") {
+			return 2
+		}
+		token, err := auth.GenerateToken()
+		if err != nil {
+			return fail(err)
+		}
+		stored := token
+		if *hashed {
+			stored = auth.HashToken(token)
+		}
+		if err := auth.AddToTokenFile(*file, *sensorID, stored, *replace); err != nil {
+			return fail(err)
+		}
+		printSensorSnippet(w, *sensorID, token, stored, *file)
+	case "revoke":
+		if !needs("file", "sensor-id") {
+			return 2
+		}
+		n, err := auth.RevokeFromTokenFile(*file, *sensorID)
+		if err != nil {
+			return fail(err)
+		}
+		if n == 0 {
+			return fail(fmt.Errorf("no token for sensor %q in %s", *sensorID, *file))
+		}
+		fmt.Fprintf(w, "revoked %s in %s; reload Loom (SIGHUP) to apply\n", *sensorID, *file)
+	case "list":
+		if !needs("file") {
+			return 2
+		}
+		hashedBy, ids, err := auth.TokenFileSensors(*file)
+		if err != nil {
+			return fail(err)
+		}
+		for _, id := range ids {
+			form := "plain"
+			if hashedBy[id] {
+				form = "hashed"
+			}
+			fmt.Fprintf(w, "%s\t%s\n", id, form)
+		}
+	default:
+		fmt.Fprint(w, tokenUsage)
+		return 2
+	}
+	return 0
+}
+
+// printSensorSnippet shows a new token once, with the headers the sensor sends and how Loom is
+// configured with it (stored is the token or its hash).
+func printSensorSnippet(w io.Writer, sensorID, token, stored, file string) {
+	fmt.Fprintf(w, "Token for sensor %s (shown once; store it on the sensor):\n\n  %s\n\n", sensorID, token)
+	fmt.Fprintf(w, "Sensor request headers:\n  Authorization: Bearer %s\n  X-Spip-ID: %s\n\n", token, sensorID)
+	if file != "" {
+		fmt.Fprintf(w, "Added to %s; reload Loom (SIGHUP) to apply:\n  %s,%s\n", file, stored, sensorID)
+		return
+	}
+	fmt.Fprintf(w, "Loom token_file line:\n  %s,%s\n", stored, sensorID)
+	if !strings.Contains(sensorID, "_") {
+		fmt.Fprintf(w, "or environment:\n  LOOM_SENSOR_%s=%s\n", strings.ReplaceAll(sensorID, "-", "_"), stored)
+	}
+}
+
+// exportCmd copies stored events from the backend of one config (or a directory of NDJSON files) to
+// the output of another, e.g. to backfill Elasticsearch from ClickHouse.
+func exportCmd(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fromConfig := fs.String("from-config", "", "Config whose [output] (clickhouse or elasticsearch) is read")
+	dir := fs.String("dir", "", "Read NDJSON files from this directory instead of -from-config")
+	toConfig := fs.String("to-config", "", "Config whose [output] receives the events (outbox not used)")
+	since := fs.String("since", "", "Only events with @timestamp at or after this (RFC 3339, or a duration like 72h)")
+	until := fs.String("until", "", "Only events with @timestamp before this (RFC 3339, or a duration)")
+	batch := fs.Int("batch", 500, "Events per batch")
+	rate := fs.Float64("rate", 0, "Maximum events per second (0 = unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *toConfig == "" || (*fromConfig == "") == (*dir == "") {
+		fmt.Fprintln(w, "export: give -to-config and one of -from-config or -dir")
+		return 2
+	}
+	fail := func(stage string, err error) int {
+		fmt.Fprintf(w, "export: %s: %v\n", stage, err)
+		return 1
+	}
+	var rng export.Range
+	now := time.Now()
+	for _, b := range []struct {
+		flag string
+		dst  *time.Time
+	}{{*since, &rng.Since}, {*until, &rng.Until}} {
+		if b.flag == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(b.flag); err == nil {
+			*b.dst = now.Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, b.flag); err == nil {
+			*b.dst = t
+		} else {
+			return fail("range", fmt.Errorf("%q is neither RFC 3339 nor a duration", b.flag))
+		}
+	}
+
+	dstCfg, err := config.LoadOutput(*toConfig)
+	if err != nil {
+		return fail("to-config", err)
+	}
+	out, err := pipeline.NewOutputWriter(&config.Config{Output: *dstCfg}, zerolog.Nop())
+	if err != nil {
+		return fail("output", err)
+	}
+	defer out.Close()
+	sink := replay.WriterSink{W: out}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := func(p replay.Progress) {
+		fmt.Fprintf(w, "export: %d events, %.0f events/s, %s\n", p.Events, p.Rate(), p.Elapsed.Round(time.Second))
+	}
+	opts := replay.Options{Dir: *dir, BatchSize: *batch, Rate: *rate, Progress: report}
+	if *dir != "" {
+		if !rng.Since.IsZero() || !rng.Until.IsZero() {
+			return fail("range", fmt.Errorf("-since/-until are not supported with -dir"))
+		}
+		if _, err := replay.Run(ctx, sink, opts); err != nil {
+			return fail("copy", err)
+		}
+		return 0
+	}
+
+	srcCfg, err := config.LoadOutput(*fromConfig)
+	if err != nil {
+		return fail("from-config", err)
+	}
+	var src export.Source
+	switch srcCfg.Type {
+	case "clickhouse":
+		src, err = export.OpenClickHouse(ctx, export.ClickHouseConfig{
+			URL:       srcCfg.ClickHouseURL,
+			Database:  srcCfg.ClickHouseDatabase,
+			Table:     srcCfg.ClickHouseTable,
+			User:      srcCfg.ClickHouseUser,
+			Password:  srcCfg.ClickHousePassword,
+			Range:     rng,
+			BatchSize: *batch,
+			Proxy:     srcCfg.Proxy,
+		})
+	case "elasticsearch":
+		src, err = export.OpenElasticsearch(export.ElasticsearchConfig{
+			URL:       srcCfg.ElasticsearchURL,
+			Index:     srcCfg.ElasticsearchIndex,
+			User:      srcCfg.ElasticsearchUser,
+			Pass:      srcCfg.ElasticsearchPass,
+			Range:     rng,
+			BatchSize: *batch,
+			Proxy:     srcCfg.Proxy,
+		})
+	default:
+		err = fmt.Errorf("output type %q cannot be read back (clickhouse or elasticsearch)", srcCfg.Type)
+	}
+	if err != nil {
+		return fail("source", err)
+	}
+	defer src.Close()
+	if _, err := replay.Stream(ctx, sink, src.Next, opts); err != nil {
+		return fail("copy", err)
+	}
+	return 0
+}
+
+// initBackendCmd prepares the configured output's destination for first use: the ClickHouse
+// database, table and summary views, or the Elasticsearch ILM policy, index template and write alias.
+func initBackendCmd(args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("init-backend", flag.ContinueOnError)
+	fs.SetOutput(w)
+	configPath := fs.String("config", "loom.toml", "Config whose [output] (clickhouse or elasticsearch) is set up")
+	dryRun := fs.Bool("dry-run", false, "Print the statements or requests instead of running them")
+	views := fs.Bool("views", true, "ClickHouse: also create the hourly summary and top-N tables and their materialized views")
+	rolloverAge := fs.String("rollover-age", "30d", "Elasticsearch: start a new backing index after this long")
+	deleteAfter := fs.Int("delete-after-days", 0, "Elasticsearch: delete backing indices this many days after rollover (0 = keep)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(w, "init-backend: %v\n", err)
+		return 1
+	}
+	o, err := config.LoadOutput(*configPath)
+	if err != nil {
+		return fail(err)
+	}
+	step := func(desc string) { fmt.Fprintln(w, desc) }
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch o.Type {
+	case "clickhouse":
+		cfg := backend.ClickHouseConfig{
+			URL:      o.ClickHouseURL,
+			User:     o.ClickHouseUser,
+			Password: o.ClickHousePassword,
+			Database: o.ClickHouseDatabase,
+			Table:    o.ClickHouseTable,
+			Views:    *views,
+			Proxy:    o.Proxy,
+		}
+		if *dryRun {
+			stmts, err := backend.ClickHouseStatements(cfg)
+			if err != nil {
+				return fail(err)
+			}
+			for _, stmt := range stmts {
+				fmt.Fprintf(w, "%s;\n\n", stmt)
+			}
+			return 0
+		}
+		err = backend.InstallClickHouse(ctx, cfg, step)
+	case "elasticsearch":
+		cfg := backend.ElasticsearchConfig{
+			URL:             o.ElasticsearchURL,
+			User:            o.ElasticsearchUser,
+			Pass:            o.ElasticsearchPass,
+			Index:           o.ElasticsearchIndex,
+			RolloverAge:     *rolloverAge,
+			DeleteAfterDays: *deleteAfter,
+			Proxy:           o.Proxy,
+		}
+		if *dryRun {
+			policy, template, bootstrap := backend.ElasticsearchRequests(cfg)
+			for _, r := range []backend.Request{policy, template, bootstrap} {
+				b, _ := json.MarshalIndent(r.Body, "", "  ")
+				fmt.Fprintf(w, "%s %s\n%s\n\n", r.Method, r.Path, b)
+			}
+			return 0
+		}
+		err = backend.InstallElasticsearch(ctx, cfg, step)
+	default:
+		err = fmt.Errorf("output type %q has no backend to set up (clickhouse or elasticsearch)", o.Type)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Fprintln(w, "backend ready")
+	return 0
+}
@@ -3,131 +3,188 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/StefanGrimminck/Loom/internal/admin"
+	"github.com/StefanGrimminck/Loom/internal/alert"
+	"github.com/StefanGrimminck/Loom/internal/artifact"
 	"github.com/StefanGrimminck/Loom/internal/auth"
 	"github.com/StefanGrimminck/Loom/internal/config"
-	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/StefanGrimminck/Loom/internal/dashboard"
+	"github.com/StefanGrimminck/Loom/internal/detect"
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
 	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/intel"
+	"github.com/StefanGrimminck/Loom/internal/kafka"
+	"github.com/StefanGrimminck/Loom/internal/metrics"
 	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/StefanGrimminck/Loom/internal/profile"
+	"github.com/StefanGrimminck/Loom/internal/query"
 	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/StefanGrimminck/Loom/internal/report"
+	"github.com/StefanGrimminck/Loom/internal/retention"
+	"github.com/StefanGrimminck/Loom/internal/rollup"
+	"github.com/StefanGrimminck/Loom/internal/sequence"
 	"github.com/StefanGrimminck/Loom/internal/server"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/StefanGrimminck/Loom/internal/session"
+	"github.com/StefanGrimminck/Loom/internal/shared"
+	"github.com/StefanGrimminck/Loom/internal/systemd"
+	"github.com/StefanGrimminck/Loom/internal/tenant"
+	"github.com/StefanGrimminck/Loom/internal/version"
+	"github.com/StefanGrimminck/Loom/pkg/loom"
 	"github.com/rs/zerolog"
 )
 
 func main() {
+	if code, ok := runSubcommand(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	configPath := flag.String("config", "loom.toml", "Path to config file (TOML)")
+	pidFile := flag.String("pid-file", "", "Optional path to write the process ID to (removed on exit)")
+	configOverride := flag.String("config-override", "", "Optional overlay config file merged over -config (e.g. per-site settings)")
+	showVersion := flag.Bool("version", false, "Print version and exit")
 	flag.Parse()
 
-	cfg, err := config.Load(*configPath)
+	if *showVersion {
+		info := version.Get()
+		os.Stdout.WriteString("loom " + info.Version + " commit=" + info.Commit + " built=" + info.BuildDate + " " + info.GoVersion + "\n")
+		return
+	}
+
+	cfg, err := config.Load(*configPath, overlays(*configOverride)...)
 	if err != nil {
 		// Don't log token or config content
 		os.Stderr.WriteString("config: " + err.Error() + "\n")
 		os.Exit(1)
 	}
 
+	if *pidFile != "" {
+		if err := os.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+			os.Stderr.WriteString("pid-file: " + err.Error() + "\n")
+			os.Exit(1)
+		}
+		defer os.Remove(*pidFile)
+	}
+
 	// Structured logging; do not log full request bodies or tokens
-	logLevel := zerolog.InfoLevel
-	switch cfg.Logging.Level {
-	case "debug":
-		logLevel = zerolog.DebugLevel
-	case "warn":
-		logLevel = zerolog.WarnLevel
-	case "error":
-		logLevel = zerolog.ErrorLevel
-	}
-	zerolog.SetGlobalLevel(logLevel)
 	var log zerolog.Logger
 	if cfg.Logging.Format == "console" {
 		log = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
 	} else {
 		log = zerolog.New(os.Stderr).With().Timestamp().Logger()
 	}
+	// Level can be raised temporarily via PUT /admin/loglevel; reloads change the configured level
+	logLevel := admin.NewLogLevel(parseLevel(cfg.Logging.Level), log)
+	build := version.Get()
+	log.Info().Str("version", build.Version).Str("commit", build.Commit).Str("build_date", build.BuildDate).Msg("starting loom")
+
+	// Metrics: one registry for all components, scraped on /metrics and/or pushed over OTLP; nil when disabled
+	var metricsReg *metrics.Registry
+	if cfg.Observability.MetricsEnabled || cfg.Observability.OTLP.Enabled {
+		metricsReg = metrics.New()
+		metricsReg.LimitSensorLabels(cfg.Observability.MetricsMaxSensors)
+	}
+	var metricsHandler http.Handler
+	if cfg.Observability.MetricsEnabled {
+		metricsHandler = metricsReg.Handler()
+	}
 
 	validator := auth.NewValidator(cfg.Auth.Tokens)
 	rateLimiter := ratelimit.NewPerSensorLimiter(cfg.Limits.PerSensorRPS)
+	rateLimiter.SetAlgorithm(cfg.Limits.RateLimitAlgorithm, cfg.Limits.RateLimitBurst)
 
-	// Enrichment: optional GeoIP and ASN DBs
-	var dnsEnricher *enrich.DNSEnricher
-	if cfg.Enrichment.DNS.Enabled {
-		ttl := cfg.Enrichment.DNS.CacheTTL
-		if ttl <= 0 {
-			ttl = 300
+	// Shared state: replicas behind a load balancer agree on first-seen indicators, rate limits and tenant quotas
+	var sharedBits loom.SharedBits
+	var sharedCounter tenant.SharedCounter
+	if cfg.Shared.Backend == "redis" {
+		redis, err := shared.NewRedis(shared.Options{
+			URL:       cfg.Shared.RedisURL,
+			KeyPrefix: cfg.Shared.KeyPrefix,
+			Timeout:   time.Duration(cfg.Shared.TimeoutMS) * time.Millisecond,
+			PoolSize:  cfg.Shared.PoolSize,
+			OnStateChange: func(err error) {
+				if err != nil {
+					log.Warn().Err(err).Msg("shared state unavailable; using local state")
+				} else {
+					log.Info().Msg("shared state available")
+				}
+			},
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("shared")
 		}
-		dnsEnricher = enrich.NewDNSEnricher(
-			time.Duration(ttl)*time.Second,
-			cfg.Enrichment.DNS.MaxQPS,
-		)
-	}
-	enricher, err := enrich.NewEnricher(
-		cfg.Enrichment.GeoIPDBPath,
-		cfg.Enrichment.ASNDBPath,
-		dnsEnricher,
-		log,
-	)
-	if err != nil {
-		log.Fatal().Err(err).Msg("enricher")
-	}
-	defer func() {
-		if err := enricher.Close(); err != nil {
-			log.Warn().Err(err).Msg("enricher close")
+		defer redis.Close()
+		if err := redis.Ping(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("shared state unavailable at startup; using local state until it is reachable")
 		}
-	}()
+		sharedBits = redis
+		sharedCounter = redis
+		rateLimiter.UseShared(redis)
+	}
 
-	out, err := output.NewWriter(output.WriterConfig{
-		Type:               cfg.Output.Type,
-		ElasticsearchURL:   cfg.Output.ElasticsearchURL,
-		ElasticsearchIndex: cfg.Output.ElasticsearchIndex,
-		ElasticsearchUser:  cfg.Output.ElasticsearchUser,
-		ElasticsearchPass:  cfg.Output.ElasticsearchPass,
-		ClickHouseURL:      cfg.Output.ClickHouseURL,
-		ClickHouseDatabase: cfg.Output.ClickHouseDatabase,
-		ClickHouseTable:    cfg.Output.ClickHouseTable,
-		ClickHouseUser:     cfg.Output.ClickHouseUser,
-		ClickHousePassword: cfg.Output.ClickHousePassword,
-		ClickHouseOutbox: output.OutboxConfig{
-			Enabled:         cfg.Output.Outbox.Enabled,
-			Dir:             cfg.Output.Outbox.Dir,
-			MaxBytes:        cfg.Output.Outbox.MaxBytes,
-			MaxBatchSize:    cfg.Output.Outbox.MaxBatchSize,
-			RetryBackoff:    time.Duration(cfg.Output.Outbox.RetryBackoffMS) * time.Millisecond,
-			RetryMaxBackoff: time.Duration(cfg.Output.Outbox.RetryMaxBackoffMS) * time.Millisecond,
-		},
-		ClickHouseFlushLog: func(rows int, err error) {
-			if err != nil {
-				log.Error().Err(err).Int("rows", rows).Msg("clickhouse flush failed")
-			} else {
-				log.Info().Int("rows", rows).Msg("clickhouse flush ok")
-			}
-		},
-	})
+	// Output: created here rather than by the pipeline so shutdown can write generated events and drain it
+	out, err := loom.NewWriter(cfg, log)
 	if err != nil {
 		log.Fatal().Err(err).Msg("output")
 	}
-	defer func() {
-		if err := out.Close(); err != nil {
-			log.Warn().Err(err).Msg("output close")
-		}
-	}()
+	// Pipeline: normalization, sensor metadata, enrichment (GeoIP, ASN, DNS, payload hashes,
+	// signatures) and first-seen tagging; the first-seen filter is persisted across restarts
+	// Profiling: pprof labels per pipeline stage, read through /admin/debug/pprof/
+	var profileLabels *profile.Labels
+	if cfg.Observability.Profiling {
+		profileLabels = profile.NewLabels()
+	}
+	pipeline, err := loom.NewPipeline(cfg, loom.Options{Writer: out, Log: log, Metrics: metricsReg.Registerer(), FirstSeen: sharedBits, ProfileLabels: profileLabels != nil})
+	if err != nil {
+		log.Fatal().Err(err).Msg("pipeline")
+	}
+	metricsReg.RegisterOutput(out)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	// Periodic flush for ClickHouse so buffered events are sent and logged even when volume is low
-	if cfg.Output.Type == "clickhouse" {
-		flushEvery := time.Duration(cfg.Output.Outbox.FlushIntervalMS) * time.Millisecond
-		if flushEvery <= 0 {
-			flushEvery = 10 * time.Second
+	var otlp *metrics.OTLPExporter
+	if cfg.Observability.OTLP.Enabled {
+		otlp, err = metricsReg.NewOTLPExporter(metrics.OTLPConfig{
+			Endpoint: cfg.Observability.OTLP.Endpoint,
+			Interval: time.Duration(cfg.Observability.OTLP.IntervalSeconds) * time.Second,
+			Timeout:  time.Duration(cfg.Observability.OTLP.TimeoutSeconds) * time.Second,
+			Headers:  cfg.Observability.OTLP.Headers,
+		}, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("otlp")
+		}
+		go otlp.Run(ctx)
+	}
+
+	// Output health: checked periodically so /ready reflects whether the destination is reachable
+	outputHealth := output.NewHealthMonitor(out, 5*time.Second, func(err error) {
+		if err != nil {
+			log.Warn().Err(err).Msg("output unhealthy; reporting not ready")
+		} else {
+			log.Info().Msg("output healthy")
 		}
+	})
+	_ = outputHealth.Check(ctx)
+	go outputHealth.Run(ctx, time.Duration(cfg.Output.HealthCheckIntervalSeconds)*time.Second)
+
+	// Periodic flush for ClickHouse and forward so buffered events are sent and logged even when volume is low
+	var lastFlush atomic.Int64 // unix nanoseconds the last periodic flush returned, for the watchdog
+	lastFlush.Store(time.Now().UnixNano())
+	if loom.FlushesPeriodically(cfg) {
 		go func() {
-			ticker := time.NewTicker(flushEvery)
+			ticker := time.NewTicker(loom.FlushInterval(cfg))
 			defer ticker.Stop()
 			for {
 				select {
@@ -135,59 +192,661 @@ func main() {
 					return
 				case <-ticker.C:
 					if err := out.Flush(); err != nil {
-						log.Error().Err(err).Msg("clickhouse periodic flush")
+						log.Error().Err(err).Msg(cfg.Output.Type + " periodic flush")
 					}
+					lastFlush.Store(time.Now().UnixNano())
 				}
 			}
 		}()
 	}
 
-	var metricsHandler http.Handler
-	var ingestMetrics *ingest.Metrics
-	if cfg.Observability.MetricsEnabled {
-		promReg := prometheus.NewRegistry()
-		metricsHandler = promhttp.HandlerFor(promReg, promhttp.HandlerOpts{})
-		ingestMetrics = ingest.NewMetrics(promReg)
+	// Retention: keep the ClickHouse table(s) bounded by age (TTL or periodic deletes)
+	if r := cfg.Output.Retention; r.Enabled {
+		table := cfg.Output.ClickHouseTable
+		if table == "" {
+			table = "loom_events" // the output's default
+		}
+		tables := map[string]int{table: r.MaxAgeDays}
+		for table, days := range r.Tables {
+			tables[table] = days
+		}
+		retainer, err := retention.New(retention.Config{
+			URL:                 cfg.Output.ClickHouseURL,
+			User:                cfg.Output.ClickHouseUser,
+			Password:            cfg.Output.ClickHousePassword,
+			Database:            cfg.Output.ClickHouseDatabase,
+			Mode:                r.Mode,
+			Tables:              tables,
+			TimestampExpression: r.TimestampExpression,
+			Interval:            time.Duration(r.IntervalMinutes) * time.Minute,
+			Proxy:               cfg.Output.Proxy,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("retention")
+		}
+		go retainer.Run(ctx, func(table string, err error) {
+			if err != nil {
+				log.Error().Err(err).Str("table", table).Msg("retention failed")
+			} else {
+				log.Info().Str("table", table).Str("mode", r.Mode).Msg("retention applied")
+			}
+		})
+	}
+
+	// Sessionization: group events per sensor + source IP and emit summaries when sessions go idle
+	var sessions *session.Tracker
+	if cfg.Sessions.Enabled {
+		sessions = session.NewTracker(
+			time.Duration(cfg.Sessions.IdleTimeoutSeconds)*time.Second,
+			cfg.Sessions.MaxSessions,
+		)
+		go func() {
+			ticker := time.NewTicker(5 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					writeGenerated(out, sessions.Expire(), log, "session summary")
+				}
+			}
+		}()
+	}
+
+	// Rollup: periodic aggregate events per group-by field (optionally replacing the raw events)
+	var aggregator *rollup.Aggregator
+	if cfg.Rollup.Enabled {
+		aggregator = rollup.NewAggregator(cfg.Rollup.GroupBy, cfg.Rollup.MaxKeys)
+		go func() {
+			ticker := time.NewTicker(time.Duration(cfg.Rollup.IntervalSeconds) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					writeGenerated(out, aggregator.Flush(), log, "rollup")
+				}
+			}
+		}()
+	}
+
+	go pipeline.Run(ctx, func(err error) {
+		log.Warn().Err(err).Msg("first_seen save")
+	})
+
+	// Last-seen per sensor, exported so a sensor that goes quiet can be alerted on
+	sensorActivity := ingest.NewSensorActivity()
+	metricsReg.RegisterSensorActivity(sensorActivity)
+	// Pauses and disables of single sensors through the admin API (tokens stay valid)
+	sensorControl := ingest.NewSensorControl()
+	// Drain mode through the admin API: ingest answers 503 while the output is flushed
+	ingestGate := ingest.NewGate()
+
+	// Query API: recent events in memory, searchable on the management port
+	var recent *query.Store
+	if cfg.Query.Enabled {
+		recent = query.NewStore(cfg.Query.MaxEvents, time.Duration(cfg.Query.RetentionHours)*time.Hour)
+	}
+
+	// Live tail: enriched events streamed to /admin/tail clients (admin token required)
+	var tail *query.Hub
+	if cfg.Observability.AdminToken != "" {
+		tail = query.NewHub()
+	}
+
+	// Alerts: webhook notifications for silent sensors, a filling outbox and a down output
+	if cfg.Alerts.Enabled {
+		var checks []alert.Check
+		if m := cfg.Alerts.SensorSilentMinutes; m > 0 {
+			checks = append(checks, alert.SensorSilent(validator.Sensors, sensorActivity, time.Now(), time.Duration(m)*time.Minute))
+		}
+		if b := cfg.Alerts.OutboxMaxBytes; b > 0 {
+			checks = append(checks, alert.OutboxAbove(out, b))
+		}
+		if m := cfg.Alerts.OutputDownMinutes; m > 0 {
+			checks = append(checks, alert.OutputDown(outputHealth, time.Duration(m)*time.Minute))
+		}
+		alerts := alert.NewManager(cfg.Alerts.WebhookURL, time.Duration(cfg.Alerts.RepeatSeconds)*time.Second, checks, log)
+		go alerts.Run(ctx, time.Duration(cfg.Alerts.IntervalSeconds)*time.Second)
+	}
+
+	// Detection: rules over the enriched stream; alerts go to the output and/or a webhook
+	var detector *detect.Engine
+	var detectWebhook chan event.Event
+	if cfg.Detection.Enabled {
+		rules, err := detect.LoadRules(cfg.Detection.RulesPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("detection")
+		}
+		detector = detect.NewEngine(rules, cfg.Detection.MaxKeys)
+		log.Info().Int("rules", detector.Len()).Msg("detection rules loaded")
+		if cfg.Detection.Output != "events" {
+			detectWebhook = make(chan event.Event, 256)
+			webhook := alert.NewWebhook(cfg.Detection.WebhookURL)
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case ev := <-detectWebhook:
+						if err := webhook.Send(ctx, ev); err != nil {
+							log.Warn().Err(err).Msg("detection webhook")
+						}
+					}
+				}
+			}()
+		}
+	}
+	emitDetections := func(alerts []event.Event) {
+		if cfg.Detection.Output != "webhook" {
+			writeGenerated(out, alerts, log, "detection alert")
+		}
+		if detectWebhook != nil {
+			for _, ev := range alerts {
+				select {
+				case detectWebhook <- ev:
+				default:
+					log.Warn().Msg("detection webhook queue full; alert dropped")
+				}
+			}
+		}
+	}
+
+	// Ordered delivery: events of these sensors get loom.sequence in the order they are written
+	var ordered []string
+	for id, sc := range cfg.Sensors {
+		if sc.OrderedDelivery {
+			ordered = append(ordered, id)
+		}
+	}
+	sequencer := sequence.New(ordered)
+
+	// Artifact uploads, linked to their event through X-Event-ID
+	var artifactHandler *artifact.Handler
+	var artifactLinks *artifact.Links
+	if cfg.Artifacts.Enabled {
+		var store artifact.Store
+		var err error
+		if cfg.Artifacts.S3Bucket != "" {
+			store, err = artifact.NewS3Store(artifact.S3Config{
+				Endpoint:  cfg.Artifacts.S3Endpoint,
+				Region:    cfg.Artifacts.S3Region,
+				Bucket:    cfg.Artifacts.S3Bucket,
+				Prefix:    cfg.Artifacts.S3Prefix,
+				AccessKey: cfg.Artifacts.S3AccessKey,
+				SecretKey: cfg.Artifacts.S3SecretKey,
+			})
+		} else {
+			store, err = artifact.NewDirStore(cfg.Artifacts.Dir)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("artifacts")
+		}
+		artifactLinks = artifact.NewLinks(time.Duration(cfg.Artifacts.LinkTTLSeconds)*time.Second, 0)
+		artifactHandler = &artifact.Handler{
+			Validator: validator,
+			Store:     store,
+			Links:     artifactLinks,
+			MaxBytes:  cfg.Artifacts.MaxBytes,
+			TempDir:   cfg.Artifacts.TempDir,
+			Log:       log,
+		}
+	}
+
+	// Threat-intel indicators: values seen by several sensors, published over TAXII
+	var indicators *intel.Tracker
+	if cfg.Intel.Enabled {
+		indicators = intel.NewTracker(intel.Config{
+			Fields:     cfg.Intel.Fields,
+			MinSensors: cfg.Intel.MinSensors,
+			Window:     time.Duration(cfg.Intel.WindowHours) * time.Hour,
+			MaxKeys:    cfg.Intel.MaxKeys,
+		})
+		go indicators.Run(ctx, time.Duration(cfg.Intel.RefreshSeconds)*time.Second)
+		if m := cfg.Intel.MISP; m.Enabled {
+			misp, err := intel.NewMISP(intel.MISPConfig{
+				URL:          m.URL,
+				APIKey:       m.APIKey,
+				CAFile:       m.CAFile,
+				Proxy:        m.Proxy,
+				EventInfo:    m.EventInfo,
+				Distribution: m.Distribution,
+				ThreatLevel:  m.ThreatLevel,
+				Analysis:     m.Analysis,
+				Tags:         m.Tags,
+				ToIDs:        m.ToIDs,
+				Sightings:    m.Sightings,
+			}, indicators)
+			if err != nil {
+				log.Fatal().Err(err).Msg("intel")
+			}
+			go misp.Run(ctx, time.Duration(m.IntervalSeconds)*time.Second, log)
+		}
+	}
+
+	// Scheduled summary reports by webhook and/or email
+	var reporter *report.Collector
+	if cfg.Reports.Enabled {
+		reporter = report.NewCollector(cfg.Reports.Schedule, time.Now(), cfg.Reports.Top, cfg.Reports.MaxKeys)
+		var senders []report.Sender
+		if cfg.Reports.WebhookURL != "" {
+			senders = append(senders, report.Webhook{Poster: alert.NewWebhook(cfg.Reports.WebhookURL)})
+		}
+		if cfg.Reports.SMTPAddr != "" {
+			senders = append(senders, report.Email{
+				Addr:     cfg.Reports.SMTPAddr,
+				Username: cfg.Reports.SMTPUsername,
+				Password: cfg.Reports.SMTPPassword,
+				From:     cfg.Reports.EmailFrom,
+				To:       cfg.Reports.EmailTo,
+			})
+		}
+		go report.Run(ctx, reporter, cfg.Reports.Hour, senders, log)
 	}
 
+	// Per-sensor clock offsets, estimated from HTTP ingest batches
+	var clockSkew *ingest.ClockSkew
+	if cs := cfg.ClockSkew; cs.Enabled {
+		var threshold time.Duration
+		if cs.Correct {
+			threshold = time.Duration(cs.ThresholdSeconds) * time.Second
+		}
+		clockSkew = ingest.NewClockSkew(threshold, cs.Sensors)
+		metricsReg.RegisterClockSkew(clockSkew)
+	}
+
+	// processBatch runs a sensor's events through the pipeline, detection, sessions and rollups
+	// to the output; used by HTTP ingest and the Kafka input
+	processBatch := func(ctx context.Context, sensorID string, events []event.Event) error {
+		stamp, done := sequencer.Batch(sensorID)
+		defer done()
+		defer profileLabels.Clear()
+		requestID := ""
+		if cfg.Observability.EventRequestID {
+			requestID = ingest.RequestID(ctx)
+		}
+		transport := ingest.Transport(ctx)
+		// A backfill comes from wherever the archive is, with old timestamps: it neither locates
+		// the sensor nor tells its clock offset
+		backfill := ingest.IsBackfill(ctx)
+		if transport != nil && !backfill {
+			// The peer address, not the forwarded one: senders set X-Forwarded-For themselves
+			pipeline.Heartbeat(sensorID, transport.RemoteIP)
+			clockSkew.Observe(sensorID, events, time.Now())
+		}
+		if !cfg.Observability.EventTransport {
+			transport = nil
+		}
+		for _, ev := range events {
+			// Before any stage that counts the event: the sensor sends the batch again
+			if ingest.ProcessingAbandoned(ctx) {
+				return ctx.Err()
+			}
+			if requestID != "" {
+				ecs.Set(ev, output.RequestIDField, requestID)
+			}
+			if transport != nil {
+				ecs.Set(ev, ingest.TransportField, transport.Fields())
+			}
+			artifactLinks.Apply(sensorID, ev)
+			pipeline.Enrich(sensorID, ev)
+			if !backfill {
+				clockSkew.Correct(sensorID, ev)
+			}
+			indicators.Observe(sensorID, ev)
+			reporter.Observe(sensorID, ev)
+			profileLabels.Enter("detect")
+			if alerts := detector.Observe(sensorID, ev); len(alerts) > 0 {
+				emitDetections(alerts)
+			}
+			if sessions != nil {
+				profileLabels.Enter("sessions")
+				sessions.Observe(sensorID, ev)
+			}
+			profileLabels.Enter("rollup")
+			if aggregator != nil && aggregator.Observe(sensorID, ev) && cfg.Rollup.DropRaw {
+				continue
+			}
+			profileLabels.Enter("output")
+			stamp(ev)
+			if err := output.WriteFrom(out, sensorID, ev); err != nil {
+				return err
+			}
+			profileLabels.Enter("query")
+			recent.Add(sensorID, ev)
+			tail.Publish(sensorID, ev)
+		}
+		return nil
+	}
+	// processRaw writes events as received in passthrough mode (HTTP ingest; the Kafka input still
+	// decodes its messages for processBatch)
+	processRaw := func(ctx context.Context, sensorID string, events []json.RawMessage) error {
+		for _, raw := range events {
+			if ingest.ProcessingAbandoned(ctx) {
+				return ctx.Err()
+			}
+			if err := output.WriteRaw(out, sensorID, raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Tenants: a tenant's sensors share its request rate, daily event quota and batch size limit
+	sensorTenants := make(map[string]string)
+	for id, sc := range cfg.Sensors {
+		if sc.Tenant != "" {
+			sensorTenants[id] = sc.Tenant
+		}
+	}
+	tenantLimits := make(map[string]tenant.Limits, len(cfg.Tenants))
+	for id, t := range cfg.Tenants {
+		tenantLimits[id] = tenant.Limits{RPS: t.RPS, EventsPerDay: t.EventsPerDay, MaxEventsPerBatch: t.MaxEventsPerBatch}
+	}
+	tenants := tenant.New(sensorTenants, tenantLimits)
+	tenants.SetAlgorithm(cfg.Limits.RateLimitAlgorithm, cfg.Limits.RateLimitBurst)
+	tenants.UseShared(sharedCounter)
 	ingestHandler := &ingest.Handler{
 		Validator:     validator,
 		RateLimiter:   rateLimiter,
 		MaxBodyBytes:  cfg.Limits.MaxBodySizeBytes,
 		MaxEvents:     cfg.Limits.MaxEventsPerBatch,
 		MaxEventBytes: cfg.Limits.MaxEventSizeBytes,
-		ProcessBatch: func(sensorID string, events []map[string]interface{}) error {
-			for _, ev := range events {
-				enricher.EnrichEvent(ev)
-				if err := out.Write(ev); err != nil {
-					return err
-				}
+		ProcessBatch:  processBatch,
+		Log:           log,
+		Metrics:       metricsReg.Ingest(),
+		Activity:      sensorActivity,
+		Tenants:       tenants,
+		Control:       sensorControl,
+		Gate:          ingestGate,
+
+		SplitLargeBatches: cfg.Limits.SplitLargeBatches,
+		Profile:           profileLabels,
+	}
+	ingestHandler.ProcessTimeout = processTimeout(cfg.Limits.ProcessingTimeoutSeconds)
+	// X-Loom-Durability: sync waits until the output has the events (or spooled them)
+	ingestHandler.Sync = func() error { return output.Sync(out) }
+	if cfg.Output.Passthrough {
+		ingestHandler.ProcessRaw = processRaw
+	}
+	// Backfill: archived events without the request rate limits
+	if bf := cfg.Backfill; bf.Enabled {
+		ingestHandler.Backfill = &ingest.Backfill{MaxBodyBytes: bf.MaxBodySizeBytes, MaxEvents: bf.MaxEventsPerBatch}
+		if len(bf.Sensors) > 0 {
+			ingestHandler.Backfill.Sensors = make(map[string]bool, len(bf.Sensors))
+			for _, id := range bf.Sensors {
+				ingestHandler.Backfill.Sensors[id] = true
 			}
-			return nil
-		},
-		Log:     log,
-		Metrics: ingestMetrics,
+		}
+	}
+	if cfg.Strict.Enabled {
+		ingestHandler.Fields = ingest.NewFieldAllowlist(cfg.Strict.AllowedFields, cfg.Strict.Mode == "strip")
+	}
+	// Back-pressure: 503 with Retry-After while the outbox is above its high-water mark, checked
+	// every second rather than on each request
+	if ob := cfg.Output.Outbox; ob.BackpressureBytes > 0 {
+		maxWait := time.Duration(ob.BackpressureMaxRetryAfterSeconds) * time.Second
+		backpressure := output.NewBackpressure(out, ob.BackpressureBytes, loom.FlushInterval(cfg), maxWait)
+		go backpressure.Run(ctx, time.Second)
+		ingestHandler.Backpressure = backpressure.RetryAfter
 	}
 
+	// TLS: certificates are selected by SNI and reloaded when the files change (e.g. after renewal)
 	var tlsConfig *tls.Config
-	if cfg.Server.TLS && (cfg.Server.CertFile != "" && cfg.Server.KeyFile != "") {
-		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.Server.TLSEnabled() && (cfg.Server.CertFile != "" && cfg.Server.KeyFile != "") {
+		pairs := []server.CertPair{{CertFile: cfg.Server.CertFile, KeyFile: cfg.Server.KeyFile}}
+		for _, c := range cfg.Server.Certificates {
+			pairs = append(pairs, server.CertPair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+		}
+		certStore, err := server.NewCertStore(pairs, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("tls")
+		}
+		go certStore.Watch(ctx, time.Duration(cfg.Server.CertReloadIntervalSeconds)*time.Second)
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certStore.GetCertificate}
+		if cfg.Observability.EventTransport {
+			tlsConfig.ClientAuth = tls.RequestClientCert // fingerprinted for loom.transport, not verified
+		}
+		if cfg.Hardening.Enabled {
+			server.Harden(tlsConfig)
+		}
 	}
 
+	// SIGHUP: reload tokens, limits, enrichment DBs and log level without restarting
+	rl := &reloader{
+		path:        *configPath,
+		overlays:    overlays(*configOverride),
+		cfg:         cfg,
+		validator:   validator,
+		rateLimiter: rateLimiter,
+		tenants:     tenants,
+		ingest:      ingestHandler,
+		enricher:    pipeline,
+		logLevel:    logLevel,
+		log:         log,
+	}
+	var adminHandler http.Handler
+	if adminRouter := admin.NewRouter(cfg.Observability.AdminToken); adminRouter != nil {
+		adminRouter.Handle(http.MethodGet, "/loglevel", logLevel)
+		adminRouter.Handle(http.MethodPut, "/loglevel", logLevel)
+		adminRouter.Handle(http.MethodGet, "/sensors", admin.NewSensors(validator, sensorActivity, rateLimiter, sensorControl, out))
+		controlHandler := admin.NewSensorControl(sensorControl, log)
+		adminRouter.Handle(http.MethodGet, "/sensors/{sensor_id}/state", controlHandler)
+		adminRouter.Handle(http.MethodPut, "/sensors/{sensor_id}/state", controlHandler)
+		drain := admin.NewDrain(ingestGate, out, time.Duration(cfg.Output.DrainTimeoutSeconds)*time.Second, log)
+		adminRouter.Handle(http.MethodGet, "/drain", drain)
+		adminRouter.Handle(http.MethodPost, "/drain", drain)
+		adminRouter.Handle(http.MethodDelete, "/drain", drain)
+		enrichmentReload := admin.NewEnrichmentReload(rl.reloadEnrichment, pipeline.Databases, log)
+		adminRouter.Handle(http.MethodGet, "/reload-enrichment", enrichmentReload)
+		adminRouter.Handle(http.MethodPost, "/reload-enrichment", enrichmentReload)
+		adminRouter.Handle(http.MethodGet, "/tail", tail)
+		if cfg.Observability.Profiling {
+			pprofHandler := profile.Handler("/admin")
+			adminRouter.Handle(http.MethodGet, "/debug/pprof/*", pprofHandler)
+			adminRouter.Handle(http.MethodPost, "/debug/pprof/*", pprofHandler)
+		}
+		if reporter != nil {
+			adminRouter.Handle(http.MethodGet, "/report", report.NewPreview(reporter))
+		}
+		adminHandler = adminRouter
+	}
+	var apiHandler http.Handler
+	if apiRouter := admin.NewRouter(cfg.Observability.AdminToken); apiRouter != nil && recent != nil {
+		api := query.NewAPI(recent)
+		apiRouter.Handle(http.MethodGet, "/events", http.HandlerFunc(api.Events))
+		apiRouter.Handle(http.MethodGet, "/events/export", http.HandlerFunc(api.Export))
+		apiRouter.Handle(http.MethodGet, "/stats", http.HandlerFunc(api.Summary))
+		apiRouter.Handle(http.MethodGet, "/stats/top", http.HandlerFunc(api.Top))
+		apiRouter.Handle(http.MethodGet, "/stats/top-talkers", http.HandlerFunc(api.TopTalkers))
+		apiRouter.Handle(http.MethodGet, "/stats/top-ports", http.HandlerFunc(api.TopPorts))
+		apiRouter.Handle(http.MethodGet, "/stats/sensors", http.HandlerFunc(api.Sensors))
+		apiRouter.Handle(http.MethodGet, "/stats/output", dashboard.NewOutputStatus(outputHealth, out))
+		apiHandler = apiRouter
+	}
+	var intelHandler http.Handler
+	if indicators != nil {
+		token := cfg.Intel.Token
+		if token == "" {
+			token = cfg.Observability.AdminToken
+		}
+		intelHandler = intel.NewHandler(indicators, token)
+	}
+	var dashboardHandler http.Handler
+	if cfg.Query.Dashboard && apiHandler != nil {
+		dashboardHandler = dashboard.Handler()
+	}
+
+	ipFilter, err := server.NewIPFilter(cfg.Server.AllowCIDRs, cfg.Server.DenyCIDRs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("ip filter")
+	}
+
+	var listeners []server.Listener
+	for _, l := range cfg.Server.Listeners {
+		listeners = append(listeners, server.Listener{Address: l.Address, TLS: l.TLS})
+	}
 	srv := &server.Server{
 		IngestHandler:  ingestHandler,
-		EnricherReady:  enricher.Ready,
-		OutputReady:    func() bool { return true },
+		EnricherReady:  pipeline.Ready,
+		OutputReady:    outputHealth.Ready,
+		Draining:       ingestGate.Closed,
 		MetricsHandler: metricsHandler,
+		Metrics:        metricsReg.Server(),
+		IPFilter:       ipFilter,
+		OnReady: func() {
+			// systemd Type=notify: ready once the listeners accept connections
+			if err := systemd.Notify(systemd.Ready); err != nil {
+				log.Warn().Err(err).Msg("sd_notify ready")
+			}
+		},
+		VersionHandler: version.Handler(),
+		ConfigHandler:  rl,
+		AdminHandler:   adminHandler,
+		APIHandler:     apiHandler,
+		Dashboard:      dashboardHandler,
+		IntelHandler:   intelHandler,
 		Logger:         log,
 		TLSConfig:      tlsConfig,
 		CertFile:       cfg.Server.CertFile,
 		KeyFile:        cfg.Server.KeyFile,
 		ListenAddr:     cfg.Server.ListenAddress,
+		Listeners:      listeners,
 		ManagementAddr: cfg.Server.ManagementListenAddress,
+
+		AccessLogSampleEvery: cfg.Logging.AccessLogSampleEvery,
+		Timeouts: server.Timeouts{
+			Read:           time.Duration(cfg.Server.ReadTimeoutSeconds) * time.Second,
+			ReadHeader:     time.Duration(cfg.Server.ReadHeaderTimeoutSeconds) * time.Second,
+			Write:          time.Duration(cfg.Server.WriteTimeoutSeconds) * time.Second,
+			Idle:           time.Duration(cfg.Server.IdleTimeoutSeconds) * time.Second,
+			MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+			ShutdownGrace:  time.Duration(cfg.Server.ShutdownGraceSeconds) * time.Second,
+		},
+		Conn: server.ConnOptions{
+			DisableHTTP2:         cfg.Server.DisableHTTP2,
+			MaxConcurrentStreams: uint32(cfg.Server.HTTP2MaxConcurrentStreams),
+			DisableKeepAlives:    cfg.Server.DisableKeepAlives,
+			TCPKeepAlive:         time.Duration(cfg.Server.TCPKeepAliveSeconds) * time.Second,
+		},
+	}
+	if artifactHandler != nil {
+		srv.ArtifactHandler = artifactHandler
+	}
+	if ingestHandler.Backfill != nil {
+		srv.BackfillHandler = http.HandlerFunc(ingestHandler.ServeBackfill)
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				_ = systemd.Notify(systemd.Reloading)
+				if err := rl.reload(); err != nil {
+					log.Error().Err(err).Msg("config reload failed; keeping current config")
+				}
+				_ = systemd.Notify(systemd.Ready)
+			}
+		}
+	}()
+
+	// systemd watchdog (WatchdogSec=): ping at half the interval while the server answers and the
+	// periodic flush makes progress, so a hung process is restarted
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		alive := func() error {
+			checkCtx, cancel := context.WithTimeout(ctx, interval/4)
+			defer cancel()
+			if err := srv.Alive(checkCtx); err != nil {
+				return err
+			}
+			if loom.FlushesPeriodically(cfg) {
+				if since := time.Since(time.Unix(0, lastFlush.Load())); since > interval+loom.FlushInterval(cfg) {
+					return fmt.Errorf("no periodic flush finished for %s", since.Round(time.Second))
+				}
+			}
+			return nil
+		}
+		go func() {
+			ticker := time.NewTicker(interval / 2)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := alive(); err != nil {
+						log.Warn().Err(err).Msg("liveness check failed; not pinging the systemd watchdog")
+						continue
+					}
+					_ = systemd.Notify(systemd.Watchdog)
+				}
+			}
+		}()
+	}
+
+	// Kafka input: consume events from topics alongside HTTP ingest; offsets are committed after output
+	consumerDone := make(chan struct{})
+	if k := cfg.Input.Kafka; k.Enabled {
+		consumer, err := kafka.NewConsumer(kafka.Config{
+			Brokers:         k.Brokers,
+			Topics:          k.Topics,
+			GroupID:         k.GroupID,
+			StartOffset:     k.StartOffset,
+			SensorIDHeader:  k.SensorIDHeader,
+			SensorIDField:   k.SensorIDField,
+			DefaultSensorID: k.DefaultSensorID,
+			BatchSize:       k.BatchSize,
+			BatchWait:       time.Duration(k.BatchWaitMS) * time.Millisecond,
+			TLS:             k.TLS,
+			SASLMechanism:   k.SASLMechanism,
+			Username:        k.Username,
+			Password:        k.Password,
+		}, func(sensorID string, events []event.Event) error {
+			if fields := ingestHandler.Fields; fields != nil {
+				// Strict mode: there is no request to reject, so events with other fields are dropped
+				kept := events[:0]
+				for _, ev := range events {
+					unknown, stripped := fields.Check(ev)
+					if unknown != "" {
+						log.Warn().Str("sensor_id", sensorID).Str("field", unknown).Msg("kafka input: dropped event with a field strict mode does not allow")
+						continue
+					}
+					metricsReg.Ingest().AddStrippedFields(sensorID, stripped)
+					kept = append(kept, ev)
+				}
+				events = kept
+			}
+			if err := processBatch(ctx, sensorID, events); err != nil {
+				return err
+			}
+			metricsReg.Ingest().AddEvents(sensorID, len(events))
+			sensorActivity.Record(sensorID, len(events))
+			return nil
+		}, func(err error) {
+			log.Warn().Err(err).Msg("kafka input")
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("kafka input")
+		}
+		log.Info().Strs("topics", k.Topics).Str("group_id", k.GroupID).Msg("kafka input started")
+		go func() {
+			defer close(consumerDone)
+			if err := consumer.Run(ctx); err != nil {
+				log.Error().Err(err).Msg("kafka input stopped")
+			}
+		}()
+	} else {
+		close(consumerDone)
+	}
+
+	srvDone := make(chan struct{})
+	go func() {
+		defer close(srvDone)
 		if err := srv.Run(ctx); err != nil && err != http.ErrServerClosed {
 			log.Error().Err(err).Msg("server")
 		}
@@ -195,4 +854,62 @@ func main() {
 
 	<-ctx.Done()
 	log.Info().Msg("shutting down")
+	_ = systemd.Notify(systemd.Stopping)
+	// Orderly drain: stop accepting and wait for in-flight requests, then flush generated
+	// events, buffered events and the outbox within the drain deadline.
+	<-srvDone
+	<-consumerDone
+	// Saves the first-seen filter and closes the enrichment DBs; out is drained and closed below
+	if err := pipeline.Close(); err != nil {
+		log.Warn().Err(err).Msg("pipeline close")
+	}
+	if sessions != nil {
+		writeGenerated(out, sessions.Flush(), log, "session summary")
+	}
+	if aggregator != nil {
+		writeGenerated(out, aggregator.Flush(), log, "rollup")
+	}
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(cfg.Output.DrainTimeoutSeconds)*time.Second)
+	defer cancelDrain()
+	left, err := output.Drain(drainCtx, out)
+	switch {
+	case !left.Empty():
+		log.Warn().Err(err).
+			Int("buffered_events", left.BufferedEvents).
+			Int("outbox_files", left.OutboxFiles).
+			Int64("outbox_bytes", left.OutboxBytes).
+			Msg("shutdown: output not fully drained; buffered events may be lost, outbox is retried on next start")
+	case err != nil:
+		log.Warn().Err(err).Msg("shutdown: output flush")
+	}
+	// Close and push even after a drain that timed out, each with its own deadline so a stuck
+	// destination cannot keep the process from exiting
+	const stepTimeout = 10 * time.Second
+	closed := make(chan error, 1)
+	go func() { closed <- out.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			log.Warn().Err(err).Msg("output close")
+		}
+	case <-time.After(stepTimeout):
+		log.Warn().Dur("timeout", stepTimeout).Msg("output close timed out")
+	}
+	// Final OTLP push so the collector sees the counters after the drain
+	pushCtx, cancelPush := context.WithTimeout(context.Background(), stepTimeout)
+	defer cancelPush()
+	if err := otlp.Push(pushCtx); err != nil {
+		log.Warn().Err(err).Msg("otlp final push")
+	}
+	log.Info().Msg("shutdown complete")
+}
+
+// writeGenerated sends events produced by Loom itself (session summaries, rollups) to the output.
+func writeGenerated(out output.Writer, events []event.Event, log zerolog.Logger, kind string) {
+	for _, ev := range events {
+		if err := out.Write(ev); err != nil {
+			log.Error().Err(err).Msg(kind + " write")
+			return
+		}
+	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/StefanGrimminck/Loom/internal/admin"
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/config"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/StefanGrimminck/Loom/internal/tenant"
+	"github.com/StefanGrimminck/Loom/pkg/loom"
+	"github.com/rs/zerolog"
+)
+
+// reloader re-reads the config file and applies the settings that can change while serving:
+// tokens, limits, enrichment DB paths and the log level. In-flight requests are not interrupted.
+type reloader struct {
+	path        string
+	overlays    []string
+	validator   *auth.Validator
+	rateLimiter *ratelimit.PerSensorLimiter
+	tenants     *tenant.Registry
+	ingest      *ingest.Handler
+	enricher    *loom.Pipeline
+	logLevel    *admin.LogLevel
+	log         zerolog.Logger
+
+	mu  sync.Mutex
+	cfg *config.Config // config currently in effect
+}
+
+// reload applies the config at r.path. On error the running config is kept.
+func (r *reloader) reload() error {
+	updated, err := config.Load(r.path, r.overlays...)
+	if err != nil {
+		return err
+	}
+	old := r.current()
+	if updated.Enrichment.GeoIPDBPath != old.Enrichment.GeoIPDBPath || updated.Enrichment.ASNDBPath != old.Enrichment.ASNDBPath {
+		if err := r.enricher.Reload(updated.Enrichment.GeoIPDBPath, updated.Enrichment.ASNDBPath); err != nil {
+			return err
+		}
+	}
+	r.validator.Update(updated.Auth.Tokens)
+	r.rateLimiter.SetRPS(updated.Limits.PerSensorRPS)
+	r.rateLimiter.SetAlgorithm(updated.Limits.RateLimitAlgorithm, updated.Limits.RateLimitBurst)
+	r.tenants.SetAlgorithm(updated.Limits.RateLimitAlgorithm, updated.Limits.RateLimitBurst)
+	r.ingest.UpdateLimits(updated.Limits.MaxBodySizeBytes, updated.Limits.MaxEventsPerBatch, updated.Limits.MaxEventSizeBytes)
+	r.ingest.SetSplitLargeBatches(updated.Limits.SplitLargeBatches)
+	r.ingest.SetProcessTimeout(processTimeout(updated.Limits.ProcessingTimeoutSeconds))
+	r.logLevel.SetBase(parseLevel(updated.Logging.Level))
+
+	if changed := config.RestartRequired(old, updated); len(changed) > 0 {
+		r.log.Warn().Strs("sections", changed).Msg("config reload: changes in these sections require a restart and were not applied")
+	}
+	r.mu.Lock()
+	r.cfg = config.Reloadable(old, updated)
+	r.mu.Unlock()
+	r.log.Info().Int("sensors", len(updated.Auth.Tokens)).Msg("config reloaded")
+	return nil
+}
+
+// reloadEnrichment reopens the MaxMind databases at the paths in effect (e.g. after geoipupdate
+// replaced them).
+func (r *reloader) reloadEnrichment() error {
+	cfg := r.current()
+	return r.enricher.Reload(cfg.Enrichment.GeoIPDBPath, cfg.Enrichment.ASNDBPath)
+}
+
+func (r *reloader) current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// ServeHTTP returns the effective configuration as TOML with tokens and passwords redacted.
+// Settings that changed on disk but need a restart show their running values.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/toml; charset=utf-8")
+	if err := toml.NewEncoder(w).Encode(r.current().Redacted()); err != nil {
+		r.log.Warn().Err(err).Msg("config endpoint encode")
+	}
+}
+
+// overlays returns the -config-override file as an overlay list (empty when unset).
+func overlays(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return []string{path}
+}
+
+// processTimeout maps limits.processing_timeout_seconds to ingest.Handler.ProcessTimeout (0 when
+// disabled).
+func processTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// parseLevel maps logging.level to a zerolog level (default info).
+func parseLevel(level string) zerolog.Level {
+	switch level {
+	case "debug":
+		return zerolog.DebugLevel
+	case "warn":
+		return zerolog.WarnLevel
+	case "error":
+		return zerolog.ErrorLevel
+	}
+	return zerolog.InfoLevel
+}
@@ -0,0 +1,60 @@
+// Package admin serves the runtime administration endpoints (/admin/*) on the management port.
+// Every endpoint requires the admin bearer token; without a token the endpoints are not served.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Router holds the /admin routes behind bearer-token auth. The /api/v1 query API uses a second
+// Router with the same token.
+type Router struct {
+	token string
+	mux   chi.Router
+}
+
+// NewRouter returns an admin router that accepts requests carrying "Authorization: Bearer <token>".
+// Returns nil when token is empty (admin endpoints disabled).
+func NewRouter(token string) *Router {
+	if token == "" {
+		return nil
+	}
+	return &Router{token: token, mux: chi.NewRouter()}
+}
+
+// Handle registers h for method and pattern (relative to /admin, e.g. "/loglevel"). Nil-safe.
+func (a *Router) Handle(method, pattern string, h http.Handler) {
+	if a == nil {
+		return
+	}
+	a.mux.Method(method, pattern, h)
+}
+
+// ServeHTTP checks the admin token and dispatches to the registered routes.
+func (a *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authz := r.Header.Get("Authorization")
+	token := ""
+	if len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+		token = strings.TrimSpace(authz[7:])
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter_RequiresToken(t *testing.T) {
+	if NewRouter("") != nil {
+		t.Fatal("empty token: admin router should be disabled")
+	}
+	a := NewRouter("admin-secret")
+	a.Handle(http.MethodGet, "/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for authz, want := range map[string]int{
+		"":                    http.StatusUnauthorized,
+		"Bearer wrong":        http.StatusUnauthorized,
+		"Bearer admin-secret": http.StatusNoContent,
+		"bearer admin-secret": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Authorization %q: status = %d, want %d", authz, rec.Code, want)
+		}
+	}
+}
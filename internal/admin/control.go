@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+// SensorControl serves /admin/sensors/{sensor_id}/state: GET shows whether the sensor is active,
+// paused or disabled; PUT {"state":"paused","reason":"flooding","duration_seconds":600} changes
+// it. The sensor's token stays valid throughout.
+type SensorControl struct {
+	control *ingest.SensorControl
+	log     zerolog.Logger
+}
+
+// NewSensorControl returns the handler for control.
+func NewSensorControl(control *ingest.SensorControl, log zerolog.Logger) *SensorControl {
+	return &SensorControl{control: control, log: log}
+}
+
+func (s *SensorControl) state(sensorID string) ingest.SensorState {
+	if st, ok := s.control.State(sensorID); ok {
+		return st
+	}
+	return ingest.SensorState{SensorID: sensorID, State: ingest.SensorActive}
+}
+
+// ServeHTTP handles GET and PUT. Pauses last duration_seconds (default 15 minutes, at most 24
+// hours); disables last until the state is set back to active, or duration_seconds if given.
+func (s *SensorControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sensorID := chi.URLParam(r, "sensor_id")
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, s.state(sensorID))
+		return
+	}
+	var req struct {
+		State           string `json:"state"`
+		Reason          string `json:"reason"`
+		DurationSeconds int    `json:"duration_seconds"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.DurationSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	d := time.Duration(req.DurationSeconds) * time.Second
+	switch req.State {
+	case ingest.SensorPaused:
+		s.control.Pause(sensorID, req.Reason, d)
+	case ingest.SensorDisabled:
+		s.control.Disable(sensorID, req.Reason, d)
+	case ingest.SensorActive:
+		s.control.Resume(sensorID)
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_state")
+		return
+	}
+	st := s.state(sensorID)
+	ev := s.log.Warn().Str("sensor_id", sensorID).Str("state", st.State).Str("reason", req.Reason)
+	if st.Until != nil {
+		ev = ev.Time("until", *st.Until)
+	}
+	ev.Msg("sensor state changed via admin endpoint")
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/rs/zerolog"
+)
+
+func TestSensorControl_SetState(t *testing.T) {
+	control := ingest.NewSensorControl()
+	r := NewRouter("admin")
+	r.Handle(http.MethodGet, "/sensors", NewSensors(auth.NewValidator(map[string]string{"t1": "spip-01"}), nil, ratelimit.NewPerSensorLimiter(10), control, nil))
+	h := NewSensorControl(control, zerolog.Nop())
+	r.Handle(http.MethodGet, "/sensors/{sensor_id}/state", h)
+	r.Handle(http.MethodPut, "/sensors/{sensor_id}/state", h)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/sensors/spip-01/state", `{"state":"paused","reason":"flooding","duration_seconds":600}`)
+	var st ingest.SensorState
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d, %v", rec.Code, err)
+	}
+	if st.State != ingest.SensorPaused || st.Reason != "flooding" || st.Until == nil {
+		t.Errorf("state = %+v", st)
+	}
+	rec = do(http.MethodGet, "/sensors", "")
+	var list struct {
+		Sensors []SensorInfo `json:"sensors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sensors) != 1 || list.Sensors[0].State != ingest.SensorPaused || list.Sensors[0].StateReason != "flooding" {
+		t.Errorf("sensors = %+v", list.Sensors)
+	}
+
+	if rec := do(http.MethodPut, "/sensors/spip-01/state", `{"state":"sleeping"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid state: status %d", rec.Code)
+	}
+	do(http.MethodPut, "/sensors/spip-01/state", `{"state":"active"}`)
+	if _, ok := control.State("spip-01"); ok {
+		t.Error("sensor still paused after setting it active")
+	}
+	if rec := do(http.MethodGet, "/sensors/spip-01/state", ""); !strings.Contains(rec.Body.String(), `"state":"active"`) {
+		t.Errorf("GET = %s", rec.Body)
+	}
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/rs/zerolog"
+)
+
+// States of a drain.
+const (
+	DrainServing  = "serving"
+	DrainDraining = "draining"
+	DrainDrained  = "drained" // nothing left in the output; safe to stop
+	DrainFailed   = "failed"  // the output still holds events after the timeout
+)
+
+// DrainStatus is the body of the /admin/drain responses.
+type DrainStatus struct {
+	State          string     `json:"state"`
+	Since          *time.Time `json:"since,omitempty"`
+	Completed      *time.Time `json:"completed,omitempty"`
+	InFlight       int        `json:"in_flight"`
+	BufferedEvents int        `json:"buffered_events"`
+	OutboxFiles    int        `json:"outbox_files"`
+	OutboxBytes    int64      `json:"outbox_bytes"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// Drain serves /admin/drain for blue/green deploys. POST closes the ingest gate (sensors get 503
+// and keep buffering), waits for the requests in progress and flushes the output, buffered events
+// and outbox, within the timeout; GET reports the progress and DELETE serves ingest again. A
+// drain stays in effect until DELETE or shutdown.
+type Drain struct {
+	gate    *ingest.Gate
+	out     output.Writer
+	timeout time.Duration
+	log     zerolog.Logger
+
+	mu     sync.Mutex
+	status DrainStatus
+	cancel context.CancelFunc // of the running drain
+}
+
+// NewDrain returns the drain handler for gate and out.
+func NewDrain(gate *ingest.Gate, out output.Writer, timeout time.Duration, log zerolog.Logger) *Drain {
+	return &Drain{gate: gate, out: out, timeout: timeout, log: log, status: DrainStatus{State: DrainServing}}
+}
+
+// Start begins a drain unless one is running or done, and returns the status.
+func (d *Drain) Start() DrainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status.State == DrainServing || d.status.State == DrainFailed {
+		now := time.Now().UTC()
+		d.status = DrainStatus{State: DrainDraining, Since: &now}
+		d.gate.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		d.cancel = cancel
+		go d.run(ctx)
+		d.log.Warn().Msg("drain started via admin endpoint; ingest answers 503")
+	}
+	return d.statusLocked()
+}
+
+func (d *Drain) run(ctx context.Context) {
+	err := d.gate.Wait(ctx)
+	var left output.Pending
+	if err == nil {
+		left, err = output.Drain(ctx, d.out)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status.State != DrainDraining || ctx.Err() == context.Canceled {
+		return // resumed meanwhile
+	}
+	d.cancel()
+	now := time.Now().UTC()
+	d.status.Completed = &now
+	d.status.BufferedEvents, d.status.OutboxFiles, d.status.OutboxBytes = left.BufferedEvents, left.OutboxFiles, left.OutboxBytes
+	if err == nil && left.Empty() && d.gate.InFlight() == 0 {
+		d.status.State = DrainDrained
+		d.log.Info().Msg("drain complete")
+		return
+	}
+	d.status.State = DrainFailed
+	if err != nil {
+		d.status.Error = err.Error()
+	}
+	d.log.Warn().Err(err).Int("buffered_events", left.BufferedEvents).Int("outbox_files", left.OutboxFiles).Msg("drain incomplete")
+}
+
+// Resume opens the gate again, ending a drain.
+func (d *Drain) Resume() DrainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status.State != DrainServing {
+		if d.cancel != nil {
+			d.cancel()
+		}
+		d.gate.Open()
+		d.status = DrainStatus{State: DrainServing}
+		d.log.Warn().Msg("drain ended via admin endpoint; ingest resumed")
+	}
+	return d.statusLocked()
+}
+
+// Status returns the state of the drain.
+func (d *Drain) Status() DrainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.statusLocked()
+}
+
+func (d *Drain) statusLocked() DrainStatus {
+	st := d.status
+	st.InFlight = d.gate.InFlight()
+	return st
+}
+
+// ServeHTTP handles GET, POST and DELETE.
+func (d *Drain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		writeJSON(w, http.StatusAccepted, d.Start())
+	case http.MethodDelete:
+		writeJSON(w, http.StatusOK, d.Resume())
+	default:
+		writeJSON(w, http.StatusOK, d.Status())
+	}
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/rs/zerolog"
+)
+
+// bufferedWriter holds events until its second flush.
+type bufferedWriter struct {
+	mu      sync.Mutex
+	held    int
+	flushes int
+}
+
+func (b *bufferedWriter) Write(event.Event) error      { b.mu.Lock(); b.held++; b.mu.Unlock(); return nil }
+func (b *bufferedWriter) Close() error                 { return nil }
+func (b *bufferedWriter) Health(context.Context) error { return nil }
+
+func (b *bufferedWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flushes++; b.flushes >= 2 {
+		b.held = 0
+	}
+	return nil
+}
+
+func (b *bufferedWriter) Pending() output.Pending {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return output.Pending{BufferedEvents: b.held}
+}
+
+func TestDrain(t *testing.T) {
+	gate := ingest.NewGate()
+	out := &bufferedWriter{}
+	_ = out.Write(event.Event{})
+	d := NewDrain(gate, out, 10*time.Second, zerolog.Nop())
+	do := func(method string) DrainStatus {
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, httptest.NewRequest(method, "/drain", nil))
+		var st DrainStatus
+		if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	if st := do(http.MethodGet); st.State != DrainServing {
+		t.Fatalf("initial state = %q", st.State)
+	}
+	if st := do(http.MethodPost); st.State != DrainDraining || !gate.Closed() {
+		t.Fatalf("after POST: state = %q, gate closed = %v", st.State, gate.Closed())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	st := do(http.MethodGet)
+	for st.State == DrainDraining && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		st = do(http.MethodGet)
+	}
+	if st.State != DrainDrained || st.Completed == nil || st.BufferedEvents != 0 {
+		t.Fatalf("status = %+v, want drained", st)
+	}
+	if st := do(http.MethodDelete); st.State != DrainServing || gate.Closed() {
+		t.Errorf("after DELETE: state = %q, gate closed = %v", st.State, gate.Closed())
+	}
+}
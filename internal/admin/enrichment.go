@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/rs/zerolog"
+)
+
+// EnrichmentStatus is the body of the /admin/reload-enrichment responses.
+type EnrichmentStatus struct {
+	Databases []enrich.DBInfo `json:"databases"`
+	// Error is set when the reload failed; the databases opened before stay in use.
+	Error string `json:"error,omitempty"`
+}
+
+// EnrichmentReload serves /admin/reload-enrichment: POST reopens the MaxMind databases at the
+// configured paths (e.g. from a geoipupdate cron job) and GET shows the open ones. Both return
+// the metadata of the databases in use afterwards.
+type EnrichmentReload struct {
+	reload    func() error
+	databases func() []enrich.DBInfo
+	log       zerolog.Logger
+}
+
+// NewEnrichmentReload returns the handler; reload reopens the databases and databases lists them.
+func NewEnrichmentReload(reload func() error, databases func() []enrich.DBInfo, log zerolog.Logger) *EnrichmentReload {
+	return &EnrichmentReload{reload: reload, databases: databases, log: log}
+}
+
+// ServeHTTP handles GET and POST. A failed reload answers 500 with the databases still in use.
+func (e *EnrichmentReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusOK, e.status())
+		return
+	}
+	if err := e.reload(); err != nil {
+		e.log.Warn().Err(err).Msg("enrichment reload via admin endpoint failed; keeping the open databases")
+		st := e.status()
+		st.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, st)
+		return
+	}
+	e.log.Info().Msg("enrichment databases reloaded via admin endpoint")
+	writeJSON(w, http.StatusOK, e.status())
+}
+
+func (e *EnrichmentReload) status() EnrichmentStatus {
+	dbs := e.databases()
+	if dbs == nil {
+		dbs = []enrich.DBInfo{} // "databases": [] when running without ASN/GEO
+	}
+	return EnrichmentStatus{Databases: dbs}
+}
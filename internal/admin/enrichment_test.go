@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/rs/zerolog"
+)
+
+func TestEnrichmentReload(t *testing.T) {
+	built := time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)
+	var reloads int
+	var fail error
+	h := NewEnrichmentReload(
+		func() error { reloads++; return fail },
+		func() []enrich.DBInfo {
+			return []enrich.DBInfo{{Name: "geo", Path: "/var/lib/loom/GeoLite2-City.mmdb", Type: "GeoLite2-City", BuildTime: built}}
+		},
+		zerolog.Nop())
+	do := func(method string) (int, EnrichmentStatus) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/reload-enrichment", nil))
+		var st EnrichmentStatus
+		if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, st
+	}
+
+	if code, st := do(http.MethodGet); code != http.StatusOK || reloads != 0 || len(st.Databases) != 1 {
+		t.Errorf("GET = %d %+v (reloads %d), want the databases without a reload", code, st, reloads)
+	}
+	code, st := do(http.MethodPost)
+	if code != http.StatusOK || reloads != 1 || st.Error != "" {
+		t.Fatalf("POST = %d %+v (reloads %d)", code, st, reloads)
+	}
+	if db := st.Databases[0]; db.Type != "GeoLite2-City" || !db.BuildTime.Equal(built) {
+		t.Errorf("database = %+v", db)
+	}
+
+	fail = errors.New("open /var/lib/loom/GeoLite2-City.mmdb: no such file or directory")
+	if code, st := do(http.MethodPost); code != http.StatusInternalServerError || st.Error != fail.Error() || len(st.Databases) != 1 {
+		t.Errorf("failed POST = %d %+v, want 500 with the error and the open databases", code, st)
+	}
+}
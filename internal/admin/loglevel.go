@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultLevelDuration = 15 * time.Minute
+	maxLevelDuration     = 24 * time.Hour
+)
+
+// LogLevel changes the global zerolog level for a limited time and then restores the configured level.
+type LogLevel struct {
+	mu    sync.Mutex
+	base  zerolog.Level
+	timer *time.Timer
+	gen   uint64 // counts overrides, so the timer of a replaced one cannot end its successor
+	until time.Time
+	log   zerolog.Logger
+}
+
+// NewLogLevel applies base as the global level.
+func NewLogLevel(base zerolog.Level, log zerolog.Logger) *LogLevel {
+	zerolog.SetGlobalLevel(base)
+	return &LogLevel{base: base, log: log}
+}
+
+// SetBase changes the configured level (e.g. on config reload). A temporary override stays
+// in effect until it expires.
+func (l *LogLevel) SetBase(level zerolog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.base = level
+	if l.timer == nil {
+		zerolog.SetGlobalLevel(level)
+	}
+}
+
+// Override sets level for d (default 15m, capped at 24h), then restores the configured level.
+func (l *LogLevel) Override(level zerolog.Level, d time.Duration) time.Time {
+	if d <= 0 {
+		d = defaultLevelDuration
+	}
+	if d > maxLevelDuration {
+		d = maxLevelDuration
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	zerolog.SetGlobalLevel(level)
+	l.until = time.Now().Add(d)
+	l.gen++
+	gen := l.gen
+	l.timer = time.AfterFunc(d, func() { l.restore(gen) })
+	return l.until
+}
+
+// restore ends override gen, unless a later override replaced it (its timer may have fired
+// before Stop).
+func (l *LogLevel) restore(gen uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if gen != l.gen {
+		return
+	}
+	l.timer = nil
+	l.until = time.Time{}
+	zerolog.SetGlobalLevel(l.base)
+	l.log.Info().Str("level", l.base.String()).Msg("log level override expired")
+}
+
+type logLevelState struct {
+	Level           string     `json:"level"`
+	ConfiguredLevel string     `json:"configured_level"`
+	Until           *time.Time `json:"until,omitempty"`
+}
+
+func (l *LogLevel) state() logLevelState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := logLevelState{Level: zerolog.GlobalLevel().String(), ConfiguredLevel: l.base.String()}
+	if !l.until.IsZero() {
+		until := l.until
+		st.Until = &until
+	}
+	return st
+}
+
+// ServeHTTP handles GET (current level) and PUT {"level":"debug","duration_seconds":600}.
+func (l *LogLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, l.state())
+		return
+	}
+	var req struct {
+		Level           string `json:"level"`
+		DurationSeconds int    `json:"duration_seconds"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	level, err := zerolog.ParseLevel(req.Level)
+	if err != nil || req.Level == "" || level == zerolog.NoLevel {
+		writeError(w, http.StatusBadRequest, "invalid_level")
+		return
+	}
+	until := l.Override(level, time.Duration(req.DurationSeconds)*time.Second)
+	l.log.Warn().Str("level", level.String()).Time("until", until).Msg("log level changed via admin endpoint")
+	writeJSON(w, http.StatusOK, l.state())
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLogLevel_OverrideExpires(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	l := NewLogLevel(zerolog.InfoLevel, zerolog.Nop())
+	l.Override(zerolog.DebugLevel, 20*time.Millisecond)
+	if zerolog.GlobalLevel() != zerolog.DebugLevel {
+		t.Fatalf("level = %v, want debug", zerolog.GlobalLevel())
+	}
+	// A reload during the override changes the level restored afterwards
+	l.SetBase(zerolog.WarnLevel)
+	if zerolog.GlobalLevel() != zerolog.DebugLevel {
+		t.Error("SetBase must not cancel an active override")
+	}
+	deadline := time.Now().Add(time.Second)
+	for zerolog.GlobalLevel() != zerolog.WarnLevel && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if zerolog.GlobalLevel() != zerolog.WarnLevel {
+		t.Errorf("after expiry level = %v, want warn", zerolog.GlobalLevel())
+	}
+}
+
+func TestLogLevel_StaleTimer(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	l := NewLogLevel(zerolog.InfoLevel, zerolog.Nop())
+	defer l.SetBase(zerolog.InfoLevel)
+	l.Override(zerolog.DebugLevel, time.Hour)
+	l.Override(zerolog.TraceLevel, time.Hour)
+	// The first override's timer fired just before the second one stopped it
+	l.restore(1)
+	if zerolog.GlobalLevel() != zerolog.TraceLevel || l.state().Until == nil {
+		t.Errorf("stale timer ended the new override: level %v", zerolog.GlobalLevel())
+	}
+	l.restore(2)
+	if zerolog.GlobalLevel() != zerolog.InfoLevel {
+		t.Errorf("level = %v, want info after the override ends", zerolog.GlobalLevel())
+	}
+}
+
+func TestLogLevel_ServeHTTP(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	l := NewLogLevel(zerolog.InfoLevel, zerolog.Nop())
+	defer l.SetBase(zerolog.InfoLevel)
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"debug","duration_seconds":60}`)))
+	if rec.Code != http.StatusOK || zerolog.GlobalLevel() != zerolog.DebugLevel {
+		t.Fatalf("PUT: status=%d level=%v", rec.Code, zerolog.GlobalLevel())
+	}
+	if !strings.Contains(rec.Body.String(), `"until"`) {
+		t.Errorf("response should report when the override ends: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level":"loud"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid level: status = %d, want 400", rec.Code)
+	}
+}
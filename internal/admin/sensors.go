@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+)
+
+// Sensors serves GET /admin/sensors: one entry per configured sensor (plus any sensor seen since
+// startup whose token has since been removed, or paused or disabled) with activity, rate-limit
+// state, pause state and outbox share.
+type Sensors struct {
+	validator   *auth.Validator
+	activity    *ingest.SensorActivity
+	rateLimiter *ratelimit.PerSensorLimiter
+	control     *ingest.SensorControl
+	out         output.Writer
+	nowFn       func() time.Time
+}
+
+// SensorInfo is one sensor in the /admin/sensors response.
+type SensorInfo struct {
+	SensorID           string     `json:"sensor_id"`
+	Configured         bool       `json:"configured"`
+	LastSeen           *time.Time `json:"last_seen,omitempty"`
+	LastSeenAgeSeconds *float64   `json:"last_seen_age_seconds,omitempty"`
+	Batches            uint64     `json:"batches"`
+	Events             uint64     `json:"events"`
+	RateLimitUsed      int        `json:"rate_limit_used"` // requests in the current second
+	RateLimitRPS       int        `json:"rate_limit_rps"`  // 0 when rate limiting is disabled
+	OutboxEvents       int        `json:"outbox_events"`
+	State              string     `json:"state"` // active, paused or disabled (see SensorControl)
+	StateReason        string     `json:"state_reason,omitempty"`
+	StateUntil         *time.Time `json:"state_until,omitempty"`
+}
+
+// NewSensors creates the inventory handler. control may be nil. out may be any writer; only
+// writers with an outbox report outbox events.
+func NewSensors(validator *auth.Validator, activity *ingest.SensorActivity, rateLimiter *ratelimit.PerSensorLimiter, control *ingest.SensorControl, out output.Writer) *Sensors {
+	return &Sensors{validator: validator, activity: activity, rateLimiter: rateLimiter, control: control, out: out, nowFn: time.Now}
+}
+
+// List returns the inventory sorted by sensor ID.
+func (s *Sensors) List() []SensorInfo {
+	now := s.nowFn()
+	byID := make(map[string]*SensorInfo)
+	get := func(id string) *SensorInfo {
+		if info := byID[id]; info != nil {
+			return info
+		}
+		info := &SensorInfo{SensorID: id, State: ingest.SensorActive}
+		info.RateLimitUsed, info.RateLimitRPS = s.rateLimiter.State(id)
+		byID[id] = info
+		return info
+	}
+	for _, id := range s.validator.Sensors() {
+		get(id).Configured = true
+	}
+	for _, st := range s.activity.Snapshot() {
+		info := get(st.SensorID)
+		lastSeen := st.LastSeen
+		age := now.Sub(lastSeen).Seconds()
+		info.LastSeen, info.LastSeenAgeSeconds = &lastSeen, &age
+		info.Batches, info.Events = st.Batches, st.Events
+	}
+	for _, st := range s.control.Snapshot() {
+		info := get(st.SensorID)
+		info.State, info.StateReason, info.StateUntil = st.State, st.Reason, st.Until
+	}
+	for id, n := range output.OutboxEventsBySensor(s.out) {
+		if id != "" {
+			get(id).OutboxEvents = n
+		}
+	}
+	list := make([]SensorInfo, 0, len(byID))
+	for _, info := range byID {
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SensorID < list[j].SensorID })
+	return list
+}
+
+// ServeHTTP writes {"sensors": [...], "outbox_unattributed_events": n}.
+func (s *Sensors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sensors":                    s.List(),
+		"outbox_unattributed_events": output.OutboxEventsBySensor(s.out)[""],
+	})
+}
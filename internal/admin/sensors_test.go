@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+)
+
+func TestSensors_List(t *testing.T) {
+	validator := auth.NewValidator(map[string]string{"t1": "quiet", "t2": "busy"})
+	activity := ingest.NewSensorActivity()
+	activity.Record("busy", 3)
+	activity.Record("removed", 1)
+	limiter := ratelimit.NewPerSensorLimiter(10)
+	limiter.Allow("busy")
+
+	rec := httptest.NewRecorder()
+	NewSensors(validator, activity, limiter, nil, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sensors", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var resp struct {
+		Sensors []SensorInfo `json:"sensors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Sensors) != 3 {
+		t.Fatalf("sensors = %+v, want busy, quiet, removed", resp.Sensors)
+	}
+	busy, quiet, removed := resp.Sensors[0], resp.Sensors[1], resp.Sensors[2]
+	if busy.SensorID != "busy" || !busy.Configured || busy.Events != 3 || busy.LastSeen == nil || busy.RateLimitRPS != 10 || busy.RateLimitUsed != 1 {
+		t.Errorf("busy = %+v", busy)
+	}
+	if quiet.SensorID != "quiet" || !quiet.Configured || quiet.LastSeen != nil || quiet.Events != 0 {
+		t.Errorf("quiet = %+v", quiet)
+	}
+	if removed.SensorID != "removed" || removed.Configured || removed.Events != 1 {
+		t.Errorf("removed = %+v", removed)
+	}
+}
@@ -0,0 +1,159 @@
+// Package alert posts operational alerts (sensor silent, outbox filling up, output down) to a webhook
+// such as a Slack incoming webhook, so small deployments need no Prometheus/Alertmanager stack.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Condition is one alert that is currently firing. Key identifies it across evaluations
+// (e.g. "sensor_silent:spip-01") and is used for dedup.
+type Condition struct {
+	Key     string
+	Message string
+}
+
+// Check returns the conditions firing at now.
+type Check func(now time.Time) []Condition
+
+// Notification is the JSON body posted to the webhook. Text makes it render in Slack; the other
+// fields are for generic receivers.
+type Notification struct {
+	Text   string    `json:"text"`
+	Alert  string    `json:"alert"`
+	Status string    `json:"status"` // firing or resolved
+	Since  time.Time `json:"since"`
+}
+
+type activeAlert struct {
+	message  string
+	since    time.Time
+	notified time.Time
+}
+
+// Manager evaluates checks periodically and notifies the webhook when a condition starts firing,
+// again every repeat interval while it keeps firing, and once when it resolves.
+type Manager struct {
+	webhook *Webhook
+	repeat  time.Duration
+	checks  []Check
+	log     zerolog.Logger
+	nowFn   func() time.Time
+
+	mu     sync.Mutex
+	active map[string]*activeAlert
+}
+
+// NewManager creates a manager posting to webhookURL. repeat is the cooldown before a still-firing
+// alert is sent again (default 1h; negative never repeats).
+func NewManager(webhookURL string, repeat time.Duration, checks []Check, log zerolog.Logger) *Manager {
+	if repeat == 0 {
+		repeat = time.Hour
+	}
+	return &Manager{
+		webhook: NewWebhook(webhookURL),
+		repeat:  repeat,
+		checks:  checks,
+		log:     log,
+		nowFn:   time.Now,
+		active:  make(map[string]*activeAlert),
+	}
+}
+
+// Evaluate runs every check once and sends the resulting notifications.
+func (m *Manager) Evaluate(ctx context.Context) {
+	now := m.nowFn()
+	firing := make(map[string]string)
+	for _, check := range m.checks {
+		for _, c := range check(now) {
+			firing[c.Key] = c.Message
+		}
+	}
+
+	var send []Notification
+	m.mu.Lock()
+	for key, msg := range firing {
+		a := m.active[key]
+		if a == nil {
+			a = &activeAlert{since: now}
+			m.active[key] = a
+		}
+		a.message = msg
+		if a.notified.IsZero() || (m.repeat > 0 && now.Sub(a.notified) >= m.repeat) {
+			a.notified = now
+			send = append(send, Notification{Text: "[FIRING] " + msg, Alert: key, Status: "firing", Since: a.since})
+		}
+	}
+	for key, a := range m.active {
+		if _, ok := firing[key]; !ok {
+			delete(m.active, key)
+			send = append(send, Notification{Text: "[RESOLVED] " + a.message, Alert: key, Status: "resolved", Since: a.since})
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(send, func(i, j int) bool { return send[i].Alert < send[j].Alert })
+	for _, n := range send {
+		if err := m.webhook.Send(ctx, n); err != nil {
+			m.log.Warn().Err(err).Str("alert", n.Alert).Msg("alert webhook")
+		}
+	}
+}
+
+// Run evaluates every interval until ctx is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Evaluate(ctx)
+		}
+	}
+}
+
+// Webhook posts JSON payloads to a URL.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a webhook client with a 10s timeout per request.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Send posts v as JSON; any non-2xx response is an error.
+func (w *Webhook) Send(ctx context.Context, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/rs/zerolog"
+)
+
+func TestManager_DedupRepeatResolve(t *testing.T) {
+	var mu sync.Mutex
+	var got []Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		_ = json.NewDecoder(r.Body).Decode(&n)
+		mu.Lock()
+		got = append(got, n)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	firing := true
+	check := func(time.Time) []Condition {
+		if !firing {
+			return nil
+		}
+		return []Condition{{Key: "output_down", Message: "output down"}}
+	}
+	now := time.Unix(1000, 0)
+	m := NewManager(srv.URL, 10*time.Minute, []Check{check}, zerolog.Nop())
+	m.nowFn = func() time.Time { return now }
+
+	m.Evaluate(context.Background()) // fires
+	now = now.Add(time.Minute)
+	m.Evaluate(context.Background()) // within cooldown: suppressed
+	now = now.Add(10 * time.Minute)
+	m.Evaluate(context.Background()) // cooldown over: repeated
+	firing = false
+	m.Evaluate(context.Background()) // resolved
+	m.Evaluate(context.Background()) // nothing
+
+	if len(got) != 3 {
+		t.Fatalf("notifications = %+v, want firing, firing, resolved", got)
+	}
+	if got[0].Status != "firing" || got[1].Status != "firing" || got[2].Status != "resolved" {
+		t.Errorf("statuses = %s, %s, %s", got[0].Status, got[1].Status, got[2].Status)
+	}
+	if got[2].Text != "[RESOLVED] output down" || !got[2].Since.Equal(time.Unix(1000, 0)) {
+		t.Errorf("resolved = %+v", got[2])
+	}
+}
+
+func TestSensorSilent(t *testing.T) {
+	start := time.Unix(0, 0)
+	activity := ingest.NewSensorActivity()
+	activity.Record("active", 1)
+	seen := activity.Snapshot()[0].LastSeen
+	known := func() []string { return []string{"active", "never"} }
+	check := SensorSilent(known, activity, start, 10*time.Minute)
+
+	conds := check(seen.Add(time.Minute))
+	if len(conds) != 1 || conds[0].Key != "sensor_silent:never" {
+		t.Errorf("conditions = %+v, want only the sensor that never sent", conds)
+	}
+	if conds := check(seen.Add(11 * time.Minute)); len(conds) != 2 {
+		t.Errorf("conditions = %+v, want both sensors silent", conds)
+	}
+}
@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/output"
+)
+
+// SensorSilent fires for every sensor that has not delivered a batch for longer than after.
+// Configured sensors (from known) that never delivered count from start, so a sensor that is dead
+// at startup is reported too.
+func SensorSilent(known func() []string, activity *ingest.SensorActivity, start time.Time, after time.Duration) Check {
+	return func(now time.Time) []Condition {
+		lastSeen := make(map[string]time.Time)
+		if known != nil {
+			for _, id := range known() {
+				lastSeen[id] = time.Time{}
+			}
+		}
+		for _, st := range activity.Snapshot() {
+			lastSeen[st.SensorID] = st.LastSeen
+		}
+		var out []Condition
+		for id, seen := range lastSeen {
+			if seen.IsZero() {
+				if now.Sub(start) > after {
+					out = append(out, Condition{
+						Key:     "sensor_silent:" + id,
+						Message: fmt.Sprintf("sensor %s has sent no events since Loom started %s ago", id, now.Sub(start).Round(time.Minute)),
+					})
+				}
+				continue
+			}
+			if age := now.Sub(seen); age > after {
+				out = append(out, Condition{
+					Key:     "sensor_silent:" + id,
+					Message: fmt.Sprintf("sensor %s has sent no events for %s", id, age.Round(time.Minute)),
+				})
+			}
+		}
+		return out
+	}
+}
+
+// OutboxAbove fires while w's outbox holds more than maxBytes.
+func OutboxAbove(w output.Writer, maxBytes int64) Check {
+	return func(time.Time) []Condition {
+		st := output.StatsOf(w)
+		if st.OutboxBytes <= maxBytes {
+			return nil
+		}
+		return []Condition{{
+			Key:     "outbox_above_threshold",
+			Message: fmt.Sprintf("outbox holds %d bytes in %d files (threshold %d bytes)", st.OutboxBytes, st.OutboxFiles, maxBytes),
+		}}
+	}
+}
+
+// OutputDown fires once the output has failed its health checks for longer than after.
+func OutputDown(h *output.HealthMonitor, after time.Duration) Check {
+	return func(now time.Time) []Condition {
+		since := h.UnhealthySince()
+		if since.IsZero() || now.Sub(since) <= after {
+			return nil
+		}
+		msg := fmt.Sprintf("output has been down for %s", now.Sub(since).Round(time.Minute))
+		if err := h.Err(); err != nil {
+			msg += ": " + err.Error()
+		}
+		return []Condition{{Key: "output_down", Message: msg}}
+	}
+}
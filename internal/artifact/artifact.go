@@ -0,0 +1,207 @@
+// Package artifact stores files sensors capture next to their events (malware samples, pcaps):
+// uploads are hashed, deduplicated by SHA-256 and kept in a directory or an S3 bucket, and the
+// upload's reference is added to the event it belongs to.
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Field is where the artifacts uploaded for an event are listed.
+const Field = "loom.artifacts"
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Store keeps artifacts by the hex SHA-256 of their content.
+type Store interface {
+	// Has reports whether the artifact is already stored.
+	Has(ctx context.Context, sha256 string) (bool, error)
+	// Put stores the size bytes read from r as the artifact sha256.
+	Put(ctx context.Context, sha256 string, r io.Reader, size int64) error
+	// Reference returns where the artifact is kept: a file path or an s3:// URL.
+	Reference(sha256 string) string
+}
+
+// DirStore keeps artifacts in a directory, as <dir>/<first two hex digits>/<sha256>.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a DirStore for dir, creating it if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("artifacts: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// Reference implements Store.
+func (s *DirStore) Reference(sha256 string) string {
+	return filepath.Join(s.dir, sha256[:2], sha256)
+}
+
+// Has implements Store.
+func (s *DirStore) Has(_ context.Context, sha256 string) (bool, error) {
+	_, err := os.Stat(s.Reference(sha256))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put implements Store. The file is written under a temporary name and renamed, so a reader never
+// sees a partial artifact.
+func (s *DirStore) Put(_ context.Context, sha256 string, r io.Reader, size int64) error {
+	path := s.Reference(sha256)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("wrote %d of %d bytes", n, size)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Ref describes one stored artifact.
+type Ref struct {
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mime_type,omitempty"`
+	Reference string `json:"reference"`
+}
+
+func (r Ref) fields() map[string]interface{} {
+	m := map[string]interface{}{"sha256": r.SHA256, "size": r.Size, "reference": r.Reference}
+	if r.MimeType != "" {
+		m["mime_type"] = r.MimeType
+	}
+	return m
+}
+
+// Links remembers which event recent uploads belong to until that event is ingested. Entries
+// expire after a TTL and the table is bounded. An event that arrives before its upload is not
+// linked: it has been written by then, so sensors must upload first.
+type Links struct {
+	ttl time.Duration
+	max int
+
+	mu      sync.Mutex
+	byEvent map[linkKey]*link
+}
+
+type linkKey struct{ sensorID, eventID string }
+
+type link struct {
+	refs  []Ref
+	added time.Time
+}
+
+// NewLinks returns Links holding at most max events (default 10000) for ttl (default 10 minutes).
+func NewLinks(ttl time.Duration, max int) *Links {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	if max <= 0 {
+		max = 10000
+	}
+	return &Links{ttl: ttl, max: max, byEvent: make(map[linkKey]*link)}
+}
+
+// Add records that the sensor uploaded ref for the event with event.id eventID.
+func (l *Links) Add(sensorID, eventID string, ref Ref) {
+	if l == nil || eventID == "" {
+		return
+	}
+	now := time.Now()
+	k := linkKey{sensorID, eventID}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e := l.byEvent[k]; e != nil {
+		for _, r := range e.refs {
+			if r.SHA256 == ref.SHA256 {
+				return
+			}
+		}
+		e.refs = append(e.refs, ref)
+		return
+	}
+	if len(l.byEvent) >= l.max {
+		l.evict(now)
+	}
+	l.byEvent[k] = &link{refs: []Ref{ref}, added: now}
+}
+
+// evict drops expired entries, and the oldest one if none has expired. Called with mu held.
+func (l *Links) evict(now time.Time) {
+	var oldest linkKey
+	var oldestAdded time.Time
+	for k, e := range l.byEvent {
+		if now.Sub(e.added) > l.ttl {
+			delete(l.byEvent, k)
+		} else if oldestAdded.IsZero() || e.added.Before(oldestAdded) {
+			oldest, oldestAdded = k, e.added
+		}
+	}
+	if len(l.byEvent) >= l.max {
+		delete(l.byEvent, oldest)
+	}
+}
+
+// Apply lists the artifacts the sensor uploaded for ev's event.id in loom.artifacts and forgets
+// them. Events without a matching upload are left alone.
+func (l *Links) Apply(sensorID string, ev event.Event) {
+	if l == nil || ev == nil {
+		return
+	}
+	id := ev.GetString("event.id")
+	if id == "" {
+		return
+	}
+	k := linkKey{sensorID, id}
+	l.mu.Lock()
+	e := l.byEvent[k]
+	delete(l.byEvent, k)
+	l.mu.Unlock()
+	if e == nil || time.Since(e.added) > l.ttl {
+		return
+	}
+	list := make([]interface{}, len(e.refs))
+	for i, r := range e.refs {
+		list[i] = r.fields()
+	}
+	ev.Set(Field, list)
+}
+
+// Len returns the number of events with pending uploads.
+func (l *Links) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.byEvent)
+}
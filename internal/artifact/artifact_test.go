@@ -0,0 +1,128 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/rs/zerolog"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	store, err := NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		Validator: auth.NewValidator(map[string]string{"test-token": "spip-001"}),
+		Store:     store,
+		Links:     NewLinks(0, 0),
+		MaxBytes:  64,
+		TempDir:   t.TempDir(),
+		Log:       zerolog.Nop(),
+	}
+}
+
+func upload(h *Handler, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/artifacts", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer test-token")
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler_StoresAndDeduplicates(t *testing.T) {
+	h := newTestHandler(t)
+	sum := sha256.Sum256([]byte("MZ\x90\x00sample"))
+	want := hex.EncodeToString(sum[:])
+
+	w := upload(h, "MZ\x90\x00sample", map[string]string{"Content-Type": "application/x-dosexec", EventIDHeader: "ev-1"})
+	var resp UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if resp.SHA256 != want || resp.Size != 10 || resp.Duplicate || resp.MimeType != "application/x-dosexec" {
+		t.Errorf("response = %+v", resp)
+	}
+	if b, err := os.ReadFile(resp.Reference); err != nil || string(b) != "MZ\x90\x00sample" {
+		t.Errorf("stored %q, %v", b, err)
+	}
+
+	w = upload(h, "MZ\x90\x00sample", map[string]string{SHA256Header: strings.ToUpper(want)})
+	resp = UploadResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || !resp.Duplicate {
+		t.Errorf("second upload: status %d, %+v", w.Code, resp)
+	}
+
+	ev := event.Event{"event": map[string]interface{}{"id": "ev-1"}}
+	h.Links.Apply("spip-001", ev)
+	list, _ := ev.Get(Field).([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["sha256"] != want {
+		t.Errorf("%s = %v", Field, ev.Get(Field))
+	}
+	if h.Links.Len() != 0 {
+		t.Error("link kept after Apply")
+	}
+}
+
+func TestHandler_Rejects(t *testing.T) {
+	h := newTestHandler(t)
+	for _, tc := range []struct {
+		body   string
+		header map[string]string
+		status int
+		code   string
+	}{
+		{"x", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized, "unauthorized"},
+		{"x", map[string]string{"X-Spip-ID": "spip-002"}, http.StatusUnauthorized, "unauthorized"},
+		{strings.Repeat("x", 65), nil, http.StatusRequestEntityTooLarge, "artifact_too_large"},
+		{"", nil, http.StatusBadRequest, "invalid_request"},
+		{"x", map[string]string{SHA256Header: strings.Repeat("0", 64)}, http.StatusBadRequest, "hash_mismatch"},
+	} {
+		w := upload(h, tc.body, tc.header)
+		var body struct{ Code string }
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tc.status || body.Code != tc.code {
+			t.Errorf("%v: status %d, code %q; want %d %q", tc.header, w.Code, body.Code, tc.status, tc.code)
+		}
+	}
+}
+
+func TestLinks_ScopedAndBounded(t *testing.T) {
+	l := NewLinks(time.Minute, 2)
+	l.Add("spip-001", "a", Ref{SHA256: "1"})
+	l.Add("spip-001", "a", Ref{SHA256: "1"})
+	l.Add("spip-001", "a", Ref{SHA256: "2"})
+
+	// Another sensor's event with the same ID gets nothing
+	other := event.Event{"event": map[string]interface{}{"id": "a"}}
+	l.Apply("spip-002", other)
+	if other.Get(Field) != nil {
+		t.Errorf("linked across sensors: %v", other)
+	}
+	ev := event.Event{"event": map[string]interface{}{"id": "a"}}
+	l.Apply("spip-001", ev)
+	if list, _ := ev.Get(Field).([]interface{}); len(list) != 2 {
+		t.Errorf("%s = %v, want both uploads once", Field, ev.Get(Field))
+	}
+
+	l.Add("spip-001", "b", Ref{SHA256: "1"})
+	l.Add("spip-001", "c", Ref{SHA256: "1"})
+	l.Add("spip-001", "d", Ref{SHA256: "1"})
+	if l.Len() != 2 {
+		t.Errorf("Len = %d, want 2", l.Len())
+	}
+}
@@ -0,0 +1,154 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/rs/zerolog"
+)
+
+// Upload headers. X-Event-ID names the event.id of the event the artifact belongs to, which the
+// sensor must send after the upload (see Links); X-Artifact-SHA256, if sent, must match the hash
+// of the body.
+const (
+	EventIDHeader = "X-Event-ID"
+	SHA256Header  = "X-Artifact-SHA256"
+)
+
+// Handler accepts artifact uploads: POST with the raw file as the body and the sensor's bearer
+// token. It answers 201 with an UploadResponse for a new artifact and 200 for one already stored.
+type Handler struct {
+	Validator *auth.Validator
+	Store     Store
+	Links     *Links // optional: link uploads with X-Event-ID to their event
+	MaxBytes  int64  // uploads above this get 413
+	TempDir   string // where uploads are spooled while hashed; default os.TempDir()
+	Log       zerolog.Logger
+}
+
+// UploadResponse is the body of a successful upload.
+type UploadResponse struct {
+	Ref
+	Duplicate bool `json:"duplicate"` // the artifact was already stored
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ingest.RequestID(r.Context()) == "" {
+		r = r.WithContext(ingest.WithRequestID(w, r))
+	}
+	log := h.Log.With().Str("request_id", ingest.RequestID(r.Context())).Logger()
+	if r.Method != http.MethodPost {
+		ingest.WriteError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "use POST")
+		return
+	}
+	sensorID, msg := h.authenticate(r)
+	if sensorID == "" {
+		log.Debug().Str("remote_ip", r.RemoteAddr).Msg("artifact upload: " + msg + " (401)")
+		ingest.WriteError(w, r, http.StatusUnauthorized, "unauthorized", msg)
+		return
+	}
+	log = log.With().Str("sensor_id", sensorID).Logger()
+	if r.ContentLength > h.MaxBytes {
+		ingest.WriteError(w, r, http.StatusRequestEntityTooLarge, "artifact_too_large", "artifact exceeds the upload size limit")
+		return
+	}
+	want := strings.ToLower(r.Header.Get(SHA256Header))
+	if want != "" && !sha256Hex.MatchString(want) {
+		ingest.WriteError(w, r, http.StatusBadRequest, "invalid_request", SHA256Header+" is not a hex SHA-256")
+		return
+	}
+
+	// Spool the body to disk while hashing it: the store is keyed by the hash, known only at the end
+	f, err := os.CreateTemp(h.TempDir, "loom-artifact-*")
+	if err != nil {
+		log.Error().Err(err).Msg("artifact upload: spool file")
+		ingest.WriteError(w, r, http.StatusInternalServerError, "internal_error", "storing the artifact failed")
+		return
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), http.MaxBytesReader(w, r.Body, h.MaxBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			ingest.WriteError(w, r, http.StatusRequestEntityTooLarge, "artifact_too_large", "artifact exceeds the upload size limit")
+			return
+		}
+		log.Debug().Err(err).Msg("artifact upload: reading body")
+		ingest.WriteError(w, r, http.StatusBadRequest, "invalid_request", "reading the upload failed")
+		return
+	}
+	if size == 0 {
+		ingest.WriteError(w, r, http.StatusBadRequest, "invalid_request", "empty artifact")
+		return
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if want != "" && want != sum {
+		ingest.WriteError(w, r, http.StatusBadRequest, "hash_mismatch", "body does not match "+SHA256Header)
+		return
+	}
+
+	resp := UploadResponse{Ref: Ref{SHA256: sum, Size: size, MimeType: mimeType(r), Reference: h.Store.Reference(sum)}}
+	resp.Duplicate, err = h.Store.Has(r.Context(), sum)
+	if err == nil && !resp.Duplicate {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			err = h.Store.Put(r.Context(), sum, f, size)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str("sha256", sum).Msg("artifact upload: store")
+		ingest.WriteError(w, r, http.StatusInternalServerError, "internal_error", "storing the artifact failed")
+		return
+	}
+	h.Links.Add(sensorID, r.Header.Get(EventIDHeader), resp.Ref)
+	log.Info().Str("sha256", sum).Int64("size", size).Bool("duplicate", resp.Duplicate).
+		Str("event_id", r.Header.Get(EventIDHeader)).Msg("artifact uploaded")
+
+	status := http.StatusCreated
+	if resp.Duplicate {
+		status = http.StatusOK
+	}
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// authenticate returns the sensor of the request's bearer token, or "" and why not. As for ingest,
+// an X-Spip-ID header must match the token's sensor.
+func (h *Handler) authenticate(r *http.Request) (sensorID, problem string) {
+	authz := r.Header.Get("Authorization")
+	if len(authz) < 7 || !strings.EqualFold(authz[:7], "bearer ") {
+		return "", "missing bearer token"
+	}
+	sensorID = h.Validator.Validate(strings.TrimSpace(authz[7:]))
+	if sensorID == "" {
+		return "", "unknown token"
+	}
+	if id := r.Header.Get("X-Spip-ID"); id != "" && id != sensorID {
+		return "", "X-Spip-ID does not match the token's sensor"
+	}
+	return sensorID, ""
+}
+
+// mimeType returns the upload's Content-Type without parameters, or "" for the generic ones.
+func mimeType(r *http.Request) string {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || t == "application/octet-stream" {
+		return ""
+	}
+	return t
+}
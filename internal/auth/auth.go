@@ -1,10 +1,19 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
 	"sync"
 )
 
+// HashPrefix marks a configured token that is stored as its SHA-256 hash (see HashToken).
+const HashPrefix = "sha256:"
+
 // Validator validates Bearer tokens and returns the single sensor ID (X-Spip-ID) for that token.
 // Uses constant-time comparison; one token per sensor.
 type Validator struct {
@@ -13,7 +22,8 @@ type Validator struct {
 }
 
 type tokenEntry struct {
-	token    []byte
+	token    []byte // the token, or HashToken(token) when hashed
+	hashed   bool
 	sensorID string
 }
 
@@ -25,10 +35,16 @@ func NewValidator(tokenToSensor map[string]string) *Validator {
 }
 
 // Update replaces the token map (e.g. after config reload). Caller must not pass nil.
+// Keys starting with HashPrefix, in any letter case, are hashes; a presented token matches them
+// by its hash.
 func (v *Validator) Update(tokenToSensor map[string]string) {
 	entries := make([]tokenEntry, 0, len(tokenToSensor))
 	for token, sensorID := range tokenToSensor {
-		entries = append(entries, tokenEntry{token: []byte(token), sensorID: sensorID})
+		hashed := IsHashed(token)
+		if hashed {
+			token = strings.ToLower(token)
+		}
+		entries = append(entries, tokenEntry{token: []byte(token), hashed: hashed, sensorID: sensorID})
 	}
 	v.mu.Lock()
 	v.tokens = entries
@@ -42,12 +58,57 @@ func (v *Validator) Validate(token string) (sensorID string) {
 		return ""
 	}
 	b := []byte(token)
+	var hashed []byte
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 	for _, e := range v.tokens {
-		if subtle.ConstantTimeCompare(e.token, b) == 1 {
+		candidate := b
+		if e.hashed {
+			if hashed == nil {
+				hashed = []byte(HashToken(token))
+			}
+			candidate = hashed
+		}
+		if subtle.ConstantTimeCompare(e.token, candidate) == 1 {
 			return e.sensorID
 		}
 	}
 	return ""
 }
+
+// IsHashed reports whether a configured token is stored as its hash: it starts with HashPrefix in
+// any letter case ("SHA256:" too, as config hardening accepts it).
+func IsHashed(token string) bool {
+	return len(token) >= len(HashPrefix) && strings.EqualFold(token[:len(HashPrefix)], HashPrefix)
+}
+
+// HashToken returns the form of token to store instead of the token itself ("sha256:<hex>").
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return HashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateToken returns a new random token (256 bits, URL-safe base64).
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sensors returns the configured sensor IDs, sorted.
+func (v *Validator) Sensors() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	seen := make(map[string]bool, len(v.tokens))
+	ids := make([]string, 0, len(v.tokens))
+	for _, e := range v.tokens {
+		if !seen[e.sensorID] {
+			seen[e.sensorID] = true
+			ids = append(ids, e.sensorID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
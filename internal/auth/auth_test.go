@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -46,3 +47,49 @@ func TestValidator_Update(t *testing.T) {
 		t.Error("new token should work after Update")
 	}
 }
+
+func TestValidator_Sensors(t *testing.T) {
+	v := NewValidator(map[string]string{"t1": "sensor-b", "t2": "sensor-a", "t3": "sensor-b"})
+	got := v.Sensors()
+	if len(got) != 2 || got[0] != "sensor-a" || got[1] != "sensor-b" {
+		t.Errorf("Sensors() = %v", got)
+	}
+}
+
+func TestValidator_HashedTokens(t *testing.T) {
+	v := NewValidator(map[string]string{
+		HashToken("hashed-secret"): "sensor-h",
+		"plain-secret":             "sensor-p",
+	})
+	if got := v.Validate("hashed-secret"); got != "sensor-h" {
+		t.Errorf("hashed token: got %q", got)
+	}
+	if got := v.Validate(HashToken("hashed-secret")); got != "" {
+		t.Error("the hash itself must not be accepted as a token")
+	}
+	if got := v.Validate("plain-secret"); got != "sensor-p" {
+		t.Errorf("plain token: got %q", got)
+	}
+}
+
+func TestValidator_HashedTokenPrefixCase(t *testing.T) {
+	stored := "SHA256:" + strings.ToUpper(strings.TrimPrefix(HashToken("hashed-secret"), HashPrefix))
+	v := NewValidator(map[string]string{stored: "sensor-h"})
+	if got := v.Validate("hashed-secret"); got != "sensor-h" {
+		t.Errorf("token of an upper-case hash: got %q", got)
+	}
+	if got := v.Validate(stored); got != "" {
+		t.Error("an upper-case hash must not be accepted as a token")
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	a, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateToken()
+	if len(a) != 43 || a == b {
+		t.Errorf("tokens %q, %q: want distinct 43-char tokens", a, b)
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Token files (auth.token_file) hold one "token,sensor_id" per line; the token may be a HashToken
+// hash. Blank lines and lines starting with # are kept as they are when the file is rewritten.
+
+// ErrSensorExists is returned by AddToTokenFile when the sensor already has a token.
+var ErrSensorExists = errors.New("sensor already has a token")
+
+// AddToTokenFile adds a line for sensorID with token (a token or a HashToken hash), creating the file
+// if needed. An existing entry for the sensor is replaced when replace is set and is an error otherwise.
+func AddToTokenFile(path, sensorID, token string, replace bool) error {
+	if err := validSensorID(sensorID); err != nil {
+		return err
+	}
+	return rewriteTokenFile(path, func(lines []string) ([]string, error) {
+		kept := lines[:0:0]
+		for _, line := range lines {
+			if id, ok := lineSensor(line); ok && id == sensorID {
+				if !replace {
+					return nil, fmt.Errorf("%s: %w", sensorID, ErrSensorExists)
+				}
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return append(kept, token+","+sensorID), nil
+	})
+}
+
+// RevokeFromTokenFile removes the entries for sensorID and returns how many were removed.
+func RevokeFromTokenFile(path, sensorID string) (int, error) {
+	removed := 0
+	err := rewriteTokenFile(path, func(lines []string) ([]string, error) {
+		kept := lines[:0:0]
+		for _, line := range lines {
+			if id, ok := lineSensor(line); ok && id == sensorID {
+				removed++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return kept, nil
+	})
+	return removed, err
+}
+
+// TokenFileSensors returns the sensor IDs in the token file, sorted, and whether each token is hashed.
+func TokenFileSensors(path string) (map[string]bool, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashed := make(map[string]bool)
+	var ids []string
+	for _, line := range strings.Split(string(data), "\n") {
+		id, ok := lineSensor(line)
+		if !ok {
+			continue
+		}
+		if _, dup := hashed[id]; !dup {
+			ids = append(ids, id)
+		}
+		hashed[id] = IsHashed(strings.TrimSpace(line))
+	}
+	sort.Strings(ids)
+	return hashed, ids, nil
+}
+
+// lineSensor returns the sensor ID of a "token,sensor_id" line.
+func lineSensor(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	token, sensorID, ok := strings.Cut(line, ",")
+	sensorID = strings.TrimSpace(sensorID)
+	if !ok || strings.TrimSpace(token) == "" || sensorID == "" {
+		return "", false
+	}
+	return sensorID, true
+}
+
+func validSensorID(id string) error {
+	if id == "" || strings.ContainsAny(id, ", \t\r\n#") {
+		return fmt.Errorf("invalid sensor ID %q", id)
+	}
+	return nil
+}
+
+// rewriteTokenFile applies edit to the file's lines and replaces the file atomically (write to a
+// temporary file in the same directory, then rename), keeping its permissions (0600 when new).
+func rewriteTokenFile(path string, edit func(lines []string) ([]string, error)) error {
+	var lines []string
+	mode := fs.FileMode(0o600)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if len(data) == 0 {
+			lines = nil
+		}
+		if st, err := os.Stat(path); err == nil {
+			mode = st.Mode().Perm()
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	lines, err = edit(lines)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenFile_AddRevokeList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# managed by loom token\nold,spip-01\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToTokenFile(path, "spip-01", "new", false); !errors.Is(err, ErrSensorExists) {
+		t.Fatalf("add existing: err = %v", err)
+	}
+	if err := AddToTokenFile(path, "spip-01", "new", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToTokenFile(path, "spip-02", HashToken("x"), false); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	want := "# managed by loom token\nnew,spip-01\n" + HashToken("x") + ",spip-02\n"
+	if string(data) != want {
+		t.Errorf("file = %q, want %q", data, want)
+	}
+	if st, _ := os.Stat(path); st.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640 kept", st.Mode().Perm())
+	}
+
+	if err := AddToTokenFile(path, "spip-03", "SHA256:"+HashToken("y")[len(HashPrefix):], false); err != nil {
+		t.Fatal(err)
+	}
+	hashed, ids, err := TokenFileSensors(path)
+	if err != nil || len(ids) != 3 || hashed["spip-01"] || !hashed["spip-02"] || !hashed["spip-03"] {
+		t.Errorf("sensors = %v %v, err %v", ids, hashed, err)
+	}
+
+	n, err := RevokeFromTokenFile(path, "spip-01")
+	if err != nil || n != 1 {
+		t.Fatalf("revoke: n=%d err=%v", n, err)
+	}
+	if n, _ := RevokeFromTokenFile(path, "spip-01"); n != 0 {
+		t.Errorf("second revoke removed %d", n)
+	}
+}
+
+func TestTokenFile_NewFileAndInvalidSensor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := AddToTokenFile(path, "spip-01", "tok", false); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := os.Stat(path); st.Mode().Perm() != 0o600 {
+		t.Errorf("new file mode = %v, want 0600", st.Mode().Perm())
+	}
+	if err := AddToTokenFile(path, "bad,id", "tok", false); err == nil {
+		t.Error("expected error for sensor ID with a comma")
+	}
+}
@@ -0,0 +1,356 @@
+// Package backend installs what Loom's outputs expect in a fresh Elasticsearch cluster or ClickHouse
+// server: an ECS-aware index template, ILM policy and write alias, or a database, events table and
+// summary materialized views. Every step is idempotent, so it is safe to run again.
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/proxy"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ClickHouseConfig locates the table written by the ClickHouse output.
+type ClickHouseConfig struct {
+	URL      string
+	User     string
+	Password string
+	Database string // default "default"
+	Table    string // default "loom_events"
+	Views    bool   // also create the hourly summary and top-N tables and their materialized views
+	Proxy    string // see the proxy package; "" honors HTTPS_PROXY
+}
+
+// topN are the per-hour top-N tables created with Views: <table>_hourly_<suffix>, counting events
+// by one column of the events table.
+var topN = []struct{ suffix, column, typ string }{
+	{"sources", "source_ip", "String"},
+	{"ports", "destination_port", "UInt16"},
+	{"countries", "source_country", "LowCardinality(String)"},
+}
+
+func (c *ClickHouseConfig) defaults() error {
+	if c.Database == "" {
+		c.Database = "default"
+	}
+	if c.Table == "" {
+		c.Table = "loom_events"
+	}
+	if !identifier.MatchString(c.Database) || !identifier.MatchString(c.Table) {
+		return fmt.Errorf("clickhouse: invalid database or table name %q.%q", c.Database, c.Table)
+	}
+	return nil
+}
+
+// ClickHouseStatements returns the DDL for cfg, in order. The events table keeps the full event in
+// the event column (what the writer inserts) and derives a few columns from it for partitioning,
+// ordering and the summary views; use timestamp as output.retention.timestamp_expression. With
+// Views, the per-hour tables are SummingMergeTrees fed by materialized views as rows are inserted,
+// so top-N queries over them (sum(events) ... GROUP BY) do not scan the events table. A table
+// created by an earlier version gets the columns the views need added.
+func ClickHouseStatements(cfg ClickHouseConfig) ([]string, error) {
+	if err := cfg.defaults(); err != nil {
+		return nil, err
+	}
+	t := cfg.Database + "." + cfg.Table
+	stmts := []string{
+		"CREATE DATABASE IF NOT EXISTS " + cfg.Database,
+		`CREATE TABLE IF NOT EXISTS ` + t + ` (
+    event String,
+    timestamp DateTime64(3) MATERIALIZED parseDateTime64BestEffortOrZero(JSONExtractString(event, '@timestamp'), 3),
+    observer_id LowCardinality(String) MATERIALIZED JSONExtractString(event, 'observer', 'id'),
+    source_ip String MATERIALIZED JSONExtractString(event, 'source', 'ip'),
+    destination_port UInt16 MATERIALIZED toUInt16(JSONExtractUInt(event, 'destination', 'port')),
+    source_country LowCardinality(String) MATERIALIZED JSONExtractString(event, 'source', 'geo', 'country_iso_code')
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (observer_id, timestamp)`,
+	}
+	if cfg.Views {
+		stmts = append(stmts,
+			`CREATE TABLE IF NOT EXISTS `+t+`_hourly (
+    hour DateTime,
+    observer_id LowCardinality(String),
+    source_ip String,
+    destination_port UInt16,
+    events UInt64
+) ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY (hour, observer_id, source_ip, destination_port)`,
+			`CREATE MATERIALIZED VIEW IF NOT EXISTS `+t+`_hourly_mv TO `+t+`_hourly AS
+SELECT toStartOfHour(timestamp) AS hour, observer_id, source_ip, destination_port, count() AS events
+FROM `+t+`
+GROUP BY hour, observer_id, source_ip, destination_port`,
+			`ALTER TABLE `+t+` ADD COLUMN IF NOT EXISTS source_country LowCardinality(String) MATERIALIZED JSONExtractString(event, 'source', 'geo', 'country_iso_code')`,
+		)
+		for _, v := range topN {
+			h := t + "_hourly_" + v.suffix
+			stmts = append(stmts,
+				`CREATE TABLE IF NOT EXISTS `+h+` (
+    hour DateTime,
+    `+v.column+` `+v.typ+`,
+    events UInt64
+) ENGINE = SummingMergeTree
+PARTITION BY toYYYYMM(hour)
+ORDER BY (hour, `+v.column+`)`,
+				`CREATE MATERIALIZED VIEW IF NOT EXISTS `+h+`_mv TO `+h+` AS
+SELECT toStartOfHour(timestamp) AS hour, `+v.column+`, count() AS events
+FROM `+t+`
+GROUP BY hour, `+v.column,
+			)
+		}
+	}
+	return stmts, nil
+}
+
+// InstallClickHouse runs ClickHouseStatements against the server, calling step before each one.
+func InstallClickHouse(ctx context.Context, cfg ClickHouseConfig, step func(desc string)) error {
+	stmts, err := ClickHouseStatements(cfg)
+	if err != nil {
+		return err
+	}
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	for _, stmt := range stmts {
+		if step != nil {
+			step(strings.SplitN(stmt, " (", 2)[0])
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cfg.URL, "/")+"/", strings.NewReader(stmt))
+		if err != nil {
+			return err
+		}
+		if cfg.User != "" || cfg.Password != "" {
+			req.SetBasicAuth(cfg.User, cfg.Password)
+		}
+		if err := check(client.Do(req)); err != nil {
+			return fmt.Errorf("clickhouse: %w", err)
+		}
+	}
+	return nil
+}
+
+// ElasticsearchConfig locates the index written by the Elasticsearch output.
+type ElasticsearchConfig struct {
+	URL   string
+	User  string
+	Pass  string
+	Index string // the write alias the output indexes into; default "loom-events"
+
+	RolloverAge     string // roll over to a new backing index after this; default "30d"
+	DeleteAfterDays int    // delete backing indices this long after rollover; 0 keeps them
+	Proxy           string // see the proxy package; "" honors HTTPS_PROXY
+}
+
+// Request is one call to the Elasticsearch API.
+type Request struct {
+	Method string
+	Path   string
+	Body   interface{}
+}
+
+// ElasticsearchRequests returns the ILM policy and index template for cfg, and the request that
+// creates the first backing index with cfg.Index as its write alias (only sent when cfg.Index does
+// not exist yet).
+func ElasticsearchRequests(cfg ElasticsearchConfig) (policy, template, bootstrap Request) {
+	if cfg.Index == "" {
+		cfg.Index = "loom-events"
+	}
+	if cfg.RolloverAge == "" {
+		cfg.RolloverAge = "30d"
+	}
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"rollover": map[string]interface{}{"max_age": cfg.RolloverAge, "max_primary_shard_size": "50gb"},
+			},
+		},
+	}
+	if cfg.DeleteAfterDays > 0 {
+		phases["delete"] = map[string]interface{}{
+			"min_age": fmt.Sprintf("%dd", cfg.DeleteAfterDays),
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	policy = Request{http.MethodPut, "/_ilm/policy/" + cfg.Index, map[string]interface{}{
+		"policy": map[string]interface{}{"phases": phases},
+	}}
+	template = Request{http.MethodPut, "/_index_template/" + cfg.Index, map[string]interface{}{
+		"index_patterns": []string{cfg.Index + "-*"},
+		"priority":       200,
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index.lifecycle.name":           cfg.Index,
+				"index.lifecycle.rollover_alias": cfg.Index,
+			},
+			"mappings": ecsMappings(),
+		},
+		"_meta": map[string]interface{}{"managed_by": "loom"},
+	}}
+	bootstrap = Request{http.MethodPut, "/" + cfg.Index + "-000001", map[string]interface{}{
+		"aliases": map[string]interface{}{cfg.Index: map[string]interface{}{"is_write_index": true}},
+	}}
+	return policy, template, bootstrap
+}
+
+// ecsMappings maps the ECS fields Loom and its sensors emit; other strings become keywords, as in ECS.
+func ecsMappings() map[string]interface{} {
+	kw := map[string]interface{}{"type": "keyword", "ignore_above": 1024}
+	typ := func(t string) map[string]interface{} { return map[string]interface{}{"type": t} }
+	obj := func(props map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"properties": props}
+	}
+	endpoint := func() map[string]interface{} {
+		return obj(map[string]interface{}{
+			"ip":      typ("ip"),
+			"port":    typ("long"),
+			"domain":  kw,
+			"geo":     obj(map[string]interface{}{"location": typ("geo_point"), "country_iso_code": kw, "city_name": kw}),
+			"as":      obj(map[string]interface{}{"number": typ("long"), "organization": obj(map[string]interface{}{"name": kw})}),
+			"address": kw,
+		})
+	}
+	return map[string]interface{}{
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{"strings_as_keyword": map[string]interface{}{
+				"match_mapping_type": "string",
+				"mapping":            kw,
+			}},
+		},
+		"properties": map[string]interface{}{
+			"@timestamp":  typ("date"),
+			"message":     typ("match_only_text"),
+			"tags":        kw,
+			"labels":      typ("object"),
+			"source":      endpoint(),
+			"destination": endpoint(),
+			"observer": obj(map[string]interface{}{
+				"id":       kw,
+				"hostname": kw,
+				"ip":       typ("ip"),
+				"geo":      obj(map[string]interface{}{"name": kw, "location": typ("geo_point")}),
+			}),
+			"event": obj(map[string]interface{}{
+				"created":  typ("date"),
+				"ingested": typ("date"),
+				"kind":     kw,
+				"category": kw,
+				"type":     kw,
+				"action":   kw,
+				"dataset":  kw,
+				"severity": typ("long"),
+			}),
+			"network": obj(map[string]interface{}{"transport": kw, "protocol": kw, "community_id": kw}),
+			"loom": obj(map[string]interface{}{
+				"first_seen": typ("boolean"),
+				"sequence":   typ("long"),
+				"request_id": kw,
+				"clock":      obj(map[string]interface{}{"offset_seconds": typ("float"), "original_timestamp": typ("date")}),
+				"transport": obj(map[string]interface{}{
+					"remote_ip":    typ("ip"),
+					"forwarded_ip": typ("ip"),
+					"http_version": kw,
+					"tls":          obj(map[string]interface{}{"version": kw, "cipher": kw, "client_cert_sha256": kw}),
+				}),
+				"artifacts": obj(map[string]interface{}{
+					"sha256":    kw,
+					"size":      typ("long"),
+					"mime_type": kw,
+					"reference": kw,
+				}),
+			}),
+		},
+	}
+}
+
+// InstallElasticsearch sends ElasticsearchRequests to the cluster, calling step before each one. An
+// existing cfg.Index is left alone; if it is a plain index rather than an alias, rollover cannot
+// apply to it and an error says so after the policy and template are installed.
+func InstallElasticsearch(ctx context.Context, cfg ElasticsearchConfig, step func(desc string)) error {
+	if cfg.Index == "" {
+		cfg.Index = "loom-events"
+	}
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	do := func(r Request) (*http.Response, error) {
+		var body io.Reader
+		if r.Body != nil {
+			b, err := json.Marshal(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = bytes.NewReader(b)
+		}
+		req, err := http.NewRequestWithContext(ctx, r.Method, strings.TrimSuffix(cfg.URL, "/")+r.Path, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if cfg.User != "" || cfg.Pass != "" {
+			req.SetBasicAuth(cfg.User, cfg.Pass)
+		}
+		return client.Do(req)
+	}
+
+	policy, template, bootstrap := ElasticsearchRequests(cfg)
+	for _, r := range []Request{policy, template} {
+		if step != nil {
+			step(r.Method + " " + r.Path)
+		}
+		if err := check(do(r)); err != nil {
+			return fmt.Errorf("elasticsearch: %w", err)
+		}
+	}
+
+	resp, err := do(Request{Method: http.MethodHead, Path: "/_alias/" + cfg.Index})
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil // the write alias exists from an earlier run
+	}
+	resp, err = do(Request{Method: http.MethodHead, Path: "/" + cfg.Index})
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("elasticsearch: %s is an index, not an alias; reindex it into %s-000001 to use rollover", cfg.Index, cfg.Index)
+	}
+	if step != nil {
+		step(bootstrap.Method + " " + bootstrap.Path)
+	}
+	if err := check(do(bootstrap)); err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	return nil
+}
+
+// check turns a response other than 2xx into an error with the start of its body.
+func check(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInstallClickHouse(t *testing.T) {
+	var mu sync.Mutex
+	var stmts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		stmts = append(stmts, string(b))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	var steps []string
+	err := InstallClickHouse(context.Background(), ClickHouseConfig{URL: srv.URL, Database: "loom", Views: true}, func(d string) { steps = append(steps, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 11 || stmts[0] != "CREATE DATABASE IF NOT EXISTS loom" {
+		t.Fatalf("statements = %q", stmts)
+	}
+	if !strings.HasPrefix(stmts[1], "CREATE TABLE IF NOT EXISTS loom.loom_events (") || !strings.Contains(stmts[3], "TO loom.loom_events_hourly") {
+		t.Errorf("statements = %q", stmts)
+	}
+	if steps[1] != "CREATE TABLE IF NOT EXISTS loom.loom_events" {
+		t.Errorf("steps = %q", steps)
+	}
+	if !strings.HasPrefix(stmts[4], "ALTER TABLE loom.loom_events ADD COLUMN IF NOT EXISTS source_country ") {
+		t.Errorf("statements[4] = %q", stmts[4])
+	}
+	if !strings.Contains(stmts[9], "CREATE TABLE IF NOT EXISTS loom.loom_events_hourly_countries (") ||
+		!strings.Contains(stmts[10], "TO loom.loom_events_hourly_countries AS\nSELECT toStartOfHour(timestamp) AS hour, source_country, count() AS events") {
+		t.Errorf("statements = %q", stmts[9:])
+	}
+	if plain, _ := ClickHouseStatements(ClickHouseConfig{}); len(plain) != 2 {
+		t.Errorf("without views: %q", plain)
+	}
+
+	if _, err := ClickHouseStatements(ClickHouseConfig{Table: "events; DROP TABLE x"}); err == nil {
+		t.Error("expected error for invalid table name")
+	}
+}
+
+func TestInstallElasticsearch(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	bodies := map[string]map[string]interface{}{}
+	aliasExists := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodHead {
+			if !aliasExists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies[r.URL.Path] = body
+	}))
+	defer srv.Close()
+
+	cfg := ElasticsearchConfig{URL: srv.URL, DeleteAfterDays: 90}
+	if err := InstallElasticsearch(context.Background(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"PUT /_ilm/policy/loom-events", "PUT /_index_template/loom-events", "HEAD /_alias/loom-events", "HEAD /loom-events", "PUT /loom-events-000001"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v\nwant    %v", calls, want)
+	}
+	phases := bodies["/_ilm/policy/loom-events"]["policy"].(map[string]interface{})["phases"].(map[string]interface{})
+	if phases["delete"].(map[string]interface{})["min_age"] != "90d" {
+		t.Errorf("phases = %v", phases)
+	}
+	if _, ok := bodies["/loom-events-000001"]["aliases"].(map[string]interface{})["loom-events"]; !ok {
+		t.Errorf("bootstrap = %v", bodies["/loom-events-000001"])
+	}
+
+	// A second run finds the alias and does not create another backing index.
+	calls, aliasExists = nil, true
+	if err := InstallElasticsearch(context.Background(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 {
+		t.Errorf("second run calls = %v", calls)
+	}
+}
@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -11,26 +15,94 @@ import (
 
 // Config holds all Loom configuration.
 type Config struct {
-	Server        ServerConfig        `toml:"server"`
-	Auth          AuthConfig          `toml:"auth"`
-	Limits        LimitsConfig        `toml:"limits"`
-	Enrichment    EnrichmentConfig    `toml:"enrichment"`
-	Output        OutputConfig        `toml:"output"`
-	Logging       LoggingConfig       `toml:"logging"`
-	Observability ObservabilityConfig `toml:"observability"`
+	Include       []string                `toml:"include" env:"-"` // overlay files merged over this one, in order
+	Server        ServerConfig            `toml:"server"`
+	Auth          AuthConfig              `toml:"auth"`
+	Limits        LimitsConfig            `toml:"limits"`
+	Backfill      BackfillConfig          `toml:"backfill"`
+	Strict        StrictConfig            `toml:"strict"`
+	Artifacts     ArtifactsConfig         `toml:"artifacts"`
+	Normalize     NormalizeConfig         `toml:"normalize"`
+	Transform     []TransformRule         `toml:"transform"`
+	Enrichment    EnrichmentConfig        `toml:"enrichment"`
+	Sensors       map[string]SensorConfig `toml:"sensors"`
+	Tenants       map[string]TenantConfig `toml:"tenants"`
+	Sessions      SessionsConfig          `toml:"sessions"`
+	Rollup        RollupConfig            `toml:"rollup"`
+	Output        OutputConfig            `toml:"output"`
+	Outputs       map[string]OutputConfig `toml:"outputs"`
+	Routes        []RouteConfig           `toml:"routes"`
+	Logging       LoggingConfig           `toml:"logging"`
+	Observability ObservabilityConfig     `toml:"observability"`
+	Alerts        AlertsConfig            `toml:"alerts"`
+	Detection     DetectionConfig         `toml:"detection"`
+	Query         QueryConfig             `toml:"query"`
+	Intel         IntelConfig             `toml:"intel"`
+	Reports       ReportsConfig           `toml:"reports"`
+	ClockSkew     ClockSkewConfig         `toml:"clock_skew"`
+	Hardening     HardeningConfig         `toml:"hardening"`
+	Shared        SharedConfig            `toml:"shared"`
+	Input         InputConfig             `toml:"input"`
 }
 
 type ServerConfig struct {
-	ListenAddress           string `toml:"listen_address"`
-	TLS                     bool   `toml:"tls"`
-	CertFile                string `toml:"cert_file"`
-	KeyFile                 string `toml:"key_file"`
-	ManagementListenAddress string `toml:"management_listen_address"`
+	ListenAddress             string              `toml:"listen_address"`
+	TLS                       bool                `toml:"tls"`
+	CertFile                  string              `toml:"cert_file"`
+	KeyFile                   string              `toml:"key_file"`
+	Certificates              []CertificateConfig `toml:"certificates"`
+	CertReloadIntervalSeconds int                 `toml:"cert_reload_interval_seconds"`
+	ManagementListenAddress   string              `toml:"management_listen_address"`
+	// Listeners replaces listen_address/tls with several ingest listeners (TCP or unix socket).
+	Listeners []ListenerConfig `toml:"listeners"`
+
+	// Ingest listener timeouts and limits
+	ReadTimeoutSeconds       int `toml:"read_timeout_seconds"`
+	ReadHeaderTimeoutSeconds int `toml:"read_header_timeout_seconds"`
+	WriteTimeoutSeconds      int `toml:"write_timeout_seconds"`
+	IdleTimeoutSeconds       int `toml:"idle_timeout_seconds"`
+	MaxHeaderBytes           int `toml:"max_header_bytes"`
+	ShutdownGraceSeconds     int `toml:"shutdown_grace_seconds"`
+
+	// Connection tuning: HTTP/2 on TLS listeners (on by default) and keep-alives
+	DisableHTTP2              bool `toml:"disable_http2"`
+	HTTP2MaxConcurrentStreams int  `toml:"http2_max_concurrent_streams"`
+	DisableKeepAlives         bool `toml:"disable_keep_alives"`
+	TCPKeepAliveSeconds       int  `toml:"tcp_keep_alive_seconds"` // -1 disables TCP keep-alive probes
+
+	// Peer address filter enforced before auth (CIDRs or bare IPs); deny wins
+	AllowCIDRs []string `toml:"allow_cidrs"`
+	DenyCIDRs  []string `toml:"deny_cidrs"`
+}
+
+// TLSEnabled reports whether any ingest listener serves TLS.
+func (s ServerConfig) TLSEnabled() bool {
+	if len(s.Listeners) == 0 {
+		return s.TLS
+	}
+	for _, l := range s.Listeners {
+		if l.TLS {
+			return true
+		}
+	}
+	return false
+}
+
+// ListenerConfig is one ingest listener. Address is host:port or "unix:/path/to.sock".
+type ListenerConfig struct {
+	Address string `toml:"address"`
+	TLS     bool   `toml:"tls"`
+}
+
+// CertificateConfig is an additional certificate served by SNI (matched against its DNS names).
+type CertificateConfig struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
 }
 
 type AuthConfig struct {
 	TokenFile string            `toml:"token_file"`
-	Tokens    map[string]string `toml:"tokens"`
+	Tokens    map[string]string `toml:"tokens" env:"-"` // from LOOM_SENSOR_* and token_file
 }
 
 type LimitsConfig struct {
@@ -39,12 +111,93 @@ type LimitsConfig struct {
 	MaxEventSizeBytes  int64 `toml:"max_event_size_bytes"`
 	PerSensorRPS       int   `toml:"per_sensor_rps"`
 	PerSensorEventsRPS int   `toml:"per_sensor_events_rps"`
+	// RateLimitAlgorithm is how per_sensor_rps and the tenants' rps are counted: "fixed" (per
+	// calendar second, the default), "sliding" (any one second) or "gcra" (requests spaced evenly,
+	// bursts up to RateLimitBurst; 0 = a tenth of the rate).
+	RateLimitAlgorithm string `toml:"rate_limit_algorithm"`
+	RateLimitBurst     int    `toml:"rate_limit_burst"`
+	// SplitLargeBatches accepts batches above max_events_per_batch and processes them in chunks.
+	SplitLargeBatches bool `toml:"split_large_batches"`
+	// ProcessingTimeoutSeconds bounds processing one ingest batch (enrichment and output): the
+	// sensor then gets 503 and sends the batch again; the events not yet processed are skipped.
+	// The events processed before the timeout are written and counted (detection, sessions,
+	// rollups, intel) again with the resend. 0 (the default) or -1 disables.
+	ProcessingTimeoutSeconds int `toml:"processing_timeout_seconds"`
+}
+
+// BackfillConfig enables POST /api/v1/ingest/backfill for replaying archived sensor events: sensor
+// tokens as for ingest, no request rate limits, and size limits that default to [limits].
+type BackfillConfig struct {
+	Enabled           bool     `toml:"enabled"`
+	MaxBodySizeBytes  int64    `toml:"max_body_size_bytes"`  // 0 = limits.max_body_size_bytes
+	MaxEventsPerBatch int      `toml:"max_events_per_batch"` // 0 = limits.max_events_per_batch
+	Sensors           []string `toml:"sensors"`              // sensors allowed to backfill; empty means all
+}
+
+// StrictConfig restricts the top-level fields of ingested events to an allowlist.
+type StrictConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Mode is "reject" (default: 400 for a batch with an event that has other fields) or "strip"
+	// (remove them).
+	Mode          string   `toml:"mode"`
+	AllowedFields []string `toml:"allowed_fields"` // default: the ECS field sets
+}
+
+// ArtifactsConfig enables the upload endpoint for files sensors capture (malware samples, pcaps).
+// Artifacts go to Dir unless S3Bucket is set.
+type ArtifactsConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	MaxBytes int64  `toml:"max_bytes"` // default 32 MiB
+	Dir      string `toml:"dir"`       // default /var/lib/loom/artifacts
+	// TempDir holds uploads while they are hashed; default the system temp directory.
+	TempDir string `toml:"temp_dir"`
+	// LinkTTLSeconds is how long an upload with X-Event-ID waits for its event; default 600.
+	LinkTTLSeconds int `toml:"link_ttl_seconds"`
+
+	S3Endpoint      string `toml:"s3_endpoint"`
+	S3Region        string `toml:"s3_region"`
+	S3Bucket        string `toml:"s3_bucket"`
+	S3Prefix        string `toml:"s3_prefix"`
+	S3AccessKey     string `toml:"s3_access_key"`
+	S3SecretKey     string `toml:"s3_secret_key"`
+	S3SecretKeyFile string `toml:"s3_secret_key_file"`
+}
+
+type NormalizeConfig struct {
+	Enabled    bool               `toml:"enabled"`
+	ECSVersion string             `toml:"ecs_version"`
+	Mappings   []NormalizeMapping `toml:"mappings"`
+}
+
+type NormalizeMapping struct {
+	From string `toml:"from"`
+	To   string `toml:"to"`
+}
+
+// TransformRule is one [[transform]] rule, applied to each event in order before normalization and
+// enrichment: rename moves from to to, drop removes field, add sets field to value.
+type TransformRule struct {
+	Action    string      `toml:"action"`
+	From      string      `toml:"from"`
+	To        string      `toml:"to"`
+	Field     string      `toml:"field"`
+	Value     interface{} `toml:"value"`
+	Overwrite bool        `toml:"overwrite"` // rename, add: replace a value already at the target
+	Sensors   []string    `toml:"sensors"`   // only events from these sensors; empty means all
 }
 
 type EnrichmentConfig struct {
-	GeoIPDBPath string    `toml:"geoip_db_path"`
-	ASNDBPath   string    `toml:"asn_db_path"`
-	DNS         DNSConfig `toml:"dns"`
+	GeoIPDBPath string           `toml:"geoip_db_path"`
+	ASNDBPath   string           `toml:"asn_db_path"`
+	DNS         DNSConfig        `toml:"dns"`
+	Payload     PayloadConfig    `toml:"payload"`
+	Signatures  SignaturesConfig `toml:"signatures"`
+	Internal    InternalConfig   `toml:"internal"`
+	Cache       CacheConfig      `toml:"cache"`
+	FirstSeen   FirstSeenConfig  `toml:"first_seen"`
+	// DBMaxAgeDays marks MaxMind databases built longer ago as stale (logged daily); default 30,
+	// -1 disables the check.
+	DBMaxAgeDays int `toml:"db_max_age_days"`
 }
 
 type DNSConfig struct {
@@ -52,6 +205,109 @@ type DNSConfig struct {
 	ResolverAddr string `toml:"resolver_addr"`
 	CacheTTL     int    `toml:"cache_ttl_seconds"`
 	MaxQPS       int    `toml:"max_qps"`
+	// Proxy sends the lookups over TCP through a socks5 or socks5h proxy (any proxy for an https
+	// server); unset resolves directly.
+	Proxy string `toml:"proxy"`
+	// Server, if set, sends the lookups encrypted to one resolver instead of the system's:
+	// tls://host[:port] for DNS over TLS (port 853 by default) or an https:// URL for DNS over HTTPS.
+	Server string `toml:"server"`
+}
+
+type PayloadConfig struct {
+	Enabled      bool     `toml:"enabled"`
+	Fields       []string `toml:"fields"`
+	Base64       bool     `toml:"base64"`
+	StoreDecoded bool     `toml:"store_decoded"`
+	MaxBytes     int      `toml:"max_bytes"`
+}
+
+type CacheConfig struct {
+	MaxEntries int `toml:"max_entries"`
+	TTLSeconds int `toml:"ttl_seconds"`
+
+	// Path, if set, keeps the ASN, GEO and DNS caches across restarts: saved every
+	// SaveIntervalSeconds and at shutdown, loaded at startup.
+	Path                string `toml:"path"`
+	SaveIntervalSeconds int    `toml:"save_interval_seconds"`
+}
+
+type InternalConfig struct {
+	Enabled     bool `toml:"enabled"`
+	SkipLookups bool `toml:"skip_lookups"`
+}
+
+// FirstSeenConfig tags events whose source IP (or other indicator) was never seen before.
+// The bloom filter in Path is sized for ExpectedItems at FalsePositiveRate; changing either starts
+// a fresh filter.
+type FirstSeenConfig struct {
+	Enabled             bool     `toml:"enabled"`
+	Path                string   `toml:"path"`
+	Fields              []string `toml:"fields"`
+	ExpectedItems       int      `toml:"expected_items"`
+	FalsePositiveRate   float64  `toml:"false_positive_rate"`
+	SaveIntervalSeconds int      `toml:"save_interval_seconds"`
+}
+
+type SignaturesConfig struct {
+	Enabled   bool     `toml:"enabled"`
+	RulesPath string   `toml:"rules_path"`
+	Fields    []string `toml:"fields"`
+}
+
+// SensorConfig is static metadata merged into every event from the sensor with this ID.
+type SensorConfig struct {
+	Site     string                 `toml:"site"`
+	Owner    string                 `toml:"owner"`
+	Tags     []string               `toml:"tags"`
+	Labels   map[string]string      `toml:"labels"`
+	Observer map[string]interface{} `toml:"observer"`
+	// Geo is where the sensor is, set as observer.geo.* on its events.
+	Geo SensorGeoConfig `toml:"geo"`
+
+	// Tenant assigns the sensor (and so its token) to a [tenants.<id>] entry.
+	Tenant string `toml:"tenant"`
+	// OrderedDelivery numbers the sensor's events (loom.sequence) and keeps them in arrival order
+	// through the ClickHouse output and its outbox.
+	OrderedDelivery bool `toml:"ordered_delivery"`
+}
+
+// SensorGeoConfig locates a sensor: fixed values, and/or the GeoIP location (enrichment.geoip_db_path)
+// of the public address it connects to ingest from, looked up when that address changes. That is the
+// connection's peer address, never X-Forwarded-For or similar headers: behind a proxy, use fixed
+// values. Fixed values take precedence over looked-up ones; zero values are left out.
+type SensorGeoConfig struct {
+	FromIP         bool    `toml:"from_ip"`
+	Lat            float64 `toml:"lat"`
+	Lon            float64 `toml:"lon"`
+	CountryISOCode string  `toml:"country_iso_code"`
+	CountryName    string  `toml:"country_name"`
+	RegionName     string  `toml:"region_name"`
+	CityName       string  `toml:"city_name"`
+}
+
+// TenantConfig limits and routes the events of the sensors assigned to a tenant. Zero values fall
+// back to [limits] and the [output] index or table.
+type TenantConfig struct {
+	RPS               int   `toml:"rps"`                  // requests per second across the tenant's sensors
+	EventsPerDay      int64 `toml:"events_per_day"`       // quota per UTC day; in memory unless [shared]
+	MaxEventsPerBatch int   `toml:"max_events_per_batch"` // at most [limits] max_events_per_batch
+
+	ElasticsearchIndex string `toml:"elasticsearch_index"`
+	ClickHouseTable    string `toml:"clickhouse_table"`
+}
+
+type SessionsConfig struct {
+	Enabled            bool `toml:"enabled"`
+	IdleTimeoutSeconds int  `toml:"idle_timeout_seconds"`
+	MaxSessions        int  `toml:"max_sessions"`
+}
+
+type RollupConfig struct {
+	Enabled         bool     `toml:"enabled"`
+	IntervalSeconds int      `toml:"interval_seconds"`
+	GroupBy         []string `toml:"group_by"`
+	MaxKeys         int      `toml:"max_keys"`
+	DropRaw         bool     `toml:"drop_raw"`
 }
 
 type OutputConfig struct {
@@ -68,6 +324,136 @@ type OutputConfig struct {
 	Outbox             OutboxConfig `toml:"outbox"`
 	KafkaBrokers       []string     `toml:"kafka_brokers"`
 	KafkaTopic         string       `toml:"kafka_topic"`
+
+	// ElasticsearchMaxBulkBytes splits bulk requests larger than this; default 10 MiB (10485760).
+	ElasticsearchMaxBulkBytes int64 `toml:"elasticsearch_max_bulk_bytes"`
+
+	// ClickHouseFlatten adds each event's fields to its row as dotted columns ("source.ip") besides
+	// the event column; the table keeps the columns it defines and skips the others.
+	ClickHouseFlatten bool `toml:"clickhouse_flatten"`
+
+	// Proxy for the connections to the destination: unset honors HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY; "direct" ignores them; or an http, https, socks5 or socks5h URL.
+	Proxy string `toml:"proxy"`
+
+	// HTTP tunes the HTTP client to Elasticsearch, ClickHouse or the forward upstream.
+	HTTP OutputHTTPConfig `toml:"http"`
+
+	// *_file variants read the credential from a file (Docker/Kubernetes secrets) and take
+	// precedence over the inline value. Read at startup: [output] is not reloaded, so a rotated
+	// secret takes a restart.
+	ElasticsearchUserFile  string `toml:"elasticsearch_user_file"`
+	ElasticsearchPassFile  string `toml:"elasticsearch_pass_file"`
+	ClickHouseUserFile     string `toml:"clickhouse_user_file"`
+	ClickHousePasswordFile string `toml:"clickhouse_password_file"`
+
+	// Retention bounds how long events are kept in the ClickHouse table (and any further tables).
+	Retention RetentionConfig `toml:"retention"`
+
+	// Forward (type = "forward") sends events to the ingest endpoint of another Loom, e.g. from an
+	// edge instance near the sensors to a central one; [output.outbox] spools what it cannot deliver.
+	ForwardURL       string `toml:"forward_url"`
+	ForwardToken     string `toml:"forward_token"`
+	ForwardTokenFile string `toml:"forward_token_file"`
+	ForwardSensorID  string `toml:"forward_sensor_id"` // sent as X-Spip-ID; the upstream token's sensor
+	ForwardGzip      bool   `toml:"forward_gzip"`
+	ForwardCAFile    string `toml:"forward_ca_file"`
+
+	// GELF (type = "gelf") sends each event to a Graylog GELF input: gelf_url is udp://, tcp://,
+	// http:// or https://. Compression is "gzip" (UDP default), "zlib" or "none" (TCP only).
+	GELFURL         string `toml:"gelf_url"`
+	GELFHost        string `toml:"gelf_host"`
+	GELFCompression string `toml:"gelf_compression"`
+	GELFChunkSize   int    `toml:"gelf_chunk_size"`
+
+	// Event Hubs (type = "eventhubs") sends events to an Azure Event Hub through the namespace's
+	// Kafka endpoint (Standard tier and above), authenticated with a SAS connection string or an
+	// Azure AD application. The connection string may name the namespace and hub (EntityPath).
+	EventHubsNamespace            string `toml:"eventhubs_namespace"`
+	EventHubsName                 string `toml:"eventhubs_name"`
+	EventHubsConnectionString     string `toml:"eventhubs_connection_string"`
+	EventHubsConnectionStringFile string `toml:"eventhubs_connection_string_file"`
+	EventHubsTenantID             string `toml:"eventhubs_tenant_id"`
+	EventHubsClientID             string `toml:"eventhubs_client_id"`
+	EventHubsClientSecret         string `toml:"eventhubs_client_secret"`
+	EventHubsClientSecretFile     string `toml:"eventhubs_client_secret_file"`
+
+	// Pub/Sub (type = "pubsub") publishes events to a Google Cloud Pub/Sub topic, authenticated with
+	// a service account key file or, without one, the metadata server's service account.
+	// pubsub_ordering sets each sensor's ID as ordering key.
+	PubSubProject         string `toml:"pubsub_project"`
+	PubSubTopic           string `toml:"pubsub_topic"`
+	PubSubOrdering        bool   `toml:"pubsub_ordering"`
+	PubSubCredentialsFile string `toml:"pubsub_credentials_file"`
+	PubSubEndpoint        string `toml:"pubsub_endpoint"` // default https://pubsub.googleapis.com
+
+	// Unix (type = "unix") sends each event as an NDJSON line in a datagram to the unix socket at
+	// unix_socket, bound by a local consumer; events nobody receives are dropped.
+	UnixSocket string `toml:"unix_socket"`
+
+	// SQLite (type = "sqlite") stores events in an embedded database file at sqlite_path, in the
+	// events table indexed on timestamp and source IP. When they take more than sqlite_max_bytes
+	// (default 1 GiB, 1073741824; -1 for no limit), the oldest events are deleted.
+	SQLitePath     string `toml:"sqlite_path"`
+	SQLiteMaxBytes int64  `toml:"sqlite_max_bytes"`
+
+	// Passthrough writes events received over HTTP as sent (checked to be JSON objects within the
+	// size limit, and compacted) without decoding them: no normalization or enrichment.
+	Passthrough bool `toml:"passthrough"`
+
+	// DrainTimeoutSeconds bounds flushing buffered events and the outbox on shutdown.
+	DrainTimeoutSeconds int `toml:"drain_timeout_seconds"`
+	// HealthCheckIntervalSeconds is how often the destination is pinged for /ready.
+	HealthCheckIntervalSeconds int `toml:"health_check_interval_seconds"`
+}
+
+// RouteConfig is a [[routes]] entry: events matching every condition it sets (one without any
+// matches all) go to outputs, names of [outputs.<name>] tables or "default" for [output]. Named
+// outputs take the keys of [output]; their outbox settings default to [output.outbox]'s, in the
+// subdirectory route-<name>. The first matching route applies unless it sets continue; events no
+// route matches go to [output].
+type RouteConfig struct {
+	Sensors          []string `toml:"sensors"`
+	EventCategories  []string `toml:"event_category"` // any value of event.category
+	DestinationPorts []int    `toml:"destination_ports"`
+	Outputs          []string `toml:"outputs"`
+	Continue         bool     `toml:"continue"`
+}
+
+// OutputHTTPConfig is [output.http]: connection pool, timeouts and connection check retries of
+// the output's HTTP client. [outputs.<name>] tables default to [output.http]'s values.
+type OutputHTTPConfig struct {
+	MaxIdleConnsPerHost        int `toml:"max_idle_conns_per_host"`
+	MaxConnsPerHost            int `toml:"max_conns_per_host"` // 0 = unlimited
+	DialTimeoutSeconds         int `toml:"dial_timeout_seconds"`
+	TLSHandshakeTimeoutSeconds int `toml:"tls_handshake_timeout_seconds"`
+	KeepAliveSeconds           int `toml:"keep_alive_seconds"` // TCP keep-alive probes; -1 disables
+	IdleConnTimeoutSeconds     int `toml:"idle_conn_timeout_seconds"`
+	RequestTimeoutSeconds      int `toml:"request_timeout_seconds"`
+	// PingAttempts is how often the startup and /ready connection checks are tried while the
+	// destination fails with a network error or 5xx.
+	PingAttempts int `toml:"ping_attempts"`
+}
+
+// setDefaults fills the unset fields of h from def.
+func (h *OutputHTTPConfig) setDefaults(def OutputHTTPConfig) {
+	for _, f := range []struct {
+		v   *int
+		def int
+	}{
+		{&h.MaxIdleConnsPerHost, def.MaxIdleConnsPerHost},
+		{&h.MaxConnsPerHost, def.MaxConnsPerHost},
+		{&h.DialTimeoutSeconds, def.DialTimeoutSeconds},
+		{&h.TLSHandshakeTimeoutSeconds, def.TLSHandshakeTimeoutSeconds},
+		{&h.KeepAliveSeconds, def.KeepAliveSeconds},
+		{&h.IdleConnTimeoutSeconds, def.IdleConnTimeoutSeconds},
+		{&h.RequestTimeoutSeconds, def.RequestTimeoutSeconds},
+		{&h.PingAttempts, def.PingAttempts},
+	} {
+		if *f.v == 0 {
+			*f.v = f.def
+		}
+	}
 }
 
 type OutboxConfig struct {
@@ -78,26 +464,230 @@ type OutboxConfig struct {
 	MaxBatchSize      int    `toml:"max_batch_size"`
 	RetryBackoffMS    int    `toml:"retry_backoff_ms"`
 	RetryMaxBackoffMS int    `toml:"retry_max_backoff_ms"`
+	// ReadyMaxBytes > 0 marks the instance not ready while the outbox holds more than this.
+	ReadyMaxBytes int64 `toml:"ready_max_bytes"`
+	// ClickHouse drain: files replayed per flush, concurrent inserts, and events per second (0 = unlimited).
+	DrainMaxFiles           int `toml:"drain_max_files"`
+	DrainWorkers            int `toml:"drain_workers"`
+	DrainMaxEventsPerSecond int `toml:"drain_max_events_per_second"`
+	// DrainPriority is "live" (live batches first, drain capped) or "fifo" (arrival order across outbox and live).
+	DrainPriority string `toml:"drain_priority"`
+	// Eviction is "oldest" (drop the oldest files when full) or "fair" (drop the oldest files of the
+	// sensor holding the most bytes); MaxBytesPerSensor > 0 caps one sensor's share. ClickHouse only.
+	Eviction          string `toml:"eviction"`
+	MaxBytesPerSensor int64  `toml:"max_bytes_per_sensor"`
+	// BackpressureBytes > 0 answers ingest with 503 while the outbox holds more than this, with a
+	// Retry-After of one flush interval per multiple of it, at most BackpressureMaxRetryAfterSeconds.
+	BackpressureBytes                int64 `toml:"backpressure_bytes"`
+	BackpressureMaxRetryAfterSeconds int   `toml:"backpressure_max_retry_after_seconds"`
+}
+
+// RetentionConfig sets a TTL on ClickHouse tables ("ttl") or periodically deletes old rows ("delete").
+type RetentionConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	Mode       string `toml:"mode"`         // "ttl" (default) or "delete"
+	MaxAgeDays int    `toml:"max_age_days"` // for clickhouse_table; default 30
+	// Tables are further tables (name or database.name) and their max age in days.
+	Tables map[string]int `toml:"tables"`
+	// TimestampExpression is the ClickHouse expression for a row's time; default the event's @timestamp.
+	TimestampExpression string `toml:"timestamp_expression"`
+	IntervalMinutes     int    `toml:"interval_minutes"` // between passes; default 60
 }
 
 type LoggingConfig struct {
 	Level  string `toml:"level"`
 	Format string `toml:"format"`
+	// AccessLogSampleEvery logs 1 in N successful ingest requests at info level; -1 logs them
+	// at debug only. Non-2xx responses are always logged.
+	AccessLogSampleEvery int `toml:"access_log_sample_every"`
 }
 
 type ObservabilityConfig struct {
 	MetricsEnabled bool `toml:"metrics_enabled"`
+	// MetricsMaxSensors caps the distinct sensor_id label values (others are counted as "other");
+	// -1 labels every sensor "all", 0 means no cap.
+	MetricsMaxSensors int `toml:"metrics_max_sensors"`
+	// EventRequestID stores each ingested event's request ID in loom.request_id.
+	EventRequestID bool `toml:"event_request_id"`
+	// EventTransport stores the connection each event arrived on (remote IP, HTTP and TLS version,
+	// cipher, client certificate fingerprint) in loom.transport.
+	EventTransport bool `toml:"event_transport"`
+	// AdminToken enables the /admin endpoints on the management port (Bearer auth); empty disables them.
+	AdminToken     string `toml:"admin_token"`
+	AdminTokenFile string `toml:"admin_token_file"` // read at startup, takes precedence over admin_token
+	// OTLP pushes the same metrics to an OpenTelemetry collector (OTLP/HTTP).
+	OTLP OTLPConfig `toml:"otlp"`
+	// Profiling serves net/http/pprof at /admin/debug/pprof/ and labels the work of each pipeline
+	// stage with the pprof label "stage". Requires admin_token.
+	Profiling bool `toml:"profiling"`
 }
 
-// Load reads config from path (TOML) and applies environment overrides (secrets).
-func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("read config: %w", err)
-	}
+// DetectionConfig evaluates detection rules over the enriched events and emits alert events.
+type DetectionConfig struct {
+	Enabled   bool   `toml:"enabled"`
+	RulesPath string `toml:"rules_path"` // empty uses the built-in rule pack
+	// Output is where alerts go: "events" (the configured output, default), "webhook" or "both".
+	Output     string `toml:"output"`
+	WebhookURL string `toml:"webhook_url"` // masked in /config
+	MaxKeys    int    `toml:"max_keys"`    // tracked group_by values per rule
+}
+
+// QueryConfig keeps recent events in memory and serves /api/v1 queries over them on the management
+// port (admin token required).
+type QueryConfig struct {
+	Enabled        bool `toml:"enabled"`
+	MaxEvents      int  `toml:"max_events"`
+	RetentionHours int  `toml:"retention_hours"`
+	// Dashboard serves a web dashboard at /dashboard on the management port, backed by the query API.
+	Dashboard bool `toml:"dashboard"`
+}
+
+// IntelConfig derives indicators (source IPs, file hashes) seen by several sensors and serves them
+// as STIX 2.1 on the management port: a TAXII 2.1 collection under /intel/taxii2/ and a bundle at
+// /intel/stix.
+type IntelConfig struct {
+	Enabled        bool       `toml:"enabled"`
+	Fields         []string   `toml:"fields"`          // default source.ip and file.hash.{sha256,sha1,md5}
+	MinSensors     int        `toml:"min_sensors"`     // default 2
+	WindowHours    int        `toml:"window_hours"`    // drop values not seen for this long; default 24
+	RefreshSeconds int        `toml:"refresh_seconds"` // how often the published indicators are recomputed; default 300
+	MaxKeys        int        `toml:"max_keys"`        // values tracked at once; default 100000
+	Token          string     `toml:"token"`           // for TAXII clients; default observability.admin_token; masked in /config
+	TokenFile      string     `toml:"token_file"`      // read at startup, takes precedence over token
+	MISP           MISPConfig `toml:"misp"`
+}
+
+// MISPConfig pushes the intel indicators to a MISP instance as attributes and sightings.
+type MISPConfig struct {
+	Enabled    bool   `toml:"enabled"`
+	URL        string `toml:"url"`
+	APIKey     string `toml:"api_key"` // masked in /config
+	APIKeyFile string `toml:"api_key_file"`
+	CAFile     string `toml:"ca_file"`
+	Proxy      string `toml:"proxy"` // like output.proxy
+	// EventInfo names the MISP event indicators go to; "{date}" makes one event per UTC day.
+	EventInfo       string   `toml:"event_info"`
+	Distribution    int      `toml:"distribution"`
+	ThreatLevel     int      `toml:"threat_level_id"`
+	Analysis        int      `toml:"analysis"`
+	Tags            []string `toml:"tags"`
+	ToIDs           bool     `toml:"to_ids"`
+	Sightings       bool     `toml:"sightings"`
+	IntervalSeconds int      `toml:"interval_seconds"` // default intel.refresh_seconds
+}
+
+// ClockSkewConfig estimates each sensor's clock offset from the newest @timestamp of its ingest
+// batches against the receive time (metric loom_sensor_clock_offset_seconds) and optionally
+// corrects the @timestamp of events from sensors whose offset exceeds threshold_seconds.
+type ClockSkewConfig struct {
+	Enabled          bool     `toml:"enabled"`
+	Correct          bool     `toml:"correct"`
+	ThresholdSeconds int      `toml:"threshold_seconds"` // default 300
+	Sensors          []string `toml:"sensors"`           // correct only these sensors; empty means all
+}
+
+// HardeningConfig enforces a restricted security profile, checked when the config is loaded: every
+// ingest listener serves TLS, at 1.3 only with NIST curves (TLS 1.3 has only AEAD cipher suites;
+// GODEBUG=fips140=on further limits them to AES-GCM), the management listener is plaintext and so
+// binds loopback only, and sensor tokens are stored as sha256 hashes.
+type HardeningConfig struct {
+	Enabled bool `toml:"enabled"`
+}
+
+// ReportsConfig sends a daily or weekly summary of the events (top source IPs, ASNs and countries,
+// new scanners, busiest sensors) as JSON to a webhook and/or as HTML by email.
+type ReportsConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	Schedule string `toml:"schedule"` // "daily" (default) or "weekly" (periods end on Monday)
+	Hour     int    `toml:"hour"`     // UTC hour at which a period ends, 0-23
+	Top      int    `toml:"top"`      // entries per list; default 10
+	MaxKeys  int    `toml:"max_keys"` // distinct values tracked per list; default 100000
+
+	WebhookURL string `toml:"webhook_url"` // masked in /config
+
+	SMTPAddr         string   `toml:"smtp_addr"` // host:port
+	SMTPUsername     string   `toml:"smtp_username"`
+	SMTPPassword     string   `toml:"smtp_password"` // masked in /config
+	SMTPPasswordFile string   `toml:"smtp_password_file"`
+	EmailFrom        string   `toml:"email_from"`
+	EmailTo          []string `toml:"email_to"`
+}
+
+// InputConfig lists event sources besides HTTP ingest.
+type InputConfig struct {
+	Kafka KafkaInputConfig `toml:"kafka"`
+}
+
+// KafkaInputConfig consumes ECS events from Kafka topics, enriches them and writes them to the
+// output, in addition to HTTP ingest. With it enabled, sensor tokens are optional (Kafka only).
+type KafkaInputConfig struct {
+	Enabled     bool     `toml:"enabled"`
+	Brokers     []string `toml:"brokers"`
+	Topics      []string `toml:"topics"`
+	GroupID     string   `toml:"group_id"`     // consumer group; default "loom"
+	StartOffset string   `toml:"start_offset"` // "latest" (default) or "earliest", for a group without offsets
+	// The sensor of a message: the header, else the event field, else default_sensor_id.
+	SensorIDHeader  string `toml:"sensor_id_header"`  // default "X-Spip-ID"
+	SensorIDField   string `toml:"sensor_id_field"`   // default "observer.id"
+	DefaultSensorID string `toml:"default_sensor_id"` // default "kafka"
+	BatchSize       int    `toml:"batch_size"`        // default 500
+	BatchWaitMS     int    `toml:"batch_wait_ms"`     // default 1000
+	TLS             bool   `toml:"tls"`
+	SASLMechanism   string `toml:"sasl_mechanism"` // "", "plain", "scram-sha-256" or "scram-sha-512"
+	Username        string `toml:"username"`
+	Password        string `toml:"password"`      // masked in /config
+	PasswordFile    string `toml:"password_file"` // read at startup, takes precedence over password
+}
+
+// SharedConfig keeps first-seen indicators, per-sensor rate limits and tenant limits in Redis so
+// that replicas behind a load balancer agree on them. Each replica falls back to its own state while Redis fails.
+type SharedConfig struct {
+	Backend      string `toml:"backend"`        // "" (per instance) or "redis"
+	RedisURL     string `toml:"redis_url"`      // redis://[user:password@]host:port/db, rediss:// for TLS; masked in /config
+	RedisURLFile string `toml:"redis_url_file"` // read at startup, takes precedence over redis_url
+	KeyPrefix    string `toml:"key_prefix"`     // default "loom:"
+	TimeoutMS    int    `toml:"timeout_ms"`     // per Redis command; default 200
+	PoolSize     int    `toml:"pool_size"`      // idle connections; default 8
+}
+
+// AlertsConfig posts operational alerts to a webhook (e.g. a Slack incoming webhook). Each condition
+// is disabled when its threshold is 0.
+type AlertsConfig struct {
+	Enabled         bool   `toml:"enabled"`
+	WebhookURL      string `toml:"webhook_url"` // masked in /config
+	IntervalSeconds int    `toml:"interval_seconds"`
+	// RepeatSeconds re-sends a still-firing alert after this long (default 3600; -1 never repeats).
+	RepeatSeconds int `toml:"repeat_seconds"`
+
+	SensorSilentMinutes int   `toml:"sensor_silent_minutes"`
+	OutboxMaxBytes      int64 `toml:"outbox_max_bytes"`
+	OutputDownMinutes   int   `toml:"output_down_minutes"`
+}
+
+// OTLPConfig configures pushing metrics over OTLP/HTTP (JSON encoding).
+type OTLPConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Endpoint is the collector's OTLP/HTTP URL, e.g. http://collector:4318 (/v1/metrics is appended
+	// when the URL has no path).
+	Endpoint        string            `toml:"endpoint"`
+	IntervalSeconds int               `toml:"interval_seconds"`
+	TimeoutSeconds  int               `toml:"timeout_seconds"`
+	Headers         map[string]string `toml:"headers"` // e.g. API keys; masked in /config
+}
+
+// Load reads config from path (TOML), merges its include files and then overlays in order,
+// and applies environment overrides (secrets).
+func Load(path string, overlays ...string) (*Config, error) {
 	var c Config
-	if _, err := toml.Decode(string(data), &c); err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
+	seen := make(map[string]bool)
+	for _, p := range append([]string{path}, overlays...) {
+		if err := c.decodeFile(p, seen); err != nil {
+			return nil, err
+		}
+	}
+	// LOOM_<SECTION>_<KEY> overrides are applied like file values, before defaults
+	if err := applyEnvOverrides(&c); err != nil {
+		return nil, err
 	}
 	c.setDefaults()
 	if err := c.applyEnv(); err != nil {
@@ -106,11 +696,109 @@ func Load(path string) (*Config, error) {
 	return &c, c.validate()
 }
 
+// LoadOutput reads the [output] settings of a config file (with includes, env overrides, defaults and
+// secret files) without validating the rest, for tools that only talk to a backend.
+func LoadOutput(path string) (*OutputConfig, error) {
+	var c Config
+	if err := c.decodeFile(path, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(&c); err != nil {
+		return nil, err
+	}
+	c.setDefaults()
+	c.Auth.TokenFile = "" // tokens are not needed and the file may not be readable here
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	return &c.Output, nil
+}
+
+// LoadPipeline reads config like Load but validates only the pipeline sections (normalize, sensors,
+// enrichment, output, ...), for programs that embed the pipeline without the ingest server.
+func LoadPipeline(path string, overlays ...string) (*Config, error) {
+	var c Config
+	seen := make(map[string]bool)
+	for _, p := range append([]string{path}, overlays...) {
+		if err := c.decodeFile(p, seen); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnvOverrides(&c); err != nil {
+		return nil, err
+	}
+	c.setDefaults()
+	c.Auth.TokenFile = "" // sensor tokens are only used by the ingest server
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	return &c, c.validatePipeline()
+}
+
+// decodeFile decodes path over c, then the files it lists in include (relative to its directory).
+// Keys present in a later file override earlier values; tables merge key by key, arrays are replaced.
+func (c *Config) decodeFile(path string, seen map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	if seen[abs] {
+		return fmt.Errorf("read config: %s included more than once", path)
+	}
+	seen[abs] = true
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	c.Include = nil
+	if _, err := toml.Decode(string(data), c); err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	includes := c.Include
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		if err := c.decodeFile(inc, seen); err != nil {
+			return err
+		}
+	}
+	c.Include = includes
+	return nil
+}
+
 func (c *Config) setDefaults() {
 	if c.Server.ListenAddress == "" {
 		c.Server.ListenAddress = ":8443"
 	}
 	// TLS default is left to config; production should set tls: true and cert_file/key_file
+	if c.Server.CertReloadIntervalSeconds == 0 {
+		c.Server.CertReloadIntervalSeconds = 60
+	}
+	if c.Server.ReadTimeoutSeconds == 0 {
+		c.Server.ReadTimeoutSeconds = 30
+	}
+	if c.Server.ReadHeaderTimeoutSeconds == 0 {
+		c.Server.ReadHeaderTimeoutSeconds = 10
+	}
+	if c.Server.WriteTimeoutSeconds == 0 {
+		c.Server.WriteTimeoutSeconds = 60
+	}
+	if c.Server.IdleTimeoutSeconds == 0 {
+		c.Server.IdleTimeoutSeconds = 120
+	}
+	if c.Server.MaxHeaderBytes == 0 {
+		c.Server.MaxHeaderBytes = 1 << 20 // 1 MiB
+	}
+	if c.Server.ShutdownGraceSeconds == 0 {
+		c.Server.ShutdownGraceSeconds = 15
+	}
+	if c.Server.HTTP2MaxConcurrentStreams == 0 {
+		c.Server.HTTP2MaxConcurrentStreams = 250
+	}
+	if c.Server.TCPKeepAliveSeconds == 0 {
+		c.Server.TCPKeepAliveSeconds = 15
+	}
 	if c.Limits.MaxBodySizeBytes == 0 {
 		c.Limits.MaxBodySizeBytes = 2 * 1024 * 1024 // 2 MiB
 	}
@@ -124,15 +812,177 @@ func (c *Config) setDefaults() {
 	if c.Limits.PerSensorRPS == 0 {
 		c.Limits.PerSensorRPS = 50
 	}
+	if c.Limits.RateLimitAlgorithm == "" {
+		c.Limits.RateLimitAlgorithm = "fixed"
+	}
+	if c.Strict.Mode == "" {
+		c.Strict.Mode = "reject"
+	}
+	if c.Artifacts.MaxBytes == 0 {
+		c.Artifacts.MaxBytes = 32 << 20
+	}
+	if c.Artifacts.Dir == "" {
+		c.Artifacts.Dir = "/var/lib/loom/artifacts"
+	}
+	if c.Artifacts.LinkTTLSeconds == 0 {
+		c.Artifacts.LinkTTLSeconds = 600
+	}
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
 	}
 	if c.Logging.Format == "" {
 		c.Logging.Format = "json"
 	}
+	if c.Logging.AccessLogSampleEvery == 0 {
+		c.Logging.AccessLogSampleEvery = 1
+	}
 	if c.Auth.Tokens == nil {
 		c.Auth.Tokens = make(map[string]string)
 	}
+	// Cache.MaxEntries: 0 or unset = default 100000; -1 = disable the ASN/GEO lookup cache
+	if c.Enrichment.DBMaxAgeDays == 0 {
+		c.Enrichment.DBMaxAgeDays = 30
+	}
+	if c.Enrichment.Cache.MaxEntries == 0 {
+		c.Enrichment.Cache.MaxEntries = 100000
+	}
+	if c.Enrichment.Cache.TTLSeconds == 0 {
+		c.Enrichment.Cache.TTLSeconds = 3600
+	}
+	if c.Enrichment.Cache.SaveIntervalSeconds == 0 {
+		c.Enrichment.Cache.SaveIntervalSeconds = 300
+	}
+	if len(c.Enrichment.Payload.Fields) == 0 {
+		c.Enrichment.Payload.Fields = []string{"event.original"}
+	}
+	if c.Enrichment.Payload.MaxBytes == 0 {
+		c.Enrichment.Payload.MaxBytes = 64 * 1024
+	}
+	if c.Enrichment.FirstSeen.Path == "" {
+		c.Enrichment.FirstSeen.Path = "/var/lib/loom/first_seen.bloom"
+	}
+	if len(c.Enrichment.FirstSeen.Fields) == 0 {
+		c.Enrichment.FirstSeen.Fields = []string{"source.ip"}
+	}
+	if c.Enrichment.FirstSeen.ExpectedItems == 0 {
+		c.Enrichment.FirstSeen.ExpectedItems = 5000000
+	}
+	if c.Enrichment.FirstSeen.FalsePositiveRate == 0 {
+		c.Enrichment.FirstSeen.FalsePositiveRate = 0.001
+	}
+	if c.Enrichment.FirstSeen.SaveIntervalSeconds == 0 {
+		c.Enrichment.FirstSeen.SaveIntervalSeconds = 60
+	}
+	if c.Sessions.IdleTimeoutSeconds == 0 {
+		c.Sessions.IdleTimeoutSeconds = 300
+	}
+	if c.Sessions.MaxSessions == 0 {
+		c.Sessions.MaxSessions = 100000
+	}
+	if c.Rollup.IntervalSeconds == 0 {
+		c.Rollup.IntervalSeconds = 60
+	}
+	if len(c.Rollup.GroupBy) == 0 {
+		c.Rollup.GroupBy = []string{"source.ip", "destination.port"}
+	}
+	if c.Rollup.MaxKeys == 0 {
+		c.Rollup.MaxKeys = 100000
+	}
+	if c.Output.DrainTimeoutSeconds == 0 {
+		c.Output.DrainTimeoutSeconds = 30
+	}
+	if c.Output.HealthCheckIntervalSeconds == 0 {
+		c.Output.HealthCheckIntervalSeconds = 10
+	}
+	if c.Observability.OTLP.IntervalSeconds == 0 {
+		c.Observability.OTLP.IntervalSeconds = 60
+	}
+	if c.Observability.OTLP.TimeoutSeconds == 0 {
+		c.Observability.OTLP.TimeoutSeconds = 10
+	}
+	if k := &c.Input.Kafka; k.Enabled {
+		if k.GroupID == "" {
+			k.GroupID = "loom"
+		}
+		if k.StartOffset == "" {
+			k.StartOffset = "latest"
+		}
+		if k.SensorIDHeader == "" {
+			k.SensorIDHeader = "X-Spip-ID"
+		}
+		if k.SensorIDField == "" {
+			k.SensorIDField = "observer.id"
+		}
+		if k.DefaultSensorID == "" {
+			k.DefaultSensorID = "kafka"
+		}
+		if k.BatchSize == 0 {
+			k.BatchSize = 500
+		}
+		if k.BatchWaitMS == 0 {
+			k.BatchWaitMS = 1000
+		}
+	}
+	if c.Shared.KeyPrefix == "" {
+		c.Shared.KeyPrefix = "loom:"
+	}
+	if c.Shared.TimeoutMS == 0 {
+		c.Shared.TimeoutMS = 200
+	}
+	if c.Shared.PoolSize == 0 {
+		c.Shared.PoolSize = 8
+	}
+	if c.Detection.Output == "" {
+		c.Detection.Output = "events"
+	}
+	if c.Detection.MaxKeys == 0 {
+		c.Detection.MaxKeys = 100000
+	}
+	if c.Query.MaxEvents == 0 {
+		c.Query.MaxEvents = 100000
+	}
+	if c.Intel.MinSensors == 0 {
+		c.Intel.MinSensors = 2
+	}
+	if c.Intel.WindowHours == 0 {
+		c.Intel.WindowHours = 24
+	}
+	if c.Intel.RefreshSeconds == 0 {
+		c.Intel.RefreshSeconds = 300
+	}
+	if c.Intel.MaxKeys == 0 {
+		c.Intel.MaxKeys = 100000
+	}
+	if c.Intel.MISP.EventInfo == "" {
+		c.Intel.MISP.EventInfo = "Loom honeypot indicators {date}"
+	}
+	if c.Intel.MISP.ThreatLevel == 0 {
+		c.Intel.MISP.ThreatLevel = 3
+	}
+	if c.Intel.MISP.IntervalSeconds == 0 {
+		c.Intel.MISP.IntervalSeconds = c.Intel.RefreshSeconds
+	}
+	if c.Query.RetentionHours == 0 {
+		c.Query.RetentionHours = 1
+	}
+	if c.ClockSkew.ThresholdSeconds == 0 {
+		c.ClockSkew.ThresholdSeconds = 300
+	}
+	if c.Reports.Schedule == "" {
+		c.Reports.Schedule = "daily"
+	}
+	if c.Reports.Top == 0 {
+		c.Reports.Top = 10
+	}
+	if c.Reports.MaxKeys == 0 {
+		c.Reports.MaxKeys = 100000
+	}
+	if c.Alerts.IntervalSeconds == 0 {
+		c.Alerts.IntervalSeconds = 60
+	}
+	if c.Alerts.RepeatSeconds == 0 {
+		c.Alerts.RepeatSeconds = 3600
+	}
 	if c.Output.Outbox.Dir == "" {
 		c.Output.Outbox.Dir = "/var/lib/loom/outbox"
 	}
@@ -151,6 +1001,75 @@ func (c *Config) setDefaults() {
 	if c.Output.Outbox.RetryMaxBackoffMS == 0 {
 		c.Output.Outbox.RetryMaxBackoffMS = 30000
 	}
+	if c.Output.Outbox.DrainMaxFiles == 0 {
+		c.Output.Outbox.DrainMaxFiles = 10
+	}
+	if c.Output.Outbox.DrainWorkers == 0 {
+		c.Output.Outbox.DrainWorkers = 1
+	}
+	if c.Output.Outbox.DrainPriority == "" {
+		c.Output.Outbox.DrainPriority = "live"
+	}
+	if c.Output.Outbox.Eviction == "" {
+		c.Output.Outbox.Eviction = "oldest"
+	}
+	if c.Output.Outbox.BackpressureMaxRetryAfterSeconds == 0 {
+		c.Output.Outbox.BackpressureMaxRetryAfterSeconds = 60
+	}
+	c.Output.HTTP.setDefaults(OutputHTTPConfig{
+		MaxIdleConnsPerHost:        32,
+		DialTimeoutSeconds:         10,
+		TLSHandshakeTimeoutSeconds: 10,
+		KeepAliveSeconds:           30,
+		IdleConnTimeoutSeconds:     90,
+		RequestTimeoutSeconds:      30,
+		PingAttempts:               3,
+	})
+	for name, o := range c.Outputs {
+		o.HTTP.setDefaults(c.Output.HTTP)
+		if o.Outbox.Dir == "" {
+			o.Outbox.Dir = filepath.Join(c.Output.Outbox.Dir, "route-"+name)
+		}
+		if o.Outbox.MaxBytes == 0 {
+			o.Outbox.MaxBytes = c.Output.Outbox.MaxBytes
+		}
+		if o.Outbox.MaxBatchSize == 0 {
+			o.Outbox.MaxBatchSize = c.Output.Outbox.MaxBatchSize
+		}
+		if o.Outbox.RetryBackoffMS == 0 {
+			o.Outbox.RetryBackoffMS = c.Output.Outbox.RetryBackoffMS
+		}
+		if o.Outbox.RetryMaxBackoffMS == 0 {
+			o.Outbox.RetryMaxBackoffMS = c.Output.Outbox.RetryMaxBackoffMS
+		}
+		if o.Outbox.DrainMaxFiles == 0 {
+			o.Outbox.DrainMaxFiles = c.Output.Outbox.DrainMaxFiles
+		}
+		if o.Outbox.DrainWorkers == 0 {
+			o.Outbox.DrainWorkers = c.Output.Outbox.DrainWorkers
+		}
+		if o.Outbox.DrainPriority == "" {
+			o.Outbox.DrainPriority = c.Output.Outbox.DrainPriority
+		}
+		if o.Outbox.Eviction == "" {
+			o.Outbox.Eviction = c.Output.Outbox.Eviction
+		}
+		if o.Outbox.MaxBytesPerSensor == 0 {
+			o.Outbox.MaxBytesPerSensor = c.Output.Outbox.MaxBytesPerSensor
+		}
+		c.Outputs[name] = o
+	}
+	if r := &c.Output.Retention; r.Enabled {
+		if r.Mode == "" {
+			r.Mode = "ttl"
+		}
+		if r.MaxAgeDays == 0 {
+			r.MaxAgeDays = 30
+		}
+		if r.IntervalMinutes == 0 {
+			r.IntervalMinutes = 60
+		}
+	}
 }
 
 func (c *Config) applyEnv() error {
@@ -189,6 +1108,59 @@ func (c *Config) applyEnv() error {
 			}
 		}
 	}
+	// Credentials from mounted secret files
+	for _, sf := range []struct {
+		key, path string
+		dst       *string
+	}{
+		{"output.elasticsearch_user_file", c.Output.ElasticsearchUserFile, &c.Output.ElasticsearchUser},
+		{"output.elasticsearch_pass_file", c.Output.ElasticsearchPassFile, &c.Output.ElasticsearchPass},
+		{"output.clickhouse_user_file", c.Output.ClickHouseUserFile, &c.Output.ClickHouseUser},
+		{"output.clickhouse_password_file", c.Output.ClickHousePasswordFile, &c.Output.ClickHousePassword},
+		{"output.forward_token_file", c.Output.ForwardTokenFile, &c.Output.ForwardToken},
+		{"output.eventhubs_connection_string_file", c.Output.EventHubsConnectionStringFile, &c.Output.EventHubsConnectionString},
+		{"output.eventhubs_client_secret_file", c.Output.EventHubsClientSecretFile, &c.Output.EventHubsClientSecret},
+		{"artifacts.s3_secret_key_file", c.Artifacts.S3SecretKeyFile, &c.Artifacts.S3SecretKey},
+		{"intel.misp.api_key_file", c.Intel.MISP.APIKeyFile, &c.Intel.MISP.APIKey},
+		{"reports.smtp_password_file", c.Reports.SMTPPasswordFile, &c.Reports.SMTPPassword},
+		{"observability.admin_token_file", c.Observability.AdminTokenFile, &c.Observability.AdminToken},
+		{"intel.token_file", c.Intel.TokenFile, &c.Intel.Token},
+		{"input.kafka.password_file", c.Input.Kafka.PasswordFile, &c.Input.Kafka.Password},
+		{"shared.redis_url_file", c.Shared.RedisURLFile, &c.Shared.RedisURL},
+	} {
+		if sf.path == "" {
+			continue
+		}
+		secret, err := readSecretFile(sf.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sf.key, err)
+		}
+		*sf.dst = secret
+	}
+	for name, o := range c.Outputs {
+		for _, sf := range []struct {
+			key, path string
+			dst       *string
+		}{
+			{"elasticsearch_user_file", o.ElasticsearchUserFile, &o.ElasticsearchUser},
+			{"elasticsearch_pass_file", o.ElasticsearchPassFile, &o.ElasticsearchPass},
+			{"clickhouse_user_file", o.ClickHouseUserFile, &o.ClickHouseUser},
+			{"clickhouse_password_file", o.ClickHousePasswordFile, &o.ClickHousePassword},
+			{"forward_token_file", o.ForwardTokenFile, &o.ForwardToken},
+			{"eventhubs_connection_string_file", o.EventHubsConnectionStringFile, &o.EventHubsConnectionString},
+			{"eventhubs_client_secret_file", o.EventHubsClientSecretFile, &o.EventHubsClientSecret},
+		} {
+			if sf.path == "" {
+				continue
+			}
+			secret, err := readSecretFile(sf.path)
+			if err != nil {
+				return fmt.Errorf("outputs.%s.%s: %w", name, sf.key, err)
+			}
+			*sf.dst = secret
+		}
+		c.Outputs[name] = o
+	}
 	// Elasticsearch credentials from env
 	if u := os.Getenv("LOOM_ELASTICSEARCH_USER"); u != "" {
 		c.Output.ElasticsearchUser = u
@@ -206,7 +1178,41 @@ func (c *Config) applyEnv() error {
 }
 
 func (c *Config) validate() error {
-	if c.Server.TLS {
+	if c.Server.ReadTimeoutSeconds < 0 || c.Server.ReadHeaderTimeoutSeconds < 0 || c.Server.WriteTimeoutSeconds < 0 ||
+		c.Server.IdleTimeoutSeconds < 0 || c.Server.ShutdownGraceSeconds < 0 {
+		return fmt.Errorf("server: timeouts must be positive")
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server: max_header_bytes must be positive")
+	}
+	for _, list := range [][]string{c.Server.AllowCIDRs, c.Server.DenyCIDRs} {
+		for _, cidr := range list {
+			if !validCIDR(cidr) {
+				return fmt.Errorf("server: invalid IP or CIDR %q in allow_cidrs/deny_cidrs", cidr)
+			}
+		}
+	}
+	if c.Server.HTTP2MaxConcurrentStreams < 0 {
+		return fmt.Errorf("server: http2_max_concurrent_streams must be positive")
+	}
+	switch c.Limits.RateLimitAlgorithm {
+	case "fixed", "sliding", "gcra":
+	default:
+		return fmt.Errorf("limits: rate_limit_algorithm must be \"fixed\", \"sliding\" or \"gcra\"")
+	}
+	if c.Limits.RateLimitBurst < 0 {
+		return fmt.Errorf("limits: rate_limit_burst must be >= 0")
+	}
+	if t := c.Limits.ProcessingTimeoutSeconds; t > 0 && c.Server.WriteTimeoutSeconds > 0 && t >= c.Server.WriteTimeoutSeconds {
+		// Otherwise the write timeout cuts the connection before the 503 is sent
+		return fmt.Errorf("limits: processing_timeout_seconds must be below server.write_timeout_seconds (%d)", c.Server.WriteTimeoutSeconds)
+	}
+	for i, l := range c.Server.Listeners {
+		if l.Address == "" || l.Address == "unix:" {
+			return fmt.Errorf("server: listeners[%d]: address required", i)
+		}
+	}
+	if c.Server.TLSEnabled() {
 		if c.Server.CertFile == "" || c.Server.KeyFile == "" {
 			return fmt.Errorf("server: tls enabled but cert_file or key_file missing")
 		}
@@ -216,8 +1222,19 @@ func (c *Config) validate() error {
 		if _, err := os.Stat(c.Server.KeyFile); err != nil {
 			return fmt.Errorf("server: key_file %q not readable: %w", c.Server.KeyFile, err)
 		}
+		for i, cert := range c.Server.Certificates {
+			if cert.CertFile == "" || cert.KeyFile == "" {
+				return fmt.Errorf("server: certificates[%d]: cert_file and key_file required", i)
+			}
+			if _, err := os.Stat(cert.CertFile); err != nil {
+				return fmt.Errorf("server: certificates[%d]: cert_file %q not readable: %w", i, cert.CertFile, err)
+			}
+			if _, err := os.Stat(cert.KeyFile); err != nil {
+				return fmt.Errorf("server: certificates[%d]: key_file %q not readable: %w", i, cert.KeyFile, err)
+			}
+		}
 	}
-	if len(c.Auth.Tokens) == 0 {
+	if len(c.Auth.Tokens) == 0 && !c.Input.Kafka.Enabled {
 		return fmt.Errorf("auth: no tokens configured (use token_file or LOOM_SENSOR_* env)")
 	}
 	// One token per sensor: each token must map to exactly one sensor
@@ -228,20 +1245,226 @@ func (c *Config) validate() error {
 		}
 		seenSensor[sensorID] = token
 	}
-	if c.Output.Type == "" {
-		c.Output.Type = "stdout"
+	if c.Hardening.Enabled {
+		if err := c.validateHardening(); err != nil {
+			return err
+		}
+	}
+	if c.Strict.Mode != "reject" && c.Strict.Mode != "strip" {
+		return fmt.Errorf("strict: mode must be reject or strip")
+	}
+	for _, f := range c.Strict.AllowedFields {
+		if f == "" || strings.Contains(f, ".") {
+			return fmt.Errorf("strict: allowed_fields are top-level field names, got %q", f)
+		}
+	}
+	if c.Artifacts.Enabled {
+		if c.Artifacts.MaxBytes < 0 || c.Artifacts.LinkTTLSeconds < 0 {
+			return fmt.Errorf("artifacts: max_bytes and link_ttl_seconds must not be negative")
+		}
+		if c.Artifacts.S3Bucket != "" && (c.Artifacts.S3Endpoint == "" || c.Artifacts.S3AccessKey == "" || c.Artifacts.S3SecretKey == "") {
+			return fmt.Errorf("artifacts: s3_bucket needs s3_endpoint, s3_access_key and s3_secret_key")
+		}
+	}
+	return c.validatePipeline()
+}
+
+// validateHardening refuses what [hardening] does not allow.
+func (c *Config) validateHardening() error {
+	if len(c.Server.Listeners) == 0 && !c.Server.TLS {
+		return fmt.Errorf("hardening: the ingest listener must use tls")
+	}
+	for i, l := range c.Server.Listeners {
+		if !l.TLS {
+			return fmt.Errorf("hardening: server.listeners[%d] (%s) must use tls", i, l.Address)
+		}
+	}
+	if addr := c.Server.ManagementListenAddress; addr != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); err != nil || (host != "localhost" && (ip == nil || !ip.IsLoopback())) {
+			return fmt.Errorf("hardening: management_listen_address %q must be a loopback address", addr)
+		}
+	}
+	for token, sensorID := range c.Auth.Tokens {
+		if !strings.HasPrefix(strings.ToLower(token), "sha256:") {
+			return fmt.Errorf("hardening: the token of sensor %q must be stored as its sha256 hash (loom token hash)", sensorID)
+		}
+	}
+	return nil
+}
+
+// validatePipeline checks the sections used by the event pipeline (everything but server and auth).
+func (c *Config) validatePipeline() error {
+	for _, m := range c.Normalize.Mappings {
+		if m.From == "" || m.To == "" {
+			return fmt.Errorf("normalize: mappings need both from and to")
+		}
+	}
+	for i, t := range c.Transform {
+		switch t.Action {
+		case "rename":
+			if !validPath(t.From) || !validPath(t.To) {
+				return fmt.Errorf("transform[%d]: rename needs from and to fields", i)
+			}
+		case "drop":
+			if !validPath(t.Field) {
+				return fmt.Errorf("transform[%d]: drop needs a field", i)
+			}
+		case "add":
+			if !validPath(t.Field) || t.Value == nil {
+				return fmt.Errorf("transform[%d]: add needs a field and a value", i)
+			}
+		default:
+			return fmt.Errorf("transform[%d]: action must be rename, drop or add", i)
+		}
+	}
+	if c.Enrichment.Cache.TTLSeconds < 0 {
+		return fmt.Errorf("enrichment.cache: ttl_seconds must be >= 0")
+	}
+	if c.Enrichment.Cache.SaveIntervalSeconds < 0 {
+		return fmt.Errorf("enrichment.cache: save_interval_seconds must be >= 0")
+	}
+	if c.Enrichment.Payload.MaxBytes < 0 {
+		return fmt.Errorf("enrichment.payload: max_bytes must be >= 0")
+	}
+	if d := c.Enrichment.DNS; d.Server != "" {
+		u, err := url.Parse(d.Server)
+		if err != nil || u.Host == "" || (u.Scheme != "tls" && u.Scheme != "https") {
+			return fmt.Errorf("enrichment.dns: server must be tls://host[:port] or an https:// URL")
+		}
+		if err := validProxy(d.Proxy, u.Scheme == "tls"); err != nil {
+			return fmt.Errorf("enrichment.dns: %w", err)
+		}
+	} else if err := validProxy(d.Proxy, true); err != nil {
+		return fmt.Errorf("enrichment.dns: %w", err)
+	}
+	if fs := c.Enrichment.FirstSeen; fs.Enabled {
+		if fs.ExpectedItems < 0 || fs.SaveIntervalSeconds < 0 {
+			return fmt.Errorf("enrichment.first_seen: expected_items and save_interval_seconds must be >= 0")
+		}
+		if fs.FalsePositiveRate <= 0 || fs.FalsePositiveRate >= 1 {
+			return fmt.Errorf("enrichment.first_seen: false_positive_rate must be between 0 and 1")
+		}
+	}
+	if c.Sessions.IdleTimeoutSeconds < 0 || c.Sessions.MaxSessions < 0 {
+		return fmt.Errorf("sessions: idle_timeout_seconds and max_sessions must be >= 0")
+	}
+	if c.Rollup.IntervalSeconds < 0 || c.Rollup.MaxKeys < 0 {
+		return fmt.Errorf("rollup: interval_seconds and max_keys must be >= 0")
+	}
+	for _, f := range c.Rollup.GroupBy {
+		if strings.TrimSpace(f) == "" || strings.HasPrefix(f, ".") || strings.HasSuffix(f, ".") {
+			return fmt.Errorf("rollup: invalid group_by field %q", f)
+		}
+	}
+	for id, sc := range c.Sensors {
+		if _, ok := c.Tenants[sc.Tenant]; sc.Tenant != "" && !ok {
+			return fmt.Errorf("sensors.%s: unknown tenant %q", id, sc.Tenant)
+		}
+		g := sc.Geo
+		if g.Lat < -90 || g.Lat > 90 || g.Lon < -180 || g.Lon > 180 {
+			return fmt.Errorf("sensors.%s.geo: lat must be -90..90 and lon -180..180", id)
+		}
+		if g.CountryISOCode != "" && len(g.CountryISOCode) != 2 {
+			return fmt.Errorf("sensors.%s.geo: country_iso_code must have two letters", id)
+		}
+		if g.FromIP && c.Enrichment.GeoIPDBPath == "" {
+			return fmt.Errorf("sensors.%s.geo: from_ip requires enrichment.geoip_db_path", id)
+		}
 	}
-	if c.Output.Type != "stdout" && c.Output.Type != "elasticsearch" && c.Output.Type != "kafka" && c.Output.Type != "clickhouse" {
-		return fmt.Errorf("output: unknown type %q", c.Output.Type)
+	for id, t := range c.Tenants {
+		if t.RPS < 0 || t.EventsPerDay < 0 || t.MaxEventsPerBatch < 0 {
+			return fmt.Errorf("tenants.%s: limits must be >= 0", id)
+		}
+		if t.MaxEventsPerBatch > c.Limits.MaxEventsPerBatch {
+			return fmt.Errorf("tenants.%s: max_events_per_batch must not exceed limits.max_events_per_batch", id)
+		}
 	}
-	if c.Output.Type == "elasticsearch" && c.Output.ElasticsearchURL == "" {
-		return fmt.Errorf("output: elasticsearch_url required when type=elasticsearch")
+	if err := validateOutput("output", &c.Output); err != nil {
+		return err
 	}
-	if c.Output.Type == "clickhouse" && c.Output.ClickHouseURL == "" {
-		return fmt.Errorf("output: clickhouse_url required when type=clickhouse")
+	for name, o := range c.Outputs {
+		if name == "default" {
+			return fmt.Errorf("outputs: the name default stands for [output] in routes")
+		}
+		key := "outputs." + name
+		if err := validateOutput(key, &o); err != nil {
+			return err
+		}
+		if o.Passthrough || o.Retention.Enabled {
+			return fmt.Errorf("%s: passthrough and retention are only supported in [output]", key)
+		}
+		c.Outputs[name] = o
 	}
-	if c.Output.Outbox.Enabled && c.Output.Type != "clickhouse" {
-		return fmt.Errorf("output: outbox requires type=clickhouse")
+	for i, r := range c.Routes {
+		if len(r.Outputs) == 0 {
+			return fmt.Errorf("routes[%d]: outputs required", i)
+		}
+		for _, name := range r.Outputs {
+			if _, ok := c.Outputs[name]; !ok && name != "default" {
+				return fmt.Errorf("routes[%d]: unknown output %q", i, name)
+			}
+		}
+		for _, p := range r.DestinationPorts {
+			if p < 0 || p > 65535 {
+				return fmt.Errorf("routes[%d]: invalid destination port %d", i, p)
+			}
+		}
+	}
+	if r := c.Output.Retention; r.Enabled {
+		if c.Output.Type != "clickhouse" {
+			return fmt.Errorf("output: retention requires type=clickhouse")
+		}
+		if r.Mode != "ttl" && r.Mode != "delete" {
+			return fmt.Errorf("output.retention: mode must be ttl or delete")
+		}
+		if r.MaxAgeDays < 0 || r.IntervalMinutes < 0 {
+			return fmt.Errorf("output.retention: max_age_days and interval_minutes must be >= 0")
+		}
+		for table, days := range r.Tables {
+			if days <= 0 {
+				return fmt.Errorf("output.retention: max age of table %q must be at least 1 day", table)
+			}
+		}
+	}
+	if c.Output.Passthrough {
+		// These need each event decoded.
+		for _, f := range []struct {
+			name string
+			on   bool
+		}{
+			{"normalize", c.Normalize.Enabled},
+			{"transform", len(c.Transform) > 0},
+			{"strict", c.Strict.Enabled},
+			{"sessions", c.Sessions.Enabled},
+			{"rollup", c.Rollup.Enabled},
+			{"detection", c.Detection.Enabled},
+			{"query", c.Query.Enabled},
+			{"intel", c.Intel.Enabled},
+			{"reports", c.Reports.Enabled},
+			{"clock_skew", c.ClockSkew.Enabled},
+			{"routes", len(c.Routes) > 0},
+			{"observability.event_request_id", c.Observability.EventRequestID},
+			{"observability.event_transport", c.Observability.EventTransport},
+		} {
+			if f.on {
+				return fmt.Errorf("output: passthrough cannot be combined with %s", f.name)
+			}
+		}
+		for id, s := range c.Sensors {
+			if s.OrderedDelivery {
+				return fmt.Errorf("output: passthrough cannot be combined with sensors.%s.ordered_delivery", id)
+			}
+		}
+	}
+	if c.Output.DrainTimeoutSeconds < 0 {
+		return fmt.Errorf("output: drain_timeout_seconds must be positive")
+	}
+	if c.Output.HealthCheckIntervalSeconds < 0 {
+		return fmt.Errorf("output: health_check_interval_seconds must be positive")
+	}
+	if c.Output.Outbox.ReadyMaxBytes < 0 {
+		return fmt.Errorf("output: outbox.ready_max_bytes must be positive")
 	}
 	if c.Output.Outbox.MaxBytes < 0 {
 		return fmt.Errorf("output.outbox: max_bytes must be >= 0")
@@ -255,9 +1478,489 @@ func (c *Config) validate() error {
 	if c.Output.Outbox.RetryBackoffMS < 0 || c.Output.Outbox.RetryMaxBackoffMS < 0 {
 		return fmt.Errorf("output.outbox: retry backoff values must be >= 0")
 	}
+	if c.Output.Outbox.DrainMaxFiles < 0 || c.Output.Outbox.DrainWorkers < 0 || c.Output.Outbox.DrainMaxEventsPerSecond < 0 {
+		return fmt.Errorf("output.outbox: drain_max_files, drain_workers and drain_max_events_per_second must be >= 0")
+	}
+	if c.Output.Outbox.BackpressureBytes < 0 || c.Output.Outbox.BackpressureMaxRetryAfterSeconds < 0 {
+		return fmt.Errorf("output.outbox: backpressure_bytes and backpressure_max_retry_after_seconds must be >= 0")
+	}
+	if c.Output.Outbox.BackpressureBytes > 0 && !c.Output.Outbox.Enabled {
+		return fmt.Errorf("output.outbox: backpressure_bytes requires the outbox to be enabled")
+	}
+	switch c.Output.Outbox.DrainPriority {
+	case "live":
+	case "fifo":
+		if c.Output.Outbox.Enabled && c.Output.Type != "clickhouse" {
+			return fmt.Errorf("output.outbox: drain_priority = \"fifo\" requires type=clickhouse")
+		}
+	default:
+		return fmt.Errorf("output.outbox: drain_priority must be \"live\" or \"fifo\"")
+	}
+	switch c.Output.Outbox.Eviction {
+	case "oldest", "fair":
+	default:
+		return fmt.Errorf("output.outbox: eviction must be \"oldest\" or \"fair\"")
+	}
+	if c.Output.Outbox.MaxBytesPerSensor < 0 {
+		return fmt.Errorf("output.outbox: max_bytes_per_sensor must be >= 0")
+	}
+	if (c.Output.Outbox.Eviction == "fair" || c.Output.Outbox.MaxBytesPerSensor > 0) && c.Output.Outbox.Enabled && c.Output.Type != "clickhouse" {
+		return fmt.Errorf("output.outbox: eviction = \"fair\" and max_bytes_per_sensor require type=clickhouse")
+	}
+	if c.Detection.Enabled {
+		switch c.Detection.Output {
+		case "events":
+		case "webhook", "both":
+			if u, err := url.Parse(c.Detection.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("detection: webhook_url must be an http(s) URL when output=%s", c.Detection.Output)
+			}
+		default:
+			return fmt.Errorf("detection: output must be events, webhook or both")
+		}
+		if c.Detection.MaxKeys < 0 {
+			return fmt.Errorf("detection: max_keys must be >= 0")
+		}
+	}
+	if c.Observability.Profiling && c.Observability.AdminToken == "" {
+		return fmt.Errorf("observability: profiling requires admin_token")
+	}
+	if c.Query.Enabled {
+		if c.Observability.AdminToken == "" {
+			return fmt.Errorf("query: observability.admin_token is required (the API exposes event contents)")
+		}
+		if c.Query.MaxEvents < 0 || c.Query.RetentionHours < 0 {
+			return fmt.Errorf("query: max_events and retention_hours must be >= 0")
+		}
+	} else if c.Query.Dashboard {
+		return fmt.Errorf("query: dashboard requires query.enabled")
+	}
+	if c.Intel.Enabled {
+		if c.Intel.Token == "" && c.Observability.AdminToken == "" {
+			return fmt.Errorf("intel: token or observability.admin_token is required")
+		}
+		if c.Intel.MinSensors < 0 || c.Intel.WindowHours < 0 || c.Intel.RefreshSeconds < 0 || c.Intel.MaxKeys < 0 {
+			return fmt.Errorf("intel: min_sensors, window_hours, refresh_seconds and max_keys must be >= 0")
+		}
+		for _, f := range c.Intel.Fields {
+			if !validPath(f) {
+				return fmt.Errorf("intel: invalid field %q", f)
+			}
+		}
+	}
+	if m := c.Intel.MISP; m.Enabled {
+		if !c.Intel.Enabled {
+			return fmt.Errorf("intel.misp: requires intel.enabled")
+		}
+		if u, err := url.Parse(m.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("intel.misp: url must be an http(s) URL")
+		}
+		if m.APIKey == "" {
+			return fmt.Errorf("intel.misp: api_key is required")
+		}
+		if err := validProxy(m.Proxy, false); err != nil {
+			return fmt.Errorf("intel.misp: %w", err)
+		}
+		if m.Distribution < 0 || m.Distribution > 5 || m.ThreatLevel < 1 || m.ThreatLevel > 4 || m.Analysis < 0 || m.Analysis > 2 {
+			return fmt.Errorf("intel.misp: distribution must be 0-5, threat_level_id 1-4 and analysis 0-2")
+		}
+		if m.IntervalSeconds < 0 {
+			return fmt.Errorf("intel.misp: interval_seconds must be >= 0")
+		}
+	}
+	if b := c.Backfill; b.Enabled && (b.MaxBodySizeBytes < 0 || b.MaxEventsPerBatch < 0) {
+		return fmt.Errorf("backfill: max_body_size_bytes and max_events_per_batch must be >= 0")
+	}
+	if cs := c.ClockSkew; cs.Enabled {
+		if cs.ThresholdSeconds < 0 {
+			return fmt.Errorf("clock_skew: threshold_seconds must be positive")
+		}
+	} else if cs.Correct {
+		return fmt.Errorf("clock_skew: correct requires clock_skew.enabled")
+	}
+	if r := c.Reports; r.Enabled {
+		if r.Schedule != "daily" && r.Schedule != "weekly" {
+			return fmt.Errorf("reports: schedule must be daily or weekly")
+		}
+		if r.Hour < 0 || r.Hour > 23 {
+			return fmt.Errorf("reports: hour must be 0-23")
+		}
+		if r.Top < 0 || r.MaxKeys < 0 {
+			return fmt.Errorf("reports: top and max_keys must be >= 0")
+		}
+		if r.WebhookURL == "" && r.SMTPAddr == "" {
+			return fmt.Errorf("reports: webhook_url or smtp_addr is required")
+		}
+		if r.WebhookURL != "" {
+			if u, err := url.Parse(r.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("reports: webhook_url must be an http(s) URL")
+			}
+		}
+		if r.SMTPAddr != "" {
+			if _, _, err := net.SplitHostPort(r.SMTPAddr); err != nil {
+				return fmt.Errorf("reports: smtp_addr must be host:port")
+			}
+			if r.EmailFrom == "" || len(r.EmailTo) == 0 {
+				return fmt.Errorf("reports: smtp_addr requires email_from and email_to")
+			}
+		}
+	}
+	if c.Alerts.Enabled {
+		if u, err := url.Parse(c.Alerts.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alerts: webhook_url must be an http(s) URL")
+		}
+		if c.Alerts.IntervalSeconds < 0 {
+			return fmt.Errorf("alerts: interval_seconds must be positive")
+		}
+		if c.Alerts.SensorSilentMinutes < 0 || c.Alerts.OutboxMaxBytes < 0 || c.Alerts.OutputDownMinutes < 0 {
+			return fmt.Errorf("alerts: thresholds must be >= 0")
+		}
+	}
+	if k := c.Input.Kafka; k.Enabled {
+		if len(k.Brokers) == 0 || len(k.Topics) == 0 {
+			return fmt.Errorf("input.kafka: brokers and topics required")
+		}
+		if k.StartOffset != "latest" && k.StartOffset != "earliest" {
+			return fmt.Errorf("input.kafka: start_offset must be latest or earliest")
+		}
+		switch k.SASLMechanism {
+		case "", "plain", "scram-sha-256", "scram-sha-512":
+		default:
+			return fmt.Errorf("input.kafka: sasl_mechanism must be plain, scram-sha-256 or scram-sha-512")
+		}
+		if k.BatchSize < 0 || k.BatchWaitMS < 0 {
+			return fmt.Errorf("input.kafka: batch_size and batch_wait_ms must be >= 0")
+		}
+	}
+	switch c.Shared.Backend {
+	case "":
+	case "redis":
+		if u, err := url.Parse(c.Shared.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("shared: redis_url must be a redis:// or rediss:// URL")
+		}
+		if c.Shared.TimeoutMS < 0 || c.Shared.PoolSize < 0 {
+			return fmt.Errorf("shared: timeout_ms and pool_size must be >= 0")
+		}
+	default:
+		return fmt.Errorf("shared: unknown backend %q (use redis)", c.Shared.Backend)
+	}
+	if otlp := c.Observability.OTLP; otlp.Enabled {
+		if u, err := url.Parse(otlp.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("observability.otlp: endpoint must be an http(s) URL")
+		}
+		if otlp.IntervalSeconds < 0 || otlp.TimeoutSeconds < 0 {
+			return fmt.Errorf("observability.otlp: interval_seconds and timeout_seconds must be positive")
+		}
+	}
 	return nil
 }
 
+// validateOutput checks the settings of an output (key is its table, for errors) and defaults its type.
+func validateOutput(key string, o *OutputConfig) error {
+	if o.Type == "" {
+		o.Type = "stdout"
+	}
+	if o.Type != "stdout" && o.Type != "elasticsearch" && o.Type != "kafka" && o.Type != "clickhouse" && o.Type != "forward" && o.Type != "gelf" &&
+		o.Type != "eventhubs" && o.Type != "pubsub" && o.Type != "unix" && o.Type != "sqlite" {
+		return fmt.Errorf("%s: unknown type %q", key, o.Type)
+	}
+	if o.ElasticsearchMaxBulkBytes < 0 {
+		return fmt.Errorf("%s: elasticsearch_max_bulk_bytes must be positive", key)
+	}
+	if o.Type == "elasticsearch" && o.ElasticsearchURL == "" {
+		return fmt.Errorf("%s: elasticsearch_url required when type=elasticsearch", key)
+	}
+	if o.Type == "clickhouse" && o.ClickHouseURL == "" {
+		return fmt.Errorf("%s: clickhouse_url required when type=clickhouse", key)
+	}
+	if o.Type == "forward" {
+		if o.ForwardURL == "" || o.ForwardToken == "" {
+			return fmt.Errorf("%s: forward_url and forward_token (or forward_token_file) required when type=forward", key)
+		}
+		if u, err := url.Parse(o.ForwardURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%s: invalid forward_url %q", key, o.ForwardURL)
+		}
+	}
+	if o.Type == "gelf" {
+		u, err := url.Parse(o.GELFURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%s: gelf_url required when type=gelf (udp://, tcp://, http:// or https://)", key)
+		}
+		switch u.Scheme {
+		case "udp", "tcp", "http", "https":
+		default:
+			return fmt.Errorf("%s: gelf_url %q: scheme must be udp, tcp, http or https", key, o.GELFURL)
+		}
+		switch o.GELFCompression {
+		case "", "none":
+		case "gzip", "zlib":
+			if u.Scheme == "tcp" {
+				return fmt.Errorf("%s: gelf_compression: GELF over TCP is sent uncompressed", key)
+			}
+		default:
+			return fmt.Errorf("%s: gelf_compression must be gzip, zlib or none", key)
+		}
+		if o.GELFChunkSize < 0 || o.GELFChunkSize > 0 && o.GELFChunkSize <= 12 {
+			return fmt.Errorf("%s: gelf_chunk_size must be above 12 (the chunk header)", key)
+		}
+	}
+	if o.Type == "eventhubs" {
+		if o.EventHubsConnectionString == "" && (o.EventHubsTenantID == "" || o.EventHubsClientID == "" || o.EventHubsClientSecret == "") {
+			return fmt.Errorf("%s: eventhubs_connection_string (SAS) or eventhubs_tenant_id, eventhubs_client_id and eventhubs_client_secret (Azure AD) required when type=eventhubs", key)
+		}
+		if o.EventHubsConnectionString == "" && (o.EventHubsNamespace == "" || o.EventHubsName == "") {
+			return fmt.Errorf("%s: eventhubs_namespace and eventhubs_name required with Azure AD", key)
+		}
+	}
+	if o.Type == "pubsub" {
+		if o.PubSubProject == "" || o.PubSubTopic == "" {
+			return fmt.Errorf("%s: pubsub_project and pubsub_topic required when type=pubsub", key)
+		}
+		if o.PubSubEndpoint != "" {
+			if u, err := url.Parse(o.PubSubEndpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("%s: invalid pubsub_endpoint %q", key, o.PubSubEndpoint)
+			}
+		}
+	}
+	if o.Type == "unix" {
+		if o.UnixSocket == "" {
+			return fmt.Errorf("%s: unix_socket required when type=unix", key)
+		}
+		if len(o.UnixSocket) > 107 { // sun_path
+			return fmt.Errorf("%s: unix_socket path longer than 107 bytes", key)
+		}
+	}
+	if o.Type == "sqlite" && o.SQLitePath == "" {
+		return fmt.Errorf("%s: sqlite_path required when type=sqlite", key)
+	}
+	if o.SQLiteMaxBytes < -1 {
+		return fmt.Errorf("%s: sqlite_max_bytes must be positive (or -1 for no limit)", key)
+	}
+	if o.Outbox.Enabled && o.Type != "clickhouse" && o.Type != "forward" {
+		return fmt.Errorf("%s: outbox requires type=clickhouse or type=forward", key)
+	}
+	if o.ClickHouseFlatten && o.Type != "clickhouse" {
+		return fmt.Errorf("%s: clickhouse_flatten requires type=clickhouse", key)
+	}
+	if err := validProxy(o.Proxy, false); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if h := o.HTTP; h.MaxIdleConnsPerHost < 0 || h.MaxConnsPerHost < 0 || h.DialTimeoutSeconds < 0 || h.TLSHandshakeTimeoutSeconds < 0 ||
+		h.KeepAliveSeconds < -1 || h.IdleConnTimeoutSeconds < 0 || h.RequestTimeoutSeconds < 0 || h.PingAttempts < 0 {
+		return fmt.Errorf("%s: http settings must be positive (keep_alive_seconds = -1 disables keep-alive probes)", key)
+	}
+	return nil
+}
+
+// RestartRequired lists the config sections that differ between old and updated but are only applied
+// at startup. Tokens, limits, enrichment DB paths and the log level are applied by a reload.
+func RestartRequired(old, updated *Config) []string {
+	var changed []string
+	check := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("server", old.Server, updated.Server)
+	check("output", old.Output, updated.Output)
+	check("outputs", old.Outputs, updated.Outputs)
+	check("routes", old.Routes, updated.Routes)
+	check("observability", old.Observability, updated.Observability)
+	check("alerts", old.Alerts, updated.Alerts)
+	check("detection", old.Detection, updated.Detection)
+	check("query", old.Query, updated.Query)
+	check("intel", old.Intel, updated.Intel)
+	check("reports", old.Reports, updated.Reports)
+	check("clock_skew", old.ClockSkew, updated.ClockSkew)
+	check("backfill", old.Backfill, updated.Backfill)
+	check("hardening", old.Hardening, updated.Hardening)
+	check("shared", old.Shared, updated.Shared)
+	check("input", old.Input, updated.Input)
+	check("normalize", old.Normalize, updated.Normalize)
+	check("transform", old.Transform, updated.Transform)
+	check("strict", old.Strict, updated.Strict)
+	check("artifacts", old.Artifacts, updated.Artifacts)
+	check("sensors", old.Sensors, updated.Sensors)
+	check("tenants", old.Tenants, updated.Tenants)
+	check("sessions", old.Sessions, updated.Sessions)
+	check("rollup", old.Rollup, updated.Rollup)
+	check("logging.format", old.Logging.Format, updated.Logging.Format)
+	check("logging.access_log_sample_every", old.Logging.AccessLogSampleEvery, updated.Logging.AccessLogSampleEvery)
+	oldEnrich, newEnrich := old.Enrichment, updated.Enrichment
+	oldEnrich.GeoIPDBPath, oldEnrich.ASNDBPath = "", ""
+	newEnrich.GeoIPDBPath, newEnrich.ASNDBPath = "", ""
+	check("enrichment", oldEnrich, newEnrich)
+	return changed
+}
+
+// Reloadable returns a copy of old with the settings a reload applies (tokens, limits,
+// enrichment DB paths, log level) taken from updated; see RestartRequired for the rest.
+func Reloadable(old, updated *Config) *Config {
+	applied := *old
+	applied.Auth = updated.Auth
+	applied.Limits = updated.Limits
+	applied.Enrichment.GeoIPDBPath = updated.Enrichment.GeoIPDBPath
+	applied.Enrichment.ASNDBPath = updated.Enrichment.ASNDBPath
+	applied.Logging.Level = updated.Logging.Level
+	return &applied
+}
+
+// Redacted returns a copy of c that is safe to show: tokens are replaced by the sensor IDs
+// they map to and passwords are masked.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Auth.Tokens = make(map[string]string, len(c.Auth.Tokens))
+	for _, sensorID := range c.Auth.Tokens {
+		r.Auth.Tokens["[redacted:"+sensorID+"]"] = sensorID
+	}
+	if r.Output.ElasticsearchPass != "" {
+		r.Output.ElasticsearchPass = redacted
+	}
+	if r.Output.ClickHousePassword != "" {
+		r.Output.ClickHousePassword = redacted
+	}
+	if r.Output.ForwardToken != "" {
+		r.Output.ForwardToken = redacted
+	}
+	if r.Output.EventHubsConnectionString != "" {
+		r.Output.EventHubsConnectionString = redacted
+	}
+	if r.Output.EventHubsClientSecret != "" {
+		r.Output.EventHubsClientSecret = redacted
+	}
+	r.Output.ElasticsearchURL = redactURL(r.Output.ElasticsearchURL)
+	r.Output.ClickHouseURL = redactURL(r.Output.ClickHouseURL)
+	r.Output.ForwardURL = redactURL(r.Output.ForwardURL)
+	r.Output.Proxy = redactURL(r.Output.Proxy)
+	r.Output.GELFURL = redactURL(r.Output.GELFURL)
+	r.Intel.MISP.Proxy = redactURL(r.Intel.MISP.Proxy)
+	r.Enrichment.DNS.Proxy = redactURL(r.Enrichment.DNS.Proxy)
+	r.Enrichment.DNS.Server = redactURL(r.Enrichment.DNS.Server)
+	if len(c.Outputs) > 0 {
+		r.Outputs = make(map[string]OutputConfig, len(c.Outputs))
+		for name, o := range c.Outputs {
+			if o.ElasticsearchPass != "" {
+				o.ElasticsearchPass = redacted
+			}
+			if o.ClickHousePassword != "" {
+				o.ClickHousePassword = redacted
+			}
+			if o.ForwardToken != "" {
+				o.ForwardToken = redacted
+			}
+			if o.EventHubsConnectionString != "" {
+				o.EventHubsConnectionString = redacted
+			}
+			if o.EventHubsClientSecret != "" {
+				o.EventHubsClientSecret = redacted
+			}
+			o.ElasticsearchURL = redactURL(o.ElasticsearchURL)
+			o.ClickHouseURL = redactURL(o.ClickHouseURL)
+			o.ForwardURL = redactURL(o.ForwardURL)
+			o.Proxy = redactURL(o.Proxy)
+			o.GELFURL = redactURL(o.GELFURL)
+			r.Outputs[name] = o
+		}
+	}
+	if r.Observability.AdminToken != "" {
+		r.Observability.AdminToken = redacted
+	}
+	if r.Detection.WebhookURL != "" {
+		r.Detection.WebhookURL = redacted
+	}
+	if r.Alerts.WebhookURL != "" {
+		r.Alerts.WebhookURL = redacted
+	}
+	if r.Shared.RedisURL != "" {
+		r.Shared.RedisURL = redacted
+	}
+	if r.Input.Kafka.Password != "" {
+		r.Input.Kafka.Password = redacted
+	}
+	if r.Intel.Token != "" {
+		r.Intel.Token = redacted
+	}
+	if r.Intel.MISP.APIKey != "" {
+		r.Intel.MISP.APIKey = redacted
+	}
+	if r.Reports.WebhookURL != "" {
+		r.Reports.WebhookURL = redacted
+	}
+	if r.Reports.SMTPPassword != "" {
+		r.Reports.SMTPPassword = redacted
+	}
+	if r.Artifacts.S3SecretKey != "" {
+		r.Artifacts.S3SecretKey = redacted
+	}
+	if len(c.Observability.OTLP.Headers) > 0 {
+		r.Observability.OTLP.Headers = make(map[string]string, len(c.Observability.OTLP.Headers))
+		for k := range c.Observability.OTLP.Headers {
+			r.Observability.OTLP.Headers[k] = redacted
+		}
+	}
+	return &r
+}
+
+const redacted = "[redacted]"
+
+// readSecretFile returns the file content without the trailing newline secret files usually have.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// validProxy accepts an empty proxy setting, "direct", or a proxy URL: socks5 or socks5h, or with
+// socksOnly false also http or https.
+func validProxy(s string, socksOnly bool) error {
+	if s == "" || s == "direct" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return nil
+	case "http", "https":
+		if !socksOnly {
+			return nil
+		}
+	}
+	if socksOnly {
+		return fmt.Errorf("proxy must be a socks5 or socks5h URL")
+	}
+	return fmt.Errorf("proxy must be an http, https, socks5 or socks5h URL")
+}
+
+// redactURL masks the password in a URL setting, such as a proxy with credentials.
+func redactURL(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return s
+}
+
+// validCIDR accepts a CIDR or a bare IP address.
+func validCIDR(s string) bool {
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return true
+	}
+	return net.ParseIP(s) != nil
+}
+
+// validPath accepts a dotted field path without empty parts (e.g. "source.ip").
+func validPath(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
 // TokenToSensor returns the sensor ID for a token, or "" if invalid. Used after Load.
 func (c *Config) TokenToSensor(token string) string {
 	return c.Auth.Tokens[token]
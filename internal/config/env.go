@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of per-key overrides: LOOM_<SECTION>_<KEY>, e.g. LOOM_SERVER_LISTEN_ADDRESS
+// or LOOM_OUTPUT_OUTBOX_MAX_BYTES (nested tables add their name).
+const envPrefix = "LOOM"
+
+// applyEnvOverrides sets every scalar and string-list key from its LOOM_<SECTION>_<KEY> variable.
+// Lists are comma-separated; string maps (observability.otlp.headers) are comma-separated key=value
+// pairs. Tables keyed by name (sensors, outputs, auth.tokens) and arrays of tables (server.certificates,
+// server.listeners, normalize.mappings, transform, routes) are file-only. Empty variables are ignored.
+func applyEnvOverrides(c *Config) error {
+	return envOverrides(reflect.ValueOf(c).Elem(), envPrefix)
+}
+
+func envOverrides(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if tag == "" || tag == "-" || field.Tag.Get("env") == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := envOverrides(fv, name); err != nil {
+				return err
+			}
+			continue
+		}
+		val := os.Getenv(name)
+		if val == "" {
+			continue
+		}
+		if err := setFromEnv(fv, val); err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setFromEnv(fv reflect.Value, val string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return nil // arrays of tables are file-only
+		}
+		parts := strings.Split(val, ",")
+		list := reflect.MakeSlice(fv.Type(), 0, len(parts))
+		for _, p := range parts {
+			if p = strings.TrimSpace(p); p != "" {
+				list = reflect.Append(list, reflect.ValueOf(p).Convert(fv.Type().Elem()))
+			}
+		}
+		fv.Set(list)
+	case reflect.Map:
+		if fv.Type().Key().Kind() != reflect.String || fv.Type().Elem().Kind() != reflect.String {
+			return nil // keyed tables are file-only
+		}
+		m := reflect.MakeMap(fv.Type())
+		for _, p := range strings.Split(val, ",") {
+			k, v, ok := strings.Cut(p, "=")
+			if !ok {
+				return fmt.Errorf("want key=value pairs, got %q", p)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)), reflect.ValueOf(strings.TrimSpace(v)))
+		}
+		fv.Set(m)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad_EnvOverrides(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "loom.toml")
+	content := `
+[server]
+listen_address = ":8080"
+tls = false
+`
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"LOOM_SENSOR_spip01":               "test-token",
+		"LOOM_SERVER_LISTEN_ADDRESS":       ":9443",
+		"LOOM_OUTPUT_OUTBOX_MAX_BYTES":     "1048576",
+		"LOOM_ENRICHMENT_DNS_ENABLED":      "true",
+		"LOOM_ROLLUP_GROUP_BY":             "source.ip, network.transport",
+		"LOOM_SERVER_ALLOW_CIDRS":          "10.0.0.0/8,192.0.2.1",
+		"LOOM_LIMITS_MAX_EVENTS_PER_BATCH": "42",
+		"LOOM_OBSERVABILITY_OTLP_HEADERS":  "api-key=secret, x-tenant=loom",
+	} {
+		t.Setenv(k, v)
+	}
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.ListenAddress != ":9443" {
+		t.Errorf("listen_address = %q; env should override the file", cfg.Server.ListenAddress)
+	}
+	if cfg.Output.Outbox.MaxBytes != 1048576 {
+		t.Errorf("outbox.max_bytes = %d", cfg.Output.Outbox.MaxBytes)
+	}
+	if !cfg.Enrichment.DNS.Enabled {
+		t.Error("enrichment.dns.enabled not set from env")
+	}
+	if len(cfg.Rollup.GroupBy) != 2 || cfg.Rollup.GroupBy[1] != "network.transport" {
+		t.Errorf("rollup.group_by = %v", cfg.Rollup.GroupBy)
+	}
+	if len(cfg.Server.AllowCIDRs) != 2 {
+		t.Errorf("allow_cidrs = %v", cfg.Server.AllowCIDRs)
+	}
+	if cfg.Limits.MaxEventsPerBatch != 42 {
+		t.Errorf("max_events_per_batch = %d", cfg.Limits.MaxEventsPerBatch)
+	}
+	if h := cfg.Observability.OTLP.Headers; len(h) != 2 || h["api-key"] != "secret" || h["x-tenant"] != "loom" {
+		t.Errorf("otlp.headers = %v", h)
+	}
+	if cfg.Server.ReadTimeoutSeconds != 30 {
+		t.Errorf("defaults should still apply: read_timeout_seconds = %d", cfg.Server.ReadTimeoutSeconds)
+	}
+}
+
+func TestLoad_EnvOverrideInvalid(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "loom.toml")
+	if err := os.WriteFile(cfgPath, []byte("[server]\ntls = false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOOM_SENSOR_spip01", "test-token")
+	t.Setenv("LOOM_LIMITS_PER_SENSOR_RPS", "fast")
+	if _, err := Load(cfgPath); err == nil {
+		t.Error("expected error for non-numeric LOOM_LIMITS_PER_SENSOR_RPS")
+	}
+}
@@ -0,0 +1,60 @@
+// Package dashboard serves a single-page web dashboard on the management port. The page itself is
+// static; it asks for the admin token and reads everything from the /api/v1 query API.
+package dashboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/output"
+)
+
+//go:embed index.html
+var page []byte
+
+// Handler serves the dashboard page.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		_, _ = w.Write(page)
+	})
+}
+
+// OutputStatus is the JSON served by GET /api/v1/stats/output.
+type OutputStatus struct {
+	Healthy             bool       `json:"healthy"`
+	Error               string     `json:"error,omitempty"`
+	UnhealthySince      *time.Time `json:"unhealthy_since,omitempty"`
+	FlushOK             uint64     `json:"flush_ok"`
+	FlushFailed         uint64     `json:"flush_failed"`
+	OutboxFiles         int        `json:"outbox_files"`
+	OutboxBytes         int64      `json:"outbox_bytes"`
+	OutboxDroppedEvents int64      `json:"outbox_dropped_events"`
+}
+
+// NewOutputStatus returns a handler reporting the output's health, flush counts and outbox depth.
+func NewOutputStatus(health *output.HealthMonitor, w output.Writer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		stats := output.StatsOf(w)
+		st := OutputStatus{
+			Healthy:             health.Ready(),
+			FlushOK:             stats.FlushOK,
+			FlushFailed:         stats.FlushFailed,
+			OutboxFiles:         stats.OutboxFiles,
+			OutboxBytes:         stats.OutboxBytes,
+			OutboxDroppedEvents: stats.OutboxDroppedEvents,
+		}
+		if err := health.Err(); err != nil {
+			st.Error = err.Error()
+		}
+		if since := health.UnhealthySince(); !since.IsZero() {
+			st.UnhealthySince = &since
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(st)
+	})
+}
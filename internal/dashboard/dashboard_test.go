@@ -0,0 +1,47 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/output"
+)
+
+func TestHandler_ServesPage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status = %d, content-type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "/api/v1") {
+		t.Error("page does not use the query API")
+	}
+}
+
+func TestOutputStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	w, err := output.NewWriter(output.WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := output.NewHealthMonitor(w, time.Second, nil)
+	_ = health.Check(context.Background())
+
+	rec := httptest.NewRecorder()
+	NewOutputStatus(health, w).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/output", nil))
+	var st OutputStatus
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Healthy || st.Error == "" || st.UnhealthySince == nil {
+		t.Errorf("status = %+v, want unhealthy with error", st)
+	}
+}
@@ -0,0 +1,208 @@
+// Package detect evaluates detection rules over the enriched event stream and produces alert events,
+// e.g. "the same source.ip hit more than 5 sensors within 10 minutes".
+package detect
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// maxAlertSensors caps the sensor IDs listed in one alert.
+const maxAlertSensors = 50
+
+// Engine holds the rules and the per-group window state. A nil *Engine matches nothing.
+type Engine struct {
+	rules   []Rule
+	maxKeys int
+	nowFn   func() time.Time
+
+	mu     sync.Mutex
+	groups []map[string]*group // per rule, keyed by the group_by value
+}
+
+// group is the window state of one rule for one group_by value.
+type group struct {
+	times         []time.Time          // matching events in the window (event counting)
+	values        map[string]time.Time // distinct sensors or field values -> last seen
+	sensors       map[string]bool      // sensors that contributed, for the alert
+	last          time.Time
+	cooldownUntil time.Time
+}
+
+// NewEngine creates an engine for rules. maxKeys bounds the tracked groups per rule (default 100000);
+// when full, idle groups are dropped first and then new groups are not tracked.
+func NewEngine(rules []Rule, maxKeys int) *Engine {
+	if maxKeys <= 0 {
+		maxKeys = 100000
+	}
+	groups := make([]map[string]*group, len(rules))
+	for i := range groups {
+		groups[i] = make(map[string]*group)
+	}
+	return &Engine{rules: rules, maxKeys: maxKeys, nowFn: time.Now, groups: groups}
+}
+
+// Len returns the number of loaded rules.
+func (e *Engine) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.rules)
+}
+
+// Observe evaluates every rule against one event from sensorID and returns the alert events it triggers.
+func (e *Engine) Observe(sensorID string, ev event.Event) []event.Event {
+	if e == nil || ev == nil {
+		return nil
+	}
+	var alerts []event.Event
+	now := e.nowFn()
+	for i := range e.rules {
+		r := &e.rules[i]
+		if !r.matches(ev) {
+			continue
+		}
+		key := ""
+		if r.GroupBy != "" {
+			v := ecs.Get(ev, r.GroupBy)
+			if v == nil {
+				continue // nothing to group on
+			}
+			key = valueString(v)
+		}
+		if alert := e.observe(i, r, key, sensorID, ev, now); alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func (e *Engine) observe(idx int, r *Rule, key, sensorID string, ev event.Event, now time.Time) event.Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	groups := e.groups[idx]
+	g := groups[key]
+	if g == nil {
+		if len(groups) >= e.maxKeys {
+			e.purgeIdle(groups, r, now)
+			if len(groups) >= e.maxKeys {
+				return nil
+			}
+		}
+		g = &group{}
+		groups[key] = g
+	}
+	g.last = now
+	if now.Before(g.cooldownUntil) {
+		return nil
+	}
+
+	count := 1
+	if r.Threshold > 0 {
+		count = g.add(r, sensorID, ev, now)
+		if count <= r.Threshold {
+			return nil
+		}
+	} else {
+		g.sensors = map[string]bool{sensorID: true}
+	}
+	alert := newAlert(r, key, count, g.sensorList(), now)
+	*g = group{last: now, cooldownUntil: now.Add(r.cooldown)}
+	return alert
+}
+
+// add records one matching event and returns the count for the rule's window.
+func (g *group) add(r *Rule, sensorID string, ev event.Event, now time.Time) int {
+	cutoff := now.Add(-r.window)
+	if g.sensors == nil {
+		g.sensors = make(map[string]bool)
+	}
+	if len(g.sensors) < maxAlertSensors {
+		g.sensors[sensorID] = true
+	}
+	if !r.DistinctSensors && r.DistinctField == "" {
+		kept := g.times[:0]
+		for _, t := range g.times {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		g.times = append(kept, now)
+		return len(g.times)
+	}
+	value := sensorID
+	if r.DistinctField != "" {
+		v := ecs.Get(ev, r.DistinctField)
+		if v == nil {
+			return len(g.values)
+		}
+		value = valueString(v)
+	}
+	if g.values == nil {
+		g.values = make(map[string]time.Time)
+	}
+	for v, t := range g.values {
+		if !t.After(cutoff) {
+			delete(g.values, v)
+		}
+	}
+	g.values[value] = now
+	return len(g.values)
+}
+
+func (g *group) sensorList() []string {
+	out := make([]string, 0, len(g.sensors))
+	for id := range g.sensors {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// purgeIdle drops groups with no matching event within the window and no active cooldown.
+func (e *Engine) purgeIdle(groups map[string]*group, r *Rule, now time.Time) {
+	for k, g := range groups {
+		if now.Sub(g.last) > r.window && !now.Before(g.cooldownUntil) {
+			delete(groups, k)
+		}
+	}
+}
+
+// newAlert builds the ECS alert event for rule r.
+func newAlert(r *Rule, key string, count int, sensors []string, now time.Time) event.Event {
+	reason := r.Description
+	if reason == "" {
+		reason = r.Name
+	}
+	ev := event.Event{
+		"@timestamp": now.UTC().Format(time.RFC3339Nano),
+		"event": map[string]interface{}{
+			"kind":    "alert",
+			"module":  "loom",
+			"dataset": "loom.detection",
+			"reason":  reason,
+		},
+		"rule": map[string]interface{}{"name": r.Name},
+	}
+	if r.Description != "" {
+		ecs.Map(ev, "rule")["description"] = r.Description
+	}
+	if r.Severity != 0 {
+		ecs.Map(ev, "event")["severity"] = r.Severity
+	}
+	detection := map[string]interface{}{"count": count, "sensors": sensors}
+	if r.GroupBy != "" {
+		ecs.Set(ev, r.GroupBy, key)
+		detection["group_by"] = r.GroupBy
+	}
+	if r.Threshold > 0 {
+		detection["threshold"] = r.Threshold
+		detection["window_seconds"] = r.WindowSeconds
+	}
+	ev["loom"] = map[string]interface{}{"detection": detection}
+	return ev
+}
@@ -0,0 +1,155 @@
+package detect
+
+import (
+	"testing"
+	"time"
+)
+
+func testEvent(ip string, port float64) map[string]interface{} {
+	return map[string]interface{}{
+		"source":      map[string]interface{}{"ip": ip},
+		"destination": map[string]interface{}{"port": port},
+	}
+}
+
+func mustParse(t *testing.T, rules string) []Rule {
+	t.Helper()
+	r, err := parseRules([]byte(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestBuiltinRules(t *testing.T) {
+	rules, err := LoadRules("")
+	if err != nil {
+		t.Fatalf("built-in rules: %v", err)
+	}
+	if len(rules) == 0 {
+		t.Fatal("no built-in rules")
+	}
+}
+
+func TestEngine_DistinctSensorsThreshold(t *testing.T) {
+	e := NewEngine(mustParse(t, `
+[[rule]]
+name = "multi-sensor"
+group_by = "source.ip"
+distinct_sensors = true
+threshold = 2
+window_seconds = 600
+[[rule.match]]
+field = "source.ip"
+exists = true
+`), 0)
+	now := time.Unix(1000, 0)
+	e.nowFn = func() time.Time { return now }
+
+	for _, sensor := range []string{"s1", "s1", "s2"} {
+		if alerts := e.Observe(sensor, testEvent("198.51.100.7", 22)); len(alerts) != 0 {
+			t.Fatalf("alert before threshold: %v", alerts)
+		}
+	}
+	if alerts := e.Observe("s3", testEvent("203.0.113.1", 22)); len(alerts) != 0 {
+		t.Fatal("other source IPs must be counted separately")
+	}
+	alerts := e.Observe("s3", testEvent("198.51.100.7", 22))
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %v, want one when a third sensor is hit", alerts)
+	}
+	a := alerts[0]
+	if a["event"].(map[string]interface{})["kind"] != "alert" || a["source"].(map[string]interface{})["ip"] != "198.51.100.7" {
+		t.Errorf("alert = %v", a)
+	}
+	det := a["loom"].(map[string]interface{})["detection"].(map[string]interface{})
+	if det["count"] != 3 || len(det["sensors"].([]string)) != 3 {
+		t.Errorf("detection = %v", det)
+	}
+	// Cooldown (default window): further hits are suppressed
+	if alerts := e.Observe("s4", testEvent("198.51.100.7", 22)); len(alerts) != 0 {
+		t.Error("alert during cooldown")
+	}
+}
+
+func TestEngine_WindowExpires(t *testing.T) {
+	e := NewEngine(mustParse(t, `
+[[rule]]
+name = "burst"
+group_by = "source.ip"
+threshold = 2
+window_seconds = 60
+[[rule.match]]
+field = "destination.port"
+in = ["22", "23"]
+`), 0)
+	now := time.Unix(1000, 0)
+	e.nowFn = func() time.Time { return now }
+	e.Observe("s1", testEvent("198.51.100.7", 22))
+	e.Observe("s1", testEvent("198.51.100.7", 23))
+	e.Observe("s1", testEvent("198.51.100.7", 80)) // does not match
+	now = now.Add(2 * time.Minute)
+	if alerts := e.Observe("s1", testEvent("198.51.100.7", 22)); len(alerts) != 0 {
+		t.Error("events outside the window must not count")
+	}
+	e.Observe("s1", testEvent("198.51.100.7", 22))
+	if alerts := e.Observe("s1", testEvent("198.51.100.7", 22)); len(alerts) != 1 {
+		t.Error("want alert on the third event within the window")
+	}
+}
+
+func TestEngine_SingleEventRule(t *testing.T) {
+	e := NewEngine(mustParse(t, `
+[[rule]]
+name = "log4shell"
+severity = 7
+[[rule.match]]
+field = "vulnerability.id"
+equals = "CVE-2021-44228"
+`), 0)
+	ev := testEvent("198.51.100.7", 8080)
+	ev["vulnerability"] = map[string]interface{}{"id": []interface{}{"CVE-2021-45046", "CVE-2021-44228"}}
+	alerts := e.Observe("s1", ev)
+	if len(alerts) != 1 || alerts[0]["event"].(map[string]interface{})["severity"] != 7 {
+		t.Fatalf("alerts = %v", alerts)
+	}
+	if alerts := e.Observe("s1", testEvent("198.51.100.7", 8080)); len(alerts) != 0 {
+		t.Error("event without the CVE must not alert")
+	}
+}
+
+func TestMatcher_NotAndNumbers(t *testing.T) {
+	rules := mustParse(t, `
+[[rule]]
+name = "external-ssh"
+[[rule.match]]
+field = "destination.port"
+equals = "22"
+[[rule.match]]
+field = "source.internal"
+equals = "true"
+not = true
+`)
+	ev := testEvent("198.51.100.7", 22)
+	if !rules[0].matches(ev) {
+		t.Error("port 22 from external source should match")
+	}
+	ev["source"].(map[string]interface{})["internal"] = true
+	if rules[0].matches(ev) {
+		t.Error("internal source should not match")
+	}
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+	for name, rules := range map[string]string{
+		"no match":        "[[rule]]\nname = \"x\"\n",
+		"two conditions":  "[[rule]]\nname = \"x\"\n[[rule.match]]\nfield = \"a\"\nequals = \"1\"\ncontains = \"1\"\n",
+		"no window":       "[[rule]]\nname = \"x\"\nthreshold = 3\n[[rule.match]]\nfield = \"a\"\nexists = true\n",
+		"bad regex":       "[[rule]]\nname = \"x\"\n[[rule.match]]\nfield = \"a\"\nregex = \"(\"\n",
+		"distinct, no th": "[[rule]]\nname = \"x\"\ndistinct_sensors = true\n[[rule.match]]\nfield = \"a\"\nexists = true\n",
+	} {
+		if _, err := parseRules([]byte(rules)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
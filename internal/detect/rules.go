@@ -0,0 +1,195 @@
+package detect
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+//go:embed rules/detection.toml
+var builtinRules []byte
+
+// Rule raises an alert when events match all of its matchers. Without a threshold every matching
+// event alerts (subject to the cooldown); with one, the alert fires once more than Threshold matching
+// events (or distinct sensors / field values) are seen for the same GroupBy value within the window.
+type Rule struct {
+	Name        string    `toml:"name"`
+	Description string    `toml:"description"`
+	Severity    int       `toml:"severity"` // event.severity of the alert; 0 omits it
+	Match       []Matcher `toml:"match"`
+
+	GroupBy         string `toml:"group_by"`         // event field the threshold is counted per, e.g. source.ip
+	Threshold       int    `toml:"threshold"`        // fire when the count exceeds this; 0 alerts on every match
+	WindowSeconds   int    `toml:"window_seconds"`   // sliding window for the count
+	DistinctSensors bool   `toml:"distinct_sensors"` // count distinct sensors instead of events
+	DistinctField   string `toml:"distinct_field"`   // count distinct values of this field instead of events
+	// CooldownSeconds suppresses repeat alerts for the same group after firing (default window_seconds).
+	CooldownSeconds int `toml:"cooldown_seconds"`
+
+	window   time.Duration
+	cooldown time.Duration
+}
+
+// Matcher tests one event field. Arrays match when any element does. Exactly one of Equals, In,
+// Contains, Regex or Exists is used; Not inverts the result.
+type Matcher struct {
+	Field    string   `toml:"field"`
+	Equals   string   `toml:"equals"`
+	In       []string `toml:"in"`
+	Contains string   `toml:"contains"` // case-insensitive substring
+	Regex    string   `toml:"regex"`
+	Exists   bool     `toml:"exists"`
+	Not      bool     `toml:"not"`
+
+	re       *regexp.Regexp
+	contains string
+}
+
+// LoadRules reads rules from path, or the built-in pack when path is "".
+func LoadRules(path string) ([]Rule, error) {
+	data := builtinRules
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("detection: %w", err)
+		}
+		data = b
+	}
+	return parseRules(data)
+}
+
+func parseRules(data []byte) ([]Rule, error) {
+	var pack struct {
+		Rule []Rule `toml:"rule"`
+	}
+	if _, err := toml.Decode(string(data), &pack); err != nil {
+		return nil, fmt.Errorf("detection: parse rules: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i := range pack.Rule {
+		r := &pack.Rule[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("detection: rule %d: name is required", i+1)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("detection: duplicate rule %q", r.Name)
+		}
+		seen[r.Name] = true
+		if len(r.Match) == 0 {
+			return nil, fmt.Errorf("detection: rule %q: at least one match is required", r.Name)
+		}
+		for j := range r.Match {
+			if err := r.Match[j].compile(); err != nil {
+				return nil, fmt.Errorf("detection: rule %q: %w", r.Name, err)
+			}
+		}
+		if r.Threshold < 0 || r.WindowSeconds < 0 || r.CooldownSeconds < 0 {
+			return nil, fmt.Errorf("detection: rule %q: threshold, window_seconds and cooldown_seconds must be >= 0", r.Name)
+		}
+		if r.Threshold > 0 && r.WindowSeconds == 0 {
+			return nil, fmt.Errorf("detection: rule %q: threshold requires window_seconds", r.Name)
+		}
+		if (r.DistinctSensors || r.DistinctField != "") && r.Threshold == 0 {
+			return nil, fmt.Errorf("detection: rule %q: distinct counting requires a threshold", r.Name)
+		}
+		if r.DistinctSensors && r.DistinctField != "" {
+			return nil, fmt.Errorf("detection: rule %q: use distinct_sensors or distinct_field, not both", r.Name)
+		}
+		r.window = time.Duration(r.WindowSeconds) * time.Second
+		r.cooldown = r.window
+		if r.CooldownSeconds > 0 {
+			r.cooldown = time.Duration(r.CooldownSeconds) * time.Second
+		}
+	}
+	return pack.Rule, nil
+}
+
+func (m *Matcher) compile() error {
+	if m.Field == "" {
+		return fmt.Errorf("match: field is required")
+	}
+	set := 0
+	for _, ok := range []bool{m.Equals != "", len(m.In) > 0, m.Contains != "", m.Regex != "", m.Exists} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("match %q: exactly one of equals, in, contains, regex or exists is required", m.Field)
+	}
+	if m.Regex != "" {
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("match %q: %w", m.Field, err)
+		}
+		m.re = re
+	}
+	m.contains = strings.ToLower(m.Contains)
+	return nil
+}
+
+// matches reports whether every matcher of r accepts event.
+func (r *Rule) matches(event event.Event) bool {
+	for i := range r.Match {
+		if !r.Match[i].matches(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Matcher) matches(event event.Event) bool {
+	v := ecs.Get(event, m.Field)
+	var ok bool
+	if m.Exists {
+		ok = v != nil
+	} else if list, isList := v.([]interface{}); isList {
+		for _, el := range list {
+			if m.matchValue(el) {
+				ok = true
+				break
+			}
+		}
+	} else if v != nil {
+		ok = m.matchValue(v)
+	}
+	return ok != m.Not
+}
+
+func (m *Matcher) matchValue(v interface{}) bool {
+	s := valueString(v)
+	switch {
+	case m.Equals != "":
+		return s == m.Equals
+	case len(m.In) > 0:
+		for _, want := range m.In {
+			if s == want {
+				return true
+			}
+		}
+		return false
+	case m.re != nil:
+		return m.re.MatchString(s)
+	default:
+		return strings.Contains(strings.ToLower(s), m.contains)
+	}
+}
+
+// valueString formats a JSON value for comparison; numbers print without exponent or trailing zeros.
+func valueString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%f", x), "0"), ".")
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,94 @@
+// Package ecs provides helpers for reading and writing dotted ECS field paths
+// (e.g. "source.geo.country_iso_code") on decoded events.
+package ecs
+
+import "strings"
+
+// Get returns the value at the dotted path, or nil if any part of the path is missing.
+func Get(event map[string]interface{}, path string) interface{} {
+	var cur interface{} = event
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[key]
+	}
+	return cur
+}
+
+// GetString returns the string at the dotted path, or "" if missing or not a string.
+func GetString(event map[string]interface{}, path string) string {
+	s, _ := Get(event, path).(string)
+	return s
+}
+
+// Set stores value at the dotted path, creating intermediate maps as needed.
+// Intermediate non-map values are replaced; SetNew keeps them.
+func Set(event map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	cur := event
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[key] = next
+		}
+		cur = next
+	}
+	cur[keys[len(keys)-1]] = value
+}
+
+// SetNew stores value at the dotted path like Set, unless the path is taken: it returns false and
+// leaves event unchanged when the path holds a value or passes through a non-map value.
+func SetNew(event map[string]interface{}, path string, value interface{}) bool {
+	keys := strings.Split(path, ".")
+	cur := event
+	for i, key := range keys[:len(keys)-1] {
+		v, exists := cur[key]
+		if !exists {
+			Set(cur, strings.Join(keys[i:], "."), value)
+			return true
+		}
+		next, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		cur = next
+	}
+	last := keys[len(keys)-1]
+	if _, exists := cur[last]; exists {
+		return false
+	}
+	cur[last] = value
+	return true
+}
+
+// Delete removes the value at the dotted path and reports whether it existed.
+func Delete(event map[string]interface{}, path string) bool {
+	keys := strings.Split(path, ".")
+	cur := event
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		cur = next
+	}
+	last := keys[len(keys)-1]
+	if _, ok := cur[last]; !ok {
+		return false
+	}
+	delete(cur, last)
+	return true
+}
+
+// Map returns the nested map at key, creating it if missing or not a map.
+func Map(event map[string]interface{}, key string) map[string]interface{} {
+	m, ok := event[key].(map[string]interface{})
+	if !ok {
+		m = make(map[string]interface{})
+		event[key] = m
+	}
+	return m
+}
@@ -0,0 +1,61 @@
+package ecs
+
+import "testing"
+
+func TestGetSetDelete(t *testing.T) {
+	ev := map[string]interface{}{
+		"source": map[string]interface{}{"ip": "1.2.3.4"},
+	}
+	if got := GetString(ev, "source.ip"); got != "1.2.3.4" {
+		t.Errorf("GetString(source.ip) = %q", got)
+	}
+	if got := Get(ev, "source.geo.country_iso_code"); got != nil {
+		t.Errorf("Get(missing) = %v, want nil", got)
+	}
+	if got := Get(ev, "source.ip.nested"); got != nil {
+		t.Errorf("Get(through non-map) = %v, want nil", got)
+	}
+
+	Set(ev, "source.geo.country_iso_code", "NL")
+	if got := GetString(ev, "source.geo.country_iso_code"); got != "NL" {
+		t.Errorf("after Set: %q", got)
+	}
+	if GetString(ev, "source.ip") != "1.2.3.4" {
+		t.Error("Set should preserve sibling fields")
+	}
+
+	if !Delete(ev, "source.ip") {
+		t.Error("Delete(source.ip) should report true")
+	}
+	if Delete(ev, "source.ip") {
+		t.Error("second Delete should report false")
+	}
+	if Delete(ev, "nope.x") {
+		t.Error("Delete on missing parent should report false")
+	}
+}
+
+func TestSetNew(t *testing.T) {
+	ev := map[string]interface{}{"source": "x", "destination": map[string]interface{}{"ip": "1.2.3.4"}}
+	if SetNew(ev, "source.ip", "2.2.2.2") || ev["source"] != "x" {
+		t.Errorf("SetNew through a scalar parent should fail and keep it: %v", ev)
+	}
+	if SetNew(ev, "destination.ip", "2.2.2.2") || GetString(ev, "destination.ip") != "1.2.3.4" {
+		t.Error("SetNew should not replace a value")
+	}
+	if !SetNew(ev, "destination.geo.city_name", "Amsterdam") || !SetNew(ev, "host.name", "h") {
+		t.Error("SetNew on a free path should succeed")
+	}
+	if GetString(ev, "destination.geo.city_name") != "Amsterdam" || GetString(ev, "host.name") != "h" {
+		t.Errorf("after SetNew: %v", ev)
+	}
+}
+
+func TestMap(t *testing.T) {
+	ev := map[string]interface{}{"observer": "not-a-map"}
+	m := Map(ev, "observer")
+	m["id"] = "spip-001"
+	if GetString(ev, "observer.id") != "spip-001" {
+		t.Error("Map should replace non-map value with a map stored in the event")
+	}
+}
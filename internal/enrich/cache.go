@@ -0,0 +1,132 @@
+package enrich
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache is a bounded in-memory cache with per-entry expiry, used to memoize DB lookups per IP.
+// When full, expired entries are purged first; if still full, arbitrary entries are evicted.
+type ttlCache[V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]ttlEntry[V]
+	nowFn      func() time.Time
+}
+
+type ttlEntry[V any] struct {
+	val V
+	exp time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration, maxEntries int) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]ttlEntry[V]),
+		nowFn:      time.Now,
+	}
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	if c.nowFn().After(e.exp) {
+		delete(c.entries, key)
+		return zero, false
+	}
+	return e.val, true
+}
+
+func (c *ttlCache[V]) put(key string, val V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.nowFn()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if now.After(e.exp) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full: evict about 10% (map order is random) to amortize the purge.
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries-c.maxEntries/10 {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ttlEntry[V]{val: val, exp: now.Add(c.ttl)}
+}
+
+// savedEntry is a cache entry in a snapshot (see SaveCaches).
+type savedEntry[V any] struct {
+	Val V
+	Exp time.Time
+}
+
+// snapshot returns the unexpired entries.
+func (c *ttlCache[V]) snapshot() map[string]savedEntry[V] {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.nowFn()
+	out := make(map[string]savedEntry[V], len(c.entries))
+	for k, e := range c.entries {
+		if !now.After(e.exp) {
+			out[k] = savedEntry[V]{Val: e.val, Exp: e.exp}
+		}
+	}
+	return out
+}
+
+// restore adds the unexpired entries of a snapshot, keeping their expiry, up to maxEntries.
+func (c *ttlCache[V]) restore(saved map[string]savedEntry[V]) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.nowFn()
+	for k, e := range saved {
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+		if !now.After(e.Exp) {
+			c.entries[k] = ttlEntry[V]{val: e.Val, exp: e.Exp}
+		}
+	}
+}
+
+// purge drops all entries (e.g. after the underlying DB changed).
+func (c *ttlCache[V]) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entries = make(map[string]ttlEntry[V])
+	c.mu.Unlock()
+}
+
+func (c *ttlCache[V]) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,49 @@
+package enrich
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache_GetPutExpire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newTTLCache[int](time.Minute, 10)
+	c.nowFn = func() time.Time { return now }
+
+	if _, ok := c.get("a"); ok {
+		t.Fatal("empty cache should miss")
+	}
+	c.put("a", 1)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Fatalf("get(a) = %v, %v", v, ok)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Error("expired entry should miss")
+	}
+	if c.len() != 0 {
+		t.Errorf("expired entry should be removed on get, len = %d", c.len())
+	}
+}
+
+func TestTTLCache_Bounded(t *testing.T) {
+	c := newTTLCache[int](time.Hour, 10)
+	for i := 0; i < 100; i++ {
+		c.put(string(rune('a'+i)), i)
+	}
+	if n := c.len(); n > 10 {
+		t.Errorf("cache len = %d, want <= 10", n)
+	}
+	c.purge()
+	if c.len() != 0 {
+		t.Error("purge should empty the cache")
+	}
+}
+
+func TestTTLCache_Nil(t *testing.T) {
+	var c *ttlCache[int]
+	c.put("a", 1)
+	if _, ok := c.get("a"); ok {
+		t.Error("nil cache should always miss")
+	}
+}
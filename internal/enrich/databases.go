@@ -0,0 +1,99 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// DBInfo describes an open MaxMind database.
+type DBInfo struct {
+	Name      string    `json:"name"` // "geo" or "asn"
+	Path      string    `json:"path"`
+	Type      string    `json:"type"` // database_type from the metadata, e.g. GeoLite2-City
+	BuildTime time.Time `json:"build_time"`
+	// Stale is set when the database is older than the configured maximum age.
+	Stale bool `json:"stale"`
+	// Error is set when the test lookup failed; the enricher then reports not ready.
+	Error string `json:"error,omitempty"`
+}
+
+// probeIP is looked up in each database to check that it answers. Whether it is found does not
+// matter, only that the lookup does not fail.
+var probeIP = net.IPv4(1, 1, 1, 1)
+
+// Databases returns the open MaxMind databases, each checked with a test lookup.
+func (e *Enricher) Databases() []DBInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	now := time.Now()
+	var out []DBInfo
+	add := func(name, path string, db *geoip2.Reader, probe func() error) {
+		if db == nil {
+			return
+		}
+		meta := db.Metadata()
+		info := DBInfo{Name: name, Path: path, Type: meta.DatabaseType, BuildTime: time.Unix(int64(meta.BuildEpoch), 0).UTC()}
+		info.Stale = e.maxDBAge > 0 && now.Sub(info.BuildTime) > e.maxDBAge
+		if err := probe(); err != nil {
+			info.Error = err.Error()
+		}
+		out = append(out, info)
+	}
+	add("geo", e.geoPath, e.geoDB, func() error { _, err := e.geoDB.City(probeIP); return err })
+	add("asn", e.asnPath, e.asnDB, func() error { _, err := e.asnDB.ASN(probeIP); return err })
+	return out
+}
+
+// Ready reports whether every open database answers a test lookup. Without databases the
+// enricher passes events through and is always ready.
+func (e *Enricher) Ready() bool {
+	for _, db := range e.Databases() {
+		if db.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// logDatabases logs the build date of each open database and warns about stale ones.
+func (e *Enricher) logDatabases() {
+	for _, db := range e.Databases() {
+		ev := e.log.Info()
+		if db.Stale || db.Error != "" {
+			ev = e.log.Warn()
+		}
+		if db.Error != "" {
+			ev = ev.Str("error", db.Error)
+		}
+		msg := "enrichment database loaded"
+		if db.Stale {
+			msg = "enrichment database is older than enrichment.db_max_age_days; update it"
+		}
+		ev.Str("db", db.Name).Str("path", db.Path).Str("type", db.Type).Time("build_time", db.BuildTime).Msg(msg)
+	}
+}
+
+// RunDBChecks logs a warning for each stale or failing database every interval until ctx is done.
+func (e *Enricher) RunDBChecks(ctx context.Context, interval time.Duration) {
+	if e == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, db := range e.Databases() {
+				if db.Stale || db.Error != "" {
+					e.log.Warn().Str("db", db.Name).Str("path", db.Path).Time("build_time", db.BuildTime).Str("error", db.Error).
+						Bool("stale", db.Stale).Msg("enrichment database needs attention")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// writeTestMMDB writes a minimal IPv4 MaxMind database without data (every lookup finds
+// nothing) of type dbType, built at built.
+func writeTestMMDB(t *testing.T, dbType string, built time.Time) string {
+	t.Helper()
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	u16 := func(v uint16) []byte { return []byte{0xa2, byte(v >> 8), byte(v)} }
+	var b []byte
+	b = append(b, 0, 0, 1, 0, 0, 1)    // one node, both records point past the tree: not found
+	b = append(b, make([]byte, 16)...) // data section separator
+	b = append(b, "\xab\xcd\xefMaxMind.com"...)
+	b = append(b, 0xe9) // metadata map of 9 entries
+	b = append(b, str("node_count")...)
+	b = append(b, 0xc1, 1) // uint32 1
+	b = append(b, str("record_size")...)
+	b = append(b, u16(24)...)
+	b = append(b, str("ip_version")...)
+	b = append(b, u16(4)...)
+	b = append(b, str("database_type")...)
+	b = append(b, str(dbType)...)
+	b = append(b, str("languages")...)
+	b = append(b, 0x00, 0x04) // empty array
+	b = append(b, str("binary_format_major_version")...)
+	b = append(b, u16(2)...)
+	b = append(b, str("binary_format_minor_version")...)
+	b = append(b, u16(0)...)
+	b = append(b, str("build_epoch")...)
+	epoch := uint64(built.Unix())
+	b = append(b, 0x08, 0x02) // uint64, 8 bytes
+	for i := 7; i >= 0; i-- {
+		b = append(b, byte(epoch>>(8*i)))
+	}
+	b = append(b, str("description")...)
+	b = append(b, 0xe0) // empty map
+	path := filepath.Join(t.TempDir(), dbType+".mmdb")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnricher_Databases(t *testing.T) {
+	built := time.Now().Add(-60 * 24 * time.Hour).Truncate(time.Second).UTC()
+	geo := writeTestMMDB(t, "GeoLite2-City", built)
+	asn := writeTestMMDB(t, "GeoLite2-ASN", time.Now().Truncate(time.Second))
+	e, err := NewEnricher(Config{GeoIPDBPath: geo, ASNDBPath: asn, MaxDBAge: 30 * 24 * time.Hour}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	dbs := e.Databases()
+	if len(dbs) != 2 {
+		t.Fatalf("Databases() = %+v, want geo and asn", dbs)
+	}
+	if g := dbs[0]; g.Name != "geo" || g.Path != geo || g.Type != "GeoLite2-City" || !g.BuildTime.Equal(built) || !g.Stale || g.Error != "" {
+		t.Errorf("geo = %+v, want a stale GeoLite2-City built %v", g, built)
+	}
+	if a := dbs[1]; a.Name != "asn" || a.Type != "GeoLite2-ASN" || a.Stale || a.Error != "" {
+		t.Errorf("asn = %+v, want a current GeoLite2-ASN", a)
+	}
+	if !e.Ready() {
+		t.Error("databases answering lookups: want ready")
+	}
+
+	// A City database opened as ASN fails its probe lookup.
+	if err := e.Reload(geo, geo); err != nil {
+		t.Fatal(err)
+	}
+	if dbs := e.Databases(); len(dbs) != 2 || dbs[1].Error == "" {
+		t.Errorf("Databases() = %+v, want an error for asn", dbs)
+	}
+	if e.Ready() {
+		t.Error("failing probe lookup: want not ready")
+	}
+}
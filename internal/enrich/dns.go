@@ -1,19 +1,29 @@
 package enrich
 
 import (
+	"context"
+	"errors"
 	"net"
 	"sync"
 	"time"
 )
 
 // DNSEnricher performs reverse DNS (PTR) lookups with in-memory cache and rate limiting.
+//
+// IPv6 scanners tend to rotate through the addresses of one /64, which rarely has PTR records
+// for more than a few of them. So for IPv6 an address without a name also caches "no name" for
+// its /64 (its other addresses are not looked up until that expires), and each /64 gets at most
+// a tenth of maxQPS, so that one scanner cannot use up the lookups of the others.
 type DNSEnricher struct {
-	cache     map[string]cacheEntry
+	cache     map[string]cacheEntry // address or /64 prefix (for IPv6 without names) -> name
 	cacheTTL  time.Duration
 	maxQPS    int
 	qpsTicker time.Time
 	qpsCount  int
+	prefixQPS map[string]int // lookups per /64 in the current second
 	mu        sync.Mutex
+	metrics   *Metrics
+	resolver  PTRResolver // nil: the system resolver
 }
 
 type cacheEntry struct {
@@ -27,36 +37,82 @@ func NewDNSEnricher(cacheTTL time.Duration, maxQPS int) *DNSEnricher {
 		maxQPS = 10
 	}
 	return &DNSEnricher{
-		cache:    make(map[string]cacheEntry),
-		cacheTTL: cacheTTL,
-		maxQPS:   maxQPS,
+		cache:     make(map[string]cacheEntry),
+		cacheTTL:  cacheTTL,
+		maxQPS:    maxQPS,
+		prefixQPS: make(map[string]int),
 	}
 }
 
+// ptrKeys returns the cache key of ip, its address in canonical form (IPv4-mapped IPv6 as IPv4),
+// and for IPv6 the key of its /64.
+func ptrKeys(ip net.IP) (key, prefix string) {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String(), ""
+	}
+	return ip.String(), ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// SetResolver makes lookups use r instead of the system resolver, e.g. one through a proxy or
+// over TLS or HTTPS. Call it before the first lookup.
+func (d *DNSEnricher) SetResolver(r PTRResolver) {
+	d.resolver = r
+}
+
 // LookupPTR returns the PTR name for ip, from cache or lookup, rate-limited. Empty string if none.
 func (d *DNSEnricher) LookupPTR(ip net.IP) string {
-	key := ip.String()
+	if ip == nil {
+		return ""
+	}
+	key, prefix := ptrKeys(ip)
+	now := time.Now()
 	d.mu.Lock()
-	if e, ok := d.cache[key]; ok && time.Now().Before(e.exp) {
+	if e, ok := d.cache[key]; ok && now.Before(e.exp) {
 		d.mu.Unlock()
+		d.metrics.IncCacheHit("dns")
 		return e.name
 	}
-	now := time.Now()
+	if e, ok := d.cache[prefix]; ok && prefix != "" && now.Before(e.exp) {
+		d.mu.Unlock()
+		d.metrics.IncCacheHit("dns")
+		return ""
+	}
 	if now.Sub(d.qpsTicker) >= time.Second {
 		d.qpsTicker = now
 		d.qpsCount = 0
+		clear(d.prefixQPS)
 	}
-	if d.qpsCount >= d.maxQPS {
+	if d.qpsCount >= d.maxQPS || (prefix != "" && d.prefixQPS[prefix] >= max(1, d.maxQPS/10)) {
 		d.mu.Unlock()
+		d.metrics.IncLookup("dns", resultRateLimited)
 		return ""
 	}
 	d.qpsCount++
+	if prefix != "" {
+		d.prefixQPS[prefix]++
+	}
 	d.mu.Unlock()
 
-	ptr, err := net.LookupAddr(key)
+	var resolver PTRResolver = net.DefaultResolver
+	if d.resolver != nil {
+		resolver = d.resolver
+	}
+	ptr, err := resolver.LookupAddr(context.Background(), key)
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil && len(ptr) > 0:
+		d.metrics.IncLookup("dns", resultOK)
+	case err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound):
+		d.metrics.IncLookup("dns", resultNotFound)
+	default:
+		d.metrics.IncLookup("dns", resultError)
+	}
 	if err != nil || len(ptr) == 0 {
 		d.mu.Lock()
 		d.cache[key] = cacheEntry{name: "", exp: now.Add(d.cacheTTL)}
+		if prefix != "" && (err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound)) {
+			d.cache[prefix] = cacheEntry{name: "", exp: now.Add(d.cacheTTL)}
+		}
 		d.mu.Unlock()
 		return ""
 	}
@@ -69,3 +125,45 @@ func (d *DNSEnricher) LookupPTR(ip net.IP) string {
 	d.mu.Unlock()
 	return name
 }
+
+// snapshot returns the unexpired PTR results, including the empty ones of failed lookups.
+func (d *DNSEnricher) snapshot() map[string]savedEntry[string] {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	out := make(map[string]savedEntry[string], len(d.cache))
+	for k, e := range d.cache {
+		if now.Before(e.exp) {
+			out[k] = savedEntry[string]{Val: e.name, Exp: e.exp}
+		}
+	}
+	return out
+}
+
+// restore adds the unexpired PTR results of a snapshot.
+func (d *DNSEnricher) restore(saved map[string]savedEntry[string]) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, e := range saved {
+		if now.Before(e.Exp) {
+			d.cache[k] = cacheEntry{name: e.Val, exp: e.Exp}
+		}
+	}
+}
+
+// cacheLen returns the number of cached PTR results (including expired ones not yet overwritten).
+func (d *DNSEnricher) cacheLen() int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.cache)
+}
@@ -0,0 +1,77 @@
+package enrich
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingResolver answers from names and counts the lookups per address.
+type countingResolver struct {
+	mu    sync.Mutex
+	names map[string]string
+	calls map[string]int
+}
+
+func (r *countingResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[addr]++
+	if name, ok := r.names[addr]; ok {
+		return []string{name + "."}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func TestDNSEnricher_IPv6(t *testing.T) {
+	r := &countingResolver{names: map[string]string{"2001:db8:1::1": "scanner.example.net", "192.0.2.7": "v4.example.net"}, calls: map[string]int{}}
+	d := NewDNSEnricher(time.Hour, 100)
+	d.SetResolver(r)
+
+	// Different spellings of one address share a cache entry
+	for _, s := range []string{"2001:db8:1::1", "2001:0db8:0001:0000:0000:0000:0000:0001"} {
+		if got := d.LookupPTR(net.ParseIP(s)); got != "scanner.example.net" {
+			t.Errorf("LookupPTR(%s) = %q", s, got)
+		}
+	}
+	if got := d.LookupPTR(net.ParseIP("::ffff:192.0.2.7")); got != "v4.example.net" || r.calls["192.0.2.7"] != 1 {
+		t.Errorf("IPv4-mapped address: %q, lookups %v", got, r.calls)
+	}
+
+	// An address without a name stands for its /64: the scanner's next addresses are not looked up
+	for i := 1; i <= 50; i++ {
+		ip := net.ParseIP("2001:db8:2::")
+		ip[15] = byte(i)
+		d.LookupPTR(ip)
+	}
+	if n := len(r.calls); n != 3 {
+		t.Errorf("%d addresses looked up, want 3: %v", n, r.calls)
+	}
+	if r.calls["2001:db8:1::1"] != 1 {
+		t.Errorf("cached address looked up %d times", r.calls["2001:db8:1::1"])
+	}
+}
+
+func TestDNSEnricher_PrefixRateLimit(t *testing.T) {
+	r := &countingResolver{names: map[string]string{}, calls: map[string]int{}}
+	for i := 1; i <= 50; i++ {
+		ip := net.ParseIP("2001:db8:3::")
+		ip[15] = byte(i)
+		r.names[ip.String()] = "host.example.net"
+	}
+	d := NewDNSEnricher(time.Hour, 100)
+	d.SetResolver(r)
+	for i := 1; i <= 50; i++ {
+		ip := net.ParseIP("2001:db8:3::")
+		ip[15] = byte(i)
+		d.LookupPTR(ip)
+	}
+	if n := len(r.calls); n != 10 {
+		t.Errorf("%d lookups in one /64 within a second, want 10 (a tenth of max_qps)", n)
+	}
+	if d.LookupPTR(net.ParseIP("2001:db8:4::1")); r.calls["2001:db8:4::1"] != 1 {
+		t.Error("another /64 should still be looked up")
+	}
+}
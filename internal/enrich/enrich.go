@@ -3,7 +3,10 @@ package enrich
 import (
 	"net"
 	"sync"
+	"time"
 
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/rs/zerolog"
 )
@@ -12,32 +15,134 @@ import (
 type Enricher struct {
 	geoDB   *geoip2.Reader
 	asnDB   *geoip2.Reader
+	geoPath string // paths of geoDB and asnDB, for Databases
+	asnPath string
 	dns     *DNSEnricher
-	log     zerolog.Logger
-	mu      sync.RWMutex
+	payload *PayloadHasher
+	sigs    *SignatureMatcher
+	metrics *Metrics
+
+	// Optional per-IP memoization of DB results; nil when disabled.
+	asnCache  *ttlCache[*geoip2.ASN]
+	cityCache *ttlCache[*geoip2.City]
+	cachePath string // snapshot file of the caches; "" when not persisted
+	maxDBAge  time.Duration
+
+	classifyInternal    bool
+	skipInternalLookups bool
+	log                 zerolog.Logger
+	mu                  sync.RWMutex
+}
+
+// Config selects the enrichment stages. The zero value enriches nothing but related.*.
+type Config struct {
+	GeoIPDBPath string            // MaxMind City DB; "" to skip GEO
+	ASNDBPath   string            // MaxMind ASN DB; "" to skip ASN
+	DNS         *DNSEnricher      // optional PTR lookups
+	Payload     *PayloadHasher    // optional payload decoding and hashing
+	Signatures  *SignatureMatcher // optional CVE/tool tagging
+	Metrics     *Metrics          // optional per-stage lookup counters and timings
+
+	// CacheSize > 0 memoizes ASN and GEO results per IP for CacheTTL (default 1h).
+	CacheSize int
+	CacheTTL  time.Duration
+	// CachePath, if set, is where SaveCaches keeps the ASN, GEO and DNS caches across restarts;
+	// NewEnricher loads it.
+	CachePath string
+
+	// MaxDBAge > 0 marks databases built longer ago than this as stale (see Databases).
+	MaxDBAge time.Duration
+
+	// ClassifyInternal sets source.internal from source.ip.
+	ClassifyInternal bool
+	// SkipInternalLookups skips ASN/GEO/DNS lookups for private, loopback, link-local and bogon source IPs.
+	SkipInternalLookups bool
 }
 
-// NewEnricher opens MaxMind DBs and optional DNS enricher. geoPath and asnPath can be "" to skip.
-func NewEnricher(geoPath, asnPath string, dns *DNSEnricher, log zerolog.Logger) (*Enricher, error) {
-	e := &Enricher{log: log, dns: dns}
+// NewEnricher opens MaxMind DBs and wires the optional stages from cfg.
+func NewEnricher(cfg Config, log zerolog.Logger) (*Enricher, error) {
+	e := &Enricher{
+		log:                 log,
+		dns:                 cfg.DNS,
+		payload:             cfg.Payload,
+		sigs:                cfg.Signatures,
+		metrics:             cfg.Metrics,
+		cachePath:           cfg.CachePath,
+		maxDBAge:            cfg.MaxDBAge,
+		classifyInternal:    cfg.ClassifyInternal,
+		skipInternalLookups: cfg.SkipInternalLookups,
+	}
+	if cfg.DNS != nil {
+		cfg.DNS.metrics = cfg.Metrics
+	}
+	if cfg.CacheSize > 0 {
+		ttl := cfg.CacheTTL
+		if ttl <= 0 {
+			ttl = time.Hour
+		}
+		e.asnCache = newTTLCache[*geoip2.ASN](ttl, cfg.CacheSize)
+		e.cityCache = newTTLCache[*geoip2.City](ttl, cfg.CacheSize)
+	}
+	geoDB, asnDB, err := openDBs(cfg.GeoIPDBPath, cfg.ASNDBPath)
+	if err != nil {
+		return nil, err
+	}
+	e.geoDB, e.asnDB = geoDB, asnDB
+	e.geoPath, e.asnPath = cfg.GeoIPDBPath, cfg.ASNDBPath
+	e.logDatabases()
+	if e.cachePath != "" {
+		// A damaged snapshot only costs the warm start.
+		if asn, geo, dns, err := e.loadCaches(); err != nil {
+			log.Warn().Err(err).Msg("enrichment cache not loaded")
+		} else {
+			log.Info().Int("asn", asn).Int("geo", geo).Int("dns", dns).Str("path", e.cachePath).Msg("enrichment cache loaded")
+		}
+	}
+	return e, nil
+}
+
+// openDBs opens the MaxMind City and ASN DBs; an empty path yields a nil reader.
+func openDBs(geoPath, asnPath string) (geoDB, asnDB *geoip2.Reader, err error) {
 	if geoPath != "" {
-		db, err := geoip2.Open(geoPath)
+		geoDB, err = geoip2.Open(geoPath)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		e.geoDB = db
 	}
 	if asnPath != "" {
-		db, err := geoip2.Open(asnPath)
+		asnDB, err = geoip2.Open(asnPath)
 		if err != nil {
-			if e.geoDB != nil {
-				_ = e.geoDB.Close()
+			if geoDB != nil {
+				_ = geoDB.Close()
 			}
-			return nil, err
+			return nil, nil, err
 		}
-		e.asnDB = db
 	}
-	return e, nil
+	return geoDB, asnDB, nil
+}
+
+// Reload opens the DBs at the given paths and swaps them in for subsequent lookups (e.g. after
+// config reload or a DB update). On error the current DBs stay in use.
+func (e *Enricher) Reload(geoPath, asnPath string) error {
+	geoDB, asnDB, err := openDBs(geoPath, asnPath)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	oldGeo, oldASN := e.geoDB, e.asnDB
+	e.geoDB, e.asnDB = geoDB, asnDB
+	e.geoPath, e.asnPath = geoPath, asnPath
+	e.mu.Unlock()
+	e.asnCache.purge()
+	e.cityCache.purge()
+	e.logDatabases()
+	if oldGeo != nil {
+		_ = oldGeo.Close()
+	}
+	if oldASN != nil {
+		_ = oldASN.Close()
+	}
+	return nil
 }
 
 // Close closes DBs.
@@ -55,9 +160,11 @@ func (e *Enricher) Close() error {
 	return nil
 }
 
-// EnrichEvent enriches one ECS-like map. Preserves all existing keys; adds source.as.*, source.geo.*, source.domain.
-// Missing source.ip is non-fatal: enrichment is skipped and the event is preserved.
-func (e *Enricher) EnrichEvent(event map[string]interface{}) {
+// EnrichEvent enriches one ECS-like map. Normalizes source.ip/destination.ip (IPv6 canonical form,
+// IPv4-mapped to IPv4) and sets network.type. Otherwise preserves all existing keys; adds source.as.*, source.geo.*, source.domain,
+// related.ip, related.hosts and (when configured) payload.*, vulnerability.id and threat.software.name.
+// Missing source.ip is non-fatal: source enrichment is skipped and the event is preserved.
+func (e *Enricher) EnrichEvent(event event.Event) {
 	if event == nil {
 		return
 	}
@@ -66,62 +173,157 @@ func (e *Enricher) EnrichEvent(event map[string]interface{}) {
 		source = make(map[string]interface{})
 		event["source"] = source
 	}
-	ipStr, _ := source["ip"].(string)
-	if ipStr == "" {
-		return
+	e.enrichSource(event, source)
+	if e.payload != nil {
+		start := time.Now()
+		e.payload.Apply(event)
+		e.metrics.ObserveSince("payload", start)
+	}
+	if e.sigs != nil {
+		start := time.Now()
+		e.sigs.Apply(event)
+		e.metrics.ObserveSince("signatures", start)
+	}
+	populateRelated(event)
+}
+
+// enrichSource normalizes source.ip and destination.ip and adds network.type, internal classification
+// and ASN, GEO and DNS data for source.ip.
+func (e *Enricher) enrichSource(event event.Event, source map[string]interface{}) {
+	if dest, ok := event["destination"].(map[string]interface{}); ok {
+		normalizeIPField(dest)
 	}
-	ip := net.ParseIP(ipStr)
+	ip := normalizeIPField(source)
 	if ip == nil {
 		return
 	}
+	network := ecs.Map(event, "network")
+	if _, ok := network["type"]; !ok {
+		network["type"] = ipFamily(ip)
+	}
+
+	internal := isInternalIP(ip)
+	if e.classifyInternal {
+		source["internal"] = internal
+	}
+	if internal && e.skipInternalLookups {
+		return
+	}
 
 	// ASN
-	if e.asnDB != nil {
-		e.mu.RLock()
-		asn, err := e.asnDB.ASN(ip)
-		e.mu.RUnlock()
-		if err == nil && asn != nil {
-			if as, ok := source["as"].(map[string]interface{}); ok && as != nil {
-				as["number"] = int(asn.AutonomousSystemNumber)
-				if asn.AutonomousSystemOrganization != "" {
-					if asOrg, ok := as["organization"].(map[string]interface{}); ok && asOrg != nil {
-						asOrg["name"] = asn.AutonomousSystemOrganization
-					} else {
-						as["organization"] = map[string]interface{}{"name": asn.AutonomousSystemOrganization}
-					}
-				}
-			} else {
-				as := map[string]interface{}{"number": int(asn.AutonomousSystemNumber)}
-				if asn.AutonomousSystemOrganization != "" {
+	if asn, err := e.lookupASN(ip); err == nil && asn != nil {
+		if as, ok := source["as"].(map[string]interface{}); ok && as != nil {
+			as["number"] = int(asn.AutonomousSystemNumber)
+			if asn.AutonomousSystemOrganization != "" {
+				if asOrg, ok := as["organization"].(map[string]interface{}); ok && asOrg != nil {
+					asOrg["name"] = asn.AutonomousSystemOrganization
+				} else {
 					as["organization"] = map[string]interface{}{"name": asn.AutonomousSystemOrganization}
 				}
-				source["as"] = as
 			}
+		} else {
+			as := map[string]interface{}{"number": int(asn.AutonomousSystemNumber)}
+			if asn.AutonomousSystemOrganization != "" {
+				as["organization"] = map[string]interface{}{"name": asn.AutonomousSystemOrganization}
+			}
+			source["as"] = as
 		}
 	}
 
 	// GEO (City DB)
-	if e.geoDB != nil {
-		e.mu.RLock()
-		city, err := e.geoDB.City(ip)
-		e.mu.RUnlock()
-		if err == nil && city != nil {
-			if geo, ok := source["geo"].(map[string]interface{}); ok && geo != nil {
-				setGeo(geo, city)
-			} else {
-				geo := make(map[string]interface{})
-				setGeo(geo, city)
-				source["geo"] = geo
-			}
+	if city, err := e.lookupCity(ip); err == nil && city != nil {
+		if geo, ok := source["geo"].(map[string]interface{}); ok && geo != nil {
+			setGeo(geo, city)
+		} else {
+			geo := make(map[string]interface{})
+			setGeo(geo, city)
+			source["geo"] = geo
 		}
 	}
 
 	// DNS PTR
 	if e.dns != nil {
+		start := time.Now()
 		if name := e.dns.LookupPTR(ip); name != "" {
 			source["domain"] = name
 		}
+		e.metrics.ObserveSince("dns", start)
+	}
+}
+
+// lookupASN queries the ASN DB, memoized per IP when the lookup cache is enabled.
+// Returns nil, nil when no ASN DB is configured.
+func (e *Enricher) lookupASN(ip net.IP) (*geoip2.ASN, error) {
+	key := ip.String()
+	if asn, ok := e.asnCache.get(key); ok {
+		e.metrics.IncCacheHit("asn")
+		return asn, nil
+	}
+	start := time.Now()
+	e.mu.RLock()
+	if e.asnDB == nil {
+		e.mu.RUnlock()
+		return nil, nil
+	}
+	asn, err := e.asnDB.ASN(ip)
+	e.mu.RUnlock()
+	e.metrics.ObserveSince("asn", start)
+	switch {
+	case err != nil:
+		e.metrics.IncLookup("asn", resultError)
+		return nil, err
+	case asn == nil || asn.AutonomousSystemNumber == 0:
+		e.metrics.IncLookup("asn", resultNotFound)
+	default:
+		e.metrics.IncLookup("asn", resultOK)
+	}
+	e.asnCache.put(key, asn)
+	return asn, nil
+}
+
+// lookupCity queries the City DB, memoized per IP when the lookup cache is enabled.
+// Returns nil, nil when no City DB is configured.
+func (e *Enricher) lookupCity(ip net.IP) (*geoip2.City, error) {
+	key := ip.String()
+	if city, ok := e.cityCache.get(key); ok {
+		e.metrics.IncCacheHit("geo")
+		return city, nil
+	}
+	start := time.Now()
+	e.mu.RLock()
+	if e.geoDB == nil {
+		e.mu.RUnlock()
+		return nil, nil
+	}
+	city, err := e.geoDB.City(ip)
+	e.mu.RUnlock()
+	e.metrics.ObserveSince("geo", start)
+	switch {
+	case err != nil:
+		e.metrics.IncLookup("geo", resultError)
+		return nil, err
+	case city == nil || (city.Country.IsoCode == "" && city.Location.Latitude == 0 && city.Location.Longitude == 0):
+		e.metrics.IncLookup("geo", resultNotFound)
+	default:
+		e.metrics.IncLookup("geo", resultOK)
+	}
+	e.cityCache.put(key, city)
+	return city, nil
+}
+
+// Geo returns the GEO fields (country_iso_code, region_name, city_name, location) of ip from the
+// City DB, or nil without a City DB or data for ip.
+func (e *Enricher) Geo(ip net.IP) map[string]interface{} {
+	city, err := e.lookupCity(ip)
+	if err != nil || city == nil {
+		return nil
+	}
+	geo := make(map[string]interface{})
+	setGeo(geo, city)
+	if len(geo) == 0 {
+		return nil
 	}
+	return geo
 }
 
 func setGeo(geo map[string]interface{}, city *geoip2.City) {
@@ -144,7 +346,7 @@ func setGeo(geo map[string]interface{}, city *geoip2.City) {
 	}
 }
 
-// Ready returns true when the enricher can be used (always true; no DBs means pass-through).
-func (e *Enricher) Ready() bool {
-	return true
+// CacheSizes returns the number of entries in the ASN, GEO and DNS caches (0 when a cache is disabled).
+func (e *Enricher) CacheSizes() (asn, geo, dns int) {
+	return e.asnCache.len(), e.cityCache.len(), e.dns.cacheLen()
 }
@@ -3,12 +3,13 @@ package enrich
 import (
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 )
 
 // Enricher with no DBs: preserves Spip events and does not add as/geo (no lookups).
 func TestEnricher_NoDBs_PreservesEvent(t *testing.T) {
-	e, err := NewEnricher("", "", nil, zerolog.Nop())
+	e, err := NewEnricher(Config{}, zerolog.Nop())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -16,11 +17,11 @@ func TestEnricher_NoDBs_PreservesEvent(t *testing.T) {
 
 	// Spip-style event with source.ip
 	ev := map[string]interface{}{
-		"@timestamp": "2026-02-15T19:47:09Z",
-		"event":      map[string]interface{}{"id": "abc", "ingested_by": "spip"},
-		"source":     map[string]interface{}{"ip": "8.8.8.8", "port": float64(12345)},
+		"@timestamp":  "2026-02-15T19:47:09Z",
+		"event":       map[string]interface{}{"id": "abc", "ingested_by": "spip"},
+		"source":      map[string]interface{}{"ip": "8.8.8.8", "port": float64(12345)},
 		"destination": map[string]interface{}{"ip": "10.0.0.1", "port": float64(443)},
-		"observer":   map[string]interface{}{"hostname": "spip-001"},
+		"observer":    map[string]interface{}{"hostname": "spip-001"},
 	}
 	e.EnrichEvent(ev)
 
@@ -40,7 +41,7 @@ func TestEnricher_NoDBs_PreservesEvent(t *testing.T) {
 }
 
 func TestEnricher_NoDBs_MissingSourceIP_PreservesEvent(t *testing.T) {
-	e, err := NewEnricher("", "", nil, zerolog.Nop())
+	e, err := NewEnricher(Config{}, zerolog.Nop())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -63,7 +64,7 @@ func TestEnricher_NoDBs_MissingSourceIP_PreservesEvent(t *testing.T) {
 }
 
 func TestEnricher_NoDBs_NilEvent_NoPanic(t *testing.T) {
-	e, err := NewEnricher("", "", nil, zerolog.Nop())
+	e, err := NewEnricher(Config{}, zerolog.Nop())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,7 +73,7 @@ func TestEnricher_NoDBs_NilEvent_NoPanic(t *testing.T) {
 }
 
 func TestEnricher_NoDBs_InvalidIP_PreservesEvent(t *testing.T) {
-	e, err := NewEnricher("", "", nil, zerolog.Nop())
+	e, err := NewEnricher(Config{}, zerolog.Nop())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -89,7 +90,7 @@ func TestEnricher_NoDBs_InvalidIP_PreservesEvent(t *testing.T) {
 }
 
 func TestEnricher_Ready(t *testing.T) {
-	e, err := NewEnricher("", "", nil, zerolog.Nop())
+	e, err := NewEnricher(Config{}, zerolog.Nop())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -98,3 +99,57 @@ func TestEnricher_Ready(t *testing.T) {
 		t.Error("Ready() should be true even with no DBs")
 	}
 }
+
+func TestEnricher_MetricsStageTimings(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+	sigs, err := NewSignatureMatcher("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEnricher(Config{Signatures: sigs, Metrics: m}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	e.EnrichEvent(map[string]interface{}{
+		"event": map[string]interface{}{"summary": "GET /"},
+	})
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := 0
+	for _, mf := range mfs {
+		if mf.GetName() == "loom_enrich_duration_seconds" {
+			series = len(mf.GetMetric())
+		}
+	}
+	if series != 1 {
+		t.Errorf("duration series = %d, want 1 (signatures stage)", series)
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.IncLookup("asn", resultOK)
+	nilMetrics.IncCacheHit("dns")
+}
+
+func TestEnricher_Reload_InvalidPathKeepsCurrent(t *testing.T) {
+	e, err := NewEnricher(Config{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.Reload("/nonexistent/GeoLite2-City.mmdb", ""); err == nil {
+		t.Fatal("Reload with missing DB: want error")
+	}
+	event := map[string]interface{}{"source": map[string]interface{}{"ip": "192.0.2.1"}}
+	e.EnrichEvent(event)
+	if src := event["source"].(map[string]interface{}); src["ip"] != "192.0.2.1" {
+		t.Errorf("source.ip = %v", src["ip"])
+	}
+	if err := e.Reload("", ""); err != nil {
+		t.Errorf("Reload with no DBs: %v", err)
+	}
+}
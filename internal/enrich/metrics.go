@@ -0,0 +1,67 @@
+package enrich
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Lookup results used as the "result" label of loom_enrich_lookups_total.
+const (
+	resultOK          = "ok"
+	resultNotFound    = "not_found"
+	resultError       = "error"
+	resultRateLimited = "rate_limited"
+)
+
+// Metrics holds Prometheus metrics for the enrichment stages (asn, geo, dns, payload, signatures).
+type Metrics struct {
+	LookupsTotal   *prometheus.CounterVec
+	CacheHitsTotal *prometheus.CounterVec
+	Duration       *prometheus.HistogramVec
+}
+
+// NewMetrics creates and registers enrichment metrics. Labels must not include IPs.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		LookupsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_enrich_lookups_total", Help: "Enrichment lookups by stage and result (ok, not_found, error, rate_limited)"},
+			[]string{"stage", "result"}),
+		CacheHitsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_enrich_cache_hits_total", Help: "Enrichment lookups answered from cache by stage"},
+			[]string{"stage"}),
+		Duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "loom_enrich_duration_seconds",
+				Help:    "Time spent per enrichment stage per event",
+				Buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+			},
+			[]string{"stage"}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.LookupsTotal, m.CacheHitsTotal, m.Duration)
+	}
+	return m
+}
+
+func (m *Metrics) IncLookup(stage, result string) {
+	if m == nil {
+		return
+	}
+	m.LookupsTotal.WithLabelValues(stage, result).Inc()
+}
+
+func (m *Metrics) IncCacheHit(stage string) {
+	if m == nil {
+		return
+	}
+	m.CacheHitsTotal.WithLabelValues(stage).Inc()
+}
+
+// ObserveSince records the duration of a stage that started at start.
+func (m *Metrics) ObserveSince(stage string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.Duration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,101 @@
+package enrich
+
+import (
+	"net"
+	"strings"
+)
+
+// internalCIDRs are private, loopback, link-local, shared, documentation, multicast and other
+// bogon ranges that are never routed on the public internet.
+var internalCIDRs = mustParseCIDRs(
+	// IPv4
+	"0.0.0.0/8",       // "this" network
+	"10.0.0.0/8",      // RFC1918
+	"100.64.0.0/10",   // carrier-grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local
+	"172.16.0.0/12",   // RFC1918
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"192.168.0.0/16",  // RFC1918
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, includes broadcast
+	// IPv6
+	"::/128",        // unspecified
+	"::1/128",       // loopback
+	"100::/64",      // discard-only
+	"2001:db8::/32", // documentation
+	"fc00::/7",      // unique local
+	"fe80::/10",     // link-local
+	"ff00::/8",      // multicast
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// isInternalIP reports whether ip is in private, loopback, link-local or bogon space.
+func isInternalIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range internalCIDRs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFamily returns the ECS network.type value for ip ("ipv4" or "ipv6").
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// parseIP parses an IP as sent by sensors: it accepts brackets ("[2001:db8::1]") and zones
+// ("fe80::1%eth0"), and maps IPv4-mapped IPv6 addresses to IPv4. Returns the IP and its canonical string form.
+func parseIP(s string) (net.IP, string, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, "", false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return ip, ip.String(), true
+}
+
+// normalizeIPField rewrites m["ip"] to its canonical form. Returns the parsed IP or nil.
+func normalizeIPField(m map[string]interface{}) net.IP {
+	s, _ := m["ip"].(string)
+	if s == "" {
+		return nil
+	}
+	ip, canonical, ok := parseIP(s)
+	if !ok {
+		return nil
+	}
+	if canonical != s {
+		m["ip"] = canonical
+	}
+	return ip
+}
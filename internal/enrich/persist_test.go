@@ -0,0 +1,53 @@
+package enrich
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/rs/zerolog"
+)
+
+func TestSaveCaches_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "enrich.gob")
+	open := func() *Enricher {
+		e, err := NewEnricher(Config{DNS: NewDNSEnricher(time.Hour, 10), CacheSize: 100, CachePath: path}, zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	e := open()
+	e.dns.cache["1.2.3.4"] = cacheEntry{name: "scanner.example.net", exp: time.Now().Add(time.Hour)}
+	e.dns.cache["5.6.7.8"] = cacheEntry{name: "expired.example.net", exp: time.Now().Add(-time.Second)}
+	city := &geoip2.City{}
+	city.Country.IsoCode = "NL"
+	e.cityCache.put("1.2.3.4", city)
+	e.asnCache.put("1.2.3.4", nil) // not saved
+	if err := e.SaveCaches(); err != nil {
+		t.Fatalf("SaveCaches: %v", err)
+	}
+
+	e = open()
+	asn, geo, dns := e.CacheSizes()
+	if asn != 0 || geo != 1 || dns != 1 {
+		t.Fatalf("CacheSizes after restart = %d, %d, %d", asn, geo, dns)
+	}
+	if got := e.dns.LookupPTR(net.ParseIP("1.2.3.4")); got != "scanner.example.net" {
+		t.Errorf("LookupPTR = %q", got)
+	}
+	if c, ok := e.cityCache.get("1.2.3.4"); !ok || c.Country.IsoCode != "NL" {
+		t.Errorf("city cache = %+v, %v", c, ok)
+	}
+
+	// A damaged file is ignored.
+	if err := os.WriteFile(path, []byte("not gob"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, dns := open().CacheSizes(); dns != 0 {
+		t.Errorf("loaded %d DNS entries from a damaged file", dns)
+	}
+}
@@ -0,0 +1,121 @@
+package enrich
+
+import (
+	"net"
+	"strings"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// relatedIPFields are the ECS fields whose IP values are collected into related.ip.
+var relatedIPFields = []string{
+	"source.ip",
+	"destination.ip",
+	"client.ip",
+	"server.ip",
+	"host.ip",
+	"observer.ip",
+}
+
+// relatedHostFields are the ECS fields whose host names are collected into related.hosts.
+var relatedHostFields = []string{
+	"source.domain",
+	"destination.domain",
+	"client.domain",
+	"server.domain",
+	"url.domain",
+	"host.name",
+	"host.hostname",
+}
+
+// populateRelated fills related.ip and related.hosts from the IP and host fields present in the event.
+// Existing related values are kept; duplicates are not added. Invalid IPs are ignored. A related, or
+// related.ip / related.hosts, of another type than ECS has (an object; strings) is left as it is.
+func populateRelated(event event.Event) {
+	var ips, hosts []string
+	for _, path := range relatedIPFields {
+		for _, v := range stringValues(ecs.Get(event, path)) {
+			if ip := net.ParseIP(v); ip != nil {
+				ips = append(ips, ip.String())
+			}
+		}
+	}
+	for _, path := range relatedHostFields {
+		for _, v := range stringValues(ecs.Get(event, path)) {
+			if h := strings.TrimSuffix(strings.TrimSpace(v), "."); h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	if len(ips) == 0 && len(hosts) == 0 {
+		return
+	}
+	related, ok := event["related"].(map[string]interface{})
+	if !ok {
+		if event["related"] != nil {
+			return
+		}
+		related = make(map[string]interface{})
+		event["related"] = related
+	}
+	if len(ips) > 0 && isStrings(related["ip"]) {
+		related["ip"] = mergeUnique(related["ip"], ips)
+	}
+	if len(hosts) > 0 && isStrings(related["hosts"]) {
+		related["hosts"] = mergeUnique(related["hosts"], hosts)
+	}
+}
+
+// isStrings reports whether v is absent, a string or an array of strings, which mergeUnique keeps.
+func isStrings(v interface{}) bool {
+	switch t := v.(type) {
+	case nil, string, []string:
+		return true
+	case []interface{}:
+		for _, x := range t {
+			if _, ok := x.(string); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// stringValues returns v as a list of strings (v may be a string or an array of strings).
+func stringValues(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		if t == "" {
+			return nil
+		}
+		return []string{t}
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, x := range t {
+			if s, ok := x.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return t
+	}
+	return nil
+}
+
+// mergeUnique appends values to the existing related list, keeping order and dropping duplicates.
+func mergeUnique(existing interface{}, values []string) []interface{} {
+	prev := stringValues(existing)
+	seen := make(map[string]bool, len(prev)+len(values))
+	out := make([]interface{}, 0, len(prev)+len(values))
+	for _, v := range append(prev, values...) {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package enrich
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEnricher_PopulatesRelated(t *testing.T) {
+	e, err := NewEnricher(Config{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	ev := map[string]interface{}{
+		"source":      map[string]interface{}{"ip": "8.8.8.8", "domain": "dns.google."},
+		"destination": map[string]interface{}{"ip": "10.0.0.1"},
+		"host":        map[string]interface{}{"name": "spip-001", "ip": []interface{}{"10.0.0.1", "192.168.1.5"}},
+	}
+	e.EnrichEvent(ev)
+
+	related, _ := ev["related"].(map[string]interface{})
+	if related == nil {
+		t.Fatal("related should be added")
+	}
+	wantIPs := []interface{}{"8.8.8.8", "10.0.0.1", "192.168.1.5"}
+	if !reflect.DeepEqual(related["ip"], wantIPs) {
+		t.Errorf("related.ip = %v, want %v", related["ip"], wantIPs)
+	}
+	wantHosts := []interface{}{"dns.google", "spip-001"}
+	if !reflect.DeepEqual(related["hosts"], wantHosts) {
+		t.Errorf("related.hosts = %v, want %v", related["hosts"], wantHosts)
+	}
+}
+
+func TestPopulateRelated_KeepsExistingAndDedups(t *testing.T) {
+	ev := map[string]interface{}{
+		"source":  map[string]interface{}{"ip": "1.2.3.4"},
+		"related": map[string]interface{}{"ip": []interface{}{"1.2.3.4", "5.6.7.8"}},
+	}
+	populateRelated(ev)
+
+	related := ev["related"].(map[string]interface{})
+	want := []interface{}{"1.2.3.4", "5.6.7.8"}
+	if !reflect.DeepEqual(related["ip"], want) {
+		t.Errorf("related.ip = %v, want %v", related["ip"], want)
+	}
+	if _, ok := related["hosts"]; ok {
+		t.Error("related.hosts should not be added when no host fields are present")
+	}
+}
+
+func TestPopulateRelated_IgnoresInvalidIP(t *testing.T) {
+	ev := map[string]interface{}{
+		"source": map[string]interface{}{"ip": "not-an-ip"},
+	}
+	populateRelated(ev)
+	if _, ok := ev["related"]; ok {
+		t.Error("related should not be added for invalid IPs only")
+	}
+}
+
+func TestPopulateRelated_KeepsOtherTypes(t *testing.T) {
+	for _, related := range []interface{}{"1.2.3.4", []interface{}{"a"}, 42.0} {
+		ev := map[string]interface{}{
+			"source":  map[string]interface{}{"ip": "8.8.8.8"},
+			"related": related,
+		}
+		populateRelated(ev)
+		if !reflect.DeepEqual(ev["related"], related) {
+			t.Errorf("related %v replaced with %v", related, ev["related"])
+		}
+	}
+
+	ev := map[string]interface{}{
+		"source":  map[string]interface{}{"ip": "8.8.8.8", "domain": "dns.google"},
+		"related": map[string]interface{}{"ip": map[string]interface{}{"v4": "1.2.3.4"}},
+	}
+	populateRelated(ev)
+	related := ev["related"].(map[string]interface{})
+	if !reflect.DeepEqual(related["ip"], map[string]interface{}{"v4": "1.2.3.4"}) {
+		t.Errorf("related.ip object replaced with %v", related["ip"])
+	}
+	if !reflect.DeepEqual(related["hosts"], []interface{}{"dns.google"}) {
+		t.Errorf("related.hosts = %v", related["hosts"])
+	}
+}
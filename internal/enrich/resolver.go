@@ -0,0 +1,161 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// PTRResolver resolves an address to names, like net.Resolver. A nil *net.Resolver is the system
+// resolver.
+type PTRResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// NewDoTResolver returns a resolver that sends every lookup to the DNS-over-TLS server at addr
+// (host:port), whatever the name servers of /etc/resolv.conf. tlsConfig may be nil; its ServerName
+// defaults to the host of addr. dial, if not nil, opens the TCP connections (e.g. through a proxy).
+func NewDoTResolver(addr string, tlsConfig *tls.Config, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*net.Resolver, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("dns over tls: %w", err)
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		// The resolver frames messages for TCP when the connection is not a net.PacketConn.
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			c, err := dial(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			tc := tls.Client(c, tlsConfig)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				c.Close()
+				return nil, err
+			}
+			return tc, nil
+		},
+	}, nil
+}
+
+// DoHResolver sends PTR queries to a DNS-over-HTTPS server (RFC 8484), e.g.
+// https://cloudflare-dns.com/dns-query.
+type DoHResolver struct {
+	url    string
+	client *http.Client
+}
+
+// maxDoHResponse bounds the response body; DNS messages are at most 64 KiB.
+const maxDoHResponse = 64 * 1024
+
+// NewDoHResolver creates a resolver posting to url with client (http.DefaultClient if nil).
+func NewDoHResolver(url string, client *http.Client) *DoHResolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &DoHResolver{url: url, client: client}
+}
+
+// LookupAddr implements PTRResolver. A missing name is a *net.DNSError with IsNotFound set.
+func (r *DoHResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	name, err := reverseName(addr)
+	if err != nil {
+		return nil, err
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr}
+	}
+	// ID 0 keeps the query cacheable by HTTP caches (RFC 8484, section 4.1).
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr, Server: r.url, IsTemporary: true}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr, Server: r.url, IsTemporary: true}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &net.DNSError{Err: "status " + resp.Status, Name: addr, Server: r.url, IsTemporary: resp.StatusCode >= 500}
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(body); err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: addr, Server: r.url}
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: addr, Server: r.url, IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: msg.RCode.String(), Name: addr, Server: r.url, IsTemporary: msg.RCode == dnsmessage.RCodeServerFailure}
+	}
+	var names []string
+	for _, a := range msg.Answers {
+		if ptr, ok := a.Body.(*dnsmessage.PTRResource); ok {
+			names = append(names, ptr.PTR.String())
+		}
+	}
+	if len(names) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, Server: r.url, IsNotFound: true}
+	}
+	return names, nil
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of addr.
+func reverseName(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	var b strings.Builder
+	if v4 := ip.To4(); v4 != nil {
+		for i := 3; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(v4[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa.")
+		return b.String(), nil
+	}
+	const hex = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hex[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
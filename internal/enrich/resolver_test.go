@@ -0,0 +1,125 @@
+package enrich
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answerPTR answers a PTR query for 4.3.2.1.in-addr.arpa. with scanner.example.net. and any other
+// name with NXDOMAIN.
+func answerPTR(t *testing.T, query []byte) []byte {
+	t.Helper()
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		t.Fatalf("unpack query: %v", err)
+	}
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionDesired: true},
+		Questions: q.Questions,
+	}
+	if len(q.Questions) == 1 && q.Questions[0].Name.String() == "4.3.2.1.in-addr.arpa." {
+		resp.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("scanner.example.net.")},
+		}}
+	} else {
+		resp.RCode = dnsmessage.RCodeNameError
+	}
+	b, err := resp.Pack()
+	if err != nil {
+		t.Fatalf("pack response: %v", err)
+	}
+	return b
+}
+
+func TestDoHResolver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answerPTR(t, query))
+	}))
+	defer srv.Close()
+
+	d := NewDNSEnricher(0, 100)
+	d.SetResolver(NewDoHResolver(srv.URL, srv.Client()))
+	if got := d.LookupPTR(net.ParseIP("1.2.3.4")); got != "scanner.example.net" {
+		t.Errorf("LookupPTR = %q", got)
+	}
+	_, err := NewDoHResolver(srv.URL, srv.Client()).LookupAddr(context.Background(), "5.6.7.8")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("missing name: %v", err)
+	}
+}
+
+func TestDoTResolver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				for {
+					var n uint16
+					if err := binary.Read(c, binary.BigEndian, &n); err != nil {
+						return
+					}
+					query := make([]byte, n)
+					if _, err := io.ReadFull(c, query); err != nil {
+						return
+					}
+					resp := answerPTR(t, query)
+					_ = binary.Write(c, binary.BigEndian, uint16(len(resp)))
+					_, _ = c.Write(resp)
+				}
+			}()
+		}
+	}()
+
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.ServerName = "example.com" // a name of the httptest certificate
+	r, err := NewDoTResolver(ln.Addr().String(), tlsConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := r.LookupAddr(context.Background(), "1.2.3.4")
+	if err != nil || len(names) != 1 || names[0] != "scanner.example.net." {
+		t.Errorf("LookupAddr = %v, %v", names, err)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for addr, want := range map[string]string{
+		"1.2.3.4":     "4.3.2.1.in-addr.arpa.",
+		"2001:db8::1": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		if got, err := reverseName(addr); err != nil || got != want {
+			t.Errorf("reverseName(%s) = %q, %v", addr, got, err)
+		}
+	}
+	if _, err := reverseName("scanner"); err == nil {
+		t.Error("expected an error for a name")
+	}
+}
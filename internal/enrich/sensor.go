@@ -0,0 +1,163 @@
+package enrich
+
+import (
+	"net"
+	"sync"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// SensorMetadata is static deployment metadata configured per sensor ID.
+type SensorMetadata struct {
+	Site     string                 // observer.geo.name
+	Geo      map[string]interface{} // observer.geo.* (e.g. location, country_iso_code)
+	Owner    string                 // labels.owner
+	Tags     []string               // appended to tags
+	Labels   map[string]string      // labels.*
+	Observer map[string]interface{} // observer.* (e.g. type, vendor, product)
+	Tenant   string                 // tenant.id
+
+	// GeoFromIP sets observer.geo.* from the GeoIP location of the sensor's public address (see
+	// SensorLocator); Geo and Site take precedence.
+	GeoFromIP bool
+}
+
+// SensorTagger merges configured sensor metadata into events from that sensor.
+// Configured values take precedence over values sent by the sensor.
+type SensorTagger struct {
+	sensors map[string]SensorMetadata
+}
+
+// NewSensorTagger creates a tagger for the given sensor ID -> metadata map. Returns nil if the map is empty.
+func NewSensorTagger(sensors map[string]SensorMetadata) *SensorTagger {
+	if len(sensors) == 0 {
+		return nil
+	}
+	return &SensorTagger{sensors: sensors}
+}
+
+// Apply merges the metadata for sensorID into the event. Unknown sensors are left unchanged.
+func (s *SensorTagger) Apply(sensorID string, event event.Event) {
+	if s == nil || event == nil {
+		return
+	}
+	meta, ok := s.sensors[sensorID]
+	if !ok {
+		return
+	}
+	for k, v := range meta.Observer {
+		ecs.Map(event, "observer")[k] = v
+	}
+	for k, v := range meta.Geo {
+		ecs.Map(ecs.Map(event, "observer"), "geo")[k] = v
+	}
+	if meta.Site != "" {
+		ecs.Map(ecs.Map(event, "observer"), "geo")["name"] = meta.Site
+	}
+	if meta.Owner != "" {
+		ecs.Map(event, "labels")["owner"] = meta.Owner
+	}
+	for k, v := range meta.Labels {
+		ecs.Map(event, "labels")[k] = v
+	}
+	if len(meta.Tags) > 0 {
+		event["tags"] = mergeUnique(event["tags"], meta.Tags)
+	}
+	if meta.Tenant != "" {
+		ecs.Map(event, "tenant")["id"] = meta.Tenant
+	}
+}
+
+// SensorLocator sets observer.geo.* on the events of sensors with GeoFromIP from the location of
+// the address they connect from. The address is looked up when a sensor first connects from it,
+// not per event.
+type SensorLocator struct {
+	lookup  func(net.IP) map[string]interface{}
+	sensors map[string]bool
+
+	mu      sync.RWMutex
+	located map[string]sensorLocation
+}
+
+type sensorLocation struct {
+	ip  string
+	geo map[string]interface{}
+}
+
+// NewSensorLocator creates a locator for the sensors with GeoFromIP, looking addresses up with
+// lookup (e.g. Enricher.Geo). Returns nil if no sensor has GeoFromIP.
+func NewSensorLocator(sensors map[string]SensorMetadata, lookup func(net.IP) map[string]interface{}) *SensorLocator {
+	l := &SensorLocator{lookup: lookup, sensors: make(map[string]bool), located: make(map[string]sensorLocation)}
+	for id, meta := range sensors {
+		if meta.GeoFromIP {
+			l.sensors[id] = true
+		}
+	}
+	if len(l.sensors) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Heartbeat notes that sensorID connected from ip and looks the address up if it changed.
+// Internal addresses (a NATed sensor, or a proxy whose forwarded headers are not trusted) are
+// ignored, keeping the last public location.
+func (l *SensorLocator) Heartbeat(sensorID, ip string) {
+	if l == nil || !l.sensors[sensorID] {
+		return
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil || isInternalIP(addr) {
+		return
+	}
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	ip = addr.String()
+	l.mu.RLock()
+	loc, ok := l.located[sensorID]
+	l.mu.RUnlock()
+	if ok && loc.ip == ip {
+		return
+	}
+	geo := l.lookup(addr)
+	l.mu.Lock()
+	l.located[sensorID] = sensorLocation{ip: ip, geo: geo}
+	l.mu.Unlock()
+}
+
+// Apply sets observer.geo.* to the location of sensorID's last public address, if known.
+func (l *SensorLocator) Apply(sensorID string, event event.Event) {
+	if l == nil || event == nil {
+		return
+	}
+	l.mu.RLock()
+	loc := l.located[sensorID]
+	l.mu.RUnlock()
+	if len(loc.geo) == 0 {
+		return
+	}
+	geo := ecs.Map(ecs.Map(event, "observer"), "geo")
+	for k, v := range loc.geo {
+		if m, ok := v.(map[string]interface{}); ok {
+			c := make(map[string]interface{}, len(m))
+			for mk, mv := range m {
+				c[mk] = mv
+			}
+			v = c
+		}
+		geo[k] = v
+	}
+}
+
+// Reset forgets the located addresses so that they are looked up again, e.g. after the GeoIP DB
+// was reloaded.
+func (l *SensorLocator) Reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.located = make(map[string]sensorLocation)
+	l.mu.Unlock()
+}
@@ -0,0 +1,107 @@
+package enrich
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+func TestSensorTagger_Apply(t *testing.T) {
+	tagger := NewSensorTagger(map[string]SensorMetadata{
+		"spip-001": {
+			Site:     "ams1",
+			Owner:    "team-x",
+			Tags:     []string{"dmz", "honeypot"},
+			Labels:   map[string]string{"env": "prod"},
+			Geo:      map[string]interface{}{"country_iso_code": "NL"},
+			Observer: map[string]interface{}{"type": "honeypot"},
+			Tenant:   "group-a",
+		},
+	})
+	ev := map[string]interface{}{
+		"observer": map[string]interface{}{"hostname": "spip-001", "id": "spip-001"},
+		"tags":     []interface{}{"honeypot", "spip"},
+	}
+	tagger.Apply("spip-001", ev)
+
+	if ecs.GetString(ev, "observer.geo.name") != "ams1" {
+		t.Errorf("observer.geo.name = %v", ecs.Get(ev, "observer.geo.name"))
+	}
+	if ecs.GetString(ev, "observer.geo.country_iso_code") != "NL" {
+		t.Errorf("observer.geo = %v", ecs.Get(ev, "observer.geo"))
+	}
+	if ecs.GetString(ev, "observer.type") != "honeypot" {
+		t.Errorf("observer.type = %v", ecs.Get(ev, "observer.type"))
+	}
+	if ecs.GetString(ev, "observer.hostname") != "spip-001" {
+		t.Error("existing observer fields should be preserved")
+	}
+	if ecs.GetString(ev, "labels.owner") != "team-x" || ecs.GetString(ev, "labels.env") != "prod" {
+		t.Errorf("labels = %v", ev["labels"])
+	}
+	if ecs.GetString(ev, "tenant.id") != "group-a" {
+		t.Errorf("tenant.id = %v", ecs.Get(ev, "tenant.id"))
+	}
+	want := []interface{}{"honeypot", "spip", "dmz"}
+	if !reflect.DeepEqual(ev["tags"], want) {
+		t.Errorf("tags = %v, want %v", ev["tags"], want)
+	}
+}
+
+func TestSensorTagger_UnknownSensorAndNil(t *testing.T) {
+	if NewSensorTagger(nil) != nil {
+		t.Error("empty metadata should return nil tagger")
+	}
+	var nilTagger *SensorTagger
+	ev := map[string]interface{}{}
+	nilTagger.Apply("spip-001", ev)
+
+	tagger := NewSensorTagger(map[string]SensorMetadata{"spip-001": {Site: "ams1"}})
+	tagger.Apply("spip-002", ev)
+	if len(ev) != 0 {
+		t.Errorf("unknown sensor should leave event unchanged, got %v", ev)
+	}
+}
+
+func TestSensorLocator(t *testing.T) {
+	lookups := 0
+	locator := NewSensorLocator(map[string]SensorMetadata{
+		"spip-001": {GeoFromIP: true},
+		"spip-002": {Site: "ams1"},
+	}, func(ip net.IP) map[string]interface{} {
+		lookups++
+		return map[string]interface{}{"country_iso_code": "NL", "location": map[string]interface{}{"lat": 52.37, "lon": 4.89}, "ip": ip.String()}
+	})
+	if NewSensorLocator(map[string]SensorMetadata{"spip-002": {Site: "ams1"}}, nil) != nil {
+		t.Error("locator without GeoFromIP sensors should be nil")
+	}
+
+	locator.Heartbeat("spip-001", "10.0.0.1") // internal: ignored
+	locator.Heartbeat("spip-001", "::ffff:8.8.8.8")
+	locator.Heartbeat("spip-001", "8.8.8.8")
+	locator.Heartbeat("spip-002", "1.1.1.1")
+	if lookups != 1 {
+		t.Errorf("lookups = %d, want 1", lookups)
+	}
+
+	ev := map[string]interface{}{"observer": map[string]interface{}{"geo": map[string]interface{}{"name": "sent"}}}
+	locator.Apply("spip-001", ev)
+	if ecs.GetString(ev, "observer.geo.country_iso_code") != "NL" || ecs.GetString(ev, "observer.geo.ip") != "8.8.8.8" ||
+		ecs.GetString(ev, "observer.geo.name") != "sent" || ecs.Get(ev, "observer.geo.location.lat") != 52.37 {
+		t.Errorf("observer = %v", ev["observer"])
+	}
+	other := map[string]interface{}{}
+	locator.Apply("spip-002", other)
+	if len(other) != 0 {
+		t.Errorf("sensor without GeoFromIP changed: %v", other)
+	}
+
+	locator.Heartbeat("spip-001", "1.1.1.1")
+	locator.Reset()
+	locator.Heartbeat("spip-001", "1.1.1.1")
+	if lookups != 3 {
+		t.Errorf("lookups = %d, want 3 after an address change and a reset", lookups)
+	}
+}
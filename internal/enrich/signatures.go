@@ -0,0 +1,148 @@
+package enrich
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+//go:embed rules/signatures.toml
+var builtinSignatures []byte
+
+// DefaultSignatureFields are the event fields matched against signature rules when none are configured.
+var DefaultSignatureFields = []string{
+	"event.summary",
+	"event.original",
+	"payload.decoded",
+	"url.original",
+	"user_agent.original",
+}
+
+// SignatureRule maps a substring or regular expression to CVE IDs and/or a tool name.
+type SignatureRule struct {
+	Name     string   `toml:"name"`
+	Contains string   `toml:"contains"`
+	Regex    string   `toml:"regex"`
+	CVE      []string `toml:"cve"`
+	Software string   `toml:"software"`
+
+	re       *regexp.Regexp
+	contains string
+}
+
+// SignatureMatcher tags events matching known exploit and tool patterns with
+// vulnerability.id and threat.software.name.
+type SignatureMatcher struct {
+	rules  []SignatureRule
+	fields []string
+}
+
+// NewSignatureMatcher loads rules from rulesPath, or the built-in rule pack if rulesPath is "".
+// fields defaults to DefaultSignatureFields.
+func NewSignatureMatcher(rulesPath string, fields []string) (*SignatureMatcher, error) {
+	data := builtinSignatures
+	if rulesPath != "" {
+		b, err := os.ReadFile(rulesPath)
+		if err != nil {
+			return nil, fmt.Errorf("signatures: %w", err)
+		}
+		data = b
+	}
+	rules, err := parseSignatureRules(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		fields = DefaultSignatureFields
+	}
+	return &SignatureMatcher{rules: rules, fields: fields}, nil
+}
+
+func parseSignatureRules(data []byte) ([]SignatureRule, error) {
+	var pack struct {
+		Rule []SignatureRule `toml:"rule"`
+	}
+	if _, err := toml.Decode(string(data), &pack); err != nil {
+		return nil, fmt.Errorf("signatures: parse rules: %w", err)
+	}
+	for i := range pack.Rule {
+		r := &pack.Rule[i]
+		if (r.Contains == "") == (r.Regex == "") {
+			return nil, fmt.Errorf("signatures: rule %q: exactly one of contains or regex is required", r.Name)
+		}
+		if len(r.CVE) == 0 && r.Software == "" {
+			return nil, fmt.Errorf("signatures: rule %q: cve or software is required", r.Name)
+		}
+		if r.Regex != "" {
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("signatures: rule %q: %w", r.Name, err)
+			}
+			r.re = re
+		}
+		r.contains = strings.ToLower(r.Contains)
+	}
+	return pack.Rule, nil
+}
+
+// Len returns the number of loaded rules.
+func (m *SignatureMatcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.rules)
+}
+
+// Apply matches all rules against the configured fields and adds vulnerability.id and threat.software.name.
+func (m *SignatureMatcher) Apply(event event.Event) {
+	if m == nil || event == nil {
+		return
+	}
+	var values []string
+	for _, f := range m.fields {
+		if v := ecs.GetString(event, f); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return
+	}
+	var cves, software []string
+	for i := range m.rules {
+		r := &m.rules[i]
+		if !r.matchAny(values) {
+			continue
+		}
+		cves = append(cves, r.CVE...)
+		if r.Software != "" {
+			software = append(software, r.Software)
+		}
+	}
+	if len(cves) > 0 {
+		vuln := ecs.Map(event, "vulnerability")
+		vuln["id"] = mergeUnique(vuln["id"], cves)
+	}
+	if len(software) > 0 {
+		sw := ecs.Map(ecs.Map(event, "threat"), "software")
+		sw["name"] = mergeUnique(sw["name"], software)
+	}
+}
+
+func (r *SignatureRule) matchAny(values []string) bool {
+	for _, v := range values {
+		if r.re != nil {
+			if r.re.MatchString(v) {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(v), r.contains) {
+			return true
+		}
+	}
+	return false
+}
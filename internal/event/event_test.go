@@ -0,0 +1,53 @@
+package event
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEvent_Accessors(t *testing.T) {
+	var e Event
+	body := `{"@timestamp":"2026-02-15T19:47:09.5Z","source":{"ip":"8.8.8.8","port":12345},` +
+		`"destination":{"port":"22"},"observer":{"id":"spip-01"},"custom":{"kept":true}}`
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := e.Timestamp(); !ok || ts.Nanosecond() != 5e8 {
+		t.Errorf("Timestamp = %v, %v", ts, ok)
+	}
+	if e.SourceIP() != "8.8.8.8" || e.SourcePort() != 12345 || e.DestinationPort() != 22 || e.ObserverID() != "spip-01" {
+		t.Errorf("accessors: %q %d %d %q", e.SourceIP(), e.SourcePort(), e.DestinationPort(), e.ObserverID())
+	}
+	if e.DestinationIP() != "" {
+		t.Errorf("DestinationIP = %q", e.DestinationIP())
+	}
+
+	// Unknown fields survive a round trip.
+	e.Set("loom.first_seen", true)
+	out, _ := json.Marshal(e)
+	var back map[string]interface{}
+	_ = json.Unmarshal(out, &back)
+	if back["custom"].(map[string]interface{})["kept"] != true || back["loom"].(map[string]interface{})["first_seen"] != true {
+		t.Errorf("round trip = %s", out)
+	}
+}
+
+func TestEvent_Validate(t *testing.T) {
+	for body, valid := range map[string]bool{
+		`{}`: true,
+		`{"@timestamp":"2026-02-15T19:47:09Z","source":{"ip":"::1","port":0}}`: true,
+		`{"@timestamp":1700000000}`:               false,
+		`{"source":{"ip":"not-an-ip"}}`:           false,
+		`{"destination":{"port":70000}}`:          false,
+		`{"destination":{"port":"22"}}`:           false,
+		`{"source":{"ip":"10.0.0.1","port":1.5}}`: false,
+	} {
+		var e Event
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Validate(); (err == nil) != valid {
+			t.Errorf("%s: Validate = %v", body, err)
+		}
+	}
+}
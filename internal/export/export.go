@@ -0,0 +1,262 @@
+// Package export reads stored events back out of ClickHouse or Elasticsearch in batches, so they can
+// be written to another output (e.g. backfilling Elasticsearch from ClickHouse during a migration).
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/proxy"
+)
+
+// Range limits the events read by their @timestamp; zero bounds are open.
+type Range struct {
+	Since time.Time // inclusive
+	Until time.Time // exclusive
+}
+
+// Source yields stored events in batches; Next returns io.EOF after the last batch.
+type Source interface {
+	Next(ctx context.Context) ([]event.Event, error)
+	Close() error
+}
+
+// ClickHouseConfig locates the table written by the ClickHouse output (one JSON event per row in
+// the event column).
+type ClickHouseConfig struct {
+	URL       string
+	Database  string
+	Table     string
+	User      string
+	Password  string
+	Range     Range
+	BatchSize int    // default 500
+	Proxy     string // see the proxy package; "" honors HTTPS_PROXY
+}
+
+type clickHouseSource struct {
+	body  io.ReadCloser
+	sc    *bufio.Scanner
+	batch int
+}
+
+// OpenClickHouse starts a streaming SELECT over the table.
+func OpenClickHouse(ctx context.Context, cfg ClickHouseConfig) (Source, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	query := fmt.Sprintf("SELECT event FROM %s.%s", cfg.Database, cfg.Table)
+	var where []string
+	const ts = "parseDateTime64BestEffortOrNull(JSONExtractString(event, '@timestamp'), 3)"
+	if !cfg.Range.Since.IsZero() {
+		where = append(where, fmt.Sprintf("%s >= parseDateTime64BestEffort('%s', 3)", ts, cfg.Range.Since.UTC().Format(time.RFC3339Nano)))
+	}
+	if !cfg.Range.Until.IsZero() {
+		where = append(where, fmt.Sprintf("%s < parseDateTime64BestEffort('%s', 3)", ts, cfg.Range.Until.UTC().Format(time.RFC3339Nano)))
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " FORMAT JSONEachRow"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cfg.URL, "/")+"/", strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.User != "" || cfg.Password != "" {
+		req.SetBasicAuth(cfg.User, cfg.Password)
+	}
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("clickhouse %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &clickHouseSource{body: resp.Body, sc: sc, batch: cfg.BatchSize}, nil
+}
+
+func (s *clickHouseSource) Next(ctx context.Context) ([]event.Event, error) {
+	batch := make([]event.Event, 0, s.batch)
+	for len(batch) < s.batch && s.sc.Scan() {
+		line := bytes.TrimSpace(s.sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var row struct {
+			Event string `json:"event"`
+		}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return nil, fmt.Errorf("clickhouse row: %w", err)
+		}
+		var ev event.Event
+		if err := json.Unmarshal([]byte(row.Event), &ev); err != nil {
+			return nil, fmt.Errorf("clickhouse event: %w", err)
+		}
+		batch = append(batch, ev)
+	}
+	if err := s.sc.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse: %w", err)
+	}
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+func (s *clickHouseSource) Close() error {
+	return s.body.Close()
+}
+
+// ElasticsearchConfig locates the index written by the Elasticsearch output.
+type ElasticsearchConfig struct {
+	URL       string
+	Index     string
+	User      string
+	Pass      string
+	Range     Range
+	BatchSize int    // default 500
+	Proxy     string // see the proxy package; "" honors HTTPS_PROXY
+}
+
+// scrollKeepAlive is how long Elasticsearch keeps the scroll context between batches.
+const scrollKeepAlive = "5m"
+
+type elasticsearchSource struct {
+	cfg      ElasticsearchConfig
+	client   *http.Client
+	scrollID string
+	started  bool
+	done     bool
+}
+
+// OpenElasticsearch reads the index with the scroll API, in index order.
+func OpenElasticsearch(cfg ElasticsearchConfig) (Source, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Index == "" {
+		cfg.Index = "loom-events"
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &elasticsearchSource{cfg: cfg, client: &http.Client{Timeout: 60 * time.Second, Transport: transport}}, nil
+}
+
+func (s *elasticsearchSource) Next(ctx context.Context) ([]event.Event, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	var (
+		path string
+		body interface{}
+	)
+	if !s.started {
+		s.started = true
+		path = "/" + url.PathEscape(s.cfg.Index) + "/_search?scroll=" + scrollKeepAlive
+		search := map[string]interface{}{"size": s.cfg.BatchSize, "sort": []string{"_doc"}}
+		if r := s.rangeQuery(); r != nil {
+			search["query"] = map[string]interface{}{"range": map[string]interface{}{"@timestamp": r}}
+		}
+		body = search
+	} else {
+		path = "/_search/scroll"
+		body = map[string]string{"scroll": scrollKeepAlive, "scroll_id": s.scrollID}
+	}
+	var resp struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []struct {
+				Source event.Event `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := s.do(ctx, http.MethodPost, path, body, &resp); err != nil {
+		return nil, err
+	}
+	s.scrollID = resp.ScrollID
+	if len(resp.Hits.Hits) == 0 {
+		s.done = true
+		return nil, io.EOF
+	}
+	batch := make([]event.Event, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		if h.Source != nil {
+			batch = append(batch, h.Source)
+		}
+	}
+	return batch, nil
+}
+
+func (s *elasticsearchSource) rangeQuery() map[string]string {
+	r := make(map[string]string)
+	if !s.cfg.Range.Since.IsZero() {
+		r["gte"] = s.cfg.Range.Since.UTC().Format(time.RFC3339Nano)
+	}
+	if !s.cfg.Range.Until.IsZero() {
+		r["lt"] = s.cfg.Range.Until.UTC().Format(time.RFC3339Nano)
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	return r
+}
+
+// Close releases the scroll context.
+func (s *elasticsearchSource) Close() error {
+	if s.scrollID == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.do(ctx, http.MethodDelete, "/_search/scroll", map[string]string{"scroll_id": s.scrollID}, nil)
+}
+
+func (s *elasticsearchSource) do(ctx context.Context, method, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.cfg.URL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.User != "" || s.cfg.Pass != "" {
+		req.SetBasicAuth(s.cfg.User, s.cfg.Pass)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("elasticsearch %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
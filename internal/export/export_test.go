@@ -0,0 +1,108 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func drain(t *testing.T, src Source) [][]event.Event {
+	t.Helper()
+	var batches [][]event.Event
+	for {
+		batch, err := src.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			return batches
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, batch)
+	}
+}
+
+func TestClickHouse_StreamsRowsInBatches(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		query = string(b)
+		for i := 1; i <= 3; i++ {
+			row, _ := json.Marshal(map[string]string{"event": fmt.Sprintf(`{"n":%d}`, i)})
+			fmt.Fprintf(w, "%s\n", row)
+		}
+	}))
+	defer srv.Close()
+
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	src, err := OpenClickHouse(context.Background(), ClickHouseConfig{URL: srv.URL, Database: "loom", Table: "events", Range: Range{Since: since}, BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	batches := drain(t, src)
+	if len(batches) != 2 || len(batches[0]) != 2 || batches[1][0]["n"] != float64(3) {
+		t.Errorf("batches = %v", batches)
+	}
+	if !strings.Contains(query, "FROM loom.events WHERE") || !strings.Contains(query, "2024-05-01T00:00:00Z") || strings.Contains(query, " < ") {
+		t.Errorf("query = %s", query)
+	}
+}
+
+func TestClickHouse_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Table loom.events doesn't exist", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	if _, err := OpenClickHouse(context.Background(), ClickHouseConfig{URL: srv.URL, Database: "loom", Table: "events"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestElasticsearch_Scroll(t *testing.T) {
+	pages := [][]string{{`{"n":1}`, `{"n":2}`}, {`{"n":3}`}, {}}
+	var calls []string
+	var firstBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodDelete {
+			return
+		}
+		if len(calls) == 1 {
+			_ = json.NewDecoder(r.Body).Decode(&firstBody)
+		}
+		page := pages[0]
+		pages = pages[1:]
+		hits := make([]string, len(page))
+		for i, src := range page {
+			hits[i] = `{"_source":` + src + `}`
+		}
+		fmt.Fprintf(w, `{"_scroll_id":"sid","hits":{"hits":[%s]}}`, strings.Join(hits, ","))
+	}))
+	defer srv.Close()
+
+	src, _ := OpenElasticsearch(ElasticsearchConfig{URL: srv.URL, Index: "loom-events", Range: Range{Until: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)}, BatchSize: 2})
+	batches := drain(t, src)
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || batches[1][0]["n"] != float64(3) {
+		t.Errorf("batches = %v", batches)
+	}
+	want := []string{"POST /loom-events/_search", "POST /_search/scroll", "POST /_search/scroll", "DELETE /_search/scroll"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	rng := firstBody["query"].(map[string]interface{})["range"].(map[string]interface{})["@timestamp"].(map[string]interface{})
+	if rng["lt"] != "2024-05-02T00:00:00Z" || rng["gte"] != nil {
+		t.Errorf("range = %v", rng)
+	}
+}
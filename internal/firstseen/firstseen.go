@@ -0,0 +1,254 @@
+// Package firstseen tags events whose indicators (source IP, JA3, ...) have never been observed
+// before, using a bloom filter persisted to disk so "new" survives restarts.
+package firstseen
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// DefaultFields are the indicators tracked when none are configured.
+var DefaultFields = []string{"source.ip"}
+
+const fileMagic = "LOOMBF1\n"
+
+// Tracker remembers indicators in a bloom filter. A false positive means a new indicator is
+// occasionally not tagged; a seen indicator is never tagged again. A nil *Tracker does nothing.
+type Tracker struct {
+	fields []string
+	path   string
+
+	mu    sync.Mutex
+	bits  []uint64
+	m     uint64 // number of bits
+	k     uint64 // hash functions
+	added uint64 // indicators inserted since creation
+	dirty bool
+
+	shared SharedBits // optional; decides what is new across replicas
+}
+
+// SharedBits is a bitmap shared by Loom replicas (see internal/shared). SetBits sets the bits at
+// offsets and reports whether any of them was clear.
+type SharedBits interface {
+	SetBits(ctx context.Context, key string, offsets []uint64) (bool, error)
+}
+
+// UseShared makes the tracker record indicators in s as well, so an indicator seen by one replica
+// is not new on another. The local filter is still updated and saved, and decides while s fails.
+// The bitmap key includes the filter size, so replicas must be sized alike to share it.
+func (t *Tracker) UseShared(s SharedBits) error {
+	if t == nil {
+		return nil
+	}
+	if t.m > 1<<32 {
+		return fmt.Errorf("first_seen: filter of %d bits is too large for a shared bitmap (at most 2^32)", t.m)
+	}
+	t.mu.Lock()
+	t.shared = s
+	t.mu.Unlock()
+	return nil
+}
+
+// Open loads the filter from path, or creates an empty one sized for expected indicators at the
+// false-positive rate fpRate. A file with different sizing is discarded (all indicators count as new
+// again). path "" keeps the filter in memory only.
+func Open(path string, fields []string, expected int, fpRate float64) (*Tracker, error) {
+	if len(fields) == 0 {
+		fields = DefaultFields
+	}
+	if expected <= 0 {
+		expected = 5000000
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.001
+	}
+	m := uint64(math.Ceil(-float64(expected) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(expected)*math.Ln2)))
+	t := &Tracker{fields: fields, path: path, m: m, k: k, bits: make([]uint64, m/64)}
+	if path == "" {
+		return t, nil
+	}
+	if err := t.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		if !errors.Is(err, errSizing) {
+			return nil, fmt.Errorf("first_seen: %w", err)
+		}
+		t.dirty = true // resized: overwrite the old file on the next save
+	}
+	return t, nil
+}
+
+var errSizing = errors.New("filter sizing changed")
+
+// Apply checks the event's indicators and sets loom.first_seen = true (with the new indicators in
+// loom.first_seen_fields) when any of them was not seen before. Indicators are recorded either way.
+func (t *Tracker) Apply(event event.Event) {
+	if t == nil || event == nil {
+		return
+	}
+	var fresh []string
+	for _, f := range t.fields {
+		v := ecs.GetString(event, f)
+		if v == "" {
+			continue
+		}
+		if t.testAndAdd(f + "=" + v) {
+			fresh = append(fresh, f)
+		}
+	}
+	if len(fresh) > 0 {
+		loom := ecs.Map(event, "loom")
+		loom["first_seen"] = true
+		loom["first_seen_fields"] = fresh
+	}
+}
+
+// testAndAdd inserts key and reports whether it was absent.
+func (t *Tracker) testAndAdd(key string) bool {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	h1 := mix64(h.Sum64())
+	h2 := mix64(h1) | 1
+	t.mu.Lock()
+	offsets := make([]uint64, t.k)
+	absent := false
+	for i := range offsets {
+		bit := (h1 + uint64(i)*h2) % t.m
+		offsets[i] = bit
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if t.bits[word]&mask == 0 {
+			absent = true
+			t.bits[word] |= mask
+		}
+	}
+	if absent {
+		t.added++
+		t.dirty = true
+	}
+	shared, key := t.shared, fmt.Sprintf("first_seen:%d:%d", t.m, t.k)
+	t.mu.Unlock()
+
+	if shared != nil {
+		if clear, err := shared.SetBits(context.Background(), key, offsets); err == nil {
+			return clear
+		}
+	}
+	return absent
+}
+
+// mix64 is the splitmix64 finalizer; FNV alone spreads similar keys poorly over the bit positions.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Save writes the filter to its file if it changed since the last save (atomically, via rename).
+func (t *Tracker) Save() error {
+	if t == nil || t.path == "" {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	bits := append([]uint64(nil), t.bits...)
+	header := []uint64{t.m, t.k, t.added}
+	t.dirty = false
+	t.mu.Unlock()
+
+	tmp := t.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	_, _ = w.WriteString(fileMagic)
+	_ = binary.Write(w, binary.LittleEndian, header)
+	_ = binary.Write(w, binary.LittleEndian, bits)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+func (t *Tracker) load() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		return fmt.Errorf("%s: not a first-seen filter", t.path)
+	}
+	header := make([]uint64, 3)
+	if err := binary.Read(r, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("%s: %w", t.path, err)
+	}
+	if header[0] != t.m || header[1] != t.k {
+		return errSizing
+	}
+	if err := binary.Read(r, binary.LittleEndian, t.bits); err != nil {
+		return fmt.Errorf("%s: %w", t.path, err)
+	}
+	t.added = header[2]
+	return nil
+}
+
+// Run saves every interval until ctx is done. Call Save once more after the last Apply.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration, onErr func(error)) {
+	if t == nil || t.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Save(); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// Len returns the approximate number of distinct indicators recorded.
+func (t *Tracker) Len() uint64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.added
+}
@@ -0,0 +1,183 @@
+package firstseen
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEvent(ip, ja3 string) map[string]interface{} {
+	ev := map[string]interface{}{"source": map[string]interface{}{"ip": ip}}
+	if ja3 != "" {
+		ev["tls"] = map[string]interface{}{"client": map[string]interface{}{"ja3": ja3}}
+	}
+	return ev
+}
+
+func firstSeenFields(ev map[string]interface{}) []string {
+	loom, _ := ev["loom"].(map[string]interface{})
+	if loom == nil || loom["first_seen"] != true {
+		return nil
+	}
+	fields, _ := loom["first_seen_fields"].([]string)
+	return fields
+}
+
+func TestApply_TagsOnlyFirstOccurrence(t *testing.T) {
+	tr, err := Open("", []string{"source.ip", "tls.client.ja3"}, 1000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := testEvent("192.0.2.1", "abc")
+	tr.Apply(ev)
+	if got := firstSeenFields(ev); len(got) != 2 || got[0] != "source.ip" || got[1] != "tls.client.ja3" {
+		t.Fatalf("first event: first_seen_fields = %v", got)
+	}
+	ev = testEvent("192.0.2.1", "abc")
+	tr.Apply(ev)
+	if _, ok := ev["loom"]; ok {
+		t.Fatalf("repeat event tagged: %v", ev["loom"])
+	}
+	ev = testEvent("192.0.2.1", "def")
+	tr.Apply(ev)
+	if got := firstSeenFields(ev); len(got) != 1 || got[0] != "tls.client.ja3" {
+		t.Fatalf("new ja3: first_seen_fields = %v", got)
+	}
+	if tr.Len() != 3 {
+		t.Errorf("Len = %d, want 3", tr.Len())
+	}
+}
+
+func TestApply_MissingFieldAndNil(t *testing.T) {
+	tr, _ := Open("", nil, 1000, 0.001)
+	ev := map[string]interface{}{"message": "x"}
+	tr.Apply(ev)
+	if _, ok := ev["loom"]; ok {
+		t.Fatal("event without source.ip tagged")
+	}
+	var nilTracker *Tracker
+	nilTracker.Apply(testEvent("192.0.2.1", ""))
+	if err := nilTracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSave_PersistsAcrossOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "first_seen.bloom")
+	tr, err := Open(path, nil, 1000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Apply(testEvent("192.0.2.1", ""))
+	if err := tr.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path, nil, 1000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 1 {
+		t.Errorf("Len after reopen = %d, want 1", reopened.Len())
+	}
+	ev := testEvent("192.0.2.1", "")
+	reopened.Apply(ev)
+	if _, ok := ev["loom"]; ok {
+		t.Fatal("indicator from the saved filter tagged again")
+	}
+	ev = testEvent("192.0.2.2", "")
+	reopened.Apply(ev)
+	if firstSeenFields(ev) == nil {
+		t.Fatal("new indicator not tagged after reopen")
+	}
+}
+
+func TestOpen_SizingChangeStartsFresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "first_seen.bloom")
+	tr, _ := Open(path, nil, 1000, 0.001)
+	tr.Apply(testEvent("192.0.2.1", ""))
+	if err := tr.Save(); err != nil {
+		t.Fatal(err)
+	}
+	resized, err := Open(path, nil, 5000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := testEvent("192.0.2.1", "")
+	resized.Apply(ev)
+	if firstSeenFields(ev) == nil {
+		t.Fatal("resized filter should start empty")
+	}
+}
+
+func TestOpen_RejectsForeignFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "first_seen.bloom")
+	if err := os.WriteFile(path, []byte("not a filter"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, nil, 1000, 0.001); err == nil {
+		t.Fatal("expected error for a file that is not a filter")
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	tr, _ := Open("", nil, 10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		tr.testAndAdd("in-" + string(rune(i)))
+	}
+	full := append([]uint64(nil), tr.bits...)
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if !tr.testAndAdd("out-" + string(rune(i))) {
+			fp++
+		}
+		copy(tr.bits, full) // probe without growing the filter
+	}
+	if fp > 200 { // ~1% expected; allow slack
+		t.Errorf("false positives = %d of 10000", fp)
+	}
+}
+
+// memBits is a shared bitmap as two replicas would see it in Redis.
+type memBits struct {
+	set  map[uint64]bool
+	fail bool
+}
+
+func (m *memBits) SetBits(_ context.Context, _ string, offsets []uint64) (bool, error) {
+	if m.fail {
+		return false, errors.New("redis down")
+	}
+	clear := false
+	for _, o := range offsets {
+		if !m.set[o] {
+			clear = true
+			m.set[o] = true
+		}
+	}
+	return clear, nil
+}
+
+func TestUseShared_ReplicasAgree(t *testing.T) {
+	bits := &memBits{set: map[uint64]bool{}}
+	a, _ := Open("", nil, 1000, 0.001)
+	b, _ := Open("", nil, 1000, 0.001)
+	for _, tr := range []*Tracker{a, b} {
+		if err := tr.UseShared(bits); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !a.testAndAdd("source.ip=192.0.2.1") {
+		t.Fatal("first sighting on replica a not new")
+	}
+	if b.testAndAdd("source.ip=192.0.2.1") {
+		t.Error("indicator seen by replica a is new on replica b")
+	}
+
+	bits.fail = true // falls back to b's own filter
+	if !b.testAndAdd("source.ip=192.0.2.2") || b.testAndAdd("source.ip=192.0.2.2") {
+		t.Error("local fallback while the shared bitmap fails")
+	}
+}
@@ -0,0 +1,61 @@
+package ingest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SensorActivity records when each sensor last delivered events, so a sensor that goes quiet can be
+// alerted on. A nil *SensorActivity records nothing.
+type SensorActivity struct {
+	mu      sync.Mutex
+	sensors map[string]*SensorStats
+	nowFn   func() time.Time
+}
+
+// SensorStats is one sensor's accepted traffic since startup.
+type SensorStats struct {
+	SensorID string    `json:"sensor_id"`
+	LastSeen time.Time `json:"last_seen"`
+	Batches  uint64    `json:"batches"`
+	Events   uint64    `json:"events"`
+}
+
+// NewSensorActivity creates an empty tracker.
+func NewSensorActivity() *SensorActivity {
+	return &SensorActivity{sensors: make(map[string]*SensorStats), nowFn: time.Now}
+}
+
+// Record notes a batch of n events accepted from sensorID.
+func (a *SensorActivity) Record(sensorID string, n int) {
+	if a == nil {
+		return
+	}
+	now := a.nowFn()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := a.sensors[sensorID]
+	if st == nil {
+		st = &SensorStats{SensorID: sensorID}
+		a.sensors[sensorID] = st
+	}
+	st.LastSeen = now
+	st.Batches++
+	st.Events += uint64(n)
+}
+
+// Snapshot returns the stats of every sensor seen so far, sorted by sensor ID.
+func (a *SensorActivity) Snapshot() []SensorStats {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	out := make([]SensorStats, 0, len(a.sensors))
+	for _, st := range a.sensors {
+		out = append(out, *st)
+	}
+	a.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].SensorID < out[j].SensorID })
+	return out
+}
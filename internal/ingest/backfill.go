@@ -0,0 +1,49 @@
+package ingest
+
+import (
+	"context"
+	"net/http"
+)
+
+// BackfillPath is the ingest route of the backfill mode (see Backfill).
+const BackfillPath = "/api/v1/ingest/backfill"
+
+// Backfill configures bulk replays of archived sensor events (weeks of NDJSON) through
+// ServeBackfill. Its requests skip the sensor and tenant request rate limits; authentication,
+// administrator pauses, back-pressure, the tenant quota and the size limits still apply. Backfilled
+// batches leave the sensor's last-seen time and clock offset alone: they say nothing about the
+// sensor being up now.
+type Backfill struct {
+	MaxBodyBytes int64           // 0: the handler's MaxBodyBytes
+	MaxEvents    int             // 0: the handler's MaxEvents (or the tenant's)
+	Sensors      map[string]bool // sensors whose tokens may backfill; nil allows all
+}
+
+// allows reports whether sensorID may use the backfill mode.
+func (b *Backfill) allows(sensorID string) bool {
+	return b.Sensors == nil || b.Sensors[sensorID]
+}
+
+// ServeBackfill handles a backfill request like ServeHTTP, with the relaxed limits of h.Backfill.
+// Without h.Backfill it answers 404.
+func (h *Handler) ServeBackfill(w http.ResponseWriter, r *http.Request) {
+	if h.Backfill == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.serve(w, r, true)
+}
+
+type backfillKey struct{}
+
+// withBackfill returns ctx marked as carrying a backfill batch, for IsBackfill.
+func withBackfill(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backfillKey{}, true)
+}
+
+// IsBackfill reports whether ctx belongs to a backfill request, so ProcessBatch can skip what only
+// live traffic should feed (sensor location, clock offsets).
+func IsBackfill(ctx context.Context) bool {
+	b, _ := ctx.Value(backfillKey{}).(bool)
+	return b
+}
@@ -0,0 +1,73 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandler_Backfill(t *testing.T) {
+	h := makeTestHandler(t)
+	h.RateLimiter = ratelimit.NewPerSensorLimiter(1)
+	h.MaxEvents = 1
+	h.Activity = NewSensorActivity()
+	h.Metrics = NewMetrics(prometheus.NewRegistry())
+	var backfills int
+	h.ProcessBatch = func(ctx context.Context, _ string, _ []event.Event) error {
+		if IsBackfill(ctx) {
+			backfills++
+		}
+		return nil
+	}
+	older, newer := spipStyleEvent("1.2.3.4", "spip-001"), spipStyleEvent("1.2.3.5", "spip-001")
+	older["@timestamp"], newer["@timestamp"] = "2026-03-01T10:00:00Z", "2026-03-01T12:00:00Z"
+	body := mustJSON([]interface{}{newer, older})
+	post := func(serve http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, BackfillPath, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		serve(rec, req)
+		return rec
+	}
+
+	if rec := post(h.ServeBackfill); rec.Code != http.StatusNotFound {
+		t.Fatalf("backfill disabled: status = %d, want 404", rec.Code)
+	}
+	h.Backfill = &Backfill{MaxEvents: 100}
+	// Past the sensor's 1 rps and with more events than MaxEvents
+	for i := 0; i < 3; i++ {
+		if rec := post(h.ServeBackfill); rec.Code != http.StatusNoContent {
+			t.Fatalf("backfill %d: status = %d, body %s", i, rec.Code, rec.Body)
+		}
+	}
+	if backfills != 3 {
+		t.Errorf("batches marked as backfill = %d, want 3", backfills)
+	}
+	if snap := h.Activity.Snapshot(); len(snap) != 0 {
+		t.Errorf("backfills recorded as sensor activity: %+v", snap)
+	}
+	if got := testutil.ToFloat64(h.Metrics.BackfillEventsTotal.WithLabelValues("spip-001")); got != 6 {
+		t.Errorf("backfill events = %v, want 6", got)
+	}
+	if got := testutil.ToFloat64(h.Metrics.BackfillEventTimestamp.WithLabelValues("spip-001")); got != 1772366400 {
+		t.Errorf("backfill timestamp = %v, want 1772366400 (2026-03-01T12:00:00Z)", got)
+	}
+	// Regular ingest keeps its limits.
+	if rec := post(h.ServeHTTP); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("ingest: status = %d, want 413", rec.Code)
+	}
+
+	h.Backfill.Sensors = map[string]bool{"spip-002": true}
+	rec := post(h.ServeBackfill)
+	if rec.Code != http.StatusForbidden || !bytes.Contains(rec.Body.Bytes(), []byte("backfill_not_allowed")) {
+		t.Errorf("sensor not allowed: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,118 @@
+package ingest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// ClockField holds the correction applied to an event's @timestamp: offset_seconds (subtracted)
+// and original_timestamp.
+const ClockField = "loom.clock"
+
+// ClockSkew estimates each sensor's clock offset: the newest @timestamp of a batch minus the time
+// the batch was received, smoothed over batches. Batching delays make the estimate slightly
+// negative for a sensor with a correct clock. A nil *ClockSkew does nothing.
+type ClockSkew struct {
+	correctAbove time.Duration   // 0: never correct
+	only         map[string]bool // sensors to correct; empty means all
+
+	mu      sync.Mutex
+	offsets map[string]time.Duration
+}
+
+// ClockOffset is one sensor's estimated clock offset.
+type ClockOffset struct {
+	SensorID string
+	Offset   time.Duration
+}
+
+// NewClockSkew creates a tracker. With correctAbove > 0, Correct shifts the @timestamp of events
+// from sensors whose offset exceeds it (either way), limited to sensors when not empty.
+func NewClockSkew(correctAbove time.Duration, sensors []string) *ClockSkew {
+	c := &ClockSkew{correctAbove: correctAbove, offsets: make(map[string]time.Duration)}
+	if len(sensors) > 0 {
+		c.only = make(map[string]bool, len(sensors))
+		for _, id := range sensors {
+			c.only[id] = true
+		}
+	}
+	return c
+}
+
+// Observe updates sensorID's offset from a batch received at received. Batches without a valid
+// @timestamp are ignored.
+func (c *ClockSkew) Observe(sensorID string, events []event.Event, received time.Time) {
+	if c == nil {
+		return
+	}
+	var newest time.Time
+	for _, ev := range events {
+		if ts, ok := ev.Timestamp(); ok && ts.After(newest) {
+			newest = ts
+		}
+	}
+	if newest.IsZero() {
+		return
+	}
+	sample := newest.Sub(received)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if off, ok := c.offsets[sensorID]; ok {
+		c.offsets[sensorID] = off + (sample-off)/5
+	} else {
+		c.offsets[sensorID] = sample
+	}
+}
+
+// Offset returns sensorID's estimated offset (positive: the sensor's clock is ahead) and whether
+// one is known.
+func (c *ClockSkew) Offset(sensorID string) (time.Duration, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	off, ok := c.offsets[sensorID]
+	return off, ok
+}
+
+// Correct subtracts sensorID's offset from ev's @timestamp when correction is on for the sensor
+// and the offset exceeds the threshold, recording it in ClockField. It reports whether ev changed.
+func (c *ClockSkew) Correct(sensorID string, ev event.Event) bool {
+	if c == nil || c.correctAbove <= 0 || (c.only != nil && !c.only[sensorID]) {
+		return false
+	}
+	off, ok := c.Offset(sensorID)
+	if !ok || (off <= c.correctAbove && off >= -c.correctAbove) {
+		return false
+	}
+	ts, ok := ev.Timestamp()
+	if !ok {
+		return false
+	}
+	original := ev["@timestamp"]
+	ev["@timestamp"] = ts.Add(-off).UTC().Format(time.RFC3339Nano)
+	ev.Set(ClockField, map[string]interface{}{
+		"offset_seconds":     off.Seconds(),
+		"original_timestamp": original,
+	})
+	return true
+}
+
+// Snapshot returns the offset of every sensor seen so far, sorted by sensor ID.
+func (c *ClockSkew) Snapshot() []ClockOffset {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	out := make([]ClockOffset, 0, len(c.offsets))
+	for id, off := range c.offsets {
+		out = append(out, ClockOffset{SensorID: id, Offset: off})
+	}
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].SensorID < out[j].SensorID })
+	return out
+}
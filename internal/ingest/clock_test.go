@@ -0,0 +1,50 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestClockSkew(t *testing.T) {
+	received := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	batch := func(ts ...string) []event.Event {
+		out := make([]event.Event, len(ts))
+		for i, s := range ts {
+			out[i] = event.Event{"@timestamp": s}
+		}
+		return out
+	}
+	c := NewClockSkew(5*time.Minute, []string{"slow", "fast"})
+	c.Observe("slow", batch("2026-03-01T11:49:00Z", "2026-03-01T11:50:00Z", "not a time"), received)
+	c.Observe("fast", batch("2026-03-01T12:01:00Z"), received)
+	c.Observe("fast", batch("2026-03-01T12:06:00Z"), received)
+	c.Observe("other", batch("2026-03-01T13:00:00Z"), received)
+	c.Observe("none", batch(), received)
+
+	if off, ok := c.Offset("slow"); !ok || off != -10*time.Minute {
+		t.Errorf("slow offset = %v, %v", off, ok)
+	}
+	if off, _ := c.Offset("fast"); off != 2*time.Minute {
+		t.Errorf("fast offset = %v, want the smoothed 2m", off)
+	}
+	if _, ok := c.Offset("none"); ok || len(c.Snapshot()) != 3 {
+		t.Errorf("snapshot = %v", c.Snapshot())
+	}
+
+	ev := event.Event{"@timestamp": "2026-03-01T11:50:00Z"}
+	if !c.Correct("slow", ev) || ev["@timestamp"] != "2026-03-01T12:00:00Z" ||
+		ev.Get("loom.clock.original_timestamp") != "2026-03-01T11:50:00Z" || ev.Get("loom.clock.offset_seconds") != -600.0 {
+		t.Errorf("corrected = %v", ev)
+	}
+	for _, id := range []string{"fast", "other"} { // under the threshold; not listed
+		ev := event.Event{"@timestamp": "2026-03-01T12:00:00Z"}
+		if c.Correct(id, ev) || ev["@timestamp"] != "2026-03-01T12:00:00Z" {
+			t.Errorf("%s corrected: %v", id, ev)
+		}
+	}
+	if NewClockSkew(0, nil).Correct("slow", event.Event{"@timestamp": "2026-03-01T11:50:00Z"}) {
+		t.Error("corrected without a threshold")
+	}
+}
@@ -0,0 +1,158 @@
+package ingest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type sensorSlotKey struct{}
+
+type sensorSlot struct {
+	id string
+}
+
+// WithSensorSlot returns a context in which the handler records the authenticated sensor ID,
+// and a func that reads it back once the request is done (e.g. for access logging).
+func WithSensorSlot(ctx context.Context) (context.Context, func() string) {
+	slot := &sensorSlot{}
+	return context.WithValue(ctx, sensorSlotKey{}, slot), func() string { return slot.id }
+}
+
+// recordSensor stores sensorID in the request's sensor slot, if any.
+func recordSensor(ctx context.Context, sensorID string) {
+	if slot, ok := ctx.Value(sensorSlotKey{}).(*sensorSlot); ok {
+		slot.id = sensorID
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestIDHeader carries the request ID: taken from the request when the client sends a usable
+// one, and always set on the response.
+const RequestIDHeader = "X-Request-ID"
+
+// WithRequestID returns r's context carrying the request's ID, and sets it as X-Request-ID on w.
+// The client's X-Request-ID is kept when it is 1-128 characters of [A-Za-z0-9._:-]; otherwise
+// a random ID is generated.
+func WithRequestID(w http.ResponseWriter, r *http.Request) context.Context {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return context.WithValue(r.Context(), requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == ':' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type transportKey struct{}
+
+type peerKey struct{}
+
+// RecordPeer is middleware that keeps the connection's peer address (r.RemoteAddr) in the request
+// context, for Transport. Put it before middleware that rewrites r.RemoteAddr from headers
+// (chi's RealIP): those headers are whatever the sender put in them.
+func RecordPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerKey{}, r.RemoteAddr)))
+	})
+}
+
+// TransportField holds TransportInfo.Fields on events when observability.event_transport is on.
+const TransportField = "loom.transport"
+
+// TransportInfo describes the connection an ingest request arrived on, to spot a sensor's token
+// being used from somewhere else.
+type TransportInfo struct {
+	RemoteIP         string // the connection's peer: the proxy's address when behind one
+	ForwardedIP      string // from X-Forwarded-For, X-Real-IP or True-Client-IP; set by the sender, not verified
+	HTTPVersion      string // e.g. "1.1", "2.0"
+	TLSVersion       string // e.g. "1.3"; empty without TLS
+	TLSCipher        string
+	ClientCertSHA256 string // hex SHA-256 of the client certificate, if the sensor presented one
+}
+
+// withTransport returns ctx carrying the connection details of r, for Transport. The peer address
+// is the one RecordPeer kept; without it, r.RemoteAddr.
+func withTransport(ctx context.Context, r *http.Request) context.Context {
+	peer, ok := r.Context().Value(peerKey{}).(string)
+	if !ok {
+		peer = r.RemoteAddr
+	}
+	t := &TransportInfo{RemoteIP: hostOf(peer)}
+	if addr := hostOf(r.RemoteAddr); addr != t.RemoteIP {
+		t.ForwardedIP = addr
+	}
+	t.HTTPVersion = strings.TrimPrefix(r.Proto, "HTTP/")
+	if cs := r.TLS; cs != nil {
+		t.TLSVersion = strings.TrimPrefix(tls.VersionName(cs.Version), "TLS ")
+		t.TLSCipher = tls.CipherSuiteName(cs.CipherSuite)
+		if len(cs.PeerCertificates) > 0 {
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			t.ClientCertSHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+	return context.WithValue(ctx, transportKey{}, t)
+}
+
+// hostOf returns the host of a host:port address, or addr when it has no port.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Transport returns the connection details of the ingest request in ctx, or nil outside one.
+func Transport(ctx context.Context) *TransportInfo {
+	t, _ := ctx.Value(transportKey{}).(*TransportInfo)
+	return t
+}
+
+// Fields returns t as a new object for TransportField; empty values are left out.
+func (t *TransportInfo) Fields() map[string]interface{} {
+	m := map[string]interface{}{"http_version": t.HTTPVersion}
+	if t.RemoteIP != "" {
+		m["remote_ip"] = t.RemoteIP
+	}
+	if t.ForwardedIP != "" {
+		m["forwarded_ip"] = t.ForwardedIP
+	}
+	if t.TLSVersion != "" {
+		tlsInfo := map[string]interface{}{"version": t.TLSVersion, "cipher": t.TLSCipher}
+		if t.ClientCertSHA256 != "" {
+			tlsInfo["client_cert_sha256"] = t.ClientCertSHA256
+		}
+		m["tls"] = tlsInfo
+	}
+	return m
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// ECSFields are the top-level fields of ECS: the base fields and the field sets. Strict mode allows
+// these when no list is configured. loom is not among them: only Loom sets loom.* fields.
+var ECSFields = []string{
+	"@timestamp", "message", "tags", "labels", "ecs",
+	"agent", "as", "client", "cloud", "code_signature", "container", "data_stream", "destination",
+	"device", "dll", "dns", "email", "error", "event", "faas", "file", "geo", "group", "hash", "host",
+	"http", "interface", "log", "network", "observer", "orchestrator", "organization", "os",
+	"package", "pe", "process", "registry", "related", "risk", "rule", "server", "service", "source",
+	"span", "threat", "tls", "trace", "transaction", "url", "user", "user_agent", "vlan",
+	"vulnerability", "x509",
+}
+
+// FieldAllowlist restricts events to a set of top-level fields (strict mode), so a sensor cannot
+// store arbitrary data alongside its events.
+type FieldAllowlist struct {
+	allowed map[string]bool
+	strip   bool
+}
+
+// NewFieldAllowlist allows fields, or ECSFields if empty. With strip, other fields are removed from
+// events; without, an event that has any is rejected.
+func NewFieldAllowlist(fields []string, strip bool) *FieldAllowlist {
+	if len(fields) == 0 {
+		fields = ECSFields
+	}
+	a := &FieldAllowlist{allowed: make(map[string]bool, len(fields)), strip: strip}
+	for _, f := range fields {
+		a.allowed[f] = true
+	}
+	return a
+}
+
+// Check looks for fields of ev that are not allowed. When stripping it removes them and returns how
+// many; otherwise it returns the first by name, leaving ev as is.
+func (a *FieldAllowlist) Check(ev event.Event) (unknown string, stripped int) {
+	if a == nil {
+		return "", 0
+	}
+	for k := range ev {
+		if a.allowed[k] {
+			continue
+		}
+		if a.strip {
+			delete(ev, k)
+			stripped++
+		} else if unknown == "" || k < unknown {
+			unknown = k
+		}
+	}
+	return unknown, stripped
+}
@@ -0,0 +1,116 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+)
+
+// Gate turns new ingest requests away while Loom drains (e.g. before a blue/green switch) and
+// counts the requests still being processed. A nil *Gate is always open.
+type Gate struct {
+	mu       sync.Mutex
+	closed   bool
+	inFlight int
+	idle     chan struct{} // closed when inFlight drops to 0 while the gate is closed
+}
+
+// NewGate returns an open gate.
+func NewGate() *Gate {
+	return &Gate{}
+}
+
+// enter admits a request unless the gate is closed; admitted requests must call leave.
+func (g *Gate) enter() bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.inFlight++
+	return true
+}
+
+// hold counts work that an admitted request leaves running after it is answered, even while the
+// gate is closed; it must call leave.
+func (g *Gate) hold() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.inFlight++
+	g.mu.Unlock()
+}
+
+func (g *Gate) leave() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+	if g.inFlight == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// Close turns new requests away; requests already admitted finish.
+func (g *Gate) Close() {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+}
+
+// Open admits requests again.
+func (g *Gate) Open() {
+	g.mu.Lock()
+	g.closed = false
+	g.mu.Unlock()
+}
+
+// Closed reports whether new requests are turned away.
+func (g *Gate) Closed() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+// InFlight returns the number of admitted requests still being processed.
+func (g *Gate) InFlight() int {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.inFlight
+}
+
+// Wait returns once no admitted request is left, or with ctx's error. Call it after Close.
+func (g *Gate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	if g.inFlight == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainingRetryAfter is the Retry-After (seconds) of requests turned away by a closed gate: long
+// enough for a load balancer to move the sensors to another instance.
+const drainingRetryAfter = "30"
@@ -1,13 +1,22 @@
 package ingest
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/profile"
 	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/StefanGrimminck/Loom/internal/tenant"
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog"
 )
 
@@ -18,159 +27,228 @@ type Handler struct {
 	MaxBodyBytes  int64
 	MaxEvents     int
 	MaxEventBytes int64
-	ProcessBatch  func(sensorID string, events []map[string]interface{}) error
+	ProcessBatch  func(ctx context.Context, sensorID string, events []event.Event) error // ctx carries the request ID and Transport
 	Log           zerolog.Logger
 	Metrics       *Metrics
+	Activity      *SensorActivity  // optional last-seen tracking per sensor
+	Tenants       *tenant.Registry // optional per-tenant rate limits, quotas and batch sizes
+	// Control, if set, rejects the requests of sensors an administrator paused (429) or
+	// disabled (403).
+	Control *SensorControl
+	// Gate, if set, answers 503 while it is closed (drain mode) and counts the requests in
+	// progress so a drain can wait for them.
+	Gate *Gate
+	// Backpressure, if set, returns how long sensors should wait while the output is backed up;
+	// requests get 503 with that Retry-After while it is > 0.
+	Backpressure func() time.Duration
+	// ProcessRaw, if set, takes the events instead of ProcessBatch (passthrough mode): each is
+	// checked to be a JSON object within MaxEventBytes and compacted, but never decoded.
+	ProcessRaw func(ctx context.Context, sensorID string, events []json.RawMessage) error
+	// Fields, if set, restricts the top-level fields of events (strict mode). Not used with ProcessRaw.
+	Fields *FieldAllowlist
+	// SplitLargeBatches processes a batch above MaxEvents in chunks of MaxEvents instead of
+	// rejecting it with 413 (for sensors whose batch size cannot be changed).
+	SplitLargeBatches bool
+	// ProcessTimeout > 0 bounds ProcessBatch (or ProcessRaw) per request: the sensor gets 503
+	// processing_timeout while processing continues in the background (see ProcessingAbandoned).
+	ProcessTimeout time.Duration
+	// Profile, if set, labels each stage for pprof.
+	Profile *profile.Labels
+	// Backfill, if set, enables ServeBackfill.
+	Backfill *Backfill
+	// Sync, if set, confirms that the events written so far were accepted by the output (or spooled
+	// to its outbox); batches sent with X-Loom-Durability: sync wait for it before the 204.
+	// Without it, ProcessBatch is taken to write synchronously.
+	Sync func() error
+
+	mu sync.RWMutex // guards the limit fields once the handler is serving (see UpdateLimits)
+}
+
+// UpdateLimits changes the body, batch and event size limits while serving (e.g. after config reload).
+func (h *Handler) UpdateLimits(maxBodyBytes int64, maxEvents int, maxEventBytes int64) {
+	h.mu.Lock()
+	h.MaxBodyBytes = maxBodyBytes
+	h.MaxEvents = maxEvents
+	h.MaxEventBytes = maxEventBytes
+	h.mu.Unlock()
+}
+
+// SetSplitLargeBatches changes SplitLargeBatches while serving.
+func (h *Handler) SetSplitLargeBatches(split bool) {
+	h.mu.Lock()
+	h.SplitLargeBatches = split
+	h.mu.Unlock()
+}
+
+// SetProcessTimeout changes ProcessTimeout while serving.
+func (h *Handler) SetProcessTimeout(d time.Duration) {
+	h.mu.Lock()
+	h.ProcessTimeout = d
+	h.mu.Unlock()
+}
+
+func (h *Handler) limits() (maxBodyBytes int64, maxEvents int, maxEventBytes int64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.MaxBodyBytes, h.MaxEvents, h.MaxEventBytes
+}
+
+func (h *Handler) splitLargeBatches() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.SplitLargeBatches
+}
+
+func (h *Handler) processTimeout() time.Duration {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.ProcessTimeout
 }
 
-// ServeHTTP implements http.Handler.
+// ServeHTTP implements http.Handler. The request passes the stages in order (see stages.go); the
+// first rejection is answered with an ErrorResponse, otherwise with 204.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_, _ = w.Write([]byte(`{"error":"method_not_allowed"}`))
-		return
+	h.serve(w, r, false)
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, backfill bool) {
+	if RequestID(r.Context()) == "" {
+		r = r.WithContext(WithRequestID(w, r))
 	}
-	if r.Header.Get("Content-Type") != "application/json" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnsupportedMediaType)
-		_, _ = w.Write([]byte(`{"error":"invalid_content_type"}`))
+	req := &request{r: r, w: w, log: h.Log.With().Str("request_id", RequestID(r.Context())).Logger(), backfill: backfill}
+	defer h.Profile.Clear()
+	if !h.Gate.enter() {
+		h.reject(req, &rejection{status: http.StatusServiceUnavailable, code: "draining", message: "server is draining; retry after Retry-After seconds",
+			reason: ReasonDraining, retryAfter: drainingRetryAfter, uncounted: true})
 		return
 	}
-
-	// Bearer token validation
-	authz := r.Header.Get("Authorization")
-	if authz == "" || !strings.HasPrefix(strings.ToLower(authz), "bearer ") {
-		if h.Metrics != nil {
-			h.Metrics.IncRequests("unknown", http.StatusUnauthorized)
+	defer h.Gate.leave()
+	for _, s := range stages {
+		h.Profile.Enter(s.name)
+		if rej := s.run(h, req); rej != nil {
+			h.reject(req, rej)
+			return
 		}
-		h.respondErr(w, http.StatusUnauthorized, "unauthorized")
-		return
 	}
-	token := strings.TrimSpace(strings.TrimPrefix(authz, "Bearer"))
-	token = strings.TrimPrefix(token, "bearer ")
-	sensorID := h.Validator.Validate(token)
+	w.Header().Set(DurabilityHeader, req.durability)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reject counts rej in the metrics and sends its error response.
+func (h *Handler) reject(req *request, rej *rejection) {
+	sensorID := req.sensorID
 	if sensorID == "" {
-		if h.Metrics != nil {
-			h.Metrics.IncRequests("unknown", http.StatusUnauthorized)
-		}
-		h.respondErr(w, http.StatusUnauthorized, "unauthorized")
-		return
+		sensorID = "unknown"
 	}
-
-	// X-Spip-ID must match the sensor for this token (one token per sensor)
-	headerSensorID := r.Header.Get("X-Spip-ID")
-	if headerSensorID != "" && headerSensorID != sensorID {
-		h.respondErr(w, http.StatusUnauthorized, "unauthorized")
-		return
+	if !rej.uncounted {
+		h.Metrics.IncRequests(sensorID, rej.status)
 	}
-	if headerSensorID == "" {
-		headerSensorID = sensorID
+	if rej.reason != "" {
+		h.Metrics.IncRejected(sensorID, rej.reason)
 	}
-
-	// Per-sensor rate limit
-	if !h.RateLimiter.Allow(headerSensorID) {
-		h.Log.Warn().Str("sensor_id", headerSensorID).Msg("rate limit exceeded (429)")
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusTooManyRequests)
-		}
-		w.Header().Set("Retry-After", "1")
-		h.respondErr(w, http.StatusTooManyRequests, "rate_limit_exceeded")
-		return
+	if rej.reason == ReasonRateLimit || rej.reason == ReasonTenantRateLimit {
+		h.Metrics.IncRateLimited(sensorID)
 	}
-
-	// Body size limit
-	r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodyBytes)
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		if strings.Contains(err.Error(), "request body too large") {
-			if h.Metrics != nil {
-				h.Metrics.IncRequests(headerSensorID, http.StatusRequestEntityTooLarge)
-			}
-			w.WriteHeader(http.StatusRequestEntityTooLarge)
-			_, _ = w.Write([]byte(`{"error":"payload_too_large"}`))
-			return
-		}
-		h.Log.Debug().Err(err).Msg("read body")
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusBadRequest)
-		}
-		h.respondErr(w, http.StatusBadRequest, "invalid_request")
-		return
+	if rej.retryAfter != "" {
+		req.w.Header().Set("Retry-After", rej.retryAfter)
 	}
+	h.respondErr(req.w, req.r, rej.status, rej.code, rej.message, rej.details...)
+}
 
-	// Request body must be a JSON array
-	bodyTrim := strings.TrimSpace(string(body))
-	if bodyTrim == "" || bodyTrim[0] != '[' {
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusBadRequest)
-		}
-		h.respondErr(w, http.StatusBadRequest, "invalid_request")
-		return
-	}
-	var events []map[string]interface{}
-	if err := json.Unmarshal(body, &events); err != nil {
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusBadRequest)
-		}
-		h.respondErr(w, http.StatusBadRequest, "invalid_request")
-		return
-	}
-	if events == nil {
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusBadRequest)
+// readBody reads the request body, decompressing it for the gzip and zstd encodings. The size limit
+// applies to the decompressed body too, so a small compressed body cannot expand without bound.
+func readBody(dst *bytes.Buffer, body io.Reader, encoding string, maxBytes int64) error {
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return err
 		}
-		h.respondErr(w, http.StatusBadRequest, "invalid_request")
-		return
-	}
-	if len(events) > h.MaxEvents {
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusRequestEntityTooLarge)
+		defer zr.Close()
+		r = zr
+	case "zstd":
+		// One goroutine and a window no larger than the limit: decoding memory is bounded by
+		// max_body_size_bytes whatever the frame header claims.
+		zr, err := zstd.NewReader(body,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(uint64(maxBytes)+zstd.MinWindowSize),
+			zstd.WithDecoderMaxMemory(uint64(maxBytes)+1))
+		if err != nil {
+			return err
 		}
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
-		_, _ = w.Write([]byte(`{"error":"batch_too_large"}`))
-		return
+		defer zr.Close()
+		r = zr
+	default:
+		_, err := dst.ReadFrom(body)
+		return err
 	}
-	for i := range events {
-		if events[i] == nil {
-			if h.Metrics != nil {
-				h.Metrics.IncRequests(headerSensorID, http.StatusBadRequest)
-			}
-			h.respondErr(w, http.StatusBadRequest, "invalid_request")
-			return
-		}
-		b, _ := json.Marshal(events[i])
-		if int64(len(b)) > h.MaxEventBytes {
-			if h.Metrics != nil {
-				h.Metrics.IncRequests(headerSensorID, http.StatusRequestEntityTooLarge)
-			}
-			w.WriteHeader(http.StatusRequestEntityTooLarge)
-			_, _ = w.Write([]byte(`{"error":"event_too_large"}`))
-			return
-		}
+	_, err := dst.ReadFrom(io.LimitReader(r, maxBytes+1))
+	if (err == nil && int64(dst.Len()) > maxBytes) || errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		err = &http.MaxBytesError{Limit: maxBytes}
 	}
+	return err
+}
 
-	if h.Metrics != nil {
-		h.Metrics.IncRequests(headerSensorID, http.StatusOK)
-		h.Metrics.AddEvents(headerSensorID, len(events))
-	}
+// bodyPool recycles request body buffers between requests; decoded events do not refer to them.
+var bodyPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
 
-	// Process (enrich + output)
-	if err := h.ProcessBatch(headerSensorID, events); err != nil {
-		h.Log.Error().Err(err).Str("sensor_id", headerSensorID).Msg("process batch")
-		if h.Metrics != nil {
-			h.Metrics.IncRequests(headerSensorID, http.StatusInternalServerError)
-		}
-		h.respondErr(w, http.StatusInternalServerError, "internal_error")
-		return
+func getBodyBuffer() *bytes.Buffer {
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBodyBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= 16<<20 { // let the odd huge body go rather than pin its memory
+		bodyPool.Put(buf)
 	}
+}
 
-	h.Log.Info().Str("sensor_id", headerSensorID).Int("events", len(events)).Msg("ingest batch ok")
-	w.WriteHeader(http.StatusNoContent)
+// DurabilityHeader lets a sensor choose per batch when it is answered: DurabilityQueued (the
+// default) once the events are handed to the output, which may buffer them until its next flush;
+// DurabilitySync once the output has accepted them or spooled them to its outbox. The response
+// repeats the level applied.
+const DurabilityHeader = "X-Loom-Durability"
+
+// Values of DurabilityHeader.
+const (
+	DurabilityQueued = "queued"
+	DurabilitySync   = "sync"
+)
+
+// ErrorResponse is the body of every error response. Code is a stable, machine-readable reason
+// (e.g. "batch_too_large"); Message is for humans and may change. Error repeats Code for clients
+// written against the original {"error":"..."} body.
+type ErrorResponse struct {
+	Error     string       `json:"error"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Details   []EventError `json:"details,omitempty"`
+}
+
+// EventError points at the event in the batch that caused the request to be rejected.
+type EventError struct {
+	Index   int    `json:"index"` // position in the batch, from 0
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (h *Handler) respondErr(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...EventError) {
+	WriteError(w, r, status, code, message, details...)
 }
 
-func (h *Handler) respondErr(w http.ResponseWriter, code int, errMsg string) {
+// WriteError writes an ErrorResponse with the request ID from r's context (see WithRequestID).
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string, details ...EventError) {
+	body, _ := json.Marshal(ErrorResponse{
+		Error:     code,
+		Code:      code,
+		Message:   message,
+		RequestID: RequestID(r.Context()),
+		Details:   details,
+	})
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_, _ = w.Write([]byte(`{"error":"` + errMsg + `"}`))
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
 }
@@ -2,13 +2,21 @@ package ingest
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/event"
 	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/StefanGrimminck/Loom/internal/tenant"
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog"
 )
 
@@ -104,9 +112,9 @@ func TestHandler_BadRequest_NotArray(t *testing.T) {
 }
 
 func TestHandler_Success_SpipStyleBatch(t *testing.T) {
-	var processed []map[string]interface{}
+	var processed []event.Event
 	h := makeTestHandler(t)
-	h.ProcessBatch = func(sensorID string, events []map[string]interface{}) error {
+	h.ProcessBatch = func(_ context.Context, sensorID string, events []event.Event) error {
 		processed = events
 		return nil
 	}
@@ -150,7 +158,7 @@ func makeTestHandler(t *testing.T) *Handler {
 		MaxBodyBytes:  1024 * 1024,
 		MaxEvents:     500,
 		MaxEventBytes: 128 * 1024,
-		ProcessBatch:  func(string, []map[string]interface{}) error { return nil },
+		ProcessBatch:  func(context.Context, string, []event.Event) error { return nil },
 		Log:           zerolog.Nop(),
 	}
 }
@@ -162,3 +170,454 @@ func mustJSON(v interface{}) []byte {
 	}
 	return b
 }
+
+func TestHandler_RecordsSensorInSlot(t *testing.T) {
+	h := makeTestHandler(t)
+	req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader([]byte("[]")))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	ctx, sensorID := WithSensorSlot(req.Context())
+	h.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	if sensorID() != "spip-001" {
+		t.Errorf("sensor slot = %q, want spip-001", sensorID())
+	}
+}
+
+func TestHandler_RecordsActivity(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Activity = NewSensorActivity()
+	body := mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001"), spipStyleEvent("1.2.3.5", "spip-001")})
+	req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	snap := h.Activity.Snapshot()
+	if len(snap) != 1 || snap[0].SensorID != "spip-001" || snap[0].Events != 2 || snap[0].Batches != 1 || snap[0].LastSeen.IsZero() {
+		t.Errorf("activity = %+v", snap)
+	}
+}
+
+func TestHandler_Gzip(t *testing.T) {
+	var processed []event.Event
+	h := makeTestHandler(t)
+	h.ProcessBatch = func(_ context.Context, _ string, events []event.Event) error {
+		processed = events
+		return nil
+	}
+	gz := func(b []byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(b)
+		_ = zw.Close()
+		return &buf
+	}
+	post := func(body *bytes.Buffer, encoding string) int {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(gz(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})), "gzip"); code != http.StatusNoContent || len(processed) != 1 {
+		t.Errorf("gzip: status = %d, processed = %d", code, len(processed))
+	}
+	// Compresses far below the limit but expands beyond it
+	big := append(append([]byte(`[{"pad":"`), bytes.Repeat([]byte("a"), 2*1024*1024)...), `"}]`...)
+	if code := post(gz(big), "gzip"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("gzip bomb: status = %d, want 413", code)
+	}
+	if code := post(bytes.NewBufferString("[]"), "br"); code != http.StatusUnsupportedMediaType {
+		t.Errorf("br: status = %d, want 415", code)
+	}
+}
+
+func TestHandler_TenantLimits(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Tenants = tenant.New(map[string]string{"spip-001": "group-a"}, map[string]tenant.Limits{"group-a": {EventsPerDay: 3, MaxEventsPerBatch: 2}})
+	post := func(n int) *httptest.ResponseRecorder {
+		events := make([]interface{}, n)
+		for i := range events {
+			events[i] = spipStyleEvent("1.2.3.4", "spip-001")
+		}
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON(events)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(3); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("batch above the tenant's max_events_per_batch: status = %d, want 413", rec.Code)
+	}
+	if rec := post(2); rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	rec := post(2)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("tenant_quota_exceeded")) {
+		t.Errorf("body = %s", rec.Body)
+	}
+}
+
+func TestHandler_Zstd(t *testing.T) {
+	var processed []event.Event
+	h := makeTestHandler(t)
+	h.ProcessBatch = func(_ context.Context, _ string, events []event.Event) error {
+		processed = events
+		return nil
+	}
+	enc, _ := zstd.NewWriter(nil)
+	post := func(body []byte) int {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(enc.EncodeAll(body, nil)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "zstd")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})); code != http.StatusNoContent || len(processed) != 1 {
+		t.Errorf("zstd: status = %d, processed = %d", code, len(processed))
+	}
+	big := append(append([]byte(`[{"pad":"`), bytes.Repeat([]byte("a"), 2*1024*1024)...), `"}]`...)
+	if code := post(big); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("zstd bomb: status = %d, want 413", code)
+	}
+}
+
+func TestHandler_Backpressure(t *testing.T) {
+	h := makeTestHandler(t)
+	var wait time.Duration
+	h.Backpressure = func() time.Duration { return wait }
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(); rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	wait = 2500 * time.Millisecond
+	rec := post()
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" {
+		t.Errorf("backed up: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("backpressure")) {
+		t.Errorf("body = %s", rec.Body)
+	}
+}
+
+func TestHandler_SensorControl(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Control = NewSensorControl()
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	h.Control.Pause("spip-001", "flooding", 90*time.Second)
+	rec := post()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "90" {
+		t.Errorf("paused: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("sensor_paused")) || !bytes.Contains(rec.Body.Bytes(), []byte("flooding")) {
+		t.Errorf("body = %s", rec.Body)
+	}
+	h.Control.Disable("spip-001", "", 0)
+	if rec := post(); rec.Code != http.StatusForbidden || !bytes.Contains(rec.Body.Bytes(), []byte("sensor_disabled")) {
+		t.Errorf("disabled: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	h.Control.Resume("spip-001")
+	if rec := post(); rec.Code != http.StatusNoContent {
+		t.Errorf("resumed: status = %d", rec.Code)
+	}
+
+	// An expired pause ends by itself.
+	now := time.Now()
+	h.Control.Pause("spip-001", "", time.Minute)
+	h.Control.nowFn = func() time.Time { return now.Add(2 * time.Minute) }
+	if rec := post(); rec.Code != http.StatusNoContent {
+		t.Errorf("expired pause: status = %d", rec.Code)
+	}
+}
+
+func TestHandler_Gate(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Gate = NewGate()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h.ProcessBatch = func(context.Context, string, []event.Event) error {
+		close(started)
+		<-release
+		return nil
+	}
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan int)
+	go func() { done <- post().Code }()
+	<-started
+	h.Gate.Close()
+	if rec := post(); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" || !bytes.Contains(rec.Body.Bytes(), []byte("draining")) {
+		t.Errorf("closed gate: status = %d, Retry-After = %q, body = %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := h.Gate.Wait(ctx); err == nil || h.Gate.InFlight() != 1 {
+		t.Errorf("Wait with a request in progress = %v, in flight %d", err, h.Gate.InFlight())
+	}
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("admitted request: status = %d", code)
+	}
+	if err := h.Gate.Wait(context.Background()); err != nil {
+		t.Errorf("Wait = %v", err)
+	}
+}
+
+func TestHandler_SplitLargeBatches(t *testing.T) {
+	h := makeTestHandler(t)
+	h.MaxEvents = 2
+	var chunks []int
+	h.ProcessBatch = func(_ context.Context, _ string, events []event.Event) error {
+		chunks = append(chunks, len(events))
+		return nil
+	}
+	post := func(n int) int {
+		events := make([]interface{}, n)
+		for i := range events {
+			events[i] = spipStyleEvent("1.2.3.4", "spip-001")
+		}
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON(events)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(5); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("without split: status = %d, want 413", code)
+	}
+	h.SetSplitLargeBatches(true)
+	if code := post(5); code != http.StatusNoContent {
+		t.Errorf("with split: status = %d, want 204", code)
+	}
+	if fmt.Sprint(chunks) != "[2 2 1]" {
+		t.Errorf("chunks = %v, want [2 2 1]", chunks)
+	}
+}
+
+func TestHandler_ErrorBodyAndRequestID(t *testing.T) {
+	h := makeTestHandler(t)
+	h.MaxEventBytes = 500
+	big := spipStyleEvent("1.2.3.4", "spip-001")
+	big["message"] = strings.Repeat("x", 500)
+	body := mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001"), big})
+	req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set(RequestIDHeader, "sensor-batch-42")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || rec.Header().Get(RequestIDHeader) != "sensor-batch-42" {
+		t.Fatalf("status = %d, X-Request-ID = %q", rec.Code, rec.Header().Get(RequestIDHeader))
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != "event_too_large" || resp.Code != "event_too_large" || resp.Message == "" || resp.RequestID != "sensor-batch-42" {
+		t.Errorf("body = %+v", resp)
+	}
+	if len(resp.Details) != 1 || resp.Details[0].Index != 1 || resp.Details[0].Code != "event_too_large" {
+		t.Errorf("details = %+v", resp.Details)
+	}
+
+	// An unusable client ID is replaced, successful responses carry one too, and the pipeline
+	// gets it in ProcessBatch's context.
+	var processedID string
+	h.ProcessBatch = func(ctx context.Context, _ string, _ []event.Event) error {
+		processedID = RequestID(ctx)
+		return nil
+	}
+	req = httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader([]byte("[]")))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set(RequestIDHeader, "has spaces\n")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); rec.Code != http.StatusNoContent || len(id) != 32 || processedID != id {
+		t.Errorf("status = %d, X-Request-ID = %q, ProcessBatch request ID = %q", rec.Code, id, processedID)
+	}
+}
+
+func BenchmarkHandler_ServeHTTP(b *testing.B) {
+	benchmarkHandler(b, false)
+}
+
+// BenchmarkHandler_ServeHTTPPassthrough is BenchmarkHandler_ServeHTTP in passthrough mode.
+func BenchmarkHandler_ServeHTTPPassthrough(b *testing.B) {
+	benchmarkHandler(b, true)
+}
+
+func benchmarkHandler(b *testing.B, passthrough bool) {
+	h := &Handler{
+		Validator:     auth.NewValidator(map[string]string{"test-token": "spip-001"}),
+		RateLimiter:   ratelimit.NewPerSensorLimiter(1e9),
+		MaxBodyBytes:  16 * 1024 * 1024,
+		MaxEvents:     1000,
+		MaxEventBytes: 128 * 1024,
+		ProcessBatch:  func(context.Context, string, []event.Event) error { return nil },
+		Log:           zerolog.Nop(),
+	}
+	if passthrough {
+		h.ProcessRaw = func(context.Context, string, []json.RawMessage) error { return nil }
+	}
+	batch := make([]interface{}, 500)
+	for i := range batch {
+		batch[i] = spipStyleEvent(fmt.Sprintf("10.0.%d.%d", i/256, i%256), "spip-001")
+	}
+	body := mustJSON(batch)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			b.Fatalf("status = %d", rec.Code)
+		}
+	}
+}
+
+func TestHandler_Durability(t *testing.T) {
+	h := makeTestHandler(t)
+	var syncs int
+	var syncErr error
+	h.Sync = func() error { syncs++; return syncErr }
+	post := func(durability string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		if durability != "" {
+			req.Header.Set(DurabilityHeader, durability)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for durability, want := range map[string]string{"": DurabilityQueued, "queued": DurabilityQueued, "Sync": DurabilitySync} {
+		syncs = 0
+		rec := post(durability)
+		if rec.Code != http.StatusNoContent || rec.Header().Get(DurabilityHeader) != want {
+			t.Errorf("%q: status = %d, %s = %q, want %q", durability, rec.Code, DurabilityHeader, rec.Header().Get(DurabilityHeader), want)
+		}
+		wantSyncs := 0
+		if want == DurabilitySync {
+			wantSyncs = 1
+		}
+		if syncs != wantSyncs {
+			t.Errorf("%q: Sync called %d times, want %d", durability, syncs, wantSyncs)
+		}
+	}
+	if rec := post("eventually"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_durability") {
+		t.Errorf("unknown level: status = %d, body %s", rec.Code, rec.Body)
+	}
+	syncErr = fmt.Errorf("clickhouse down")
+	rec := post("sync")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "not_durable") || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("failed sync: status = %d, Retry-After %q, body %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+}
+
+func TestHandler_ProcessTimeout(t *testing.T) {
+	h := makeTestHandler(t)
+	release := make(chan struct{})
+	abandoned := make(chan bool, 1)
+	h.ProcessBatch = func(ctx context.Context, _ string, _ []event.Event) error {
+		<-release
+		abandoned <- ProcessingAbandoned(ctx)
+		return nil
+	}
+	h.SetProcessTimeout(20 * time.Millisecond)
+	h.Gate = NewGate()
+	req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON([]interface{}{spipStyleEvent("1.2.3.4", "spip-001")})))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "processing_timeout") || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("status = %d, Retry-After %q, body %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+	// A drain waits for the processing left running after the 503
+	h.Gate.Close()
+	if n := h.Gate.InFlight(); n != 1 {
+		t.Errorf("in flight after the 503 = %d, want 1", n)
+	}
+	close(release)
+	if !<-abandoned {
+		t.Error("ProcessingAbandoned after the 503 = false")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Gate.Wait(ctx); err != nil {
+		t.Errorf("drain after the processing ended: %v", err)
+	}
+}
+
+func TestHandler_SlowBatchWithoutProcessTimeout(t *testing.T) {
+	// At the default (no processing timeout) a slow batch is answered once it is done, so the
+	// sensor does not send it again and nothing counts its events twice
+	h := makeTestHandler(t)
+	var calls, counted int
+	h.ProcessBatch = func(ctx context.Context, _ string, events []event.Event) error {
+		calls++
+		for range events {
+			if ProcessingAbandoned(ctx) {
+				return ctx.Err()
+			}
+			counted++
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	}
+	batch := []interface{}{spipStyleEvent("1.2.3.4", "spip-001"), spipStyleEvent("1.2.3.5", "spip-001")}
+	req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON(batch)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent && rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if calls != 1 || counted != 2 {
+		t.Errorf("processed %d times, %d events counted; want once, 2", calls, counted)
+	}
+}
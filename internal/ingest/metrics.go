@@ -1,13 +1,103 @@
 package ingest
 
 import (
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Metrics holds Prometheus metrics for the ingest API.
 type Metrics struct {
-	RequestsTotal *prometheus.CounterVec
-	EventsTotal   *prometheus.CounterVec
+	RequestsTotal    *prometheus.CounterVec
+	EventsTotal      *prometheus.CounterVec
+	RateLimitedTotal *prometheus.CounterVec
+	RejectionsTotal  *prometheus.CounterVec
+	// StrippedFieldsTotal counts fields removed by strict mode (see FieldAllowlist).
+	StrippedFieldsTotal *prometheus.CounterVec
+	// OversizedBodiesTotal counts bodies over max_body_size_bytes by Content-Encoding, so a
+	// decompression bomb (gzip, zstd) can be told from a sensor sending too much at once (identity).
+	OversizedBodiesTotal *prometheus.CounterVec
+	// Progress of backfills (see Backfill): events accepted, and the newest @timestamp of the last
+	// backfill batch, which shows how far a replay in time order has come.
+	BackfillEventsTotal    *prometheus.CounterVec
+	BackfillEventTimestamp *prometheus.GaugeVec
+	// Size distributions per sensor for tuning [limits]; batches rejected as too large are included.
+	BodyBytes   *prometheus.HistogramVec
+	BatchEvents *prometheus.HistogramVec
+	EventBytes  *prometheus.HistogramVec
+
+	mu         sync.Mutex
+	maxSensors int             // see LimitSensors
+	labelled   map[string]bool // sensors with their own sensor_id label value
+}
+
+// Reasons a request is rejected, the reason label of loom_ingest_rejections_total.
+const (
+	ReasonMethodNotAllowed = "method_not_allowed"
+	ReasonContentType      = "content_type"
+	ReasonContentEncoding  = "content_encoding"
+	ReasonMissingToken     = "missing_token"
+	ReasonBadToken         = "bad_token"
+	ReasonSensorMismatch   = "sensor_mismatch" // X-Spip-ID is not the token's sensor
+	ReasonSensorPaused     = "sensor_paused"   // paused by an administrator
+	ReasonSensorDisabled   = "sensor_disabled" // disabled by an administrator
+	ReasonRateLimit        = "rate_limit"
+	ReasonTenantRateLimit  = "tenant_rate_limit"
+	ReasonBackpressure     = "backpressure"
+	ReasonDraining         = "draining" // drain mode (see Gate)
+	ReasonPayloadTooLarge  = "payload_too_large"
+	ReasonInvalidRequest   = "invalid_request"
+	ReasonBatchTooLarge    = "batch_too_large"
+	ReasonEventTooLarge    = "event_too_large"
+	ReasonQuota            = "quota"
+	ReasonUnknownField     = "unknown_field"        // strict mode
+	ReasonBackfillDenied   = "backfill_not_allowed" // the sensor is not in backfill.sensors
+	ReasonTimeout          = "processing_timeout"   // see Handler.ProcessTimeout
+)
+
+// Label values used instead of sensor IDs when per-sensor labels are capped or disabled.
+const (
+	SensorLabelOther = "other"
+	SensorLabelAll   = "all"
+)
+
+// LimitSensors caps the distinct sensor_id label values, so thousands of short-lived sensors cannot
+// blow up the number of series: the first max sensors seen keep their ID, later ones are counted as
+// "other". max < 0 counts every sensor as "all"; 0 (the default) removes the cap.
+func (m *Metrics) LimitSensors(max int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.maxSensors = max
+	m.mu.Unlock()
+}
+
+// SensorLabel returns the sensor_id label value for sensorID under the LimitSensors cap.
+func (m *Metrics) SensorLabel(sensorID string) string {
+	if m == nil {
+		return sensorID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.maxSensors == 0 || sensorID == "unknown":
+		return sensorID
+	case m.maxSensors < 0:
+		return SensorLabelAll
+	case m.labelled[sensorID]:
+		return sensorID
+	case len(m.labelled) < m.maxSensors:
+		if m.labelled == nil {
+			m.labelled = make(map[string]bool)
+		}
+		m.labelled[sensorID] = true
+		return sensorID
+	default:
+		return SensorLabelOther
+	}
 }
 
 // NewMetrics creates and registers ingest metrics. Labels must not include tokens or IPs; sensor_id is allowed.
@@ -19,9 +109,40 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 		EventsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{Name: "loom_ingest_events_total", Help: "Total events received by sensor"},
 			[]string{"sensor_id"}),
+		RateLimitedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_ratelimit_rejections_total", Help: "Requests rejected by the per-sensor rate limit"},
+			[]string{"sensor_id"}),
+		RejectionsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_ingest_rejections_total", Help: "Ingest requests rejected, by sensor and reason"},
+			[]string{"sensor_id", "reason"}),
+		StrippedFieldsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_ingest_stripped_fields_total", Help: "Event fields removed by strict mode, by sensor"},
+			[]string{"sensor_id"}),
+		OversizedBodiesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_ingest_oversized_bodies_total", Help: "Ingest bodies over max_body_size_bytes (after decompression), by sensor and encoding"},
+			[]string{"sensor_id", "encoding"}),
+		BackfillEventsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "loom_ingest_backfill_events_total", Help: "Events accepted through the backfill endpoint, by sensor"},
+			[]string{"sensor_id"}),
+		BackfillEventTimestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "loom_ingest_backfill_event_timestamp_seconds", Help: "Newest @timestamp of the last backfill batch, by sensor"},
+			[]string{"sensor_id"}),
+		BodyBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "loom_ingest_body_bytes", Help: "Ingest request body size after decompression, by sensor",
+				Buckets: prometheus.ExponentialBuckets(256, 4, 9)}, // 256 B .. 16 MiB
+			[]string{"sensor_id"}),
+		BatchEvents: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "loom_ingest_batch_events", Help: "Events per ingest request, by sensor",
+				Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}},
+			[]string{"sensor_id"}),
+		EventBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "loom_ingest_event_bytes", Help: "Size of each received event as JSON, by sensor",
+				Buckets: prometheus.ExponentialBuckets(64, 4, 8)}, // 64 B .. 1 MiB
+			[]string{"sensor_id"}),
 	}
 	if reg != nil {
-		reg.MustRegister(m.RequestsTotal, m.EventsTotal)
+		reg.MustRegister(m.RequestsTotal, m.EventsTotal, m.RateLimitedTotal, m.RejectionsTotal, m.StrippedFieldsTotal, m.OversizedBodiesTotal,
+			m.BackfillEventsTotal, m.BackfillEventTimestamp, m.BodyBytes, m.BatchEvents, m.EventBytes)
 	}
 	return m
 }
@@ -30,14 +151,93 @@ func (m *Metrics) IncRequests(sensorID string, status int) {
 	if m == nil {
 		return
 	}
-	m.RequestsTotal.WithLabelValues(sensorID, statusToString(status)).Inc()
+	m.RequestsTotal.WithLabelValues(m.SensorLabel(sensorID), statusToString(status)).Inc()
 }
 
 func (m *Metrics) AddEvents(sensorID string, n int) {
 	if m == nil {
 		return
 	}
-	m.EventsTotal.WithLabelValues(sensorID).Add(float64(n))
+	m.EventsTotal.WithLabelValues(m.SensorLabel(sensorID)).Add(float64(n))
+}
+
+// ObserveBody records the size of a request body from sensorID.
+func (m *Metrics) ObserveBody(sensorID string, bytes int) {
+	if m == nil {
+		return
+	}
+	m.BodyBytes.WithLabelValues(m.SensorLabel(sensorID)).Observe(float64(bytes))
+}
+
+// ObserveBatch records the number of events in a request from sensorID.
+func (m *Metrics) ObserveBatch(sensorID string, events int) {
+	if m == nil {
+		return
+	}
+	m.BatchEvents.WithLabelValues(m.SensorLabel(sensorID)).Observe(float64(events))
+}
+
+// ObserveEvent records the size of one event from sensorID.
+func (m *Metrics) ObserveEvent(sensorID string, bytes int) {
+	if m == nil {
+		return
+	}
+	m.EventBytes.WithLabelValues(m.SensorLabel(sensorID)).Observe(float64(bytes))
+}
+
+// AddStrippedFields counts n fields removed from an event of sensorID by strict mode.
+func (m *Metrics) AddStrippedFields(sensorID string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.StrippedFieldsTotal.WithLabelValues(m.SensorLabel(sensorID)).Add(float64(n))
+}
+
+// IncOversizedBody counts a body from sensorID over the size limit; encoding is its Content-Encoding
+// ("" for none).
+func (m *Metrics) IncOversizedBody(sensorID, encoding string) {
+	if m == nil {
+		return
+	}
+	if encoding == "" {
+		encoding = "identity"
+	}
+	m.OversizedBodiesTotal.WithLabelValues(m.SensorLabel(sensorID), encoding).Inc()
+}
+
+// ObserveBackfill records a backfill batch of n events from sensorID; events are nil in passthrough
+// mode, which leaves the timestamp gauge alone.
+func (m *Metrics) ObserveBackfill(sensorID string, n int, events []event.Event) {
+	if m == nil {
+		return
+	}
+	label := m.SensorLabel(sensorID)
+	m.BackfillEventsTotal.WithLabelValues(label).Add(float64(n))
+	var newest time.Time
+	for _, ev := range events {
+		if ts, ok := ev.Timestamp(); ok && ts.After(newest) {
+			newest = ts
+		}
+	}
+	if !newest.IsZero() {
+		m.BackfillEventTimestamp.WithLabelValues(label).Set(float64(newest.UnixNano()) / 1e9)
+	}
+}
+
+// IncRejected counts a request from sensorID rejected for reason (one of the Reason constants).
+// Requests rejected before authentication are counted under "unknown".
+func (m *Metrics) IncRejected(sensorID, reason string) {
+	if m == nil {
+		return
+	}
+	m.RejectionsTotal.WithLabelValues(m.SensorLabel(sensorID), reason).Inc()
+}
+
+func (m *Metrics) IncRateLimited(sensorID string) {
+	if m == nil {
+		return
+	}
+	m.RateLimitedTotal.WithLabelValues(m.SensorLabel(sensorID)).Inc()
 }
 
 func statusToString(code int) string {
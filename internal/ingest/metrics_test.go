@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetrics_LimitSensors(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry())
+	m.LimitSensors(2)
+	for _, id := range []string{"s1", "s2", "s3", "s1", "s4"} {
+		m.AddEvents(id, 1)
+	}
+	m.IncRequests("unknown", 401)
+	if n := testutil.CollectAndCount(m.EventsTotal); n != 3 {
+		t.Errorf("events series = %d, want 3 (s1, s2, other)", n)
+	}
+	if v := testutil.ToFloat64(m.EventsTotal.WithLabelValues(SensorLabelOther)); v != 2 {
+		t.Errorf("other = %v, want 2", v)
+	}
+	if v := testutil.ToFloat64(m.RequestsTotal.WithLabelValues("unknown", "401")); v != 1 {
+		t.Errorf("unknown sensor should keep its label, got %v", v)
+	}
+
+	m = NewMetrics(nil)
+	m.LimitSensors(-1)
+	if m.SensorLabel("s1") != SensorLabelAll {
+		t.Error("max < 0 should label every sensor all")
+	}
+	m.LimitSensors(0)
+	if m.SensorLabel("s9") != "s9" {
+		t.Error("max 0 should not cap")
+	}
+}
+
+func TestHandler_SizeHistograms(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Metrics = NewMetrics(prometheus.NewRegistry())
+	h.MaxEvents = 1
+	events := []interface{}{spipStyleEvent("1.2.3.4", "spip-001"), spipStyleEvent("1.2.3.5", "spip-001")}
+	body := mustJSON(events)
+	req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+
+	// The rejected batch is still measured: that is what the limits need tuning for.
+	sum := func(hv *prometheus.HistogramVec) float64 {
+		var m dto.Metric
+		if err := hv.WithLabelValues("spip-001").(prometheus.Histogram).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHistogram().GetSampleSum()
+	}
+	if got := sum(h.Metrics.BodyBytes); got != float64(len(body)) {
+		t.Errorf("body bytes = %v, want %d", got, len(body))
+	}
+	if got := sum(h.Metrics.BatchEvents); got != 2 {
+		t.Errorf("batch events = %v, want 2", got)
+	}
+	if got := sum(h.Metrics.EventBytes); got != 0 {
+		t.Errorf("event bytes = %v, want 0: the batch was rejected before its events were read", got)
+	}
+
+	h.MaxEvents = 10
+	req = httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-token")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := sum(h.Metrics.EventBytes); got != float64(len(mustJSON(events[0]))+len(mustJSON(events[1]))) {
+		t.Errorf("event bytes = %v", got)
+	}
+}
+
+func TestHandler_RejectionReasons(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Metrics = NewMetrics(prometheus.NewRegistry())
+	h.MaxEvents = 1
+	post := func(token, sensorHeader string, n int) {
+		events := make([]interface{}, n)
+		for i := range events {
+			events[i] = spipStyleEvent("1.2.3.4", "spip-001")
+		}
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustJSON(events)))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if sensorHeader != "" {
+			req.Header.Set("X-Spip-ID", sensorHeader)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	post("", "", 1)
+	post("wrong", "", 1)
+	post("test-token", "spip-999", 1)
+	post("test-token", "", 2)
+	post("test-token", "", 1)
+
+	for _, tc := range []struct {
+		sensor, reason string
+	}{
+		{"unknown", ReasonMissingToken},
+		{"unknown", ReasonBadToken},
+		{"spip-001", ReasonSensorMismatch},
+		{"spip-001", ReasonBatchTooLarge},
+	} {
+		if v := testutil.ToFloat64(h.Metrics.RejectionsTotal.WithLabelValues(tc.sensor, tc.reason)); v != 1 {
+			t.Errorf("%s/%s = %v, want 1", tc.sensor, tc.reason, v)
+		}
+	}
+	if n := testutil.CollectAndCount(h.Metrics.RejectionsTotal); n != 4 {
+		t.Errorf("rejection series = %d, want 4 (the accepted request is not a rejection)", n)
+	}
+}
+
+func TestHandler_OversizedBody(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Metrics = NewMetrics(prometheus.NewRegistry())
+	h.MaxBodyBytes = 1024
+	big := append(append([]byte(`[{"pad":"`), bytes.Repeat([]byte("a"), 4096)...), `"}]`...)
+	var gz, zs, truncated bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(big)
+	_ = zw.Close()
+	enc, _ := zstd.NewWriter(&zs)
+	_, _ = enc.Write(big)
+	_ = enc.Close()
+	truncated.Write(gz.Bytes()[:gz.Len()/2])
+
+	for _, tc := range []struct {
+		encoding string
+		body     []byte
+		want     int
+		label    string
+	}{
+		{"", big, http.StatusRequestEntityTooLarge, "identity"},
+		{"gzip", gz.Bytes(), http.StatusRequestEntityTooLarge, "gzip"},
+		{"zstd", zs.Bytes(), http.StatusRequestEntityTooLarge, "zstd"},
+		{"gzip", truncated.Bytes(), http.StatusBadRequest, ""}, // a read error, not the limit
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", tc.encoding)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%q: status = %d, want %d", tc.encoding, rec.Code, tc.want)
+		}
+		if tc.label != "" {
+			if v := testutil.ToFloat64(h.Metrics.OversizedBodiesTotal.WithLabelValues("spip-001", tc.label)); v != 1 {
+				t.Errorf("%q: loom_ingest_oversized_bodies_total = %v, want 1", tc.encoding, v)
+			}
+		}
+	}
+	if n := testutil.CollectAndCount(h.Metrics.OversizedBodiesTotal); n != 3 {
+		t.Errorf("oversized body series = %d, want 3", n)
+	}
+}
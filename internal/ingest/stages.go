@@ -0,0 +1,491 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/rs/zerolog"
+)
+
+// request is the state of one ingest request as it passes the stages.
+type request struct {
+	r        *http.Request
+	w        http.ResponseWriter
+	log      zerolog.Logger
+	encoding string // Content-Encoding, lower case
+	backfill bool   // ServeBackfill: no request rate limits, relaxed size limits
+	// durability is the X-Loom-Durability the batch is answered with: DurabilityQueued or DurabilitySync
+	durability string
+
+	sensorID string // set by authenticate: X-Spip-ID, or the token's sensor
+
+	// Limits for this sensor, set by checkLimits
+	maxBodyBytes  int64
+	maxEvents     int
+	maxEventBytes int64
+
+	events []event.Event     // set by decode
+	raw    []json.RawMessage // set by decode instead of events in passthrough mode (ProcessRaw)
+	sizes  []int             // set by decode: each event's size in the body, in bytes
+}
+
+// count returns the number of events in the batch.
+func (req *request) count() int {
+	if req.raw != nil {
+		return len(req.raw)
+	}
+	return len(req.events)
+}
+
+// null reports whether event i of the batch is JSON null.
+func (req *request) null(i int) bool {
+	if req.raw != nil {
+		return req.raw[i] == nil
+	}
+	return req.events[i] == nil
+}
+
+// rejection ends a request with an error response (see respondErr).
+type rejection struct {
+	status     int
+	code       string // ErrorResponse.Code
+	message    string
+	reason     string // loom_ingest_rejections_total reason; "" is not counted there
+	retryAfter string // Retry-After header value, if any
+	details    []EventError
+	// uncounted rejections are left out of loom_ingest_requests_total: the checks before
+	// authentication count them as rejections only.
+	uncounted bool
+}
+
+// stage is one step of handling an ingest request. It may fill in req for later stages; a non-nil
+// rejection ends the request.
+type stage func(h *Handler, req *request) *rejection
+
+// stages handle an ingest request in order; when all pass, the batch has been processed.
+// Each is named by its pprof stage label (see Handler.Profile); ProcessBatch may label its own stages.
+var stages = []struct {
+	name string
+	run  stage
+}{
+	{"protocol", checkProtocol}, {"authenticate", authenticate}, {"limits", checkLimits},
+	{"decode", decode}, {"validate", validate}, {"quota", checkQuota}, {"process", process},
+}
+
+// checkProtocol checks the method, Content-Type, Content-Encoding and X-Loom-Durability.
+func checkProtocol(h *Handler, req *request) *rejection {
+	if req.r.Method != http.MethodPost {
+		return &rejection{status: http.StatusMethodNotAllowed, code: "method_not_allowed", message: "use POST",
+			reason: ReasonMethodNotAllowed, uncounted: true}
+	}
+	if req.r.Header.Get("Content-Type") != "application/json" {
+		return &rejection{status: http.StatusUnsupportedMediaType, code: "invalid_content_type", message: "Content-Type must be application/json",
+			reason: ReasonContentType, uncounted: true}
+	}
+	req.encoding = strings.ToLower(strings.TrimSpace(req.r.Header.Get("Content-Encoding")))
+	if req.encoding != "" && req.encoding != "identity" && req.encoding != "gzip" && req.encoding != "zstd" {
+		return &rejection{status: http.StatusUnsupportedMediaType, code: "unsupported_content_encoding", message: "Content-Encoding must be gzip, zstd or identity",
+			reason: ReasonContentEncoding, uncounted: true}
+	}
+	switch d := strings.ToLower(strings.TrimSpace(req.r.Header.Get(DurabilityHeader))); d {
+	case "", DurabilityQueued:
+		req.durability = DurabilityQueued
+	case DurabilitySync:
+		req.durability = DurabilitySync
+	default:
+		return &rejection{status: http.StatusBadRequest, code: "invalid_durability", message: DurabilityHeader + " must be sync or queued",
+			reason: ReasonInvalidRequest, uncounted: true}
+	}
+	return nil
+}
+
+// authenticate validates the bearer token and checks that X-Spip-ID, if sent, is the token's sensor
+// (one token per sensor).
+func authenticate(h *Handler, req *request) *rejection {
+	authz := req.r.Header.Get("Authorization")
+	if authz == "" || !strings.HasPrefix(strings.ToLower(authz), "bearer ") {
+		req.log.Debug().Str("remote_ip", req.r.RemoteAddr).Msg("missing bearer token (401)")
+		return &rejection{status: http.StatusUnauthorized, code: "unauthorized", message: "missing bearer token", reason: ReasonMissingToken}
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authz, "Bearer"))
+	token = strings.TrimPrefix(token, "bearer ")
+	tokenSensorID := h.Validator.Validate(token)
+	if tokenSensorID == "" {
+		req.log.Debug().Str("remote_ip", req.r.RemoteAddr).Msg("unknown token (401)")
+		return &rejection{status: http.StatusUnauthorized, code: "unauthorized", message: "unknown token", reason: ReasonBadToken}
+	}
+	req.sensorID = tokenSensorID
+	if id := req.r.Header.Get("X-Spip-ID"); id != "" && id != tokenSensorID {
+		req.log.Debug().Str("sensor_id", tokenSensorID).Str("x_spip_id", id).Msg("X-Spip-ID does not match token (401)")
+		return &rejection{status: http.StatusUnauthorized, code: "unauthorized", message: "X-Spip-ID does not match the token's sensor", reason: ReasonSensorMismatch}
+	}
+	recordSensor(req.r.Context(), req.sensorID)
+	return nil
+}
+
+// checkLimits applies administrator pauses, the sensor and tenant request rates (except to
+// backfills) and back-pressure, and looks up the size limits for the sensor.
+func checkLimits(h *Handler, req *request) *rejection {
+	if req.backfill && !h.Backfill.allows(req.sensorID) {
+		req.log.Debug().Str("sensor_id", req.sensorID).Msg("sensor may not backfill (403)")
+		return &rejection{status: http.StatusForbidden, code: "backfill_not_allowed", message: "sensor is not allowed to backfill",
+			reason: ReasonBackfillDenied}
+	}
+	if st, ok := h.Control.State(req.sensorID); ok {
+		if rej := controlRejection(st, time.Now()); rej != nil {
+			req.log.Debug().Str("sensor_id", req.sensorID).Str("state", st.State).Msgf("sensor %s (%d)", st.State, rej.status)
+			return rej
+		}
+	}
+	// A backfill sends as fast as the output takes it; back-pressure below still paces it
+	if !req.backfill && !h.RateLimiter.Allow(req.sensorID) {
+		req.log.Warn().Str("sensor_id", req.sensorID).Msg("rate limit exceeded (429)")
+		return &rejection{status: http.StatusTooManyRequests, code: "rate_limit_exceeded", message: "sensor request rate limit exceeded",
+			reason: ReasonRateLimit, retryAfter: "1"}
+	}
+	// Per-tenant rate limit, shared by the tenant's sensors
+	if !req.backfill && !h.Tenants.AllowRequest(req.sensorID) {
+		req.log.Warn().Str("sensor_id", req.sensorID).Str("tenant", h.Tenants.Tenant(req.sensorID)).Msg("tenant rate limit exceeded (429)")
+		return &rejection{status: http.StatusTooManyRequests, code: "tenant_rate_limit_exceeded", message: "tenant request rate limit exceeded",
+			reason: ReasonTenantRateLimit, retryAfter: "1"}
+	}
+	// Output backed up: have the sensor buffer instead of spooling here
+	if h.Backpressure != nil {
+		if d := h.Backpressure(); d > 0 {
+			req.log.Debug().Str("sensor_id", req.sensorID).Dur("retry_after", d).Msg("output backed up (503)")
+			return &rejection{status: http.StatusServiceUnavailable, code: "backpressure", message: "output backed up; retry after Retry-After seconds",
+				reason: ReasonBackpressure, retryAfter: strconv.Itoa(int((d + time.Second - 1) / time.Second))}
+		}
+	}
+	req.maxBodyBytes, req.maxEvents, req.maxEventBytes = h.limits()
+	req.maxEvents = h.Tenants.MaxEvents(req.sensorID, req.maxEvents)
+	if req.backfill {
+		if b := h.Backfill; b.MaxBodyBytes > 0 {
+			req.maxBodyBytes = b.MaxBodyBytes
+		}
+		if b := h.Backfill; b.MaxEvents > 0 {
+			req.maxEvents = b.MaxEvents
+		}
+	}
+	return nil
+}
+
+// controlRejection answers a request from a paused or disabled sensor.
+func controlRejection(st SensorState, now time.Time) *rejection {
+	message := "sensor " + st.State + " by an administrator"
+	if st.Reason != "" {
+		message += ": " + st.Reason
+	}
+	switch st.State {
+	case SensorPaused:
+		rej := &rejection{status: http.StatusTooManyRequests, code: "sensor_paused", message: message, reason: ReasonSensorPaused}
+		if st.Until != nil {
+			rej.retryAfter = strconv.Itoa(int((st.Until.Sub(now) + time.Second - 1) / time.Second))
+		}
+		return rej
+	case SensorDisabled:
+		return &rejection{status: http.StatusForbidden, code: "sensor_disabled", message: message, reason: ReasonSensorDisabled}
+	}
+	return nil
+}
+
+// decode reads the body within the size limit, decompressing it, and parses the JSON array of events.
+// The body is decoded in one pass that also notes each event's size, so validate need not encode
+// the events again to measure them. In passthrough mode the events are only checked and compacted.
+func decode(h *Handler, req *request) *rejection {
+	buf := getBodyBuffer()
+	defer putBodyBuffer(buf)
+	req.r.Body = http.MaxBytesReader(req.w, req.r.Body, req.maxBodyBytes)
+	if err := readBody(buf, req.r.Body, req.encoding, req.maxBodyBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.Metrics.IncOversizedBody(req.sensorID, req.encoding)
+			return &rejection{status: http.StatusRequestEntityTooLarge, code: "payload_too_large",
+				message: fmt.Sprintf("body exceeds %d bytes", req.maxBodyBytes), reason: ReasonPayloadTooLarge}
+		}
+		req.log.Debug().Err(err).Msg("read body")
+		return &rejection{status: http.StatusBadRequest, code: "invalid_request", message: "cannot read body", reason: ReasonInvalidRequest}
+	}
+	body := buf.Bytes()
+	h.Metrics.ObserveBody(req.sensorID, len(body))
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) == 0 || trimmed[0] != '[' {
+		return &rejection{status: http.StatusBadRequest, code: "invalid_request", message: "body must be a JSON array of events", reason: ReasonInvalidRequest}
+	}
+	var err error
+	if h.ProcessRaw != nil {
+		req.raw, req.sizes, err = decodeRaw(body)
+	} else {
+		req.events, req.sizes, err = decodeEvents(body)
+	}
+	if err != nil {
+		return &rejection{status: http.StatusBadRequest, code: "invalid_request", message: "body is not valid JSON: " + err.Error(), reason: ReasonInvalidRequest}
+	}
+	return nil
+}
+
+// decodeEvents parses body, a JSON array, into its events and their sizes (the bytes of each element,
+// without the separators around it). A null element decodes to a nil event.
+func decodeEvents(body []byte) ([]event.Event, []int, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if _, err := dec.Token(); err != nil { // [
+		return nil, nil, err
+	}
+	events := make([]event.Event, 0, 64)
+	sizes := make([]int, 0, 64)
+	for dec.More() {
+		start := dec.InputOffset()
+		var ev event.Event
+		if err := dec.Decode(&ev); err != nil {
+			return nil, nil, err
+		}
+		raw := bytes.TrimLeft(body[start:dec.InputOffset()], " \t\r\n,")
+		events = append(events, ev)
+		sizes = append(sizes, len(raw))
+	}
+	if _, err := dec.Token(); err != nil { // ]
+		return nil, nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, errors.New("invalid data after top-level value")
+	}
+	return events, sizes, nil
+}
+
+// decodeRaw splits body, a JSON array, into its events, compacted, and their sizes as decodeEvents
+// does, without decoding the events. The events share one new buffer, as body is reused after the
+// request. A null element is a nil event; any other element that is not an object is an error.
+func decodeRaw(body []byte) ([]json.RawMessage, []int, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if _, err := dec.Token(); err != nil { // [
+		return nil, nil, err
+	}
+	var compact bytes.Buffer
+	compact.Grow(len(body))
+	var ends []int // end of each event in compact, or -1 for null
+	sizes := make([]int, 0, 64)
+	for dec.More() {
+		start := dec.InputOffset()
+		var obj rawObject
+		if err := dec.Decode(&obj); err != nil {
+			return nil, nil, err
+		}
+		raw := bytes.TrimLeft(body[start:dec.InputOffset()], " \t\r\n,")
+		sizes = append(sizes, len(raw))
+		if obj.null {
+			ends = append(ends, -1)
+			continue
+		}
+		if err := json.Compact(&compact, raw); err != nil {
+			return nil, nil, err
+		}
+		ends = append(ends, compact.Len())
+	}
+	if _, err := dec.Token(); err != nil { // ]
+		return nil, nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, errors.New("invalid data after top-level value")
+	}
+	b := compact.Bytes()
+	events := make([]json.RawMessage, len(ends))
+	from := 0
+	for i, end := range ends {
+		if end >= 0 {
+			events[i] = json.RawMessage(b[from:end:end])
+			from = end
+		}
+	}
+	return events, sizes, nil
+}
+
+// rawObject checks an array element is an object (or null) as the decoder passes it, without
+// decoding it.
+type rawObject struct{ null bool }
+
+func (o *rawObject) UnmarshalJSON(b []byte) error {
+	switch {
+	case bytes.HasPrefix(b, []byte("{")):
+		return nil
+	case bytes.Equal(b, []byte("null")):
+		o.null = true
+		return nil
+	}
+	return errors.New("events must be JSON objects")
+}
+
+// validate checks the batch size (unless large batches are split) and each event: its size and, in
+// strict mode, its fields.
+func validate(h *Handler, req *request) *rejection {
+	n := req.count()
+	h.Metrics.ObserveBatch(req.sensorID, n)
+	if n > req.maxEvents && !h.splitLargeBatches() {
+		return &rejection{status: http.StatusRequestEntityTooLarge, code: "batch_too_large",
+			message: fmt.Sprintf("batch has %d events, at most %d allowed", n, req.maxEvents), reason: ReasonBatchTooLarge}
+	}
+	for i := 0; i < n; i++ {
+		if req.null(i) {
+			return &rejection{status: http.StatusBadRequest, code: "invalid_request", message: "events must be JSON objects", reason: ReasonInvalidRequest,
+				details: []EventError{{Index: i, Code: "invalid_event", Message: "event is null"}}}
+		}
+		size := req.sizes[i]
+		h.Metrics.ObserveEvent(req.sensorID, size)
+		if int64(size) > req.maxEventBytes {
+			return &rejection{status: http.StatusRequestEntityTooLarge, code: "event_too_large",
+				message: fmt.Sprintf("events may be at most %d bytes", req.maxEventBytes), reason: ReasonEventTooLarge,
+				details: []EventError{{Index: i, Code: "event_too_large", Message: fmt.Sprintf("event is %d bytes", size)}}}
+		}
+		if h.Fields != nil && req.raw == nil {
+			unknown, stripped := h.Fields.Check(req.events[i])
+			if unknown != "" {
+				return &rejection{status: http.StatusBadRequest, code: "unknown_field", message: "events may only have the allowed top-level fields",
+					reason: ReasonUnknownField, details: []EventError{{Index: i, Code: "unknown_field", Message: fmt.Sprintf("field %q is not allowed", unknown)}}}
+			}
+			h.Metrics.AddStrippedFields(req.sensorID, stripped)
+		}
+	}
+	return nil
+}
+
+// checkQuota counts the batch against the tenant's daily event quota.
+func checkQuota(h *Handler, req *request) *rejection {
+	if !h.Tenants.AllowEvents(req.sensorID, req.count()) {
+		req.log.Warn().Str("sensor_id", req.sensorID).Str("tenant", h.Tenants.Tenant(req.sensorID)).Msg("tenant quota exceeded (429)")
+		return &rejection{status: http.StatusTooManyRequests, code: "tenant_quota_exceeded", message: "tenant daily event quota exceeded",
+			reason: ReasonQuota, retryAfter: strconv.Itoa(int(h.Tenants.QuotaResetIn().Seconds()) + 1)}
+	}
+	return nil
+}
+
+// process hands the events to ProcessBatch (enrich + output), or ProcessRaw in passthrough mode, in
+// chunks of maxEvents when a large batch is split, and with X-Loom-Durability: sync waits for Sync;
+// all within ProcessTimeout.
+func process(h *Handler, req *request) *rejection {
+	n := req.count()
+	h.Metrics.IncRequests(req.sensorID, http.StatusOK)
+	h.Metrics.AddEvents(req.sensorID, n)
+
+	ctx := withTransport(req.r.Context(), req.r)
+	if req.backfill {
+		ctx = withBackfill(ctx)
+	}
+	var err error
+	if timeout := h.processTimeout(); timeout > 0 {
+		err = processWithin(ctx, h, req, timeout)
+		if errors.Is(err, errProcessTimeout) {
+			req.log.Warn().Str("sensor_id", req.sensorID).Int("events", n).Dur("timeout", timeout).Msg("processing timed out (503)")
+			return &rejection{status: http.StatusServiceUnavailable, code: "processing_timeout",
+				message: fmt.Sprintf("processing the batch took longer than %s; send it again after Retry-After seconds", timeout),
+				reason:  ReasonTimeout, retryAfter: strconv.Itoa(int((timeout + time.Second - 1) / time.Second))}
+		}
+	} else {
+		err = processAll(ctx, h, req)
+	}
+	if errors.Is(err, errNotDurable) {
+		return &rejection{status: http.StatusServiceUnavailable, code: "not_durable",
+			message: "events were processed but not confirmed written by the output; send the batch again", retryAfter: "1"}
+	}
+	if err != nil {
+		return &rejection{status: http.StatusInternalServerError, code: "internal_error", message: "processing failed"}
+	}
+	if req.backfill {
+		h.Metrics.ObserveBackfill(req.sensorID, n, req.events)
+		req.log.Info().Str("sensor_id", req.sensorID).Int("events", n).Msg("backfill batch ok")
+		return nil
+	}
+	h.Activity.Record(req.sensorID, n)
+	req.log.Info().Str("sensor_id", req.sensorID).Int("events", n).Msg("ingest batch ok")
+	return nil
+}
+
+// errNotDurable is returned by processAll when Sync failed.
+var errNotDurable = errors.New("events not confirmed written")
+
+// processAll runs the batch through ProcessBatch or ProcessRaw, in chunks when it is split, then
+// waits for Sync if the sensor asked for it. Errors are logged here.
+func processAll(ctx context.Context, h *Handler, req *request) error {
+	n := req.count()
+	chunk := n
+	if n > req.maxEvents && req.maxEvents > 0 {
+		chunk = req.maxEvents
+		req.log.Debug().Str("sensor_id", req.sensorID).Int("events", n).Int("max_events", req.maxEvents).Msg("splitting large batch")
+	}
+	for start := 0; ; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+		var err error
+		if h.ProcessRaw != nil {
+			err = h.ProcessRaw(ctx, req.sensorID, req.raw[start:end])
+		} else {
+			err = h.ProcessBatch(ctx, req.sensorID, req.events[start:end])
+		}
+		if err != nil {
+			req.log.Error().Err(err).Str("sensor_id", req.sensorID).Int("processed_events", start).Msg("process batch")
+			return err
+		}
+		if end == n {
+			break
+		}
+	}
+	if req.durability == DurabilitySync && h.Sync != nil {
+		h.Profile.Enter("sync")
+		if err := h.Sync(); err != nil {
+			req.log.Error().Err(err).Str("sensor_id", req.sensorID).Int("events", n).Msg("sync batch")
+			return fmt.Errorf("%w: %v", errNotDurable, err)
+		}
+	}
+	return nil
+}
+
+// errProcessTimeout is returned by processWithin when processing takes longer than its timeout.
+var errProcessTimeout = errors.New("processing timed out")
+
+type abandonedKey struct{}
+
+// processWithin runs processAll with a ctx that expires after timeout, and gives up waiting for
+// it then. Not every stage watches ctx (an output call may hang); the processing goes on in the
+// background, where ProcessingAbandoned tells ProcessBatch that the request was answered. It
+// holds the gate until it ends, so a drain waits for it.
+func processWithin(ctx context.Context, h *Handler, req *request, timeout time.Duration) error {
+	abandoned := new(atomic.Bool)
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, abandonedKey{}, abandoned), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	h.Gate.hold()
+	go func() {
+		defer h.Gate.leave()
+		done <- processAll(ctx, h, req)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			abandoned.Store(true)
+			return errProcessTimeout
+		}
+		// The client went away; finish as without a timeout
+		return <-done
+	}
+}
+
+// ProcessingAbandoned reports whether the ingest request of ctx was already answered 503 because
+// processing took longer than Handler.ProcessTimeout. ProcessBatch should check it before each
+// event and stop there, before anything counts or writes the event: the sensor sends the batch
+// again, and the events left arrive with it.
+func ProcessingAbandoned(ctx context.Context) bool {
+	a, _ := ctx.Value(abandonedKey{}).(*atomic.Bool)
+	return a != nil && a.Load()
+}
@@ -0,0 +1,265 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/tenant"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+)
+
+// newStageRequest returns the request state the stages see for an ingest POST of body.
+func newStageRequest(body string, header map[string]string) *request {
+	r := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader([]byte(body)))
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	return &request{r: r, w: httptest.NewRecorder(), log: zerolog.Nop()}
+}
+
+func TestStage_CheckProtocol(t *testing.T) {
+	h := makeTestHandler(t)
+	for _, tc := range []struct {
+		header map[string]string
+		code   string
+	}{
+		{map[string]string{"Content-Encoding": "GZIP"}, ""},
+		{map[string]string{"Content-Type": "text/plain"}, "invalid_content_type"},
+		{map[string]string{"Content-Encoding": "br"}, "unsupported_content_encoding"},
+	} {
+		req := newStageRequest("[]", tc.header)
+		rej := checkProtocol(h, req)
+		if (rej == nil) != (tc.code == "") || rej != nil && (rej.code != tc.code || !rej.uncounted) {
+			t.Errorf("%v: rejection = %+v, want %q", tc.header, rej, tc.code)
+		}
+	}
+	req := newStageRequest("[]", map[string]string{"Content-Encoding": "GZIP"})
+	_ = checkProtocol(h, req)
+	if req.encoding != "gzip" {
+		t.Errorf("encoding = %q", req.encoding)
+	}
+}
+
+func TestStage_Authenticate(t *testing.T) {
+	h := makeTestHandler(t)
+	req := newStageRequest("[]", map[string]string{"Authorization": "bearer test-token"})
+	if rej := authenticate(h, req); rej != nil || req.sensorID != "spip-001" {
+		t.Errorf("rejection = %+v, sensor = %q", rej, req.sensorID)
+	}
+	// A mismatch is counted against the token's sensor, not the claimed one.
+	req = newStageRequest("[]", map[string]string{"Authorization": "Bearer test-token", "X-Spip-ID": "spip-002"})
+	if rej := authenticate(h, req); rej == nil || rej.reason != ReasonSensorMismatch || req.sensorID != "spip-001" {
+		t.Errorf("rejection = %+v, sensor = %q", rej, req.sensorID)
+	}
+	req = newStageRequest("[]", map[string]string{"Authorization": "Bearer wrong"})
+	if rej := authenticate(h, req); rej == nil || rej.reason != ReasonBadToken || req.sensorID != "" {
+		t.Errorf("rejection = %+v, sensor = %q", rej, req.sensorID)
+	}
+}
+
+func TestStage_CheckLimits(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Tenants = tenant.New(map[string]string{"spip-001": "a"}, map[string]tenant.Limits{"a": {MaxEventsPerBatch: 7}})
+	req := newStageRequest("[]", nil)
+	req.sensorID = "spip-001"
+	if rej := checkLimits(h, req); rej != nil || req.maxEvents != 7 || req.maxBodyBytes != h.MaxBodyBytes {
+		t.Errorf("rejection = %+v, limits = %d/%d", rej, req.maxBodyBytes, req.maxEvents)
+	}
+}
+
+func TestStage_DecodeAndValidate(t *testing.T) {
+	h := makeTestHandler(t)
+	for body, code := range map[string]string{
+		`{"a":1}`:   "invalid_request",
+		`null`:      "invalid_request",
+		`[{"a":1}`:  "invalid_request",
+		`[{"a":1}]`: "",
+	} {
+		req := newStageRequest(body, nil)
+		req.maxBodyBytes = 1024
+		rej := decode(h, req)
+		if (rej == nil) != (code == "") || rej != nil && rej.code != code {
+			t.Errorf("%s: rejection = %+v", body, rej)
+		}
+	}
+
+	req := newStageRequest(`[{"a":1},null]`, nil)
+	req.maxBodyBytes, req.maxEvents, req.maxEventBytes = 1024, 10, 100
+	if rej := decode(h, req); rej != nil || len(req.events) != 2 {
+		t.Fatalf("decode: %+v, %d events", rej, len(req.events))
+	}
+	if rej := validate(h, req); rej == nil || len(rej.details) != 1 || rej.details[0].Index != 1 {
+		t.Errorf("validate = %+v, want the null event at index 1", rej)
+	}
+	req.events, req.maxEvents = req.events[:1], 0
+	if rej := validate(h, req); rej == nil || rej.code != "batch_too_large" {
+		t.Errorf("validate = %+v, want batch_too_large", rej)
+	}
+}
+
+func TestStage_Process(t *testing.T) {
+	h := makeTestHandler(t)
+	var chunks []int
+	h.ProcessBatch = func(_ context.Context, _ string, events []event.Event) error {
+		chunks = append(chunks, len(events))
+		return nil
+	}
+	req := newStageRequest("", nil)
+	req.sensorID, req.maxEvents = "spip-001", 2
+	req.events = make([]event.Event, 5)
+	if rej := process(h, req); rej != nil || len(chunks) != 3 || chunks[2] != 1 {
+		t.Errorf("rejection = %+v, chunks = %v", rej, chunks)
+	}
+
+	h.ProcessBatch = func(context.Context, string, []event.Event) error { return context.Canceled }
+	if rej := process(h, req); rej == nil || rej.status != http.StatusInternalServerError || rej.reason != "" {
+		t.Errorf("rejection = %+v, want internal_error without a rejection reason", rej)
+	}
+}
+
+func TestDecodeEvents_Sizes(t *testing.T) {
+	events, sizes, err := decodeEvents([]byte(" [ {\"a\":1} ,\n\t{\"b\": \"xy\"},null ] "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2] != nil || events[1]["b"] != "xy" {
+		t.Errorf("events = %v", events)
+	}
+	if len(sizes) != 3 || sizes[0] != 7 || sizes[1] != 11 || sizes[2] != 4 {
+		t.Errorf("sizes = %v, want [7 11 4]", sizes)
+	}
+	for _, body := range []string{`[{"a":1}] x`, `[{"a":1},]`, `[1]`, `[{"a":1}`} {
+		if _, _, err := decodeEvents([]byte(body)); err == nil {
+			t.Errorf("%s: expected error", body)
+		}
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	events, sizes, err := decodeRaw([]byte("[ {\"a\": 1,\n \"b\": [1, 2]} ,null,{}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || string(events[0]) != `{"a":1,"b":[1,2]}` || events[1] != nil || string(events[2]) != `{}` {
+		t.Errorf("events = %q", events)
+	}
+	if len(sizes) != 3 || sizes[0] != 22 || sizes[1] != 4 || sizes[2] != 2 {
+		t.Errorf("sizes = %v, want [22 4 2]", sizes)
+	}
+	for _, body := range []string{`[{"a":1}] x`, `[{"a":}]`, `[1]`, `["x"]`, `[{"a":1}`} {
+		if _, _, err := decodeRaw([]byte(body)); err == nil {
+			t.Errorf("%s: expected error", body)
+		}
+	}
+}
+
+func TestStage_ProcessRaw(t *testing.T) {
+	h := makeTestHandler(t)
+	var got []json.RawMessage
+	h.ProcessRaw = func(_ context.Context, _ string, events []json.RawMessage) error {
+		got = append(got, events...)
+		return nil
+	}
+	req := newStageRequest(`[{"a":1},{"b":2}]`, nil)
+	req.maxBodyBytes, req.maxEvents, req.maxEventBytes = 1024, 10, 100
+	for _, st := range []stage{decode, validate, process} {
+		if rej := st(h, req); rej != nil {
+			t.Fatalf("rejection = %+v", rej)
+		}
+	}
+	if len(got) != 2 || string(got[1]) != `{"b":2}` || req.events != nil {
+		t.Errorf("ProcessRaw got %q, events = %v", got, req.events)
+	}
+}
+
+func TestStage_ValidateStrict(t *testing.T) {
+	h := makeTestHandler(t)
+	h.Fields = NewFieldAllowlist([]string{"@timestamp", "source"}, false)
+	req := newStageRequest(`[{"source":{"ip":"10.0.0.1"}},{"source":{},"zz":1,"payload":"x"}]`, nil)
+	req.maxBodyBytes, req.maxEvents, req.maxEventBytes = 1024, 10, 100
+	if rej := decode(h, req); rej != nil {
+		t.Fatal(rej)
+	}
+	rej := validate(h, req)
+	if rej == nil || rej.code != "unknown_field" || len(rej.details) != 1 || rej.details[0].Index != 1 ||
+		rej.details[0].Message != `field "payload" is not allowed` {
+		t.Fatalf("validate = %+v", rej)
+	}
+
+	h.Fields = NewFieldAllowlist([]string{"@timestamp", "source"}, true)
+	if rej := validate(h, req); rej != nil {
+		t.Fatalf("strip: validate = %+v", rej)
+	}
+	if len(req.events[1]) != 1 || req.events[1]["source"] == nil {
+		t.Errorf("strip left %v", req.events[1])
+	}
+}
+
+func TestFieldAllowlist_DefaultsToECS(t *testing.T) {
+	a := NewFieldAllowlist(nil, false)
+	if unknown, _ := a.Check(event.Event{"@timestamp": "x", "source": nil, "user_agent": nil}); unknown != "" {
+		t.Errorf("ECS field rejected: %q", unknown)
+	}
+	if unknown, _ := a.Check(event.Event{"loom": map[string]interface{}{"first_seen": true}}); unknown != "loom" {
+		t.Errorf("loom.* from a sensor allowed: %q", unknown)
+	}
+}
+
+func TestStage_ProcessCarriesTransport(t *testing.T) {
+	h := makeTestHandler(t)
+	var got *TransportInfo
+	h.ProcessBatch = func(ctx context.Context, _ string, _ []event.Event) error {
+		got = Transport(ctx)
+		return nil
+	}
+	req := newStageRequest("", nil)
+	req.r.RemoteAddr = "192.0.2.7:51234"
+	req.r.Proto = "HTTP/2.0"
+	req.r.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{{Raw: []byte("cert")}}}
+	req.sensorID, req.events = "spip-001", make([]event.Event, 1)
+	if rej := process(h, req); rej != nil {
+		t.Fatal(rej)
+	}
+	if got == nil {
+		t.Fatal("no transport in ctx")
+	}
+	f := got.Fields()
+	tlsInfo, _ := f["tls"].(map[string]interface{})
+	if f["remote_ip"] != "192.0.2.7" || f["http_version"] != "2.0" || tlsInfo["version"] != "1.3" ||
+		tlsInfo["cipher"] != "TLS_AES_128_GCM_SHA256" || len(tlsInfo["client_cert_sha256"].(string)) != 64 {
+		t.Errorf("transport = %v", f)
+	}
+	if Transport(context.Background()) != nil {
+		t.Error("transport outside a request")
+	}
+}
+
+func TestRecordPeer(t *testing.T) {
+	var got *TransportInfo
+	h := RecordPeer(middleware.RealIP(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = Transport(withTransport(r.Context(), r))
+	})))
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "192.0.2.7:51234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got.RemoteIP != "192.0.2.7" || got.ForwardedIP != "198.51.100.1" {
+		t.Errorf("remote %q, forwarded %q; want the peer and the header's address", got.RemoteIP, got.ForwardedIP)
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got.RemoteIP != "192.0.2.7" || got.ForwardedIP != "" {
+		t.Errorf("remote %q, forwarded %q without headers", got.RemoteIP, got.ForwardedIP)
+	}
+}
@@ -0,0 +1,225 @@
+// Package intel derives threat-intel indicators from the event stream: source IPs and file hashes
+// seen by at least a minimum number of sensors. They are published as STIX 2.1 indicators through a
+// read-only TAXII 2.1 collection and a bundle download (see Handler).
+package intel
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Indicator types.
+const (
+	TypeIPv4   = "ipv4-addr"
+	TypeIPv6   = "ipv6-addr"
+	TypeMD5    = "md5"
+	TypeSHA1   = "sha1"
+	TypeSHA256 = "sha256"
+)
+
+// DefaultFields are the event fields indicators are taken from when none are configured.
+var DefaultFields = []string{"source.ip", "file.hash.sha256", "file.hash.sha1", "file.hash.md5"}
+
+var hexDigits = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// Indicator is a value seen by MinSensors or more sensors within the window.
+type Indicator struct {
+	Type      string
+	Value     string
+	Sensors   []string // sorted
+	Events    int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Added     time.Time // when the value first qualified; stable while it keeps qualifying
+}
+
+// Config tunes a Tracker.
+type Config struct {
+	Fields     []string      // default DefaultFields; the SHA-256 of linked artifacts is always used
+	MinSensors int           // default 2
+	Window     time.Duration // values not seen for this long are dropped; default 24h
+	MaxKeys    int           // values tracked at once; default 100000
+}
+
+// Tracker counts, per candidate value, the sensors and events it was seen in, and keeps a snapshot
+// of the values that qualify as indicators, recomputed by Refresh.
+type Tracker struct {
+	cfg   Config
+	nowFn func() time.Time
+
+	mu       sync.Mutex
+	seen     map[key]*sighting
+	added    map[key]time.Time
+	snapshot []Indicator
+	updated  time.Time
+}
+
+type key struct{ typ, value string }
+
+type sighting struct {
+	sensors     map[string]struct{}
+	events      int
+	first, last time.Time
+}
+
+// NewTracker returns a Tracker for cfg.
+func NewTracker(cfg Config) *Tracker {
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = DefaultFields
+	}
+	if cfg.MinSensors <= 0 {
+		cfg.MinSensors = 2
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 24 * time.Hour
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 100000
+	}
+	return &Tracker{cfg: cfg, nowFn: time.Now, seen: make(map[key]*sighting), added: make(map[key]time.Time)}
+}
+
+// Observe records the indicator values in ev as seen by sensorID. Nil-safe. When MaxKeys values
+// are tracked, new ones are ignored until Refresh expires old ones.
+func (t *Tracker) Observe(sensorID string, ev event.Event) {
+	if t == nil || ev == nil {
+		return
+	}
+	var keys []key
+	for _, f := range t.cfg.Fields {
+		keys = appendKeys(keys, ev.Get(f))
+	}
+	if list, ok := ev.Get("loom.artifacts").([]interface{}); ok {
+		for _, a := range list {
+			if m, ok := a.(map[string]interface{}); ok {
+				keys = appendKeys(keys, m["sha256"])
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	now := t.nowFn()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		s := t.seen[k]
+		if s == nil {
+			if len(t.seen) >= t.cfg.MaxKeys {
+				continue
+			}
+			s = &sighting{sensors: make(map[string]struct{}), first: now}
+			t.seen[k] = s
+		}
+		s.sensors[sensorID] = struct{}{}
+		s.events++
+		s.last = now
+	}
+}
+
+// appendKeys adds the indicator in v (a string, or a list of strings) to keys.
+func appendKeys(keys []key, v interface{}) []key {
+	switch v := v.(type) {
+	case string:
+		if k, ok := classify(v); ok {
+			keys = append(keys, k)
+		}
+	case []interface{}:
+		for _, e := range v {
+			keys = appendKeys(keys, e)
+		}
+	}
+	return keys
+}
+
+// classify returns the indicator for s: an IP address or a hex MD5, SHA-1 or SHA-256 hash.
+func classify(s string) (key, bool) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return key{TypeIPv4, ip4.String()}, true
+		}
+		return key{TypeIPv6, ip.String()}, true
+	}
+	s = strings.ToLower(s)
+	if !hexDigits.MatchString(s) {
+		return key{}, false
+	}
+	switch len(s) {
+	case 32:
+		return key{TypeMD5, s}, true
+	case 40:
+		return key{TypeSHA1, s}, true
+	case 64:
+		return key{TypeSHA256, s}, true
+	}
+	return key{}, false
+}
+
+// Refresh drops values not seen within the window and recomputes the indicator snapshot.
+func (t *Tracker) Refresh() {
+	now := t.nowFn()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Indicator
+	for k, s := range t.seen {
+		if now.Sub(s.last) > t.cfg.Window {
+			delete(t.seen, k)
+			delete(t.added, k)
+			continue
+		}
+		if len(s.sensors) < t.cfg.MinSensors {
+			continue
+		}
+		added, ok := t.added[k]
+		if !ok {
+			added = now
+			t.added[k] = now
+		}
+		sensors := make([]string, 0, len(s.sensors))
+		for id := range s.sensors {
+			sensors = append(sensors, id)
+		}
+		sort.Strings(sensors)
+		out = append(out, Indicator{Type: k.typ, Value: k.value, Sensors: sensors, Events: s.events,
+			FirstSeen: s.first, LastSeen: s.last, Added: added})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Added.Equal(out[j].Added) {
+			return out[i].Added.Before(out[j].Added)
+		}
+		return out[i].Type+out[i].Value < out[j].Type+out[j].Value
+	})
+	t.snapshot, t.updated = out, now
+}
+
+// Indicators returns the snapshot of the last Refresh, oldest Added first, and when it was taken.
+// The slice must not be modified.
+func (t *Tracker) Indicators() ([]Indicator, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot, t.updated
+}
+
+// Window returns how long an indicator stays valid after it was last seen.
+func (t *Tracker) Window() time.Duration { return t.cfg.Window }
+
+// Run calls Refresh every interval until ctx is done.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Refresh()
+		}
+	}
+}
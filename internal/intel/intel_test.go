@@ -0,0 +1,139 @@
+package intel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func newEvent(ip string, extra map[string]interface{}) event.Event {
+	ev := event.Event{"source": map[string]interface{}{"ip": ip}}
+	for k, v := range extra {
+		ev.Set(k, v)
+	}
+	return ev
+}
+
+func TestTracker_MinSensorsAndWindow(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr := NewTracker(Config{MinSensors: 2, Window: time.Hour})
+	tr.nowFn = func() time.Time { return now }
+
+	sha := strings.Repeat("ab", 32)
+	tr.Observe("s1", newEvent("203.0.113.9", map[string]interface{}{"file.hash.sha256": strings.ToUpper(sha)}))
+	tr.Observe("s1", newEvent("203.0.113.9", nil))
+	tr.Observe("s2", newEvent("203.0.113.9", map[string]interface{}{
+		"loom.artifacts": []interface{}{map[string]interface{}{"sha256": sha}},
+	}))
+	tr.Observe("s2", newEvent("::ffff:198.51.100.1", nil))
+	tr.Observe("s1", newEvent("not-an-ip", nil))
+	tr.Refresh()
+
+	got, _ := tr.Indicators()
+	if len(got) != 2 {
+		t.Fatalf("indicators = %+v", got)
+	}
+	if got[0].Type != TypeIPv4 || got[0].Value != "203.0.113.9" || got[0].Events != 3 || len(got[0].Sensors) != 2 {
+		t.Errorf("ip indicator = %+v", got[0])
+	}
+	if got[1].Type != TypeSHA256 || got[1].Value != sha {
+		t.Errorf("hash indicator = %+v", got[1])
+	}
+	added := got[0].Added
+
+	// Still qualifying: Added is kept. Past the window: dropped.
+	now = now.Add(30 * time.Minute)
+	tr.Observe("s3", newEvent("203.0.113.9", nil))
+	tr.Refresh()
+	if got, _ := tr.Indicators(); len(got) != 2 || !got[0].Added.Equal(added) {
+		t.Errorf("after refresh: %+v", got)
+	}
+	now = now.Add(61 * time.Minute)
+	tr.Refresh()
+	if got, _ := tr.Indicators(); len(got) != 0 {
+		t.Errorf("expired indicators kept: %+v", got)
+	}
+}
+
+func TestIndicator_STIX(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	i := Indicator{Type: TypeSHA1, Value: strings.Repeat("0", 40), Sensors: []string{"a", "b"}, Events: 5,
+		FirstSeen: ts, LastSeen: ts.Add(time.Minute), Added: ts.Add(time.Second)}
+	s := i.STIX(time.Hour)
+	if s.Pattern != "[file:hashes.'SHA-1' = '"+i.Value+"']" || s.Created != "2026-03-01T12:00:01.000Z" ||
+		s.Modified != "2026-03-01T12:01:00.000Z" || s.ValidUntil != "2026-03-01T13:01:00.000Z" || s.SensorCount != 2 {
+		t.Errorf("STIX = %+v", s)
+	}
+	if s.ID != i.STIXID() || s.ID != (Indicator{Type: TypeSHA1, Value: i.Value}).STIXID() || len(s.ID) != len("indicator--")+36 {
+		t.Errorf("ID %q not derived from the value", s.ID)
+	}
+	if s.ID[len("indicator--")+14] != '5' {
+		t.Errorf("ID %q is not a version 5 UUID", s.ID)
+	}
+}
+
+func TestHandler_TAXII(t *testing.T) {
+	tr := NewTracker(Config{MinSensors: 1})
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		tr.Observe("s1", newEvent(ip, nil))
+	}
+	tr.Refresh()
+	h := NewHandler(tr, "secret")
+
+	get := func(path string, auth func(*http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != nil {
+			auth(r)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	basic := func(r *http.Request) { r.SetBasicAuth("taxii", "secret") }
+
+	if w := get("/taxii2/", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: %d", w.Code)
+	}
+	w := get("/taxii2/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") })
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/intel/taxii2/loom/") {
+		t.Errorf("discovery: %d %s", w.Code, w.Body)
+	}
+
+	objects := "/taxii2/loom/collections/" + CollectionID + "/objects/"
+	w = get(objects+"?limit=2", basic)
+	var env struct {
+		More    bool
+		Next    string
+		Objects []STIXIndicator
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("objects: %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Content-Type") != TAXIIMediaType || !env.More || len(env.Objects) != 2 || w.Header().Get("X-TAXII-Date-Added-First") == "" {
+		t.Errorf("first page: %+v", env)
+	}
+	env.Objects = nil
+	w = get(objects+"?limit=2&next="+env.Next, basic)
+	_ = json.Unmarshal(w.Body.Bytes(), &env)
+	if env.More || len(env.Objects) != 1 || env.Objects[0].Pattern != "[ipv4-addr:value = '192.0.2.3']" {
+		t.Errorf("second page: %+v", env)
+	}
+
+	if w := get(objects+env.Objects[0].ID+"/", basic); w.Code != http.StatusOK {
+		t.Errorf("object by id: %d", w.Code)
+	}
+	if w := get("/taxii2/loom/collections/nope/objects/", basic); w.Code != http.StatusNotFound {
+		t.Errorf("unknown collection: %d", w.Code)
+	}
+	w = get("/stix", basic)
+	var b Bundle
+	if err := json.Unmarshal(w.Body.Bytes(), &b); err != nil || b.Type != "bundle" || len(b.Objects) != 3 ||
+		w.Header().Get("Content-Type") != STIXMediaType {
+		t.Errorf("bundle: %s", w.Body)
+	}
+}
@@ -0,0 +1,245 @@
+package intel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/proxy"
+	"github.com/rs/zerolog"
+)
+
+// MISPConfig says where and how a MISP pusher records indicators.
+type MISPConfig struct {
+	URL    string
+	APIKey string
+	CAFile string // PEM CA bundle for MISP's certificate; system roots when empty
+	Proxy  string // see the proxy package; "" honors HTTPS_PROXY
+	// EventInfo is the info (title) of the MISP event indicators are added to; "{date}" is replaced
+	// with the UTC date of the push, giving one event per day. Default "Loom honeypot indicators {date}".
+	EventInfo    string
+	Distribution int // MISP distribution of new events (0: your organisation only)
+	ThreatLevel  int // threat_level_id of new events; default 3 (low)
+	Analysis     int // analysis state of new events (0 initial, 1 ongoing, 2 completed)
+	Tags         []string
+	ToIDs        bool // set to_ids on the attributes, so MISP exports them to detection systems
+	Sightings    bool // add a sighting each push an indicator was seen again
+}
+
+// MISP pushes a Tracker's indicators to a MISP instance: each becomes an attribute (ip-src, md5,
+// sha1 or sha256) of the event named by EventInfo, and later pushes add sightings when it was seen
+// again. Values already pushed to the event are not sent again.
+type MISP struct {
+	cfg     MISPConfig
+	tracker *Tracker
+	client  *http.Client
+	nowFn   func() time.Time
+
+	events map[string]string // event info -> MISP event ID
+	pushed map[string]int    // event ID, type and value -> indicator event count at the last push
+}
+
+// NewMISP returns a MISP pusher for tracker.
+func NewMISP(cfg MISPConfig, tracker *Tracker) (*MISP, error) {
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("misp: %w", err)
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("misp: ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("misp: ca_file: no certificates in %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+	if cfg.EventInfo == "" {
+		cfg.EventInfo = "Loom honeypot indicators {date}"
+	}
+	if cfg.ThreatLevel == 0 {
+		cfg.ThreatLevel = 3
+	}
+	return &MISP{cfg: cfg, tracker: tracker, client: &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		nowFn: time.Now, events: make(map[string]string), pushed: make(map[string]int)}, nil
+}
+
+// Run pushes every interval until ctx is done, logging failures; the next push retries.
+func (m *MISP) Run(ctx context.Context, interval time.Duration, log zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := m.Push(ctx)
+			if err != nil {
+				log.Warn().Err(err).Msg("misp push")
+			} else if n > 0 {
+				log.Debug().Int("attributes", n).Msg("misp push")
+			}
+		}
+	}
+}
+
+// Push sends the current indicators and returns how many attributes it added. Not safe for
+// concurrent use.
+func (m *MISP) Push(ctx context.Context) (int, error) {
+	indicators, _ := m.tracker.Indicators()
+	if len(indicators) == 0 {
+		return 0, nil
+	}
+	info := strings.ReplaceAll(m.cfg.EventInfo, "{date}", m.nowFn().UTC().Format("2006-01-02"))
+	eventID, err := m.event(ctx, info)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	pushed := make(map[string]int, len(indicators))
+	var sighted []string
+	for _, i := range indicators {
+		k := eventID + "|" + i.Type + "|" + i.Value
+		prev, ok := m.pushed[k]
+		if !ok {
+			if err := m.addAttribute(ctx, eventID, i); err != nil {
+				m.keep(pushed)
+				return added, err
+			}
+			added++
+		}
+		pushed[k] = i.Events
+		if ok && i.Events > prev {
+			sighted = append(sighted, i.Value)
+		}
+	}
+	m.pushed = pushed
+	if m.cfg.Sightings && len(sighted) > 0 {
+		body := map[string]interface{}{"values": sighted, "source": "loom"}
+		if err := m.post(ctx, "/sightings/add", body, nil); err != nil {
+			return added, fmt.Errorf("misp: sightings: %w", err)
+		}
+	}
+	return added, nil
+}
+
+// keep merges what this push managed to send into the pushed set before returning early.
+func (m *MISP) keep(pushed map[string]int) {
+	for k, v := range pushed {
+		m.pushed[k] = v
+	}
+}
+
+// event returns the ID of the event with info, creating it if it does not exist.
+func (m *MISP) event(ctx context.Context, info string) (string, error) {
+	if id := m.events[info]; id != "" {
+		return id, nil
+	}
+	var found []struct {
+		ID   string `json:"id"`
+		Info string `json:"info"`
+	}
+	if err := m.post(ctx, "/events/index", map[string]string{"eventinfo": info}, &found); err != nil {
+		return "", fmt.Errorf("misp: find event: %w", err)
+	}
+	id := ""
+	for _, e := range found {
+		if e.Info == info {
+			id = e.ID
+			break
+		}
+	}
+	if id == "" {
+		tags := make([]map[string]string, len(m.cfg.Tags))
+		for n, t := range m.cfg.Tags {
+			tags[n] = map[string]string{"name": t}
+		}
+		var created struct {
+			Event struct {
+				ID string `json:"id"`
+			}
+		}
+		body := map[string]interface{}{"Event": map[string]interface{}{
+			"info":            info,
+			"distribution":    strconv.Itoa(m.cfg.Distribution),
+			"threat_level_id": strconv.Itoa(m.cfg.ThreatLevel),
+			"analysis":        strconv.Itoa(m.cfg.Analysis),
+			"Tag":             tags,
+		}}
+		if err := m.post(ctx, "/events/add", body, &created); err != nil {
+			return "", fmt.Errorf("misp: create event: %w", err)
+		}
+		if id = created.Event.ID; id == "" {
+			return "", fmt.Errorf("misp: create event: no event ID in the response")
+		}
+	}
+	m.events = map[string]string{info: id} // only the current event is needed
+	return id, nil
+}
+
+// addAttribute adds i to the event. An attribute MISP already has counts as added.
+func (m *MISP) addAttribute(ctx context.Context, eventID string, i Indicator) error {
+	typ, category := "ip-src", "Network activity"
+	if i.Type != TypeIPv4 && i.Type != TypeIPv6 {
+		typ, category = i.Type, "Payload delivery"
+	}
+	body := map[string]interface{}{
+		"type":       typ,
+		"category":   category,
+		"value":      i.Value,
+		"to_ids":     m.cfg.ToIDs,
+		"comment":    fmt.Sprintf("Seen by %d honeypot sensors in %d events", len(i.Sensors), i.Events),
+		"first_seen": i.FirstSeen.UTC().Format(time.RFC3339),
+		"last_seen":  i.LastSeen.UTC().Format(time.RFC3339),
+	}
+	err := m.post(ctx, "/attributes/add/"+eventID, body, nil)
+	if err != nil && strings.Contains(err.Error(), "already exists") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("misp: add attribute %s: %w", i.Value, err)
+	}
+	return nil
+}
+
+// post sends body as JSON to the MISP API and decodes the response into out, if set.
+func (m *MISP) post(ctx context.Context, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.URL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", m.cfg.APIKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,99 @@
+package intel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMISP records the API calls a MISP pusher makes.
+type fakeMISP struct {
+	mu         sync.Mutex
+	events     []string // info of created events
+	attributes []string // type:value
+	sightings  []string
+}
+
+func (f *fakeMISP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "key" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	switch {
+	case r.URL.Path == "/events/index":
+		var out []map[string]string
+		for n, info := range f.events {
+			if info == body["eventinfo"] {
+				out = append(out, map[string]string{"id": string(rune('1' + n)), "info": info})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	case r.URL.Path == "/events/add":
+		f.events = append(f.events, body["Event"].(map[string]interface{})["info"].(string))
+		_, _ = w.Write([]byte(`{"Event":{"id":"` + string(rune('0'+len(f.events))) + `"}}`))
+	case strings.HasPrefix(r.URL.Path, "/attributes/add/"):
+		a := body["type"].(string) + ":" + body["value"].(string)
+		for _, have := range f.attributes {
+			if have == a {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":{"value":["A similar attribute already exists for this event."]}}`))
+				return
+			}
+		}
+		f.attributes = append(f.attributes, a)
+	case r.URL.Path == "/sightings/add":
+		for _, v := range body["values"].([]interface{}) {
+			f.sightings = append(f.sightings, v.(string))
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMISP_PushDeduplicatesAndSights(t *testing.T) {
+	fake := &fakeMISP{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tr := NewTracker(Config{MinSensors: 2})
+	tr.Observe("s1", newEvent("192.0.2.1", map[string]interface{}{"file.hash.md5": strings.Repeat("c", 32)}))
+	tr.Observe("s2", newEvent("192.0.2.1", map[string]interface{}{"file.hash.md5": strings.Repeat("c", 32)}))
+	tr.Refresh()
+
+	m, err := NewMISP(MISPConfig{URL: srv.URL + "/", APIKey: "key", Sightings: true}, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nowFn = func() time.Time { return time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC) }
+	ctx := context.Background()
+	if n, err := m.Push(ctx); err != nil || n != 2 {
+		t.Fatalf("first push: %d, %v", n, err)
+	}
+	if len(fake.events) != 1 || fake.events[0] != "Loom honeypot indicators 2026-03-01" ||
+		len(fake.attributes) != 2 || fake.attributes[0] != "ip-src:192.0.2.1" {
+		t.Fatalf("events %v, attributes %v", fake.events, fake.attributes)
+	}
+
+	// Seen again: a sighting, no new attribute
+	tr.Observe("s1", newEvent("192.0.2.1", nil))
+	tr.Refresh()
+	if n, err := m.Push(ctx); err != nil || n != 0 || len(fake.sightings) != 1 || fake.sightings[0] != "192.0.2.1" {
+		t.Fatalf("second push: %d, %v, sightings %v", n, err, fake.sightings)
+	}
+
+	// A restarted pusher finds the day's event and MISP's duplicate answer counts as added
+	m, _ = NewMISP(MISPConfig{URL: srv.URL, APIKey: "key"}, tr)
+	m.nowFn = func() time.Time { return time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC) }
+	if _, err := m.Push(ctx); err != nil || len(fake.events) != 1 || len(fake.attributes) != 2 {
+		t.Errorf("after restart: %v, events %v, attributes %v", err, fake.events, fake.attributes)
+	}
+}
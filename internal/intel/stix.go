@@ -0,0 +1,121 @@
+package intel
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespace is the UUID namespace of Loom's STIX identifiers, so an indicator keeps its ID across
+// refreshes and restarts.
+var namespace = [16]byte{0x6c, 0x0f, 0x3e, 0x52, 0x9a, 0x1d, 0x4b, 0x7e, 0x8f, 0x21, 0x5d, 0x44, 0x0b, 0x6a, 0x93, 0xc7}
+
+// stixTime is the STIX timestamp format (UTC, millisecond precision).
+const stixTime = "2006-01-02T15:04:05.000Z"
+
+// STIXIndicator is a STIX 2.1 indicator object. The x_loom_* properties carry the sighting counts.
+type STIXIndicator struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	IndicatorTypes []string `json:"indicator_types"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	ValidFrom      string   `json:"valid_from"`
+	ValidUntil     string   `json:"valid_until"`
+	Labels         []string `json:"labels"`
+	SensorCount    int      `json:"x_loom_sensor_count"`
+	EventCount     int      `json:"x_loom_event_count"`
+}
+
+// Bundle is a STIX 2.1 bundle.
+type Bundle struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Objects []STIXIndicator `json:"objects"`
+}
+
+// STIX returns i as a STIX indicator that is valid until window after it was last seen.
+func (i Indicator) STIX(window time.Duration) STIXIndicator {
+	modified := i.LastSeen
+	if i.Added.After(modified) {
+		modified = i.Added
+	}
+	return STIXIndicator{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             i.STIXID(),
+		Created:        i.Added.UTC().Format(stixTime),
+		Modified:       modified.UTC().Format(stixTime),
+		Name:           i.Value,
+		Description:    "Seen by " + strconv.Itoa(len(i.Sensors)) + " honeypot sensors in " + strconv.Itoa(i.Events) + " events",
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        i.pattern(),
+		PatternType:    "stix",
+		ValidFrom:      i.FirstSeen.UTC().Format(stixTime),
+		ValidUntil:     i.LastSeen.Add(window).UTC().Format(stixTime),
+		Labels:         []string{"honeypot"},
+		SensorCount:    len(i.Sensors),
+		EventCount:     i.Events,
+	}
+}
+
+// STIXID returns the indicator's STIX identifier, derived from its type and value.
+func (i Indicator) STIXID() string {
+	return "indicator--" + uuid5(i.Type+":"+i.Value)
+}
+
+// pattern returns the STIX pattern matching the value. Values are IP addresses or hex digests, so
+// they need no escaping.
+func (i Indicator) pattern() string {
+	switch i.Type {
+	case TypeIPv4, TypeIPv6:
+		return "[" + i.Type + ":value = '" + i.Value + "']"
+	case TypeMD5:
+		return "[file:hashes.MD5 = '" + i.Value + "']"
+	case TypeSHA1:
+		return "[file:hashes.'SHA-1' = '" + i.Value + "']"
+	}
+	return "[file:hashes.'SHA-256' = '" + i.Value + "']"
+}
+
+// NewBundle returns a bundle of the indicators.
+func NewBundle(indicators []Indicator, window time.Duration) Bundle {
+	b := Bundle{Type: "bundle", ID: "bundle--" + uuid4(), Objects: make([]STIXIndicator, len(indicators))}
+	for n, i := range indicators {
+		b.Objects[n] = i.STIX(window)
+	}
+	return b
+}
+
+// uuid5 returns the name-based (SHA-1) UUID of name in Loom's namespace.
+func uuid5(name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func uuid4() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	s := fmt.Sprintf("%x", u)
+	return strings.Join([]string{s[:8], s[8:12], s[12:16], s[16:20], s[20:]}, "-")
+}
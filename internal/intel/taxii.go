@@ -0,0 +1,261 @@
+package intel
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Media types of the TAXII 2.1 and STIX 2.1 responses.
+const (
+	TAXIIMediaType = "application/taxii+json;version=2.1"
+	STIXMediaType  = "application/stix+json;version=2.1"
+)
+
+const (
+	apiRoot          = "loom"
+	defaultPageLimit = 1000
+)
+
+// CollectionID is the ID of the TAXII collection holding the indicators.
+var CollectionID = uuid5("collection:indicators")
+
+// Handler serves the indicators of a Tracker on the management port, under BasePath:
+//
+//	GET /stix                                   STIX 2.1 bundle of all indicators
+//	GET /taxii2/                                TAXII 2.1 discovery
+//	GET /taxii2/loom/                           API root
+//	GET /taxii2/loom/collections/               the single, read-only collection
+//	GET /taxii2/loom/collections/{id}/objects/  indicators (added_after, limit, next, match[id], match[type])
+//	GET /taxii2/loom/collections/{id}/manifest/ their manifest
+//
+// Requests need the token as a bearer token or as the password of HTTP basic auth, which most TAXII
+// clients use.
+type Handler struct {
+	BasePath string // where the handler is mounted, for the URLs in discovery; default "/intel"
+
+	tracker *Tracker
+	token   string
+	mux     chi.Router
+}
+
+// NewHandler returns a Handler for tracker with the given token.
+func NewHandler(tracker *Tracker, token string) *Handler {
+	h := &Handler{BasePath: "/intel", tracker: tracker, token: token}
+	mux := chi.NewRouter()
+	mux.Get("/stix", h.bundle)
+	mux.Get("/taxii2/", h.discovery)
+	mux.Get("/taxii2/"+apiRoot+"/", h.apiRoot)
+	mux.Get("/taxii2/"+apiRoot+"/collections/", h.collections)
+	mux.Get("/taxii2/"+apiRoot+"/collections/{id}/", h.collection)
+	mux.Get("/taxii2/"+apiRoot+"/collections/{id}/objects/", h.objects)
+	mux.Get("/taxii2/"+apiRoot+"/collections/{id}/objects/{object}/", h.objects)
+	mux.Get("/taxii2/"+apiRoot+"/collections/{id}/manifest/", h.manifest)
+	h.mux = mux
+	return h
+}
+
+// ServeHTTP checks the token and dispatches to the endpoints.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if _, pass, ok := r.BasicAuth(); ok {
+		token = pass
+	} else if authz := r.Header.Get("Authorization"); len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+		token = strings.TrimSpace(authz[7:])
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="loom"`)
+		taxiiError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) bundle(w http.ResponseWriter, _ *http.Request) {
+	indicators, _ := h.tracker.Indicators()
+	writeMedia(w, STIXMediaType, http.StatusOK, NewBundle(indicators, h.tracker.Window()))
+}
+
+func (h *Handler) discovery(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	root := scheme + "://" + r.Host + h.BasePath + "/taxii2/" + apiRoot + "/"
+	writeMedia(w, TAXIIMediaType, http.StatusOK, map[string]interface{}{
+		"title":       "Loom",
+		"description": "Indicators from the honeypot sensor fleet",
+		"default":     root,
+		"api_roots":   []string{root},
+	})
+}
+
+func (h *Handler) apiRoot(w http.ResponseWriter, _ *http.Request) {
+	writeMedia(w, TAXIIMediaType, http.StatusOK, map[string]interface{}{
+		"title":              "Loom indicators",
+		"versions":           []string{TAXIIMediaType},
+		"max_content_length": 0,
+	})
+}
+
+func collectionInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          CollectionID,
+		"title":       "Honeypot indicators",
+		"description": "Source IPs and file hashes seen by several sensors",
+		"can_read":    true,
+		"can_write":   false,
+		"media_types": []string{STIXMediaType},
+	}
+}
+
+func (h *Handler) collections(w http.ResponseWriter, _ *http.Request) {
+	writeMedia(w, TAXIIMediaType, http.StatusOK, map[string]interface{}{"collections": []interface{}{collectionInfo()}})
+}
+
+func (h *Handler) collection(w http.ResponseWriter, r *http.Request) {
+	if chi.URLParam(r, "id") != CollectionID {
+		taxiiError(w, http.StatusNotFound, "collection not found")
+		return
+	}
+	writeMedia(w, TAXIIMediaType, http.StatusOK, collectionInfo())
+}
+
+func (h *Handler) objects(w http.ResponseWriter, r *http.Request) {
+	page, more, next, ok := h.page(w, r)
+	if !ok {
+		return
+	}
+	objects := make([]STIXIndicator, len(page))
+	for n, i := range page {
+		objects[n] = i.STIX(h.tracker.Window())
+	}
+	env := map[string]interface{}{"more": more, "objects": objects}
+	if more {
+		env["next"] = next
+	}
+	writeMedia(w, TAXIIMediaType, http.StatusOK, env)
+}
+
+func (h *Handler) manifest(w http.ResponseWriter, r *http.Request) {
+	page, more, next, ok := h.page(w, r)
+	if !ok {
+		return
+	}
+	type record struct {
+		ID        string `json:"id"`
+		DateAdded string `json:"date_added"`
+		Version   string `json:"version"`
+		MediaType string `json:"media_type"`
+	}
+	objects := make([]record, len(page))
+	for n, i := range page {
+		s := i.STIX(h.tracker.Window())
+		objects[n] = record{ID: s.ID, DateAdded: s.Created, Version: s.Modified, MediaType: STIXMediaType}
+	}
+	env := map[string]interface{}{"more": more, "objects": objects}
+	if more {
+		env["next"] = next
+	}
+	writeMedia(w, TAXIIMediaType, http.StatusOK, env)
+}
+
+// page selects the indicators of an objects or manifest request and sets the X-TAXII-Date-Added
+// headers. Pages are indicators in date-added order; next is the offset of the following page.
+func (h *Handler) page(w http.ResponseWriter, r *http.Request) (page []Indicator, more bool, next string, ok bool) {
+	if chi.URLParam(r, "id") != CollectionID {
+		taxiiError(w, http.StatusNotFound, "collection not found")
+		return nil, false, "", false
+	}
+	q := r.URL.Query()
+	var addedAfter time.Time
+	if v := q.Get("added_after"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			taxiiError(w, http.StatusBadRequest, "added_after must be an RFC 3339 timestamp")
+			return nil, false, "", false
+		}
+		addedAfter = t
+	}
+	limit := defaultPageLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			taxiiError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return nil, false, "", false
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	offset := 0
+	if v := q.Get("next"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			taxiiError(w, http.StatusBadRequest, "invalid next")
+			return nil, false, "", false
+		}
+		offset = n
+	}
+	ids := splitMatch(q.Get("match[id]"))
+	if id := chi.URLParam(r, "object"); id != "" {
+		ids = map[string]bool{id: true}
+	}
+	types := splitMatch(q.Get("match[type]"))
+
+	all, _ := h.tracker.Indicators()
+	var matched []Indicator
+	for _, i := range all {
+		// Truncated like the millisecond date_added clients page with
+		if !addedAfter.IsZero() && !i.Added.Truncate(time.Millisecond).After(addedAfter) {
+			continue
+		}
+		if ids != nil && !ids[i.STIXID()] || types != nil && !types["indicator"] {
+			continue
+		}
+		matched = append(matched, i)
+	}
+	if chi.URLParam(r, "object") != "" && len(matched) == 0 {
+		taxiiError(w, http.StatusNotFound, "object not found")
+		return nil, false, "", false
+	}
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	page = matched[offset:]
+	if len(page) > limit {
+		page, more, next = page[:limit], true, strconv.Itoa(offset+limit)
+	}
+	if len(page) > 0 {
+		w.Header().Set("X-TAXII-Date-Added-First", page[0].Added.UTC().Format(stixTime))
+		w.Header().Set("X-TAXII-Date-Added-Last", page[len(page)-1].Added.UTC().Format(stixTime))
+	}
+	return page, more, next, true
+}
+
+// splitMatch returns the comma-separated values of a match[...] parameter, or nil if absent.
+func splitMatch(v string) map[string]bool {
+	if v == "" {
+		return nil
+	}
+	m := make(map[string]bool)
+	for _, s := range strings.Split(v, ",") {
+		m[strings.TrimSpace(s)] = true
+	}
+	return m
+}
+
+func writeMedia(w http.ResponseWriter, mediaType string, code int, v interface{}) {
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func taxiiError(w http.ResponseWriter, code int, title string) {
+	writeMedia(w, TAXIIMediaType, code, map[string]interface{}{"title": title, "http_status": strconv.Itoa(code)})
+}
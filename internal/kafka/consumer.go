@@ -0,0 +1,263 @@
+// Package kafka consumes ECS events from Kafka topics so Loom can run as the enrichment tier of an
+// existing streaming setup. Offsets are committed after a batch was processed (at-least-once).
+// Producer writes to a topic for the outputs that speak the Kafka protocol (Event Hubs).
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Config configures a Consumer.
+type Config struct {
+	Brokers     []string
+	Topics      []string
+	GroupID     string
+	StartOffset string // "latest" (default) or "earliest"; used when the group has no committed offset
+
+	// The sensor of a message is read from SensorIDHeader, then from SensorIDField of the event,
+	// then DefaultSensorID.
+	SensorIDHeader  string
+	SensorIDField   string
+	DefaultSensorID string
+
+	BatchSize int           // messages per batch; default 500
+	BatchWait time.Duration // longest wait to fill a batch; default 1s
+
+	TLS           bool
+	SASLMechanism string // "", "plain", "scram-sha-256" or "scram-sha-512"
+	Username      string
+	Password      string
+}
+
+// ProcessFunc handles the events of one sensor from a batch (enrichment and output).
+type ProcessFunc func(sensorID string, events []event.Event) error
+
+// reader is the part of kafka-go's Reader the consumer uses.
+type reader interface {
+	FetchMessage(ctx context.Context) (kafkago.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// Consumer reads events from Kafka and passes them to a ProcessFunc.
+type Consumer struct {
+	cfg     Config
+	r       reader
+	process ProcessFunc
+	onError func(error) // malformed messages and retried processing or commit failures
+	backoff time.Duration
+}
+
+// NewConsumer returns a consumer for cfg. onError receives messages that are skipped and failures
+// that are retried; it may be nil.
+func NewConsumer(cfg Config, process ProcessFunc, onError func(error)) (*Consumer, error) {
+	if len(cfg.Brokers) == 0 || len(cfg.Topics) == 0 {
+		return nil, errors.New("kafka: brokers and topics are required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = time.Second
+	}
+	dialer := &kafkago.Dialer{Timeout: 10 * time.Second, DualStack: true}
+	if cfg.TLS {
+		dialer.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	var err error
+	if dialer.SASLMechanism, err = saslMechanism(cfg.SASLMechanism, cfg.Username, cfg.Password); err != nil {
+		return nil, err
+	}
+	start := kafkago.LastOffset
+	if cfg.StartOffset == "earliest" {
+		start = kafkago.FirstOffset
+	}
+	r := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:     cfg.Brokers,
+		GroupID:     cfg.GroupID,
+		GroupTopics: cfg.Topics,
+		Dialer:      dialer,
+		StartOffset: start,
+		MaxWait:     cfg.BatchWait,
+	})
+	return newConsumer(cfg, r, process, onError), nil
+}
+
+func newConsumer(cfg Config, r reader, process ProcessFunc, onError func(error)) *Consumer {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	return &Consumer{cfg: cfg, r: r, process: process, onError: onError, backoff: time.Second}
+}
+
+func saslMechanism(mechanism, username, password string) (sasl.Mechanism, error) {
+	switch mechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("kafka: unknown sasl_mechanism %q", mechanism)
+}
+
+// Run consumes until ctx is done and then closes the reader. A batch whose processing fails is
+// retried with backoff and its offsets are only committed once it succeeded.
+func (c *Consumer) Run(ctx context.Context) error {
+	defer c.r.Close()
+	for {
+		msgs, err := c.fetchBatch(ctx)
+		if len(msgs) > 0 {
+			if perr := c.handle(ctx, msgs); perr != nil {
+				return nil // ctx done while retrying; the batch is consumed again after a restart
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// fetchBatch collects up to BatchSize messages, returning early once BatchWait has passed since the
+// first one.
+func (c *Consumer) fetchBatch(ctx context.Context) ([]kafkago.Message, error) {
+	var msgs []kafkago.Message
+	msg, err := c.r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs = append(msgs, msg)
+	wait, cancel := context.WithTimeout(ctx, c.cfg.BatchWait)
+	defer cancel()
+	for len(msgs) < c.cfg.BatchSize {
+		msg, err := c.r.FetchMessage(wait)
+		if err != nil {
+			if wait.Err() != nil && ctx.Err() == nil {
+				return msgs, nil // batch wait elapsed
+			}
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// handle processes and commits msgs, retrying until it succeeds or ctx is done.
+func (c *Consumer) handle(ctx context.Context, msgs []kafkago.Message) error {
+	bySensor, order := c.decode(msgs)
+	backoff := c.backoff
+	for {
+		err := c.processAll(bySensor, order)
+		if err == nil {
+			if err = c.r.CommitMessages(ctx, msgs...); err == nil {
+				return nil
+			}
+			bySensor = nil // processed; only the commit is retried
+		}
+		c.onError(err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *Consumer) processAll(bySensor map[string][]event.Event, order []string) error {
+	for _, id := range order {
+		if events := bySensor[id]; len(events) > 0 {
+			if err := c.process(id, events); err != nil {
+				return fmt.Errorf("kafka: process %s: %w", id, err)
+			}
+			delete(bySensor, id) // not processed again when a later sensor fails
+		}
+	}
+	return nil
+}
+
+// decode groups the events of msgs by sensor, in order of first appearance. A message holds one
+// event object or an array of them; malformed messages are reported and skipped.
+func (c *Consumer) decode(msgs []kafkago.Message) (map[string][]event.Event, []string) {
+	bySensor := make(map[string][]event.Event)
+	var order []string
+	for _, m := range msgs {
+		events, err := decodeEvents(m.Value)
+		if err != nil {
+			c.onError(fmt.Errorf("kafka: %s[%d]@%d: %w", m.Topic, m.Partition, m.Offset, err))
+			continue
+		}
+		for _, ev := range events {
+			id := c.sensorID(m, ev)
+			if _, ok := bySensor[id]; !ok {
+				order = append(order, id)
+			}
+			bySensor[id] = append(bySensor[id], ev)
+		}
+	}
+	return bySensor, order
+}
+
+func decodeEvents(value []byte) ([]event.Event, error) {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return nil, errors.New("empty message")
+	}
+	if value[0] == '[' {
+		var events []event.Event
+		if err := json.Unmarshal(value, &events); err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			if ev == nil {
+				return nil, errors.New("null event in array")
+			}
+		}
+		return events, nil
+	}
+	var ev event.Event
+	if err := json.Unmarshal(value, &ev); err != nil {
+		return nil, err
+	}
+	if ev == nil {
+		return nil, errors.New("null event")
+	}
+	return []event.Event{ev}, nil
+}
+
+func (c *Consumer) sensorID(m kafkago.Message, ev event.Event) string {
+	if c.cfg.SensorIDHeader != "" {
+		for _, h := range m.Headers {
+			if h.Key == c.cfg.SensorIDHeader && len(h.Value) > 0 {
+				return string(h.Value)
+			}
+		}
+	}
+	if c.cfg.SensorIDField != "" {
+		if id := ecs.GetString(ev, c.cfg.SensorIDField); id != "" {
+			return id
+		}
+	}
+	return c.cfg.DefaultSensorID
+}
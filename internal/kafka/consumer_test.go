@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// fakeReader hands out msgs, then blocks until the context ends.
+type fakeReader struct {
+	mu        sync.Mutex
+	msgs      []kafkago.Message
+	committed []int64
+	closed    bool
+}
+
+func (f *fakeReader) FetchMessage(ctx context.Context) (kafkago.Message, error) {
+	f.mu.Lock()
+	if len(f.msgs) > 0 {
+		m := f.msgs[0]
+		f.msgs = f.msgs[1:]
+		f.mu.Unlock()
+		return m, nil
+	}
+	f.mu.Unlock()
+	<-ctx.Done()
+	return kafkago.Message{}, ctx.Err()
+}
+
+func (f *fakeReader) CommitMessages(_ context.Context, msgs ...kafkago.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range msgs {
+		f.committed = append(f.committed, m.Offset)
+	}
+	return nil
+}
+
+func (f *fakeReader) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	return nil
+}
+
+func msg(offset int64, value string, headers ...kafkago.Header) kafkago.Message {
+	return kafkago.Message{Topic: "spip", Offset: offset, Value: []byte(value), Headers: headers}
+}
+
+func TestConsumer_GroupsBySensorAndCommits(t *testing.T) {
+	r := &fakeReader{msgs: []kafkago.Message{
+		msg(0, `{"n":1}`, kafkago.Header{Key: "X-Spip-ID", Value: []byte("spip-01")}),
+		msg(1, `[{"n":2,"observer":{"id":"spip-02"}},{"n":3}]`),
+		msg(2, `not json`),
+	}}
+	var mu sync.Mutex
+	got := map[string]int{}
+	var skipped []error
+	done := make(chan struct{})
+	c := newConsumer(Config{SensorIDHeader: "X-Spip-ID", SensorIDField: "observer.id", DefaultSensorID: "kafka", BatchSize: 10, BatchWait: 20 * time.Millisecond, Topics: []string{"spip"}},
+		r, func(sensorID string, events []event.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[sensorID] += len(events)
+			if got["spip-01"]+got["spip-02"]+got["kafka"] == 3 {
+				close(done)
+			}
+			return nil
+		}, func(err error) { skipped = append(skipped, err) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan error)
+	go func() { runDone <- c.Run(ctx) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("events not processed")
+	}
+	cancel()
+	if err := <-runDone; err != nil {
+		t.Fatal(err)
+	}
+
+	if got["spip-01"] != 1 || got["spip-02"] != 1 || got["kafka"] != 1 {
+		t.Errorf("events per sensor = %v", got)
+	}
+	if len(r.committed) != 3 || !r.closed {
+		t.Errorf("committed = %v (malformed messages are committed too), closed = %v", r.committed, r.closed)
+	}
+	if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "spip[0]@2") {
+		t.Errorf("skipped = %v", skipped)
+	}
+}
+
+func TestConsumer_RetriesFailedBatchBeforeCommit(t *testing.T) {
+	r := &fakeReader{msgs: []kafkago.Message{msg(7, `{"n":1}`)}}
+	calls := 0
+	c := newConsumer(Config{DefaultSensorID: "kafka", BatchSize: 1, BatchWait: time.Millisecond}, r,
+		func(string, []event.Event) error {
+			calls++
+			if calls < 3 {
+				return errors.New("output down")
+			}
+			return nil
+		}, nil)
+	c.backoff = time.Millisecond
+
+	batch, err := c.fetchBatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.handle(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(r.committed) != 1 || r.committed[0] != 7 {
+		t.Errorf("calls = %d, committed = %v", calls, r.committed)
+	}
+}
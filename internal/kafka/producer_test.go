@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOAuthBearer(t *testing.T) {
+	m := oauthBearer{token: func(context.Context) (string, error) { return "tok", nil }}
+	sess, ir, err := m.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ir) != "n,,\x01auth=Bearer tok\x01\x01" {
+		t.Errorf("initial response = %q", ir)
+	}
+	if done, _, err := sess.Next(context.Background(), nil); !done || err != nil {
+		t.Errorf("empty server response: done %v, err %v", done, err)
+	}
+	if _, _, err := sess.Next(context.Background(), []byte(`{"status":"invalid_token"}`)); err == nil {
+		t.Error("expected error for an error document")
+	}
+}
+
+func TestNewProducer(t *testing.T) {
+	if _, err := NewProducer(ProducerConfig{Brokers: []string{"localhost:9093"}}); err == nil {
+		t.Error("expected error without a topic")
+	}
+	if _, err := NewProducer(ProducerConfig{Brokers: []string{"localhost:9093"}, Topic: "events", SASLMechanism: "oauthbearer"}); err == nil {
+		t.Error("expected error for oauthbearer without a token source")
+	}
+	p, err := NewProducer(ProducerConfig{Brokers: []string{"localhost:9093"}, Topic: "events", TLS: true, SASLMechanism: "plain", Username: "u", Password: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = p.Close()
+}
@@ -0,0 +1,167 @@
+// Package metrics owns Loom's Prometheus registry. Every component's metrics are registered here so
+// /metrics exposes one consistent set:
+//
+//	loom_ingest_requests_total{sensor_id,status}     ingest requests
+//	loom_ingest_events_total{sensor_id}              events received
+//	loom_ratelimit_rejections_total{sensor_id}       requests rejected by the per-sensor rate limit
+//	loom_ingest_rejections_total{sensor_id,reason}   rejected requests (rate_limit, quota, bad_token, ...)
+//	loom_ingest_stripped_fields_total{sensor_id}     event fields removed by strict mode
+//	loom_ingest_oversized_bodies_total{sensor_id,encoding} bodies over max_body_size_bytes
+//	loom_ingest_backfill_events_total{sensor_id}     events accepted through the backfill endpoint
+//	loom_ingest_backfill_event_timestamp_seconds{sensor_id} newest @timestamp of the last backfill batch
+//	loom_ingest_body_bytes{sensor_id}                histogram of request body sizes (decompressed)
+//	loom_ingest_batch_events{sensor_id}              histogram of events per request
+//	loom_ingest_event_bytes{sensor_id}               histogram of event sizes
+//	loom_sensor_last_event_timestamp_seconds{sensor_id}
+//	loom_sensor_last_event_age_seconds{sensor_id}    seconds since the sensor's last accepted batch
+//	loom_sensor_clock_offset_seconds{sensor_id}      estimated sensor clock offset (clock_skew.enabled)
+//	loom_enrich_lookups_total{stage,result}          enrichment lookups
+//	loom_enrich_cache_hits_total{stage}              enrichment lookups answered from cache
+//	loom_enrich_duration_seconds{stage}              time per enrichment stage
+//	loom_enrich_cache_entries{cache}                 entries in the asn, geo and dns caches
+//	loom_enrich_db_build_timestamp_seconds{db}       build time of the geo and asn databases
+//	loom_enrich_db_age_seconds{db}                   seconds since the database was built
+//	loom_http_request_duration_seconds{route,method,status}
+//	loom_http_requests_in_flight{route}
+//	loom_output_flushes_total{result}                output batches written (ok) or rejected (error)
+//	loom_outbox_files                                batches spooled in the disk outbox
+//	loom_outbox_bytes                                bytes spooled in the disk outbox
+//	loom_outbox_dropped_events_total                 events dropped because the outbox was full
+//	loom_outbox_repaired_files_total                 spool files repaired by the startup scan
+//	loom_outbox_quarantined_files_total              damaged spool files moved to the quarantine directory
+//	loom_build_info{version,commit,build_date,go_version}
+//
+// sensor_id label values can be capped with LimitSensorLabels; sensors over the cap are counted as
+// "other" and left out of the per-sensor gauges.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/StefanGrimminck/Loom/internal/server"
+	"github.com/StefanGrimminck/Loom/internal/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the registry and the per-component metrics handed to each component.
+// A nil *Registry (metrics disabled) returns nil metrics and a nil handler.
+type Registry struct {
+	reg    *prometheus.Registry
+	ingest *ingest.Metrics
+	server *server.Metrics
+}
+
+// New creates a registry with the ingest, HTTP and build metrics registered. The pipeline registers
+// the enrichment metrics through Registerer.
+func New() *Registry {
+	reg := prometheus.NewRegistry()
+	r := &Registry{
+		reg:    reg,
+		ingest: ingest.NewMetrics(reg),
+		server: server.NewMetrics(reg),
+	}
+	version.RegisterMetrics(reg)
+	return r
+}
+
+// Ingest returns the metrics for the ingest handler.
+func (r *Registry) Ingest() *ingest.Metrics {
+	if r == nil {
+		return nil
+	}
+	return r.ingest
+}
+
+// LimitSensorLabels caps the distinct sensor_id label values (see ingest.Metrics.LimitSensors).
+func (r *Registry) LimitSensorLabels(max int) {
+	if r == nil {
+		return
+	}
+	r.ingest.LimitSensors(max)
+}
+
+// Registerer returns the registry for metrics registered outside this package, or nil.
+func (r *Registry) Registerer() prometheus.Registerer {
+	if r == nil {
+		return nil
+	}
+	return r.reg
+}
+
+// Server returns the HTTP metrics for the server.
+func (r *Registry) Server() *server.Metrics {
+	if r == nil {
+		return nil
+	}
+	return r.server
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (r *Registry) Handler() http.Handler {
+	if r == nil {
+		return nil
+	}
+	return promhttp.HandlerFor(r.reg, promhttp.HandlerOpts{})
+}
+
+// RegisterOutput exports w's flush counters and outbox depth, read on each scrape.
+func (r *Registry) RegisterOutput(w output.Writer) {
+	if r == nil {
+		return
+	}
+	flushes := func(result string, count func(output.Stats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "loom_output_flushes_total",
+			Help:        "Output batches written (ok) or rejected by the destination (error)",
+			ConstLabels: prometheus.Labels{"result": result},
+		}, func() float64 { return float64(count(output.StatsOf(w))) })
+	}
+	r.reg.MustRegister(
+		flushes("ok", func(s output.Stats) uint64 { return s.FlushOK }),
+		flushes("error", func(s output.Stats) uint64 { return s.FlushFailed }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "loom_outbox_files",
+			Help: "Batches spooled in the disk outbox awaiting retry",
+		}, func() float64 { return float64(output.StatsOf(w).OutboxFiles) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "loom_outbox_bytes",
+			Help: "Bytes spooled in the disk outbox awaiting retry",
+		}, func() float64 { return float64(output.StatsOf(w).OutboxBytes) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "loom_outbox_dropped_events_total",
+			Help: "Events dropped because the disk outbox was full",
+		}, func() float64 { return float64(output.StatsOf(w).OutboxDroppedEvents) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "loom_outbox_repaired_files_total",
+			Help: "Outbox spool files repaired at startup after a crash (partial last line or unfinished write)",
+		}, func() float64 { return float64(output.StatsOf(w).OutboxRepairedFiles) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "loom_outbox_quarantined_files_total",
+			Help: "Damaged outbox spool files moved to the quarantine directory instead of being drained",
+		}, func() float64 { return float64(output.StatsOf(w).OutboxQuarantinedFiles) }),
+	)
+}
+
+// RegisterEnrichCaches exports the enricher's cache sizes to reg, read on each scrape. A nil reg
+// does nothing.
+func RegisterEnrichCaches(reg prometheus.Registerer, e *enrich.Enricher) {
+	if reg == nil {
+		return
+	}
+	size := func(cache string, pick func(asn, geo, dns int) int) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "loom_enrich_cache_entries",
+			Help:        "Entries in the enrichment lookup caches",
+			ConstLabels: prometheus.Labels{"cache": cache},
+		}, func() float64 { return float64(pick(e.CacheSizes())) })
+	}
+	reg.MustRegister(
+		size("asn", func(asn, _, _ int) int { return asn }),
+		size("geo", func(_, geo, _ int) int { return geo }),
+		size("dns", func(_, _, dns int) int { return dns }),
+	)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/rs/zerolog"
+)
+
+func TestNew_RegistersAllComponents(t *testing.T) {
+	r := New()
+	w, err := output.NewWriter(output.WriterConfig{Type: "stdout"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := enrich.NewEnricher(enrich.Config{CacheSize: 10}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RegisterOutput(w)
+	RegisterEnrichCaches(r.Registerer(), e)
+	r.Ingest().IncRateLimited("s1")
+
+	mfs, err := r.reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for _, mf := range mfs {
+		got[mf.GetName()] = len(mf.GetMetric())
+	}
+	want := map[string]int{
+		"loom_build_info":                     1,
+		"loom_ratelimit_rejections_total":     1,
+		"loom_output_flushes_total":           2,
+		"loom_outbox_files":                   1,
+		"loom_outbox_bytes":                   1,
+		"loom_outbox_dropped_events_total":    1,
+		"loom_outbox_repaired_files_total":    1,
+		"loom_outbox_quarantined_files_total": 1,
+		"loom_enrich_cache_entries":           3,
+	}
+	for name, n := range want {
+		if got[name] != n {
+			t.Errorf("%s: %d series, want %d", name, got[name], n)
+		}
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	if r.Ingest() != nil || r.Registerer() != nil || r.Server() != nil || r.Handler() != nil {
+		t.Error("nil registry should return nil metrics and handler")
+	}
+	r.RegisterOutput(nil)
+	RegisterEnrichCaches(r.Registerer(), nil)
+}
+
+func TestSensorCollector(t *testing.T) {
+	r := New()
+	a := ingest.NewSensorActivity()
+	a.Record("s1", 3)
+	c := &sensorCollector{activity: a, nowFn: func() time.Time { return time.Now().Add(time.Minute) }}
+	r.reg.MustRegister(c)
+
+	mfs, err := r.reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "loom_sensor_last_event_timestamp_seconds":
+			found++
+			if v := mf.GetMetric()[0].GetGauge().GetValue(); v < float64(time.Now().Add(-time.Minute).Unix()) {
+				t.Errorf("last event timestamp = %v", v)
+			}
+		case "loom_sensor_last_event_age_seconds":
+			found++
+			if v := mf.GetMetric()[0].GetGauge().GetValue(); v < 59 || v > 61 {
+				t.Errorf("age = %v, want ~60", v)
+			}
+		}
+	}
+	if found != 2 {
+		t.Errorf("found %d sensor metrics, want 2", found)
+	}
+}
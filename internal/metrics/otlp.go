@@ -0,0 +1,267 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/version"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+)
+
+// OTLPConfig configures pushing the registry to an OpenTelemetry collector over OTLP/HTTP.
+type OTLPConfig struct {
+	Endpoint string            // collector URL; /v1/metrics is appended when it has no path
+	Interval time.Duration     // push interval (default 60s)
+	Timeout  time.Duration     // per-push timeout (default 10s)
+	Headers  map[string]string // extra request headers, e.g. API keys
+}
+
+// OTLPExporter periodically pushes every registered metric as OTLP/HTTP JSON, for sites that collect
+// with OpenTelemetry instead of scraping /metrics. Counters become cumulative monotonic sums, gauges
+// gauges and histograms explicit-bucket histograms.
+type OTLPExporter struct {
+	r        *Registry
+	endpoint string
+	interval time.Duration
+	headers  map[string]string
+	client   *http.Client
+	start    time.Time
+	log      zerolog.Logger
+}
+
+// NewOTLPExporter creates an exporter for r. Returns nil when r is nil (metrics disabled).
+func (r *Registry) NewOTLPExporter(cfg OTLPConfig, log zerolog.Logger) (*OTLPExporter, error) {
+	if r == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("otlp endpoint: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &OTLPExporter{
+		r:        r,
+		endpoint: u.String(),
+		interval: cfg.Interval,
+		headers:  cfg.Headers,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		start:    time.Now(),
+		log:      log,
+	}, nil
+}
+
+// Run pushes every interval until ctx is done. Call Push once more after shutdown to send the final values.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Push(ctx); err != nil {
+				e.log.Warn().Err(err).Msg("otlp push")
+			}
+		}
+	}
+}
+
+// Push gathers the registry and sends it to the collector once. Nil-safe.
+func (e *OTLPExporter) Push(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	mfs, err := e.r.reg.Gather()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(otlpRequest(mfs, e.start, time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("otlp collector %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// OTLP/JSON wire types (opentelemetry-proto metrics/v1). 64-bit integers are JSON strings.
+type (
+	otlpExport struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string          `json:"key"`
+		Value otlpStringValue `json:"value"`
+	}
+	otlpStringValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpMetric struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Sum         *otlpSum       `json:"sum,omitempty"`
+		Gauge       *otlpGauge     `json:"gauge,omitempty"`
+		Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	}
+	otlpNumberPoint struct {
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string     `json:"timeUnixNano"`
+		AsDouble          float64    `json:"asDouble"`
+	}
+	otlpHistogram struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	}
+	otlpHistogramPoint struct {
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		TimeUnixNano      string     `json:"timeUnixNano"`
+		Count             string     `json:"count"`
+		Sum               float64    `json:"sum"`
+		BucketCounts      []string   `json:"bucketCounts"`
+		ExplicitBounds    []float64  `json:"explicitBounds"`
+	}
+)
+
+const otlpCumulative = 2 // AGGREGATION_TEMPORALITY_CUMULATIVE
+
+// otlpRequest converts gathered metric families; start is when the cumulative series began.
+func otlpRequest(mfs []*dto.MetricFamily, start, now time.Time) otlpExport {
+	startNano, nowNano := nanos(start), nanos(now)
+	metrics := make([]otlpMetric, 0, len(mfs))
+	for _, mf := range mfs {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, pm := range mf.GetMetric() {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{
+					Attributes: attrs(pm), StartTimeUnixNano: startNano, TimeUnixNano: nowNano, AsDouble: pm.GetCounter().GetValue(),
+				})
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otlpGauge{}
+			for _, pm := range mf.GetMetric() {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{Attributes: attrs(pm), TimeUnixNano: nowNano, AsDouble: v})
+			}
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, pm := range mf.GetMetric() {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, histogramPoint(pm, startNano, nowNano))
+			}
+		default:
+			continue // summaries are not used by Loom
+		}
+		metrics = append(metrics, m)
+	}
+	info := version.Get()
+	return otlpExport{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttr{
+			{Key: "service.name", Value: otlpStringValue{"loom"}},
+			{Key: "service.version", Value: otlpStringValue{info.Version}},
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/StefanGrimminck/Loom", Version: info.Version},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// histogramPoint converts Prometheus' cumulative buckets to OTLP per-bucket counts; the +Inf
+// bucket is implied by the total count.
+func histogramPoint(pm *dto.Metric, startNano, nowNano string) otlpHistogramPoint {
+	h := pm.GetHistogram()
+	p := otlpHistogramPoint{
+		Attributes:        attrs(pm),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      nowNano,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+	}
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+		prev = b.GetCumulativeCount()
+	}
+	p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+	return p
+}
+
+func attrs(pm *dto.Metric) []otlpAttr {
+	out := make([]otlpAttr, 0, len(pm.GetLabel()))
+	for _, l := range pm.GetLabel() {
+		out = append(out, otlpAttr{Key: l.GetName(), Value: otlpStringValue{l.GetValue()}})
+	}
+	return out
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
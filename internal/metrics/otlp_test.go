@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestOTLPExporter_Push(t *testing.T) {
+	var got otlpExport
+	var path, apiKey string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, apiKey = r.URL.Path, r.Header.Get("Api-Key")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	r := New()
+	r.Ingest().AddEvents("s1", 3)
+	r.Server().Duration.WithLabelValues("ingest", "POST", "204").Observe(0.02)
+	e, err := r.NewOTLPExporter(OTLPConfig{Endpoint: srv.URL, Headers: map[string]string{"api-key": "secret"}}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if path != "/v1/metrics" || apiKey != "secret" {
+		t.Errorf("path = %q, api-key = %q", path, apiKey)
+	}
+
+	byName := make(map[string]otlpMetric)
+	for _, m := range got.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		byName[m.Name] = m
+	}
+	events := byName["loom_ingest_events_total"]
+	if events.Sum == nil || !events.Sum.IsMonotonic || events.Sum.DataPoints[0].AsDouble != 3 {
+		t.Errorf("events counter = %+v", events.Sum)
+	}
+	if info := byName["loom_build_info"]; info.Gauge == nil || info.Gauge.DataPoints[0].AsDouble != 1 {
+		t.Errorf("build info gauge = %+v", info.Gauge)
+	}
+	h := byName["loom_http_request_duration_seconds"].Histogram
+	if h == nil || h.DataPoints[0].Count != "1" || len(h.DataPoints[0].BucketCounts) != len(h.DataPoints[0].ExplicitBounds)+1 {
+		t.Fatalf("histogram = %+v", h)
+	}
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	e, err := New().NewOTLPExporter(OTLPConfig{Endpoint: srv.URL + "/otlp/v1/metrics"}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.endpoint != srv.URL+"/otlp/v1/metrics" {
+		t.Errorf("endpoint = %q; an explicit path must be kept", e.endpoint)
+	}
+	if err := e.Push(context.Background()); err == nil {
+		t.Error("expected error for 400 from collector")
+	}
+}
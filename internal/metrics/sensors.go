@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sensorLastEventDesc = prometheus.NewDesc(
+		"loom_sensor_last_event_timestamp_seconds",
+		"Unix time of the last accepted batch per sensor",
+		[]string{"sensor_id"}, nil)
+	sensorAgeDesc = prometheus.NewDesc(
+		"loom_sensor_last_event_age_seconds",
+		"Seconds since the last accepted batch per sensor; alert when a sensor goes quiet",
+		[]string{"sensor_id"}, nil)
+	sensorClockOffsetDesc = prometheus.NewDesc(
+		"loom_sensor_clock_offset_seconds",
+		"Estimated clock offset per sensor: newest event @timestamp minus receive time, smoothed; positive when the sensor's clock is ahead",
+		[]string{"sensor_id"}, nil)
+)
+
+// sensorCollector exports SensorActivity on each scrape. Sensors without a sensor_id label value of
+// their own under the ingest metrics' cap are left out: these gauges cannot be summed into "other".
+type sensorCollector struct {
+	activity *ingest.SensorActivity
+	labels   *ingest.Metrics
+	nowFn    func() time.Time
+}
+
+func (c *sensorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sensorLastEventDesc
+	ch <- sensorAgeDesc
+}
+
+func (c *sensorCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.nowFn()
+	for _, st := range c.activity.Snapshot() {
+		if c.labels.SensorLabel(st.SensorID) != st.SensorID {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(sensorLastEventDesc, prometheus.GaugeValue,
+			float64(st.LastSeen.UnixNano())/1e9, st.SensorID)
+		ch <- prometheus.MustNewConstMetric(sensorAgeDesc, prometheus.GaugeValue,
+			now.Sub(st.LastSeen).Seconds(), st.SensorID)
+	}
+}
+
+// RegisterSensorActivity exports last-seen time and age per sensor from a.
+func (r *Registry) RegisterSensorActivity(a *ingest.SensorActivity) {
+	if r == nil {
+		return
+	}
+	r.reg.MustRegister(&sensorCollector{activity: a, labels: r.ingest, nowFn: time.Now})
+}
+
+// clockCollector exports ClockSkew offsets on each scrape, with the same sensor_id cap as
+// sensorCollector.
+type clockCollector struct {
+	skew   *ingest.ClockSkew
+	labels *ingest.Metrics
+}
+
+func (c *clockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sensorClockOffsetDesc
+}
+
+func (c *clockCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, o := range c.skew.Snapshot() {
+		if c.labels.SensorLabel(o.SensorID) != o.SensorID {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(sensorClockOffsetDesc, prometheus.GaugeValue, o.Offset.Seconds(), o.SensorID)
+	}
+}
+
+// RegisterClockSkew exports the estimated clock offset per sensor from c.
+func (r *Registry) RegisterClockSkew(c *ingest.ClockSkew) {
+	if r == nil || c == nil {
+		return
+	}
+	r.reg.MustRegister(&clockCollector{skew: c, labels: r.ingest})
+}
+
+var (
+	enrichDBBuildDesc = prometheus.NewDesc(
+		"loom_enrich_db_build_timestamp_seconds",
+		"Build time of each open MaxMind database (db: geo, asn)",
+		[]string{"db"}, nil)
+	enrichDBAgeDesc = prometheus.NewDesc(
+		"loom_enrich_db_age_seconds",
+		"Seconds since each open MaxMind database was built; alert when it stops being updated",
+		[]string{"db"}, nil)
+)
+
+// enrichDBCollector exports the build time and age of the enricher's databases on each scrape.
+type enrichDBCollector struct {
+	enricher *enrich.Enricher
+	nowFn    func() time.Time
+}
+
+func (c *enrichDBCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- enrichDBBuildDesc
+	ch <- enrichDBAgeDesc
+}
+
+func (c *enrichDBCollector) Collect(ch chan<- prometheus.Metric) {
+	now := c.nowFn()
+	for _, db := range c.enricher.Databases() {
+		ch <- prometheus.MustNewConstMetric(enrichDBBuildDesc, prometheus.GaugeValue, float64(db.BuildTime.Unix()), db.Name)
+		ch <- prometheus.MustNewConstMetric(enrichDBAgeDesc, prometheus.GaugeValue, now.Sub(db.BuildTime).Seconds(), db.Name)
+	}
+}
+
+// RegisterEnrichDBs exports the build time and age of e's MaxMind databases to reg. A nil reg
+// does nothing.
+func RegisterEnrichDBs(reg prometheus.Registerer, e *enrich.Enricher) {
+	if reg == nil {
+		return
+	}
+	reg.MustRegister(&enrichDBCollector{enricher: e, nowFn: time.Now})
+}
@@ -0,0 +1,166 @@
+// Package normalize upgrades events from older or flattened ECS shapes to the
+// nested shape and ECS version Loom enriches and writes.
+package normalize
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// DefaultECSVersion is written to ecs.version when no target version is configured.
+const DefaultECSVersion = "8.11.0"
+
+// Mapping moves the value at From to To when To is not already set.
+type Mapping struct {
+	From string
+	To   string
+}
+
+// DefaultMappings upgrade fields that moved between ECS versions or are commonly emitted by older sensors.
+var DefaultMappings = []Mapping{
+	{From: "log.original", To: "event.original"},
+	{From: "source.geo.country_iso", To: "source.geo.country_iso_code"},
+	{From: "destination.geo.country_iso", To: "destination.geo.country_iso_code"},
+	{From: "http.request.body", To: "http.request.body.content"},
+	{From: "user_agent", To: "user_agent.original"},
+	{From: "src_ip", To: "source.ip"},
+	{From: "src_port", To: "source.port"},
+	{From: "dst_ip", To: "destination.ip"},
+	{From: "dst_port", To: "destination.port"},
+}
+
+// Normalizer rewrites events in place: expands dotted keys into nested objects, applies
+// field mappings, coerces common type mismatches and sets ecs.version.
+type Normalizer struct {
+	version  string
+	mappings []Mapping
+}
+
+// New creates a normalizer targeting ecsVersion (default DefaultECSVersion).
+// The given mappings are applied after DefaultMappings.
+func New(ecsVersion string, mappings []Mapping) *Normalizer {
+	if ecsVersion == "" {
+		ecsVersion = DefaultECSVersion
+	}
+	all := make([]Mapping, 0, len(DefaultMappings)+len(mappings))
+	all = append(all, DefaultMappings...)
+	all = append(all, mappings...)
+	return &Normalizer{version: ecsVersion, mappings: all}
+}
+
+// Apply normalizes the event in place.
+func (n *Normalizer) Apply(event event.Event) {
+	if n == nil || event == nil {
+		return
+	}
+	expandDotted(event)
+	for _, m := range n.mappings {
+		move(event, m.From, m.To)
+	}
+	for _, side := range []string{"source", "destination"} {
+		addressToIP(event, side)
+		coercePort(event, side+".port")
+	}
+	coerceTimestamp(event)
+	for _, path := range []string{"event.category", "event.type", "tags"} {
+		coerceArray(event, path)
+	}
+	ecs.Map(event, "ecs")["version"] = n.version
+}
+
+// expandDotted turns {"source.ip": "x"} into {"source": {"ip": "x"}} at every level.
+// If both a dotted and a nested form exist, the nested value wins. A dotted key whose parent is
+// not an object (e.g. "source.ip" next to "source": "x") is kept as it is.
+func expandDotted(m map[string]interface{}) {
+	var dotted []string
+	for k, v := range m {
+		if child, ok := v.(map[string]interface{}); ok {
+			expandDotted(child)
+		}
+		if strings.Contains(k, ".") && !strings.HasPrefix(k, ".") && !strings.HasSuffix(k, ".") && k != "@timestamp" {
+			dotted = append(dotted, k)
+		}
+	}
+	// Sorted, so that of "a.b" and "a.b.c" the parent is expanded and the child kept flat
+	sort.Strings(dotted)
+	for _, k := range dotted {
+		v := m[k]
+		delete(m, k)
+		if !ecs.SetNew(m, k, v) && ecs.Get(m, k) == nil {
+			m[k] = v // a parent is not an object
+		}
+	}
+}
+
+// move moves the value at from to to, unless to is already set. A "to" nested under
+// "from" (e.g. user_agent -> user_agent.original) is supported for scalar values.
+func move(event map[string]interface{}, from, to string) {
+	v := ecs.Get(event, from)
+	if v == nil {
+		return
+	}
+	if _, isMap := v.(map[string]interface{}); isMap {
+		return
+	}
+	if strings.HasPrefix(to, from+".") {
+		ecs.Delete(event, from)
+		ecs.Set(event, to, v)
+		return
+	}
+	if ecs.Get(event, to) != nil {
+		return
+	}
+	ecs.Delete(event, from)
+	ecs.Set(event, to, v)
+}
+
+// addressToIP sets <side>.ip from <side>.address when the address is an IP and ip is missing.
+func addressToIP(event map[string]interface{}, side string) {
+	if ecs.Get(event, side+".ip") != nil {
+		return
+	}
+	addr := ecs.GetString(event, side+".address")
+	if addr != "" && net.ParseIP(addr) != nil {
+		ecs.Set(event, side+".ip", addr)
+	}
+}
+
+// coercePort converts a numeric string port to a number.
+func coercePort(event map[string]interface{}, path string) {
+	s, ok := ecs.Get(event, path).(string)
+	if !ok {
+		return
+	}
+	if p, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && p >= 0 && p <= 65535 {
+		ecs.Set(event, path, float64(p))
+	}
+}
+
+// coerceTimestamp converts a numeric @timestamp (epoch seconds or milliseconds) to RFC 3339.
+func coerceTimestamp(event map[string]interface{}) {
+	f, ok := event["@timestamp"].(float64)
+	if !ok {
+		return
+	}
+	var ts time.Time
+	if f > 1e12 {
+		ts = time.UnixMilli(int64(f))
+	} else {
+		sec := int64(f)
+		ts = time.Unix(sec, int64((f-float64(sec))*1e9))
+	}
+	event["@timestamp"] = ts.UTC().Format(time.RFC3339Nano)
+}
+
+// coerceArray wraps a single string value in an array (ECS categorization fields and tags are arrays).
+func coerceArray(event map[string]interface{}, path string) {
+	if s, ok := ecs.Get(event, path).(string); ok {
+		ecs.Set(event, path, []interface{}{s})
+	}
+}
@@ -0,0 +1,129 @@
+package normalize
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+func TestNormalizer_ExpandsDottedKeys(t *testing.T) {
+	n := New("", nil)
+	ev := map[string]interface{}{
+		"@timestamp":  "2026-02-15T19:47:09Z",
+		"source.ip":   "1.2.3.4",
+		"source.port": float64(4496),
+		"destination": map[string]interface{}{"geo.country_iso_code": "NL", "ip": "5.6.7.8"},
+	}
+	n.Apply(ev)
+
+	if ecs.GetString(ev, "source.ip") != "1.2.3.4" || ecs.Get(ev, "source.port") != float64(4496) {
+		t.Errorf("source = %v", ev["source"])
+	}
+	if ecs.GetString(ev, "destination.geo.country_iso_code") != "NL" || ecs.GetString(ev, "destination.ip") != "5.6.7.8" {
+		t.Errorf("destination = %v", ev["destination"])
+	}
+	if _, ok := ev["source.ip"]; ok {
+		t.Error("dotted key should be removed")
+	}
+	if ecs.GetString(ev, "ecs.version") != DefaultECSVersion {
+		t.Errorf("ecs.version = %v", ecs.Get(ev, "ecs.version"))
+	}
+	if ev["@timestamp"] != "2026-02-15T19:47:09Z" {
+		t.Error("@timestamp should be preserved")
+	}
+}
+
+func TestNormalizer_NestedWinsOverDotted(t *testing.T) {
+	ev := map[string]interface{}{
+		"source":    map[string]interface{}{"ip": "1.1.1.1"},
+		"source.ip": "2.2.2.2",
+	}
+	New("", nil).Apply(ev)
+	if ecs.GetString(ev, "source.ip") != "1.1.1.1" {
+		t.Errorf("source.ip = %v, want nested value", ecs.Get(ev, "source.ip"))
+	}
+}
+
+func TestNormalizer_DottedUnderScalarParent(t *testing.T) {
+	ev := map[string]interface{}{
+		"source":      "honeypot-a",
+		"source.ip":   "2.2.2.2",
+		"network.a":   "x",
+		"network.a.b": "y",
+	}
+	New("", nil).Apply(ev)
+	if ev["source"] != "honeypot-a" || ev["source.ip"] != "2.2.2.2" {
+		t.Errorf("scalar parent should be kept and the dotted key left flat: %v", ev)
+	}
+	if ecs.GetString(ev, "network.a") != "x" || ev["network.a.b"] != "y" {
+		t.Errorf("network = %v, network.a.b = %v", ev["network"], ev["network.a.b"])
+	}
+}
+
+func TestNormalizer_MappingsAndCoercion(t *testing.T) {
+	n := New("8.0.0", []Mapping{{From: "sensor_name", To: "observer.name"}})
+	ev := map[string]interface{}{
+		"@timestamp":  float64(1771184829),
+		"src_ip":      "1.2.3.4",
+		"dst_port":    "6379",
+		"log":         map[string]interface{}{"original": "raw line"},
+		"user_agent":  "curl/8.0",
+		"sensor_name": "spip-001",
+		"event":       map[string]interface{}{"category": "network"},
+		"tags":        "spip",
+	}
+	n.Apply(ev)
+
+	checks := map[string]interface{}{
+		"@timestamp":          "2026-02-15T19:47:09Z",
+		"source.ip":           "1.2.3.4",
+		"destination.port":    float64(6379),
+		"event.original":      "raw line",
+		"user_agent.original": "curl/8.0",
+		"observer.name":       "spip-001",
+		"ecs.version":         "8.0.0",
+	}
+	for path, want := range checks {
+		if got := ecs.Get(ev, path); got != want {
+			t.Errorf("%s = %v (%T), want %v", path, got, got, want)
+		}
+	}
+	if _, ok := ev["src_ip"]; ok {
+		t.Error("src_ip should be moved")
+	}
+	if !reflect.DeepEqual(ecs.Get(ev, "event.category"), []interface{}{"network"}) {
+		t.Errorf("event.category = %v", ecs.Get(ev, "event.category"))
+	}
+	if !reflect.DeepEqual(ev["tags"], []interface{}{"spip"}) {
+		t.Errorf("tags = %v", ev["tags"])
+	}
+}
+
+func TestNormalizer_MappingDoesNotOverwrite(t *testing.T) {
+	ev := map[string]interface{}{
+		"src_ip": "1.2.3.4",
+		"source": map[string]interface{}{"ip": "5.6.7.8"},
+	}
+	New("", nil).Apply(ev)
+	if ecs.GetString(ev, "source.ip") != "5.6.7.8" {
+		t.Errorf("source.ip = %v, existing value should win", ecs.Get(ev, "source.ip"))
+	}
+	if ev["src_ip"] != "1.2.3.4" {
+		t.Error("unmapped legacy field should be kept")
+	}
+}
+
+func TestNormalizer_AddressToIP(t *testing.T) {
+	ev := map[string]interface{}{
+		"source":      map[string]interface{}{"address": "2001:db8::1"},
+		"destination": map[string]interface{}{"address": "example.com"},
+	}
+	New("", nil).Apply(ev)
+	if ecs.GetString(ev, "source.ip") != "2001:db8::1" {
+		t.Errorf("source.ip = %v", ecs.Get(ev, "source.ip"))
+	}
+	if ecs.Get(ev, "destination.ip") != nil {
+		t.Error("non-IP address should not become destination.ip")
+	}
+}
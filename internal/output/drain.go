@@ -0,0 +1,100 @@
+package output
+
+import (
+	"context"
+	"time"
+)
+
+// Pending is what a writer still holds: events buffered in memory and batches spooled to the outbox.
+type Pending struct {
+	BufferedEvents int
+	OutboxFiles    int
+	OutboxBytes    int64
+}
+
+// Empty reports whether nothing is left to send.
+func (p Pending) Empty() bool {
+	return p.BufferedEvents == 0 && p.OutboxFiles == 0
+}
+
+// pendingReporter is implemented by writers that buffer events.
+type pendingReporter interface {
+	Pending() Pending
+}
+
+// drainPollInterval is how often Drain retries while events remain (e.g. outbox waiting on backoff).
+const drainPollInterval = 250 * time.Millisecond
+
+// Drain flushes w until nothing is pending or ctx is done, and returns what was left behind.
+// The returned error is the last flush error, if any. Buffered events left behind are lost;
+// outbox files stay on disk and are retried on the next start.
+func Drain(ctx context.Context, w Writer) (Pending, error) {
+	var lastErr error
+	for {
+		done := make(chan error, 1)
+		go func() { done <- w.Flush() }()
+		select {
+		case err := <-done:
+			lastErr = err
+		case <-ctx.Done():
+			return pendingOf(w), ctx.Err()
+		}
+		left := pendingOf(w)
+		if left.Empty() {
+			return left, lastErr
+		}
+		select {
+		case <-ctx.Done():
+			return left, lastErr
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+func pendingOf(w Writer) Pending {
+	if pr, ok := w.(pendingReporter); ok {
+		return pr.Pending()
+	}
+	return Pending{}
+}
+
+func (e *esWriter) Pending() Pending {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Pending{BufferedEvents: len(e.buf)}
+}
+
+func (c *clickHouseWriter) Pending() Pending {
+	c.mu.Lock()
+	p := Pending{BufferedEvents: len(c.buf)}
+	c.mu.Unlock()
+	if c.outbox != nil {
+		p.OutboxFiles, p.OutboxBytes, _ = c.outbox.stats()
+	}
+	return p
+}
+
+func (f *forwardWriter) Pending() Pending {
+	files, size := f.c.SpoolSize()
+	return Pending{OutboxFiles: files, OutboxBytes: size}
+}
+
+// add sums what w holds into p.
+func (p *Pending) add(w Writer) {
+	q := pendingOf(w)
+	p.BufferedEvents += q.BufferedEvents
+	p.OutboxFiles += q.OutboxFiles
+	p.OutboxBytes += q.OutboxBytes
+}
+
+func (r *tenantRouter) Pending() Pending {
+	var p Pending
+	_ = r.each(func(_ string, w Writer) error { p.add(w); return nil })
+	return p
+}
+
+func (r *router) Pending() Pending {
+	var p Pending
+	_ = r.each(func(w Writer) error { p.add(w); return nil })
+	return p
+}
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrain_FlushesBufferedEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "elasticsearch", ElasticsearchURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Write(spipStyleEvent())
+	_ = w.Write(spipStyleEvent())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	left, err := Drain(ctx, w)
+	if err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if !left.Empty() {
+		t.Errorf("left behind: %+v", left)
+	}
+}
+
+func TestDrain_ReturnsFlushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Write(spipStyleEvent())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	left, err := Drain(ctx, w)
+	if err == nil {
+		t.Error("expected flush error")
+	}
+	// Failed insert without outbox drops the batch from the buffer; nothing is retained
+	if left.BufferedEvents != 0 || left.OutboxFiles != 0 {
+		t.Errorf("left behind: %+v", left)
+	}
+}
+
+func TestDrain_OutboxLeftBehind(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{
+		Type:               "clickhouse",
+		ClickHouseURL:      srv.URL,
+		SkipClickHousePing: true,
+		ClickHouseOutbox:   OutboxConfig{Enabled: true, Dir: t.TempDir(), MaxBytes: 1 << 20, RetryBackoff: time.Hour, RetryMaxBackoff: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Write(spipStyleEvent())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	left, _ := Drain(ctx, w)
+	if left.OutboxFiles != 1 || left.OutboxBytes == 0 {
+		t.Errorf("left behind: %+v, want one outbox file", left)
+	}
+}
+
+func TestFlushError_NamesRequestIDs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"req-a", "req-a", "req-b", ""} {
+		ev := spipStyleEvent()
+		if id != "" {
+			ev["loom"] = map[string]interface{}{"request_id": id}
+		}
+		_ = w.Write(ev)
+	}
+	if err := w.Flush(); err == nil || !strings.HasSuffix(err.Error(), "(request_ids=req-a,req-b)") {
+		t.Errorf("Flush = %v, want the batch's request IDs", err)
+	}
+}
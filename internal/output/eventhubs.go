@@ -0,0 +1,224 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/kafka"
+)
+
+// eventHubsPort is the Kafka endpoint of an Event Hubs namespace (Standard tier and above).
+const eventHubsPort = "9093"
+
+// aadAuthority issues the Azure AD tokens for Event Hubs with a client secret.
+var aadAuthority = "https://login.microsoftonline.com"
+
+type producer interface {
+	Send(ctx context.Context, msgs []kafka.Message) error
+	Close() error
+}
+
+// eventHubsWriter sends events to an Azure Event Hub through the namespace's Kafka endpoint, one
+// JSON event per record keyed by sensor ID (so a sensor's events share a partition and stay in
+// order), in batches of up to 100 records.
+type eventHubsWriter struct {
+	p        producer
+	broker   string // host:port, for Health
+	flushLog FlushLogger
+	mu       sync.Mutex
+	buf      []kafka.Message
+	flush    int
+	barrier  flushBarrier // see Sync
+
+	flushOK, flushFailed atomic.Uint64
+}
+
+func newEventHubsWriter(cfg WriterConfig) (*eventHubsWriter, error) {
+	ns, hub := cfg.EventHubsNamespace, cfg.EventHubsName
+	var cs map[string]string
+	if cfg.EventHubsConnectionString != "" {
+		var err error
+		if cs, err = parseConnectionString(cfg.EventHubsConnectionString); err != nil {
+			return nil, err
+		}
+		if ns == "" {
+			ns = cs["Endpoint"]
+		}
+		if hub == "" {
+			hub = cs["EntityPath"]
+		}
+	}
+	host := eventHubsHost(ns)
+	if host == "" || hub == "" {
+		return nil, fmt.Errorf("eventhubs: namespace and event hub name required (or a connection string with EntityPath)")
+	}
+	pcfg := kafka.ProducerConfig{Brokers: []string{net.JoinHostPort(host, eventHubsPort)}, Topic: hub, TLS: true}
+	switch {
+	case cs != nil:
+		// The SAS connection string is the password of the user "$ConnectionString"
+		pcfg.SASLMechanism, pcfg.Username, pcfg.Password = "plain", "$ConnectionString", cfg.EventHubsConnectionString
+	case cfg.EventHubsTenantID != "" && cfg.EventHubsClientID != "" && cfg.EventHubsClientSecret != "":
+		transport, err := httpTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		tok := &cachedToken{fetch: aadToken(
+			&http.Client{Timeout: 30 * time.Second, Transport: transport},
+			aadAuthority+"/"+url.PathEscape(cfg.EventHubsTenantID)+"/oauth2/v2.0/token",
+			cfg.EventHubsClientID, cfg.EventHubsClientSecret, "https://"+host+"/.default",
+		)}
+		pcfg.SASLMechanism, pcfg.Token = "oauthbearer", tok.get
+	default:
+		return nil, fmt.Errorf("eventhubs: a connection string (SAS) or tenant ID, client ID and client secret (Azure AD) required")
+	}
+	p, err := kafka.NewProducer(pcfg)
+	if err != nil {
+		return nil, err
+	}
+	return &eventHubsWriter{p: p, broker: pcfg.Brokers[0], flushLog: cfg.FlushLog, flush: 100}, nil
+}
+
+// parseConnectionString splits an Event Hubs connection string
+// ("Endpoint=sb://<ns>.servicebus.windows.net/;SharedAccessKeyName=...;SharedAccessKey=...;EntityPath=...").
+func parseConnectionString(s string) (map[string]string, error) {
+	kv := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("eventhubs: malformed connection string")
+		}
+		kv[k] = v
+	}
+	if kv["Endpoint"] == "" || kv["SharedAccessKeyName"] == "" || kv["SharedAccessKey"] == "" {
+		return nil, fmt.Errorf("eventhubs: connection string needs Endpoint, SharedAccessKeyName and SharedAccessKey")
+	}
+	return kv, nil
+}
+
+// eventHubsHost returns the namespace's host name from "<ns>", "<ns>.servicebus.windows.net" or
+// an endpoint URL such as "sb://<ns>.servicebus.windows.net/".
+func eventHubsHost(ns string) string {
+	if i := strings.Index(ns, "://"); i >= 0 {
+		ns = ns[i+3:]
+	}
+	ns = strings.TrimSuffix(ns, "/")
+	if ns != "" && !strings.Contains(ns, ".") {
+		ns += ".servicebus.windows.net"
+	}
+	return ns
+}
+
+func (e *eventHubsWriter) Write(ev event.Event) error {
+	return e.WriteFrom("", ev)
+}
+
+// WriteFrom buffers event as a record keyed by sensorID.
+func (e *eventHubsWriter) WriteFrom(sensorID string, ev event.Event) error {
+	if ev == nil {
+		return nil
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return e.WriteRaw(sensorID, b)
+}
+
+// WriteRaw buffers an encoded event from sensorID.
+func (e *eventHubsWriter) WriteRaw(sensorID string, raw json.RawMessage) error {
+	m := kafka.Message{Value: raw}
+	if sensorID != "" {
+		m.Key = []byte(sensorID)
+	}
+	e.mu.Lock()
+	e.buf = append(e.buf, m)
+	full := len(e.buf) >= e.flush
+	e.mu.Unlock()
+	if full {
+		return e.Flush()
+	}
+	return nil
+}
+
+func (e *eventHubsWriter) Flush() error {
+	return e.flushBuf()
+}
+
+func (e *eventHubsWriter) flushBuf() (err error) {
+	done := e.barrier.start()
+	defer func() { done(err != nil) }()
+	e.mu.Lock()
+	batch := e.buf
+	e.buf = nil
+	e.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = e.p.Send(ctx, batch)
+	if err != nil {
+		e.flushFailed.Add(1)
+	} else {
+		e.flushOK.Add(1)
+	}
+	if e.flushLog != nil {
+		e.flushLog(len(batch), err)
+	}
+	return err
+}
+
+func (e *eventHubsWriter) Sync() error {
+	return e.barrier.sync(e.flushBuf)
+}
+
+func (e *eventHubsWriter) Close() error {
+	err := e.flushBuf()
+	if cerr := e.p.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Health checks that the namespace's Kafka endpoint accepts connections.
+func (e *eventHubsWriter) Health(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.broker)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (e *eventHubsWriter) Stats() Stats {
+	return Stats{FlushOK: e.flushOK.Load(), FlushFailed: e.flushFailed.Load()}
+}
+
+// aadToken returns a fetch for cachedToken that gets Azure AD access tokens for scope with the
+// client credentials grant.
+func aadToken(client *http.Client, endpoint, clientID, secret, scope string) func(ctx context.Context) (string, time.Time, error) {
+	return func(ctx context.Context) (string, time.Time, error) {
+		token, expires, err := requestToken(ctx, client, endpoint, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {clientID},
+			"client_secret": {secret},
+			"scope":         {scope},
+		})
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("azure ad %w", err)
+		}
+		return token, expires, nil
+	}
+}
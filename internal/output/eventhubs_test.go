@@ -0,0 +1,103 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/kafka"
+)
+
+type fakeProducer struct {
+	batches [][]kafka.Message
+	err     error
+}
+
+func (f *fakeProducer) Send(_ context.Context, msgs []kafka.Message) error {
+	f.batches = append(f.batches, msgs)
+	return f.err
+}
+
+func (f *fakeProducer) Close() error { return nil }
+
+func TestEventHubsWriter(t *testing.T) {
+	p := &fakeProducer{}
+	w := &eventHubsWriter{p: p, flush: 2}
+	if err := WriteFrom(w, "spip-001", event.Event{"message": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.batches) != 0 {
+		t.Fatal("sent before the batch was full")
+	}
+	if err := w.Write(event.Event{"message": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.batches) != 1 || len(p.batches[0]) != 2 {
+		t.Fatalf("batches = %v, want one of 2 records", p.batches)
+	}
+	if m := p.batches[0][0]; string(m.Key) != "spip-001" || string(m.Value) != `{"message":"a"}` {
+		t.Errorf("record = %s %s, want keyed by sensor", m.Key, m.Value)
+	}
+	if m := p.batches[0][1]; m.Key != nil {
+		t.Errorf("record without a sensor keyed %q", m.Key)
+	}
+
+	p.err = errors.New("broker down")
+	_ = w.Write(event.Event{"message": "c"})
+	if err := Sync(w); !errors.Is(err, p.err) {
+		t.Errorf("Sync = %v, want the send error", err)
+	}
+	if st := w.Stats(); st.FlushOK != 1 || st.FlushFailed != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestEventHubsConfig(t *testing.T) {
+	cs, err := parseConnectionString("Endpoint=sb://loom-ns.servicebus.windows.net/;SharedAccessKeyName=send;SharedAccessKey=c2VjcmV0;EntityPath=events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := eventHubsHost(cs["Endpoint"]); h != "loom-ns.servicebus.windows.net" || cs["EntityPath"] != "events" {
+		t.Errorf("host %q, entity path %q", h, cs["EntityPath"])
+	}
+	if h := eventHubsHost("loom-ns"); h != "loom-ns.servicebus.windows.net" {
+		t.Errorf("short namespace host = %q", h)
+	}
+	if _, err := parseConnectionString("Endpoint=sb://loom-ns.servicebus.windows.net/"); err == nil {
+		t.Error("expected error for a connection string without a key")
+	}
+	if _, err := NewWriter(WriterConfig{Type: "eventhubs", EventHubsNamespace: "loom-ns"}); err == nil {
+		t.Error("expected error without an event hub name and credentials")
+	}
+}
+
+func TestAADToken(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "https://loom-ns.servicebus.windows.net/.default" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"tok-1","expires_in":3600}`))
+	}))
+	defer srv.Close()
+	a := &cachedToken{fetch: aadToken(srv.Client(), srv.URL, "app", "s", "https://loom-ns.servicebus.windows.net/.default")}
+	for i := 0; i < 2; i++ {
+		if tok, err := a.get(context.Background()); err != nil || tok != "tok-1" {
+			t.Fatalf("token = %q, %v", tok, err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d token requests, want the token cached", n)
+	}
+	a.token, a.fetch = "", aadToken(srv.Client(), srv.URL, "app", "s", "other")
+	if _, err := a.get(context.Background()); err == nil {
+		t.Error("expected error for a rejected token request")
+	}
+}
@@ -0,0 +1,203 @@
+package output
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/pkg/client"
+)
+
+// forwardWriteTimeout bounds how long Write waits when it sends a full batch, so ingest is not
+// held up while the upstream is slow or answers 429/503: the batch then goes to the outbox (or,
+// without one, fails).
+const forwardWriteTimeout = 5 * time.Second
+
+// forwardWriter sends events to the ingest endpoint of another Loom (an edge instance near the
+// sensors forwarding to a central one), batched, with retries, and spooled to the outbox directory
+// when the upstream stays unavailable.
+type forwardWriter struct {
+	c             *client.Client
+	http          *http.Client
+	url           string
+	spoolDir      string // empty without an outbox
+	maxSpoolBytes int64
+	readyMaxBytes int64
+	flushLog      FlushLogger
+	pingAttempts  int // see retryPing
+
+	flushOK, flushFailed atomic.Uint64
+	droppedEvents        atomic.Int64
+}
+
+func newForwardWriter(cfg WriterConfig) (*forwardWriter, error) {
+	transport, err := httpTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ForwardCAFile != "" {
+		pem, err := os.ReadFile(cfg.ForwardCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("forward_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("forward_ca_file: no certificates in %s", cfg.ForwardCAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+	f := &forwardWriter{url: cfg.ForwardURL, flushLog: cfg.FlushLog, pingAttempts: cfg.HTTP.PingAttempts}
+	f.http = &http.Client{Timeout: cfg.HTTP.requestTimeout(), Transport: &countingTransport{next: transport, w: f}}
+	ccfg := client.Config{
+		URL:        cfg.ForwardURL,
+		Token:      cfg.ForwardToken,
+		SensorID:   cfg.ForwardSensorID,
+		HTTPClient: f.http,
+		Gzip:       cfg.ForwardGzip,
+		Backoff:    cfg.ClickHouseOutbox.RetryBackoff,
+	}
+	if ob := cfg.ClickHouseOutbox; ob.Enabled {
+		// With a spool to fall back on, retry briefly so ingest is not held up by a long outage.
+		ccfg.SpoolDir, ccfg.MaxRetries, ccfg.MaxRetryAfter = ob.Dir, 2, forwardWriteTimeout
+		f.spoolDir, f.maxSpoolBytes, f.readyMaxBytes = ob.Dir, ob.MaxBytes, ob.ReadyMaxBytes
+	}
+	c, err := client.New(ccfg)
+	if err != nil {
+		return nil, err
+	}
+	f.c = c
+	return f, nil
+}
+
+// countingTransport counts requests to the upstream for Stats.
+type countingTransport struct {
+	next http.RoundTripper
+	w    *forwardWriter
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if req.Method == http.MethodPost {
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			t.w.flushOK.Add(1)
+		} else {
+			t.w.flushFailed.Add(1)
+		}
+	}
+	return resp, err
+}
+
+func (f *forwardWriter) Write(event event.Event) error {
+	if event == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), forwardWriteTimeout)
+	defer cancel()
+	return f.c.Add(ctx, event)
+}
+
+func (f *forwardWriter) WriteRaw(_ string, raw json.RawMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardWriteTimeout)
+	defer cancel()
+	return f.c.Add(ctx, raw)
+}
+
+// Flush sends the queued events and, once the upstream accepts them, the spooled batches. Batches
+// left in the spool are not an error: they are retried on the next flush.
+func (f *forwardWriter) Flush() error {
+	if f.spoolDir == "" {
+		err := f.c.Flush(context.Background())
+		if err != nil && f.flushLog != nil {
+			f.flushLog(0, err)
+		}
+		return err
+	}
+	before, _ := f.c.SpoolSize()
+	if err := f.c.Flush(context.Background()); err != nil {
+		if f.flushLog != nil {
+			f.flushLog(0, err)
+		}
+		return err
+	}
+	if after, _ := f.c.SpoolSize(); after > before {
+		if f.flushLog != nil {
+			f.flushLog(0, fmt.Errorf("forward upstream unavailable; batch queued to outbox (queue_files=%d)", after))
+		}
+	} else if sent, err := f.c.Resend(context.Background()); err != nil {
+		if f.flushLog != nil {
+			f.flushLog(sent, fmt.Errorf("outbox drain failed: %w", err))
+		}
+	} else if sent > 0 && f.flushLog != nil {
+		f.flushLog(sent, nil)
+	}
+	f.trimSpool()
+	return nil
+}
+
+func (f *forwardWriter) Close() error {
+	return f.Flush()
+}
+
+// Health checks the outbox depth against the ready threshold and that the upstream answers at its
+// ingest URL (any response below 500; a GET is refused with 405 without touching the pipeline).
+func (f *forwardWriter) Health(ctx context.Context) error {
+	if f.spoolDir != "" && f.readyMaxBytes > 0 {
+		if _, size := f.c.SpoolSize(); size > f.readyMaxBytes {
+			return fmt.Errorf("outbox holds %d bytes (ready threshold %d)", size, f.readyMaxBytes)
+		}
+	}
+	return retryPing(ctx, f.pingAttempts, f.ping)
+}
+
+func (f *forwardWriter) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return &pingStatusError{status: resp.StatusCode, msg: fmt.Sprintf("forward upstream %d", resp.StatusCode)}
+	}
+	return nil
+}
+
+func (f *forwardWriter) Stats() Stats {
+	st := Stats{FlushOK: f.flushOK.Load(), FlushFailed: f.flushFailed.Load(), OutboxDroppedEvents: f.droppedEvents.Load()}
+	if f.spoolDir != "" {
+		st.OutboxFiles, st.OutboxBytes = f.c.SpoolSize()
+	}
+	return st
+}
+
+// trimSpool drops the oldest spooled batches while the spool is above the outbox max_bytes.
+func (f *forwardWriter) trimSpool() {
+	if f.maxSpoolBytes <= 0 {
+		return
+	}
+	if _, size := f.c.SpoolSize(); size <= f.maxSpoolBytes {
+		return
+	}
+	paths, _ := f.c.SpoolFiles()
+	for _, p := range paths {
+		if _, size := f.c.SpoolSize(); size <= f.maxSpoolBytes {
+			return
+		}
+		if events, err := f.c.DropSpooled(p); err == nil {
+			f.droppedEvents.Add(int64(events))
+			if f.flushLog != nil {
+				f.flushLog(events, fmt.Errorf("outbox full, dropped oldest forward batch %s", p))
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwardWriter_SpoolsAndResends(t *testing.T) {
+	var mu sync.Mutex
+	down := true
+	var received []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer edge-token" || r.Header.Get("X-Spip-ID") != "edge-ams" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		received = append(received, batch...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w, err := NewWriter(WriterConfig{
+		Type:            "forward",
+		ForwardURL:      srv.URL + "/api/v1/ingest",
+		ForwardToken:    "edge-token",
+		ForwardSensorID: "edge-ams",
+		ClickHouseOutbox: OutboxConfig{
+			Enabled:       true,
+			Dir:           t.TempDir(),
+			RetryBackoff:  time.Millisecond,
+			ReadyMaxBytes: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(map[string]interface{}{"observer": map[string]interface{}{"id": "spip-01"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush while upstream is down should spool: %v", err)
+	}
+	st := StatsOf(w)
+	if st.OutboxFiles != 1 || st.FlushOK != 0 || st.FlushFailed != 3 {
+		t.Errorf("stats while down = %+v", st)
+	}
+	if err := w.Health(context.Background()); err == nil {
+		t.Error("expected unhealthy while the outbox is above ready_max_bytes")
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0]["observer"].(map[string]interface{})["id"] != "spip-01" {
+		t.Errorf("received = %v", received)
+	}
+	if st := StatsOf(w); st.OutboxFiles != 0 || st.FlushOK != 1 {
+		t.Errorf("stats after resend = %+v", st)
+	}
+	if err := w.Health(context.Background()); err != nil {
+		t.Errorf("Health: %v", err)
+	}
+}
+
+func TestNewWriter_Forward_NoURL(t *testing.T) {
+	if _, err := NewWriter(WriterConfig{Type: "forward", ForwardToken: "t"}); err == nil {
+		t.Fatal("expected error when forward_url is empty")
+	}
+}
+
+func TestForwardWriter_WriteDoesNotWaitForUpstream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	cfg := WriterConfig{
+		Type:             "forward",
+		ForwardURL:       srv.URL,
+		ForwardToken:     "edge-token",
+		ClickHouseOutbox: OutboxConfig{Enabled: true, Dir: t.TempDir(), RetryBackoff: time.Millisecond},
+	}
+	w, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 500; i++ { // one full batch
+		if err := w.Write(map[string]interface{}{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 2*forwardWriteTimeout {
+		t.Errorf("Write took %v while the upstream asked to wait an hour", d)
+	}
+	st := StatsOf(w)
+	if st.OutboxFiles != 1 || st.OutboxBytes == 0 {
+		t.Errorf("stats = %+v, want the batch in the outbox", st)
+	}
+
+	// A new writer counts what the outbox already holds.
+	w2, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st2 := StatsOf(w2); st2.OutboxFiles != 1 || st2.OutboxBytes != st.OutboxBytes {
+		t.Errorf("stats after restart = %+v, want %+v", st2, st)
+	}
+}
@@ -0,0 +1,353 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+const (
+	defaultGELFChunkSize = 1420 // fits an Ethernet MTU with IP and UDP headers
+	gelfMaxChunks        = 128  // Graylog drops messages of more chunks
+)
+
+// gelfWriteTimeout bounds a TCP write: a Graylog that stops reading must not block ingest (writes
+// hold the writer's lock).
+var gelfWriteTimeout = 10 * time.Second
+
+// gelfWriter sends each event as a GELF 1.1 message to Graylog: over UDP compressed and, when
+// larger than one datagram, chunked; over TCP uncompressed and null-byte delimited (Graylog's TCP
+// input does not decompress); over HTTP as one POST per message. Messages are sent as they are
+// written, so there is nothing to flush.
+type gelfWriter struct {
+	network   string // "udp", "tcp" or "http"
+	addr      string // host:port; the URL for http
+	host      string // GELF host field
+	compress  string // "gzip", "zlib" or "none"
+	chunkSize int
+	client    *http.Client
+
+	mu   sync.Mutex // guards conn and serializes writes to it
+	conn net.Conn
+
+	flushOK, flushFailed atomic.Uint64
+}
+
+func newGELFWriter(cfg WriterConfig) (*gelfWriter, error) {
+	u, err := url.Parse(cfg.GELFURL)
+	if err != nil {
+		return nil, fmt.Errorf("gelf_url: %w", err)
+	}
+	g := &gelfWriter{network: u.Scheme, addr: u.Host, host: cfg.GELFHost, compress: cfg.GELFCompression, chunkSize: cfg.GELFChunkSize}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Port() == "" {
+			return nil, fmt.Errorf("gelf_url %q: port required", cfg.GELFURL)
+		}
+	case "http", "https":
+		g.network, g.addr = "http", u.String()
+		transport, err := httpTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		g.client = &http.Client{Timeout: cfg.HTTP.requestTimeout(), Transport: transport}
+	default:
+		return nil, fmt.Errorf("gelf_url %q: scheme must be udp, tcp, http or https", cfg.GELFURL)
+	}
+	if g.host == "" {
+		g.host, _ = os.Hostname()
+	}
+	if g.compress == "" {
+		g.compress = "none"
+		if g.network == "udp" {
+			g.compress = "gzip"
+		}
+	}
+	if g.compress != "none" && g.network == "tcp" {
+		return nil, fmt.Errorf("gelf_compression: GELF over TCP is sent uncompressed")
+	}
+	if g.chunkSize <= 0 {
+		g.chunkSize = defaultGELFChunkSize
+	}
+	return g, nil
+}
+
+func (g *gelfWriter) Write(ev event.Event) error {
+	if ev == nil {
+		return nil
+	}
+	msg, err := json.Marshal(gelfMessage(ev, g.host))
+	if err != nil {
+		return err
+	}
+	if err := g.send(msg); err != nil {
+		g.flushFailed.Add(1)
+		return fmt.Errorf("gelf %s: %w", g.network, err)
+	}
+	g.flushOK.Add(1)
+	return nil
+}
+
+func (g *gelfWriter) send(msg []byte) error {
+	switch g.network {
+	case "udp":
+		payload, err := g.compressed(msg)
+		if err != nil {
+			return err
+		}
+		return g.sendUDP(payload)
+	case "tcp":
+		return g.sendTCP(append(msg, 0))
+	}
+	return g.sendHTTP(msg)
+}
+
+func (g *gelfWriter) compressed(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch g.compress {
+	case "gzip":
+		zw = gzip.NewWriter(&buf)
+	case "zlib":
+		zw = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	if _, err := zw.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *gelfWriter) sendUDP(payload []byte) error {
+	chunks, err := gelfChunks(payload, g.chunkSize)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		conn, err := net.Dial("udp", g.addr)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+	for _, c := range chunks {
+		if _, err := g.conn.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gelfChunks splits payload into GELF chunks of at most size bytes including the 12-byte header
+// (magic bytes, message ID, sequence number and count); a payload that fits is sent as is.
+func gelfChunks(payload []byte, size int) ([][]byte, error) {
+	if len(payload) <= size {
+		return [][]byte{payload}, nil
+	}
+	const header = 12
+	per := size - header
+	n := (len(payload) + per - 1) / per
+	if n > gelfMaxChunks {
+		return nil, fmt.Errorf("message of %d bytes needs %d chunks (at most %d)", len(payload), n, gelfMaxChunks)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		end := (i + 1) * per
+		if end > len(payload) {
+			end = len(payload)
+		}
+		c := make([]byte, 0, header+end-i*per)
+		c = append(c, 0x1e, 0x0f)
+		c = append(c, id[:]...)
+		c = append(c, byte(i), byte(n))
+		chunks = append(chunks, append(c, payload[i*per:end]...))
+	}
+	return chunks, nil
+}
+
+// sendTCP writes msg on the open connection, and once more on a new one if that fails (Graylog
+// or a load balancer may have closed an idle connection). A write that times out is not retried:
+// Graylog is there but not reading. Either way the connection is dropped, as a message may have
+// been written in part.
+func (g *gelfWriter) sendTCP(msg []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if g.conn == nil {
+			if g.conn, err = net.DialTimeout("tcp", g.addr, 10*time.Second); err != nil {
+				return err
+			}
+		}
+		_ = g.conn.SetWriteDeadline(time.Now().Add(gelfWriteTimeout))
+		if _, err = g.conn.Write(msg); err == nil {
+			return nil
+		}
+		g.conn.Close()
+		g.conn = nil
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+	}
+	return err
+}
+
+func (g *gelfWriter) sendHTTP(msg []byte) error {
+	body, err := g.compressed(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, g.addr, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch g.compress {
+	case "gzip":
+		req.Header.Set("Content-Encoding", "gzip")
+	case "zlib":
+		req.Header.Set("Content-Encoding", "deflate")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, string(b))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// gelfMessage converts ev to a GELF 1.1 message: message becomes short_message (or a summary of
+// the connection when the event has none), @timestamp the timestamp in seconds, and every other
+// leaf an additional field named after its path with underscores ("_source_geo_country_iso_code").
+// Arrays are sent as JSON and booleans as "true" or "false": GELF fields are strings or numbers.
+func gelfMessage(ev event.Event, host string) map[string]interface{} {
+	msg := map[string]interface{}{"version": "1.1", "host": host, "level": 6} // informational
+	short := ev.GetString("message")
+	if short == "" {
+		short = gelfSummary(ev)
+	}
+	msg["short_message"] = short
+	if ts, ok := ev.Timestamp(); ok {
+		msg["timestamp"] = float64(ts.UnixMilli()) / 1000
+	}
+	fields := make(map[string]interface{})
+	flattenInto(fields, "", ev)
+	for k, v := range fields {
+		if k == "message" || k == "@timestamp" || v == nil {
+			continue
+		}
+		name := "_" + strings.ReplaceAll(k, ".", "_")
+		if name == "_id" { // reserved by GELF
+			name = "_event_id"
+		}
+		switch v := v.(type) {
+		case string, json.Number, float64, float32, int, int64, int32, uint64, uint32:
+			msg[name] = v
+		case bool:
+			msg[name] = strconv.FormatBool(v)
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			msg[name] = string(b)
+		}
+	}
+	return msg
+}
+
+// gelfSummary describes an event without a message, e.g. "connection 203.0.113.7:51234 ->
+// 198.51.100.2:22".
+func gelfSummary(ev event.Event) string {
+	what := ev.GetString("event.action")
+	if what == "" {
+		what = "event"
+	}
+	src, dst := ev.SourceIP(), ev.DestinationIP()
+	if p := ev.SourcePort(); p > 0 && src != "" {
+		src = net.JoinHostPort(src, strconv.Itoa(p))
+	}
+	if p := ev.DestinationPort(); p > 0 && dst != "" {
+		dst = net.JoinHostPort(dst, strconv.Itoa(p))
+	}
+	switch {
+	case src != "" && dst != "":
+		return what + " " + src + " -> " + dst
+	case src != "":
+		return what + " from " + src
+	}
+	return what
+}
+
+// Flush does nothing: every message is sent when written.
+func (g *gelfWriter) Flush() error { return nil }
+
+func (g *gelfWriter) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
+
+// Health checks that Graylog accepts TCP connections at the input's address (for HTTP, at the
+// URL's host). A UDP input cannot be checked; only the address is resolved.
+func (g *gelfWriter) Health(ctx context.Context) error {
+	addr := g.addr
+	switch g.network {
+	case "udp":
+		_, err := net.ResolveUDPAddr("udp", addr)
+		return err
+	case "http":
+		u, _ := url.Parse(g.addr)
+		addr = u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (g *gelfWriter) Stats() Stats {
+	return Stats{FlushOK: g.flushOK.Load(), FlushFailed: g.flushFailed.Load()}
+}
@@ -0,0 +1,216 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestGELFMessage(t *testing.T) {
+	ev := event.Event{
+		"@timestamp":  "2024-05-01T10:00:00.250Z",
+		"id":          "abc",
+		"source":      map[string]interface{}{"ip": "203.0.113.7", "port": 51234.0, "geo": map[string]interface{}{"country_iso_code": "NL"}},
+		"destination": map[string]interface{}{"ip": "198.51.100.2", "port": 22.0},
+		"event":       map[string]interface{}{"action": "connection"},
+		"tags":        []interface{}{"a", "b"},
+		"loom":        map[string]interface{}{"internal": true},
+	}
+	msg := gelfMessage(ev, "loom-1")
+	want := map[string]interface{}{
+		"version":                      "1.1",
+		"host":                         "loom-1",
+		"short_message":                "connection 203.0.113.7:51234 -> 198.51.100.2:22",
+		"timestamp":                    1714557600.25,
+		"_event_id":                    "abc",
+		"_source_ip":                   "203.0.113.7",
+		"_source_geo_country_iso_code": "NL",
+		"_destination_port":            22.0,
+		"_tags":                        `["a","b"]`,
+		"_loom_internal":               "true",
+	}
+	for k, v := range want {
+		if msg[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, msg[k], v)
+		}
+	}
+	if _, ok := msg["_id"]; ok {
+		t.Error("reserved _id field sent")
+	}
+	ev["message"] = "ssh login"
+	if got := gelfMessage(ev, "loom-1")["short_message"]; got != "ssh login" {
+		t.Errorf("short_message = %v, want the event message", got)
+	}
+}
+
+func TestGELFWriter_UDPChunks(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	w, err := NewWriter(WriterConfig{Type: "gelf", GELFURL: "udp://" + pc.LocalAddr().String(), GELFChunkSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// Random hex compresses to about half, so the message needs several chunks
+	raw := make([]byte, 600)
+	_, _ = rand.New(rand.NewSource(1)).Read(raw)
+	noise := hex.EncodeToString(raw)
+	if err := w.Write(event.Event{"message": "big", "noise": noise}); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[byte][]byte)
+	var count byte
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for count == 0 || len(parts) < int(count) {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 100 || buf[0] != 0x1e || buf[1] != 0x0f {
+			t.Fatalf("datagram of %d bytes, header % x: want chunks of at most 100 bytes", n, buf[:2])
+		}
+		count = buf[11]
+		parts[buf[10]] = append([]byte(nil), buf[12:n]...)
+	}
+	var payload []byte
+	for i := byte(0); i < count; i++ {
+		payload = append(payload, parts[i]...)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.NewDecoder(zr).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["short_message"] != "big" || msg["_noise"] != noise {
+		t.Errorf("reassembled message = %v", msg["short_message"])
+	}
+}
+
+func TestGELFWriter_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			got <- strings.TrimSuffix(msg, "\x00")
+		}
+	}()
+	w, err := NewWriter(WriterConfig{Type: "gelf", GELFURL: "tcp://" + ln.Addr().String(), GELFHost: "loom-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, m := range []string{"one", "two"} {
+		if err := w.Write(event.Event{"message": m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, m := range []string{"one", "two"} {
+		var msg map[string]interface{}
+		if err := json.Unmarshal([]byte(<-got), &msg); err != nil || msg["short_message"] != m || msg["host"] != "loom-1" {
+			t.Errorf("message = %v (%v), want %s", msg, err, m)
+		}
+	}
+	if err := w.Health(context.Background()); err != nil {
+		t.Errorf("Health = %v", err)
+	}
+}
+
+func TestGELFWriter_TCPStalled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept() // never read from
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+	old := gelfWriteTimeout
+	gelfWriteTimeout = 100 * time.Millisecond
+	defer func() { gelfWriteTimeout = old }()
+	w, err := NewWriter(WriterConfig{Type: "gelf", GELFURL: "tcp://" + ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	big := event.Event{"message": strings.Repeat("x", 1<<20)}
+	start := time.Now()
+	for i := 0; i < 64; i++ { // more than the socket buffers hold
+		if err = w.Write(big); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Fatal("writes to a Graylog that does not read never failed")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("stalled write returned after %s", d)
+	}
+}
+
+func TestGELFWriter_HTTP(t *testing.T) {
+	var body []byte
+	var encoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ = io.ReadAll(zr)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "gelf", GELFURL: srv.URL + "/gelf", GELFCompression: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(event.Event{"message": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "gzip" || !strings.Contains(string(body), `"short_message":"hello"`) {
+		t.Errorf("Content-Encoding %q, body %s", encoding, body)
+	}
+	if st := StatsOf(w); st.FlushOK != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
@@ -0,0 +1,125 @@
+package output
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func (s *stdoutWriter) Health(ctx context.Context) error {
+	return nil
+}
+
+// Health checks the Elasticsearch cluster responds at the base URL.
+func (e *esWriter) Health(ctx context.Context) error {
+	return retryPing(ctx, e.pingAttempts, e.ping)
+}
+
+func (e *esWriter) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.baseURL+"/", nil)
+	if err != nil {
+		return err
+	}
+	if e.user != "" && e.pass != "" {
+		req.SetBasicAuth(e.user, e.pass)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &pingStatusError{status: resp.StatusCode, msg: fmt.Sprintf("elasticsearch ping %d: %s", resp.StatusCode, string(body))}
+	}
+	return nil
+}
+
+// Health pings ClickHouse and checks the outbox depth against the ready threshold.
+func (c *clickHouseWriter) Health(ctx context.Context) error {
+	if c.outbox != nil && c.readyMaxBytes > 0 {
+		if _, bytes, _ := c.outbox.stats(); bytes > c.readyMaxBytes {
+			return fmt.Errorf("outbox holds %d bytes (ready threshold %d)", bytes, c.readyMaxBytes)
+		}
+	}
+	return retryPing(ctx, c.pingAttempts, func(ctx context.Context) error {
+		return pingClickHouse(ctx, c.client, c.url, c.user, c.pass)
+	})
+}
+
+// HealthMonitor checks a Writer periodically and caches the result, so readiness probes
+// do not wait on the destination.
+type HealthMonitor struct {
+	w        Writer
+	timeout  time.Duration
+	onChange func(err error)
+
+	mu    sync.RWMutex
+	err   error
+	since time.Time // when the writer became unhealthy
+}
+
+// NewHealthMonitor returns a monitor for w. Each check is bounded by timeout (default 5s);
+// onChange (optional) is called when the writer goes from healthy to unhealthy or back.
+func NewHealthMonitor(w Writer, timeout time.Duration, onChange func(err error)) *HealthMonitor {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &HealthMonitor{w: w, timeout: timeout, onChange: onChange}
+}
+
+// Check runs one health check and stores the result.
+func (m *HealthMonitor) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	err := m.w.Health(ctx)
+	m.mu.Lock()
+	changed := (err == nil) != (m.err == nil)
+	m.err = err
+	if err == nil {
+		m.since = time.Time{}
+	} else if changed {
+		m.since = time.Now()
+	}
+	m.mu.Unlock()
+	if changed && m.onChange != nil {
+		m.onChange(err)
+	}
+	return err
+}
+
+// Run checks every interval until ctx is done.
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = m.Check(ctx)
+		}
+	}
+}
+
+// Ready reports whether the last check succeeded.
+func (m *HealthMonitor) Ready() bool {
+	return m.Err() == nil
+}
+
+// Err returns the error from the last check, or nil.
+func (m *HealthMonitor) Err() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err
+}
+
+// UnhealthySince returns when the writer started failing checks; zero while healthy.
+func (m *HealthMonitor) UnhealthySince() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.since
+}
@@ -0,0 +1,74 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClickHouseHealth_Ping(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []error
+	m := NewHealthMonitor(w, time.Second, func(err error) { changes = append(changes, err) })
+	if err := m.Check(context.Background()); err != nil || !m.Ready() {
+		t.Fatalf("healthy server: err=%v ready=%v", err, m.Ready())
+	}
+	status.Store(http.StatusServiceUnavailable)
+	if err := m.Check(context.Background()); err == nil || m.Ready() {
+		t.Fatal("unhealthy server: want error and not ready")
+	}
+	since := m.UnhealthySince()
+	_ = m.Check(context.Background())
+	if since.IsZero() || !m.UnhealthySince().Equal(since) {
+		t.Errorf("UnhealthySince = %v then %v; want the time of the first failure", since, m.UnhealthySince())
+	}
+	status.Store(http.StatusOK)
+	_ = m.Check(context.Background())
+	if !m.Ready() || !m.UnhealthySince().IsZero() {
+		t.Error("recovered server: want ready")
+	}
+	if len(changes) != 2 || changes[0] == nil || changes[1] != nil {
+		t.Errorf("onChange calls = %v, want [err <nil>]", changes)
+	}
+}
+
+func TestClickHouseHealth_OutboxThreshold(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{
+		Type:               "clickhouse",
+		ClickHouseURL:      srv.URL,
+		SkipClickHousePing: true,
+		ClickHouseOutbox:   OutboxConfig{Enabled: true, Dir: t.TempDir(), MaxBytes: 1 << 20, ReadyMaxBytes: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Health(context.Background()); err != nil {
+		t.Fatalf("empty outbox: %v", err)
+	}
+	_ = w.Write(spipStyleEvent())
+	_ = w.Flush() // insert fails, batch is spooled
+	if err := w.Health(context.Background()); err == nil {
+		t.Error("outbox above ready_max_bytes: want error")
+	}
+}
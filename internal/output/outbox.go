@@ -11,13 +11,16 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
 )
 
 type spoolFileMeta struct {
-	name   string
-	path   string
-	size   int64
-	events int
+	name    string
+	path    string
+	size    int64
+	events  int
+	sensors map[string]int // events per sensor ID; nil for files left by a previous run
 }
 
 // diskOutbox is a simple NDJSON file spool for failed ClickHouse batches.
@@ -30,6 +33,17 @@ type diskOutbox struct {
 	files         []spoolFileMeta
 	seq           int64
 	droppedEvents int64
+
+	// fair evicts from the sensor holding the most bytes rather than the oldest file first, and
+	// maxSensorBytes > 0 caps the bytes of one sensor's files (see enforceMaxBytesLocked). Either
+	// needs files that each hold one sensor's events (see clickHouseWriter.spool).
+	fair           bool
+	maxSensorBytes int64
+
+	// Startup scan (see checkFile); quarantinedFiles also counts files found unreadable later.
+	repairs          []spoolRepair
+	repairedFiles    int64
+	quarantinedFiles int64
 }
 
 func newDiskOutbox(dir string, maxBytes int64) (*diskOutbox, error) {
@@ -47,6 +61,7 @@ func newDiskOutbox(dir string, maxBytes int64) (*diskOutbox, error) {
 	return ob, nil
 }
 
+// reload loads the spooled files left by a previous run, checking each one (see checkFile).
 func (o *diskOutbox) reload() error {
 	ents, err := os.ReadDir(o.dir)
 	if err != nil {
@@ -55,25 +70,16 @@ func (o *diskOutbox) reload() error {
 	files := make([]spoolFileMeta, 0, len(ents))
 	var total int64
 	for _, ent := range ents {
-		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".ndjson") {
-			continue
-		}
-		path := filepath.Join(o.dir, ent.Name())
-		info, err := ent.Info()
-		if err != nil {
+		name := ent.Name()
+		if ent.IsDir() || !strings.HasSuffix(name, ".ndjson") && !strings.HasSuffix(name, ".ndjson.tmp") {
 			continue
 		}
-		events, err := countNDJSONLines(path)
-		if err != nil {
+		meta, ok := o.checkFile(name)
+		if !ok {
 			continue
 		}
-		files = append(files, spoolFileMeta{
-			name:   ent.Name(),
-			path:   path,
-			size:   info.Size(),
-			events: events,
-		})
-		total += info.Size()
+		files = append(files, meta)
+		total += meta.size
 	}
 	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
 	o.files = files
@@ -81,17 +87,25 @@ func (o *diskOutbox) reload() error {
 	return nil
 }
 
-func (o *diskOutbox) enqueue(batch []map[string]interface{}) (droppedEvents int, err error) {
+func (o *diskOutbox) enqueue(batch []event.Event) (droppedEvents int, err error) {
+	raw := make([]json.RawMessage, len(batch))
+	for i, ev := range batch {
+		if raw[i], err = json.Marshal(ev); err != nil {
+			return 0, err
+		}
+	}
+	return o.enqueueFrom(raw, nil)
+}
+
+// enqueueFrom spools batch, encoded events one per line, remembering how many of its events came
+// from each sensor.
+func (o *diskOutbox) enqueueFrom(batch []json.RawMessage, sensors map[string]int) (droppedEvents int, err error) {
 	if len(batch) == 0 {
 		return 0, nil
 	}
 	var body bytes.Buffer
-	for _, ev := range batch {
-		b, err := json.Marshal(ev)
-		if err != nil {
-			return 0, err
-		}
-		body.Write(b)
+	for _, raw := range batch {
+		body.Write(raw)
 		body.WriteByte('\n')
 	}
 	o.mu.Lock()
@@ -108,41 +122,115 @@ func (o *diskOutbox) enqueue(batch []map[string]interface{}) (droppedEvents int,
 		return 0, err
 	}
 	meta := spoolFileMeta{
-		name:   name,
-		path:   final,
-		size:   int64(body.Len()),
-		events: len(batch),
+		name:    name,
+		path:    final,
+		size:    int64(body.Len()),
+		events:  len(batch),
+		sensors: sensors,
 	}
 	o.files = append(o.files, meta)
 	sort.Slice(o.files, func(i, j int) bool { return o.files[i].name < o.files[j].name })
 	o.totalBytes += meta.size
-	droppedEvents = o.enforceMaxBytesLocked()
+	droppedEvents = o.enforceMaxBytesLocked(meta.owner())
 	return droppedEvents, nil
 }
 
-func (o *diskOutbox) enforceMaxBytesLocked() int {
+// enforceMaxBytesLocked drops spooled files until the outbox is within its limits, and returns the
+// events dropped. The file just written (owned by newest) is kept. With maxSensorBytes, newest's
+// oldest files go first while it holds more than that. Then, while the outbox is over maxBytes, the
+// oldest file goes, or with fair the oldest file of the sensor holding the most bytes, so a sensor
+// that floods the outbox during an outage loses its own events rather than everyone's.
+func (o *diskOutbox) enforceMaxBytesLocked(newest string) int {
+	dropped := 0
+	if o.maxSensorBytes > 0 && newest != "" {
+		for {
+			held := o.bytesByOwnerLocked()
+			i := o.oldestOfLocked(newest)
+			if held[newest] <= o.maxSensorBytes || i < 0 || i == len(o.files)-1 {
+				break
+			}
+			dropped += o.dropLocked(i)
+		}
+	}
 	if o.maxBytes <= 0 {
-		return 0
+		return dropped
 	}
-	dropped := 0
 	for o.totalBytes > o.maxBytes && len(o.files) > 1 {
-		oldest := o.files[0]
-		o.files = o.files[1:]
-		o.totalBytes -= oldest.size
-		o.droppedEvents += int64(oldest.events)
-		dropped += oldest.events
-		_ = os.Remove(oldest.path)
+		i := 0
+		if o.fair {
+			i = o.fairVictimLocked()
+		}
+		dropped += o.dropLocked(i)
 	}
 	return dropped
 }
 
-func (o *diskOutbox) oldestMeta() (spoolFileMeta, bool) {
+// perSensor reports whether files must hold one sensor's events each for eviction.
+func (o *diskOutbox) perSensor() bool {
+	return o.fair || o.maxSensorBytes > 0
+}
+
+// dropLocked removes the file at index i and returns its events.
+func (o *diskOutbox) dropLocked(i int) int {
+	f := o.files[i]
+	o.files = append(o.files[:i], o.files[i+1:]...)
+	o.totalBytes -= f.size
+	o.droppedEvents += int64(f.events)
+	_ = os.Remove(f.path)
+	return f.events
+}
+
+// owner is the sensor whose events f holds (the one with the most, for a mixed file); "" for files
+// without attribution.
+func (f spoolFileMeta) owner() string {
+	owner, most := "", 0
+	for id, n := range f.sensors {
+		if n > most || n == most && id < owner {
+			owner, most = id, n
+		}
+	}
+	return owner
+}
+
+func (o *diskOutbox) bytesByOwnerLocked() map[string]int64 {
+	out := make(map[string]int64)
+	for _, f := range o.files {
+		out[f.owner()] += f.size
+	}
+	return out
+}
+
+// oldestOfLocked returns the index of owner's oldest file, or -1.
+func (o *diskOutbox) oldestOfLocked(owner string) int {
+	for i, f := range o.files {
+		if f.owner() == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// fairVictimLocked returns the index of the oldest file of the owner holding the most bytes; on a
+// tie, the owner with the older file. The newest file is only chosen when it is the only one.
+func (o *diskOutbox) fairVictimLocked() int {
+	held := o.bytesByOwnerLocked()
+	victim := -1
+	for i, f := range o.files[:len(o.files)-1] {
+		if victim < 0 || held[f.owner()] > held[o.files[victim].owner()] {
+			victim = i
+		}
+	}
+	return victim
+}
+
+// oldest returns up to n of the oldest spooled files, oldest first.
+func (o *diskOutbox) oldest(n int) []spoolFileMeta {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	if len(o.files) == 0 {
-		return spoolFileMeta{}, false
+	if n > len(o.files) {
+		n = len(o.files)
 	}
-	return o.files[0], true
+	return append([]spoolFileMeta(nil), o.files[:n]...)
 }
 
 func (o *diskOutbox) removeByName(name string) error {
@@ -174,47 +262,65 @@ func (o *diskOutbox) stats() (files int, bytes int64, droppedEvents int64) {
 	return len(o.files), o.totalBytes, o.droppedEvents
 }
 
-func readBatchFile(path string) ([]map[string]interface{}, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// holdsAny reports whether a spooled file may hold events from one of sensors. Files without
+// sensor attribution (left by a previous run) may hold anything.
+func (o *diskOutbox) holdsAny(sensors map[string]bool) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, f := range o.files {
+		if f.sensors == nil {
+			return true
+		}
+		for id := range f.sensors {
+			if sensors[id] {
+				return true
+			}
+		}
 	}
-	defer f.Close()
-	out := make([]map[string]interface{}, 0, 128)
-	sc := bufio.NewScanner(f)
-	buf := make([]byte, 0, 64*1024)
-	sc.Buffer(buf, 2*1024*1024)
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" {
+	return false
+}
+
+// eventsBySensor returns the spooled events per sensor ID. Events without a known sensor (e.g. spooled
+// before a restart) are counted under "".
+func (o *diskOutbox) eventsBySensor() map[string]int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := make(map[string]int)
+	for _, f := range o.files {
+		if f.sensors == nil {
+			out[""] += f.events
 			continue
 		}
-		var ev map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &ev); err != nil {
-			return nil, err
+		for id, n := range f.sensors {
+			out[id] += n
 		}
-		out = append(out, ev)
 	}
-	if err := sc.Err(); err != nil {
-		return nil, err
-	}
-	return out, nil
+	return out
 }
 
-func countNDJSONLines(path string) (int, error) {
+// readBatchFile returns the encoded events of an outbox file.
+func readBatchFile(path string) ([]json.RawMessage, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer f.Close()
+	out := make([]json.RawMessage, 0, 128)
 	sc := bufio.NewScanner(f)
 	buf := make([]byte, 0, 64*1024)
-	sc.Buffer(buf, 2*1024*1024)
-	n := 0
+	sc.Buffer(buf, maxSpoolLine)
 	for sc.Scan() {
-		if strings.TrimSpace(sc.Text()) != "" {
-			n++
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !validSpoolLine(line) {
+			return nil, fmt.Errorf("event %d is not a JSON object", len(out)+1)
 		}
+		out = append(out, append(json.RawMessage(nil), line...))
 	}
-	return n, sc.Err()
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
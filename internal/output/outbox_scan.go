@@ -0,0 +1,171 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// quarantineDir is the subdirectory of the outbox that damaged spool files are moved to. They are
+// kept for inspection (or `loom replay` after a manual fix) and never drained.
+const quarantineDir = "quarantine"
+
+// maxSpoolLine is the longest event line an outbox file may hold.
+const maxSpoolLine = 2 * 1024 * 1024
+
+// Results of the startup scan of a spool file.
+const (
+	spoolRepaired    = "repaired"    // a cut-off last line was removed
+	spoolRecovered   = "recovered"   // a .tmp file left by a crash before its rename was put in place
+	spoolRemoved     = "removed"     // no complete event was left
+	spoolQuarantined = "quarantined" // a bad line before the last one; moved to quarantineDir
+)
+
+// spoolRepair is what the startup scan did to one spool file.
+type spoolRepair struct {
+	name   string
+	result string
+	events int   // events kept (for quarantined files, the readable ones before the bad line)
+	cut    int   // bytes removed from the end
+	err    error // why the file was quarantined, or why the repair failed
+}
+
+// checkFile scans the spool file name at startup. A crash while a file is written can leave its
+// last line cut off (or padded with NUL bytes); the file is cut back to its last complete event. A
+// bad line before that means the damage is not from a crash, and nothing tells which events are
+// intact: the file is moved to quarantineDir. A .tmp file (the crash came before the rename) is
+// checked the same way and then renamed into the spool. ok is false when nothing is left to drain.
+func (o *diskOutbox) checkFile(name string) (meta spoolFileMeta, ok bool) {
+	path := filepath.Join(o.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return meta, false
+	}
+	final := strings.TrimSuffix(name, ".tmp")
+	events, keep, bad := scanSpoolData(data)
+	rep := spoolRepair{name: final, events: events, cut: len(data) - keep}
+	switch {
+	case bad > 0:
+		rep.result = spoolQuarantined
+		rep.err = fmt.Errorf("line %d is not a JSON object", bad)
+		if err := o.moveToQuarantine(path, final); err != nil {
+			rep.err = fmt.Errorf("%v; moving it to %s failed: %w", rep.err, quarantineDir, err)
+		}
+		o.quarantinedFiles++
+		o.repairs = append(o.repairs, rep)
+		return meta, false
+	case events == 0:
+		if name == final && rep.cut == 0 {
+			_ = os.Remove(path) // empty: nothing to report
+			return meta, false
+		}
+		rep.result = spoolRemoved
+		rep.err = os.Remove(path)
+	case rep.cut > 0:
+		rep.result = spoolRepaired
+		rep.err = os.Truncate(path, int64(keep))
+	}
+	if name != final && rep.err == nil {
+		if rep.result == "" {
+			rep.result = spoolRecovered
+		}
+		rep.err = os.Rename(path, filepath.Join(o.dir, final))
+	}
+	if rep.result != "" {
+		o.repairedFiles++
+		o.repairs = append(o.repairs, rep)
+	}
+	if events == 0 || rep.err != nil {
+		return meta, false
+	}
+	return spoolFileMeta{name: final, path: filepath.Join(o.dir, final), size: int64(keep), events: events}, true
+}
+
+// scanSpoolData counts the events in the contents of a spool file. keep is the length of the part
+// to retain: all of data, or up to a damaged last line. bad is the number (from 1) of a damaged line
+// followed by more data, or 0.
+func scanSpoolData(data []byte) (events, keep, bad int) {
+	line := 0
+	for off := 0; off < len(data); {
+		next := len(data)
+		if i := bytes.IndexByte(data[off:], '\n'); i >= 0 {
+			next = off + i + 1
+		}
+		line++
+		l := bytes.TrimSpace(data[off:next])
+		switch {
+		case len(l) == 0:
+		case validSpoolLine(l):
+			events++
+		case len(bytes.Trim(data[next:], " \t\r\n\x00")) == 0:
+			return events, off, 0
+		default:
+			return events, off, line
+		}
+		off = next
+	}
+	return events, len(data), 0
+}
+
+// validSpoolLine reports whether line (trimmed) holds one encoded event.
+func validSpoolLine(line []byte) bool {
+	return len(line) <= maxSpoolLine && line[0] == '{' && json.Valid(line)
+}
+
+// moveToQuarantine moves the file at path to quarantineDir under name.
+func (o *diskOutbox) moveToQuarantine(path, name string) error {
+	dir := filepath.Join(o.dir, quarantineDir)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(dir, name))
+}
+
+// quarantine takes the spooled file name out of the queue and moves it to quarantineDir.
+func (o *diskOutbox) quarantine(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, f := range o.files {
+		if f.name == name {
+			o.files = append(o.files[:i], o.files[i+1:]...)
+			o.totalBytes -= f.size
+			o.quarantinedFiles++
+			return o.moveToQuarantine(f.path, f.name)
+		}
+	}
+	return nil
+}
+
+// scanStats returns the number of files the startup scan repaired and the files quarantined.
+func (o *diskOutbox) scanStats() (repaired, quarantined int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.repairedFiles, o.quarantinedFiles
+}
+
+// logRepairs reports the results of the startup scan through log.
+func (o *diskOutbox) logRepairs(log FlushLogger) {
+	if log == nil {
+		return
+	}
+	for _, r := range o.repairs {
+		var err error
+		switch r.result {
+		case spoolQuarantined:
+			err = fmt.Errorf("outbox file %q is damaged, moved to %s: %w", r.name, quarantineDir, r.err)
+		case spoolRemoved:
+			err = fmt.Errorf("outbox file %q held no complete event (%d bytes), removed", r.name, r.cut)
+		case spoolRecovered:
+			err = fmt.Errorf("outbox file %q was left unfinished by a crash, recovered %d events", r.name, r.events)
+		default:
+			err = fmt.Errorf("outbox file %q had a partial last line (%d bytes), cut back to %d events", r.name, r.cut, r.events)
+		}
+		if r.err != nil && r.result != spoolQuarantined {
+			err = fmt.Errorf("%w; repair failed: %v", err, r.err)
+		}
+		log(r.events, err)
+	}
+}
@@ -2,15 +2,20 @@ package output
 
 import (
 	"bufio"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
 )
 
 func TestClickHouseOutbox_QueueAndDrain(t *testing.T) {
@@ -103,12 +108,12 @@ func TestDiskOutbox_DropOldestOnOverflow(t *testing.T) {
 			"summary": strings.Repeat("A", 400),
 		},
 	}
-	if dropped, err := ob.enqueue([]map[string]interface{}{large}); err != nil {
+	if dropped, err := ob.enqueue([]event.Event{large}); err != nil {
 		t.Fatal(err)
 	} else if dropped != 0 {
 		t.Fatalf("unexpected initial dropped count: %d", dropped)
 	}
-	if dropped, err := ob.enqueue([]map[string]interface{}{large}); err != nil {
+	if dropped, err := ob.enqueue([]event.Event{large}); err != nil {
 		t.Fatal(err)
 	} else if dropped == 0 {
 		t.Fatal("expected dropping oldest events when queue overflows")
@@ -122,6 +127,55 @@ func TestDiskOutbox_DropOldestOnOverflow(t *testing.T) {
 	}
 }
 
+func TestDiskOutbox_FairEviction(t *testing.T) {
+	line := json.RawMessage(`{"summary":"` + strings.Repeat("A", 85) + `"}`) // 100 bytes with the newline
+	spool := func(ob *diskOutbox, sensor string) {
+		t.Helper()
+		if _, err := ob.enqueueFrom([]json.RawMessage{line}, map[string]int{sensor: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A flooding sensor fills the outbox; the quiet sensor's older file survives.
+	ob, err := newDiskOutbox(t.TempDir(), 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob.fair = true
+	spool(ob, "quiet")
+	for i := 0; i < 8; i++ {
+		spool(ob, "noisy")
+	}
+	if got := ob.eventsBySensor(); got["quiet"] != 1 || got["noisy"] != 4 {
+		t.Errorf("fair: events by sensor = %v, want quiet 1 and noisy 4", got)
+	}
+
+	// Per-sensor quota: a sensor over its share drops its own oldest files, below max_bytes.
+	ob, err = newDiskOutbox(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob.maxSensorBytes = 200
+	spool(ob, "quiet")
+	for i := 0; i < 5; i++ {
+		spool(ob, "noisy")
+	}
+	if got := ob.eventsBySensor(); got["quiet"] != 1 || got["noisy"] != 2 {
+		t.Errorf("quota: events by sensor = %v, want quiet 1 and noisy 2", got)
+	}
+	if _, _, dropped := ob.stats(); dropped != 3 {
+		t.Errorf("quota: dropped = %d, want 3", dropped)
+	}
+}
+
+func TestGroupBySensor(t *testing.T) {
+	batch := []json.RawMessage{json.RawMessage(`{"n":1}`), json.RawMessage(`{"n":2}`), json.RawMessage(`{"n":3}`)}
+	groups := groupBySensor(batch, []string{"b", "a", "b"})
+	if len(groups) != 2 || len(groups[0].events) != 2 || string(groups[0].events[1]) != `{"n":3}` || groups[1].sensors[0] != "a" {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
 func countSpoolFiles(t *testing.T, dir string) int {
 	t.Helper()
 	ents, err := os.ReadDir(dir)
@@ -136,3 +190,219 @@ func countSpoolFiles(t *testing.T, dir string) int {
 	}
 	return n
 }
+
+func TestClickHouseOutbox_ParallelDrain(t *testing.T) {
+	var inFlight, maxInFlight, inserts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		inserts.Add(1)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ob, err := newDiskOutbox(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		if _, err := ob.enqueue([]event.Event{spipStyleEvent()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := newClickHouseWriter(srv.Client(), srv.URL, "default", "loom_events", "", "", nil, OutboxConfig{
+		Enabled:       true,
+		Dir:           dir,
+		DrainWorkers:  4,
+		DrainMaxFiles: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if inserts.Load() != 8 || countSpoolFiles(t, dir) != 4 {
+		t.Errorf("inserts = %d, files left = %d; want 8 and 4", inserts.Load(), countSpoolFiles(t, dir))
+	}
+	if m := maxInFlight.Load(); m < 2 || m > 4 {
+		t.Errorf("max concurrent inserts = %d, want 2..4", m)
+	}
+}
+
+func TestDrainLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newDrainLimiter(100)
+	l.nowFn = func() time.Time { return now }
+	l.last = now
+	if !l.take(60) || l.take(60) {
+		t.Error("second file should exceed the budget of 100 events per second")
+	}
+	now = now.Add(300 * time.Millisecond)
+	if !l.take(60) {
+		t.Error("budget should refill at 100 events per second")
+	}
+	now = now.Add(time.Hour)
+	if !l.take(500) {
+		t.Error("a file larger than one second's budget should pass once the budget is full")
+	}
+	if l.take(1) {
+		t.Error("budget should be spent after the large file")
+	}
+	if newDrainLimiter(0) != nil || !(*drainLimiter)(nil).take(1e6) {
+		t.Error("rate 0 should not limit")
+	}
+}
+
+func TestClickHouseOutbox_DrainPriority(t *testing.T) {
+	for _, tc := range []struct {
+		priority string
+		workers  int
+		want     string
+	}{
+		{"live", 1, "live,old1,old2"},
+		{"fifo", 4, "old1,old2,live"}, // fifo drains one file at a time whatever drain_workers says
+	} {
+		var mu sync.Mutex
+		var order []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var row struct{ Event string }
+			_ = json.NewDecoder(r.Body).Decode(&row)
+			var ev map[string]interface{}
+			_ = json.Unmarshal([]byte(row.Event), &ev)
+			mu.Lock()
+			order = append(order, fmt.Sprint(ev["id"]))
+			mu.Unlock()
+		}))
+
+		dir := t.TempDir()
+		ob, err := newDiskOutbox(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"old1", "old2"} {
+			if _, err := ob.enqueue([]event.Event{{"id": id}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w, err := newClickHouseWriter(srv.Client(), srv.URL, "default", "loom_events", "", "", nil, OutboxConfig{
+			Enabled:       true,
+			Dir:           dir,
+			DrainWorkers:  tc.workers,
+			DrainPriority: tc.priority,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = w.Write(map[string]interface{}{"id": "live"})
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		if got := strings.Join(order, ","); got != tc.want {
+			t.Errorf("%s: inserts = %s, want %s", tc.priority, got, tc.want)
+		}
+		if n := countSpoolFiles(t, dir); n != 0 {
+			t.Errorf("%s: %d files left", tc.priority, n)
+		}
+	}
+}
+
+func TestClickHouseOutbox_OrderedSensors(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var row struct{ Event string }
+		_ = json.NewDecoder(r.Body).Decode(&row)
+		var ev map[string]interface{}
+		_ = json.Unmarshal([]byte(row.Event), &ev)
+		mu.Lock()
+		order = append(order, fmt.Sprint(ev["id"]))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ob, err := newDiskOutbox(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.enqueueFrom([]json.RawMessage{json.RawMessage(`{"id":"old-ordered"}`)}, map[string]int{"ordered": 1}); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newClickHouseWriter(srv.Client(), srv.URL, "default", "loom_events", "", "", nil, OutboxConfig{Enabled: true, Dir: dir, DrainWorkers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.orderSensors([]string{"ordered"})
+
+	// Other sensors' batches still go ahead of the backlog ...
+	_ = w.WriteFrom("other", map[string]interface{}{"id": "live-other"})
+	if err := w.flushBuf(); err != nil {
+		t.Fatal(err)
+	}
+	// ... but a batch with the ordered sensor's events waits for its older events.
+	_ = w.WriteFrom("ordered", map[string]interface{}{"id": "live-ordered"})
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, ","); got != "live-other,old-ordered,live-ordered" {
+		t.Errorf("inserts = %s", got)
+	}
+	if w.drainWorkers != 1 {
+		t.Errorf("drain workers = %d, want 1 with ordered sensors", w.drainWorkers)
+	}
+}
+
+func TestDiskOutbox_StartupScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("01-intact.ndjson", "{\"a\":1}\n{\"a\":2}\n")
+	write("02-partial.ndjson", "{\"a\":1}\n{\"a\":2}\n{\"a\":")
+	write("03-zeroed.ndjson", "{\"a\":1}\n\x00\x00\x00\x00")
+	write("04-nothing.ndjson", "{\"a\"")
+	write("05-corrupt.ndjson", "{\"a\":1}\nnot json\n{\"a\":3}\n")
+	write("06-unrenamed.ndjson.tmp", "{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n")
+
+	ob, err := newDiskOutbox(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	events := 0
+	for _, f := range ob.oldest(10) {
+		names = append(names, f.name)
+		events += f.events
+		if _, err := readBatchFile(f.path); err != nil {
+			t.Errorf("%s after the scan: %v", f.name, err)
+		}
+	}
+	if got := strings.Join(names, ","); got != "01-intact.ndjson,02-partial.ndjson,03-zeroed.ndjson,06-unrenamed.ndjson" || events != 8 {
+		t.Errorf("spooled files = %s with %d events", got, events)
+	}
+	if _, err := os.Stat(filepath.Join(dir, quarantineDir, "05-corrupt.ndjson")); err != nil {
+		t.Errorf("corrupt file not quarantined: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "04-nothing.ndjson")); !os.IsNotExist(err) {
+		t.Errorf("file without a complete event was not removed: %v", err)
+	}
+	if repaired, quarantined := ob.scanStats(); repaired != 4 || quarantined != 1 {
+		t.Errorf("repaired = %d, quarantined = %d; want 4 and 1", repaired, quarantined)
+	}
+	var logged []string
+	ob.logRepairs(func(_ int, err error) { logged = append(logged, err.Error()) })
+	if len(logged) != 5 || !strings.Contains(logged[0], "02-partial.ndjson") || !strings.Contains(logged[0], "cut back to 2 events") {
+		t.Errorf("logged = %q", logged)
+	}
+}
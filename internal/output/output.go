@@ -3,28 +3,116 @@ package output
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
 )
 
 // Writer emits one enriched ECS document per event to a configured destination.
 type Writer interface {
-	Write(event map[string]interface{}) error
+	Write(event event.Event) error
 	Flush() error
 	Close() error
+	// Health checks that the destination is reachable and any outbox is below its ready threshold.
+	Health(ctx context.Context) error
+}
+
+type sensorWriter interface {
+	WriteFrom(sensorID string, event event.Event) error
+}
+
+// WriteFrom writes an event received from sensorID. Writers with an outbox use the sensor ID to
+// report each sensor's share of the spool (see OutboxEventsBySensor); others just Write.
+func WriteFrom(w Writer, sensorID string, event event.Event) error {
+	if sw, ok := w.(sensorWriter); ok {
+		return sw.WriteFrom(sensorID, event)
+	}
+	return w.Write(event)
+}
+
+type rawWriter interface {
+	WriteRaw(sensorID string, raw json.RawMessage) error
+}
+
+// WriteRaw writes raw, one event as a compact JSON object received from sensorID, as is (passthrough
+// mode). Writers that support it buffer and send the bytes without decoding them; for others the
+// event is decoded and written with WriteFrom. raw must not be modified afterwards.
+func WriteRaw(w Writer, sensorID string, raw json.RawMessage) error {
+	if rw, ok := w.(rawWriter); ok {
+		return rw.WriteRaw(sensorID, raw)
+	}
+	var ev event.Event
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return err
+	}
+	return WriteFrom(w, sensorID, ev)
+}
+
+// bufferPool recycles the request bodies of flushes. A body goes back only after its response is
+// closed: until then the transport may still be sending it.
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= 64<<20 { // let the odd huge batch go rather than pin its memory
+		bufferPool.Put(buf)
+	}
 }
 
 // FlushLogger is called after each ClickHouse flush (rows written, or err if failed).
 // Used for logging; may be nil.
 type FlushLogger func(rows int, err error)
 
+// RequestIDField holds the ID of the ingest request an event arrived in, when
+// observability.event_request_id is on. Errors for failed flushes then name those requests.
+const RequestIDField = "loom.request_id"
+
+// withRequestIDs adds the distinct request IDs of batch (RequestIDField) to err, so a failed flush
+// can be traced to the ingest requests and sensors it held; at most 10 are listed.
+func withRequestIDs(err error, batch []json.RawMessage) error {
+	const maxIDs = 10
+	var ids []string
+	seen := make(map[string]bool)
+	for _, raw := range batch {
+		var ev struct { // just RequestIDField
+			Loom struct {
+				RequestID string `json:"request_id"`
+			} `json:"loom"`
+		}
+		_ = json.Unmarshal(raw, &ev)
+		id := ev.Loom.RequestID
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	switch {
+	case len(ids) == 0:
+		return err
+	case len(ids) > maxIDs:
+		return fmt.Errorf("%w (request_ids=%s and %d more)", err, strings.Join(ids[:maxIDs], ","), len(ids)-maxIDs)
+	}
+	return fmt.Errorf("%w (request_ids=%s)", err, strings.Join(ids, ","))
+}
+
 // OutboxConfig controls local disk spooling for failed ClickHouse writes.
 type OutboxConfig struct {
 	Enabled         bool
@@ -33,6 +121,22 @@ type OutboxConfig struct {
 	MaxBatchSize    int
 	RetryBackoff    time.Duration
 	RetryMaxBackoff time.Duration
+	// ReadyMaxBytes > 0 reports the writer unhealthy while the outbox holds more than this.
+	ReadyMaxBytes int64
+	// Draining replays up to DrainMaxFiles files per flush (default 10) with DrainWorkers concurrent
+	// inserts (default 1), and at most DrainMaxEventsPerSecond events per second (0 = unlimited).
+	DrainWorkers            int
+	DrainMaxFiles           int
+	DrainMaxEventsPerSecond int
+	// DrainPriority "live" (default) inserts live batches at once, ahead of the backlog. "fifo" queues
+	// them behind it while the outbox is not empty, so ClickHouse receives batches in arrival order;
+	// the drain then runs one insert at a time and without the rate cap.
+	DrainPriority string
+	// Eviction "oldest" (default) drops the oldest files when the outbox is over MaxBytes; "fair"
+	// drops the oldest files of the sensor holding the most bytes. MaxBytesPerSensor > 0 drops a
+	// sensor's oldest files while it holds more than this. With either, each file holds one sensor.
+	Eviction          string
+	MaxBytesPerSensor int64
 }
 
 // WriterConfig holds all output backend options; only fields for the chosen type are used.
@@ -47,12 +151,78 @@ type WriterConfig struct {
 	ClickHouseTable    string
 	ClickHouseUser     string
 	ClickHousePassword string
-	ClickHouseFlushLog FlushLogger // optional: log each flush (success or failure)
 	ClickHouseOutbox   OutboxConfig
 	SkipClickHousePing bool // if true, skip startup connection check (for tests)
+
+	// FlushLog, if set, is called after each flush of a buffering writer (ClickHouse, forward,
+	// Event Hubs, Pub/Sub, SQLite) with the number of events and the result.
+	FlushLog FlushLogger
+
+	// ElasticsearchMaxBulkBytes splits bulk requests so that none is larger than this (a single
+	// larger event is sent alone); default 10 MiB, below Elasticsearch's http.max_content_length.
+	ElasticsearchMaxBulkBytes int64
+
+	// ClickHouseFlatten adds every leaf of an event to its JSONEachRow row under its dotted path
+	// ("source.geo.country_iso_code"), next to the event column, for tables with such columns.
+	ClickHouseFlatten bool
+
+	// Proxy for the destination's HTTP client: "" honors HTTPS_PROXY/HTTP_PROXY, "direct" does
+	// not, or an http, https, socks5 or socks5h URL (see the proxy package).
+	Proxy string
+
+	// HTTP tunes the destination's HTTP client: connection pool, timeouts and ping retries.
+	HTTP HTTPConfig
+
+	// OrderedSensors have ordered delivery: ClickHouse receives their events in the order written,
+	// across failed inserts and the outbox (see clickHouseWriter.orderSensors).
+	OrderedSensors []string
+
+	// Forward sends events to another Loom's ingest endpoint; ClickHouseOutbox configures its spool.
+	ForwardURL      string
+	ForwardToken    string
+	ForwardSensorID string
+	ForwardGzip     bool
+	ForwardCAFile   string // PEM CA bundle for the upstream's certificate; system roots when empty
+
+	// GELF sends events to a Graylog GELF input at GELFURL (udp://, tcp://, http:// or https://).
+	// GELFCompression is "gzip" (default for UDP), "zlib" or "none"; GELFChunkSize bounds UDP
+	// datagrams (default 1420); GELFHost is the host field (default the hostname).
+	GELFURL         string
+	GELFHost        string
+	GELFCompression string
+	GELFChunkSize   int
+
+	// Event Hubs sends events to EventHubsName in EventHubsNamespace over its Kafka endpoint,
+	// authenticated with a SAS EventHubsConnectionString (which may also name both) or an Azure
+	// AD application (tenant ID, client ID and secret).
+	EventHubsNamespace        string
+	EventHubsName             string
+	EventHubsConnectionString string
+	EventHubsTenantID         string
+	EventHubsClientID         string
+	EventHubsClientSecret     string
+
+	// Pub/Sub publishes events to PubSubTopic in PubSubProject, with the sensor ID as ordering key
+	// when PubSubOrdering is set. PubSubCredentialsFile is a service account JSON key; without one
+	// the metadata server's service account is used. PubSubEndpoint defaults to the global one.
+	PubSubProject         string
+	PubSubTopic           string
+	PubSubOrdering        bool
+	PubSubCredentialsFile string
+	PubSubEndpoint        string
+
+	// UnixSocket is the path of a unix datagram socket a local consumer has bound; each event is
+	// sent to it as an NDJSON line, and dropped when nobody receives it.
+	UnixSocket string
+
+	// SQLitePath is the database file of the embedded SQLite output; when its events take more than
+	// SQLiteMaxBytes (default 1 GiB, negative for no limit), the oldest are deleted.
+	SQLitePath     string
+	SQLiteMaxBytes int64
 }
 
-// NewWriter creates a Writer from config. Type: "stdout", "elasticsearch", "clickhouse".
+// NewWriter creates a Writer from config. Type: "stdout", "elasticsearch", "clickhouse", "forward",
+// "gelf", "eventhubs", "pubsub", "unix", "sqlite".
 func NewWriter(cfg WriterConfig) (Writer, error) {
 	switch cfg.Type {
 	case "stdout":
@@ -65,15 +235,29 @@ func NewWriter(cfg WriterConfig) (Writer, error) {
 		if idx == "" {
 			idx = "loom-events"
 		}
-		client := &http.Client{Timeout: 30 * time.Second}
+		maxBytes := cfg.ElasticsearchMaxBulkBytes
+		if maxBytes <= 0 {
+			maxBytes = defaultESMaxBulkBytes
+		}
+		transport, err := httpTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: cfg.HTTP.requestTimeout(), Transport: transport}
+		meta, _ := json.Marshal(map[string]interface{}{"index": map[string]interface{}{"_index": idx}})
 		return &esWriter{
-			client: client,
-			url:    strings.TrimSuffix(cfg.ElasticsearchURL, "/") + "/_bulk",
-			index:  idx,
-			user:   cfg.ElasticsearchUser,
-			pass:   cfg.ElasticsearchPass,
-			buf:    make([]map[string]interface{}, 0, 100),
-			flush:  100,
+			client:   client,
+			baseURL:  strings.TrimSuffix(cfg.ElasticsearchURL, "/"),
+			url:      strings.TrimSuffix(cfg.ElasticsearchURL, "/") + "/_bulk",
+			index:    idx,
+			user:     cfg.ElasticsearchUser,
+			pass:     cfg.ElasticsearchPass,
+			meta:     append(meta, '\n'),
+			buf:      make([]json.RawMessage, 0, 100),
+			flush:    100,
+			maxBytes: maxBytes,
+
+			pingAttempts: cfg.HTTP.PingAttempts,
 		}, nil
 	case "clickhouse":
 		if cfg.ClickHouseURL == "" {
@@ -87,22 +271,54 @@ func NewWriter(cfg WriterConfig) (Writer, error) {
 		if tbl == "" {
 			tbl = "loom_events"
 		}
-		client := &http.Client{Timeout: 30 * time.Second}
+		transport, err := httpTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: cfg.HTTP.requestTimeout(), Transport: transport}
 		if !cfg.SkipClickHousePing {
-			if err := pingClickHouse(client, cfg.ClickHouseURL, cfg.ClickHouseUser, cfg.ClickHousePassword); err != nil {
+			err := retryPing(context.Background(), cfg.HTTP.PingAttempts, func(ctx context.Context) error {
+				return pingClickHouse(ctx, client, cfg.ClickHouseURL, cfg.ClickHouseUser, cfg.ClickHousePassword)
+			})
+			if err != nil {
 				return nil, fmt.Errorf("clickhouse connection check failed: %w", err)
 			}
 		}
-		return newClickHouseWriter(
+		w, err := newClickHouseWriter(
 			client,
 			cfg.ClickHouseURL,
 			db,
 			tbl,
 			cfg.ClickHouseUser,
 			cfg.ClickHousePassword,
-			cfg.ClickHouseFlushLog,
+			cfg.FlushLog,
 			cfg.ClickHouseOutbox,
 		)
+		if err != nil {
+			return nil, err
+		}
+		w.orderSensors(cfg.OrderedSensors)
+		w.flatten = cfg.ClickHouseFlatten
+		w.pingAttempts = cfg.HTTP.PingAttempts
+		return w, nil
+	case "forward":
+		if cfg.ForwardURL == "" || cfg.ForwardToken == "" {
+			return nil, fmt.Errorf("forward_url and forward_token required")
+		}
+		return newForwardWriter(cfg)
+	case "gelf":
+		if cfg.GELFURL == "" {
+			return nil, fmt.Errorf("gelf_url required")
+		}
+		return newGELFWriter(cfg)
+	case "eventhubs":
+		return newEventHubsWriter(cfg)
+	case "pubsub":
+		return newPubSubWriter(cfg)
+	case "unix":
+		return newUnixWriter(cfg)
+	case "sqlite":
+		return newSQLiteWriter(cfg)
 	default:
 		return nil, fmt.Errorf("unknown output type: %s", cfg.Type)
 	}
@@ -113,7 +329,7 @@ type stdoutWriter struct {
 	w  *bufio.Writer
 }
 
-func (s *stdoutWriter) Write(event map[string]interface{}) error {
+func (s *stdoutWriter) Write(event event.Event) error {
 	if event == nil {
 		return nil
 	}
@@ -129,6 +345,18 @@ func (s *stdoutWriter) Write(event map[string]interface{}) error {
 	return s.w.Flush()
 }
 
+func (s *stdoutWriter) WriteRaw(_ string, raw json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(raw); err != nil {
+		return err
+	}
+	if err := s.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
 func (s *stdoutWriter) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -141,24 +369,45 @@ func (s *stdoutWriter) Flush() error {
 	return s.w.Flush()
 }
 
+// defaultESMaxBulkBytes bounds a bulk request when WriterConfig.ElasticsearchMaxBulkBytes is unset.
+const defaultESMaxBulkBytes = 10 << 20
+
 type esWriter struct {
-	client *http.Client
-	url    string
-	index  string
-	user   string
-	pass   string
-	mu     sync.Mutex
-	buf    []map[string]interface{}
-	flush  int
+	client   *http.Client
+	baseURL  string
+	url      string
+	index    string
+	user     string
+	pass     string
+	meta     []byte // bulk action line, with its newline
+	mu       sync.Mutex
+	buf      []json.RawMessage // encoded events
+	bufBytes int64             // bulk body size of buf
+	flush    int
+	maxBytes int64
+	barrier  flushBarrier // see Sync
+
+	pingAttempts int // see retryPing
+
+	flushOK, flushFailed atomic.Uint64
 }
 
-func (e *esWriter) Write(event map[string]interface{}) error {
+func (e *esWriter) Write(event event.Event) error {
 	if event == nil {
 		return nil
 	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return e.WriteRaw("", b)
+}
+
+func (e *esWriter) WriteRaw(_ string, raw json.RawMessage) error {
 	e.mu.Lock()
-	e.buf = append(e.buf, event)
-	shouldFlush := len(e.buf) >= e.flush
+	e.buf = append(e.buf, raw)
+	e.bufBytes += int64(len(e.meta) + len(raw) + 1)
+	shouldFlush := len(e.buf) >= e.flush || e.bufBytes >= e.maxBytes
 	e.mu.Unlock()
 	if shouldFlush {
 		return e.flushBuf()
@@ -166,47 +415,106 @@ func (e *esWriter) Write(event map[string]interface{}) error {
 	return nil
 }
 
-func (e *esWriter) flushBuf() error {
+// flushBuf sends the buffered events in bulk requests of at most maxBytes each. The requests are
+// streamed from the encoded events rather than copied into one body. A failed request does not
+// stop the others; the errors are joined.
+func (e *esWriter) flushBuf() (err error) {
+	done := e.barrier.start()
+	defer func() { done(err != nil) }()
 	e.mu.Lock()
 	if len(e.buf) == 0 {
 		e.mu.Unlock()
 		return nil
 	}
 	batch := e.buf
-	e.buf = make([]map[string]interface{}, 0, e.flush)
+	e.buf = make([]json.RawMessage, 0, e.flush)
+	e.bufBytes = 0
 	e.mu.Unlock()
 
-	var ndjson bytes.Buffer
-	for _, ev := range batch {
-		// Bulk action: index to index
-		meta := map[string]interface{}{"index": map[string]interface{}{"_index": e.index}}
-		metaB, _ := json.Marshal(meta)
-		ndjson.Write(metaB)
-		ndjson.WriteByte('\n')
-		docB, _ := json.Marshal(ev)
-		ndjson.Write(docB)
-		ndjson.WriteByte('\n')
-	}
-	req, err := http.NewRequest(http.MethodPost, e.url, &ndjson)
+	var errs []error
+	for len(batch) > 0 {
+		n, size := 0, int64(0)
+		for n < len(batch) {
+			line := int64(len(e.meta) + len(batch[n]) + 1)
+			if n > 0 && size+line > e.maxBytes {
+				break
+			}
+			size += line
+			n++
+		}
+		if err := e.bulk(batch[:n], size); err != nil {
+			errs = append(errs, err)
+		}
+		batch = batch[n:]
+	}
+	return errors.Join(errs...)
+}
+
+// bulk sends one bulk request of docs, whose body is size bytes.
+func (e *esWriter) bulk(docs []json.RawMessage, size int64) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, &bulkBody{meta: e.meta, docs: docs})
 	if err != nil {
 		return err
 	}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(&bulkBody{meta: e.meta, docs: docs}), nil
+	}
 	req.Header.Set("Content-Type", "application/x-ndjson")
 	if e.user != "" && e.pass != "" {
 		req.SetBasicAuth(e.user, e.pass)
 	}
 	resp, err := e.client.Do(req)
 	if err != nil {
-		return err
+		e.flushFailed.Add(1)
+		return withRequestIDs(err, docs)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		e.flushFailed.Add(1)
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("elasticsearch bulk %d: %s", resp.StatusCode, string(body))
+		return withRequestIDs(fmt.Errorf("elasticsearch bulk %d: %s", resp.StatusCode, string(body)), docs)
 	}
+	e.flushOK.Add(1)
 	return nil
 }
 
+// bulkBody reads as the NDJSON of a bulk request: the action line meta, then each document and a
+// newline.
+type bulkBody struct {
+	meta []byte
+	docs []json.RawMessage
+	off  int // offset in the current line: meta, docs[0], "\n"
+}
+
+func (b *bulkBody) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(b.docs) == 0 {
+			if n == 0 {
+				return 0, io.EOF
+			}
+			break
+		}
+		doc := b.docs[0]
+		switch {
+		case b.off < len(b.meta):
+			c := copy(p[n:], b.meta[b.off:])
+			n += c
+			b.off += c
+		case b.off < len(b.meta)+len(doc):
+			c := copy(p[n:], doc[b.off-len(b.meta):])
+			n += c
+			b.off += c
+		default:
+			p[n] = '\n'
+			n++
+			b.docs, b.off = b.docs[1:], 0
+		}
+	}
+	return n, nil
+}
+
 func (e *esWriter) Flush() error {
 	return e.flushBuf()
 }
@@ -216,9 +524,9 @@ func (e *esWriter) Close() error {
 }
 
 // pingClickHouse runs SELECT 1 against the server to verify connectivity and auth.
-func pingClickHouse(client *http.Client, baseURL, user, pass string) error {
+func pingClickHouse(ctx context.Context, client *http.Client, baseURL, user, pass string) error {
 	url := strings.TrimSuffix(baseURL, "/") + "/?query=" + url.QueryEscape("SELECT 1")
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
@@ -232,7 +540,7 @@ func pingClickHouse(client *http.Client, baseURL, user, pass string) error {
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("ping %d: %s", resp.StatusCode, string(body))
+		return &pingStatusError{status: resp.StatusCode, msg: fmt.Sprintf("ping %d: %s", resp.StatusCode, string(body))}
 	}
 	return nil
 }
@@ -250,13 +558,29 @@ type clickHouseWriter struct {
 	outbox   *diskOutbox
 
 	mu              sync.Mutex
-	buf             []map[string]interface{}
+	buf             []json.RawMessage // encoded events
+	bufSensors      []string          // sensor ID per buffered event, for outbox attribution
 	flush           int
 	retryBackoff    time.Duration
 	retryMax        time.Duration
-	nextRetryAt     time.Time
-	currentBackoff  time.Duration
 	outboxBatchSize int
+	drainWorkers    int
+	drainFiles      int
+	drainRate       *drainLimiter
+	fifo            bool            // live batches queue behind the outbox (DrainPriority "fifo")
+	ordered         map[string]bool // sensors with ordered delivery
+	flatten         bool            // add dotted columns to each row (see flattenRow)
+	orderMu         sync.Mutex      // held for a whole flushBuf when ordered is not empty
+	readyMaxBytes   int64
+	pingAttempts    int // see retryPing
+
+	drainMu        sync.Mutex // held while draining; guards nextRetryAt and currentBackoff
+	nextRetryAt    time.Time
+	currentBackoff time.Duration
+
+	barrier flushBarrier // see Sync
+
+	flushOK, flushFailed atomic.Uint64
 }
 
 func newClickHouseWriter(
@@ -277,12 +601,17 @@ func newClickHouseWriter(
 		user:            user,
 		pass:            pass,
 		flushLog:        flushLog,
-		buf:             make([]map[string]interface{}, 0, 100),
+		buf:             make([]json.RawMessage, 0, 100),
 		flush:           100,
 		retryBackoff:    outboxCfg.RetryBackoff,
 		retryMax:        outboxCfg.RetryMaxBackoff,
 		currentBackoff:  outboxCfg.RetryBackoff,
 		outboxBatchSize: outboxCfg.MaxBatchSize,
+		drainWorkers:    outboxCfg.DrainWorkers,
+		drainFiles:      outboxCfg.DrainMaxFiles,
+		drainRate:       newDrainLimiter(outboxCfg.DrainMaxEventsPerSecond),
+		fifo:            outboxCfg.Enabled && outboxCfg.DrainPriority == "fifo",
+		readyMaxBytes:   outboxCfg.ReadyMaxBytes,
 	}
 	if w.retryBackoff <= 0 {
 		w.retryBackoff = time.Second
@@ -294,22 +623,62 @@ func newClickHouseWriter(
 	if w.outboxBatchSize <= 0 {
 		w.outboxBatchSize = w.flush
 	}
+	if w.drainWorkers <= 0 {
+		w.drainWorkers = 1
+	}
+	if w.drainFiles <= 0 {
+		w.drainFiles = 10
+	}
+	if w.fifo {
+		w.drainWorkers, w.drainRate = 1, nil
+	}
 	if outboxCfg.Enabled {
 		ob, err := newDiskOutbox(outboxCfg.Dir, outboxCfg.MaxBytes)
 		if err != nil {
 			return nil, err
 		}
+		ob.fair, ob.maxSensorBytes = outboxCfg.Eviction == "fair", outboxCfg.MaxBytesPerSensor
 		w.outbox = ob
+		ob.logRepairs(flushLog)
 	}
 	return w, nil
 }
 
-func (c *clickHouseWriter) Write(event map[string]interface{}) error {
+// orderSensors turns on ordered delivery for sensorIDs. Their events leave in the order written:
+// buffer flushes run one at a time, a batch with their events queues behind outbox files that hold
+// any (or files of unknown origin), and the outbox drains one file at a time without the rate cap.
+func (c *clickHouseWriter) orderSensors(sensorIDs []string) {
+	if len(sensorIDs) == 0 {
+		return
+	}
+	c.ordered = make(map[string]bool, len(sensorIDs))
+	for _, id := range sensorIDs {
+		c.ordered[id] = true
+	}
+	c.drainWorkers, c.drainRate = 1, nil
+}
+
+func (c *clickHouseWriter) Write(event event.Event) error {
+	return c.WriteFrom("", event)
+}
+
+// WriteFrom buffers event like Write and attributes it to sensorID if it ends up in the outbox.
+func (c *clickHouseWriter) WriteFrom(sensorID string, event event.Event) error {
 	if event == nil {
 		return nil
 	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return c.WriteRaw(sensorID, b)
+}
+
+// WriteRaw buffers an encoded event from sensorID.
+func (c *clickHouseWriter) WriteRaw(sensorID string, raw json.RawMessage) error {
 	c.mu.Lock()
-	c.buf = append(c.buf, event)
+	c.buf = append(c.buf, raw)
+	c.bufSensors = append(c.bufSensors, sensorID)
 	shouldFlush := len(c.buf) >= c.flush
 	c.mu.Unlock()
 	if shouldFlush {
@@ -325,37 +694,51 @@ func (c *clickHouseWriter) Flush() error {
 	return c.drainOutbox()
 }
 
-func (c *clickHouseWriter) flushBuf() error {
+func (c *clickHouseWriter) flushBuf() (err error) {
+	done := c.barrier.start()
+	defer func() { done(err != nil) }()
+	if len(c.ordered) > 0 {
+		c.orderMu.Lock()
+		defer c.orderMu.Unlock()
+	}
 	c.mu.Lock()
 	if len(c.buf) == 0 {
 		c.mu.Unlock()
 		return nil
 	}
-	batch := c.buf
-	c.buf = make([]map[string]interface{}, 0, c.flush)
+	batch, sensors := c.buf, c.bufSensors
+	c.buf = make([]json.RawMessage, 0, c.flush)
+	c.bufSensors = make([]string, 0, c.flush)
 	c.mu.Unlock()
+	if c.queueBehindOutbox(sensors) {
+		// Queue behind the backlog; the drain that follows sends it in order.
+		if _, err := c.spool(batch, sensors); err != nil {
+			if c.flushLog != nil {
+				c.flushLog(len(batch), fmt.Errorf("outbox enqueue failed: %w", err))
+			}
+			return err
+		}
+		return nil
+	}
 	if err := c.insertBatch(batch); err != nil {
 		if c.outbox != nil {
-			dropped := 0
-			for _, chunk := range splitBatches(batch, c.outboxBatchSize) {
-				d, qerr := c.outbox.enqueue(chunk)
-				dropped += d
-				if qerr != nil {
-					if c.flushLog != nil {
-						c.flushLog(len(batch), fmt.Errorf("clickhouse insert failed and outbox enqueue failed: %w (insert err: %v)", qerr, err))
-					}
-					return qerr
+			dropped, qerr := c.spool(batch, sensors)
+			if qerr != nil {
+				if c.flushLog != nil {
+					c.flushLog(len(batch), withRequestIDs(fmt.Errorf("clickhouse insert failed and outbox enqueue failed: %w (insert err: %v)", qerr, err), batch))
 				}
+				return qerr
 			}
 			if c.flushLog != nil {
 				files, bytes, _ := c.outbox.stats()
 				c.flushLog(
 					len(batch),
-					fmt.Errorf("clickhouse insert failed; queued to outbox (dropped_oldest_events=%d queue_files=%d queue_bytes=%d): %w", dropped, files, bytes, err),
+					withRequestIDs(fmt.Errorf("clickhouse insert failed; queued to outbox (dropped_oldest_events=%d queue_files=%d queue_bytes=%d): %w", dropped, files, bytes, err), batch),
 				)
 			}
 			return nil
 		}
+		err = withRequestIDs(err, batch)
 		if c.flushLog != nil {
 			c.flushLog(len(batch), err)
 		}
@@ -367,21 +750,88 @@ func (c *clickHouseWriter) flushBuf() error {
 	return nil
 }
 
-func (c *clickHouseWriter) insertBatch(batch []map[string]interface{}) error {
-	var body bytes.Buffer
-	for _, ev := range batch {
-		eventJSON, err := json.Marshal(ev)
+// queueBehindOutbox reports whether a batch from sensors must go to the outbox rather than be
+// inserted ahead of the files already there (DrainPriority "fifo" or ordered delivery).
+func (c *clickHouseWriter) queueBehindOutbox(sensors []string) bool {
+	if c.outbox == nil {
+		return false
+	}
+	if c.fifo {
+		files, _, _ := c.outbox.stats()
+		return files > 0
+	}
+	if len(c.ordered) == 0 {
+		return false
+	}
+	batchOrdered := make(map[string]bool)
+	for _, id := range sensors {
+		if c.ordered[id] {
+			batchOrdered[id] = true
+		}
+	}
+	return len(batchOrdered) > 0 && c.outbox.holdsAny(batchOrdered)
+}
+
+// spool writes batch to the outbox in files of at most outboxBatchSize events. With per-sensor
+// eviction each sensor's events go to their own files, in order.
+func (c *clickHouseWriter) spool(batch []json.RawMessage, sensors []string) (dropped int, err error) {
+	if c.outbox.perSensor() {
+		for _, g := range groupBySensor(batch, sensors) {
+			d, err := c.spoolChunks(g.events, g.sensors)
+			dropped += d
+			if err != nil {
+				return dropped, err
+			}
+		}
+		return dropped, nil
+	}
+	return c.spoolChunks(batch, sensors)
+}
+
+func (c *clickHouseWriter) spoolChunks(batch []json.RawMessage, sensors []string) (dropped int, err error) {
+	off := 0
+	for _, chunk := range splitBatches(batch, c.outboxBatchSize) {
+		d, err := c.outbox.enqueueFrom(chunk, countSensors(sensors[off:off+len(chunk)]))
+		off += len(chunk)
+		dropped += d
 		if err != nil {
-			return err
+			return dropped, err
 		}
-		row := map[string]string{"event": string(eventJSON)}
-		rowJSON, _ := json.Marshal(row)
-		body.Write(rowJSON)
-		body.WriteByte('\n')
+	}
+	return dropped, nil
+}
+
+// insertBatch sends batch and counts the outcome for Stats.
+func (c *clickHouseWriter) insertBatch(batch []json.RawMessage) error {
+	if err := c.doInsert(batch); err != nil {
+		c.flushFailed.Add(1)
+		return err
+	}
+	c.flushOK.Add(1)
+	return nil
+}
+
+func (c *clickHouseWriter) doInsert(batch []json.RawMessage) error {
+	body := getBuffer()
+	for _, raw := range batch {
+		if c.flatten {
+			flattenRow(body, raw)
+			continue
+		}
+		// Each row is {"event":"<the event as a JSON string>"}
+		eventJSON, _ := json.Marshal(string(raw))
+		body.WriteString(`{"event":`)
+		body.Write(eventJSON)
+		body.WriteString("}\n")
 	}
 	query := fmt.Sprintf("INSERT INTO %s.%s (event) FORMAT JSONEachRow", c.db, c.table)
 	reqURL := c.url + "/?query=" + url.QueryEscape(query)
-	req, err := http.NewRequest(http.MethodPost, reqURL, &body)
+	if c.flatten {
+		// The table has the columns it wants; the others are skipped.
+		query = fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", c.db, c.table)
+		reqURL = c.url + "/?input_format_skip_unknown_fields=1&query=" + url.QueryEscape(query)
+	}
+	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body.Bytes()))
 	if err != nil {
 		return err
 	}
@@ -391,9 +841,12 @@ func (c *clickHouseWriter) insertBatch(batch []map[string]interface{}) error {
 	}
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return err
+		return err // the transport may still hold body: leave it to the GC
 	}
-	defer resp.Body.Close()
+	defer func() {
+		resp.Body.Close()
+		putBuffer(body) // the request is done with once its response is closed
+	}()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		respBody, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("clickhouse insert %d: %s", resp.StatusCode, string(respBody))
@@ -401,54 +854,210 @@ func (c *clickHouseWriter) insertBatch(batch []map[string]interface{}) error {
 	return nil
 }
 
+// flattenRow writes the JSONEachRow row of raw with the event column and a column per leaf of the
+// event under its dotted path: {"event":"...","@timestamp":"...","source.ip":"...",
+// "source.geo.country_iso_code":"NL",...}. Arrays are leaves. Numbers keep their encoding. A row
+// that does not decode as an object gets the event column only.
+func flattenRow(body *bytes.Buffer, raw json.RawMessage) {
+	eventJSON, _ := json.Marshal(string(raw))
+	body.WriteString(`{"event":`)
+	body.Write(eventJSON)
+	var ev map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if dec.Decode(&ev) == nil {
+		cols := make(map[string]interface{})
+		flattenInto(cols, "", ev)
+		delete(cols, "event") // the event column
+		if b, err := json.Marshal(cols); err == nil && len(b) > 2 {
+			body.WriteByte(',')
+			body.Write(b[1:]) // without the opening brace
+			body.WriteByte('\n')
+			return
+		}
+	}
+	body.WriteString("}\n")
+}
+
+func flattenInto(cols map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+			flattenInto(cols, k, sub)
+			continue
+		}
+		cols[k] = v
+	}
+}
+
+// drainOutbox replays the oldest outbox files: up to drainFiles per call, drainWorkers at a time,
+// within the drain rate. A failed insert stops the drain and backs off. Only one drain runs at a
+// time; a flush that finds one in progress skips it rather than wait.
 func (c *clickHouseWriter) drainOutbox() error {
 	if c.outbox == nil {
 		return nil
 	}
+	if !c.drainMu.TryLock() {
+		return nil
+	}
+	defer c.drainMu.Unlock()
 	if !c.nextRetryAt.IsZero() && time.Now().Before(c.nextRetryAt) {
 		return nil
 	}
-	for i := 0; i < 10; i++ {
-		meta, ok := c.outbox.oldestMeta()
-		if !ok {
-			c.currentBackoff = c.retryBackoff
-			c.nextRetryAt = time.Time{}
-			return nil
-		}
-		batch, err := readBatchFile(meta.path)
-		if err != nil {
-			_ = c.outbox.removeByName(meta.name)
-			if c.flushLog != nil {
-				c.flushLog(meta.events, fmt.Errorf("outbox file unreadable, dropped batch %q: %w", meta.name, err))
+	files := c.outbox.oldest(c.drainFiles)
+	if len(files) == 0 {
+		c.currentBackoff = c.retryBackoff
+		c.nextRetryAt = time.Time{}
+		return nil
+	}
+
+	var (
+		next   atomic.Int64
+		failed atomic.Bool
+		wg     sync.WaitGroup
+	)
+	workers := c.drainWorkers
+	if workers > len(files) {
+		workers = len(files)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !failed.Load() {
+				j := int(next.Add(1)) - 1
+				if j >= len(files) || !c.drainRate.take(files[j].events) {
+					return
+				}
+				if !c.drainFile(files[j]) {
+					failed.Store(true)
+				}
 			}
-			continue
+		}()
+	}
+	wg.Wait()
+	if failed.Load() {
+		c.nextRetryAt = time.Now().Add(c.currentBackoff)
+		c.currentBackoff *= 2
+		if c.currentBackoff > c.retryMax {
+			c.currentBackoff = c.retryMax
 		}
-		if err := c.insertBatch(batch); err != nil {
-			if c.flushLog != nil {
-				c.flushLog(len(batch), fmt.Errorf("outbox drain failed: %w", err))
-			}
-			c.nextRetryAt = time.Now().Add(c.currentBackoff)
-			c.currentBackoff *= 2
-			if c.currentBackoff > c.retryMax {
-				c.currentBackoff = c.retryMax
-			}
-			return nil
+	}
+	return nil
+}
+
+// drainFile inserts one outbox file and removes it. It returns false if the insert failed; the file
+// is then kept for the next attempt. A file that cannot be read is quarantined.
+func (c *clickHouseWriter) drainFile(meta spoolFileMeta) bool {
+	batch, err := readBatchFile(meta.path)
+	if errors.Is(err, fs.ErrNotExist) { // gone: dropped by max_bytes meanwhile
+		_ = c.outbox.removeByName(meta.name)
+		return true
+	}
+	if err != nil {
+		if qerr := c.outbox.quarantine(meta.name); qerr != nil {
+			err = fmt.Errorf("%w; moving it to %s failed: %v", err, quarantineDir, qerr)
 		}
-		if err := c.outbox.removeByName(meta.name); err != nil && c.flushLog != nil {
-			c.flushLog(len(batch), fmt.Errorf("outbox drain delete failed: %w", err))
+		if c.flushLog != nil {
+			c.flushLog(meta.events, fmt.Errorf("outbox file unreadable, moved batch %q to %s: %w", meta.name, quarantineDir, err))
 		}
+		return true
+	}
+	if err := c.insertBatch(batch); err != nil {
 		if c.flushLog != nil {
-			c.flushLog(len(batch), nil)
+			c.flushLog(len(batch), withRequestIDs(fmt.Errorf("outbox drain failed: %w", err), batch))
 		}
+		return false
 	}
-	return nil
+	if err := c.outbox.removeByName(meta.name); err != nil && c.flushLog != nil {
+		c.flushLog(len(batch), fmt.Errorf("outbox drain delete failed: %w", err))
+	}
+	if c.flushLog != nil {
+		c.flushLog(len(batch), nil)
+	}
+	return true
+}
+
+// drainLimiter caps the events replayed from the outbox per second so a large backlog does not
+// crowd out live traffic. It holds at most one second of budget; a file larger than that is let
+// through once the budget is full. A nil drainLimiter allows everything.
+type drainLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	nowFn  func() time.Time
+}
+
+func newDrainLimiter(eventsPerSecond int) *drainLimiter {
+	if eventsPerSecond <= 0 {
+		return nil
+	}
+	l := &drainLimiter{rate: float64(eventsPerSecond), tokens: float64(eventsPerSecond), nowFn: time.Now}
+	l.last = l.nowFn()
+	return l
+}
+
+// take reports whether n events may be replayed now, and if so counts them.
+func (l *drainLimiter) take(n int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.nowFn()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	if float64(n) > l.tokens && l.tokens < l.rate {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// countSensors returns the number of events per sensor ID ("" for events written without one).
+func countSensors(sensors []string) map[string]int {
+	out := make(map[string]int)
+	for _, id := range sensors {
+		out[id]++
+	}
+	return out
+}
+
+// sensorGroup is the events of one sensor in a batch.
+type sensorGroup struct {
+	events  []json.RawMessage
+	sensors []string
+}
+
+// groupBySensor splits batch by sensor ID, keeping the order of events within each sensor and of
+// the sensors' first events.
+func groupBySensor(batch []json.RawMessage, sensors []string) []sensorGroup {
+	var groups []sensorGroup
+	index := make(map[string]int)
+	for i, raw := range batch {
+		g, ok := index[sensors[i]]
+		if !ok {
+			g = len(groups)
+			index[sensors[i]] = g
+			groups = append(groups, sensorGroup{})
+		}
+		groups[g].events = append(groups[g].events, raw)
+		groups[g].sensors = append(groups[g].sensors, sensors[i])
+	}
+	return groups
 }
 
-func splitBatches(batch []map[string]interface{}, size int) [][]map[string]interface{} {
+func splitBatches(batch []json.RawMessage, size int) [][]json.RawMessage {
 	if size <= 0 || len(batch) <= size {
-		return [][]map[string]interface{}{batch}
+		return [][]json.RawMessage{batch}
 	}
-	out := make([][]map[string]interface{}, 0, (len(batch)+size-1)/size)
+	out := make([][]json.RawMessage, 0, (len(batch)+size-1)/size)
 	for i := 0; i < len(batch); i += size {
 		j := i + size
 		if j > len(batch) {
@@ -4,7 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
 )
 
 func TestNewWriter_Stdout(t *testing.T) {
@@ -49,6 +55,56 @@ func TestNewWriter_Elasticsearch_DefaultIndex(t *testing.T) {
 	_ = w.Close()
 }
 
+func TestElasticsearch_SplitsBulkByBytes(t *testing.T) {
+	var bodies []string
+	var lengths []int64
+	fail := 2 // the second request fails
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		lengths = append(lengths, r.ContentLength)
+		if len(bodies) == fail {
+			http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+		}
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "elasticsearch", ElasticsearchURL: srv.URL, ElasticsearchIndex: "ix", ElasticsearchMaxBulkBytes: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := `{"index":{"_index":"ix"}}` + "\n"
+	small := `{"n":1}`
+	big := `{"n":"` + strings.Repeat("x", 200) + `"}`
+	var errs []error
+	for _, raw := range []string{small, small, small, big, small} {
+		// The big event takes the buffer over 150 bytes and flushes it
+		errs = append(errs, WriteRaw(w, "s1", json.RawMessage(raw)))
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// 3 small (3*34 bytes), the big one alone, then the last small one
+	want := []string{
+		strings.Repeat(meta+small+"\n", 3),
+		meta + big + "\n",
+		meta + small + "\n",
+	}
+	if len(bodies) != len(want) {
+		t.Fatalf("requests = %q", bodies)
+	}
+	for i := range want {
+		if bodies[i] != want[i] || lengths[i] != int64(len(want[i])) {
+			t.Errorf("request %d: %q (Content-Length %d), want %q", i, bodies[i], lengths[i], want[i])
+		}
+	}
+	if errs[3] == nil || !strings.Contains(errs[3].Error(), "413") {
+		t.Errorf("WriteRaw = %v, want the failed request's error", errs)
+	}
+	if st := StatsOf(w); st.FlushOK != 2 || st.FlushFailed != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
 func TestNewWriter_ClickHouse_NoURL(t *testing.T) {
 	_, err := NewWriter(WriterConfig{Type: "clickhouse"})
 	if err == nil {
@@ -67,6 +123,73 @@ func TestNewWriter_ClickHouse_Defaults(t *testing.T) {
 	_ = w.Close()
 }
 
+func TestWriteRaw_ClickHouseInsertsBytesAsIs(t *testing.T) {
+	var rows []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var row struct{ Event string }
+			_ = json.Unmarshal(sc.Bytes(), &row)
+			rows = append(rows, row.Event)
+		}
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := `{"z":1,"a":{"b":"<c>"}}` // key order and escaping survive
+	if err := WriteRaw(w, "spip-001", json.RawMessage(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0] != raw {
+		t.Errorf("rows = %q, want [%s]", rows, raw)
+	}
+}
+
+func TestClickHouse_FlattenedRows(t *testing.T) {
+	var query, row string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		b, _ := io.ReadAll(r.Body)
+		row = string(b)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true, ClickHouseFlatten: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := `{"@timestamp":"2026-02-15T19:47:09Z","event":{"id":"abc"},"source":{"ip":"192.0.2.1","port":4496,"geo":{"country_iso_code":"NL"}},"tags":["a"],"n":12345678901234567890}`
+	if err := WriteRaw(w, "spip-001", json.RawMessage(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	eventJSON, _ := json.Marshal(raw)
+	want := `{"event":` + string(eventJSON) + `,"@timestamp":"2026-02-15T19:47:09Z","event.id":"abc","n":12345678901234567890,` +
+		`"source.geo.country_iso_code":"NL","source.ip":"192.0.2.1","source.port":4496,"tags":["a"]}` + "\n"
+	if row != want {
+		t.Errorf("row = %s\nwant  %s", row, want)
+	}
+	if !strings.Contains(query, "input_format_skip_unknown_fields=1") || !strings.Contains(query, "loom_events+FORMAT") {
+		t.Errorf("query = %s", query)
+	}
+}
+
+func TestWriteRaw_DecodesForOtherWriters(t *testing.T) {
+	w := &memWriter{}
+	if err := WriteRaw(w, "spip-001", json.RawMessage(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.events) != 1 || w.events[0]["a"] != float64(1) {
+		t.Errorf("written = %v", w.events)
+	}
+}
+
 // spipStyleEvent returns a minimal ECS event as produced by Spip (roundtrip via JSON).
 func spipStyleEvent() map[string]interface{} {
 	return map[string]interface{}{
@@ -114,3 +237,51 @@ func TestStdoutWriter_WriteToBuffer(t *testing.T) {
 		t.Errorf("output = %s", out)
 	}
 }
+
+func BenchmarkClickHouseWriter_Insert(b *testing.B) {
+	c := newBenchClickHouseWriter(b)
+	batch := make([]event.Event, 500)
+	for i := range batch {
+		batch[i] = spipStyleEvent()
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, ev := range batch {
+			_ = c.WriteFrom("spip-001", ev)
+		}
+		if err := c.flushBuf(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkClickHouseWriter_InsertRaw writes the same events as passthrough mode does, already encoded.
+func BenchmarkClickHouseWriter_InsertRaw(b *testing.B) {
+	c := newBenchClickHouseWriter(b)
+	raw, _ := json.Marshal(spipStyleEvent())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 500; j++ {
+			_ = c.WriteRaw("spip-001", raw)
+		}
+		if err := c.flushBuf(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newBenchClickHouseWriter(b *testing.B) *clickHouseWriter {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	b.Cleanup(srv.Close)
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	c := w.(*clickHouseWriter)
+	c.flush = 1000 // flushed by the benchmark
+	return c
+}
@@ -0,0 +1,290 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+const (
+	defaultPubSubEndpoint = "https://pubsub.googleapis.com"
+	pubsubScope           = "https://www.googleapis.com/auth/pubsub"
+)
+
+// gceMetadataToken is the metadata server's token URL for the instance's (or, on GKE with Workload
+// Identity, the pod's) service account.
+var gceMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// pubsubMessage is a message of the Pub/Sub publish REST call.
+type pubsubMessage struct {
+	Data        []byte            `json:"data"` // base64 in JSON
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// pubsubWriter publishes events to a Google Cloud Pub/Sub topic over the REST API, one JSON event
+// per message with a sensor_id attribute, in batches of up to 100 messages. With ordering, a
+// sensor's messages carry its ID as ordering key, so subscriptions with message ordering receive
+// them in order.
+type pubsubWriter struct {
+	client   *http.Client
+	url      string // the topic's :publish URL
+	token    func(ctx context.Context) (string, error)
+	ordering bool
+	flushLog FlushLogger
+	mu       sync.Mutex
+	buf      []pubsubMessage
+	flush    int
+	barrier  flushBarrier // see Sync
+
+	flushOK, flushFailed atomic.Uint64
+}
+
+func newPubSubWriter(cfg WriterConfig) (*pubsubWriter, error) {
+	if cfg.PubSubProject == "" || cfg.PubSubTopic == "" {
+		return nil, fmt.Errorf("pubsub_project and pubsub_topic required")
+	}
+	endpoint := strings.TrimSuffix(cfg.PubSubEndpoint, "/")
+	if endpoint == "" {
+		endpoint = defaultPubSubEndpoint
+	}
+	transport, err := httpTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: cfg.HTTP.requestTimeout(), Transport: transport}
+	// The metadata server is link-local: never through the proxy
+	tok := &cachedToken{fetch: metadataToken(&http.Client{Timeout: 10 * time.Second})}
+	if cfg.PubSubCredentialsFile != "" {
+		key, err := readServiceAccountKey(cfg.PubSubCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		tok.fetch = key.token(client)
+	}
+	return &pubsubWriter{
+		client:   client,
+		url:      endpoint + "/v1/projects/" + url.PathEscape(cfg.PubSubProject) + "/topics/" + url.PathEscape(cfg.PubSubTopic) + ":publish",
+		token:    tok.get,
+		ordering: cfg.PubSubOrdering,
+		flushLog: cfg.FlushLog,
+		flush:    100,
+	}, nil
+}
+
+func (p *pubsubWriter) Write(ev event.Event) error {
+	return p.WriteFrom("", ev)
+}
+
+// WriteFrom buffers event as a message from sensorID.
+func (p *pubsubWriter) WriteFrom(sensorID string, ev event.Event) error {
+	if ev == nil {
+		return nil
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return p.WriteRaw(sensorID, b)
+}
+
+// WriteRaw buffers an encoded event from sensorID.
+func (p *pubsubWriter) WriteRaw(sensorID string, raw json.RawMessage) error {
+	m := pubsubMessage{Data: raw}
+	if sensorID != "" {
+		m.Attributes = map[string]string{"sensor_id": sensorID}
+		if p.ordering {
+			m.OrderingKey = sensorID
+		}
+	}
+	p.mu.Lock()
+	p.buf = append(p.buf, m)
+	full := len(p.buf) >= p.flush
+	p.mu.Unlock()
+	if full {
+		return p.Flush()
+	}
+	return nil
+}
+
+func (p *pubsubWriter) Flush() error {
+	return p.flushBuf()
+}
+
+func (p *pubsubWriter) flushBuf() (err error) {
+	done := p.barrier.start()
+	defer func() { done(err != nil) }()
+	p.mu.Lock()
+	batch := p.buf
+	p.buf = nil
+	p.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err = p.publish(context.Background(), batch)
+	if err != nil {
+		p.flushFailed.Add(1)
+	} else {
+		p.flushOK.Add(1)
+	}
+	if p.flushLog != nil {
+		p.flushLog(len(batch), err)
+	}
+	return err
+}
+
+func (p *pubsubWriter) publish(ctx context.Context, batch []pubsubMessage) error {
+	token, err := p.token(ctx)
+	if err != nil {
+		return fmt.Errorf("pubsub credentials: %w", err)
+	}
+	body, err := json.Marshal(map[string]interface{}{"messages": batch})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pubsub publish %d: %s", resp.StatusCode, string(b))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (p *pubsubWriter) Sync() error {
+	return p.barrier.sync(p.flushBuf)
+}
+
+func (p *pubsubWriter) Close() error {
+	return p.flushBuf()
+}
+
+// Health checks that an access token can be obtained. Checking the topic itself would need
+// pubsub.topics.get, which the Publisher role does not grant.
+func (p *pubsubWriter) Health(ctx context.Context) error {
+	_, err := p.token(ctx)
+	return err
+}
+
+func (p *pubsubWriter) Stats() Stats {
+	return Stats{FlushOK: p.flushOK.Load(), FlushFailed: p.flushFailed.Load()}
+}
+
+// serviceAccountKey is the part of a Google service account JSON key used to get tokens.
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+func readServiceAccountKey(path string) (*serviceAccountKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub_credentials_file: %w", err)
+	}
+	var k serviceAccountKey
+	if err := json.Unmarshal(b, &k); err != nil {
+		return nil, fmt.Errorf("pubsub_credentials_file: %w", err)
+	}
+	if k.Type != "service_account" || k.ClientEmail == "" || k.TokenURI == "" {
+		return nil, fmt.Errorf("pubsub_credentials_file: not a service account key")
+	}
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("pubsub_credentials_file: no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub_credentials_file: %w", err)
+	}
+	var ok bool
+	if k.key, ok = parsed.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("pubsub_credentials_file: private key is not RSA")
+	}
+	return &k, nil
+}
+
+// token returns a fetch for cachedToken that exchanges a JWT signed with the key for an access
+// token (RFC 7523).
+func (k *serviceAccountKey) token(client *http.Client) func(ctx context.Context) (string, time.Time, error) {
+	return func(ctx context.Context) (string, time.Time, error) {
+		assertion, err := k.assertion(time.Now())
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return requestToken(ctx, client, k.TokenURI, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	}
+}
+
+// assertion is the JWT asking for a Pub/Sub token, signed with RS256.
+func (k *serviceAccountKey) assertion(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"scope": pubsubScope,
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, k.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// metadataToken returns a fetch for cachedToken that gets the token of the attached service
+// account from the metadata server (Compute Engine, GKE, Cloud Run).
+func metadataToken(client *http.Client) func(ctx context.Context) (string, time.Time, error) {
+	return func(ctx context.Context) (string, time.Time, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gceMetadataToken, nil)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		token, expires, err := doTokenRequest(client, req)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("metadata server (set pubsub_credentials_file outside Google Cloud): %w", err)
+		}
+		return token, expires, nil
+	}
+}
@@ -0,0 +1,163 @@
+package output
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestPubSubWriter(t *testing.T) {
+	var got []pubsubMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/my-project/topics/events:publish" || r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "wrong topic or token", http.StatusForbidden)
+			return
+		}
+		var body struct{ Messages []pubsubMessage }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		got = append(got, body.Messages...)
+		w.Write([]byte(`{"messageIds":["1"]}`))
+	}))
+	defer srv.Close()
+	w, err := newPubSubWriter(WriterConfig{PubSubProject: "my-project", PubSubTopic: "events", PubSubEndpoint: srv.URL + "/", PubSubOrdering: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.token = func(context.Context) (string, error) { return "tok", nil }
+	if err := WriteFrom(w, "spip-001", event.Event{"message": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(event.Event{"message": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatal("published before a flush")
+	}
+	if err := Sync(w); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, want 2", len(got))
+	}
+	if m := got[0]; string(m.Data) != `{"message":"a"}` || m.Attributes["sensor_id"] != "spip-001" || m.OrderingKey != "spip-001" {
+		t.Errorf("message = %s %v %q", m.Data, m.Attributes, m.OrderingKey)
+	}
+	if m := got[1]; m.Attributes != nil || m.OrderingKey != "" {
+		t.Errorf("message without a sensor has %v %q", m.Attributes, m.OrderingKey)
+	}
+
+	w.token = func(context.Context) (string, error) { return "expired", nil }
+	_ = w.Write(event.Event{"message": "c"})
+	if err := w.Flush(); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Flush = %v, want the 403", err)
+	}
+	if st := w.Stats(); st.FlushOK != 1 || st.FlushFailed != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assertion string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		assertion = r.FormValue("assertion")
+		w.Write([]byte(`{"access_token":"ya29.tok","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "loom@my-project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL,
+	})
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, keyFile, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := readServiceAccountKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, _, err := sa.token(srv.Client())(context.Background())
+	if err != nil || tok != "ya29.tok" {
+		t.Fatalf("token = %q, %v", tok, err)
+	}
+
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("assertion %q is not a JWT", assertion)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != "loom@my-project.iam.gserviceaccount.com" || claims["aud"] != srv.URL || claims["scope"] != pubsubScope {
+		t.Errorf("claims = %v", claims)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"type":"authorized_user"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readServiceAccountKey(path); err == nil {
+		t.Error("expected error for a key that is not a service account's")
+	}
+}
+
+func TestMetadataToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"access_token":"ya29.meta","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer srv.Close()
+	old := gceMetadataToken
+	gceMetadataToken = srv.URL
+	defer func() { gceMetadataToken = old }()
+
+	w, err := NewWriter(WriterConfig{Type: "pubsub", PubSubProject: "p", PubSubTopic: "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Health(context.Background()); err != nil {
+		t.Errorf("Health = %v", err)
+	}
+	if tok, err := w.(*pubsubWriter).token(context.Background()); tok != "ya29.meta" {
+		t.Errorf("token = %q, %v", tok, err)
+	}
+}
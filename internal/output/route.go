@@ -0,0 +1,193 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// DefaultOutput names the default writer in Rule.Outputs.
+const DefaultOutput = "default"
+
+// Rule sends matching events to Outputs. An empty condition matches any event; an event matches
+// when it satisfies every non-empty condition.
+type Rule struct {
+	Sensors    []string // sensor the event was written from
+	Categories []string // any value of event.category
+	Ports      []int    // destination.port
+	Outputs    []string // names of the routed writers, or DefaultOutput
+	Continue   bool     // keep evaluating the rules after this one
+}
+
+type rule struct {
+	sensors    map[string]bool
+	categories map[string]bool
+	ports      map[int]bool
+	outputs    []Writer
+	cont       bool
+}
+
+func keySet[K comparable](keys []K) map[K]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	m := make(map[K]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
+
+func (r *rule) match(sensorID string, ev event.Event) bool {
+	if r.sensors != nil && !r.sensors[sensorID] {
+		return false
+	}
+	if r.ports != nil && !r.ports[ev.DestinationPort()] {
+		return false
+	}
+	if r.categories != nil {
+		switch c := ev.Get("event.category").(type) {
+		case string:
+			return r.categories[c]
+		case []interface{}:
+			for _, v := range c {
+				if s, ok := v.(string); ok && r.categories[s] {
+					return true
+				}
+			}
+			return false
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// router sends each event to the outputs of the rules it matches, in order until a matching rule
+// without Continue, and events no rule matches to the default writer.
+type router struct {
+	def   Writer
+	named map[string]Writer
+	names []string // sorted keys of named
+	rules []rule
+}
+
+// NewRouter returns a Writer routing events by rules to def and the named writers. Returns def
+// when there are no rules. Rule outputs must be DefaultOutput or keys of named.
+func NewRouter(def Writer, named map[string]Writer, rules []Rule) (Writer, error) {
+	if len(rules) == 0 {
+		return def, nil
+	}
+	r := &router{def: def, named: named}
+	for name := range named {
+		r.names = append(r.names, name)
+	}
+	sort.Strings(r.names)
+	for i, rl := range rules {
+		compiled := rule{sensors: keySet(rl.Sensors), categories: keySet(rl.Categories), ports: keySet(rl.Ports), cont: rl.Continue}
+		for _, name := range rl.Outputs {
+			w, ok := named[name]
+			if name == DefaultOutput {
+				w, ok = def, true
+			}
+			if !ok {
+				return nil, fmt.Errorf("route %d: unknown output %q", i, name)
+			}
+			compiled.outputs = append(compiled.outputs, w)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+func (r *router) Write(event event.Event) error {
+	return r.WriteFrom("", event)
+}
+
+// WriteFrom writes event to every writer its matching rules name, each writer at most once.
+func (r *router) WriteFrom(sensorID string, event event.Event) error {
+	if event == nil {
+		return nil
+	}
+	targets := r.route(sensorID, event)
+	if len(targets) == 1 {
+		return WriteFrom(targets[0], sensorID, event)
+	}
+	var errs []error
+	for _, w := range targets {
+		if err := WriteFrom(w, sensorID, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteRaw writes raw (passthrough mode, where events are not decoded) to the default writer.
+func (r *router) WriteRaw(sensorID string, raw json.RawMessage) error {
+	return WriteRaw(r.def, sensorID, raw)
+}
+
+func (r *router) route(sensorID string, ev event.Event) []Writer {
+	var targets []Writer
+	for i := range r.rules {
+		rl := &r.rules[i]
+		if !rl.match(sensorID, ev) {
+			continue
+		}
+		for _, w := range rl.outputs {
+			if !contains(targets, w) {
+				targets = append(targets, w)
+			}
+		}
+		if !rl.cont {
+			break
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, r.def)
+	}
+	return targets
+}
+
+func contains(ws []Writer, w Writer) bool {
+	for _, x := range ws {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// each calls fn for the default writer and every named writer and returns the first error.
+func (r *router) each(fn func(w Writer) error) error {
+	first := fn(r.def)
+	for _, name := range r.names {
+		if err := fn(r.named[name]); err != nil && first == nil {
+			first = fmt.Errorf("output %s: %w", name, err)
+		}
+	}
+	return first
+}
+
+func (r *router) Flush() error {
+	return r.each(func(w Writer) error { return w.Flush() })
+}
+
+func (r *router) Close() error {
+	return r.each(func(w Writer) error { return w.Close() })
+}
+
+func (r *router) Health(ctx context.Context) error {
+	return r.each(func(w Writer) error { return w.Health(ctx) })
+}
+
+// Stats sums the stats of all writers.
+func (r *router) Stats() Stats {
+	var sum Stats
+	_ = r.each(func(w Writer) error { sum.add(StatsOf(w)); return nil })
+	return sum
+}
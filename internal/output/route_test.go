@@ -0,0 +1,53 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestRouter(t *testing.T) {
+	def, ssh, all := &memWriter{}, &memWriter{}, &memWriter{}
+	w, err := NewRouter(def, map[string]Writer{"ssh": ssh, "archive": all}, []Rule{
+		{Ports: []int{22}, Categories: []string{"authentication"}, Outputs: []string{"ssh"}, Continue: true},
+		{Sensors: []string{"spip-01"}, Outputs: []string{"archive", DefaultOutput}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshEvent := func() event.Event {
+		return event.Event{
+			"event":       map[string]interface{}{"category": []interface{}{"network", "authentication"}},
+			"destination": map[string]interface{}{"port": float64(22)},
+		}
+	}
+	_ = WriteFrom(w, "spip-01", sshEvent())                                                       // ssh, archive, default
+	_ = WriteFrom(w, "spip-02", sshEvent())                                                       // ssh only
+	_ = WriteFrom(w, "spip-02", event.Event{"destination": map[string]interface{}{"port": "22"}}) // no category: default
+	_ = w.Write(event.Event{"n": 1})                                                              // no sensor: default
+	_ = WriteRaw(w, "spip-01", json.RawMessage(`{"n":2}`))                                        // not decoded: default
+	if len(ssh.events) != 2 || len(all.events) != 1 || len(def.events) != 4 {
+		t.Errorf("ssh got %d, archive got %d, default got %d", len(ssh.events), len(all.events), len(def.events))
+	}
+
+	if err := w.Flush(); err != nil || ssh.flushes != 1 || def.flushes != 1 {
+		t.Errorf("Flush = %v (flushes %d, %d)", err, ssh.flushes, def.flushes)
+	}
+	if st := StatsOf(w); st.FlushOK != 3 {
+		t.Errorf("stats = %+v", st)
+	}
+	ssh.health = errors.New("topic missing")
+	if err := w.Health(context.Background()); err == nil || err.Error() != "output ssh: topic missing" {
+		t.Errorf("Health = %v", err)
+	}
+
+	if _, err := NewRouter(def, nil, []Rule{{Outputs: []string{"kafka"}}}); err == nil {
+		t.Error("route to an unknown output should fail")
+	}
+	if w, _ := NewRouter(def, nil, nil); w != Writer(def) {
+		t.Error("router without rules should be the default writer")
+	}
+}
@@ -0,0 +1,238 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+
+	_ "modernc.org/sqlite" // database/sql driver "sqlite", pure Go
+)
+
+// defaultSQLiteMaxBytes bounds the database when WriterConfig.SQLiteMaxBytes is unset.
+const defaultSQLiteMaxBytes = 1 << 30
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY,
+	timestamp TEXT NOT NULL,
+	sensor_id TEXT,
+	source_ip TEXT,
+	destination_ip TEXT,
+	destination_port INTEGER,
+	event TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_timestamp ON events (timestamp);
+CREATE INDEX IF NOT EXISTS events_source_ip ON events (source_ip);
+`
+
+// sqliteTimeFormat sorts as text in time order, and SQLite's date functions read it.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// sqliteRow is a buffered event with the columns extracted from it.
+type sqliteRow struct {
+	ts, sensor, srcIP, dstIP string
+	dstPort                  int
+	event                    json.RawMessage
+}
+
+// sqliteWriter stores events in an embedded SQLite database, in the events table with the whole
+// event as JSON (event, for json_extract) next to indexed timestamp and source IP columns, in
+// transactions of up to 100 rows. When the data outgrows maxBytes, the oldest events are deleted
+// after a flush; SQLite reuses their pages, so the file stays near that size.
+type sqliteWriter struct {
+	db       *sql.DB
+	maxBytes int64 // 0 = unlimited
+	flushLog FlushLogger
+	mu       sync.Mutex
+	buf      []sqliteRow
+	flush    int
+	barrier  flushBarrier // see Sync
+
+	flushOK, flushFailed atomic.Uint64
+}
+
+func newSQLiteWriter(cfg WriterConfig) (*sqliteWriter, error) {
+	if cfg.SQLitePath == "" {
+		return nil, fmt.Errorf("sqlite_path required")
+	}
+	dsn := cfg.SQLitePath + "?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection: SQLite has a single writer, and readers (sqlite3, datasette) use their own
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite %s: %w", cfg.SQLitePath, err)
+	}
+	maxBytes := cfg.SQLiteMaxBytes
+	switch {
+	case maxBytes == 0:
+		maxBytes = defaultSQLiteMaxBytes
+	case maxBytes < 0:
+		maxBytes = 0
+	}
+	return &sqliteWriter{db: db, maxBytes: maxBytes, flushLog: cfg.FlushLog, flush: 100}, nil
+}
+
+func (s *sqliteWriter) Write(ev event.Event) error {
+	return s.WriteFrom("", ev)
+}
+
+// WriteFrom buffers event as a row from sensorID.
+func (s *sqliteWriter) WriteFrom(sensorID string, ev event.Event) error {
+	if ev == nil {
+		return nil
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ts, ok := ev.Timestamp()
+	if !ok {
+		ts = time.Now()
+	}
+	r := sqliteRow{
+		ts:      ts.UTC().Format(sqliteTimeFormat),
+		sensor:  sensorID,
+		srcIP:   ev.SourceIP(),
+		dstIP:   ev.DestinationIP(),
+		dstPort: ev.DestinationPort(),
+		event:   b,
+	}
+	s.mu.Lock()
+	s.buf = append(s.buf, r)
+	full := len(s.buf) >= s.flush
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// WriteRaw buffers an encoded event from sensorID, decoding it for the indexed columns.
+func (s *sqliteWriter) WriteRaw(sensorID string, raw json.RawMessage) error {
+	var ev event.Event
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return err
+	}
+	return s.WriteFrom(sensorID, ev)
+}
+
+func (s *sqliteWriter) Flush() error {
+	return s.flushBuf()
+}
+
+func (s *sqliteWriter) flushBuf() (err error) {
+	done := s.barrier.start()
+	defer func() { done(err != nil) }()
+	s.mu.Lock()
+	batch := s.buf
+	s.buf = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = s.insert(ctx, batch)
+	if err != nil {
+		s.flushFailed.Add(1)
+	} else {
+		s.flushOK.Add(1)
+	}
+	if s.flushLog != nil {
+		s.flushLog(len(batch), err)
+	}
+	if err == nil && s.maxBytes > 0 {
+		// The events are stored; a failed prune is tried again after the next flush
+		_ = s.prune(ctx)
+	}
+	return err
+}
+
+func (s *sqliteWriter) insert(ctx context.Context, batch []sqliteRow) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO events (timestamp, sensor_id, source_ip, destination_ip, destination_port, event) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range batch {
+		if _, err := stmt.ExecContext(ctx, r.ts, nullString(r.sensor), nullString(r.srcIP), nullString(r.dstIP), nullInt(r.dstPort), string(r.event)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt(n int) interface{} {
+	if n <= 0 {
+		return nil
+	}
+	return n
+}
+
+// usedBytes is the size of the database's pages in use (deleted rows' pages are free for reuse).
+func (s *sqliteWriter) usedBytes(ctx context.Context) (int64, error) {
+	var used int64
+	err := s.db.QueryRowContext(ctx, `SELECT (page_count - freelist_count) * page_size FROM pragma_page_count(), pragma_freelist_count(), pragma_page_size()`).Scan(&used)
+	return used, err
+}
+
+// prune deletes the oldest events when the data is above maxBytes, down to 90% of it so that not
+// every flush has to delete.
+func (s *sqliteWriter) prune(ctx context.Context) error {
+	used, err := s.usedBytes(ctx)
+	if err != nil || used <= s.maxBytes {
+		return err
+	}
+	var rows int64
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM events`).Scan(&rows); err != nil {
+		return err
+	}
+	n := rows * (used - s.maxBytes*9/10) / used
+	if n < 1 {
+		n = 1
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM events WHERE id IN (SELECT id FROM events ORDER BY timestamp LIMIT ?)`, n)
+	return err
+}
+
+func (s *sqliteWriter) Sync() error {
+	return s.barrier.sync(s.flushBuf)
+}
+
+func (s *sqliteWriter) Close() error {
+	err := s.flushBuf()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *sqliteWriter) Health(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteWriter) Stats() Stats {
+	return Stats{FlushOK: s.flushOK.Load(), FlushFailed: s.flushFailed.Load()}
+}
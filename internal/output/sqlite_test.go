@@ -0,0 +1,109 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	w, err := NewWriter(WriterConfig{Type: "sqlite", SQLitePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := event.Event{
+		"@timestamp":  "2024-05-01T12:00:00.5Z",
+		"source":      map[string]interface{}{"ip": "203.0.113.7", "port": 51234},
+		"destination": map[string]interface{}{"ip": "198.51.100.2", "port": 22},
+	}
+	if err := WriteFrom(w, "spip-001", ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteRaw(w, "", []byte(`{"message":"no timestamp"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var ts, sensor, src, dst string
+	var port int
+	err = db.QueryRow(`SELECT timestamp, sensor_id, source_ip, destination_port, json_extract(event, '$.destination.ip') FROM events WHERE source_ip = ?`, "203.0.113.7").
+		Scan(&ts, &sensor, &src, &port, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != "2024-05-01T12:00:00.500Z" || sensor != "spip-001" || port != 22 || dst != "198.51.100.2" {
+		t.Errorf("row = %s %s %s %d %s", ts, sensor, src, port, dst)
+	}
+	var plan string
+	var id, parent, unused int
+	if err := db.QueryRow(`EXPLAIN QUERY PLAN SELECT * FROM events WHERE source_ip = '203.0.113.7'`).Scan(&id, &parent, &unused, &plan); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plan, "events_source_ip") {
+		t.Errorf("query by source IP does not use the index: %s", plan)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM events WHERE sensor_id IS NULL AND source_ip IS NULL`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("raw event without a sensor: %d rows, %v", n, err)
+	}
+}
+
+func TestSQLiteWriter_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	const maxBytes = 256 << 10
+	w, err := newSQLiteWriter(WriterConfig{SQLitePath: path, SQLiteMaxBytes: maxBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	pad := strings.Repeat("x", 500)
+	for i := 0; i < 2000; i++ {
+		ev := event.Event{
+			"@timestamp": start.Add(time.Duration(i) * time.Second).Format(time.RFC3339),
+			"source":     map[string]interface{}{"ip": fmt.Sprintf("10.0.%d.%d", i/256, i%256)},
+			"message":    pad,
+		}
+		if err := w.Write(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	used, err := w.usedBytes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used > maxBytes+maxBytes/4 {
+		t.Errorf("database uses %d bytes, want about %d", used, maxBytes)
+	}
+	var n int
+	var oldest string
+	if err := w.db.QueryRow(`SELECT count(*), min(timestamp) FROM events`).Scan(&n, &oldest); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n >= 2000 {
+		t.Fatalf("%d events left, want some pruned", n)
+	}
+	if want := start.Add(time.Duration(2000-n) * time.Second).Format(sqliteTimeFormat); oldest != want {
+		t.Errorf("oldest event left %s, want %s (the newest kept)", oldest, want)
+	}
+}
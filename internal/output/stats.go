@@ -0,0 +1,139 @@
+package output
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are a writer's cumulative flush counters and current outbox depth, exported as metrics.
+type Stats struct {
+	FlushOK             uint64 // batches written to the destination (including outbox drains)
+	FlushFailed         uint64 // batches the destination rejected or could not be reached for
+	OutboxFiles         int
+	OutboxBytes         int64
+	OutboxDroppedEvents int64 // events dropped because the outbox was full
+	// Spool files the startup scan repaired (cut back to their last complete event, or recovered
+	// from a crash before they were renamed), and files moved to the quarantine directory.
+	OutboxRepairedFiles    int64
+	OutboxQuarantinedFiles int64
+}
+
+// add sums st into s.
+func (s *Stats) add(st Stats) {
+	s.FlushOK += st.FlushOK
+	s.FlushFailed += st.FlushFailed
+	s.OutboxFiles += st.OutboxFiles
+	s.OutboxBytes += st.OutboxBytes
+	s.OutboxDroppedEvents += st.OutboxDroppedEvents
+	s.OutboxRepairedFiles += st.OutboxRepairedFiles
+	s.OutboxQuarantinedFiles += st.OutboxQuarantinedFiles
+}
+
+type statsReporter interface {
+	Stats() Stats
+}
+
+// StatsOf returns w's stats; writers that send synchronously (stdout) report zero.
+func StatsOf(w Writer) Stats {
+	if sr, ok := w.(statsReporter); ok {
+		return sr.Stats()
+	}
+	return Stats{}
+}
+
+func (e *esWriter) Stats() Stats {
+	return Stats{FlushOK: e.flushOK.Load(), FlushFailed: e.flushFailed.Load()}
+}
+
+func (c *clickHouseWriter) Stats() Stats {
+	st := Stats{FlushOK: c.flushOK.Load(), FlushFailed: c.flushFailed.Load()}
+	if c.outbox != nil {
+		st.OutboxFiles, st.OutboxBytes, st.OutboxDroppedEvents = c.outbox.stats()
+		st.OutboxRepairedFiles, st.OutboxQuarantinedFiles = c.outbox.scanStats()
+	}
+	return st
+}
+
+// OutboxEventsBySensor returns the events currently spooled in w's outbox per sensor ID. Events
+// without a known sensor (written with Write, or spooled before a restart) are counted under "".
+// Returns nil when w has no outbox.
+func OutboxEventsBySensor(w Writer) map[string]int {
+	if c, ok := w.(*clickHouseWriter); ok && c.outbox != nil {
+		return c.outbox.eventsBySensor()
+	}
+	if r, ok := w.(*tenantRouter); ok {
+		var out map[string]int
+		_ = r.each(func(_ string, w Writer) error {
+			for id, n := range OutboxEventsBySensor(w) {
+				if out == nil {
+					out = make(map[string]int)
+				}
+				out[id] += n
+			}
+			return nil
+		})
+		return out
+	}
+	return nil
+}
+
+// RetryAfter is how long senders should hold back while w's outbox holds more than highWater bytes:
+// per for each multiple of highWater spooled, in whole seconds, at least one second and at most max.
+// It returns 0 at or below the mark, or when highWater is 0.
+func RetryAfter(w Writer, highWater int64, per, max time.Duration) time.Duration {
+	if highWater <= 0 {
+		return 0
+	}
+	b := StatsOf(w).OutboxBytes
+	if b <= highWater {
+		return 0
+	}
+	d := time.Duration(float64(per) * float64(b) / float64(highWater)).Round(time.Second)
+	if d < time.Second {
+		d = time.Second
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// Backpressure keeps RetryAfter for a writer up to date on a ticker, so ingest can read it on
+// every request without asking the writers for their stats each time.
+type Backpressure struct {
+	w         Writer
+	highWater int64
+	per, max  time.Duration
+	wait      atomic.Int64 // time.Duration
+}
+
+// NewBackpressure returns a Backpressure for RetryAfter(w, highWater, per, max), computed once now.
+func NewBackpressure(w Writer, highWater int64, per, max time.Duration) *Backpressure {
+	b := &Backpressure{w: w, highWater: highWater, per: per, max: max}
+	b.refresh()
+	return b
+}
+
+// Run recomputes the wait every interval until ctx is done.
+func (b *Backpressure) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.refresh()
+		}
+	}
+}
+
+// RetryAfter returns the wait as of the last refresh.
+func (b *Backpressure) RetryAfter() time.Duration {
+	return time.Duration(b.wait.Load())
+}
+
+func (b *Backpressure) refresh() {
+	b.wait.Store(int64(RetryAfter(b.w, b.highWater, b.per, b.max)))
+}
@@ -0,0 +1,137 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestStatsOf_CountsFlushes(t *testing.T) {
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{
+		Type:               "clickhouse",
+		ClickHouseURL:      srv.URL,
+		SkipClickHousePing: true,
+		ClickHouseOutbox:   OutboxConfig{Enabled: true, Dir: t.TempDir(), MaxBytes: 1 << 20, RetryBackoff: time.Hour, RetryMaxBackoff: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	_ = w.Write(spipStyleEvent())
+	_ = w.Flush()
+	st := StatsOf(w)
+	// The failed insert is spooled, then Flush immediately retries it from the outbox
+	if st.FlushOK != 0 || st.FlushFailed != 2 {
+		t.Errorf("after failed flush: %+v", st)
+	}
+	if st.OutboxFiles != 1 || st.OutboxBytes == 0 {
+		t.Errorf("failed batch not spooled: %+v", st)
+	}
+
+	fail = false
+	_ = w.Write(spipStyleEvent())
+	_ = w.Flush()
+	if st := StatsOf(w); st.FlushOK != 1 {
+		t.Errorf("after ok flush: %+v", st)
+	}
+}
+
+func TestStatsOf_Stdout(t *testing.T) {
+	w, err := NewWriter(WriterConfig{Type: "stdout"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := StatsOf(w); st != (Stats{}) {
+		t.Errorf("stdout stats = %+v, want zero", st)
+	}
+}
+
+func TestOutboxEventsBySensor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{
+		Type:               "clickhouse",
+		ClickHouseURL:      srv.URL,
+		SkipClickHousePing: true,
+		ClickHouseOutbox:   OutboxConfig{Enabled: true, Dir: t.TempDir(), MaxBytes: 1 << 20, MaxBatchSize: 2, RetryBackoff: time.Hour, RetryMaxBackoff: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	_ = WriteFrom(w, "s1", spipStyleEvent())
+	_ = WriteFrom(w, "s1", spipStyleEvent())
+	_ = WriteFrom(w, "s2", spipStyleEvent())
+	_ = w.Write(spipStyleEvent())
+	_ = w.Flush()
+
+	got := OutboxEventsBySensor(w)
+	if got["s1"] != 2 || got["s2"] != 1 || got[""] != 1 {
+		t.Errorf("OutboxEventsBySensor = %v", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	w, err := newClickHouseWriter(http.DefaultClient, "http://127.0.0.1:1", "default", "loom_events", "", "", nil, OutboxConfig{Enabled: true, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.outbox.enqueue([]event.Event{spipStyleEvent()}); err != nil {
+		t.Fatal(err)
+	}
+	spooled := StatsOf(w).OutboxBytes
+	for _, tc := range []struct {
+		highWater int64
+		want      time.Duration
+	}{
+		{0, 0},
+		{spooled, 0},
+		{spooled / 2, 20 * time.Second},
+		{spooled / 100, time.Minute},
+	} {
+		if got := RetryAfter(w, tc.highWater, 10*time.Second, time.Minute); got != tc.want {
+			t.Errorf("RetryAfter(high water %d of %d) = %v, want %v", tc.highWater, spooled, got, tc.want)
+		}
+	}
+	if got := RetryAfter(w, spooled-1, time.Millisecond, time.Minute); got != time.Second {
+		t.Errorf("RetryAfter = %v, want at least 1s", got)
+	}
+
+	b := NewBackpressure(w, spooled/2, 10*time.Second, time.Minute)
+	if got := b.RetryAfter(); got != 20*time.Second {
+		t.Errorf("Backpressure.RetryAfter = %v, want 20s", got)
+	}
+	if _, err := w.outbox.enqueue([]event.Event{spipStyleEvent()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.RetryAfter(); got != 20*time.Second {
+		t.Errorf("Backpressure.RetryAfter = %v before a refresh, want the cached 20s", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, 10*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for b.RetryAfter() != 40*time.Second && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.RetryAfter(); got != 40*time.Second {
+		t.Errorf("Backpressure.RetryAfter = %v after a refresh, want 40s", got)
+	}
+}
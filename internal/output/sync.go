@@ -0,0 +1,84 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNotWritten is returned by Sync when a flush that may have held the synced events failed.
+var ErrNotWritten = errors.New("output: events not confirmed written")
+
+// syncer is implemented by writers that can confirm the events written so far more cheaply than
+// a Flush (e.g. without draining the outbox).
+type syncer interface {
+	Sync() error
+}
+
+// Sync returns once every event written to w before the call has been accepted by the output or
+// spooled to its outbox, or with an error when that cannot be confirmed. Writers without their
+// own Sync are flushed.
+func Sync(w Writer) error {
+	if s, ok := w.(syncer); ok {
+		return s.Sync()
+	}
+	return w.Flush()
+}
+
+// flushBarrier lets Sync wait for flushes already under way. A flush takes its batch out of the
+// buffer before writing it, so finding the buffer empty does not mean the events are written yet.
+type flushBarrier struct {
+	inFlight sync.RWMutex  // read-held by each flush from taking its batch until it is written or spooled
+	failed   atomic.Uint64 // flushes whose batch was neither written nor spooled
+}
+
+// start is called by a flush before it takes its batch; the returned func when it is done, with
+// whether the batch was lost.
+func (b *flushBarrier) start() func(failed bool) {
+	b.inFlight.RLock()
+	return func(failed bool) {
+		if failed {
+			b.failed.Add(1)
+		}
+		b.inFlight.RUnlock()
+	}
+}
+
+// sync runs flush and waits for the flushes started before it, failing if any of them failed.
+func (b *flushBarrier) sync(flush func() error) error {
+	before := b.failed.Load()
+	if err := flush(); err != nil {
+		return err
+	}
+	// Lock waits for the flushes holding the read lock
+	b.inFlight.Lock()
+	b.inFlight.Unlock()
+	if b.failed.Load() != before {
+		return ErrNotWritten
+	}
+	return nil
+}
+
+// Sync writes the buffer to ClickHouse (or the outbox, when the insert fails) without draining
+// the outbox.
+func (c *clickHouseWriter) Sync() error {
+	return c.barrier.sync(c.flushBuf)
+}
+
+func (e *esWriter) Sync() error {
+	return e.barrier.sync(e.flushBuf)
+}
+
+// Sync sends the queued events; with a spool, a batch the upstream refused is spooled instead.
+func (f *forwardWriter) Sync() error {
+	return f.c.Flush(context.Background())
+}
+
+func (r *tenantRouter) Sync() error {
+	return r.each(func(_ string, w Writer) error { return Sync(w) })
+}
+
+func (r *router) Sync() error {
+	return r.each(func(w Writer) error { return Sync(w) })
+}
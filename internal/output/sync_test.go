@@ -0,0 +1,48 @@
+package output
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestSync_WaitsForRunningFlush(t *testing.T) {
+	inserting, release := make(chan struct{}), make(chan int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inserting <- struct{}{}
+		w.WriteHeader(<-release)
+	}))
+	defer srv.Close()
+	w, err := NewWriter(WriterConfig{Type: "clickhouse", ClickHouseURL: srv.URL, SkipClickHousePing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		if err := w.Write(event.Event{"message": "a"}); err != nil {
+			t.Fatal(err)
+		}
+		// A periodic flush takes the event out of the buffer and is still inserting it
+		go func() { _ = w.Flush() }()
+		<-inserting
+		synced := make(chan error, 1)
+		go func() { synced <- Sync(w) }()
+		select {
+		case err := <-synced:
+			t.Fatalf("status %d: Sync returned %v before the insert finished", status, err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		release <- status
+		err := <-synced
+		if status == http.StatusOK && err != nil {
+			t.Errorf("Sync after a successful insert = %v", err)
+		}
+		if status != http.StatusOK && !errors.Is(err, ErrNotWritten) {
+			t.Errorf("Sync after a failed insert = %v, want ErrNotWritten", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// tenantRouter sends the events of each tenant's sensors to that tenant's writer (its own index or
+// table) and everything else, including events written without a sensor, to the default writer.
+type tenantRouter struct {
+	def      Writer
+	byTenant map[string]Writer
+	tenants  []string // sorted keys of byTenant
+	tenantOf func(sensorID string) string
+}
+
+// NewTenantRouter returns a Writer routing by the tenant tenantOf reports for the sensor an event
+// was written from (see WriteFrom). Returns def when byTenant is empty.
+func NewTenantRouter(def Writer, byTenant map[string]Writer, tenantOf func(sensorID string) string) Writer {
+	if len(byTenant) == 0 {
+		return def
+	}
+	r := &tenantRouter{def: def, byTenant: byTenant, tenantOf: tenantOf}
+	for id := range byTenant {
+		r.tenants = append(r.tenants, id)
+	}
+	sort.Strings(r.tenants)
+	return r
+}
+
+func (r *tenantRouter) Write(event event.Event) error {
+	return r.def.Write(event)
+}
+
+// WriteFrom writes event to the writer of sensorID's tenant, or the default writer.
+func (r *tenantRouter) WriteFrom(sensorID string, event event.Event) error {
+	return WriteFrom(r.writerFor(sensorID), sensorID, event)
+}
+
+// WriteRaw writes raw to the writer of sensorID's tenant, or the default writer.
+func (r *tenantRouter) WriteRaw(sensorID string, raw json.RawMessage) error {
+	return WriteRaw(r.writerFor(sensorID), sensorID, raw)
+}
+
+func (r *tenantRouter) writerFor(sensorID string) Writer {
+	if w, ok := r.byTenant[r.tenantOf(sensorID)]; ok {
+		return w
+	}
+	return r.def
+}
+
+// each calls fn for the default writer ("") and every tenant writer and returns the first error.
+func (r *tenantRouter) each(fn func(tenant string, w Writer) error) error {
+	first := fn("", r.def)
+	for _, id := range r.tenants {
+		if err := fn(id, r.byTenant[id]); err != nil && first == nil {
+			first = fmt.Errorf("tenant %s: %w", id, err)
+		}
+	}
+	return first
+}
+
+func (r *tenantRouter) Flush() error {
+	return r.each(func(_ string, w Writer) error { return w.Flush() })
+}
+
+func (r *tenantRouter) Close() error {
+	return r.each(func(_ string, w Writer) error { return w.Close() })
+}
+
+func (r *tenantRouter) Health(ctx context.Context) error {
+	return r.each(func(_ string, w Writer) error { return w.Health(ctx) })
+}
+
+// Stats sums the stats of all writers.
+func (r *tenantRouter) Stats() Stats {
+	var sum Stats
+	_ = r.each(func(_ string, w Writer) error { sum.add(StatsOf(w)); return nil })
+	return sum
+}
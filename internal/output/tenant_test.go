@@ -0,0 +1,55 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+type memWriter struct {
+	events  []event.Event
+	health  error
+	flushes int
+}
+
+func (m *memWriter) Write(ev event.Event) error {
+	m.events = append(m.events, ev)
+	return nil
+}
+func (m *memWriter) Flush() error                 { m.flushes++; return nil }
+func (m *memWriter) Close() error                 { return m.Flush() }
+func (m *memWriter) Health(context.Context) error { return m.health }
+func (m *memWriter) Stats() Stats                 { return Stats{FlushOK: uint64(m.flushes)} }
+
+func TestTenantRouter(t *testing.T) {
+	def, a := &memWriter{}, &memWriter{}
+	w := NewTenantRouter(def, map[string]Writer{"group-a": a}, func(sensorID string) string {
+		return map[string]string{"spip-01": "group-a", "spip-02": "group-b"}[sensorID]
+	})
+	_ = WriteFrom(w, "spip-01", map[string]interface{}{"n": 1})
+	_ = WriteFrom(w, "spip-02", map[string]interface{}{"n": 2}) // tenant without its own writer
+	_ = WriteFrom(w, "spip-03", map[string]interface{}{"n": 3})
+	_ = w.Write(map[string]interface{}{"n": 4}) // generated, no sensor
+	_ = WriteRaw(w, "spip-01", json.RawMessage(`{"n":5}`))
+	if len(a.events) != 2 || len(def.events) != 3 {
+		t.Errorf("tenant writer got %d, default got %d", len(a.events), len(def.events))
+	}
+
+	if err := w.Flush(); err != nil || a.flushes != 1 || def.flushes != 1 {
+		t.Errorf("Flush = %v (flushes %d, %d)", err, a.flushes, def.flushes)
+	}
+	if st := StatsOf(w); st.FlushOK != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+	a.health = errors.New("index missing")
+	if err := w.Health(context.Background()); err == nil || err.Error() != "tenant group-a: index missing" {
+		t.Errorf("Health = %v", err)
+	}
+
+	if NewTenantRouter(def, nil, nil) != Writer(def) {
+		t.Error("router without tenant writers should be the default writer")
+	}
+}
@@ -0,0 +1,67 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachedToken keeps an OAuth access token from fetch until five minutes before it expires.
+type cachedToken struct {
+	fetch func(ctx context.Context) (token string, expires time.Time, err error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (c *cachedToken) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Until(c.expires) > 5*time.Minute {
+		return c.token, nil
+	}
+	token, expires, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expires = token, expires
+	return token, nil
+}
+
+// requestToken posts form to an OAuth token endpoint (Azure AD, Google) and returns the access
+// token and when it expires.
+func requestToken(ctx context.Context, client *http.Client, endpoint string, form url.Values) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(client, req)
+}
+
+func doTokenRequest(client *http.Client, req *http.Request) (string, time.Time, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("token %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token %d: %s %s", resp.StatusCode, body.Error, body.Description)
+	}
+	return body.AccessToken, time.Now().Add(time.Duration(body.ExpiresIn) * time.Second), nil
+}
@@ -0,0 +1,109 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/proxy"
+)
+
+// HTTPConfig tunes the HTTP client of the Elasticsearch, ClickHouse and forward outputs. Zero
+// fields keep Go's defaults, which hold only 2 idle connections per host: concurrent flushes and
+// drain workers then dial (and handshake) again for most requests.
+type HTTPConfig struct {
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int // 0 = unlimited
+	DialTimeout         time.Duration
+	KeepAlive           time.Duration // interval of TCP keep-alive probes; negative disables them
+	TLSHandshakeTimeout time.Duration
+	IdleConnTimeout     time.Duration
+	RequestTimeout      time.Duration // whole request including the response body; default 30s
+	// PingAttempts is how often a connection check (at startup and for /ready) is tried while it
+	// fails with a network error or a 5xx; default 1.
+	PingAttempts int
+}
+
+// httpTransport returns the transport for cfg's destination: proxy.Transport with cfg.HTTP applied.
+func httpTransport(cfg WriterConfig) (*http.Transport, error) {
+	t, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	h := cfg.HTTP
+	if h.DialTimeout != 0 || h.KeepAlive != 0 {
+		// http.DefaultTransport's dialer
+		d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		if h.DialTimeout > 0 {
+			d.Timeout = h.DialTimeout
+		}
+		if h.KeepAlive != 0 {
+			d.KeepAlive = h.KeepAlive
+		}
+		t.DialContext = d.DialContext
+	}
+	if h.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = h.MaxIdleConnsPerHost
+		if t.MaxIdleConns > 0 && t.MaxIdleConns < h.MaxIdleConnsPerHost {
+			t.MaxIdleConns = h.MaxIdleConnsPerHost
+		}
+	}
+	if h.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = h.MaxConnsPerHost
+	}
+	if h.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = h.TLSHandshakeTimeout
+	}
+	if h.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = h.IdleConnTimeout
+	}
+	return t, nil
+}
+
+func (h HTTPConfig) requestTimeout() time.Duration {
+	if h.RequestTimeout > 0 {
+		return h.RequestTimeout
+	}
+	return 30 * time.Second
+}
+
+// pingStatusError is a connection check answered with a status other than 2xx.
+type pingStatusError struct {
+	status int
+	msg    string
+}
+
+func (e *pingStatusError) Error() string { return e.msg }
+
+// pingBackoff is the wait before the second attempt of retryPing; it doubles for each further one.
+var pingBackoff = 250 * time.Millisecond
+
+// retryPing runs ping, a request without side effects, up to attempts times while it fails with a
+// network error, 429 or a 5xx. Other answers (bad credentials, a wrong URL) are returned at once.
+func retryPing(ctx context.Context, attempts int, ping func(ctx context.Context) error) error {
+	wait := pingBackoff
+	for i := 1; ; i++ {
+		err := ping(ctx)
+		if err == nil || i >= attempts || !retryable(err) {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		wait *= 2
+	}
+}
+
+func retryable(err error) bool {
+	var se *pingStatusError
+	if errors.As(err, &se) {
+		return se.status >= 500 || se.status == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, context.Canceled)
+}
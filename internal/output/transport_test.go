@@ -0,0 +1,64 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	tr, err := httpTransport(WriterConfig{HTTP: HTTPConfig{
+		MaxIdleConnsPerHost: 200,
+		MaxConnsPerHost:     50,
+		TLSHandshakeTimeout: 3 * time.Second,
+		IdleConnTimeout:     time.Minute,
+		DialTimeout:         2 * time.Second,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.MaxIdleConnsPerHost != 200 || tr.MaxIdleConns < 200 || tr.MaxConnsPerHost != 50 || tr.TLSHandshakeTimeout != 3*time.Second || tr.IdleConnTimeout != time.Minute {
+		t.Errorf("transport = idle/host %d idle %d conns/host %d tls %v idle timeout %v",
+			tr.MaxIdleConnsPerHost, tr.MaxIdleConns, tr.MaxConnsPerHost, tr.TLSHandshakeTimeout, tr.IdleConnTimeout)
+	}
+
+	def, err := httpTransport(WriterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if std := http.DefaultTransport.(*http.Transport); def.MaxIdleConnsPerHost != std.MaxIdleConnsPerHost || def.TLSHandshakeTimeout != std.TLSHandshakeTimeout {
+		t.Error("zero HTTPConfig should keep Go's defaults")
+	}
+}
+
+func TestRetryPing(t *testing.T) {
+	defer func(b time.Duration) { pingBackoff = b }(pingBackoff)
+	pingBackoff = time.Millisecond
+
+	var calls atomic.Int32
+	statuses := []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[min(int(calls.Add(1))-1, len(statuses)-1)])
+	}))
+	defer srv.Close()
+	ping := func(ctx context.Context) error { return pingClickHouse(ctx, srv.Client(), srv.URL, "", "") }
+
+	if err := retryPing(context.Background(), 3, ping); err != nil || calls.Load() != 3 {
+		t.Errorf("retryPing = %v after %d calls, want success on the third", err, calls.Load())
+	}
+
+	calls.Store(0)
+	statuses = []int{http.StatusUnauthorized, http.StatusOK}
+	if err := retryPing(context.Background(), 3, ping); err == nil || calls.Load() != 1 {
+		t.Errorf("retryPing = %v after %d calls, want the 401 without retrying", err, calls.Load())
+	}
+
+	calls.Store(0)
+	statuses = []int{http.StatusBadGateway}
+	if err := retryPing(context.Background(), 2, ping); err == nil || calls.Load() != 2 {
+		t.Errorf("retryPing = %v after %d calls, want failure after 2 attempts", err, calls.Load())
+	}
+}
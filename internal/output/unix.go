@@ -0,0 +1,123 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// unixSendWait is how long a send waits for room in the consumer's receive queue before the
+// event is dropped.
+const unixSendWait = time.Millisecond
+
+// unixRedial is the wait between attempts to reach a socket nobody is bound to.
+var unixRedial = time.Second
+
+// unixWriter sends each event as one NDJSON line in a datagram to a unix socket that a local
+// consumer has bound. Like a ZeroMQ PUB socket it never holds back ingest: while no process is
+// bound to the socket, or its receive queue is full, events are dropped and counted as failed.
+// Events larger than the kernel's datagram limit (net.core.wmem_default, about 200 KiB) are
+// dropped too.
+type unixWriter struct {
+	path string
+
+	mu       sync.Mutex // guards conn and nextDial
+	conn     *net.UnixConn
+	nextDial time.Time
+
+	flushOK, flushFailed atomic.Uint64
+}
+
+func newUnixWriter(cfg WriterConfig) (*unixWriter, error) {
+	if cfg.UnixSocket == "" {
+		return nil, fmt.Errorf("unix_socket required")
+	}
+	if _, err := os.Stat(filepath.Dir(cfg.UnixSocket)); err != nil {
+		return nil, fmt.Errorf("unix_socket: %w", err)
+	}
+	return &unixWriter{path: cfg.UnixSocket}, nil
+}
+
+func (u *unixWriter) Write(ev event.Event) error {
+	if ev == nil {
+		return nil
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return u.WriteRaw("", b)
+}
+
+// WriteRaw sends an encoded event.
+func (u *unixWriter) WriteRaw(_ string, raw json.RawMessage) error {
+	line := make([]byte, 0, len(raw)+1)
+	line = append(append(line, raw...), '\n')
+	if u.send(line) {
+		u.flushOK.Add(1)
+	} else {
+		u.flushFailed.Add(1)
+	}
+	return nil
+}
+
+// send reports whether the consumer received line. A failed send on the open socket is tried once
+// more on a new one: the consumer may have restarted and bound the path again.
+func (u *unixWriter) send(line []byte) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if u.conn == nil {
+			if time.Now().Before(u.nextDial) {
+				return false
+			}
+			conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: u.path, Net: "unixgram"})
+			if err != nil {
+				u.nextDial = time.Now().Add(unixRedial)
+				return false
+			}
+			u.conn = conn
+		}
+		_ = u.conn.SetWriteDeadline(time.Now().Add(unixSendWait))
+		_, err := u.conn.Write(line)
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return false // queue full: the consumer is still there
+		}
+		u.conn.Close()
+		u.conn = nil
+	}
+	return false
+}
+
+// Flush does nothing: every event is sent when written.
+func (u *unixWriter) Flush() error { return nil }
+
+func (u *unixWriter) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conn == nil {
+		return nil
+	}
+	err := u.conn.Close()
+	u.conn = nil
+	return err
+}
+
+// Health always succeeds: no consumer being bound to the socket is not an error for this output.
+func (u *unixWriter) Health(context.Context) error { return nil }
+
+func (u *unixWriter) Stats() Stats {
+	return Stats{FlushOK: u.flushOK.Load(), FlushFailed: u.flushFailed.Load()}
+}
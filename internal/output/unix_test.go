@@ -0,0 +1,74 @@
+package output
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestUnixWriter(t *testing.T) {
+	dir, err := os.MkdirTemp("", "loom") // t.TempDir can exceed the 107-byte socket path limit
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.sock")
+	old := unixRedial
+	unixRedial = 0
+	defer func() { unixRedial = old }()
+
+	w, err := NewWriter(WriterConfig{Type: "unix", UnixSocket: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(event.Event{"message": "nobody listening"}); err != nil {
+		t.Errorf("Write without a consumer = %v, want the event dropped", err)
+	}
+
+	listen := func() *net.UnixConn {
+		t.Helper()
+		os.Remove(path)
+		c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	receive := func(c *net.UnixConn) string {
+		t.Helper()
+		buf := make([]byte, 1024)
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	c := listen()
+	if err := w.Write(event.Event{"message": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(c); got != "{\"message\":\"a\"}\n" {
+		t.Errorf("datagram = %q", got)
+	}
+
+	// The consumer restarts and binds the path again
+	c.Close()
+	c = listen()
+	defer c.Close()
+	if err := w.Write(event.Event{"message": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(c); got != "{\"message\":\"b\"}\n" {
+		t.Errorf("datagram after the consumer restarted = %q", got)
+	}
+	if st := StatsOf(w); st.FlushOK != 2 || st.FlushFailed != 1 {
+		t.Errorf("stats = %+v, want 2 sent and 1 dropped", st)
+	}
+}
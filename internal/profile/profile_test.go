@@ -0,0 +1,35 @@
+package profile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler("/admin")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/pprof/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("index: %d %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/pprof/goroutine?debug=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("goroutine profile: %d", rec.Code)
+	}
+}
+
+func TestLabels_NilAndUnknownStage(t *testing.T) {
+	var l *Labels
+	l.Enter("decode")
+	l.Clear()
+	l = NewLabels()
+	l.Enter("no such stage")
+	l.Enter("decode")
+	l.Clear()
+	if len(l.stages) != len(Stages) {
+		t.Errorf("labels for %d stages, want %d", len(l.stages), len(Stages))
+	}
+}
@@ -0,0 +1,94 @@
+// Package proxy routes outbound connections through a configured proxy: the HTTP clients of the
+// outputs and MISP, and the DNS enricher's lookups.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// Direct connects without a proxy, ignoring HTTPS_PROXY and HTTP_PROXY.
+const Direct = "direct"
+
+// Parse checks a proxy setting: "" for the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+// variables, Direct, or an http, https, socks5 or socks5h URL. It returns the URL, if any.
+func Parse(setting string) (*url.URL, error) {
+	if setting == "" || setting == Direct {
+		return nil, nil
+	}
+	u, err := url.Parse(setting)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy: unsupported scheme in %q (http, https, socks5 or socks5h)", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy: no host in %q", u.Redacted())
+	}
+	return u, nil
+}
+
+// Transport returns a clone of http.DefaultTransport that uses setting (see Parse).
+func Transport(setting string) (*http.Transport, error) {
+	u, err := Parse(setting)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	switch {
+	case u != nil:
+		t.Proxy = http.ProxyURL(u)
+	case setting == Direct:
+		t.Proxy = nil
+	}
+	return t, nil
+}
+
+// DialFunc opens a connection, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dialer returns a DialFunc that connects through the SOCKS5 proxy in setting, or nil for "" and
+// Direct.
+func Dialer(setting string) (DialFunc, error) {
+	u, err := Parse(setting)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("proxy: DNS lookups need a socks5 proxy, not %s", u.Scheme)
+	}
+	d, err := xproxy.FromURL(u, xproxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	cd, ok := d.(xproxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("proxy: %s dialer does not take a context", u.Scheme)
+	}
+	return cd.DialContext, nil
+}
+
+// Resolver returns a resolver whose lookups go over TCP through the SOCKS5 proxy in setting, or
+// nil for "" and Direct (the system resolver). The proxy must reach the name servers of
+// /etc/resolv.conf.
+func Resolver(setting string) (*net.Resolver, error) {
+	dial, err := Dialer(setting)
+	if err != nil || dial == nil {
+		return nil, err
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		// The resolver frames messages for TCP when the connection is not a net.PacketConn.
+		Dial: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, "tcp", addr)
+		},
+	}, nil
+}
@@ -0,0 +1,199 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// shorthand query parameters for common fields.
+var shorthands = map[string]string{
+	"source_ip":        "source.ip",
+	"destination_ip":   "destination.ip",
+	"destination_port": "destination.port",
+}
+
+// API serves the query endpoints (mounted under /api/v1 on the management port).
+type API struct {
+	store *Store
+	nowFn func() time.Time
+}
+
+// NewAPI returns the query endpoints over store.
+func NewAPI(store *Store) *API {
+	return &API{store: store, nowFn: time.Now}
+}
+
+// Events serves GET /events: matching events, newest first. Parameters: limit (default 100, at most
+// 1000), since (duration like 15m or RFC 3339 time), sensor_id, source_ip, destination_ip,
+// destination_port, and any dotted ECS field (e.g. event.dataset=loom.detection).
+func (a *API) Events(w http.ResponseWriter, r *http.Request) {
+	f, limit, err := a.parse(r, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	type entry struct {
+		SensorID string      `json:"sensor_id"`
+		Received time.Time   `json:"received"`
+		Event    event.Event `json:"event"`
+	}
+	entries := a.store.Events(f, limit)
+	out := make([]entry, len(entries))
+	for i, e := range entries {
+		out[i] = entry{SensorID: e.SensorID, Received: e.Received.UTC(), Event: e.Event}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(out), "events": out})
+}
+
+// Top serves GET /stats/top?field=...: the most frequent values of field (limit default 100),
+// filtered like Events.
+func (a *API) Top(w http.ResponseWriter, r *http.Request) {
+	a.serveTop(w, r, "")
+}
+
+// TopTalkers serves GET /stats/top-talkers: Top for source.ip.
+func (a *API) TopTalkers(w http.ResponseWriter, r *http.Request) {
+	a.serveTop(w, r, "source.ip")
+}
+
+// TopPorts serves GET /stats/top-ports: Top for destination.port.
+func (a *API) TopPorts(w http.ResponseWriter, r *http.Request) {
+	a.serveTop(w, r, "destination.port")
+}
+
+func (a *API) serveTop(w http.ResponseWriter, r *http.Request, field string) {
+	if field == "" {
+		field = r.URL.Query().Get("field")
+		if field == "" {
+			writeError(w, http.StatusBadRequest, "field is required")
+			return
+		}
+	}
+	f, limit, err := a.parse(r, "field")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	top, total := a.store.Top(field, f, limit)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"field": field, "total": total, "top": top})
+}
+
+// Sensors serves GET /stats/sensors: events and events per second per sensor over since (default 5m).
+func (a *API) Sensors(w http.ResponseWriter, r *http.Request) {
+	f, _, err := a.parse(r, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f.Since.IsZero() {
+		f.Since = a.nowFn().Add(-5 * time.Minute)
+	}
+	window := a.nowFn().Sub(f.Since)
+	if retention := a.store.Retention(); window > retention {
+		window = retention
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+	type sensorRate struct {
+		SensorID     string  `json:"sensor_id"`
+		Events       int     `json:"events"`
+		EventsPerSec float64 `json:"events_per_second"`
+	}
+	counts := a.store.SensorCounts(f)
+	out := make([]sensorRate, len(counts))
+	for i, c := range counts {
+		out[i] = sensorRate{SensorID: c.Value, Events: c.Count, EventsPerSec: float64(c.Count) / window.Seconds()}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"window_seconds": int(window.Seconds()), "sensors": out})
+}
+
+// Summary serves GET /stats: the number of retained events, the oldest one's receive time and the
+// retention.
+func (a *API) Summary(w http.ResponseWriter, r *http.Request) {
+	n, oldest := a.store.Len()
+	resp := map[string]interface{}{
+		"events":            n,
+		"retention_seconds": int(a.store.Retention().Seconds()),
+	}
+	if n > 0 {
+		resp["oldest"] = oldest.UTC()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parse reads limit, since, sensor_id and field filters from r; skip names one more parameter that
+// is not a filter.
+func (a *API) parse(r *http.Request, skip string) (Filter, int, error) {
+	return parseFilter(r.URL.Query(), skip, a.nowFn, true)
+}
+
+// parseFilter reads sensor_id and field filters from q, and limit and since when paging is set.
+func parseFilter(q url.Values, skip string, nowFn func() time.Time, paging bool) (Filter, int, error) {
+	f := Filter{Fields: make(map[string]string)}
+	limit := defaultLimit
+	for name, values := range q {
+		v := values[0]
+		switch {
+		case name == skip:
+		case paging && name == "limit":
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return f, 0, fmt.Errorf("limit must be a positive integer")
+			}
+			if n > maxLimit {
+				n = maxLimit
+			}
+			limit = n
+		case paging && name == "since":
+			since, err := parseSince(v, nowFn())
+			if err != nil {
+				return f, 0, err
+			}
+			f.Since = since
+		case name == "sensor_id":
+			f.SensorID = v
+		case shorthands[name] != "":
+			f.Fields[shorthands[name]] = v
+		case strings.Contains(name, ".") && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "."):
+			f.Fields[name] = v
+		default:
+			return f, 0, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	return f, limit, nil
+}
+
+// parseSince accepts a duration back from now (15m, 2h) or an RFC 3339 time.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be a duration (15m) or an RFC 3339 time")
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,92 @@
+package query
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, h http.HandlerFunc, target string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAPI_Events(t *testing.T) {
+	s, now := testStore(100, time.Hour)
+	s.Add("s1", ev("192.0.2.1", 22))
+	s.Add("s2", ev("192.0.2.2", 22))
+	s.Add("s2", ev("192.0.2.1", 443))
+	api := NewAPI(s)
+	api.nowFn = func() time.Time { return *now }
+
+	var resp struct {
+		Count  int `json:"count"`
+		Events []struct {
+			SensorID string                 `json:"sensor_id"`
+			Event    map[string]interface{} `json:"event"`
+		} `json:"events"`
+	}
+	if code := serve(t, api.Events, "/events?source_ip=192.0.2.1&limit=1", &resp); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if resp.Count != 1 || resp.Events[0].SensorID != "s2" {
+		t.Errorf("resp = %+v", resp)
+	}
+	if code := serve(t, api.Events, "/events?destination_port=22&since=5m", &resp); code != http.StatusOK || resp.Count != 2 {
+		t.Errorf("port filter: status=%d count=%d", code, resp.Count)
+	}
+	for _, bad := range []string{"/events?limit=0", "/events?since=yesterday", "/events?bogus=1"} {
+		if code := serve(t, api.Events, bad, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad, code)
+		}
+	}
+}
+
+func TestAPI_TopAndSensors(t *testing.T) {
+	s, now := testStore(100, time.Hour)
+	for i := 0; i < 3; i++ {
+		s.Add("s1", ev("192.0.2.1", 22))
+	}
+	s.Add("s2", ev("192.0.2.2", 80))
+	api := NewAPI(s)
+	api.nowFn = func() time.Time { return *now }
+
+	var top struct {
+		Field string  `json:"field"`
+		Total int     `json:"total"`
+		Top   []Count `json:"top"`
+	}
+	if code := serve(t, api.TopTalkers, "/stats/top-talkers?limit=1", &top); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if top.Field != "source.ip" || top.Total != 4 || len(top.Top) != 1 || top.Top[0].Value != "192.0.2.1" {
+		t.Errorf("top-talkers = %+v", top)
+	}
+	if code := serve(t, api.Top, "/stats/top", nil); code != http.StatusBadRequest {
+		t.Errorf("top without field: status = %d", code)
+	}
+
+	var sensors struct {
+		WindowSeconds int `json:"window_seconds"`
+		Sensors       []struct {
+			SensorID     string  `json:"sensor_id"`
+			Events       int     `json:"events"`
+			EventsPerSec float64 `json:"events_per_second"`
+		} `json:"sensors"`
+	}
+	if code := serve(t, api.Sensors, "/stats/sensors?since=1m", &sensors); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if sensors.WindowSeconds != 60 || len(sensors.Sensors) != 2 || sensors.Sensors[0].Events != 3 || sensors.Sensors[0].EventsPerSec != 0.05 {
+		t.Errorf("sensors = %+v", sensors)
+	}
+}
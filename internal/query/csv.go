@@ -0,0 +1,115 @@
+package query
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+const (
+	defaultExportLimit = 10000
+	maxExportColumns   = 100
+)
+
+// DefaultExportColumns are the columns of an export that does not choose its own.
+var DefaultExportColumns = []string{
+	"received", "sensor_id", "@timestamp", "source.ip", "source.port", "destination.ip", "destination.port",
+	"network.transport", "event.action", "source.geo.country_iso_code", "source.as.number",
+}
+
+// Export serves GET /events/export: matching events as CSV (format=csv, the default) or TSV
+// (format=tsv), newest first, one column per entry of columns (comma-separated dotted ECS fields,
+// plus received and sensor_id; default DefaultExportColumns). Filters are those of Events; limit
+// defaults to 10000 and is bounded only by the store.
+func (a *API) Export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, columns, limitParam := q.Get("format"), q.Get("columns"), q.Get("limit")
+	q.Del("format")
+	q.Del("columns")
+	q.Del("limit")
+	f, _, err := parseFilter(q, "", a.nowFn, true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := defaultExportLimit
+	if limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	cols := DefaultExportColumns
+	if columns != "" {
+		cols = strings.Split(columns, ",")
+		if len(cols) > maxExportColumns {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d columns", maxExportColumns))
+			return
+		}
+		for i, c := range cols {
+			cols[i] = strings.TrimSpace(c)
+			if cols[i] == "" || strings.HasPrefix(cols[i], ".") || strings.HasSuffix(cols[i], ".") || strings.Contains(cols[i], "..") {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid column %q", c))
+				return
+			}
+		}
+	}
+	cw := csv.NewWriter(w)
+	contentType, ext := "text/csv; charset=utf-8", "csv"
+	switch format {
+	case "", "csv":
+	case "tsv":
+		cw.Comma = '\t'
+		contentType, ext = "text/tab-separated-values; charset=utf-8", "tsv"
+	default:
+		writeError(w, http.StatusBadRequest, "format must be csv or tsv")
+		return
+	}
+
+	entries := a.store.Events(f, limit)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="loom-events.`+ext+`"`)
+	_ = cw.Write(cols)
+	row := make([]string, len(cols))
+	for _, e := range entries {
+		for i, c := range cols {
+			switch c {
+			case "received":
+				row[i] = e.Received.UTC().Format(time.RFC3339Nano)
+			case "sensor_id":
+				row[i] = cell(e.SensorID)
+			default:
+				row[i] = cell(ecs.Get(e.Event, c))
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return
+		}
+	}
+	cw.Flush()
+}
+
+// cell formats a field for a spreadsheet: objects and arrays as JSON, numbers in decimal, missing
+// as empty. Strings that a spreadsheet would run as a formula (sensors relay attacker input) get a
+// leading single quote.
+func cell(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if x != "" && strings.ContainsRune("=+-@\t\r", rune(x[0])) {
+			return "'" + x
+		}
+		return x
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(x)
+		return cell(string(b))
+	}
+	return valueString(v)
+}
@@ -0,0 +1,55 @@
+package query
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPI_Export(t *testing.T) {
+	s, now := testStore(100, time.Hour)
+	s.Add("s1", ev("192.0.2.1", 22))
+	e := ev("192.0.2.2", 443)
+	e["user_agent"] = map[string]interface{}{"original": "=HYPERLINK(\"http://x\")"}
+	e["tags"] = []interface{}{"a", "b"}
+	s.Add("s2", e)
+	api := NewAPI(s)
+	api.nowFn = func() time.Time { return *now }
+
+	rec := httptest.NewRecorder()
+	api.Export(rec, httptest.NewRequest(http.MethodGet,
+		"/events/export?columns=sensor_id,source.ip,destination.port,user_agent.original,tags,missing.field&since=5m", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("status %d, %s", rec.Code, rec.Body)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][1] != "source.ip" {
+		t.Fatalf("rows = %q", rows)
+	}
+	// Newest first
+	if got := rows[1]; got[0] != "s2" || got[2] != "443" || got[3] != "'=HYPERLINK(\"http://x\")" || got[4] != `["a","b"]` || got[5] != "" {
+		t.Errorf("row = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	api.Export(rec, httptest.NewRequest(http.MethodGet, "/events/export?format=tsv&sensor_id=s1", nil))
+	r := csv.NewReader(rec.Body)
+	r.Comma = '\t'
+	rows, err = r.ReadAll()
+	if err != nil || len(rows) != 2 || len(rows[0]) != len(DefaultExportColumns) || rows[1][1] != "s1" {
+		t.Errorf("tsv: %q, %v", rows, err)
+	}
+
+	for _, bad := range []string{"/events/export?format=xlsx", "/events/export?columns=a..b", "/events/export?limit=-1", "/events/export?bogus=1"} {
+		rec = httptest.NewRecorder()
+		api.Export(rec, httptest.NewRequest(http.MethodGet, bad, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad, rec.Code)
+		}
+	}
+}
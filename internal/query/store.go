@@ -0,0 +1,232 @@
+// Package query keeps the most recent events in memory and answers small queries over them
+// (event search, top values, per-sensor rates), and streams the live event flow to tail clients, so
+// a deployment without ClickHouse or Elasticsearch can still see what is flowing.
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Entry is one stored event with the sensor that sent it and the time Loom received it.
+type Entry struct {
+	SensorID string
+	Received time.Time
+	Event    event.Event
+}
+
+// Filter selects entries. Fields maps dotted ECS paths to the exact value wanted (numbers compare by
+// their decimal form, arrays match when any element does).
+type Filter struct {
+	SensorID string
+	Since    time.Time
+	Fields   map[string]string
+}
+
+// Count is one value and how often it occurred.
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Store is a ring buffer of the last maxEvents events, further bounded to those received within
+// retention. Stored events must not be modified afterwards. A nil *Store stores nothing.
+type Store struct {
+	retention time.Duration
+	nowFn     func() time.Time
+
+	mu      sync.RWMutex
+	entries []Entry
+	next    int // index the next Add writes to
+	full    bool
+}
+
+// NewStore creates a store holding at most maxEvents events (default 100000) received within
+// retention (default 1h).
+func NewStore(maxEvents int, retention time.Duration) *Store {
+	if maxEvents <= 0 {
+		maxEvents = 100000
+	}
+	if retention <= 0 {
+		retention = time.Hour
+	}
+	return &Store{retention: retention, nowFn: time.Now, entries: make([]Entry, maxEvents)}
+}
+
+// Add stores event from sensorID, overwriting the oldest event when the buffer is full.
+func (s *Store) Add(sensorID string, ev event.Event) {
+	if s == nil || ev == nil {
+		return
+	}
+	now := s.nowFn()
+	s.mu.Lock()
+	s.entries[s.next] = Entry{SensorID: sensorID, Received: now, Event: ev}
+	s.next++
+	if s.next == len(s.entries) {
+		s.next = 0
+		s.full = true
+	}
+	s.mu.Unlock()
+}
+
+// each calls fn for the retained entries matching f, newest first, until fn returns false.
+func (s *Store) each(f Filter, fn func(Entry) bool) {
+	if s == nil {
+		return
+	}
+	cutoff := s.nowFn().Add(-s.retention)
+	if f.Since.After(cutoff) {
+		cutoff = f.Since
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := s.next
+	if s.full {
+		n = len(s.entries)
+	}
+	for i := 0; i < n; i++ {
+		idx := s.next - 1 - i
+		if idx < 0 {
+			idx += len(s.entries)
+		}
+		e := s.entries[idx]
+		if e.Received.Before(cutoff) {
+			return // older entries are older still
+		}
+		if !f.matches(e.SensorID, e.Event) {
+			continue
+		}
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// Events returns up to limit matching entries, newest first.
+func (s *Store) Events(f Filter, limit int) []Entry {
+	var out []Entry
+	s.each(f, func(e Entry) bool {
+		out = append(out, e)
+		return len(out) < limit
+	})
+	return out
+}
+
+// Top returns the limit most frequent values of field among the matching entries, and the number
+// of matching entries that had the field.
+func (s *Store) Top(field string, f Filter, limit int) ([]Count, int) {
+	counts := make(map[string]int)
+	total := 0
+	s.each(f, func(e Entry) bool {
+		v := ecs.Get(e.Event, field)
+		if v == nil {
+			return true
+		}
+		total++
+		if list, ok := v.([]interface{}); ok {
+			for _, el := range list {
+				counts[valueString(el)]++
+			}
+			return true
+		}
+		counts[valueString(v)]++
+		return true
+	})
+	return topN(counts, limit), total
+}
+
+// SensorCounts returns the number of matching entries per sensor, most active first.
+func (s *Store) SensorCounts(f Filter) []Count {
+	counts := make(map[string]int)
+	s.each(f, func(e Entry) bool {
+		counts[e.SensorID]++
+		return true
+	})
+	return topN(counts, len(counts))
+}
+
+// Len returns the number of retained events and the time the oldest of them was received.
+func (s *Store) Len() (int, time.Time) {
+	n := 0
+	var oldest time.Time
+	s.each(Filter{}, func(e Entry) bool {
+		n++
+		oldest = e.Received
+		return true
+	})
+	return n, oldest
+}
+
+// Retention returns how far back the store keeps events.
+func (s *Store) Retention() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return s.retention
+}
+
+func topN(counts map[string]int, limit int) []Count {
+	out := make([]Count, 0, len(counts))
+	for v, c := range counts {
+		out = append(out, Count{Value: v, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	if limit >= 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// matches applies the sensor and field conditions of f (not Since).
+func (f Filter) matches(sensorID string, event event.Event) bool {
+	if f.SensorID != "" && sensorID != f.SensorID {
+		return false
+	}
+	return matchFields(event, f.Fields)
+}
+
+func matchFields(event event.Event, fields map[string]string) bool {
+	for path, want := range fields {
+		v := ecs.Get(event, path)
+		if list, ok := v.([]interface{}); ok {
+			found := false
+			for _, el := range list {
+				if valueString(el) == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+			continue
+		}
+		if v == nil || valueString(v) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// valueString formats a JSON value for comparison; numbers print without exponent or trailing zeros.
+func valueString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%f", x), "0"), ".")
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,103 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func testStore(maxEvents int, retention time.Duration) (*Store, *time.Time) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewStore(maxEvents, retention)
+	s.nowFn = func() time.Time { return now }
+	return s, &now
+}
+
+func ev(ip string, port float64) map[string]interface{} {
+	return map[string]interface{}{
+		"source":      map[string]interface{}{"ip": ip},
+		"destination": map[string]interface{}{"port": port},
+	}
+}
+
+func TestStore_RingOverwritesOldest(t *testing.T) {
+	s, _ := testStore(3, time.Hour)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		s.Add("s1", ev(ip, 22))
+	}
+	got := s.Events(Filter{}, 10)
+	if len(got) != 3 {
+		t.Fatalf("len = %d, want 3", len(got))
+	}
+	if ip := got[0].Event["source"].(map[string]interface{})["ip"]; ip != "192.0.2.4" {
+		t.Errorf("newest = %v, want 192.0.2.4", ip)
+	}
+	if ip := got[2].Event["source"].(map[string]interface{})["ip"]; ip != "192.0.2.2" {
+		t.Errorf("oldest = %v, want 192.0.2.2", ip)
+	}
+}
+
+func TestStore_RetentionAndSince(t *testing.T) {
+	s, now := testStore(10, time.Hour)
+	s.Add("s1", ev("192.0.2.1", 22))
+	*now = now.Add(30 * time.Minute)
+	s.Add("s1", ev("192.0.2.2", 22))
+	*now = now.Add(40 * time.Minute) // first event is now 70m old
+
+	if got := s.Events(Filter{}, 10); len(got) != 1 {
+		t.Fatalf("retained = %d, want 1", len(got))
+	}
+	if got := s.Events(Filter{Since: now.Add(-10 * time.Minute)}, 10); len(got) != 0 {
+		t.Fatalf("since 10m = %d, want 0", len(got))
+	}
+	if n, oldest := s.Len(); n != 1 || !oldest.Equal(now.Add(-40*time.Minute)) {
+		t.Errorf("Len = %d, %v", n, oldest)
+	}
+}
+
+func TestStore_FilterAndTop(t *testing.T) {
+	s, _ := testStore(100, time.Hour)
+	s.Add("s1", ev("192.0.2.1", 22))
+	s.Add("s1", ev("192.0.2.1", 23))
+	s.Add("s2", ev("192.0.2.1", 22))
+	s.Add("s2", ev("192.0.2.9", 80))
+	s.Add("s2", map[string]interface{}{"message": "no source"})
+
+	if got := s.Events(Filter{Fields: map[string]string{"destination.port": "22"}}, 10); len(got) != 2 {
+		t.Errorf("port 22 = %d, want 2", len(got))
+	}
+	if got := s.Events(Filter{SensorID: "s2", Fields: map[string]string{"source.ip": "192.0.2.1"}}, 10); len(got) != 1 {
+		t.Errorf("s2 + ip = %d, want 1", len(got))
+	}
+	top, total := s.Top("source.ip", Filter{}, 1)
+	if total != 4 || len(top) != 1 || top[0] != (Count{Value: "192.0.2.1", Count: 3}) {
+		t.Errorf("top = %v total = %d", top, total)
+	}
+	ports, _ := s.Top("destination.port", Filter{}, 10)
+	if len(ports) != 3 || ports[0] != (Count{Value: "22", Count: 2}) {
+		t.Errorf("ports = %v", ports)
+	}
+	sensors := s.SensorCounts(Filter{})
+	if len(sensors) != 2 || sensors[0] != (Count{Value: "s2", Count: 3}) {
+		t.Errorf("sensors = %v", sensors)
+	}
+}
+
+func TestStore_ArrayFieldMatchesAnyElement(t *testing.T) {
+	s, _ := testStore(10, time.Hour)
+	s.Add("s1", map[string]interface{}{"tags": []interface{}{"cve-2021-44228", "scanner"}})
+	if got := s.Events(Filter{Fields: map[string]string{"tags": "scanner"}}, 10); len(got) != 1 {
+		t.Errorf("array match = %d, want 1", len(got))
+	}
+	top, _ := s.Top("tags", Filter{}, 10)
+	if len(top) != 2 {
+		t.Errorf("array top = %v", top)
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	var s *Store
+	s.Add("s1", ev("192.0.2.1", 22))
+	if got := s.Events(Filter{}, 10); len(got) != 0 {
+		t.Fatal("nil store returned events")
+	}
+}
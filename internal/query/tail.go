@@ -0,0 +1,153 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+const (
+	maxTailSubscribers = 16
+	tailBuffer         = 256
+	tailHeartbeat      = 15 * time.Second
+)
+
+type subscriber struct {
+	filter  Filter
+	ch      chan tailEvent
+	dropped int
+}
+
+type tailEvent struct {
+	SensorID string      `json:"sensor_id"`
+	Event    event.Event `json:"event"`
+}
+
+// Hub fans out the live event stream to tail clients. Publishing never blocks: a client that
+// cannot keep up loses events and is told how many. A nil *Hub does nothing.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// NewHub creates a hub with no subscribers.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*subscriber]struct{})}
+}
+
+// Publish hands event from sensorID to every subscriber whose filter matches. Events must not be
+// modified afterwards.
+func (h *Hub) Publish(sensorID string, ev event.Event) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if !s.filter.matches(sensorID, ev) {
+			continue
+		}
+		select {
+		case s.ch <- tailEvent{SensorID: sensorID, Event: ev}:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Subscribers returns the number of connected tail clients.
+func (h *Hub) Subscribers() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (h *Hub) subscribe(f Filter) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) >= maxTailSubscribers {
+		return nil
+	}
+	s := &subscriber{filter: f, ch: make(chan tailEvent, tailBuffer)}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// takeDropped returns and resets the events s lost since the last call.
+func (h *Hub) takeDropped(s *subscriber) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+// ServeHTTP streams matching events as Server-Sent Events (one "data:" JSON line per event with
+// sensor_id and event) until the client disconnects. Filters: sensor_id, source_ip, destination_ip,
+// destination_port and dotted ECS fields, as for /api/v1/events.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, _, err := parseFilter(r.URL.Query(), "", time.Now, false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s := h.subscribe(f)
+	if s == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("at most %d tail clients", maxTailSubscribers))
+		return
+	}
+	defer h.unsubscribe(s)
+
+	// The management server's write timeout would end the stream; lift it for this response.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case ev := <-s.ch:
+			if n := h.takeDropped(s); n > 0 {
+				if _, err := fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", n); err != nil {
+					return
+				}
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
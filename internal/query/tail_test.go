@@ -0,0 +1,81 @@
+package query
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHub_StreamsMatchingEvents(t *testing.T) {
+	hub := NewHub()
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tail?sensor_id=s1&destination_port=22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content-type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Subscribers() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hub.Publish("s2", ev("192.0.2.1", 22)) // other sensor
+	hub.Publish("s1", ev("192.0.2.1", 80)) // other port
+	hub.Publish("s1", ev("192.0.2.7", 22))
+
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		line := lines.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var got tailEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got); err != nil {
+			t.Fatal(err)
+		}
+		if ip := got.Event["source"].(map[string]interface{})["ip"]; got.SensorID != "s1" || ip != "192.0.2.7" {
+			t.Fatalf("streamed %+v, want the s1 port 22 event", got)
+		}
+		return
+	}
+	t.Fatalf("stream ended: %v", lines.Err())
+}
+
+func TestHub_RejectsPagingParametersAndExtraClients(t *testing.T) {
+	hub := NewHub()
+	rec := httptest.NewRecorder()
+	hub.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tail?limit=10", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("limit: status = %d, want 400", rec.Code)
+	}
+	for i := 0; i < maxTailSubscribers; i++ {
+		hub.subscribe(Filter{})
+	}
+	rec = httptest.NewRecorder()
+	hub.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tail", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("full hub: status = %d, want 503", rec.Code)
+	}
+}
+
+func TestHub_SlowClientDropsInsteadOfBlocking(t *testing.T) {
+	hub := NewHub()
+	s := hub.subscribe(Filter{})
+	for i := 0; i < tailBuffer+5; i++ {
+		hub.Publish("s1", ev("192.0.2.1", 22))
+	}
+	if n := hub.takeDropped(s); n != 5 {
+		t.Errorf("dropped = %d, want 5", n)
+	}
+	var nilHub *Hub
+	nilHub.Publish("s1", ev("192.0.2.1", 22))
+}
@@ -1,10 +1,25 @@
 package ratelimit
 
 import (
+	"context"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// Algorithms of PerSensorLimiter.
+const (
+	// Fixed counts requests per calendar second. Cheap, but a sensor can send rps requests at the end
+	// of one second and rps more at the start of the next: up to twice the limit within a second.
+	Fixed = "fixed"
+	// Sliding keeps the times of each sensor's last rps requests and allows a request when the oldest
+	// is at least a second old: never more than rps in any second. Costs 8 bytes per rps per sensor.
+	Sliding = "sliding"
+	// GCRA (generic cell rate algorithm) spaces requests 1/rps apart and allows bursts of up to burst
+	// requests: at most burst-1 over rps in any second. Costs one timestamp per sensor.
+	GCRA = "gcra"
+)
+
 // PerSensorLimiter enforces per-sensor rate limits (requests per second).
 // Returns 429 when the limit is exceeded.
 type PerSensorLimiter struct {
@@ -13,6 +28,27 @@ type PerSensorLimiter struct {
 	lastTick map[string]int64   // sensor -> last second bucket
 	count    map[string]int      // sensor -> count in current second
 	nowFn    func() time.Time
+
+	algorithm string             // Fixed when empty
+	burst     int                // GCRA burst; 0 = a tenth of rps, at least 1
+	window    map[string][]int64 // Sliding: sensor -> ring of its last rps request times (ns)
+	next      map[string]int     // Sliding: sensor -> ring index of its oldest request
+	tat       map[string]int64   // GCRA: sensor -> theoretical arrival time of the next request (ns)
+
+	shared SharedCounter // optional; counts across replicas
+}
+
+// SharedCounter counts requests in a window across Loom replicas (see internal/shared).
+type SharedCounter interface {
+	IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// UseShared makes the limit apply to the requests all replicas sharing c receive for a sensor. The
+// local count is kept as well and decides while c fails.
+func (p *PerSensorLimiter) UseShared(c SharedCounter) {
+	p.mu.Lock()
+	p.shared = c
+	p.mu.Unlock()
 }
 
 // NewPerSensorLimiter creates a limiter allowing rps requests per second per sensor.
@@ -28,18 +64,46 @@ func NewPerSensorLimiter(rps int) *PerSensorLimiter {
 		rps:      rps,
 		lastTick: make(map[string]int64),
 		count:    make(map[string]int),
-		nowFn:    time.Now().UTC,
+		nowFn:    func() time.Time { return time.Now().UTC() },
+		window:   make(map[string][]int64),
+		next:     make(map[string]int),
+		tat:      make(map[string]int64),
 	}
 }
 
 // Allow returns true if the sensor is within rate limit, false otherwise (caller should return 429).
 func (p *PerSensorLimiter) Allow(sensorID string) bool {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	if p.rps <= 0 {
+		p.mu.Unlock()
 		return true
 	}
-	now := p.nowFn().Unix()
+	rps, shared, fixed := p.rps, p.shared, p.algorithm == "" || p.algorithm == Fixed
+	t := p.nowFn()
+	now := t.Unix()
+	var allowed bool
+	switch p.algorithm {
+	case Sliding:
+		allowed = p.allowSlidingLocked(sensorID, t.UnixNano())
+	case GCRA:
+		allowed = p.allowGCRALocked(sensorID, t.UnixNano())
+	default:
+		allowed = p.allowLocked(sensorID, now)
+	}
+	p.mu.Unlock()
+
+	if shared != nil {
+		// Replicas share fixed one-second counts; with another algorithm, each replica also
+		// smooths the requests it receives.
+		key := "ratelimit:" + sensorID + ":" + strconv.FormatInt(now, 10)
+		if n, err := shared.IncrWindow(context.Background(), key, 2*time.Second); err == nil {
+			return n <= int64(rps) && (fixed || allowed)
+		}
+	}
+	return allowed
+}
+
+func (p *PerSensorLimiter) allowLocked(sensorID string, now int64) bool {
 	tick, ok := p.lastTick[sensorID]
 	if !ok || tick != now {
 		p.lastTick[sensorID] = now
@@ -52,6 +116,102 @@ func (p *PerSensorLimiter) Allow(sensorID string) bool {
 	return true
 }
 
+func (p *PerSensorLimiter) allowSlidingLocked(sensorID string, now int64) bool {
+	ring := p.window[sensorID]
+	if len(ring) != p.rps {
+		ring = make([]int64, p.rps)
+		p.window[sensorID] = ring
+	}
+	i := p.next[sensorID]
+	if ring[i] != 0 && now-ring[i] < int64(time.Second) {
+		return false
+	}
+	ring[i] = now
+	p.next[sensorID] = (i + 1) % len(ring)
+	return true
+}
+
+func (p *PerSensorLimiter) allowGCRALocked(sensorID string, now int64) bool {
+	interval := int64(time.Second) / int64(p.rps)
+	tat := p.tat[sensorID]
+	if tat < now {
+		tat = now
+	}
+	if tat-now > int64(p.burstLocked()-1)*interval {
+		return false
+	}
+	p.tat[sensorID] = tat + interval
+	return true
+}
+
+func (p *PerSensorLimiter) burstLocked() int {
+	if p.burst > 0 {
+		return p.burst
+	}
+	if p.rps >= 10 {
+		return p.rps / 10
+	}
+	return 1
+}
+
+// SetAlgorithm selects Fixed (also for ""), Sliding or GCRA with burst (0 = a tenth of the limit,
+// at least 1). Changing it forgets the requests counted so far.
+func (p *PerSensorLimiter) SetAlgorithm(algorithm string, burst int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if algorithm != p.algorithm {
+		p.lastTick, p.count = make(map[string]int64), make(map[string]int)
+		p.window, p.next, p.tat = make(map[string][]int64), make(map[string]int), make(map[string]int64)
+	}
+	p.algorithm, p.burst = algorithm, burst
+}
+
+// SetRPS changes the limit (e.g. after config reload). Same semantics as NewPerSensorLimiter:
+// 0 defaults to 50, negative disables rate limiting.
+func (p *PerSensorLimiter) SetRPS(rps int) {
+	if rps == 0 {
+		rps = 50
+	}
+	if rps < 0 {
+		rps = 0
+	}
+	p.mu.Lock()
+	if rps != p.rps {
+		p.window, p.next = make(map[string][]int64), make(map[string]int) // rings sized for the old limit
+	}
+	p.rps = rps
+	p.mu.Unlock()
+}
+
+// State returns how many requests sensorID has made in the current second (Sliding: the last
+// second; GCRA: the requests still counted against the burst) and the per-second limit (0 when rate
+// limiting is disabled).
+func (p *PerSensorLimiter) State(sensorID string) (used, limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.nowFn()
+	switch p.algorithm {
+	case Sliding:
+		for _, t := range p.window[sensorID] {
+			if t != 0 && now.UnixNano()-t < int64(time.Second) {
+				used++
+			}
+		}
+	case GCRA:
+		if p.rps > 0 {
+			interval := int64(time.Second) / int64(p.rps)
+			if ahead := p.tat[sensorID] - now.UnixNano(); ahead > 0 {
+				used = int((ahead + interval - 1) / interval)
+			}
+		}
+	default:
+		if p.lastTick[sensorID] == now.Unix() {
+			used = p.count[sensorID]
+		}
+	}
+	return used, p.rps
+}
+
 // RetryAfterSeconds returns a suggested Retry-After value in seconds when rate limited.
 func (p *PerSensorLimiter) RetryAfterSeconds(sensorID string) int {
 	return 1
@@ -1,6 +1,8 @@
 package ratelimit
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 )
@@ -73,3 +75,164 @@ func TestNewPerSensorLimiter_NegativeRPS_NoLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestPerSensorLimiter_SetRPS(t *testing.T) {
+	l := NewPerSensorLimiter(1)
+	if !l.Allow("s") || l.Allow("s") {
+		t.Fatal("rps=1: first allowed, second denied")
+	}
+	l.SetRPS(-1)
+	if !l.Allow("s") {
+		t.Error("after SetRPS(-1) rate limiting should be disabled")
+	}
+}
+
+func TestPerSensorLimiter_State(t *testing.T) {
+	now := time.Now().UTC().Unix()
+	limiter := &PerSensorLimiter{
+		rps:      5,
+		lastTick: make(map[string]int64),
+		count:    make(map[string]int),
+		nowFn:    func() time.Time { return time.Unix(now, 0) },
+	}
+	limiter.Allow("x")
+	limiter.Allow("x")
+	if used, limit := limiter.State("x"); used != 2 || limit != 5 {
+		t.Errorf("State = %d/%d, want 2/5", used, limit)
+	}
+	limiter.nowFn = func() time.Time { return time.Unix(now+1, 0) }
+	if used, _ := limiter.State("x"); used != 0 {
+		t.Errorf("State in next second = %d, want 0", used)
+	}
+}
+
+type memCounter struct {
+	counts map[string]int64
+	fail   bool
+}
+
+func (m *memCounter) IncrWindow(_ context.Context, key string, _ time.Duration) (int64, error) {
+	if m.fail {
+		return 0, errors.New("redis down")
+	}
+	m.counts[key]++
+	return m.counts[key], nil
+}
+
+func TestPerSensorLimiter_Shared(t *testing.T) {
+	shared := &memCounter{counts: map[string]int64{}}
+	now := time.Unix(1700000000, 0)
+	a, b := NewPerSensorLimiter(2), NewPerSensorLimiter(2)
+	for _, l := range []*PerSensorLimiter{a, b} {
+		l.nowFn = func() time.Time { return now }
+		l.UseShared(shared)
+	}
+	if !a.Allow("s1") || !b.Allow("s1") {
+		t.Fatal("first two requests across replicas should be allowed")
+	}
+	if a.Allow("s1") {
+		t.Error("third request across replicas in the same second should be denied")
+	}
+
+	shared.fail = true // b has used 1 of its own 2
+	if !b.Allow("s1") || b.Allow("s1") {
+		t.Error("local limit should apply while the shared counter fails")
+	}
+}
+
+func TestPerSensorLimiter_SharedWindows(t *testing.T) {
+	shared := &memCounter{counts: map[string]int64{}}
+	now := time.Unix(1700000000, 900*int64(time.Millisecond))
+	l := NewPerSensorLimiter(2)
+	l.nowFn = func() time.Time { return now }
+	l.UseShared(shared)
+	if !l.Allow("s1") || !l.Allow("s1") || l.Allow("s1") {
+		t.Fatal("want 2 of 3 allowed in the first second")
+	}
+	now = now.Add(200 * time.Millisecond)
+	if !l.Allow("s1") || !l.Allow("s1") || l.Allow("s1") {
+		t.Error("want 2 of 3 allowed in the next second")
+	}
+	if shared.counts["ratelimit:s1:1700000000"] != 3 || shared.counts["ratelimit:s1:1700000001"] != 3 {
+		t.Errorf("shared counts = %v, want 3 in each second's key", shared.counts)
+	}
+
+	// On the wall clock.
+	l = NewPerSensorLimiter(2)
+	l.UseShared(shared)
+	for l.Allow("s2") {
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if !l.Allow("s2") {
+		t.Error("still denied 1.1s after the shared limit was reached")
+	}
+}
+
+func TestPerSensorLimiter_Algorithms(t *testing.T) {
+	// 10 requests just before a second boundary and 10 just after: fixed windows allow all 20.
+	base := time.Unix(1000, 0)
+	offsets := make([]time.Duration, 0, 20)
+	for i := 0; i < 10; i++ {
+		offsets = append(offsets, 900*time.Millisecond+time.Duration(i)*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		offsets = append(offsets, time.Second+time.Duration(i)*time.Millisecond)
+	}
+	for _, tc := range []struct {
+		algorithm string
+		burst     int
+		want      int
+	}{
+		{Fixed, 0, 20},
+		{Sliding, 0, 10},
+		{GCRA, 5, 6}, // the burst, then one more 100ms later
+	} {
+		l := NewPerSensorLimiter(10)
+		l.SetAlgorithm(tc.algorithm, tc.burst)
+		allowed := 0
+		for _, off := range offsets {
+			now := base.Add(off)
+			l.nowFn = func() time.Time { return now }
+			if l.Allow("s") {
+				allowed++
+			}
+		}
+		if allowed != tc.want {
+			t.Errorf("%s: allowed %d of 20, want %d", tc.algorithm, allowed, tc.want)
+		}
+	}
+}
+
+func TestPerSensorLimiter_SlidingRecovers(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewPerSensorLimiter(2)
+	l.SetAlgorithm(Sliding, 0)
+	l.nowFn = func() time.Time { return now }
+	if !l.Allow("s") || !l.Allow("s") || l.Allow("s") {
+		t.Fatal("want 2 of 3 allowed")
+	}
+	if used, limit := l.State("s"); used != 2 || limit != 2 {
+		t.Errorf("State = %d/%d, want 2/2", used, limit)
+	}
+	now = now.Add(999 * time.Millisecond)
+	if l.Allow("s") {
+		t.Error("allowed before the oldest request left the window")
+	}
+	now = now.Add(time.Millisecond)
+	if !l.Allow("s") {
+		t.Error("denied after the oldest request left the window")
+	}
+}
+
+func TestPerSensorLimiter_RealClock(t *testing.T) {
+	for _, algorithm := range []string{Fixed, Sliding, GCRA} {
+		l := NewPerSensorLimiter(2)
+		l.SetAlgorithm(algorithm, 0)
+		for l.Allow("s") {
+		}
+		time.Sleep(1100 * time.Millisecond)
+		if !l.Allow("s") {
+			t.Errorf("%s: still denied 1.1s after the limit was reached", algorithm)
+		}
+	}
+}
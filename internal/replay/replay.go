@@ -0,0 +1,314 @@
+// Package replay re-submits events from NDJSON files (outbox spool files, exports) to an output or to
+// a running Loom's ingest endpoint, for disaster recovery. Delivery is at least once: a file that
+// fails part-way is replayed from its start next time.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/output"
+)
+
+// Sink receives replayed events one batch at a time.
+type Sink interface {
+	Send(ctx context.Context, batch []event.Event) error
+}
+
+// Options configures a replay run.
+type Options struct {
+	Dir       string   // directory of *.ndjson / *.jsonl files, replayed in name order (oldest spool file first)
+	Files     []string // explicit files instead of Dir
+	BatchSize int      // events per Send; default 500
+	Rate      float64  // events per second; 0 is unlimited
+	Delete    bool     // remove each file once all of its events were sent
+	// Progress is called about every ProgressEvery and once at the end.
+	Progress      func(Progress)
+	ProgressEvery time.Duration
+}
+
+// Progress reports how far a replay run is.
+type Progress struct {
+	Files     int
+	FilesDone int
+	Events    int
+	Elapsed   time.Duration
+	Done      bool
+}
+
+// Rate returns the average events per second so far.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Events) / p.Elapsed.Seconds()
+}
+
+// Files lists the NDJSON files in dir in name order.
+func Files(dir string) ([]string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, ent := range ents {
+		name := ent.Name()
+		if ent.IsDir() || !(strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".jsonl")) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Run sends every event of the files to sink and returns the final progress.
+func Run(ctx context.Context, sink Sink, opts Options) (Progress, error) {
+	files := opts.Files
+	if len(files) == 0 {
+		var err error
+		if files, err = Files(opts.Dir); err != nil {
+			return Progress{}, err
+		}
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.ProgressEvery <= 0 {
+		opts.ProgressEvery = 5 * time.Second
+	}
+	r := &runner{sink: sink, opts: opts, start: time.Now()}
+	r.progress.Files = len(files)
+	r.lastReport = r.start
+	for _, path := range files {
+		if err := r.file(ctx, path); err != nil {
+			r.report(true)
+			return r.progress, fmt.Errorf("%s: %w", path, err)
+		}
+		r.progress.FilesDone++
+		if opts.Delete {
+			if err := os.Remove(path); err != nil {
+				r.report(true)
+				return r.progress, err
+			}
+		}
+	}
+	r.report(true)
+	return r.progress, nil
+}
+
+// Stream sends the batches returned by next until it returns io.EOF, with the batch rate limit and
+// progress reporting of opts (file options are ignored). Used to copy events out of a backend.
+func Stream(ctx context.Context, sink Sink, next func(context.Context) ([]event.Event, error), opts Options) (Progress, error) {
+	if opts.ProgressEvery <= 0 {
+		opts.ProgressEvery = 5 * time.Second
+	}
+	r := &runner{sink: sink, opts: opts, start: time.Now()}
+	r.lastReport = r.start
+	for {
+		batch, err := next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && len(batch) > 0 {
+			err = r.send(ctx, batch)
+		}
+		if err != nil {
+			r.report(true)
+			return r.progress, err
+		}
+	}
+	r.report(true)
+	return r.progress, nil
+}
+
+type runner struct {
+	sink       Sink
+	opts       Options
+	start      time.Time
+	lastReport time.Time
+	progress   Progress
+}
+
+func (r *runner) file(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	batch := make([]event.Event, 0, r.opts.BatchSize)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var ev event.Event
+		if err := json.Unmarshal(text, &ev); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		batch = append(batch, ev)
+		if len(batch) == r.opts.BatchSize {
+			if err := r.send(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return r.send(ctx, batch)
+	}
+	return nil
+}
+
+func (r *runner) send(ctx context.Context, batch []event.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := r.sink.Send(ctx, batch); err != nil {
+		return err
+	}
+	r.progress.Events += len(batch)
+	if r.opts.Rate > 0 {
+		// Sleep until the average rate is back at the limit
+		due := r.start.Add(time.Duration(float64(r.progress.Events) / r.opts.Rate * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+	r.report(false)
+	return nil
+}
+
+func (r *runner) report(final bool) {
+	now := time.Now()
+	r.progress.Elapsed = now.Sub(r.start)
+	r.progress.Done = final
+	if r.opts.Progress == nil || (!final && now.Sub(r.lastReport) < r.opts.ProgressEvery) {
+		return
+	}
+	r.lastReport = now
+	r.opts.Progress(r.progress)
+}
+
+// WriterSink sends batches through an output writer, flushing after each batch so a failure is
+// reported for the batch that caused it.
+type WriterSink struct {
+	W output.Writer
+}
+
+// Send writes batch and flushes the writer.
+func (s WriterSink) Send(_ context.Context, batch []event.Event) error {
+	for _, ev := range batch {
+		if err := s.W.Write(ev); err != nil {
+			return err
+		}
+	}
+	return s.W.Flush()
+}
+
+// IngestSink posts batches to a Loom ingest endpoint as a sensor would. 429 and 5xx responses are
+// retried with backoff; other errors stop the replay.
+type IngestSink struct {
+	URL      string
+	Token    string
+	SensorID string // optional X-Spip-ID header
+	Client   *http.Client
+	Retries  int           // per batch; default 5
+	Backoff  time.Duration // first retry delay, doubled up to 30s; default 1s
+}
+
+// Send posts batch, retrying rate-limit and server errors.
+func (s IngestSink) Send(ctx context.Context, batch []event.Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	retries := s.Retries
+	if retries <= 0 {
+		retries = 5
+	}
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := s.post(ctx, client, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt == retries {
+			return err
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// post sends one request. retryAfter is negative when the error is not worth retrying.
+func (s IngestSink) post(ctx context.Context, client *http.Client, body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	if s.SensorID != "" {
+		req.Header.Set("X-Spip-ID", s.SensorID)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return 0, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("ingest %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs > 0 {
+			return time.Duration(secs) * time.Second, err
+		}
+		return 0, err
+	}
+	return -1, err
+}
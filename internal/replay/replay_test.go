@@ -0,0 +1,158 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+type memSink struct {
+	batches [][]event.Event
+	failAt  int // fail the n-th Send (1-based); 0 never
+}
+
+func (m *memSink) Send(_ context.Context, batch []event.Event) error {
+	if m.failAt > 0 && len(m.batches)+1 == m.failAt {
+		return os.ErrDeadlineExceeded
+	}
+	m.batches = append(m.batches, append([]event.Event(nil), batch...))
+	return nil
+}
+
+func writeFile(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun_BatchesInFileOrderAndDeletes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "002.ndjson", `{"n":3}`)
+	writeFile(t, dir, "001.ndjson", `{"n":1}`, ``, `{"n":2}`)
+	writeFile(t, dir, "notes.txt", `not an event`)
+
+	sink := &memSink{}
+	var reports []Progress
+	p, err := Run(context.Background(), sink, Options{Dir: dir, BatchSize: 2, Delete: true, Progress: func(p Progress) { reports = append(reports, p) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Files != 2 || p.FilesDone != 2 || p.Events != 3 {
+		t.Errorf("progress = %+v", p)
+	}
+	if len(sink.batches) != 2 || len(sink.batches[0]) != 2 || sink.batches[1][0]["n"] != float64(3) {
+		t.Errorf("batches = %v", sink.batches)
+	}
+	if len(reports) == 0 || !reports[len(reports)-1].Done {
+		t.Errorf("final progress not reported: %v", reports)
+	}
+	left, _ := Files(dir)
+	if len(left) != 0 {
+		t.Errorf("files left = %v", left)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error("non-NDJSON file removed")
+	}
+}
+
+func TestRun_FailureKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "001.ndjson", `{"n":1}`)
+	writeFile(t, dir, "002.ndjson", `{"n":2}`)
+	p, err := Run(context.Background(), &memSink{failAt: 2}, Options{Dir: dir, Delete: true})
+	if err == nil || !strings.Contains(err.Error(), "002.ndjson") {
+		t.Fatalf("err = %v, want failure on 002.ndjson", err)
+	}
+	if p.FilesDone != 1 {
+		t.Errorf("files done = %d, want 1", p.FilesDone)
+	}
+	if left, _ := Files(dir); len(left) != 1 {
+		t.Errorf("files left = %v, want the failed file", left)
+	}
+}
+
+func TestRun_BadLine(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "x.jsonl", `{"n":1}`, `{broken`)
+	if _, err := Run(context.Background(), &memSink{}, Options{Files: []string{path}}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("err = %v, want line 2", err)
+	}
+}
+
+func TestRun_RateLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "001.ndjson", `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`)
+	start := time.Now()
+	if _, err := Run(context.Background(), &memSink{}, Options{Dir: dir, BatchSize: 1, Rate: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 events at 20/s took %v, want >= 200ms", elapsed)
+	}
+}
+
+func TestIngestSink_RetriesRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tk" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var batch []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil || len(batch) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink := IngestSink{URL: srv.URL, Token: "tk", Backoff: time.Millisecond}
+	if err := sink.Send(context.Background(), []event.Event{{"n": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, want 2", calls.Load())
+	}
+	bad := IngestSink{URL: srv.URL, Token: "wrong", Backoff: time.Millisecond}
+	if err := bad.Send(context.Background(), []event.Event{{"n": 1}}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("err = %v, want 401 without retries", err)
+	}
+}
+
+func TestStream_UntilEOF(t *testing.T) {
+	batches := [][]event.Event{{{"n": 1}, {"n": 2}}, {}, {{"n": 3}}}
+	next := func(context.Context) ([]event.Event, error) {
+		if len(batches) == 0 {
+			return nil, io.EOF
+		}
+		b := batches[0]
+		batches = batches[1:]
+		return b, nil
+	}
+	sink := &memSink{}
+	p, err := Stream(context.Background(), sink, next, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Events != 3 || !p.Done || len(sink.batches) != 2 {
+		t.Errorf("progress = %+v, batches = %v", p, sink.batches)
+	}
+}
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Sender delivers a report.
+type Sender interface {
+	Send(ctx context.Context, r Report) error
+}
+
+// JSONPoster posts a value as JSON, like alert.Webhook.
+type JSONPoster interface {
+	Send(ctx context.Context, v interface{}) error
+}
+
+// Webhook delivers reports as JSON.
+type Webhook struct {
+	Poster JSONPoster
+}
+
+// Send implements Sender.
+func (w Webhook) Send(ctx context.Context, r Report) error {
+	return w.Poster.Send(ctx, r)
+}
+
+// Email delivers reports as an HTML mail over SMTP. The connection is upgraded with STARTTLS
+// when the server offers it; Username and Password, if set, authenticate with PLAIN, which the
+// client only does over TLS or to localhost.
+type Email struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Send implements Sender.
+func (e Email) Send(_ context.Context, r Report) error {
+	body, err := HTML(r)
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", r.Title()))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(body)
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := strings.Cut(e.Addr, ":")
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	return smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes())
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{"rows": rows}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="font-family: sans-serif">
+<h1>{{.Title}}</h1>
+<p>{{.Start.Format "2006-01-02 15:04"}} to {{.End.Format "2006-01-02 15:04"}} UTC: <b>{{.Events}}</b> events from <b>{{.UniqueSources}}</b> source IPs, <b>{{.NewScanners}}</b> of them never seen before.{{if .Truncated}} Some rare values were not counted.{{end}}</p>
+{{define "table"}}<table border="1" cellpadding="4" cellspacing="0"><tr><th>{{.Label}}</th><th>Events</th></tr>
+{{range .Rows}}<tr><td>{{.Value}}{{if .Name}} ({{.Name}}){{end}}</td><td align="right">{{.Events}}</td></tr>
+{{else}}<tr><td colspan="2">none</td></tr>
+{{end}}</table>{{end}}
+<h2>Top source IPs</h2>
+{{template "table" (rows "Source IP" .TopSources)}}
+<h2>Top ASNs</h2>
+{{template "table" (rows "ASN" .TopASNs)}}
+<h2>Top countries</h2>
+{{template "table" (rows "Country" .TopCountries)}}
+<h2>New scanners</h2>
+{{template "table" (rows "Source IP" .TopNewScanners)}}
+<h2>Busiest sensors</h2>
+{{template "table" (rows "Sensor" .TopSensors)}}
+</body></html>
+`))
+
+type table struct {
+	Label string
+	Rows  []Count
+}
+
+func rows(label string, counts []Count) table { return table{label, counts} }
+
+// HTML renders r as an HTML page.
+func HTML(r Report) ([]byte, error) {
+	var b bytes.Buffer
+	if err := htmlTemplate.Execute(&b, r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// NewPreview serves the report of the current period so far: JSON, or HTML with ?format=html.
+func NewPreview(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := c.Preview(time.Now())
+		if r.URL.Query().Get("format") == "html" {
+			page, err := HTML(rep)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write(page)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rep)
+	})
+}
+
+// Run cuts and delivers a report at the end of every period (see Next) until ctx is done. Failed
+// deliveries are logged; the report is not resent.
+func Run(ctx context.Context, c *Collector, hour int, senders []Sender, log zerolog.Logger) {
+	for {
+		end := Next(c.period, hour, time.Now())
+		timer := time.NewTimer(time.Until(end))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		r := c.Cut(end)
+		for _, s := range senders {
+			if err := s.Send(ctx, r); err != nil {
+				log.Warn().Err(err).Str("report", r.Title()).Msg("report delivery")
+			}
+		}
+		log.Info().Str("report", r.Title()).Int("events", r.Events).Msg("report sent")
+	}
+}
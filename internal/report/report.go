@@ -0,0 +1,227 @@
+// Package report summarizes the event stream per day or week (top source IPs, ASNs and countries,
+// new scanners, busiest sensors) and delivers the summary by webhook (JSON) or email (HTML).
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Count is one value and the number of events it had.
+type Count struct {
+	Value  string `json:"value"`
+	Name   string `json:"name,omitempty"` // e.g. the organization of an ASN
+	Events int    `json:"events"`
+}
+
+// Report summarizes the events of one period.
+type Report struct {
+	Period         string    `json:"period"` // "daily" or "weekly"
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	Events         int       `json:"events"`
+	UniqueSources  int       `json:"unique_sources"`
+	TopSources     []Count   `json:"top_sources"`
+	TopASNs        []Count   `json:"top_asns"`
+	TopCountries   []Count   `json:"top_countries"`
+	NewScanners    int       `json:"new_scanners"` // source IPs with loom.first_seen (enrichment.first_seen)
+	TopNewScanners []Count   `json:"top_new_scanners"`
+	TopSensors     []Count   `json:"top_sensors"`
+	// Truncated is set when more distinct values arrived than the collector tracks; counts of the
+	// values it did track are exact.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Title returns e.g. "Loom daily report 2026-03-01".
+func (r Report) Title() string {
+	return "Loom " + r.Period + " report " + r.Start.UTC().Format("2006-01-02")
+}
+
+// Collector counts the events of the current period.
+type Collector struct {
+	period  string
+	top     int
+	maxKeys int
+
+	mu        sync.Mutex
+	start     time.Time
+	events    int
+	sources   map[string]int
+	asns      map[string]int
+	asnNames  map[string]string
+	countries map[string]int
+	scanners  map[string]int
+	sensors   map[string]int
+	truncated bool
+}
+
+// NewCollector returns a collector for period ("daily" or "weekly") starting at start. Reports list
+// the top values of each kind (default 10); at most maxKeys values (default 100000) of each kind are
+// tracked.
+func NewCollector(period string, start time.Time, top, maxKeys int) *Collector {
+	if top <= 0 {
+		top = 10
+	}
+	if maxKeys <= 0 {
+		maxKeys = 100000
+	}
+	c := &Collector{period: period, top: top, maxKeys: maxKeys}
+	c.reset(start)
+	return c
+}
+
+func (c *Collector) reset(start time.Time) {
+	c.start, c.events, c.truncated = start, 0, false
+	c.sources = make(map[string]int)
+	c.asns = make(map[string]int)
+	c.asnNames = make(map[string]string)
+	c.countries = make(map[string]int)
+	c.scanners = make(map[string]int)
+	c.sensors = make(map[string]int)
+}
+
+// Observe counts ev, sent by sensorID. Nil-safe.
+func (c *Collector) Observe(sensorID string, ev event.Event) {
+	if c == nil || ev == nil {
+		return
+	}
+	ip := ev.SourceIP()
+	asn := ""
+	switch v := ev.Get("source.as.number").(type) {
+	case float64:
+		asn = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+	default:
+		asn = fmt.Sprint(v)
+	}
+	country := ev.GetString("source.geo.country_iso_code")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events++
+	c.count(c.sensors, sensorID)
+	if ip != "" {
+		c.count(c.sources, ip)
+		if firstSeenSource(ev) {
+			c.count(c.scanners, ip)
+		}
+	}
+	if asn != "" {
+		c.count(c.asns, asn)
+		if name := ev.GetString("source.as.organization.name"); name != "" {
+			if _, ok := c.asns[asn]; ok {
+				c.asnNames[asn] = name
+			}
+		}
+	}
+	if country != "" {
+		c.count(c.countries, country)
+	}
+}
+
+// count adds one to m[k], unless m already holds maxKeys other values. Called with mu held.
+func (c *Collector) count(m map[string]int, k string) {
+	if _, ok := m[k]; ok || len(m) < c.maxKeys {
+		m[k]++
+		return
+	}
+	c.truncated = true
+}
+
+// firstSeenSource reports whether first-seen tagging marked the event's source.ip as new.
+func firstSeenSource(ev event.Event) bool {
+	if seen, _ := ev.Get("loom.first_seen").(bool); !seen {
+		return false
+	}
+	switch fields := ev.Get("loom.first_seen_fields").(type) {
+	case []string:
+		for _, f := range fields {
+			if f == "source.ip" {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, f := range fields {
+			if f == "source.ip" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Preview returns the report of the current period so far.
+func (c *Collector) Preview(now time.Time) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.build(now)
+}
+
+// Cut returns the report of the period ending at end and starts the next period there.
+func (c *Collector) Cut(end time.Time) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.build(end)
+	c.reset(end)
+	return r
+}
+
+func (c *Collector) build(end time.Time) Report {
+	r := Report{
+		Period:         c.period,
+		Start:          c.start.UTC(),
+		End:            end.UTC(),
+		Events:         c.events,
+		UniqueSources:  len(c.sources),
+		TopSources:     topN(c.sources, c.top),
+		TopASNs:        topN(c.asns, c.top),
+		TopCountries:   topN(c.countries, c.top),
+		NewScanners:    len(c.scanners),
+		TopNewScanners: topN(c.scanners, c.top),
+		TopSensors:     topN(c.sensors, c.top),
+		Truncated:      c.truncated,
+	}
+	for i := range r.TopASNs {
+		r.TopASNs[i].Name = c.asnNames[r.TopASNs[i].Value]
+	}
+	return r
+}
+
+// topN returns the limit values with the most events, ties by value.
+func topN(m map[string]int, limit int) []Count {
+	out := make([]Count, 0, len(m))
+	for v, n := range m {
+		out = append(out, Count{Value: v, Events: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Events != out[j].Events {
+			return out[i].Events > out[j].Events
+		}
+		return out[i].Value < out[j].Value
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Next returns the end of the period that contains now: the next hour:00 UTC for "daily", and
+// the next Monday at hour:00 UTC for "weekly".
+func Next(period string, hour int, now time.Time) time.Time {
+	now = now.UTC()
+	t := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	if strings.EqualFold(period, "weekly") {
+		for t.Weekday() != time.Monday {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t
+}
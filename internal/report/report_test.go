@@ -0,0 +1,77 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func attack(ip string, asn float64, org, country string, firstSeen bool) event.Event {
+	ev := event.Event{"source": map[string]interface{}{
+		"ip":  ip,
+		"as":  map[string]interface{}{"number": asn, "organization": map[string]interface{}{"name": org}},
+		"geo": map[string]interface{}{"country_iso_code": country},
+	}}
+	if firstSeen {
+		ev.Set("loom.first_seen", true)
+		ev.Set("loom.first_seen_fields", []string{"source.ip"})
+	}
+	return ev
+}
+
+func TestCollector_CutReportsAndResets(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCollector("daily", start, 2, 2)
+	c.Observe("s1", attack("192.0.2.1", 4200000000, "<Evil> Hosting", "NL", true))
+	c.Observe("s1", attack("192.0.2.1", 4200000000, "<Evil> Hosting", "NL", false))
+	c.Observe("s2", attack("192.0.2.2", 64500, "Other", "DE", false))
+	c.Observe("s2", attack("192.0.2.3", 64501, "Third", "US", true))
+
+	r := c.Cut(start.Add(24 * time.Hour))
+	if r.Events != 4 || r.UniqueSources != 2 || !r.Truncated || r.NewScanners != 2 {
+		t.Errorf("report = %+v", r)
+	}
+	if len(r.TopSources) != 2 || r.TopSources[0] != (Count{Value: "192.0.2.1", Events: 2}) {
+		t.Errorf("top sources = %+v", r.TopSources)
+	}
+	if r.TopASNs[0].Value != "4200000000" || r.TopASNs[0].Name != "<Evil> Hosting" {
+		t.Errorf("top ASNs = %+v", r.TopASNs)
+	}
+	if len(r.TopSensors) != 2 || r.TopSensors[0].Value != "s1" || r.Title() != "Loom daily report 2026-03-01" {
+		t.Errorf("sensors = %+v, title %q", r.TopSensors, r.Title())
+	}
+
+	if next := c.Preview(start.Add(25 * time.Hour)); next.Events != 0 || next.Truncated || !next.Start.Equal(start.Add(24*time.Hour)) {
+		t.Errorf("after cut: %+v", next)
+	}
+
+	page, err := HTML(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "&lt;Evil&gt; Hosting") || strings.Contains(string(page), "<Evil>") {
+		t.Errorf("HTML does not escape event values:\n%s", page)
+	}
+}
+
+func TestNext(t *testing.T) {
+	wed := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC) // a Wednesday
+	for _, tc := range []struct {
+		period string
+		hour   int
+		now    time.Time
+		want   time.Time
+	}{
+		{"daily", 6, wed, time.Date(2026, 3, 5, 6, 0, 0, 0, time.UTC)},
+		{"daily", 12, wed, time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)},
+		{"daily", 10, wed, time.Date(2026, 3, 5, 10, 0, 0, 0, time.UTC)},
+		{"weekly", 0, wed, time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"weekly", 12, time.Date(2026, 3, 9, 11, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)},
+	} {
+		if got := Next(tc.period, tc.hour, tc.now); !got.Equal(tc.want) {
+			t.Errorf("Next(%s, %d, %v) = %v, want %v", tc.period, tc.hour, tc.now, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package retention keeps ClickHouse tables bounded by age, so operators do not need a separate cron
+// job. In "ttl" mode it sets a table TTL and leaves the deletion to ClickHouse merges; in "delete"
+// mode it periodically runs ALTER TABLE ... DELETE for rows older than the configured age.
+package retention
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/proxy"
+)
+
+// DefaultTimestamp is the row time used when Config.TimestampExpression is empty: the @timestamp of
+// the JSON event in the event column. It is 0 (1970) for rows without a parseable @timestamp; the
+// policy keeps those rather than deleting them as the oldest.
+const DefaultTimestamp = "parseDateTimeBestEffortOrZero(JSONExtractString(event, '@timestamp'))"
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Config configures a Manager.
+type Config struct {
+	URL      string
+	User     string
+	Password string
+	Database string // for table names without a database
+
+	Mode                string         // "ttl" (default) or "delete"
+	Tables              map[string]int // table (name or database.name) -> max age in days
+	TimestampExpression string         // ClickHouse expression for a row's time; default DefaultTimestamp
+	Interval            time.Duration  // between passes; default 1h
+	Proxy               string         // see the proxy package; "" honors HTTPS_PROXY
+}
+
+// Manager applies the retention policy to each configured table.
+type Manager struct {
+	cfg    Config
+	client *http.Client
+	tables []string // sorted, database-qualified
+	ages   map[string]int
+	guard  string // condition a row must also meet to be deleted; "" = none
+}
+
+// New validates cfg and returns a Manager.
+func New(cfg Config) (*Manager, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = "ttl"
+	}
+	if cfg.Mode != "ttl" && cfg.Mode != "delete" {
+		return nil, fmt.Errorf("retention: unknown mode %q", cfg.Mode)
+	}
+	if cfg.TimestampExpression == "" {
+		cfg.TimestampExpression = DefaultTimestamp
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	if cfg.Database == "" {
+		cfg.Database = "default"
+	}
+	transport, err := proxy.Transport(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{cfg: cfg, client: &http.Client{Timeout: 5 * time.Minute, Transport: transport}, ages: make(map[string]int)}
+	if cfg.TimestampExpression == DefaultTimestamp {
+		m.guard = DefaultTimestamp + " > 0"
+	}
+	for name, days := range cfg.Tables {
+		if !tableName.MatchString(name) {
+			return nil, fmt.Errorf("retention: invalid table name %q", name)
+		}
+		if days <= 0 {
+			return nil, fmt.Errorf("retention: max age of %s must be at least 1 day", name)
+		}
+		if !strings.Contains(name, ".") {
+			name = cfg.Database + "." + name
+		}
+		m.tables = append(m.tables, name)
+		m.ages[name] = days
+	}
+	sort.Strings(m.tables)
+	return m, nil
+}
+
+// Statement returns the ALTER TABLE statement applied to table (database-qualified).
+func (m *Manager) Statement(table string) string {
+	days := m.ages[table]
+	if m.cfg.Mode == "delete" {
+		stmt := fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s < now() - INTERVAL %d DAY", table, m.cfg.TimestampExpression, days)
+		if m.guard != "" {
+			stmt += " AND " + m.guard
+		}
+		return stmt
+	}
+	stmt := fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDateTime(%s) + INTERVAL %d DAY", table, m.cfg.TimestampExpression, days)
+	if m.guard != "" {
+		stmt += " DELETE WHERE " + m.guard
+	}
+	return stmt
+}
+
+// Apply runs one pass over the tables and calls report with each table's outcome (nil on success).
+// It returns the first error.
+func (m *Manager) Apply(ctx context.Context, report func(table string, err error)) error {
+	var first error
+	for _, table := range m.tables {
+		err := m.exec(ctx, m.Statement(table))
+		if report != nil {
+			report(table, err)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Run applies the policy now and then every Interval until ctx is done. Re-applying a TTL is cheap
+// (existing parts are not rewritten) and restores it if the table was changed by hand.
+func (m *Manager) Run(ctx context.Context, report func(table string, err error)) {
+	_ = m.Apply(ctx, report)
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = m.Apply(ctx, report)
+		}
+	}
+}
+
+func (m *Manager) exec(ctx context.Context, query string) error {
+	params := url.Values{}
+	if m.cfg.Mode == "ttl" {
+		// Old rows go with regular merges instead of rewriting every part now.
+		params.Set("materialize_ttl_after_modify", "0")
+	}
+	reqURL := strings.TrimSuffix(m.cfg.URL, "/") + "/?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, strings.NewReader(query))
+	if err != nil {
+		return err
+	}
+	if m.cfg.User != "" || m.cfg.Password != "" {
+		req.SetBasicAuth(m.cfg.User, m.cfg.Password)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("clickhouse: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("clickhouse %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,92 @@
+package retention
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeClickHouse struct {
+	mu      sync.Mutex
+	queries []string
+	params  []string
+}
+
+func (f *fakeClickHouse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.queries = append(f.queries, string(b))
+	f.params = append(f.params, r.URL.RawQuery)
+	f.mu.Unlock()
+	if strings.Contains(string(b), "missing") {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Code: 60. DB::Exception: Table default.missing does not exist"))
+	}
+}
+
+func TestManager_TTL(t *testing.T) {
+	f := &fakeClickHouse{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	m, err := New(Config{URL: srv.URL, Database: "loom", Tables: map[string]int{"ecs_raw": 30, "archive.alerts": 365}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reported []string
+	if err := m.Apply(context.Background(), func(table string, err error) {
+		if err != nil {
+			t.Errorf("%s: %v", table, err)
+		}
+		reported = append(reported, table)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 2 || reported[0] != "archive.alerts" || reported[1] != "loom.ecs_raw" {
+		t.Errorf("tables = %v", reported)
+	}
+	// Rows without a parseable @timestamp (0) are kept
+	want := "ALTER TABLE loom.ecs_raw MODIFY TTL toDateTime(" + DefaultTimestamp + ") + INTERVAL 30 DAY DELETE WHERE " + DefaultTimestamp + " > 0"
+	if f.queries[1] != want {
+		t.Errorf("query = %q\nwant    %q", f.queries[1], want)
+	}
+	if f.params[1] != "materialize_ttl_after_modify=0" {
+		t.Errorf("params = %q", f.params[1])
+	}
+}
+
+func TestManager_DeleteAndErrors(t *testing.T) {
+	f := &fakeClickHouse{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	m, err := New(Config{URL: srv.URL, Mode: "delete", TimestampExpression: "ts", Tables: map[string]int{"ecs_raw": 7, "missing": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]error{}
+	err = m.Apply(context.Background(), func(table string, err error) { failed[table] = err })
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("Apply = %v, want the missing table's error", err)
+	}
+	if failed["default.ecs_raw"] != nil || failed["default.missing"] == nil {
+		t.Errorf("outcomes = %v", failed)
+	}
+	if f.queries[0] != "ALTER TABLE default.ecs_raw DELETE WHERE ts < now() - INTERVAL 7 DAY" || f.params[0] != "" {
+		t.Errorf("query = %q (params %q)", f.queries[0], f.params[0])
+	}
+
+	m, _ = New(Config{Mode: "delete", Tables: map[string]int{"ecs_raw": 7}})
+	if got, want := m.Statement("default.ecs_raw"), "ALTER TABLE default.ecs_raw DELETE WHERE "+DefaultTimestamp+" < now() - INTERVAL 7 DAY AND "+DefaultTimestamp+" > 0"; got != want {
+		t.Errorf("default expression: %q\nwant %q", got, want)
+	}
+
+	if _, err := New(Config{Tables: map[string]int{"x; DROP TABLE y": 1}}); err == nil {
+		t.Error("expected error for invalid table name")
+	}
+	if _, err := New(Config{Mode: "truncate"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
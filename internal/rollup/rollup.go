@@ -0,0 +1,141 @@
+// Package rollup aggregates events into periodic summary events (per source IP,
+// per destination port, ...) so mass-scanning noise can be stored as counts.
+package rollup
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Aggregator counts events per value of each configured group-by field.
+type Aggregator struct {
+	mu      sync.Mutex
+	fields  []string
+	maxKeys int
+	start   time.Time
+	groups  map[groupKey]*group
+	nowFn   func() time.Time
+}
+
+type groupKey struct {
+	field string
+	value string
+}
+
+type group struct {
+	value     interface{}
+	events    int
+	sensors   map[string]struct{}
+	countries map[string]struct{}
+}
+
+// NewAggregator creates an aggregator grouping by the given dotted ECS fields (e.g. "source.ip", "destination.port").
+// maxKeys caps the number of distinct groups per interval (<= 0 defaults to 100000); events beyond it are not aggregated.
+func NewAggregator(fields []string, maxKeys int) *Aggregator {
+	if maxKeys <= 0 {
+		maxKeys = 100000
+	}
+	a := &Aggregator{
+		fields:  fields,
+		maxKeys: maxKeys,
+		groups:  make(map[groupKey]*group),
+		nowFn:   time.Now,
+	}
+	a.start = a.nowFn()
+	return a
+}
+
+// Observe adds the event to the current interval. It returns true if the event was counted in
+// every group-by field (i.e. it is fully represented by the rollup).
+func (a *Aggregator) Observe(sensorID string, ev event.Event) bool {
+	if ev == nil {
+		return false
+	}
+	country := ecs.GetString(ev, "source.geo.country_iso_code")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counted := len(a.fields) > 0
+	for _, field := range a.fields {
+		v := ecs.Get(ev, field)
+		if v == nil {
+			counted = false
+			continue
+		}
+		key := groupKey{field: field, value: fmt.Sprint(v)}
+		g, ok := a.groups[key]
+		if !ok {
+			if len(a.groups) >= a.maxKeys {
+				counted = false
+				continue
+			}
+			g = &group{
+				value:     v,
+				sensors:   make(map[string]struct{}),
+				countries: make(map[string]struct{}),
+			}
+			a.groups[key] = g
+		}
+		g.events++
+		if sensorID != "" {
+			g.sensors[sensorID] = struct{}{}
+		}
+		if country != "" {
+			g.countries[country] = struct{}{}
+		}
+	}
+	return counted
+}
+
+// Flush returns one aggregate event per group for the interval since the previous flush and starts a new interval.
+func (a *Aggregator) Flush() []event.Event {
+	a.mu.Lock()
+	groups := a.groups
+	start := a.start
+	end := a.nowFn()
+	a.groups = make(map[groupKey]*group)
+	a.start = end
+	a.mu.Unlock()
+
+	out := make([]event.Event, 0, len(groups))
+	for key, g := range groups {
+		ev := event.Event{
+			"@timestamp": end.UTC().Format(time.RFC3339Nano),
+			"event": map[string]interface{}{
+				"kind":    "metric",
+				"dataset": "loom.rollup",
+				"start":   start.UTC().Format(time.RFC3339Nano),
+				"end":     end.UTC().Format(time.RFC3339Nano),
+			},
+			"loom": map[string]interface{}{
+				"rollup": map[string]interface{}{
+					"field":        key.field,
+					"event_count":  g.events,
+					"sensor_count": len(g.sensors),
+					"sensors":      sortedKeys(g.sensors),
+					"countries":    sortedKeys(g.countries),
+				},
+			},
+		}
+		ecs.Set(ev, key.field, g.value)
+		out = append(out, ev)
+	}
+	return out
+}
+
+func sortedKeys(m map[string]struct{}) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = k
+	}
+	return out
+}
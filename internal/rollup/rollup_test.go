@@ -0,0 +1,81 @@
+package rollup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+func scanEvent(ip string, port float64, country string) map[string]interface{} {
+	return map[string]interface{}{
+		"source": map[string]interface{}{
+			"ip":  ip,
+			"geo": map[string]interface{}{"country_iso_code": country},
+		},
+		"destination": map[string]interface{}{"port": port},
+	}
+}
+
+func TestAggregator_CountsPerField(t *testing.T) {
+	a := NewAggregator([]string{"source.ip", "destination.port"}, 0)
+	a.Observe("spip-001", scanEvent("1.2.3.4", 22, "NL"))
+	a.Observe("spip-002", scanEvent("1.2.3.4", 23, "NL"))
+	a.Observe("spip-001", scanEvent("5.6.7.8", 22, "US"))
+
+	got := a.Flush()
+	if len(got) != 4 {
+		t.Fatalf("Flush returned %d aggregates, want 4", len(got))
+	}
+	var ip, port map[string]interface{}
+	for _, ev := range got {
+		if ecs.GetString(ev, "source.ip") == "1.2.3.4" {
+			ip = ev
+		}
+		if ecs.Get(ev, "destination.port") == float64(22) {
+			port = ev
+		}
+	}
+	if ip == nil || port == nil {
+		t.Fatal("missing expected aggregates")
+	}
+	if ecs.Get(ip, "loom.rollup.event_count") != 2 || ecs.Get(ip, "loom.rollup.sensor_count") != 2 {
+		t.Errorf("source.ip rollup = %v", ip["loom"])
+	}
+	if want := []interface{}{"NL", "US"}; !reflect.DeepEqual(ecs.Get(port, "loom.rollup.countries"), want) {
+		t.Errorf("destination.port countries = %v, want %v", ecs.Get(port, "loom.rollup.countries"), want)
+	}
+	if len(a.Flush()) != 0 {
+		t.Error("second Flush should start an empty interval")
+	}
+}
+
+func TestAggregator_IntervalBounds(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := NewAggregator([]string{"source.ip"}, 0)
+	a.nowFn = func() time.Time { return now }
+	a.start = now
+	a.Observe("s", scanEvent("1.2.3.4", 22, ""))
+	now = now.Add(time.Minute)
+	got := a.Flush()
+	if len(got) != 1 {
+		t.Fatalf("Flush returned %d aggregates, want 1", len(got))
+	}
+	if ecs.GetString(got[0], "event.start") != "2023-11-14T22:13:20Z" || ecs.GetString(got[0], "event.end") != "2023-11-14T22:14:20Z" {
+		t.Errorf("event = %v", got[0]["event"])
+	}
+}
+
+func TestAggregator_ObserveReportsCoverage(t *testing.T) {
+	a := NewAggregator([]string{"source.ip", "destination.port"}, 2)
+	if !a.Observe("s", scanEvent("1.2.3.4", 22, "")) {
+		t.Error("event with all group-by fields should be counted")
+	}
+	if a.Observe("s", map[string]interface{}{"source": map[string]interface{}{"ip": "1.2.3.4"}}) {
+		t.Error("event missing destination.port should not be fully counted")
+	}
+	if a.Observe("s", scanEvent("9.9.9.9", 80, "")) {
+		t.Error("event beyond max keys should not be fully counted")
+	}
+}
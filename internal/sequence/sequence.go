@@ -0,0 +1,56 @@
+// Package sequence numbers the events of sensors with ordered delivery, so their arrival order can
+// be kept through the output and recovered from the stored events (loom.sequence).
+package sequence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Field is where Stamp puts an event's sequence number.
+const Field = "loom.sequence"
+
+// Sequencer hands out per-sensor sequence numbers. Numbers start at the process start time in
+// microseconds, so they keep increasing across restarts unless a sensor averaged more than a million
+// events per second. A nil Sequencer numbers nothing.
+type Sequencer struct {
+	sensors map[string]*counter
+}
+
+type counter struct {
+	mu   sync.Mutex
+	next int64
+}
+
+// New returns a sequencer for sensorIDs, or nil when there are none.
+func New(sensorIDs []string) *Sequencer {
+	if len(sensorIDs) == 0 {
+		return nil
+	}
+	start := time.Now().UnixMicro()
+	s := &Sequencer{sensors: make(map[string]*counter, len(sensorIDs))}
+	for _, id := range sensorIDs {
+		s.sensors[id] = &counter{next: start}
+	}
+	return s
+}
+
+// Batch numbers a batch of events from sensorID. Call stamp on each event in the order they are
+// written to the output and done when the batch is written: the sensor's next batch waits until
+// then, so sequence numbers and output order agree. For other sensors both are no-ops.
+func (s *Sequencer) Batch(sensorID string) (stamp func(ev event.Event), done func()) {
+	var c *counter
+	if s != nil {
+		c = s.sensors[sensorID]
+	}
+	if c == nil {
+		return func(event.Event) {}, func() {}
+	}
+	c.mu.Lock()
+	return func(ev event.Event) {
+		ev.Set(Field, c.next)
+		c.next++
+	}, c.mu.Unlock
+}
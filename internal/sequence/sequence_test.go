@@ -0,0 +1,52 @@
+package sequence
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+)
+
+func TestSequencer_Batch(t *testing.T) {
+	s := New([]string{"spip-01"})
+
+	var mu sync.Mutex
+	var written []int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stamp, done := s.Batch("spip-01")
+			defer done()
+			for j := 0; j < 10; j++ {
+				ev := map[string]interface{}{}
+				stamp(ev)
+				mu.Lock()
+				written = append(written, ecs.Get(ev, Field).(int64))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 1; i < len(written); i++ {
+		if written[i] != written[i-1]+1 {
+			t.Fatalf("sequence not consecutive in write order at %d: %v", i, written)
+		}
+	}
+
+	ev := map[string]interface{}{}
+	stamp, done := s.Batch("other")
+	stamp(ev)
+	done()
+	if len(ev) != 0 {
+		t.Errorf("sensor without ordered delivery stamped: %v", ev)
+	}
+	var none *Sequencer
+	stamp, done = none.Batch("spip-01")
+	stamp(ev)
+	done()
+	if New(nil) != nil || len(ev) != 0 {
+		t.Error("nil sequencer must not stamp")
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// writeTestCert writes a self-signed certificate for host to dir and returns the pair.
+func writeTestCert(t *testing.T, dir, name, host string, serial int64) CertPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := CertPair{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	if err := os.WriteFile(p.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCertStore_SNISelection(t *testing.T) {
+	dir := t.TempDir()
+	def := writeTestCert(t, dir, "default", "loom.example.com", 1)
+	other := writeTestCert(t, dir, "other", "ingest.example.org", 2)
+	s, err := NewCertStore([]CertPair{def, other}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sni    string
+		serial int64
+	}{
+		{"ingest.example.org", 2},
+		{"INGEST.example.org.", 2},
+		{"loom.example.com", 1},
+		{"unknown.example.net", 1},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		c, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.sni})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Leaf.SerialNumber.Int64(); got != tt.serial {
+			t.Errorf("SNI %q: serial = %d, want %d", tt.sni, got, tt.serial)
+		}
+	}
+}
+
+func TestCertStore_ReloadChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTestCert(t, dir, "default", "loom.example.com", 1)
+	s, err := NewCertStore([]CertPair{p}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestCert(t, dir, "default", "loom.example.com", 42)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(p.CertFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	s.Reload()
+
+	c, _ := s.GetCertificate(&tls.ClientHelloInfo{})
+	if got := c.Leaf.SerialNumber.Int64(); got != 42 {
+		t.Errorf("serial after reload = %d, want 42", got)
+	}
+}
+
+func TestCertStore_ReloadKeepsPreviousOnError(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTestCert(t, dir, "default", "loom.example.com", 7)
+	s, err := NewCertStore([]CertPair{p}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.CertFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(p.CertFile, future, future)
+	s.Reload()
+
+	c, _ := s.GetCertificate(&tls.ClientHelloInfo{})
+	if got := c.Leaf.SerialNumber.Int64(); got != 7 {
+		t.Errorf("serial after failed reload = %d, want previous 7", got)
+	}
+}
+
+func TestNewCertStore_NoPairs(t *testing.T) {
+	if _, err := NewCertStore(nil, zerolog.Nop()); err == nil {
+		t.Fatal("expected error for no certificates")
+	}
+}
+
+func TestHarden_RefusesTLS12(t *testing.T) {
+	s, err := NewCertStore([]CertPair{writeTestCert(t, t.TempDir(), "default", "loom.example.com", 1)}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &tls.Config{GetCertificate: s.GetCertificate}
+	Harden(cfg)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.(*tls.Conn).Handshake()
+			_ = c.Close()
+		}
+	}()
+	handshake := func(maxVersion uint16) (uint16, error) {
+		c, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion})
+		if err != nil {
+			return 0, err
+		}
+		defer c.Close()
+		return c.ConnectionState().Version, nil
+	}
+	if v, err := handshake(tls.VersionTLS13); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("TLS 1.3 handshake: version %x, %v", v, err)
+	}
+	if _, err := handshake(tls.VersionTLS12); err == nil {
+		t.Error("TLS 1.2 handshake succeeded")
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter_Allowed(t *testing.T) {
+	f, err := NewIPFilter([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"}, []string{"10.66.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"10.66.1.1":       false, // deny wins over allow
+		"192.0.2.7":       true,
+		"192.0.2.8":       false,
+		"::ffff:10.1.2.3": true,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"198.51.100.1":    false,
+	} {
+		if got := f.Allowed(net.ParseIP(ip)); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestIPFilter_DenyOnly(t *testing.T) {
+	f, err := NewIPFilter(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Allowed(net.ParseIP("198.51.100.1")) || f.Allowed(net.ParseIP("203.0.113.9")) {
+		t.Error("deny-only filter should allow everything except the denied range")
+	}
+}
+
+func TestNewIPFilter_Invalid(t *testing.T) {
+	if _, err := NewIPFilter([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	if f, err := NewIPFilter(nil, nil); f != nil || err != nil {
+		t.Errorf("empty lists: got %v, %v; want nil filter", f, err)
+	}
+}
+
+func TestIPFilter_Middleware(t *testing.T) {
+	f, _ := NewIPFilter([]string{"10.0.0.0/8"}, nil)
+	h := f.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for remote, want := range map[string]int{
+		"10.0.0.1:5555":     http.StatusNoContent,
+		"198.51.100.1:5555": http.StatusForbidden,
+		"@":                 http.StatusNoContent, // unix socket peer
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+		req.RemoteAddr = remote
+		// X-Forwarded-For must not bypass the filter
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", remote, rec.Code, want)
+		}
+	}
+}
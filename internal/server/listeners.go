@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Listener is one ingest listen address. Address is host:port for TCP or "unix:/path/to.sock"
+// for a Unix domain socket (e.g. behind a local reverse proxy).
+type Listener struct {
+	Address string
+	TLS     bool
+}
+
+// unixSocketMode is applied to ingest sockets so a reverse proxy in the same group can connect.
+const unixSocketMode = 0o660
+
+// network splits Address into the net.Listen network and address.
+func (l Listener) network() (string, string) {
+	if path, ok := strings.CutPrefix(l.Address, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", l.Address
+}
+
+// listen opens the listener with the given TCP keep-alive period (0: default, negative: off).
+// A stale socket file left by a previous run is removed first.
+func (l Listener) listen(keepAlive time.Duration) (net.Listener, error) {
+	network, addr := l.network()
+	if network == "unix" {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(addr)
+		}
+	}
+	lc := net.ListenConfig{KeepAlive: keepAlive}
+	ln, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", l.Address, err)
+	}
+	if network == "unix" {
+		if err := os.Chmod(addr, unixSocketMode); err != nil {
+			_ = ln.Close()
+			return nil, fmt.Errorf("listen %s: %w", l.Address, err)
+		}
+	}
+	return ln, nil
+}
+
+// listeners returns the configured listeners, or ListenAddr (TLS when certificates are configured).
+func (s *Server) listeners() []Listener {
+	if len(s.Listeners) > 0 {
+		return s.Listeners
+	}
+	return []Listener{{Address: s.ListenAddr, TLS: s.tlsConfig() != nil}}
+}
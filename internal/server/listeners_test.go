@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListener_Network(t *testing.T) {
+	for _, tc := range []struct {
+		addr, network, want string
+	}{
+		{":8443", "tcp", ":8443"},
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"unix:/run/loom/ingest.sock", "unix", "/run/loom/ingest.sock"},
+	} {
+		network, addr := Listener{Address: tc.addr}.network()
+		if network != tc.network || addr != tc.want {
+			t.Errorf("%q: got %s %s, want %s %s", tc.addr, network, addr, tc.network, tc.want)
+		}
+	}
+}
+
+func TestListener_UnixSocketReplacesStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.sock")
+	// Simulate a socket file left behind by a crashed process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	ln, err := Listener{Address: "unix:" + path}.listen(0)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != unixSocketMode {
+		t.Errorf("socket mode = %v, want %v", fi.Mode().Perm(), os.FileMode(unixSocketMode))
+	}
+}
+
+func TestServer_ListenersDefault(t *testing.T) {
+	s := &Server{ListenAddr: ":8080"}
+	got := s.listeners()
+	if len(got) != 1 || got[0].Address != ":8080" || got[0].TLS {
+		t.Errorf("listeners = %+v", got)
+	}
+	s.Listeners = []Listener{{Address: ":8443", TLS: true}, {Address: "127.0.0.1:8080"}}
+	if got := s.listeners(); len(got) != 2 {
+		t.Errorf("listeners = %+v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds Prometheus metrics for the ingest HTTP server.
+type Metrics struct {
+	Duration *prometheus.HistogramVec
+	InFlight *prometheus.GaugeVec
+}
+
+// NewMetrics creates and registers HTTP server metrics. Labels are the route pattern, method and
+// status code (bounded); never the raw path or client address.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "loom_http_request_duration_seconds",
+				Help:    "Ingest HTTP request latency by route, method and status",
+				Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+			},
+			[]string{"route", "method", "status"}),
+		InFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "loom_http_requests_in_flight", Help: "Ingest HTTP requests currently being served by route"},
+			[]string{"route"}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.Duration, m.InFlight)
+	}
+	return m
+}
+
+// instrument wraps next with latency and in-flight tracking for route. Nil-safe: returns next.
+func (m *Metrics) instrument(route string, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	inFlight := m.InFlight.WithLabelValues(route)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+		ww, ok := w.(middleware.WrapResponseWriter)
+		if !ok {
+			ww = middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		}
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.Duration.WithLabelValues(route, r.Method, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics_Instrument(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+	var inFlightDuring float64
+	h := m.instrument("/ingest", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlightDuring = gaugeValue(t, reg, "loom_http_requests_in_flight")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingest", nil))
+
+	if inFlightDuring != 1 {
+		t.Errorf("in-flight during request = %v, want 1", inFlightDuring)
+	}
+	if v := gaugeValue(t, reg, "loom_http_requests_in_flight"); v != 0 {
+		t.Errorf("in-flight after request = %v, want 0", v)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "loom_http_request_duration_seconds" {
+			continue
+		}
+		metric := mf.GetMetric()[0]
+		labels := map[string]string{}
+		for _, lp := range metric.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if labels["route"] != "/ingest" || labels["method"] != "POST" || labels["status"] != "204" {
+			t.Errorf("labels = %v", labels)
+		}
+		if metric.GetHistogram().GetSampleCount() != 1 {
+			t.Errorf("sample count = %d", metric.GetHistogram().GetSampleCount())
+		}
+		return
+	}
+	t.Error("loom_http_request_duration_seconds not found")
+}
+
+func TestMetrics_NilInstrumentPassesThrough(t *testing.T) {
+	var m *Metrics
+	called := false
+	m.instrument("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	if !called {
+		t.Error("handler not called")
+	}
+}
+
+func gaugeValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == name {
+			return mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return -1
+}
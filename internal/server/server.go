@@ -3,46 +3,136 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/StefanGrimminck/Loom/internal/ingest"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/StefanGrimminck/Loom/internal/ingest"
 	"github.com/rs/zerolog"
+	"golang.org/x/net/http2"
 )
 
 // Server runs the ingest API and optional management (health, metrics).
 type Server struct {
-	IngestHandler  http.Handler
-	EnricherReady  func() bool
-	OutputReady    func() bool
-	MetricsHandler http.Handler
-	Logger         zerolog.Logger
-	TLSConfig      *tls.Config
-	CertFile       string
-	KeyFile        string
-	ListenAddr     string
-	ManagementAddr string
+	IngestHandler http.Handler
+	// ArtifactHandler, if set, takes artifact uploads at POST /api/v1/artifacts on the ingest listeners.
+	ArtifactHandler http.Handler
+	// BackfillHandler, if set, takes bulk replays at POST /api/v1/ingest/backfill on the ingest listeners.
+	BackfillHandler http.Handler
+	EnricherReady   func() bool
+	OutputReady     func() bool
+	Draining        func() bool // optional: /ready reports 503 while true
+	MetricsHandler  http.Handler
+	Metrics         *Metrics     // optional HTTP latency and in-flight metrics for the ingest routes
+	VersionHandler  http.Handler // optional: GET /version on the management port
+	ConfigHandler   http.Handler // optional: GET /config (effective, redacted) on the management port
+	AdminHandler    http.Handler // optional: /admin/* on the management port (handles its own auth)
+	APIHandler      http.Handler // optional: /api/v1/* query API on the management port (handles its own auth)
+	Dashboard       http.Handler // optional: GET /dashboard on the management port
+	IntelHandler    http.Handler // optional: /intel/* (STIX bundle, TAXII) on the management port (handles its own auth)
+	Logger          zerolog.Logger
+	TLSConfig       *tls.Config
+	CertFile        string
+	KeyFile         string
+	ListenAddr      string
+	Listeners       []Listener // when set, replaces ListenAddr
+	ManagementAddr  string
+	Timeouts        Timeouts
+	Conn            ConnOptions
+	IPFilter        *IPFilter // optional CIDR allow/deny list enforced before auth
+	OnReady         func()    // optional: called once every ingest listener is accepting connections
+	// AccessLogSampleEvery logs 1 in N successful requests at info level (others at debug);
+	// 0 defaults to 1, negative logs successful requests at debug only. Errors are always logged.
+	AccessLogSampleEvery int
+
+	mu       sync.Mutex // guards the fields below, for Alive
+	serving  int        // ingest listeners serving
+	stopped  int        // ingest listeners whose Serve returned
+	mgmtAddr net.Addr   // management listener, once open
+}
+
+// Timeouts tunes the ingest listener. Zero values use the defaults below.
+type Timeouts struct {
+	Read           time.Duration // default 30s
+	ReadHeader     time.Duration // default 10s
+	Write          time.Duration // default 60s
+	Idle           time.Duration // default 120s
+	MaxHeaderBytes int           // default 1 MiB
+	ShutdownGrace  time.Duration // default 15s; in-flight requests get this long to finish
+}
+
+// ConnOptions tunes connection handling on the ingest listeners.
+type ConnOptions struct {
+	DisableHTTP2         bool          // serve HTTP/1.1 only on TLS listeners
+	MaxConcurrentStreams uint32        // HTTP/2 streams per connection; 0 = 250
+	DisableKeepAlives    bool          // close each connection after one request
+	TCPKeepAlive         time.Duration // TCP keep-alive probe period; 0 = 15s, negative disables
+}
+
+func (t Timeouts) withDefaults() Timeouts {
+	if t.Read <= 0 {
+		t.Read = 30 * time.Second
+	}
+	if t.ReadHeader <= 0 {
+		t.ReadHeader = 10 * time.Second
+	}
+	if t.Write <= 0 {
+		t.Write = 60 * time.Second
+	}
+	if t.Idle <= 0 {
+		t.Idle = 120 * time.Second
+	}
+	if t.MaxHeaderBytes <= 0 {
+		t.MaxHeaderBytes = 1 << 20
+	}
+	if t.ShutdownGrace <= 0 {
+		t.ShutdownGrace = 15 * time.Second
+	}
+	return t
 }
 
-// Run starts the ingest server (HTTPS) and optionally management server (HTTP on separate port).
+// Run starts the ingest server (HTTPS) on each listener and optionally management server (HTTP on separate port).
 func (s *Server) Run(ctx context.Context) error {
+	sampleEvery := s.AccessLogSampleEvery
+	if sampleEvery == 0 {
+		sampleEvery = 1
+	}
 	ingestRouter := chi.NewRouter()
-	ingestRouter.Use(middleware.RealIP, middleware.Recoverer, requestLogger(s.Logger))
+	// IP filter runs first, on the connection's peer address (before RealIP rewrites it); the
+	// peer address is also kept for ingest.Transport
+	ingestRouter.Use(s.IPFilter.middleware, ingest.RecordPeer, middleware.RealIP, middleware.Recoverer, requestLogger(s.Logger, sampleEvery))
 	// Ingest: multiple paths accepted (/api/v1/ingest, /ingest, /) for client flexibility
-	ingestRouter.Post("/api/v1/ingest", s.IngestHandler.ServeHTTP)
-	ingestRouter.Post("/ingest", s.IngestHandler.ServeHTTP)
-	ingestRouter.Post("/", s.IngestHandler.ServeHTTP)
+	for _, route := range []string{"/api/v1/ingest", "/ingest", "/"} {
+		ingestRouter.Method(http.MethodPost, route, s.Metrics.instrument(route, s.IngestHandler))
+	}
+	if s.BackfillHandler != nil {
+		ingestRouter.Method(http.MethodPost, ingest.BackfillPath, s.Metrics.instrument(ingest.BackfillPath, s.BackfillHandler))
+	}
+	if s.ArtifactHandler != nil {
+		ingestRouter.Method(http.MethodPost, "/api/v1/artifacts", s.Metrics.instrument("/api/v1/artifacts", s.ArtifactHandler))
+	}
 
+	timeouts := s.Timeouts.withDefaults()
 	ingestSrv := &http.Server{
 		Addr:              s.ListenAddr,
-		Handler:            ingestRouter,
-		TLSConfig:          s.tlsConfig(),
-		ReadTimeout:        30 * time.Second,
-		ReadHeaderTimeout:  10 * time.Second,
-		WriteTimeout:       60 * time.Second,
-		IdleTimeout:        120 * time.Second,
+		Handler:           ingestRouter,
+		TLSConfig:         s.tlsConfig(),
+		ReadTimeout:       timeouts.Read,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		MaxHeaderBytes:    timeouts.MaxHeaderBytes,
+	}
+	ingestSrv.SetKeepAlivesEnabled(!s.Conn.DisableKeepAlives)
+	listeners := s.listeners()
+	if err := s.configureHTTP2(ingestSrv, listeners, timeouts.Idle); err != nil {
+		return err
 	}
 
 	if s.ManagementAddr != "" {
@@ -53,6 +143,24 @@ func (s *Server) Run(ctx context.Context) error {
 		if s.MetricsHandler != nil {
 			mgmt.Handle("/metrics", s.MetricsHandler)
 		}
+		if s.VersionHandler != nil {
+			mgmt.Handle("/version", s.VersionHandler)
+		}
+		if s.ConfigHandler != nil {
+			mgmt.Handle("/config", s.ConfigHandler)
+		}
+		if s.AdminHandler != nil {
+			mgmt.Mount("/admin", s.AdminHandler)
+		}
+		if s.APIHandler != nil {
+			mgmt.Mount("/api/v1", s.APIHandler)
+		}
+		if s.Dashboard != nil {
+			mgmt.Get("/dashboard", s.Dashboard.ServeHTTP)
+		}
+		if s.IntelHandler != nil {
+			mgmt.Mount("/intel", s.IntelHandler)
+		}
 		mgmtSrv := &http.Server{
 			Addr:              s.ManagementAddr,
 			Handler:           mgmt,
@@ -62,8 +170,16 @@ func (s *Server) Run(ctx context.Context) error {
 			IdleTimeout:       30 * time.Second,
 		}
 		go func() {
+			ln, err := net.Listen("tcp", s.ManagementAddr)
+			if err != nil {
+				s.Logger.Error().Err(err).Str("addr", s.ManagementAddr).Msg("management server")
+				return
+			}
+			s.mu.Lock()
+			s.mgmtAddr = ln.Addr()
+			s.mu.Unlock()
 			s.Logger.Info().Str("addr", s.ManagementAddr).Msg("management server listening")
-			_ = mgmtSrv.ListenAndServe()
+			_ = mgmtSrv.Serve(ln)
 		}()
 		defer func() {
 			mgmtCtx, mgmtCancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -72,29 +188,95 @@ func (s *Server) Run(ctx context.Context) error {
 		}()
 	}
 
-	errCh := make(chan error, 1)
-	go func() {
-		if s.CertFile != "" && s.KeyFile != "" {
-			s.Logger.Info().Str("addr", s.ListenAddr).Msg("ingest server (HTTPS) listening")
-			errCh <- ingestSrv.ListenAndServeTLS(s.CertFile, s.KeyFile)
-		} else {
-			s.Logger.Info().Str("addr", s.ListenAddr).Msg("ingest server listening (no TLS)")
-			errCh <- ingestSrv.ListenAndServe()
+	errCh := make(chan error, len(listeners))
+	opened := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		ln, err := l.listen(s.Conn.TCPKeepAlive)
+		if err != nil {
+			for _, o := range opened {
+				_ = o.Close()
+			}
+			return err
 		}
-	}()
+		opened = append(opened, ln)
+	}
+	s.mu.Lock()
+	s.serving = len(opened)
+	s.mu.Unlock()
+	if s.OnReady != nil {
+		s.OnReady()
+	}
+	for i, l := range listeners {
+		go func(l Listener, ln net.Listener) {
+			defer func() {
+				s.mu.Lock()
+				s.stopped++
+				s.mu.Unlock()
+			}()
+			switch {
+			case !l.TLS:
+				s.Logger.Info().Str("addr", l.Address).Msg("ingest server listening (no TLS)")
+				errCh <- ingestSrv.Serve(ln)
+			case s.TLSConfig != nil && s.TLSConfig.GetCertificate != nil:
+				// Certificates come from TLSConfig (SNI selection, hot reload)
+				s.Logger.Info().Str("addr", l.Address).Msg("ingest server (HTTPS) listening")
+				errCh <- ingestSrv.ServeTLS(ln, "", "")
+			default:
+				s.Logger.Info().Str("addr", l.Address).Msg("ingest server (HTTPS) listening")
+				errCh <- ingestSrv.ServeTLS(ln, s.CertFile, s.KeyFile)
+			}
+		}(l, opened[i])
+	}
 	select {
 	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeouts.ShutdownGrace)
 		defer cancel()
 		if err := ingestSrv.Shutdown(shutdownCtx); err != nil {
 			s.Logger.Warn().Err(err).Msg("ingest server shutdown")
 		}
 		return nil
 	case err := <-errCh:
+		_ = ingestSrv.Close()
 		return err
 	}
 }
 
+// Alive reports whether the server is working: every ingest listener is still serving and, when
+// ManagementAddr is set, the management server answers GET /live. The systemd watchdog asks it
+// before each ping.
+func (s *Server) Alive(ctx context.Context) error {
+	s.mu.Lock()
+	serving, stopped, mgmtAddr := s.serving, s.stopped, s.mgmtAddr
+	s.mu.Unlock()
+	switch {
+	case serving == 0:
+		return errors.New("ingest listeners not open")
+	case stopped > 0:
+		return fmt.Errorf("%d of %d ingest listeners stopped serving", stopped, serving)
+	case s.ManagementAddr == "":
+		return nil
+	case mgmtAddr == nil:
+		return errors.New("management listener not open")
+	}
+	addr := mgmtAddr.String()
+	if tcp, ok := mgmtAddr.(*net.TCPAddr); ok && tcp.IP.IsUnspecified() {
+		addr = net.JoinHostPort("localhost", fmt.Sprint(tcp.Port))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/live", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("management server: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("management server: /live returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func (s *Server) serveLiveness(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
@@ -111,22 +293,48 @@ func (s *Server) serveReadiness(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("output not ready"))
 		return
 	}
+	if s.Draining != nil && s.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("draining"))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
 
-func requestLogger(log zerolog.Logger) func(next http.Handler) http.Handler {
+// requestLogger writes an info-level access log per request. Non-2xx responses are always logged;
+// 2xx responses are logged 1 in sampleEvery (<= 0: every request is logged at debug level only).
+func requestLogger(log zerolog.Logger, sampleEvery int) func(next http.Handler) http.Handler {
+	var ok2xx atomic.Uint64
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(ingest.WithRequestID(w, r))
+			ctx, sensorID := ingest.WithSensorSlot(r.Context())
+			r = r.WithContext(ctx)
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 			start := time.Now()
 			next.ServeHTTP(ww, r)
-			log.Debug().
+
+			status := ww.Status()
+			ev := log.Info()
+			if status >= 200 && status < 300 {
+				if sampleEvery <= 0 || (ok2xx.Add(1)-1)%uint64(sampleEvery) != 0 {
+					ev = log.Debug()
+				}
+			} else if status >= 500 {
+				ev = log.Warn()
+			}
+			ev.Str("remote_ip", r.RemoteAddr).
+				Str("request_id", ingest.RequestID(r.Context())).
+				Str("sensor_id", sensorID()).
 				Str("method", r.Method).
 				Str("path", r.URL.Path).
-				Int("status", ww.Status()).
+				Int("status", status).
+				Int64("bytes_in", r.ContentLength).
+				Int("bytes_out", ww.BytesWritten()).
 				Dur("duration", time.Since(start)).
-				Msg("request")
+				Str("user_agent", r.UserAgent()).
+				Msg("access")
 		})
 	}
 }
@@ -136,6 +344,25 @@ type IngestHandler interface {
 	ServeHTTP(http.ResponseWriter, *http.Request)
 }
 
+// configureHTTP2 enables HTTP/2 with the configured stream limit when any listener serves TLS,
+// or restricts TLS listeners to HTTP/1.1 when HTTP/2 is disabled.
+func (s *Server) configureHTTP2(srv *http.Server, listeners []Listener, idle time.Duration) error {
+	if s.Conn.DisableHTTP2 {
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	for _, l := range listeners {
+		if l.TLS {
+			streams := s.Conn.MaxConcurrentStreams
+			if streams == 0 {
+				streams = 250
+			}
+			return http2.ConfigureServer(srv, &http2.Server{MaxConcurrentStreams: streams, IdleTimeout: idle})
+		}
+	}
+	return nil
+}
+
 func (s *Server) tlsConfig() *tls.Config {
 	if s.TLSConfig != nil {
 		return s.TLSConfig
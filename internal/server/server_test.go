@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func accessLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestRequestLogger_SamplesSuccessAlwaysLogsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf).Level(zerolog.InfoLevel)
+	status := http.StatusNoContent
+	h := requestLogger(log, 3)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	for i := 0; i < 6; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/ingest", nil))
+	}
+	if n := len(accessLines(t, &buf)); n != 2 {
+		t.Errorf("2xx logged %d times out of 6 with sample 1/3, want 2", n)
+	}
+
+	buf.Reset()
+	status = http.StatusUnauthorized
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/ingest", nil))
+	}
+	lines := accessLines(t, &buf)
+	if len(lines) != 3 {
+		t.Fatalf("non-2xx logged %d times, want 3", len(lines))
+	}
+	if lines[0]["status"] != float64(401) || lines[0]["path"] != "/ingest" {
+		t.Errorf("access log = %v", lines[0])
+	}
+}
+
+func TestConfigureHTTP2(t *testing.T) {
+	tlsListener := []Listener{{Address: ":8443", TLS: true}}
+
+	s := &Server{Conn: ConnOptions{MaxConcurrentStreams: 32}}
+	srv := &http.Server{}
+	if err := s.configureHTTP2(srv, tlsListener, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if srv.TLSConfig == nil || len(srv.TLSConfig.NextProtos) == 0 || srv.TLSConfig.NextProtos[0] != "h2" {
+		t.Errorf("h2 not advertised: %+v", srv.TLSConfig)
+	}
+
+	s = &Server{Conn: ConnOptions{DisableHTTP2: true}}
+	srv = &http.Server{}
+	if err := s.configureHTTP2(srv, tlsListener, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if srv.TLSNextProto == nil || len(srv.TLSNextProto) != 0 {
+		t.Error("disabled HTTP/2: TLSNextProto should be an empty non-nil map")
+	}
+
+	s = &Server{}
+	srv = &http.Server{}
+	if err := s.configureHTTP2(srv, []Listener{{Address: ":8080"}}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if srv.TLSConfig != nil {
+		t.Error("plaintext listeners only: TLS config should not be touched")
+	}
+}
+
+func TestServer_Alive(t *testing.T) {
+	s := &Server{
+		IngestHandler:  http.NotFoundHandler(),
+		Listeners:      []Listener{{Address: "127.0.0.1:0"}},
+		ManagementAddr: "127.0.0.1:0",
+		Logger:         zerolog.Nop(),
+	}
+	if err := s.Alive(context.Background()); err == nil {
+		t.Error("Alive before Run should fail")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for err := s.Alive(ctx); err != nil; err = s.Alive(ctx) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Alive: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Alive(context.Background()); err == nil {
+		t.Error("Alive after the listeners stopped should fail")
+	}
+}
@@ -0,0 +1,191 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Tracker groups events from the same sensor and source IP into sessions.
+// A session stays open while events keep arriving within the idle timeout; when it
+// expires, Expire returns a summary event for it.
+type Tracker struct {
+	mu          sync.Mutex
+	idleTimeout time.Duration
+	maxSessions int
+	sessions    map[sessionKey]*sessionState
+	closed      []*sessionState // expired sessions replaced before the next Expire
+	nowFn       func() time.Time
+}
+
+type sessionKey struct {
+	sensorID string
+	sourceIP string
+}
+
+type sessionState struct {
+	id       string
+	sensorID string
+	sourceIP string
+	first    time.Time // event time of the first event
+	last     time.Time // event time of the latest event
+	lastSeen time.Time // wall clock of the latest event, used for expiry
+	events   int
+	source   map[string]interface{}
+}
+
+// NewTracker creates a session tracker. idleTimeout <= 0 defaults to 5 minutes; maxSessions <= 0 defaults to 100000.
+// When maxSessions open sessions exist, events for new source IPs are passed through without a session.
+func NewTracker(idleTimeout time.Duration, maxSessions int) *Tracker {
+	if idleTimeout <= 0 {
+		idleTimeout = 5 * time.Minute
+	}
+	if maxSessions <= 0 {
+		maxSessions = 100000
+	}
+	return &Tracker{
+		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+		sessions:    make(map[sessionKey]*sessionState),
+		nowFn:       time.Now,
+	}
+}
+
+// Observe assigns session.id to the event and updates the session counters.
+// Events without source.ip are left unchanged.
+func (t *Tracker) Observe(sensorID string, ev event.Event) {
+	if ev == nil {
+		return
+	}
+	source, _ := ev["source"].(map[string]interface{})
+	ip, _ := source["ip"].(string)
+	if ip == "" {
+		return
+	}
+	now := t.nowFn()
+	ts := eventTime(ev, now)
+	key := sessionKey{sensorID: sensorID, sourceIP: ip}
+
+	t.mu.Lock()
+	st, ok := t.sessions[key]
+	if ok && now.Sub(st.lastSeen) > t.idleTimeout {
+		// Expired but not yet swept: report it on the next Expire and start a new one.
+		t.closed = append(t.closed, st)
+		delete(t.sessions, key)
+		ok = false
+	}
+	if !ok {
+		if len(t.sessions) >= t.maxSessions {
+			t.mu.Unlock()
+			return
+		}
+		st = &sessionState{id: newID(), sensorID: sensorID, sourceIP: ip, first: ts, last: ts}
+		t.sessions[key] = st
+	}
+	st.events++
+	st.lastSeen = now
+	if ts.Before(st.first) {
+		st.first = ts
+	}
+	if ts.After(st.last) {
+		st.last = ts
+	}
+	st.source = source
+	id := st.id
+	t.mu.Unlock()
+
+	sess, _ := ev["session"].(map[string]interface{})
+	if sess == nil {
+		sess = make(map[string]interface{})
+		ev["session"] = sess
+	}
+	sess["id"] = id
+}
+
+// Expire closes sessions idle for longer than the idle timeout and returns one summary event per closed session.
+func (t *Tracker) Expire() []event.Event {
+	now := t.nowFn()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]event.Event, 0, len(t.closed))
+	for _, st := range t.closed {
+		out = append(out, summary(st))
+	}
+	t.closed = nil
+	for key, st := range t.sessions {
+		if now.Sub(st.lastSeen) > t.idleTimeout {
+			out = append(out, summary(st))
+			delete(t.sessions, key)
+		}
+	}
+	return out
+}
+
+// Flush closes all open sessions (e.g. on shutdown) and returns their summary events.
+func (t *Tracker) Flush() []event.Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]event.Event, 0, len(t.closed)+len(t.sessions))
+	for _, st := range t.closed {
+		out = append(out, summary(st))
+	}
+	t.closed = nil
+	for key, st := range t.sessions {
+		out = append(out, summary(st))
+		delete(t.sessions, key)
+	}
+	return out
+}
+
+// Len returns the number of open sessions.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.sessions)
+}
+
+// summary builds the ECS session summary event for a closed session.
+func summary(st *sessionState) event.Event {
+	source := map[string]interface{}{"ip": st.sourceIP}
+	// Carry over enrichment results so summaries can be filtered like raw events.
+	for _, k := range []string{"as", "geo", "domain"} {
+		if v, ok := st.source[k]; ok {
+			source[k] = v
+		}
+	}
+	return event.Event{
+		"@timestamp": st.last.UTC().Format(time.RFC3339Nano),
+		"event": map[string]interface{}{
+			"kind":     "event",
+			"category": []interface{}{"session"},
+			"type":     []interface{}{"end"},
+			"dataset":  "loom.session",
+			"start":    st.first.UTC().Format(time.RFC3339Nano),
+			"end":      st.last.UTC().Format(time.RFC3339Nano),
+			"duration": st.last.Sub(st.first).Nanoseconds(),
+		},
+		"session": map[string]interface{}{
+			"id":          st.id,
+			"event_count": st.events,
+		},
+		"source":   source,
+		"observer": map[string]interface{}{"id": st.sensorID},
+	}
+}
+
+// eventTime returns the event's @timestamp, or fallback if it is missing or unparsable.
+func eventTime(ev event.Event, fallback time.Time) time.Time {
+	if ts, ok := ev.Timestamp(); ok {
+		return ts
+	}
+	return fallback
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func testEvent(ip, ts string) map[string]interface{} {
+	return map[string]interface{}{
+		"@timestamp": ts,
+		"source":     map[string]interface{}{"ip": ip, "port": float64(4496)},
+	}
+}
+
+func sessionID(t *testing.T, ev map[string]interface{}) string {
+	t.Helper()
+	sess, _ := ev["session"].(map[string]interface{})
+	if sess == nil {
+		t.Fatal("event missing session")
+	}
+	id, _ := sess["id"].(string)
+	return id
+}
+
+func TestTracker_GroupsBySensorAndSourceIP(t *testing.T) {
+	tr := NewTracker(time.Minute, 0)
+
+	a1 := testEvent("1.2.3.4", "2026-02-15T19:47:09Z")
+	a2 := testEvent("1.2.3.4", "2026-02-15T19:47:19Z")
+	b := testEvent("5.6.7.8", "2026-02-15T19:47:20Z")
+	other := testEvent("1.2.3.4", "2026-02-15T19:47:21Z")
+	tr.Observe("spip-001", a1)
+	tr.Observe("spip-001", a2)
+	tr.Observe("spip-001", b)
+	tr.Observe("spip-002", other)
+
+	if sessionID(t, a1) != sessionID(t, a2) {
+		t.Error("same sensor and source IP should share a session")
+	}
+	if sessionID(t, a1) == sessionID(t, b) {
+		t.Error("different source IPs should not share a session")
+	}
+	if sessionID(t, a1) == sessionID(t, other) {
+		t.Error("different sensors should not share a session")
+	}
+	if tr.Len() != 3 {
+		t.Errorf("open sessions = %d, want 3", tr.Len())
+	}
+}
+
+func TestTracker_ExpireEmitsSummary(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := NewTracker(time.Minute, 0)
+	tr.nowFn = func() time.Time { return now }
+
+	tr.Observe("spip-001", testEvent("1.2.3.4", "2026-02-15T19:47:09Z"))
+	tr.Observe("spip-001", testEvent("1.2.3.4", "2026-02-15T19:47:39Z"))
+
+	if got := tr.Expire(); len(got) != 0 {
+		t.Fatalf("Expire before timeout returned %d summaries", len(got))
+	}
+
+	now = now.Add(2 * time.Minute)
+	got := tr.Expire()
+	if len(got) != 1 {
+		t.Fatalf("Expire returned %d summaries, want 1", len(got))
+	}
+	sess := got[0]["session"].(map[string]interface{})
+	if sess["event_count"] != 2 {
+		t.Errorf("event_count = %v, want 2", sess["event_count"])
+	}
+	ev := got[0]["event"].(map[string]interface{})
+	if ev["start"] != "2026-02-15T19:47:09Z" || ev["end"] != "2026-02-15T19:47:39Z" {
+		t.Errorf("start/end = %v/%v", ev["start"], ev["end"])
+	}
+	if ev["duration"] != (30 * time.Second).Nanoseconds() {
+		t.Errorf("duration = %v", ev["duration"])
+	}
+	if tr.Len() != 0 {
+		t.Errorf("open sessions after expire = %d, want 0", tr.Len())
+	}
+}
+
+func TestTracker_NewSessionAfterIdle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := NewTracker(time.Minute, 0)
+	tr.nowFn = func() time.Time { return now }
+
+	first := testEvent("1.2.3.4", "")
+	tr.Observe("spip-001", first)
+	now = now.Add(2 * time.Minute)
+	second := testEvent("1.2.3.4", "")
+	tr.Observe("spip-001", second)
+
+	if sessionID(t, first) == sessionID(t, second) {
+		t.Error("event after idle timeout should start a new session")
+	}
+	if got := tr.Expire(); len(got) != 1 {
+		t.Errorf("Expire returned %d summaries, want 1 (the replaced session)", len(got))
+	}
+	if got := tr.Flush(); len(got) != 1 {
+		t.Errorf("Flush returned %d summaries, want 1", len(got))
+	}
+}
+
+func TestTracker_MaxSessions(t *testing.T) {
+	tr := NewTracker(time.Minute, 1)
+	tr.Observe("s", testEvent("1.1.1.1", ""))
+	ev := testEvent("2.2.2.2", "")
+	tr.Observe("s", ev)
+	if _, ok := ev["session"]; ok {
+		t.Error("event beyond max_sessions should not get a session")
+	}
+}
+
+func TestTracker_NoSourceIP(t *testing.T) {
+	tr := NewTracker(time.Minute, 0)
+	ev := map[string]interface{}{"event": map[string]interface{}{"id": "x"}}
+	tr.Observe("s", ev)
+	if _, ok := ev["session"]; ok {
+		t.Error("event without source.ip should not get a session")
+	}
+}
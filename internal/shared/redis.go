@@ -0,0 +1,330 @@
+// Package shared keeps state that Loom replicas behind a load balancer must agree on (first-seen
+// indicators, per-sensor rate limits) in Redis, so several instances behave like one. Callers fall
+// back to their local state when Redis is unreachable.
+package shared
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis is a small Redis client (RESP2) with a connection pool, covering the commands Loom needs.
+// It is safe for concurrent use.
+type Redis struct {
+	addr     string
+	user     string
+	password string
+	db       int
+	tls      *tls.Config
+	prefix   string
+	timeout  time.Duration
+
+	pool chan *redisConn
+
+	mu       sync.Mutex
+	lastErr  error
+	onChange func(err error)
+}
+
+// Options configures a Redis client.
+type Options struct {
+	URL       string        // redis://[user:password@]host:port/db or rediss:// for TLS
+	KeyPrefix string        // prepended to every key, e.g. "loom:"
+	Timeout   time.Duration // per command, including connecting; default 200ms
+	PoolSize  int           // idle connections kept; default 8
+	// OnStateChange is called when commands start failing (with the error) and when they succeed
+	// again (with nil).
+	OnStateChange func(err error)
+}
+
+// NewRedis returns a client for opts.URL. Connections are made on first use.
+func NewRedis(opts Options) (*Redis, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("shared: redis_url must be redis://host:port or rediss://host:port")
+	}
+	r := &Redis{addr: u.Host, prefix: opts.KeyPrefix, timeout: opts.Timeout, onChange: opts.OnStateChange}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		r.tls = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}
+	}
+	if u.User != nil {
+		if pw, ok := u.User.Password(); ok {
+			r.user, r.password = u.User.Username(), pw
+		} else {
+			r.password = u.User.Username() // redis://password@host
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil || r.db < 0 {
+			return nil, fmt.Errorf("shared: invalid redis database %q", db)
+		}
+	}
+	if r.timeout <= 0 {
+		r.timeout = 200 * time.Millisecond
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 8
+	}
+	r.pool = make(chan *redisConn, opts.PoolSize)
+	return r, nil
+}
+
+// Ping checks that Redis is reachable.
+func (r *Redis) Ping(ctx context.Context) error {
+	_, err := r.pipeline(ctx, [][]string{{"PING"}})
+	return err
+}
+
+// SetBits sets the given bit offsets of the bitmap at key and reports whether any of them was clear.
+func (r *Redis) SetBits(ctx context.Context, key string, offsets []uint64) (bool, error) {
+	cmds := make([][]string, len(offsets))
+	for i, off := range offsets {
+		cmds[i] = []string{"SETBIT", r.prefix + key, strconv.FormatUint(off, 10), "1"}
+	}
+	replies, err := r.pipeline(ctx, cmds)
+	if err != nil {
+		return false, err
+	}
+	clear := false
+	for _, rep := range replies {
+		if n, ok := rep.(int64); ok && n == 0 {
+			clear = true
+		}
+	}
+	return clear, nil
+}
+
+// IncrWindow increments the counter at key, sets it to expire after ttl and returns the new count.
+// Keys are meant to name a window (e.g. include the second), so the expiry only cleans them up.
+func (r *Redis) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return r.IncrByWindow(ctx, key, 1, ttl)
+}
+
+// IncrByWindow is IncrWindow adding n (which may be negative) instead of 1.
+func (r *Redis) IncrByWindow(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	replies, err := r.pipeline(ctx, [][]string{
+		{"INCRBY", r.prefix + key, strconv.FormatInt(n, 10)},
+		{"PEXPIRE", r.prefix + key, ms},
+	})
+	if err != nil {
+		return 0, err
+	}
+	v, ok := replies[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCRBY reply %v", replies[0])
+	}
+	return v, nil
+}
+
+// Close closes the idle connections.
+func (r *Redis) Close() error {
+	for {
+		select {
+		case c := <-r.pool:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// pipeline sends cmds in one round trip and returns their replies. A Redis error reply to any
+// command is returned as the error.
+func (r *Redis) pipeline(ctx context.Context, cmds [][]string) ([]interface{}, error) {
+	replies, err := r.roundTrip(ctx, cmds)
+	r.report(err)
+	return replies, err
+}
+
+func (r *Redis) roundTrip(ctx context.Context, cmds [][]string) ([]interface{}, error) {
+	deadline := time.Now().Add(r.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c, err := r.get(ctx, deadline)
+	if err != nil {
+		return nil, err
+	}
+	_ = c.SetDeadline(deadline)
+	for _, cmd := range cmds {
+		writeCommand(c.w, cmd)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	var replyErr error
+	for i := range cmds {
+		rep, err := readReply(c.r)
+		if err != nil {
+			var re redisError
+			if !errors.As(err, &re) {
+				c.Close() // the stream position is unknown
+				return nil, err
+			}
+			if replyErr == nil {
+				replyErr = err
+			}
+		}
+		replies[i] = rep
+	}
+	r.put(c)
+	return replies, replyErr
+}
+
+// report calls OnStateChange when the outcome differs from the previous command's.
+func (r *Redis) report(err error) {
+	if r.onChange == nil {
+		return
+	}
+	r.mu.Lock()
+	changed := (err == nil) != (r.lastErr == nil)
+	r.lastErr = err
+	r.mu.Unlock()
+	if changed {
+		r.onChange(err)
+	}
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (r *Redis) get(ctx context.Context, deadline time.Time) (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+	dialCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if r.tls != nil {
+		d := &tls.Dialer{Config: r.tls}
+		conn, err = d.DialContext(dialCtx, "tcp", r.addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(dialCtx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	_ = c.SetDeadline(deadline)
+	var setup [][]string
+	if r.password != "" {
+		if r.user != "" {
+			setup = append(setup, []string{"AUTH", r.user, r.password})
+		} else {
+			setup = append(setup, []string{"AUTH", r.password})
+		}
+	}
+	if r.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.db)})
+	}
+	for _, cmd := range setup {
+		writeCommand(c.w, cmd)
+	}
+	if err := c.w.Flush(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	for range setup {
+		if _, err := readReply(c.r); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (r *Redis) put(c *redisConn) {
+	select {
+	case r.pool <- c:
+	default:
+		c.Close()
+	}
+}
+
+func writeCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+}
+
+// redisError is an error reply ("-ERR ..."); the connection stays usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readReply reads one RESP2 reply: strings, integers, bulk strings (nil when absent) and arrays.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed integer %q", body)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,167 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks enough RESP for the client: AUTH, SELECT, PING, SETBIT, INCRBY and PEXPIRE.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	bits     map[string]map[uint64]bool
+	counters map[string]int64
+	cmds     []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, bits: map[string]map[uint64]bool{}, counters: map[string]int64{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		rep, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := rep.([]interface{})
+		args := make([]string, len(items))
+		for i, it := range items {
+			args[i], _ = it.(string)
+		}
+		f.mu.Lock()
+		f.cmds = append(f.cmds, strings.Join(args, " "))
+		var out string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == f.password
+			out = "+OK\r\n"
+			if !authed {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT" || cmd == "PEXPIRE":
+			out = "+OK\r\n"
+		case cmd == "PING":
+			out = "+PONG\r\n"
+		case cmd == "SETBIT":
+			off, _ := strconv.ParseUint(args[2], 10, 64)
+			if f.bits[args[1]] == nil {
+				f.bits[args[1]] = map[uint64]bool{}
+			}
+			old := 0
+			if f.bits[args[1]][off] {
+				old = 1
+			}
+			f.bits[args[1]][off] = true
+			out = fmt.Sprintf(":%d\r\n", old)
+		case cmd == "INCRBY":
+			n, _ := strconv.ParseInt(args[2], 10, 64)
+			f.counters[args[1]] += n
+			out = fmt.Sprintf(":%d\r\n", f.counters[args[1]])
+		default:
+			out = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedis_SetBitsAndIncr(t *testing.T) {
+	f := newFakeRedis(t, "s3cret")
+	r, err := NewRedis(Options{URL: "redis://:s3cret@" + f.ln.Addr().String() + "/2", KeyPrefix: "loom:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	if clear, err := r.SetBits(ctx, "fs", []uint64{1, 70}); err != nil || !clear {
+		t.Fatalf("first SetBits = %v, %v; want clear", clear, err)
+	}
+	if clear, err := r.SetBits(ctx, "fs", []uint64{1, 70}); err != nil || clear {
+		t.Errorf("second SetBits = %v, %v; want all set", clear, err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if n, err := r.IncrWindow(ctx, "rl:s1:100", 2*time.Second); err != nil || n != want {
+			t.Errorf("IncrWindow = %d, %v; want %d", n, err, want)
+		}
+	}
+	if n, err := r.IncrByWindow(ctx, "rl:s1:100", -2, 2*time.Second); err != nil || n != 1 {
+		t.Errorf("IncrByWindow = %d, %v; want 1", n, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cmds[0] != "AUTH s3cret" || f.cmds[1] != "SELECT 2" || !f.bits["loom:fs"][70] {
+		t.Errorf("commands = %v", f.cmds)
+	}
+	auths := 0
+	for _, c := range f.cmds {
+		if strings.HasPrefix(c, "AUTH") {
+			auths++
+		}
+	}
+	if auths != 1 {
+		t.Errorf("AUTH sent %d times; connection not reused", auths)
+	}
+}
+
+func TestRedis_ErrorsAndStateChanges(t *testing.T) {
+	f := newFakeRedis(t, "s3cret")
+	var states []error
+	r, _ := NewRedis(Options{URL: "redis://wrong@" + f.ln.Addr().String(), OnStateChange: func(err error) { states = append(states, err) }})
+	if err := r.Ping(context.Background()); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("Ping = %v, want WRONGPASS", err)
+	}
+
+	r.password = "s3cret"
+	if err := r.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0] == nil || states[1] != nil {
+		t.Errorf("state changes = %v, want [error, nil]", states)
+	}
+
+	down, _ := NewRedis(Options{URL: "redis://127.0.0.1:1", Timeout: 50 * time.Millisecond})
+	if _, err := down.IncrWindow(context.Background(), "k", time.Second); err == nil {
+		t.Error("expected error for unreachable redis")
+	}
+	if _, err := NewRedis(Options{URL: "http://redis:6379"}); err == nil {
+		t.Error("expected error for non-redis URL")
+	}
+}
@@ -0,0 +1,204 @@
+// Package tenant lets one Loom serve several groups (tenants). Each sensor belongs to at most one
+// tenant; a tenant's sensors share its request rate limit, daily event quota and batch size limit.
+//
+// The counts are kept in memory: without shared state (UseShared) each replica enforces the limits
+// on its own, and a restart starts the day's quota over.
+package tenant
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+)
+
+// Limits are the per-tenant limits; zero values mean no tenant limit (the [limits] values apply).
+type Limits struct {
+	RPS               int   // requests per second across the tenant's sensors
+	EventsPerDay      int64 // events accepted per UTC day
+	MaxEventsPerBatch int
+}
+
+// Registry maps sensors to tenants and enforces the tenants' limits. A nil Registry has no tenants
+// and allows everything.
+type Registry struct {
+	sensors map[string]string // sensor ID -> tenant ID
+	limits  map[string]Limits
+	rate    map[string]*ratelimit.PerSensorLimiter // tenants with an RPS limit
+
+	mu    sync.Mutex
+	day   int64            // UTC day of the counts
+	used  map[string]int64 // tenant -> events accepted today
+	nowFn func() time.Time
+
+	shared SharedCounter // optional; counts across replicas and restarts
+}
+
+// SharedCounter counts in windows across Loom replicas (see internal/shared).
+type SharedCounter interface {
+	IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	IncrByWindow(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// quotaTTL keeps a day's quota count past the end of the day, for replicas whose clocks lag.
+const quotaTTL = 48 * time.Hour
+
+// UseShared makes the tenants' request rates and daily quotas apply to all replicas sharing c, and
+// keeps the quotas' counts across restarts. The local counts decide while c fails.
+func (r *Registry) UseShared(c SharedCounter) {
+	if r == nil || c == nil {
+		return
+	}
+	r.mu.Lock()
+	r.shared = c
+	r.mu.Unlock()
+	for _, l := range r.rate {
+		l.UseShared(prefixedCounter{c, "tenant:"})
+	}
+}
+
+// prefixedCounter keeps the tenants' rate limit keys apart from the sensors'.
+type prefixedCounter struct {
+	c      SharedCounter
+	prefix string
+}
+
+func (p prefixedCounter) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return p.c.IncrWindow(ctx, p.prefix+key, ttl)
+}
+
+// New returns a registry for the sensor -> tenant assignments and tenant limits. Returns nil when no
+// sensor is assigned to a tenant.
+func New(sensors map[string]string, limits map[string]Limits) *Registry {
+	if len(sensors) == 0 {
+		return nil
+	}
+	r := &Registry{
+		sensors: sensors,
+		limits:  limits,
+		rate:    make(map[string]*ratelimit.PerSensorLimiter),
+		used:    make(map[string]int64),
+		nowFn:   time.Now,
+	}
+	for id, l := range limits {
+		if l.RPS > 0 {
+			r.rate[id] = ratelimit.NewPerSensorLimiter(l.RPS)
+		}
+	}
+	return r
+}
+
+// SetAlgorithm counts the tenants' request rates with algorithm and burst, like the per-sensor
+// limit (see ratelimit.PerSensorLimiter.SetAlgorithm).
+func (r *Registry) SetAlgorithm(algorithm string, burst int) {
+	if r == nil {
+		return
+	}
+	for _, l := range r.rate {
+		l.SetAlgorithm(algorithm, burst)
+	}
+}
+
+// Tenant returns the tenant of sensorID, or "" when it has none.
+func (r *Registry) Tenant(sensorID string) string {
+	if r == nil {
+		return ""
+	}
+	return r.sensors[sensorID]
+}
+
+// AllowRequest reports whether sensorID's tenant is within its request rate.
+func (r *Registry) AllowRequest(sensorID string) bool {
+	if r == nil {
+		return true
+	}
+	id := r.sensors[sensorID]
+	if l := r.rate[id]; l != nil {
+		return l.Allow(id)
+	}
+	return true
+}
+
+// MaxEvents returns the batch size limit for sensorID: its tenant's, else def.
+func (r *Registry) MaxEvents(sensorID string, def int) int {
+	if r == nil {
+		return def
+	}
+	if n := r.limits[r.sensors[sensorID]].MaxEventsPerBatch; n > 0 {
+		return n
+	}
+	return def
+}
+
+// AllowEvents counts n events against the daily quota of sensorID's tenant and reports whether they
+// fit. Events that do not fit are not counted.
+func (r *Registry) AllowEvents(sensorID string, n int) bool {
+	if r == nil {
+		return true
+	}
+	id := r.sensors[sensorID]
+	quota := r.limits[id].EventsPerDay
+	if id == "" || quota <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	r.rollLocked()
+	day, shared := r.day, r.shared
+	r.mu.Unlock()
+	if shared != nil {
+		key := "quota:" + id + ":" + strconv.FormatInt(day, 10)
+		if total, err := shared.IncrByWindow(context.Background(), key, int64(n), quotaTTL); err == nil {
+			if total > quota {
+				// Take them back so the rejected events do not use up the quota
+				_, _ = shared.IncrByWindow(context.Background(), key, -int64(n), quotaTTL)
+				return false
+			}
+			r.mu.Lock()
+			r.rollLocked()
+			r.used[id] += int64(n)
+			r.mu.Unlock()
+			return true
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rollLocked()
+	if r.used[id]+int64(n) > quota {
+		return false
+	}
+	r.used[id] += int64(n)
+	return true
+}
+
+// QuotaResetIn returns the time until the daily quotas start over (UTC midnight).
+func (r *Registry) QuotaResetIn() time.Duration {
+	now := time.Now().UTC()
+	if r != nil {
+		now = r.nowFn().UTC()
+	}
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// Used returns the events each tenant with a quota has had accepted today by this replica.
+func (r *Registry) Used() map[string]int64 {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rollLocked()
+	out := make(map[string]int64, len(r.used))
+	for id, n := range r.used {
+		out[id] = n
+	}
+	return out
+}
+
+func (r *Registry) rollLocked() {
+	if day := r.nowFn().Unix() / 86400; day != r.day {
+		r.day = day
+		r.used = make(map[string]int64)
+	}
+}
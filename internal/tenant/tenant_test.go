@@ -0,0 +1,130 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Limits(t *testing.T) {
+	r := New(
+		map[string]string{"spip-01": "group-a", "spip-02": "group-a", "spip-03": "group-b"},
+		map[string]Limits{"group-a": {RPS: 2, EventsPerDay: 10, MaxEventsPerBatch: 5}, "group-b": {}},
+	)
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	r.nowFn = func() time.Time { return now }
+
+	if r.Tenant("spip-02") != "group-a" || r.Tenant("other") != "" {
+		t.Errorf("Tenant: %q, %q", r.Tenant("spip-02"), r.Tenant("other"))
+	}
+	// The rate limit is shared by the tenant's sensors.
+	if !r.AllowRequest("spip-01") || !r.AllowRequest("spip-02") || r.AllowRequest("spip-01") {
+		t.Error("third request in the same second should exceed the tenant's rps")
+	}
+	if !r.AllowRequest("spip-03") || !r.AllowRequest("other") {
+		t.Error("tenants without rps and sensors without tenant are not limited")
+	}
+	if r.MaxEvents("spip-01", 1000) != 5 || r.MaxEvents("spip-03", 1000) != 1000 {
+		t.Error("MaxEvents")
+	}
+
+	if !r.AllowEvents("spip-01", 6) || !r.AllowEvents("spip-02", 4) || r.AllowEvents("spip-01", 1) {
+		t.Error("quota of 10 events per day")
+	}
+	if got := r.Used()["group-a"]; got != 10 {
+		t.Errorf("used = %d", got)
+	}
+	if d := r.QuotaResetIn(); d != time.Minute {
+		t.Errorf("QuotaResetIn = %v", d)
+	}
+	now = now.Add(2 * time.Minute)
+	if !r.AllowEvents("spip-01", 10) {
+		t.Error("quota should reset at UTC midnight")
+	}
+
+	var none *Registry
+	if !none.AllowRequest("x") || !none.AllowEvents("x", 1e9) || none.Tenant("x") != "" || none.MaxEvents("x", 7) != 7 {
+		t.Error("nil registry must allow everything")
+	}
+	if New(nil, nil) != nil {
+		t.Error("New without assignments should return nil")
+	}
+}
+
+// fakeCounter is an in-memory SharedCounter; down makes it fail.
+type fakeCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	down   bool
+}
+
+func (f *fakeCounter) IncrWindow(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return f.IncrByWindow(ctx, key, 1, ttl)
+}
+
+func (f *fakeCounter) IncrByWindow(_ context.Context, key string, n int64, _ time.Duration) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return 0, errors.New("down")
+	}
+	f.counts[key] += n
+	return f.counts[key], nil
+}
+
+func TestRegistry_Shared(t *testing.T) {
+	c := &fakeCounter{counts: map[string]int64{}}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	replica := func() *Registry {
+		r := New(map[string]string{"spip-01": "group-a"}, map[string]Limits{"group-a": {RPS: 1, EventsPerDay: 10}})
+		r.nowFn = func() time.Time { return now }
+		r.UseShared(c)
+		return r
+	}
+	a, b := replica(), replica()
+
+	if !a.AllowEvents("spip-01", 6) || b.AllowEvents("spip-01", 5) || !b.AllowEvents("spip-01", 4) {
+		t.Error("replicas should share the quota of 10 events per day")
+	}
+	if got := c.counts["quota:group-a:20513"]; got != 10 {
+		t.Errorf("shared count = %d, want 10 (rejected events taken back)", got)
+	}
+	if !replica().AllowEvents("spip-01", 0) || replica().AllowEvents("spip-01", 1) {
+		t.Error("a restarted replica should keep the day's count")
+	}
+
+	a.AllowRequest("spip-01")
+	for key := range c.counts {
+		if strings.HasPrefix(key, "ratelimit:") {
+			t.Errorf("tenant rate limit key %q can collide with a sensor's", key)
+		}
+	}
+
+	c.down = true
+	if !a.AllowEvents("spip-01", 4) || a.AllowEvents("spip-01", 1) {
+		t.Error("local count of 6 should decide while shared state is down")
+	}
+}
+
+func TestRegistry_RateRecovers(t *testing.T) {
+	for _, algorithm := range []string{"fixed", "sliding", "gcra"} {
+		r := New(map[string]string{"spip-01": "group-a"}, map[string]Limits{"group-a": {RPS: 2}})
+		r.SetAlgorithm(algorithm, 0)
+		allowed := 0
+		for i := 0; i < 3; i++ {
+			if r.AllowRequest("spip-01") {
+				allowed++
+			}
+		}
+		if allowed == 3 && algorithm != "fixed" { // fixed allows 3 across a second boundary
+			t.Errorf("%s: allowed 3 requests at rps 2", algorithm)
+		}
+		time.Sleep(1100 * time.Millisecond)
+		if !r.AllowRequest("spip-01") {
+			t.Errorf("%s: still denied 1.1s later", algorithm)
+		}
+	}
+}
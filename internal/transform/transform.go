@@ -0,0 +1,144 @@
+// Package transform applies the [[transform]] rules of the config: declarative renames, drops and
+// additions that adapt events from sensors that are not quite ECS, before normalization and
+// enrichment.
+package transform
+
+import (
+	"github.com/StefanGrimminck/Loom/internal/ecs"
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+// Rule is one transformation. Fields are dotted paths; a top-level key that contains the dots itself
+// (e.g. {"source.ip": ...}, as some sensors send) matches too.
+type Rule struct {
+	Action    string      // "rename", "drop" or "add"
+	From      string      // rename: the field to move
+	To        string      // rename: where to move it
+	Field     string      // drop, add: the field
+	Value     interface{} // add: the value to set
+	Overwrite bool        // rename, add: replace a value already at the target
+	Sensors   []string    // apply only to events from these sensors; empty means all
+}
+
+// Transformer applies rules to events in order.
+type Transformer struct {
+	rules []rule
+}
+
+type rule struct {
+	Rule
+	sensors map[string]bool // nil means all
+}
+
+// New returns a Transformer for rules, or nil (which does nothing) when there are none. Unknown
+// actions are ignored; the config rejects them.
+func New(rules []Rule) *Transformer {
+	if len(rules) == 0 {
+		return nil
+	}
+	t := &Transformer{rules: make([]rule, 0, len(rules))}
+	for _, r := range rules {
+		tr := rule{Rule: r}
+		tr.Value = jsonValue(r.Value)
+		if len(r.Sensors) > 0 {
+			tr.sensors = make(map[string]bool, len(r.Sensors))
+			for _, id := range r.Sensors {
+				tr.sensors[id] = true
+			}
+		}
+		t.rules = append(t.rules, tr)
+	}
+	return t
+}
+
+// Apply transforms ev, received from sensorID, in place.
+func (t *Transformer) Apply(sensorID string, ev event.Event) {
+	if t == nil || ev == nil {
+		return
+	}
+	for _, r := range t.rules {
+		if r.sensors != nil && !r.sensors[sensorID] {
+			continue
+		}
+		switch r.Action {
+		case "rename":
+			v, ok := get(ev, r.From)
+			if !ok || r.From == r.To {
+				continue
+			}
+			if _, exists := get(ev, r.To); exists && !r.Overwrite {
+				continue
+			}
+			del(ev, r.From)
+			del(ev, r.To)
+			ecs.Set(ev, r.To, v)
+		case "drop":
+			del(ev, r.Field)
+		case "add":
+			if _, exists := get(ev, r.Field); exists && !r.Overwrite {
+				continue
+			}
+			del(ev, r.Field)
+			ecs.Set(ev, r.Field, copyValue(r.Value))
+		}
+	}
+}
+
+// get returns the value at path, looking for a top-level key named path first.
+func get(ev event.Event, path string) (interface{}, bool) {
+	if v, ok := ev[path]; ok {
+		return v, true
+	}
+	v := ecs.Get(ev, path)
+	return v, v != nil
+}
+
+// del removes path, both as a top-level key and as a nested field.
+func del(ev event.Event, path string) {
+	delete(ev, path)
+	ecs.Delete(ev, path)
+}
+
+// jsonValue converts a value decoded from TOML to what decoding JSON gives: numbers are float64 and
+// tables map[string]interface{}.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int64:
+		return float64(x)
+	case int:
+		return float64(x)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = jsonValue(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = jsonValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// copyValue returns a deep copy of an added value, so events do not share (and later enrichment
+// does not change) the rule's objects and arrays.
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = copyValue(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = copyValue(e)
+		}
+		return out
+	}
+	return v
+}
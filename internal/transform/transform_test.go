@@ -0,0 +1,70 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/event"
+)
+
+func TestTransformer_Apply(t *testing.T) {
+	tr := New([]Rule{
+		{Action: "rename", From: "src_ip", To: "source.ip"},
+		{Action: "rename", From: "source.hostname", To: "source.domain"},
+		{Action: "rename", From: "dst", To: "destination.ip"}, // target set: kept
+		{Action: "drop", Field: "debug"},
+		{Action: "add", Field: "event.dataset", Value: "spip.http"},
+		{Action: "add", Field: "labels.env", Value: "prod"}, // already set: kept
+		{Action: "add", Field: "event.severity", Value: int64(3), Overwrite: true},
+		{Action: "add", Field: "tags", Value: []interface{}{"honeypot"}, Sensors: []string{"spip-02"}},
+	})
+	ev := event.Event{
+		"src_ip":          "10.0.0.1",
+		"source.hostname": "scanner.example",
+		"dst":             "10.0.0.9",
+		"destination":     map[string]interface{}{"ip": "10.0.0.2"},
+		"debug":           map[string]interface{}{"raw": "x"},
+		"labels":          map[string]interface{}{"env": "staging"},
+		"event":           map[string]interface{}{"severity": float64(1)},
+	}
+	tr.Apply("spip-01", ev)
+
+	if ev.SourceIP() != "10.0.0.1" || ev["src_ip"] != nil {
+		t.Errorf("rename src_ip: %v", ev)
+	}
+	if ev.GetString("source.domain") != "scanner.example" || ev["source.hostname"] != nil {
+		t.Errorf("rename of a dotted key: %v", ev)
+	}
+	if ev.DestinationIP() != "10.0.0.2" || ev["dst"] != "10.0.0.9" {
+		t.Errorf("rename onto a set field: %v", ev)
+	}
+	if ev["debug"] != nil {
+		t.Errorf("drop: %v", ev)
+	}
+	if ev.GetString("event.dataset") != "spip.http" || ev.GetString("labels.env") != "staging" || ev.Get("event.severity") != float64(3) {
+		t.Errorf("add: %v", ev)
+	}
+	if ev["tags"] != nil {
+		t.Errorf("rule for spip-02 applied to spip-01: %v", ev["tags"])
+	}
+
+	ev2 := event.Event{}
+	tr.Apply("spip-02", ev2)
+	tags, _ := ev2["tags"].([]interface{})
+	if len(tags) != 1 {
+		t.Fatalf("tags = %v", ev2["tags"])
+	}
+	tags[0] = "changed" // events get their own copy
+	ev3 := event.Event{}
+	tr.Apply("spip-02", ev3)
+	if ev3["tags"].([]interface{})[0] != "honeypot" {
+		t.Errorf("added value shared between events: %v", ev3["tags"])
+	}
+}
+
+func TestNew_NoRules(t *testing.T) {
+	tr := New(nil)
+	if tr != nil {
+		t.Fatal("New(nil) should return nil")
+	}
+	tr.Apply("s", event.Event{"a": 1}) // no-op on nil
+}
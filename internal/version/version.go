@@ -0,0 +1,76 @@
+// Package version reports the build version, commit and date of the running binary.
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/StefanGrimminck/Loom/internal/version.Version=v1.2.0 \
+//	  -X github.com/StefanGrimminck/Loom/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/StefanGrimminck/Loom/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info. Commit and build date fall back to the VCS stamp Go embeds
+// when the ldflags are not set.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			}
+		}
+	}
+	return info
+}
+
+// Handler serves Get() as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get())
+	})
+}
+
+// RegisterMetrics registers loom_build_info (always 1, build details as labels).
+func RegisterMetrics(reg prometheus.Registerer) {
+	info := Get()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "loom_build_info",
+		Help: "Build information of the running Loom binary; value is always 1.",
+		ConstLabels: prometheus.Labels{
+			"version":    info.Version,
+			"commit":     info.Commit,
+			"build_date": info.BuildDate,
+			"go_version": info.GoVersion,
+		},
+	})
+	g.Set(1)
+	reg.MustRegister(g)
+}
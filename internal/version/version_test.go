@@ -0,0 +1,33 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/version", nil))
+	var info Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != Version || info.GoVersion == "" {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	RegisterMetrics(reg)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || mfs[0].GetName() != "loom_build_info" || mfs[0].GetMetric()[0].GetGauge().GetValue() != 1 {
+		t.Errorf("unexpected metrics: %v", mfs)
+	}
+}
@@ -0,0 +1,321 @@
+// Package client sends events to a Loom ingest endpoint the way Loom expects: JSON arrays of event
+// objects with a Bearer token and X-Spip-ID, batched within the server's limits, optionally gzipped,
+// with retries and backoff for 429 and 5xx responses and an optional local spool for batches that
+// still fail.
+//
+//	c, err := client.New(client.Config{URL: "https://loom:8443/api/v1/ingest", Token: token, SensorID: "spip-01", Gzip: true})
+//	...
+//	go c.Run(ctx, 5*time.Second, func(err error) { log.Print(err) })
+//	err = c.Add(ctx, event)
+//	...
+//	err = c.Close(context.Background()) // sends what is left
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults match the server's default [limits].
+const (
+	DefaultBatchSize     = 500
+	DefaultMaxBodyBytes  = 2 * 1024 * 1024
+	DefaultMaxEventBytes = 128 * 1024
+)
+
+// ErrEventTooLarge is returned by Add for an event the server would reject as too large.
+var ErrEventTooLarge = errors.New("event too large")
+
+// Config configures a Client. URL and Token are required.
+type Config struct {
+	URL      string // ingest endpoint, e.g. https://loom:8443/api/v1/ingest
+	Token    string
+	SensorID string // sent as X-Spip-ID; must be the token's sensor when set
+
+	HTTPClient    *http.Client // default: 30s timeout
+	BatchSize     int          // events per request; default 500 (max_events_per_batch)
+	MaxBodyBytes  int          // uncompressed request body limit; default 2 MiB (max_body_size_bytes)
+	MaxEventBytes int          // default 128 KiB (max_event_size_bytes)
+	Gzip          bool         // compress request bodies (Content-Encoding: gzip)
+
+	MaxRetries int           // per batch for network errors, 429 and 5xx; default 5
+	Backoff    time.Duration // first retry delay, doubled up to 30s; default 1s; Retry-After takes precedence
+	// MaxRetryAfter is the longest Retry-After waited for; default 60s. A server asking for longer
+	// ends the batch's retries with its StatusError (and the batch is spooled, with SpoolDir).
+	MaxRetryAfter time.Duration
+
+	// SpoolDir, when set, receives batches that still fail after the retries as NDJSON files (the
+	// format loom replay reads); Resend and Run send them again. Without it such batches are dropped
+	// and the error is returned.
+	SpoolDir string
+}
+
+// StatusError is a response other than 2xx from the server.
+type StatusError struct {
+	StatusCode int
+	Code       string        // the "code" field of the body, e.g. "rate_limit_exceeded"
+	Message    string        // the "message" field of the body
+	RequestID  string        // the X-Request-ID response header; quote it when reporting problems
+	RetryAfter time.Duration // from the Retry-After header; 0 when absent
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("loom: status %d", e.StatusCode)
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("loom: status %d: %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("loom: status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Temporary reports whether the request may succeed when retried (429 and 5xx).
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Client batches events and sends them to Loom. It is safe for concurrent use; Add blocks while a
+// full batch is being sent, retries included: bound it with ctx and Config.MaxRetryAfter.
+type Client struct {
+	cfg Config
+
+	mu      sync.Mutex
+	pending []json.RawMessage
+	size    int // bytes of the pending batch as a JSON array
+
+	spoolFiles, spoolBytes atomic.Int64 // what SpoolDir holds, for SpoolSize
+}
+
+// New returns a Client for cfg.
+func New(cfg Config) (*Client, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, errors.New("client: URL and Token are required")
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid URL %q", cfg.URL)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if cfg.MaxEventBytes <= 0 {
+		cfg.MaxEventBytes = DefaultMaxEventBytes
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxRetryAfter <= 0 {
+		cfg.MaxRetryAfter = time.Minute
+	}
+	c := &Client{cfg: cfg}
+	c.countSpool()
+	return c, nil
+}
+
+// Add queues an event (anything that marshals to a JSON object, usually an ECS map or struct) and
+// sends the batch when it is full.
+func (c *Client) Add(ctx context.Context, event interface{}) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 || b[0] != '{' {
+		return fmt.Errorf("client: event must be a JSON object, got %.20s", b)
+	}
+	if len(b) > c.cfg.MaxEventBytes || len(b)+2 > c.cfg.MaxBodyBytes {
+		return fmt.Errorf("client: %d bytes: %w", len(b), ErrEventTooLarge)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) > 0 && c.size+1+len(b) > c.cfg.MaxBodyBytes {
+		if err := c.flushLocked(ctx); err != nil {
+			return err
+		}
+	}
+	c.pending = append(c.pending, b)
+	if len(c.pending) == 1 {
+		c.size = 2 + len(b)
+	} else {
+		c.size += 1 + len(b)
+	}
+	if len(c.pending) >= c.cfg.BatchSize {
+		return c.flushLocked(ctx)
+	}
+	return nil
+}
+
+// Flush sends the queued events.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked(ctx)
+}
+
+// Close sends the queued events. The Client can still be used afterwards.
+func (c *Client) Close(ctx context.Context) error {
+	return c.Flush(ctx)
+}
+
+// Run flushes every interval, and resends spooled batches after a successful flush, until ctx is done.
+func (c *Client) Run(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := c.Flush(ctx)
+			if err == nil && c.cfg.SpoolDir != "" {
+				_, err = c.Resend(ctx)
+			}
+			if err != nil && onErr != nil && ctx.Err() == nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+func (c *Client) flushLocked(ctx context.Context) error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	batch := c.pending
+	c.pending, c.size = nil, 0
+	err := c.send(ctx, batch)
+	if err == nil || c.cfg.SpoolDir == "" || !temporary(err) {
+		return err
+	}
+	if serr := c.spool(batch); serr != nil {
+		return fmt.Errorf("%w (spool: %v)", err, serr)
+	}
+	return nil
+}
+
+// send posts one batch, retrying temporary failures.
+func (c *Client) send(ctx context.Context, batch []json.RawMessage) error {
+	body, err := c.encode(batch)
+	if err != nil {
+		return err
+	}
+	backoff := c.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		err := c.post(ctx, body)
+		if err == nil || !temporary(err) || attempt == c.cfg.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		wait := backoff
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			if se.RetryAfter > c.cfg.MaxRetryAfter {
+				return err
+			}
+			wait = se.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *Client) encode(batch []json.RawMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if c.cfg.Gzip {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	_, _ = w.Write([]byte{'['})
+	for i, ev := range batch {
+		if i > 0 {
+			_, _ = w.Write([]byte{','})
+		}
+		_, _ = w.Write(ev)
+	}
+	_, _ = w.Write([]byte{']'})
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Client) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	if c.cfg.SensorID != "" {
+		req.Header.Set("X-Spip-ID", c.cfg.SensorID)
+	}
+	if c.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	se := &StatusError{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	var msg struct {
+		Error   string `json:"error"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&msg) == nil {
+		se.Code, se.Message = msg.Code, msg.Message
+		if se.Code == "" {
+			se.Code = msg.Error // servers before the code field
+		}
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		se.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return se
+}
+
+// temporary reports whether err is worth retrying: network errors and 429/5xx responses.
+func temporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	var ue *url.Error // transport failure from http.Client.Do
+	return errors.As(err, &ue)
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/rs/zerolog"
+)
+
+// loomServer runs the real ingest handler, so the tests check the client against what Loom accepts.
+func loomServer(t *testing.T) (*httptest.Server, func() [][]event.Event) {
+	t.Helper()
+	var mu sync.Mutex
+	var batches [][]event.Event
+	h := &ingest.Handler{
+		Validator:     auth.NewValidator(map[string]string{"tk": "spip-01"}),
+		RateLimiter:   ratelimit.NewPerSensorLimiter(-1),
+		MaxBodyBytes:  4096,
+		MaxEvents:     500,
+		MaxEventBytes: 1024,
+		ProcessBatch: func(_ context.Context, _ string, events []event.Event) error {
+			mu.Lock()
+			batches = append(batches, events)
+			mu.Unlock()
+			return nil
+		},
+		Log: zerolog.Nop(),
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, func() [][]event.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return batches
+	}
+}
+
+func TestClient_BatchesWithinLimits(t *testing.T) {
+	srv, batches := loomServer(t)
+	for _, gz := range []bool{false, true} {
+		c, err := New(Config{URL: srv.URL, Token: "tk", SensorID: "spip-01", Gzip: gz, BatchSize: 3, MaxBodyBytes: 4096, MaxEventBytes: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 7; i++ {
+			if err := c.Add(context.Background(), map[string]interface{}{"n": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := batches()
+	if len(got) != 6 || len(got[0]) != 3 || len(got[2]) != 1 || got[5][0]["n"] != float64(6) {
+		t.Errorf("batches = %v", got)
+	}
+
+	// Events near the body limit force early sends instead of a 413
+	c, _ := New(Config{URL: srv.URL, Token: "tk", MaxBodyBytes: 4096, MaxEventBytes: 1024})
+	pad := strings.Repeat("x", 900)
+	for i := 0; i < 10; i++ {
+		if err := c.Add(context.Background(), map[string]string{"pad": pad}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(batches()); n != 6+3 {
+		t.Errorf("batches = %d, want 9", n)
+	}
+	if err := c.Add(context.Background(), map[string]string{"pad": pad + pad}); !errors.Is(err, ErrEventTooLarge) {
+		t.Errorf("err = %v, want ErrEventTooLarge", err)
+	}
+	if err := c.Add(context.Background(), []int{1}); err == nil {
+		t.Error("non-object event accepted")
+	}
+}
+
+func TestClient_PermanentErrorNotRetried(t *testing.T) {
+	srv, _ := loomServer(t)
+	c, _ := New(Config{URL: srv.URL, Token: "wrong", SpoolDir: t.TempDir(), Backoff: time.Millisecond})
+	_ = c.Add(context.Background(), map[string]int{"n": 1})
+	err := c.Flush(context.Background())
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized || se.Code != "unauthorized" {
+		t.Fatalf("err = %v", err)
+	}
+	if files, _ := c.SpoolFiles(); len(files) != 0 {
+		t.Errorf("rejected batch spooled: %v", files)
+	}
+}
+
+func TestClient_RetriesThenSpoolsAndResends(t *testing.T) {
+	var calls, down atomic.Int32
+	down.Store(1)
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"internal_error"}`))
+			return
+		}
+		if calls.Load() == 4 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		received.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, _ := New(Config{URL: srv.URL, Token: "tk", SpoolDir: t.TempDir(), MaxRetries: 2, Backoff: time.Millisecond})
+	_ = c.Add(context.Background(), map[string]int{"n": 1})
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatalf("flush with spool: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3 (1 + 2 retries)", calls.Load())
+	}
+	files, _ := c.SpoolFiles()
+	if len(files) != 1 {
+		t.Fatalf("spool = %v", files)
+	}
+	if n, size := c.SpoolSize(); n != 1 || size != int64(len(`{"n":1}`)+1) {
+		t.Errorf("SpoolSize = %d, %d", n, size)
+	}
+
+	down.Store(0)
+	n, err := c.Resend(context.Background())
+	if err != nil || n != 1 || received.Load() != 1 {
+		t.Errorf("resend = %d, %v; received %d", n, err, received.Load())
+	}
+	if files, _ := c.SpoolFiles(); len(files) != 0 {
+		t.Errorf("spool after resend = %v", files)
+	}
+	if n, size := c.SpoolSize(); n != 0 || size != 0 {
+		t.Errorf("SpoolSize after resend = %d, %d", n, size)
+	}
+}
+
+func TestClient_RetryAfterCapped(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c, _ := New(Config{URL: srv.URL, Token: "tk", MaxRetryAfter: time.Second})
+	_ = c.Add(context.Background(), map[string]int{"n": 1})
+	start := time.Now()
+	err := c.Flush(context.Background())
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable || se.RetryAfter != time.Hour {
+		t.Fatalf("err = %v", err)
+	}
+	if calls.Load() != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("%d calls in %v; want 1 without waiting", calls.Load(), time.Since(start))
+	}
+
+	// Within the cap, the wait still ends with ctx.
+	c, _ = New(Config{URL: srv.URL, Token: "tk", MaxRetryAfter: 2 * time.Hour})
+	_ = c.Add(context.Background(), map[string]int{"n": 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := c.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("flush = %v after %v; want the ctx's error at its deadline", err, time.Since(start))
+	}
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Spooled batches are NDJSON files named by the time they were written, so name order is oldest first.
+// A batch the server rejects permanently on resend is renamed to *.rejected and skipped.
+
+// spool writes batch to a new file in SpoolDir atomically (temporary file, then rename).
+func (c *Client) spool(batch []json.RawMessage) error {
+	if err := os.MkdirAll(c.cfg.SpoolDir, 0o700); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, ev := range batch {
+		buf.Write(ev)
+		buf.WriteByte('\n')
+	}
+	tmp, err := os.CreateTemp(c.cfg.SpoolDir, ".spool-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	for seq := 0; ; seq++ {
+		name := filepath.Join(c.cfg.SpoolDir, fmt.Sprintf("%020d-%d.ndjson", time.Now().UnixNano(), seq))
+		if _, err := os.Stat(name); err == nil {
+			continue
+		}
+		if err := os.Rename(tmp.Name(), name); err != nil {
+			return err
+		}
+		c.spoolFiles.Add(1)
+		c.spoolBytes.Add(int64(buf.Len()))
+		return nil
+	}
+}
+
+// Resend sends the spooled batches, oldest first, removing each once it was accepted, and returns
+// how many events were sent. It stops at the first batch that still fails temporarily.
+func (c *Client) Resend(ctx context.Context) (int, error) {
+	if c.cfg.SpoolDir == "" {
+		return 0, nil
+	}
+	files, err := c.SpoolFiles()
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return sent, err
+		}
+		batch, err := readSpool(path)
+		if err != nil {
+			return sent, err
+		}
+		if len(batch) > 0 {
+			if err := c.send(ctx, batch); err != nil {
+				if temporary(err) {
+					return sent, err
+				}
+				if rerr := os.Rename(path, strings.TrimSuffix(path, ".ndjson")+".rejected"); rerr != nil {
+					return sent, rerr
+				}
+				c.unspooled(fi.Size())
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return sent, err
+		}
+		c.unspooled(fi.Size())
+		sent += len(batch)
+	}
+	return sent, nil
+}
+
+// SpoolFiles lists the batches waiting in SpoolDir, oldest first.
+func (c *Client) SpoolFiles() ([]string, error) {
+	if c.cfg.SpoolDir == "" {
+		return nil, nil
+	}
+	ents, err := os.ReadDir(c.cfg.SpoolDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []string
+	for _, ent := range ents {
+		if !ent.IsDir() && strings.HasSuffix(ent.Name(), ".ndjson") {
+			files = append(files, filepath.Join(c.cfg.SpoolDir, ent.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// DropSpooled removes the spooled batch at path (one of SpoolFiles) without sending it, and returns
+// how many events it held.
+func (c *Client) DropSpooled(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+	c.unspooled(int64(len(b)))
+	return bytes.Count(b, []byte{'\n'}), nil
+}
+
+// SpoolSize returns how many batches SpoolDir holds and their size in bytes, without reading the
+// directory: the counts are kept as batches are spooled, resent and dropped.
+func (c *Client) SpoolSize() (files int, size int64) {
+	return int(c.spoolFiles.Load()), c.spoolBytes.Load()
+}
+
+// countSpool counts the batches already in SpoolDir, e.g. from before a restart.
+func (c *Client) countSpool() {
+	files, _ := c.SpoolFiles()
+	for _, p := range files {
+		if fi, err := os.Stat(p); err == nil {
+			c.spoolFiles.Add(1)
+			c.spoolBytes.Add(fi.Size())
+		}
+	}
+}
+
+func (c *Client) unspooled(size int64) {
+	c.spoolFiles.Add(-1)
+	c.spoolBytes.Add(-size)
+}
+
+func readSpool(path string) ([]json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var batch []json.RawMessage
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) > 0 {
+			batch = append(batch, append(json.RawMessage(nil), line...))
+		}
+	}
+	return batch, sc.Err()
+}
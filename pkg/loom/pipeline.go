@@ -0,0 +1,611 @@
+// Package loom embeds Loom's event pipeline in other Go programs: the events a service receives go
+// through the same transform rules, normalization, sensor metadata, enrichment (GeoIP, ASN, DNS, payload hashes,
+// signatures), first-seen tagging and output as events posted to the Loom server, without running
+// the HTTP server.
+//
+//	cfg, err := loom.LoadConfig("loom.toml")
+//	...
+//	p, err := loom.NewPipeline(cfg, loom.Options{Log: log})
+//	...
+//	defer p.Close()
+//	go p.Run(ctx, func(err error) { log.Warn().Err(err).Msg("pipeline") })
+//	err = p.Ingest("sensor-01", events)
+package loom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/StefanGrimminck/Loom/internal/config"
+	"github.com/StefanGrimminck/Loom/internal/enrich"
+	"github.com/StefanGrimminck/Loom/internal/event"
+	"github.com/StefanGrimminck/Loom/internal/firstseen"
+	"github.com/StefanGrimminck/Loom/internal/metrics"
+	"github.com/StefanGrimminck/Loom/internal/normalize"
+	"github.com/StefanGrimminck/Loom/internal/output"
+	"github.com/StefanGrimminck/Loom/internal/profile"
+	"github.com/StefanGrimminck/Loom/internal/proxy"
+	"github.com/StefanGrimminck/Loom/internal/sequence"
+	"github.com/StefanGrimminck/Loom/internal/transform"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// Config is the loom.toml configuration. The pipeline uses the [[transform]], [normalize], [sensors],
+// [enrichment] and [output] sections.
+type Config = config.Config
+
+// SharedBits is a bitmap shared by replicas, such as the Redis client of the [shared] section.
+type SharedBits = firstseen.SharedBits
+
+// Event is one ECS event: a map of its JSON fields with accessors for the ECS fields Loom uses.
+// Fields Loom does not know are kept as they are.
+type Event = event.Event
+
+// Writer receives enriched events. Implement it to send events somewhere Loom has no output for.
+type Writer = output.Writer
+
+// DBInfo describes an open MaxMind database: its path, type, build time and whether it is stale or
+// failed a test lookup.
+type DBInfo = enrich.DBInfo
+
+// LoadConfig reads a loom.toml (and overlays merged over it) for a pipeline: the [server] and [auth]
+// sections are not required.
+func LoadConfig(path string, overlays ...string) (*Config, error) {
+	return config.LoadPipeline(path, overlays...)
+}
+
+// Enricher changes events in place after the built-in stages.
+type Enricher interface {
+	Enrich(sensorID string, event Event)
+}
+
+// EnricherFunc adapts a function to Enricher.
+type EnricherFunc func(sensorID string, event Event)
+
+// Enrich calls f.
+func (f EnricherFunc) Enrich(sensorID string, event Event) { f(sensorID, event) }
+
+// Options adds to what the Config sets up.
+type Options struct {
+	// Writer receives the events instead of the writer built from [output]. The pipeline does not
+	// flush or close a writer passed here.
+	Writer Writer
+	// Enrichers run after the built-in stages, in order.
+	Enrichers []Enricher
+	Log       zerolog.Logger
+	// Metrics, when set, registers the enrichment metrics (loom_enrich_*: lookups, cache hits and
+	// sizes, durations, database age) with it.
+	Metrics prometheus.Registerer
+	// FirstSeen, when set, is shared with other replicas so they agree on which indicators are new.
+	FirstSeen SharedBits
+	// ProfileLabels labels each stage of Enrich for pprof (label "stage").
+	ProfileLabels bool
+}
+
+// Pipeline enriches events and writes them to the output. It is safe for concurrent use.
+type Pipeline struct {
+	transform  *transform.Transformer
+	normalizer *normalize.Normalizer
+	tagger     *enrich.SensorTagger
+	locator    *enrich.SensorLocator
+	enricher   *enrich.Enricher
+	firstSeen  *firstseen.Tracker
+	enrichers  []Enricher
+	sequencer  *sequence.Sequencer // sensors with ordered_delivery
+	profile    *profile.Labels
+
+	out       Writer
+	ownsOut   bool
+	flushEach time.Duration // periodic flush of an owned ClickHouse or forward writer; 0 disables
+	saveEach  time.Duration
+	cacheEach time.Duration // periodic save of the enrichment caches when persisted
+}
+
+// NewPipeline opens the enrichment databases, the first-seen filter and, unless opts.Writer is set,
+// the configured output.
+func NewPipeline(cfg *Config, opts Options) (*Pipeline, error) {
+	p := &Pipeline{
+		tagger:    enrich.NewSensorTagger(sensorMetadata(cfg)),
+		enrichers: opts.Enrichers,
+		sequencer: sequence.New(orderedSensors(cfg)),
+		saveEach:  time.Duration(cfg.Enrichment.FirstSeen.SaveIntervalSeconds) * time.Second,
+		cacheEach: time.Duration(cfg.Enrichment.Cache.SaveIntervalSeconds) * time.Second,
+	}
+	rules := make([]transform.Rule, 0, len(cfg.Transform))
+	for _, t := range cfg.Transform {
+		rules = append(rules, transform.Rule{
+			Action: t.Action, From: t.From, To: t.To, Field: t.Field, Value: t.Value,
+			Overwrite: t.Overwrite, Sensors: t.Sensors,
+		})
+	}
+	p.transform = transform.New(rules)
+	if opts.ProfileLabels {
+		p.profile = profile.NewLabels()
+	}
+	if cfg.Normalize.Enabled {
+		mappings := make([]normalize.Mapping, 0, len(cfg.Normalize.Mappings))
+		for _, m := range cfg.Normalize.Mappings {
+			mappings = append(mappings, normalize.Mapping{From: m.From, To: m.To})
+		}
+		p.normalizer = normalize.New(cfg.Normalize.ECSVersion, mappings)
+	}
+
+	var err error
+	if p.enricher, err = newEnricher(cfg, opts); err != nil {
+		return nil, err
+	}
+	metrics.RegisterEnrichCaches(opts.Metrics, p.enricher)
+	metrics.RegisterEnrichDBs(opts.Metrics, p.enricher)
+	p.locator = enrich.NewSensorLocator(sensorMetadata(cfg), p.enricher.Geo)
+	if fs := cfg.Enrichment.FirstSeen; fs.Enabled {
+		if p.firstSeen, err = firstseen.Open(fs.Path, fs.Fields, fs.ExpectedItems, fs.FalsePositiveRate); err != nil {
+			_ = p.enricher.Close()
+			return nil, err
+		}
+		opts.Log.Info().Uint64("indicators", p.firstSeen.Len()).Str("path", fs.Path).Msg("first-seen filter loaded")
+		if opts.FirstSeen != nil {
+			if err := p.firstSeen.UseShared(opts.FirstSeen); err != nil {
+				_ = p.enricher.Close()
+				return nil, err
+			}
+		}
+	}
+
+	p.out = opts.Writer
+	if p.out == nil {
+		if p.out, err = NewWriter(cfg, opts.Log); err != nil {
+			_ = p.enricher.Close()
+			return nil, err
+		}
+		p.ownsOut = true
+		if FlushesPeriodically(cfg) {
+			p.flushEach = FlushInterval(cfg)
+		}
+	}
+	return p, nil
+}
+
+func newEnricher(cfg *Config, opts Options) (*enrich.Enricher, error) {
+	var dns *enrich.DNSEnricher
+	if cfg.Enrichment.DNS.Enabled {
+		ttl := cfg.Enrichment.DNS.CacheTTL
+		if ttl <= 0 {
+			ttl = 300
+		}
+		dns = enrich.NewDNSEnricher(time.Duration(ttl)*time.Second, cfg.Enrichment.DNS.MaxQPS)
+		resolver, err := dnsResolver(cfg.Enrichment.DNS)
+		if err != nil {
+			return nil, fmt.Errorf("enrichment.dns: %w", err)
+		}
+		if resolver != nil {
+			dns.SetResolver(resolver)
+		}
+	}
+	var enrichMetrics *enrich.Metrics
+	if opts.Metrics != nil {
+		enrichMetrics = enrich.NewMetrics(opts.Metrics)
+	}
+	var payload *enrich.PayloadHasher
+	if cfg.Enrichment.Payload.Enabled {
+		payload = enrich.NewPayloadHasher(enrich.PayloadConfig{
+			Fields:       cfg.Enrichment.Payload.Fields,
+			Base64:       cfg.Enrichment.Payload.Base64,
+			StoreDecoded: cfg.Enrichment.Payload.StoreDecoded,
+			MaxBytes:     cfg.Enrichment.Payload.MaxBytes,
+		})
+	}
+	var signatures *enrich.SignatureMatcher
+	if cfg.Enrichment.Signatures.Enabled {
+		var err error
+		signatures, err = enrich.NewSignatureMatcher(cfg.Enrichment.Signatures.RulesPath, cfg.Enrichment.Signatures.Fields)
+		if err != nil {
+			return nil, err
+		}
+		opts.Log.Info().Int("rules", signatures.Len()).Msg("signature rules loaded")
+	}
+	return enrich.NewEnricher(enrich.Config{
+		GeoIPDBPath: cfg.Enrichment.GeoIPDBPath,
+		ASNDBPath:   cfg.Enrichment.ASNDBPath,
+		DNS:         dns,
+		Payload:     payload,
+		Signatures:  signatures,
+		Metrics:     enrichMetrics,
+		CacheSize:   cfg.Enrichment.Cache.MaxEntries,
+		CacheTTL:    time.Duration(cfg.Enrichment.Cache.TTLSeconds) * time.Second,
+		CachePath:   cfg.Enrichment.Cache.Path,
+		MaxDBAge:    time.Duration(cfg.Enrichment.DBMaxAgeDays) * 24 * time.Hour,
+
+		ClassifyInternal:    cfg.Enrichment.Internal.Enabled,
+		SkipInternalLookups: cfg.Enrichment.Internal.SkipLookups,
+	}, opts.Log)
+}
+
+// dnsResolver returns the resolver of the DNS enricher: DNS over TLS or HTTPS to c.Server, the
+// system resolver through c.Proxy, or nil for the system resolver.
+func dnsResolver(c config.DNSConfig) (enrich.PTRResolver, error) {
+	if c.Server == "" {
+		r, err := proxy.Resolver(c.Proxy)
+		if err != nil || r == nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "tls" {
+		dial, err := proxy.Dialer(c.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "853")
+		}
+		return enrich.NewDoTResolver(addr, nil, dial)
+	}
+	t, err := proxy.Transport(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return enrich.NewDoHResolver(c.Server, &http.Client{Transport: t, Timeout: 5 * time.Second}), nil
+}
+
+func sensorMetadata(cfg *Config) map[string]enrich.SensorMetadata {
+	meta := make(map[string]enrich.SensorMetadata, len(cfg.Sensors))
+	for id, sc := range cfg.Sensors {
+		meta[id] = enrich.SensorMetadata{
+			Site:      sc.Site,
+			Geo:       sensorGeo(sc.Geo),
+			Owner:     sc.Owner,
+			Tags:      sc.Tags,
+			Labels:    sc.Labels,
+			Observer:  sc.Observer,
+			Tenant:    sc.Tenant,
+			GeoFromIP: sc.Geo.FromIP,
+		}
+	}
+	return meta
+}
+
+// sensorGeo returns the configured observer.geo fields of a sensor, or nil.
+func sensorGeo(g config.SensorGeoConfig) map[string]interface{} {
+	geo := make(map[string]interface{})
+	for k, v := range map[string]string{
+		"country_iso_code": g.CountryISOCode,
+		"country_name":     g.CountryName,
+		"region_name":      g.RegionName,
+		"city_name":        g.CityName,
+	} {
+		if v != "" {
+			geo[k] = v
+		}
+	}
+	if g.Lat != 0 || g.Lon != 0 {
+		geo["location"] = map[string]interface{}{"lat": g.Lat, "lon": g.Lon}
+	}
+	if len(geo) == 0 {
+		return nil
+	}
+	return geo
+}
+
+// NewWriter builds the writer configured in [output], including the ClickHouse outbox. Flush results
+// are logged to log. Tenants with their own elasticsearch_index or clickhouse_table get a writer of
+// their own (with the outbox in a subdirectory), and their sensors' events are routed to it. With
+// [[routes]], the writers of [outputs.<name>] are built too and events are routed between them.
+func NewWriter(cfg *Config, log zerolog.Logger) (Writer, error) {
+	wc := writerConfig(cfg, cfg.Output, log)
+	def, err := output.NewWriter(wc)
+	if err != nil {
+		return nil, err
+	}
+	byTenant := make(map[string]output.Writer)
+	for id, t := range cfg.Tenants {
+		tc := wc
+		switch {
+		case wc.Type == "elasticsearch" && t.ElasticsearchIndex != "":
+			tc.ElasticsearchIndex = t.ElasticsearchIndex
+		case wc.Type == "clickhouse" && t.ClickHouseTable != "":
+			tc.ClickHouseTable = t.ClickHouseTable
+			tc.ClickHouseOutbox.Dir = filepath.Join(wc.ClickHouseOutbox.Dir, "tenant-"+id)
+		default:
+			continue
+		}
+		w, err := output.NewWriter(tc)
+		if err != nil {
+			for _, w := range byTenant {
+				_ = w.Close()
+			}
+			_ = def.Close()
+			return nil, fmt.Errorf("tenant %s: %w", id, err)
+		}
+		byTenant[id] = w
+	}
+	sensorTenant := make(map[string]string, len(cfg.Sensors))
+	for id, sc := range cfg.Sensors {
+		sensorTenant[id] = sc.Tenant
+	}
+	def = output.NewTenantRouter(def, byTenant, func(sensorID string) string { return sensorTenant[sensorID] })
+	if len(cfg.Routes) == 0 {
+		return def, nil
+	}
+	named := make(map[string]output.Writer, len(cfg.Outputs))
+	closeAll := func() {
+		for _, w := range named {
+			_ = w.Close()
+		}
+		_ = def.Close()
+	}
+	for name, o := range cfg.Outputs {
+		w, err := output.NewWriter(writerConfig(cfg, o, log.With().Str("output", name).Logger()))
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("output %s: %w", name, err)
+		}
+		named[name] = w
+	}
+	rules := make([]output.Rule, len(cfg.Routes))
+	for i, r := range cfg.Routes {
+		rules[i] = output.Rule{
+			Sensors:    r.Sensors,
+			Categories: r.EventCategories,
+			Ports:      r.DestinationPorts,
+			Outputs:    r.Outputs,
+			Continue:   r.Continue,
+		}
+	}
+	w, err := output.NewRouter(def, named, rules)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewOutputWriter builds the writer of [output] alone, without its outbox, tenants or routes, for
+// one-off work such as checking that the output is reachable or replaying spool files (which must
+// not spool failures back into the outbox being replayed).
+func NewOutputWriter(cfg *Config, log zerolog.Logger) (Writer, error) {
+	wc := writerConfig(cfg, cfg.Output, log)
+	wc.ClickHouseOutbox = output.OutboxConfig{}
+	return output.NewWriter(wc)
+}
+
+// orderedSensors returns the sensors with ordered_delivery, sorted.
+func orderedSensors(cfg *Config) []string {
+	var ids []string
+	for id, sc := range cfg.Sensors {
+		if sc.OrderedDelivery {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func writerConfig(cfg *Config, o config.OutputConfig, log zerolog.Logger) output.WriterConfig {
+	return output.WriterConfig{
+		Type:               o.Type,
+		ElasticsearchURL:   o.ElasticsearchURL,
+		ElasticsearchIndex: o.ElasticsearchIndex,
+		ElasticsearchUser:  o.ElasticsearchUser,
+		ElasticsearchPass:  o.ElasticsearchPass,
+		ClickHouseURL:      o.ClickHouseURL,
+		ClickHouseDatabase: o.ClickHouseDatabase,
+		ClickHouseTable:    o.ClickHouseTable,
+		ClickHouseUser:     o.ClickHouseUser,
+		ClickHousePassword: o.ClickHousePassword,
+		OrderedSensors:     orderedSensors(cfg),
+		ClickHouseOutbox: output.OutboxConfig{
+			Enabled:                 o.Outbox.Enabled,
+			Dir:                     o.Outbox.Dir,
+			MaxBytes:                o.Outbox.MaxBytes,
+			MaxBatchSize:            o.Outbox.MaxBatchSize,
+			RetryBackoff:            time.Duration(o.Outbox.RetryBackoffMS) * time.Millisecond,
+			RetryMaxBackoff:         time.Duration(o.Outbox.RetryMaxBackoffMS) * time.Millisecond,
+			ReadyMaxBytes:           o.Outbox.ReadyMaxBytes,
+			DrainWorkers:            o.Outbox.DrainWorkers,
+			DrainMaxFiles:           o.Outbox.DrainMaxFiles,
+			DrainMaxEventsPerSecond: o.Outbox.DrainMaxEventsPerSecond,
+			DrainPriority:           o.Outbox.DrainPriority,
+			Eviction:                o.Outbox.Eviction,
+			MaxBytesPerSensor:       o.Outbox.MaxBytesPerSensor,
+		},
+		FlushLog: func(rows int, err error) {
+			if err != nil {
+				log.Error().Err(err).Int("rows", rows).Msg(o.Type + " flush failed")
+			} else {
+				log.Info().Int("rows", rows).Msg(o.Type + " flush ok")
+			}
+		},
+		ForwardURL:      o.ForwardURL,
+		ForwardToken:    o.ForwardToken,
+		ForwardSensorID: o.ForwardSensorID,
+		ForwardGzip:     o.ForwardGzip,
+		ForwardCAFile:   o.ForwardCAFile,
+		GELFURL:         o.GELFURL,
+		GELFHost:        o.GELFHost,
+		GELFCompression: o.GELFCompression,
+		GELFChunkSize:   o.GELFChunkSize,
+
+		EventHubsNamespace:        o.EventHubsNamespace,
+		EventHubsName:             o.EventHubsName,
+		EventHubsConnectionString: o.EventHubsConnectionString,
+		EventHubsTenantID:         o.EventHubsTenantID,
+		EventHubsClientID:         o.EventHubsClientID,
+		EventHubsClientSecret:     o.EventHubsClientSecret,
+
+		PubSubProject:         o.PubSubProject,
+		PubSubTopic:           o.PubSubTopic,
+		PubSubOrdering:        o.PubSubOrdering,
+		PubSubCredentialsFile: o.PubSubCredentialsFile,
+		PubSubEndpoint:        o.PubSubEndpoint,
+
+		UnixSocket:     o.UnixSocket,
+		SQLitePath:     o.SQLitePath,
+		SQLiteMaxBytes: o.SQLiteMaxBytes,
+
+		ElasticsearchMaxBulkBytes: o.ElasticsearchMaxBulkBytes,
+		ClickHouseFlatten:         o.ClickHouseFlatten,
+		Proxy:                     o.Proxy,
+		HTTP:                      httpConfig(o.HTTP),
+	}
+}
+
+// httpConfig converts [output.http] to the output's HTTPConfig.
+func httpConfig(h config.OutputHTTPConfig) output.HTTPConfig {
+	return output.HTTPConfig{
+		MaxIdleConnsPerHost: h.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     h.MaxConnsPerHost,
+		DialTimeout:         time.Duration(h.DialTimeoutSeconds) * time.Second,
+		KeepAlive:           time.Duration(h.KeepAliveSeconds) * time.Second, // negative disables
+		TLSHandshakeTimeout: time.Duration(h.TLSHandshakeTimeoutSeconds) * time.Second,
+		IdleConnTimeout:     time.Duration(h.IdleConnTimeoutSeconds) * time.Second,
+		RequestTimeout:      time.Duration(h.RequestTimeoutSeconds) * time.Second,
+		PingAttempts:        h.PingAttempts,
+	}
+}
+
+// FlushesPeriodically reports whether an output buffers events that FlushInterval must flush: a
+// ClickHouse, forward or Event Hubs [output], or such an [outputs.<name>] with routes.
+func FlushesPeriodically(cfg *Config) bool {
+	if buffers(cfg.Output.Type) {
+		return true
+	}
+	if len(cfg.Routes) == 0 {
+		return false
+	}
+	for _, o := range cfg.Outputs {
+		if buffers(o.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// buffers reports whether outputs of type hold events until a flush.
+func buffers(outputType string) bool {
+	return outputType == "clickhouse" || outputType == "forward" || outputType == "eventhubs" || outputType == "pubsub" ||
+		outputType == "sqlite"
+}
+
+// FlushInterval is how often buffered ClickHouse rows and forward batches are flushed when volume is low.
+func FlushInterval(cfg *Config) time.Duration {
+	if d := time.Duration(cfg.Output.Outbox.FlushIntervalMS) * time.Millisecond; d > 0 {
+		return d
+	}
+	return 10 * time.Second
+}
+
+// Enrich runs the pipeline stages on event in place: transform rules, normalization, sensor
+// location and metadata, enrichment, first-seen tagging, then the Options enrichers.
+func (p *Pipeline) Enrich(sensorID string, event Event) {
+	p.profile.Enter("transform")
+	p.transform.Apply(sensorID, event)
+	p.profile.Enter("normalize")
+	p.normalizer.Apply(event)
+	p.profile.Enter("sensor")
+	p.locator.Apply(sensorID, event)
+	p.tagger.Apply(sensorID, event)
+	p.profile.Enter("enrich")
+	p.enricher.EnrichEvent(event)
+	p.profile.Enter("first_seen")
+	p.firstSeen.Apply(event)
+	p.profile.Enter("enrichers")
+	for _, e := range p.enrichers {
+		e.Enrich(sensorID, event)
+	}
+}
+
+// Ingest enriches events received from sensorID and writes them to the output. Events are changed
+// in place.
+func (p *Pipeline) Ingest(sensorID string, events []Event) error {
+	stamp, done := p.sequencer.Batch(sensorID)
+	defer done()
+	for _, ev := range events {
+		p.Enrich(sensorID, ev)
+		stamp(ev)
+		if err := output.WriteFrom(p.out, sensorID, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Heartbeat notes the public address sensorID connected from (e.g. the ingest request's remote
+// IP), which locates the sensors with [sensors.<id>.geo] from_ip.
+func (p *Pipeline) Heartbeat(sensorID, ip string) {
+	p.locator.Heartbeat(sensorID, ip)
+}
+
+// Writer returns the output the pipeline writes to.
+func (p *Pipeline) Writer() Writer {
+	return p.out
+}
+
+// Reload reopens the GeoIP and ASN databases (e.g. after an update or a path change).
+func (p *Pipeline) Reload(geoIPDBPath, asnDBPath string) error {
+	if err := p.enricher.Reload(geoIPDBPath, asnDBPath); err != nil {
+		return err
+	}
+	p.locator.Reset()
+	return nil
+}
+
+// Databases returns the metadata of the open GeoIP and ASN databases.
+func (p *Pipeline) Databases() []DBInfo {
+	return p.enricher.Databases()
+}
+
+// Ready reports whether the configured enrichment databases are loaded and answer a test lookup.
+func (p *Pipeline) Ready() bool {
+	return p.enricher.Ready()
+}
+
+// dbCheckInterval is how often Run warns about stale or failing enrichment databases.
+const dbCheckInterval = 24 * time.Hour
+
+// Run saves the first-seen filter periodically and flushes an output built from [output] (ClickHouse
+// buffers rows) until ctx is done.
+func (p *Pipeline) Run(ctx context.Context, onErr func(error)) {
+	if p.firstSeen != nil {
+		go p.firstSeen.Run(ctx, p.saveEach, onErr)
+	}
+	go p.enricher.RunCacheSaves(ctx, p.cacheEach, onErr)
+	go p.enricher.RunDBChecks(ctx, dbCheckInterval)
+	if p.flushEach <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.flushEach)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.out.Flush(); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// Close saves the first-seen filter and the enrichment caches, closes the enrichment databases
+// and, when the pipeline built it, flushes and closes the output. Call it after the last Ingest.
+func (p *Pipeline) Close() error {
+	errs := []error{p.firstSeen.Save(), p.enricher.SaveCaches(), p.enricher.Close()}
+	if p.ownsOut {
+		errs = append(errs, p.out.Close())
+	}
+	return errors.Join(errs...)
+}
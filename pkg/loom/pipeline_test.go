@@ -0,0 +1,156 @@
+package loom
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+type memWriter struct {
+	events []Event
+}
+
+func (m *memWriter) Write(ev Event) error {
+	m.events = append(m.events, ev)
+	return nil
+}
+func (m *memWriter) Flush() error                     { return nil }
+func (m *memWriter) Close() error                     { return nil }
+func (m *memWriter) Health(ctx context.Context) error { return nil }
+
+func TestPipeline_IngestWithCustomEnricherAndWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "loom.toml")
+	content := `
+[server]
+tls = true # no certificates: only the ingest server needs them
+
+[sensors.spip-01]
+site = "ams-1"
+owner = "research"
+
+[sensors.spip-01.geo]
+country_iso_code = "NL"
+lat = 52.37
+lon = 4.89
+
+[enrichment.first_seen]
+enabled = true
+path = "` + filepath.Join(dir, "first_seen.bloom") + `"
+expected_items = 1000
+
+[[transform]]
+action = "add"
+field = "event.dataset"
+value = "spip.http"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig without tokens or certificates: %v", err)
+	}
+
+	out := &memWriter{}
+	p, err := NewPipeline(cfg, Options{
+		Writer: out,
+		Log:    zerolog.Nop(),
+		Enrichers: []Enricher{EnricherFunc(func(sensorID string, ev Event) {
+			ev["custom"] = sensorID
+		})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{"source": map[string]interface{}{"ip": "192.0.2.1"}},
+		{"source": map[string]interface{}{"ip": "192.0.2.1"}},
+	}
+	if err := p.Ingest("spip-01", events); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.events) != 2 {
+		t.Fatalf("written = %d, want 2", len(out.events))
+	}
+	ev := out.events[0]
+	if ev["custom"] != "spip-01" {
+		t.Errorf("custom enricher not run: %v", ev)
+	}
+	if ev.GetString("event.dataset") != "spip.http" {
+		t.Errorf("transform rule not applied: %v", ev)
+	}
+	if labels, _ := ev["labels"].(map[string]interface{}); labels["owner"] != "research" {
+		t.Errorf("sensor metadata not applied: %v", ev)
+	}
+	if ev.GetString("observer.geo.country_iso_code") != "NL" || ev.Get("observer.geo.location.lon") != 4.89 || ev.GetString("observer.geo.name") != "ams-1" {
+		t.Errorf("sensor geo not applied: %v", ev["observer"])
+	}
+	if loom, _ := ev["loom"].(map[string]interface{}); loom["first_seen"] != true {
+		t.Errorf("first event not tagged first seen: %v", ev)
+	}
+	if loom, _ := out.events[1]["loom"].(map[string]interface{}); loom["first_seen"] == true {
+		t.Errorf("repeat tagged first seen: %v", out.events[1])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "first_seen.bloom")); err != nil {
+		t.Errorf("first-seen filter not saved on Close: %v", err)
+	}
+}
+
+func TestNewOutputWriter(t *testing.T) {
+	dir := t.TempDir()
+	for _, output := range []string{
+		`type = "sqlite"
+sqlite_path = "` + filepath.Join(dir, "events.db") + `"`,
+		`type = "gelf"
+gelf_url = "udp://127.0.0.1:12201"`,
+	} {
+		path := filepath.Join(dir, "loom.toml")
+		if err := os.WriteFile(path, []byte("[output]\n"+output+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewOutputWriter(cfg, zerolog.Nop())
+		if err != nil {
+			t.Fatalf("%s: %v", cfg.Output.Type, err)
+		}
+		if err := w.Health(context.Background()); err != nil {
+			t.Errorf("%s: Health = %v", cfg.Output.Type, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: Close = %v", cfg.Output.Type, err)
+		}
+	}
+}
+
+func TestPipeline_Metrics(t *testing.T) {
+	cfg := &Config{}
+	reg := prometheus.NewRegistry()
+	p, err := NewPipeline(cfg, Options{Writer: &memWriter{}, Log: zerolog.Nop(), Metrics: reg, ProfileLabels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	if !names["loom_enrich_cache_entries"] {
+		t.Errorf("enrichment metrics not registered: %v", names)
+	}
+}